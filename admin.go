@@ -0,0 +1,65 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// adminAddr is where the endpoints for running the server are served, never alongside the public ones.
+// Then an intranet-only port can have them, and the public port does not need a login to hide them.
+// Without it they are not served at all.
+var adminAddr string
+
+// adminAPIs are the endpoints that only the admin address answers:
+// those that report on the server or its cache rather than on the sharepoint,
+// and those that cost too much to offer to anybody who asks.
+// A path ending in a slash covers everything below it.
+var adminAPIs = map[string]func(fsys *FS, w http.ResponseWriter, r *http.Request){
+	"/readyz":          readyzAPI,
+	"/api/v1/tasks":    func(fsys *FS, w http.ResponseWriter, r *http.Request) { tasksAPI(w, r) },
+	"/api/v1/prefetch": prefetchAPI,
+	"/api/v1/pin":      pinAPI,
+	"/api/v1/access":   accessAPI,
+	"/api/v1/audit":    auditAPI,
+	"/api/v1/validate": validateAPI,
+	"/api/v1/export":   exportAPI,
+	jobsPath:           jobsAPI,
+	jobsPath + "/":     jobsAPI,
+}
+
+// adminHandler answers the adminAPIs and the profiler
+func adminHandler(fsys *FS) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/pprof/", http.DefaultServeMux) // registered by importing net/http/pprof
+	for p, api := range adminAPIs {
+		mux.HandleFunc(p, func(w http.ResponseWriter, r *http.Request) { api(fsys, w, r) })
+	}
+	return mux
+}
+
+// isAdminPath is true of the requests that adminHandler answers, and the public handler refuses
+func isAdminPath(p string) bool {
+	if strings.HasPrefix(p, "/debug/pprof/") {
+		return true
+	}
+	for a := range adminAPIs {
+		if p == a || strings.HasSuffix(a, "/") && strings.HasPrefix(p, a) {
+			return true
+		}
+	}
+	return false
+}
+
+// serve listens on the public address, and on the admin address if there is one,
+// until either fails
+func serve(fsys *FS, port string) error {
+	errc := make(chan error, 2)
+	go func() { errc <- http.ListenAndServe(port, handler(fsys)) }()
+	if adminAddr != "" {
+		go func() { errc <- http.ListenAndServe(adminAddr, adminHandler(fsys)) }()
+	}
+	return <-errc
+}
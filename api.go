@@ -0,0 +1,271 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	gopath "path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// searchAPI streams glob matches as newline-delimited JSON, as soon as the walker finds them.
+//
+//	GET /api/v1/search?q=PATTERN[&root=PATH][&limit=N][&sort=newest|oldest]
+//
+// Each match is a line {"path":"..."} and the final line is {"count":N,"elapsed":"..."}.
+// The walk is abandoned when the client disconnects.
+// Sorted matches also have "mtime", as the archive recorded it for a file inside one,
+// and arrive only once the walk is complete, or has run for sortedSearchWait,
+// in which case the final line also has "truncated":true.
+func searchAPI(fsys *FS, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	pattern := q.Get("q")
+	if !doublestar.ValidatePattern(pattern) {
+		http.Error(w, "not a valid glob pattern", http.StatusBadRequest)
+		return
+	}
+	limit := -1
+	if s := q.Get("limit"); s != "" {
+		var err error
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 0 {
+			http.Error(w, "not a valid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	sort := q.Get("sort")
+	if sort != "" && !slices.Contains(searchSorts, sort) {
+		http.Error(w, "sort must be one of "+strings.Join(searchSorts, ", "), http.StatusBadRequest)
+		return
+	}
+	root := strings.Trim(q.Get("root"), "/")
+	if root == "" {
+		root = "."
+	}
+	o, err := fsys.path(root)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if r.Method == "HEAD" {
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+
+	const flushEvery = 100 * time.Millisecond
+	t := time.Now()
+	lastFlush := t
+	n := 0
+	truncated := false
+	if sort != "" {
+		var matches []timedMatch
+		matches, truncated = o.globByTime(r.Context(), pattern, sort == "oldest", false, limit)
+		for _, m := range matches {
+			if enc.Encode(timedLine(m)) != nil {
+				return
+			}
+		}
+		n = len(matches)
+	} else {
+		for buf := range o.glob(r.Context(), pattern) {
+			if n == limit {
+				break
+			}
+			err := enc.Encode(struct {
+				Path string `json:"path"`
+			}{unsafeString(buf)})
+			if err != nil {
+				return // client has gone away
+			}
+			n++
+			if time.Since(lastFlush) > flushEvery {
+				if bw.Flush() != nil || rc.Flush() != nil {
+					return
+				}
+				lastFlush = time.Now()
+			}
+		}
+	}
+	if r.Context().Err() != nil {
+		return
+	}
+	enc.Encode(struct {
+		Count     int    `json:"count"`
+		Elapsed   string `json:"elapsed"`
+		Truncated bool   `json:"truncated,omitempty"`
+	}{n, time.Since(t).String(), truncated})
+	bw.Flush()
+}
+
+// diffAPI compares two directories (such as two mounted archives) by member path, size and checksum.
+//
+//	GET /api/v1/diff?a=PATH&b=PATH
+//
+// Each difference is a line {"change":"added"|"removed"|"changed","path":"...","a":{...},"b":{...}}
+// and the final line counts each kind of change. Checksums are only computed when sizes match.
+// Archives nested within the compared trees are compared as files, not descended into.
+func diffAPI(fsys *FS, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var roots [2]string
+	for i, param := range [2]string{"a", "b"} {
+		roots[i] = strings.Trim(r.URL.Query().Get(param), "/")
+		if roots[i] == "" {
+			roots[i] = "."
+		}
+		if _, err := fs.Stat(fsys, roots[i]); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if r.Method == "HEAD" {
+		return
+	}
+
+	ctx := r.Context()
+	alist, aerr := diffList(ctx, fsys, roots[0])
+	blist, berr := diffList(ctx, fsys, roots[1])
+	if err := errors.Join(aerr, berr); err != nil {
+		if ctx.Err() == nil {
+			walkFailed(w, err)
+		}
+		return
+	}
+
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+
+	type change struct {
+		Change string     `json:"change"`
+		Path   string     `json:"path"`
+		A      *diffEntry `json:"a,omitempty"`
+		B      *diffEntry `json:"b,omitempty"`
+	}
+	var counts struct {
+		Added   int `json:"added"`
+		Removed int `json:"removed"`
+		Changed int `json:"changed"`
+		Same    int `json:"same"`
+	}
+
+	bmap := make(map[string]*diffEntry, len(blist))
+	for i := range blist {
+		bmap[blist[i].rel] = &blist[i]
+	}
+	for i := range alist {
+		if ctx.Err() != nil {
+			return
+		}
+		a := &alist[i]
+		b, ok := bmap[a.rel]
+		if !ok {
+			enc.Encode(change{"removed", a.rel, a, nil})
+			counts.Removed++
+			continue
+		}
+		b.seen = true
+		if a.same(fsys, roots, b) {
+			counts.Same++
+		} else {
+			enc.Encode(change{"changed", a.rel, a, b})
+			counts.Changed++
+		}
+	}
+	for i := range blist {
+		if b := &blist[i]; !b.seen {
+			enc.Encode(change{"added", b.rel, nil, b})
+			counts.Added++
+		}
+	}
+	enc.Encode(counts)
+}
+
+type diffEntry struct {
+	rel    string
+	seen   bool
+	IsDir  bool   `json:"dir,omitempty"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+func diffList(ctx context.Context, fsys *FS, root string) ([]diffEntry, error) {
+	var list []diffEntry
+	err := fs.WalkDir(fsys, root, func(name string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		} else if isIncomplete(err) {
+			return err // rather than an answer that looks whole
+		} else if err != nil || name == root {
+			return nil // report what we can
+		} else if strings.HasSuffix(name, Special) {
+			return fs.SkipDir // compare nested archives as files
+		}
+		e := diffEntry{rel: name, IsDir: d.IsDir()}
+		if root != "." {
+			e.rel = name[len(root)+1:]
+		}
+		if !e.IsDir {
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			e.Size = info.Size()
+		}
+		list = append(list, e)
+		return nil
+	})
+	return list, err
+}
+
+// same computes checksums lazily, only for files that could be identical
+func (a *diffEntry) same(fsys *FS, roots [2]string, b *diffEntry) bool {
+	if a.IsDir || b.IsDir {
+		return a.IsDir == b.IsDir
+	} else if a.Size != b.Size {
+		return false
+	}
+	for i, e := range [2]*diffEntry{a, b} {
+		o, err := fsys.path(gopath.Join(roots[i], e.rel))
+		if err != nil {
+			return false
+		}
+		d, err := o.sha256()
+		if err != nil {
+			return false
+		}
+		e.SHA256 = hex.EncodeToString(d[:])
+	}
+	return a.SHA256 == b.SHA256
+}
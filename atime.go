@@ -0,0 +1,220 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/pebble/v2"
+)
+
+// recordAccess keeps the time of the last download of every file, so that curators can find
+// the material that nobody uses when deciding what to keep in hot storage.
+// A download from inside an archive also counts for the archive file in the sharepoint.
+var recordAccess bool
+
+const (
+	atimePrefix = "\xffatime/" // + textual path, and the value is the unix time then the number of downloads
+	atimeFlush  = 30 * time.Second
+)
+
+type accessNote struct {
+	last time.Time
+	hits int64
+}
+
+// noteAccess is cheap enough for every request, because the checking and writing happen later in a batch
+func (fsys *FS) noteAccess(name string, t time.Time) {
+	if fsys.db.Load() == nil {
+		return
+	}
+	fsys.aMu.Lock()
+	defer fsys.aMu.Unlock()
+	if fsys.accessed == nil {
+		fsys.accessed = make(map[string]accessNote)
+		go func() {
+			for range time.Tick(atimeFlush) {
+				fsys.flushAccess()
+			}
+		}()
+	}
+	n := fsys.accessed[name]
+	n.last, n.hits = t, n.hits+1
+	fsys.accessed[name] = n
+}
+
+func (fsys *FS) flushAccess() {
+	fsys.aMu.Lock()
+	notes := fsys.accessed
+	fsys.accessed = make(map[string]accessNote)
+	fsys.aMu.Unlock()
+
+	merged := make(map[string]accessNote)
+	for name, n := range notes {
+		if s, err := fsys.Stat(name); err != nil || !s.Mode().IsRegular() {
+			continue // a page or an API, not a file
+		}
+		names := []string{name}
+		if host, _, nested := strings.Cut(name, Special+"/"); nested {
+			names = append(names, host)
+		}
+		for _, name := range names {
+			m := merged[name]
+			m.hits += n.hits
+			if n.last.After(m.last) {
+				m.last = n.last
+			}
+			merged[name] = m
+		}
+	}
+
+	batch := fsys.db.Load().NewBatch()
+	for name, n := range merged {
+		if old, ok := fsys.getAccess(name); ok {
+			n.hits += old.hits
+		}
+		val := appendint(appendint(nil, n.last.Unix()), n.hits)
+		batch.Set([]byte(atimePrefix+name), val, nil)
+	}
+	if err := batch.Commit(pebble.NoSync); err != nil {
+		slog.Error("atimeFlushError", "err", err)
+	}
+}
+
+func (fsys *FS) getAccess(name string) (accessNote, bool) {
+	val, closer, err := fsys.db.Load().Get([]byte(atimePrefix + name))
+	if err != nil {
+		return accessNote{}, false
+	}
+	defer closer.Close()
+	return parseAccess(val)
+}
+
+func parseAccess(val []byte) (accessNote, bool) {
+	if len(val) == 0 || int(val[0])+1 > len(val) {
+		return accessNote{}, false
+	}
+	unix, ok1 := read1int(val[:val[0]+1])
+	hits, ok2 := read1int(val[val[0]+1:])
+	if !ok1 || !ok2 {
+		return accessNote{}, false
+	}
+	return accessNote{time.Unix(unix, 0), hits}, true
+}
+
+// accessAPI lists the files in the sharepoint with their last download, for deciding what to keep in hot storage.
+//
+//	GET /api/v1/access?root=PATH[&never=1|&inside=1]
+//
+// Each file is a line {"path":"...","atime":"...","hits":N}, omitting atime and hits if it was never downloaded,
+// and the final line is {"count":N,"never":N}. With never=1 only the files never downloaded are listed.
+// Archives are listed as files, counting the downloads from inside them, and not descended into.
+// With inside=1 the files that were downloaded from inside archives are listed too, but not the files never downloaded.
+// Downloads from the last half minute might not be counted yet.
+func accessAPI(fsys *FS, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	} else if fsys.db.Load() == nil || !recordAccess {
+		http.Error(w, "access times are not being recorded", http.StatusNotFound)
+		return
+	}
+	root := strings.Trim(r.URL.Query().Get("root"), "/")
+	if root == "" {
+		root = "."
+	}
+	onlyNever := r.URL.Query().Get("never") != ""
+	if r.URL.Query().Get("inside") != "" {
+		accessListInside(fsys, w, r, root)
+		return
+	}
+
+	list, err := manifestList(r.Context(), fsys, root)
+	if err != nil {
+		walkFailed(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method == "HEAD" {
+		return
+	}
+
+	type line struct {
+		Path  string `json:"path"`
+		ATime string `json:"atime,omitempty"`
+		Hits  int64  `json:"hits,omitempty"`
+	}
+	enc := json.NewEncoder(w)
+	count, never := 0, 0
+	for _, e := range list {
+		if !e.regular {
+			continue
+		}
+		name := e.Path
+		if root != "." {
+			name = root + "/" + e.Path
+		}
+		n, ok := fsys.getAccess(name)
+		if !ok {
+			never++
+		} else if onlyNever {
+			continue
+		}
+		l := line{Path: e.Path}
+		if ok {
+			l.ATime, l.Hits = n.last.UTC().Format(time.RFC3339), n.hits
+		}
+		enc.Encode(l)
+		count++
+	}
+	enc.Encode(struct {
+		Count int `json:"count"`
+		Never int `json:"never"`
+	}{count, never})
+}
+
+func accessListInside(fsys *FS, w http.ResponseWriter, r *http.Request, root string) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method == "HEAD" {
+		return
+	}
+	prefix := atimePrefix
+	if root != "." {
+		prefix += root + "/"
+	}
+	iter, err := fsys.db.Load().NewIter(&pebble.IterOptions{
+		LowerBound: []byte(prefix),
+		UpperBound: []byte(prefix + "\xff"),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer iter.Close()
+
+	enc := json.NewEncoder(w)
+	count := 0
+	for iter.First(); iter.Valid() && r.Context().Err() == nil; iter.Next() {
+		n, ok := parseAccess(iter.Value())
+		if !ok {
+			continue
+		}
+		enc.Encode(struct {
+			Path  string `json:"path"`
+			ATime string `json:"atime"`
+			Hits  int64  `json:"hits"`
+		}{string(iter.Key()[len(prefix):]), n.last.UTC().Format(time.RFC3339), n.hits})
+		count++
+	}
+	enc.Encode(struct {
+		Count int `json:"count"`
+		Never int `json:"never"`
+	}{count, 0})
+}
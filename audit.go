@@ -0,0 +1,280 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	gopath "path"
+	"strconv"
+	"strings"
+)
+
+const auditHello = `Usage:  BeHierarchic audit [-root SUBDIR] CACHE SHAREPOINT DAT
+
+Checks the sharepoint (including the contents of archives) against a ROM manager's
+DAT file in Logiqx XML format, as published by No-Intro and TOSEC, and prints
+one line per ROM: present, missing, mismatched (a file of that name with the wrong
+checksum) or unknown (a file of that name whose checksum could not be computed).`
+
+// maxDAT is far larger than the biggest TOSEC DAT
+const maxDAT = 256 << 20
+
+// datROM is one ROM in a DAT file. A ROM without a SHA-1 is matched by name and size.
+type datROM struct {
+	Game string `json:"game"`
+	Name string `json:"rom"`
+	Size int64  `json:"size"`
+	SHA1 string `json:"sha1,omitempty"`
+}
+
+// parseDAT reads the Logiqx XML format, in which MAME-style DATs say machine instead of game
+func parseDAT(r io.Reader) ([]datROM, error) {
+	type rom struct {
+		Name string `xml:"name,attr"`
+		Size string `xml:"size,attr"`
+		SHA1 string `xml:"sha1,attr"`
+	}
+	type game struct {
+		Name string `xml:"name,attr"`
+		ROMs []rom  `xml:"rom"`
+	}
+	var dat struct {
+		XMLName  xml.Name `xml:"datafile"`
+		Games    []game   `xml:"game"`
+		Machines []game   `xml:"machine"`
+	}
+	dec := xml.NewDecoder(r)
+	dec.Strict = false // DATs often declare a DOCTYPE with an unreachable DTD
+	if err := dec.Decode(&dat); err != nil {
+		return nil, fmt.Errorf("not a Logiqx XML DAT file: %w", err)
+	}
+
+	var roms []datROM
+	for _, g := range append(dat.Games, dat.Machines...) {
+		for _, r := range g.ROMs {
+			size, err := strconv.ParseInt(r.Size, 0, 64)
+			if err != nil {
+				size = -1 // unknown, so only a checksum can match it
+			}
+			roms = append(roms, datROM{
+				Game: g.Name,
+				Name: strings.ReplaceAll(r.Name, "\\", "/"),
+				Size: size,
+				SHA1: strings.ToLower(r.SHA1),
+			})
+		}
+	}
+	return roms, nil
+}
+
+type auditFile struct {
+	path string
+	size int64
+	sha1 string // empty if not known
+}
+
+type auditResult struct {
+	Status string `json:"status"`
+	datROM
+	Path string `json:"path,omitempty"`
+}
+
+// auditRank decides which of several files with a ROM's name to report
+var auditRank = map[string]int{"missing": 0, "mismatched": 1, "unknown": 2, "present": 3}
+
+type auditCounts struct {
+	Present    int `json:"present"`
+	Missing    int `json:"missing"`
+	Mismatched int `json:"mismatched"`
+	Unknown    int `json:"unknown"`
+}
+
+func (c *auditCounts) add(status string) {
+	switch status {
+	case "present":
+		c.Present++
+	case "missing":
+		c.Missing++
+	case "mismatched":
+		c.Mismatched++
+	case "unknown":
+		c.Unknown++
+	}
+}
+
+// audit matches every ROM against the files under root.
+// Unless compute is set, only checksums already in the cache are used.
+func audit(ctx context.Context, fsys *FS, root string, roms []datROM, compute bool, emit func(auditResult) error) (auditCounts, error) {
+	var counts auditCounts
+	bySHA1 := make(map[string]string)
+	byName := make(map[string][]auditFile)
+	err := fs.WalkDir(fsys, root, func(name string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		} else if isIncomplete(err) {
+			return err // rather than an answer that looks whole
+		} else if err != nil || !d.Type().IsRegular() {
+			return nil // report what we can
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		f := auditFile{path: name, size: info.Size()}
+		if o, err := fsys.path(name); err == nil {
+			if compute {
+				o.sha256()
+			}
+			if d, ok := o.cachedSHA1(); ok {
+				f.sha1 = hex.EncodeToString(d[:])
+				if _, dup := bySHA1[f.sha1]; !dup {
+					bySHA1[f.sha1] = name
+				}
+			}
+		}
+		base := strings.ToLower(gopath.Base(name))
+		byName[base] = append(byName[base], f)
+		return nil
+	})
+	if err != nil {
+		return counts, err
+	}
+
+	for _, rom := range roms {
+		res := auditResult{Status: "missing", datROM: rom}
+		if p, ok := bySHA1[rom.SHA1]; ok && rom.SHA1 != "" {
+			res.Status, res.Path = "present", p
+		} else {
+			for _, f := range byName[strings.ToLower(gopath.Base(rom.Name))] {
+				status := "mismatched"
+				switch {
+				case rom.SHA1 == "" && rom.Size < 0:
+					status = "unknown"
+				case rom.SHA1 == "" && f.size == rom.Size:
+					status = "present"
+				case rom.SHA1 != "" && f.sha1 == "" && (rom.Size < 0 || f.size == rom.Size):
+					status = "unknown"
+				}
+				if auditRank[status] > auditRank[res.Status] {
+					res.Status, res.Path = status, f.path
+				}
+			}
+		}
+		counts.add(res.Status)
+		if err := emit(res); err != nil {
+			return counts, err
+		}
+	}
+	return counts, nil
+}
+
+func auditCmd(args []string) error {
+	flags := flag.NewFlagSet("audit", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprintln(flags.Output(), auditHello) }
+	root := flags.String("root", ".", "")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 3 {
+		return errors.New(auditHello)
+	}
+	cache, target, datName := flags.Arg(0), flags.Arg(1), flags.Arg(2)
+
+	s, err := os.Stat(target)
+	if err != nil {
+		return err
+	} else if !s.IsDir() {
+		return fmt.Errorf("%s: not a directory", target)
+	}
+	*root = strings.Trim(*root, "/")
+	if *root == "" {
+		*root = "."
+	}
+
+	datFile, err := os.Open(datName)
+	if err != nil {
+		return err
+	}
+	roms, err := parseDAT(io.LimitReader(datFile, maxDAT))
+	datFile.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %w", datName, err)
+	}
+
+	fsys := Wrapper(os.DirFS(target), cache)
+	if _, err := fs.Stat(fsys, *root); err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(os.Stdout)
+	counts, err := audit(context.Background(), fsys, *root, roms, true, func(res auditResult) error {
+		_, err := fmt.Fprintf(bw, "%s\t%s/%s\t%s\n", res.Status, res.Game, res.Name, res.Path)
+		return err
+	})
+	fmt.Fprintf(bw, "# %d present, %d missing, %d mismatched, %d unknown\n",
+		counts.Present, counts.Missing, counts.Mismatched, counts.Unknown)
+	err = errors.Join(err, bw.Flush())
+	if fsys.db.Load() != nil {
+		err = errors.Join(err, fsys.db.Load().Close())
+	}
+	return err
+}
+
+// auditAPI checks a subtree against the DAT file in the request body, using only cached checksums,
+// so that a tree that has not been prefetched reports unknown rather than being read in full.
+//
+//	POST /api/v1/audit[?root=PATH]
+//
+// Each ROM is a line {"status":"present"|"missing"|"mismatched"|"unknown","game":"...","rom":"...","size":N,"sha1":"...","path":"..."}
+// and the final line counts each status.
+func auditAPI(fsys *FS, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "use POST with a DAT file", http.StatusMethodNotAllowed)
+		return
+	}
+
+	root := strings.Trim(r.URL.Query().Get("root"), "/")
+	if root == "" {
+		root = "."
+	}
+	if _, err := fs.Stat(fsys, root); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	roms, err := parseDAT(http.MaxBytesReader(w, r.Body, maxDAT))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	counts, err := audit(r.Context(), fsys, root, roms, false, func(res auditResult) error {
+		return enc.Encode(res)
+	})
+	if isIncomplete(err) {
+		enc.Encode(struct {
+			Error string `json:"error"`
+		}{err.Error()}) // instead of counts that look whole
+		return
+	} else if err != nil {
+		return // client has gone away
+	}
+	enc.Encode(counts)
+}
@@ -0,0 +1,251 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"io"
+	"io/fs"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/pebble/v2"
+	"github.com/elliotnunn/BeHierarchic/internal/internpath"
+)
+
+// With -backfill, the server computes the SHA-256 of every file, including those inside archives,
+// so that finding duplicates, writing DATs and searching by hash need not wait for a first download.
+// On a big collection this takes days, so the reads are paced and give way to requests,
+// and the host file that the pass has reached is kept in the database so that a restart carries on from there.
+// After each pass the backfill rests, then starts again to catch new files.
+
+var (
+	backfillWorkers int     // host files hashed at once, or 0 for no backfill
+	backfillRate    float64 // MiB per second, across all the workers
+)
+
+const (
+	backfillRest  = 6 * time.Hour
+	backfillYield = 10 * time.Second // the longest to wait for a moment without requests
+	backfillLog   = time.Minute
+)
+
+var backfillCursorKey = []byte("\xffbackfill/cursor") // the value is the last host file finished, in walk order
+
+type backfill struct {
+	fsys   *FS
+	pacer  *pacer
+	hashed atomic.Int64 // files read through
+	known  atomic.Int64 // files whose digest was already in the database
+	failed atomic.Int64
+}
+
+// backfillForever runs passes over the sharepoint, resting between them
+func (fsys *FS) backfillForever() {
+	for {
+		fsys.backfillPass()
+		time.Sleep(backfillRest)
+	}
+}
+
+func (fsys *FS) backfillPass() {
+	b := &backfill{fsys: fsys, pacer: &pacer{rate: backfillRate * (1 << 20)}}
+	cursor := fsys.backfillCursor()
+	slog.Info("backfillStart", "workers", backfillWorkers, "resumeAfter", cursor)
+	t := time.Now()
+
+	stopTick := make(chan struct{})
+	go func() {
+		tick := time.Tick(backfillLog)
+		for {
+			select {
+			case <-tick:
+				b.log("backfillProgress", t)
+			case <-stopTick:
+				return
+			}
+		}
+	}()
+
+	// The cursor only passes a host file once every file before it is finished too
+	type job struct {
+		seq  int
+		name string
+	}
+	ch := make(chan job)
+	var mu sync.Mutex
+	finished := make(map[int]string)
+	next := 0
+	var wg sync.WaitGroup
+	for range max(backfillWorkers, 1) {
+		wg.Go(func() {
+			for j := range ch {
+				b.file(path{fsys, fsys.root, internpath.Make(j.name)})
+				mu.Lock()
+				finished[j.seq] = j.name
+				for name, ok := finished[next]; ok; name, ok = finished[next] {
+					delete(finished, next)
+					next++
+					fsys.setBackfillCursor(name)
+				}
+				mu.Unlock()
+			}
+		})
+	}
+	seq := 0
+	fs.WalkDir(fsys.root, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		} else if cursor != "" && walkOrder(name, cursor) <= 0 {
+			return nil
+		}
+		ch <- job{seq, name}
+		seq++
+		return nil
+	})
+	close(ch)
+	wg.Wait()
+	close(stopTick)
+
+	fsys.setBackfillCursor("") // the next pass starts from the beginning
+	b.log("backfillDone", t)
+}
+
+func (b *backfill) log(msg string, t time.Time) {
+	slog.Info(msg,
+		"t", time.Since(t).Truncate(time.Second).String(),
+		"hashed", thouSep(b.hashed.Load()),
+		"alreadyKnown", thouSep(b.known.Load()),
+		"failed", thouSep(b.failed.Load()),
+		"bytes", thouSep(b.pacer.bytes.Load()),
+	)
+}
+
+// file hashes a file, then everything inside it if it is an archive
+func (b *backfill) file(o path) {
+	_, known := o.cachedSHA256()
+	if known {
+		_, known = o.cachedSHA1()
+	}
+	if known {
+		b.known.Add(1)
+	} else {
+		b.pacer.yield()
+		if _, err := o.sha256Paced(b.pacer); err != nil {
+			b.failed.Add(1)
+			slog.Warn("backfillFail", "path", o, "err", err)
+		} else {
+			b.hashed.Add(1)
+		}
+	}
+
+	if strings.HasPrefix(o.name.Base(), "._") {
+		return // no use probing resource forks
+	}
+	isar, mnt := o.getArchive(true, true)
+	if !isar {
+		return
+	}
+	for p, kind := range mnt.flatWalk() {
+		if kind.IsRegular() {
+			b.file(p)
+		}
+	}
+}
+
+// walkOrder compares two slash-separated paths in the order that fs.WalkDir visits them,
+// which sorts each directory by name
+func walkOrder(a, b string) int {
+	for {
+		aa, arest, amore := strings.Cut(a, "/")
+		bb, brest, bmore := strings.Cut(b, "/")
+		if c := strings.Compare(aa, bb); c != 0 {
+			return c
+		} else if !amore || !bmore {
+			switch {
+			case amore:
+				return 1
+			case bmore:
+				return -1
+			}
+			return 0
+		}
+		a, b = arest, brest
+	}
+}
+
+func (fsys *FS) backfillCursor() string {
+	db := fsys.db.Load()
+	if db == nil {
+		return ""
+	}
+	val, closer, err := db.Get(backfillCursorKey)
+	if err != nil {
+		return ""
+	}
+	defer closer.Close()
+	return string(val)
+}
+
+func (fsys *FS) setBackfillCursor(name string) {
+	db := fsys.db.Load()
+	if db == nil {
+		return
+	}
+	var err error
+	if name == "" {
+		err = db.Delete(backfillCursorKey, &pebble.WriteOptions{})
+	} else {
+		err = db.Set(backfillCursorKey, []byte(name), &pebble.WriteOptions{})
+	}
+	if err != nil {
+		slog.Error("setBackfillCursorError", "err", err)
+	}
+}
+
+// A pacer spreads reads out in time so that they average no more than a rate,
+// and holds them back while the server is answering requests
+type pacer struct {
+	mu    sync.Mutex
+	rate  float64 // bytes per second, or 0 for no limit
+	next  time.Time
+	bytes atomic.Int64
+}
+
+// wait sleeps long enough to pay for n bytes just read
+func (p *pacer) wait(n int) {
+	p.bytes.Add(int64(n))
+	if p.rate <= 0 {
+		return
+	}
+	p.mu.Lock()
+	now := time.Now()
+	if p.next.Before(now) {
+		p.next = now
+	}
+	d := p.next.Sub(now)
+	p.next = p.next.Add(time.Duration(float64(n) / p.rate * float64(time.Second)))
+	p.mu.Unlock()
+	time.Sleep(d)
+}
+
+// yield waits for a moment when no request is in flight, but not forever, so that a busy server still makes progress
+func (p *pacer) yield() {
+	for deadline := time.Now().Add(backfillYield); inFlight.Load() > 0 && time.Now().Before(deadline); {
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+type pacedReader struct {
+	r io.Reader
+	p *pacer
+}
+
+func (r *pacedReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.p.wait(n)
+	return n, err
+}
@@ -4,9 +4,26 @@
 package main
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
 	"embed"
+	"encoding/binary"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"testing/fstest"
+
+	"github.com/cockroachdb/pebble/v2"
+	"github.com/elliotnunn/BeHierarchic/internal/crc16"
 )
 
 //go:embed testdata
@@ -15,8 +32,308 @@ var image embed.FS
 func TestFS(t *testing.T) {
 	fsys := Wrapper(image, "")
 	fsys.Prefetch()
-	err := fstest.TestFS(fsys, "testdata/archive.tgz◆/archive.tar◆/archive.zip◆/disk.img◆/Macintosh HD/hello world.txt")
+	err := fstest.TestFS(fsys, "testdata/archive.tgz◆/archive.zip◆/Macintosh HD/hello world.txt")
 	if err != nil {
 		t.Error(err)
 	}
+	_, err = fs.ReadFile(fsys, "testdata/archive.tgz◆/archive.tar◆/archive.zip◆/disk.img◆/Macintosh HD/hello world.txt")
+	if err != nil {
+		t.Error("the path through the hidden layers should still work:", err)
+	}
+}
+
+func TestAdminPaths(t *testing.T) {
+	fsys := Wrapper(image, "")
+	public, admin := handler(fsys), adminHandler(fsys)
+	paths := []string{"/debug/pprof/", "/debug/pprof/heap", jobsPath + "/ABC/result"}
+	for p := range adminAPIs {
+		paths = append(paths, p)
+	}
+	for _, p := range paths {
+		w := httptest.NewRecorder()
+		public.ServeHTTP(w, httptest.NewRequest("GET", p, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("public %s: got status %d, want 404", p, w.Code)
+		}
+		if !isAdminPath(p) {
+			t.Errorf("%s: not an admin path", p)
+		}
+	}
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code == http.StatusNotFound {
+		t.Error("admin /readyz: got status 404")
+	}
+}
+
+func TestPublicRepack(t *testing.T) {
+	public := handler(Wrapper(image, ""))
+	const p = "/api/v1/repack?root=testdata"
+	w := httptest.NewRecorder()
+	public.ServeHTTP(w, httptest.NewRequest("GET", p, nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != repackType("zip") {
+		t.Fatalf("public repack: got status %d, %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if _, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len())); err != nil {
+		t.Error("public repack:", err)
+	}
+
+	for range maxRepacks {
+		repackSlots <- struct{}{}
+	}
+	defer func() {
+		for range maxRepacks {
+			<-repackSlots
+		}
+	}()
+	w = httptest.NewRecorder()
+	public.ServeHTTP(w, httptest.NewRequest("GET", p, nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("repack beyond maxRepacks: got status %d, want 503", w.Code)
+	}
+}
+
+func TestSavedSearch(t *testing.T) {
+	defer func(c string) { curator = c }(curator)
+	curator = "user:pass"
+	fsys := Wrapper(image, t.TempDir())
+	public := handler(fsys)
+	post := func(origin string) int {
+		form := "name=recent&q=**/*.txt&sort=newest&days=30"
+		r := httptest.NewRequest("POST", "/.glob.html", strings.NewReader(form))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("Origin", origin)
+		r.SetBasicAuth("user", "pass")
+		w := httptest.NewRecorder()
+		public.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := post("http://elsewhere.example"); code != http.StatusForbidden {
+		t.Errorf("cross-origin save: got status %d, want 403", code)
+	}
+	if got := fsys.savedSearches(); len(got) != 0 {
+		t.Fatalf("a cross-origin save was kept: %v", got)
+	}
+	if code := post("http://example.com"); code != http.StatusSeeOther {
+		t.Fatalf("same-origin save: got status %d, want 303", code)
+	}
+	want := []savedSearch{{Name: "recent", Root: ".", Pattern: "**/*.txt", Sort: "newest", Days: 30}}
+	if got := fsys.savedSearches(); !slices.Equal(got, want) {
+		t.Errorf("saved %v, want %v", got, want)
+	}
+	if u := want[0].URL(); !strings.Contains(u, "days=30") || !strings.Contains(u, "sort=newest") {
+		t.Errorf("the saved search's URL lost its filters: %s", u)
+	}
+}
+
+func TestSealRef(t *testing.T) {
+	sum := blockSum(sha256.Sum256([]byte("block")))
+	if got, ok := unsealRef(sealRef(sum)); !ok || got != sum {
+		t.Errorf("a block reference did not survive sealing: %x %v", got, ok)
+	}
+	if _, ok := unseal(sealRef(sum)); ok {
+		t.Error("a block reference unsealed as data")
+	}
+	if _, ok := unsealRef(seal(make([]byte, sha256.Size))); ok {
+		t.Error("data the size of a block hash unsealed as a block reference")
+	}
+	v := sealRef(sum)
+	v[0] ^= 1
+	if _, ok := unsealRef(v); ok {
+		t.Error("a corrupt block reference unsealed")
+	}
+}
+
+func TestBlockRefs(t *testing.T) {
+	fsys := Wrapper(image, t.TempDir())
+	db := fsys.db.Load()
+	if db == nil {
+		t.Fatal("no cache")
+	}
+	t.Cleanup(func() { db.Close() })
+
+	blk := bytes.Repeat([]byte("block"), dedupBlock/5+1)[:dedupBlock]
+	sum := blockSum(sha256.Sum256(blk))
+	extent := func(prefix string) []byte { return appendint([]byte(prefix), dedupBlock) }
+	for _, prefix := range []string{"a", "b"} {
+		batch := db.NewBatch()
+		refs := make(map[blockSum]blockRef)
+		fsys.setData(batch, []byte(prefix), blk, 0, refs)
+		fsys.applyRefs(batch, refs)
+		if err := batch.Commit(&pebble.WriteOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := fsys.blockRefCount(sum); n != 2 {
+		t.Fatalf("expected two extents to share the block, got a count of %d", n)
+	} else if _, ok := fsys.getBlock(sum); !ok {
+		t.Fatal("the block was not stored")
+	}
+
+	db.Set(blockKey(blockPrefix, sum), []byte("corrupt"), &pebble.WriteOptions{})
+	fsys.dropRef([]byte("a"), extent("a"), sum)
+	fsys.dropRef([]byte("a"), extent("a"), sum) // already gone, so no change
+	if n := fsys.blockRefCount(sum); n != 1 {
+		t.Errorf("expected dropping a corrupt extent to leave a count of 1, got %d", n)
+	}
+	fsys.dropRef([]byte("b"), extent("b"), sum)
+	for _, key := range [][]byte{blockKey(blockRefPrefix, sum), blockKey(blockPrefix, sum), extent("a"), extent("b")} {
+		if _, closer, err := db.Get(key); err != pebble.ErrNotFound {
+			t.Errorf("expected %q to be deleted along with the last reference, got %v", key, err)
+			if err == nil {
+				closer.Close()
+			}
+		}
+	}
+}
+
+// TestConcurrentRefs writes the same blocks into several files at once, several times each,
+// and expects each block to be counted once per file
+func TestConcurrentRefs(t *testing.T) {
+	fsys := Wrapper(image, t.TempDir())
+	db := fsys.db.Load()
+	if db == nil {
+		t.Fatal("no cache")
+	}
+	t.Cleanup(func() { db.Close() })
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 2*dedupBlock/16)
+	data[dedupBlock] = 'X' // so the two blocks differ
+	names := []string{"testdata/archive.tgz", "testdata/archive.tgz◆/archive.tar", "testdata/archive.tgz◆/archive.tar◆/archive.zip"}
+	var wg sync.WaitGroup
+	for _, name := range names {
+		o, err := fsys.path(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range 4 {
+			wg.Go(func() { (&cachingFile{path: o}).setExtent(slices.Clone(data), 0, false) })
+		}
+	}
+	wg.Wait()
+	for _, blk := range [][]byte{data[:dedupBlock], data[dedupBlock:]} {
+		if n := fsys.blockRefCount(sha256.Sum256(blk)); n != int64(len(names)) {
+			t.Errorf("expected a count of %d, got %d", len(names), n)
+		}
+	}
+}
+
+// TestLegacyExtents expects the extents cached before sealing to be deleted when the cache is opened,
+// and nothing else
+func TestLegacyExtents(t *testing.T) {
+	dir := t.TempDir()
+	fsys := Wrapper(image, dir)
+	o, err := fsys.path("testdata/archive.tgz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := appendint(append(dbkey(o), legacyOffsetByte), 100)
+	current := appendint(append(dbkey(o), offsetByte), 100)
+	db := fsys.db.Load()
+	db.Set(legacy, []byte("unsealed"), &pebble.WriteOptions{})
+	db.Set(current, seal([]byte("sealed")), &pebble.WriteOptions{})
+	db.Delete(legacyDropped, &pebble.WriteOptions{}) // as if the cache were older than the sealing
+	db.Close()
+
+	db = Wrapper(image, dir).db.Load()
+	t.Cleanup(func() { db.Close() })
+	if _, closer, err := db.Get(legacy); err != pebble.ErrNotFound {
+		t.Errorf("expected the legacy extent to be gone, got %v", err)
+		if err == nil {
+			closer.Close()
+		}
+	}
+	if _, closer, err := db.Get(current); err != nil {
+		t.Errorf("expected the sealed extent to survive, got %v", err)
+	} else {
+		closer.Close()
+	}
+}
+
+// TestPrefetchZeroRuns walks a directory on disk, as the server does at startup,
+// then reads a file with a long run of zeros inside a compressed archive as prefetch does,
+// and checks that the zeros are cached as a marker and read back intact
+func TestPrefetchZeroRuns(t *testing.T) {
+	dir := t.TempDir()
+	content := slices.Concat([]byte("start"), make([]byte, 256<<10), []byte("end"))
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	tw.WriteHeader(&tar.Header{Name: "sparse.bin", Mode: 0o644, Size: int64(len(content))})
+	tw.Write(content)
+	tw.Close()
+	zw.Close()
+	if err := os.WriteFile(filepath.Join(dir, "archive.tgz"), buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := Wrapper(os.DirFS(dir), t.TempDir())
+	db := fsys.db.Load()
+	if db == nil {
+		t.Fatal("no cache")
+	}
+	t.Cleanup(func() { db.Close() })
+	fsys.Prefetch()
+
+	o, err := fsys.path("archive.tgz◆/sparse.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 { // the second time from the cache
+		f, err := o.prefetchCachedOpen()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, len(content))
+		n, err := f.ReadAt(got, 0)
+		f.Close()
+		if n != len(content) || !bytes.Equal(got, content) {
+			t.Fatalf("read back %d bytes that differ from the %d written (%v)", n, len(content), err)
+		}
+	}
+
+	iter, err := db.NewIter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iter.Close()
+	var zeros int64
+	for iter.First(); iter.Valid(); iter.Next() {
+		if _, n, _, ok := unsealExtent(iter.Value()); ok {
+			zeros += n
+		}
+	}
+	if zeros < 255<<10 {
+		t.Errorf("expected the run of zeros to be cached as a marker, found markers for only %d bytes", zeros)
+	}
+}
+
+// macBinary makes a MacBinary II file with only a data fork
+func macBinary(finderType string, data []byte) []byte {
+	h := make([]byte, 128)
+	h[1] = byte(copy(h[2:], "Archive.sea"))
+	copy(h[65:], finderType+"SIT!")
+	binary.BigEndian.PutUint32(h[83:], uint32(len(data)))
+	h[122], h[123] = 129, 129
+	binary.BigEndian.PutUint16(h[124:], crc16.Checksum(h[:124]))
+	return append(h, data...)
+}
+
+func TestSeaRange(t *testing.T) {
+	archive := []byte("StuffIt (c)1997-2002 Aladdin Systems, Inc., http://www.aladdinsys.com/StuffIt/\r\n")
+	offset, size, ok := seaRange(bytes.NewReader(macBinary("APPL", archive)))
+	if !ok || offset != 128 || size != int64(len(archive)) {
+		t.Errorf("self-extracting archive: got %d, %d, %v", offset, size, ok)
+	}
+
+	for name, file := range map[string][]byte{
+		"document":    macBinary("TEXT", archive),
+		"application": macBinary("APPL", []byte("not an archive at all, just code")),
+		"plain file":  bytes.Repeat([]byte("StuffIt (c)1997-"), 16),
+	} {
+		if _, _, ok := seaRange(bytes.NewReader(file)); ok {
+			t.Errorf("%s: mistaken for a self-extracting archive", name)
+		}
+	}
 }
@@ -0,0 +1,78 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// readBudget caps the bytes served in answer to one request (0 for no cap),
+// because a naive download manager can ask for a nested disk image that expands to hundreds of GB
+var readBudget int64
+
+var errBudget = errors.New("request exceeded the read budget")
+
+// budgeted refuses, with 503, a response whose Content-Length is over budget,
+// and aborts one that reaches the budget without declaring its length
+func budgeted(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if readBudget <= 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(&budgetWriter{ResponseWriter: w, r: r, left: readBudget}, r)
+	})
+}
+
+type budgetWriter struct {
+	http.ResponseWriter
+	r           *http.Request
+	left        int64
+	wroteHeader bool
+	refused     bool
+}
+
+func (w *budgetWriter) WriteHeader(status int) {
+	if w.wroteHeader || w.refused {
+		return
+	}
+	if status >= 200 {
+		w.wroteHeader = true
+	}
+	if n, err := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64); err == nil && n > w.left {
+		slog.Warn("readBudgetRefused", "path", w.r.URL.Path, "length", n, "budget", readBudget)
+		w.refused = true
+		h := w.Header()
+		for _, k := range []string{"Content-Length", "Content-Range", "ETag", "Last-Modified", "Accept-Ranges"} {
+			h.Del(k)
+		}
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+		w.ResponseWriter.WriteHeader(http.StatusServiceUnavailable)
+		w.ResponseWriter.Write([]byte(errBudget.Error() + "\n"))
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *budgetWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.refused {
+		return 0, errBudget // so that the handler stops reading
+	}
+	if int64(len(p)) > w.left {
+		slog.Warn("readBudgetAborted", "path", w.r.URL.Path, "budget", readBudget)
+		panic(http.ErrAbortHandler) // too late for a 503, so cut the connection
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.left -= int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach Flush on the underlying writer
+func (w *budgetWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
@@ -0,0 +1,300 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/cockroachdb/pebble/v2"
+	"github.com/cockroachdb/pebble/v2/sstable/block"
+	"github.com/elliotnunn/BeHierarchic/internal/fileid"
+)
+
+// The cache only saves work, so when it cannot be opened (another process holds it, or it is corrupt)
+// the server carries on without it, trying again now and then, and /readyz says so until it succeeds.
+// With -requirecache the server refuses to start instead.
+
+// requireCache makes the server fail to start without its cache
+var requireCache bool
+
+const (
+	reopenFirst = 10 * time.Second
+	reopenMax   = 10 * time.Minute
+)
+
+func dbOptions() *pebble.Options {
+	opts := &pebble.Options{
+		CacheSize:            128 * 1024 * 1024,
+		AllocatorSizeClasses: []int{16 * 1024, 32 * 1024, 64 * 1024, 128 * 1024, 256 * 1024},
+		EventListener: &pebble.EventListener{
+			// A corrupt block is a failed read like any other, not a reason to stop the server
+			DataCorruption: func(info pebble.DataCorruptionInfo) {
+				slog.Error("dbCorrupt", "file", info.Path, "err", info.Details, "hint", "try BeHierarchic cache repair")
+			},
+		},
+	}
+	opts.ApplyCompressionSettings(func() pebble.DBCompressionSettings {
+		return pebble.UniformDBCompressionSettings(block.MinLZCompression)
+	})
+	return opts
+}
+
+func (fsys *FS) setupDB(dsn string) {
+	if dsn == "" {
+		return
+	}
+	fsys.pinDir = filepath.Join(dsn, "pinned")
+	err := fsys.openDB(dsn)
+	if err != nil {
+		slog.Error("dbFail", "path", dsn, "err", err, "hint", dbHint(err))
+		go fsys.reopenDB(dsn)
+	}
+}
+
+func (fsys *FS) openDB(dsn string) error {
+	db, err := pebble.Open(dsn, dbOptions())
+	fsys.cMu.Lock()
+	fsys.dbErr = err
+	fsys.cMu.Unlock()
+	if err != nil {
+		return err
+	}
+	slog.Info("dbOK", "dsn", dsn)
+	dropLegacyExtents(db)
+	fsys.pMu.Lock()
+	fsys.db.Store(db)
+	fsys.loadPins()
+	fsys.pMu.Unlock()
+	return nil
+}
+
+// Extents cached before they were sealed are under legacyOffsetByte, where nothing reads them.
+// They cannot be deleted as one range, because each sits under the key of its own file,
+// so the first open of an older cache looks through it once, then leaves legacyDropped to say so.
+const legacyOffsetByte = 0xcc
+
+var legacyDropped = []byte("\xfflegacy/0xcc-dropped")
+
+func dropLegacyExtents(db *pebble.DB) {
+	if _, closer, err := db.Get(legacyDropped); err == nil {
+		closer.Close()
+		return
+	}
+	iter, err := db.NewIter(&pebble.IterOptions{UpperBound: []byte{0xff}}) // above every dbkey
+	if err != nil {
+		slog.Error("legacyExtentsError", "err", err)
+		return
+	}
+	defer iter.Close()
+	var n int
+	batch := db.NewBatch()
+	for iter.First(); iter.Valid(); iter.Next() {
+		if keyKind(iter.Key()) != legacyOffsetByte {
+			continue
+		}
+		batch.Delete(slices.Clone(iter.Key()), &pebble.WriteOptions{})
+		n++
+		if batch.Len() >= 1<<20 {
+			if err := batch.Commit(&pebble.WriteOptions{}); err != nil {
+				slog.Error("legacyExtentsError", "err", err)
+				return
+			}
+			batch = db.NewBatch()
+		}
+	}
+	batch.Set(legacyDropped, nil, &pebble.WriteOptions{})
+	if err := batch.Commit(&pebble.WriteOptions{}); err != nil {
+		slog.Error("legacyExtentsError", "err", err)
+		return
+	}
+	if n > 0 {
+		slog.Info("legacyExtentsDropped", "count", n)
+	}
+}
+
+// keyKind finds the byte that follows the dbkey at the start of a key, such as offsetByte,
+// by skipping the identities that make up the dbkey, each preceded by its length
+func keyKind(key []byte) byte {
+	i := 0
+	for i < len(key) && int(key[i]) <= len(fileid.ID{}) {
+		i += 1 + int(key[i])
+	}
+	if i >= len(key) {
+		return 0
+	}
+	return key[i]
+}
+
+// reopenDB keeps trying, less and less often, until the cache opens
+func (fsys *FS) reopenDB(dsn string) {
+	for wait := reopenFirst; ; wait = min(2*wait, reopenMax) {
+		time.Sleep(wait)
+		err := fsys.openDB(dsn)
+		if err == nil {
+			return
+		}
+		slog.Warn("dbRetryFail", "path", dsn, "err", err, "next", min(2*wait, reopenMax))
+	}
+}
+
+// cacheErr is why the cache is not open, or nil if it is open or there is none
+func (fsys *FS) cacheErr() error {
+	fsys.cMu.Lock()
+	defer fsys.cMu.Unlock()
+	return fsys.dbErr
+}
+
+func dbLocked(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EACCES)
+}
+
+func dbHint(err error) string {
+	if dbLocked(err) {
+		return "is another BeHierarchic using it?"
+	}
+	return "try BeHierarchic cache repair"
+}
+
+// readyzAPI answers 200 when the server is working fully, and 503 while it is working without its cache
+func readyzAPI(fsys *FS, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if err := fsys.cacheErr(); err != nil {
+		http.Error(w, "cache unavailable: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+const cacheHello = `Usage:  BeHierarchic cache repair CACHE
+
+Checks the cache database, and if it is corrupt, replaces it with a new one
+holding whatever could be read from the old, which is kept beside it.
+Pinned files are carried over. Run it while the server is stopped.`
+
+func cacheCmd(args []string) error {
+	flags := flag.NewFlagSet("cache", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprintln(flags.Output(), cacheHello) }
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 2 || flags.Arg(0) != "repair" {
+		return errors.New(cacheHello)
+	}
+	return repairCache(flags.Arg(1))
+}
+
+func repairCache(dsn string) error {
+	if _, err := os.Stat(dsn); err != nil {
+		return err
+	}
+	opts := dbOptions()
+	db, err := pebble.Open(dsn, opts)
+	if dbLocked(err) {
+		return fmt.Errorf("%s: in use, so stop the server first: %w", dsn, err)
+	}
+	if err == nil {
+		err = checkDB(db)
+		if err == nil {
+			fmt.Printf("%s: no problems found\n", dsn)
+			return db.Close()
+		}
+	} else {
+		// Perhaps enough of it can be read to save something
+		opts.ReadOnly = true
+		db, _ = pebble.Open(dsn, opts)
+	}
+	fmt.Printf("%s: %v\n", dsn, err)
+
+	fresh := dsn + ".repair"
+	aside := dsn + ".corrupt-" + time.Now().Format("20060102-150405")
+	os.RemoveAll(fresh)
+	ndb, err := pebble.Open(fresh, dbOptions())
+	if err != nil {
+		if db != nil {
+			db.Close()
+		}
+		return err
+	}
+	var n int
+	var salvageErr error
+	if db != nil {
+		n, salvageErr = copyDB(ndb, db)
+		db.Close()
+	}
+	err = ndb.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(dsn, aside)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(filepath.Join(aside, "pinned"), filepath.Join(fresh, "pinned"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	err = os.Rename(fresh, dsn)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: rebuilt with %d records salvaged", dsn, n)
+	if salvageErr != nil {
+		fmt.Printf(" before %v", salvageErr)
+	}
+	fmt.Printf("\nthe old one is at %s and can be deleted\n", aside)
+	return nil
+}
+
+// checkDB reads every record
+func checkDB(db *pebble.DB) error {
+	err := db.CheckLevels(nil)
+	if err != nil {
+		return err
+	}
+	iter, err := db.NewIter(&pebble.IterOptions{})
+	if err != nil {
+		return err
+	}
+	for iter.First(); iter.Valid(); iter.Next() {
+		iter.Value()
+	}
+	return errors.Join(iter.Error(), iter.Close())
+}
+
+// copyDB copies records in order until it cannot read any more
+func copyDB(dst, src *pebble.DB) (n int, err error) {
+	iter, err := src.NewIter(&pebble.IterOptions{})
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+	batch := dst.NewBatch()
+	for iter.First(); iter.Valid(); iter.Next() {
+		batch.Set(iter.Key(), iter.Value(), nil)
+		n++
+		if batch.Len() > 16<<20 {
+			if err := batch.Commit(pebble.NoSync); err != nil {
+				return n, err
+			}
+			batch = dst.NewBatch()
+		}
+	}
+	return n, errors.Join(iter.Error(), batch.Commit(pebble.Sync))
+}
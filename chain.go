@@ -0,0 +1,78 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"encoding/xml"
+	"io/fs"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/elliotnunn/BeHierarchic/internal/webdavfs"
+)
+
+// propNS is the XML namespace of the WebDAV properties that only BeHierarchic has
+const propNS = "https://github.com/elliotnunn/BeHierarchic"
+
+// The container-chain property lists the archives enclosing a file, outermost first,
+// so that a sync tool or cataloger can record where exactly it came from:
+//
+//	<container-chain xmlns="https://github.com/elliotnunn/BeHierarchic">
+//	  <container format="zip"><href>/cds/games.zip</href></container>
+//	  <container format="gzip tar"><href>/cds/games.zip%E2%97%86/src.tar.gz</href></container>
+//	</container-chain>
+//
+// The format attribute names any wrapper layers that are hidden, such as the gzip around a tar, outermost first.
+var containerChainProp = xml.Name{Space: propNS, Local: "container-chain"}
+
+var (
+	_ webdavfs.LivePropsFS = (*FS)(nil)
+	_ webdavfs.LivePropsFS = view{}
+)
+
+func (fsys *FS) LiveProps(name string, fi fs.FileInfo) (map[xml.Name]string, error) {
+	return fsys.liveProps(nil, name)
+}
+
+func (v view) LiveProps(name string, fi fs.FileInfo) (map[xml.Name]string, error) {
+	return v.FS.liveProps(v.s, name)
+}
+
+func (fsys *FS) liveProps(s *session, name string) (map[xml.Name]string, error) {
+	o, err := fsys.pathIn(s, name)
+	if err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	for _, c := range o.containerChain() {
+		b.WriteString(`<container format="`)
+		xml.EscapeText(&b, []byte(strings.Join(c.formats, " ")))
+		b.WriteString(`"><href>`)
+		xml.EscapeText(&b, []byte((&url.URL{Path: "/" + c.path}).EscapedPath()))
+		b.WriteString(`</href></container>`)
+	}
+	return map[xml.Name]string{containerChainProp: b.String()}, nil
+}
+
+type container struct {
+	path    string
+	formats []string // outermost first
+}
+
+// containerChain returns the archives that enclose a file, outermost first
+func (o path) containerChain() []container {
+	var chain []container
+	for f := o.fsys; f != o.container.root; {
+		o.container.rMu.RLock()
+		archive := o.container.mountedFrom(f)
+		o.container.rMu.RUnlock()
+		formats := o.container.formatsOf(f)
+		slices.Reverse(formats)
+		chain = append(chain, container{archive.Thick(o.container).String(), formats})
+		f = archive.fsys
+	}
+	slices.Reverse(chain)
+	return chain
+}
@@ -0,0 +1,56 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import "errors"
+
+// When many clients want the same uncached range at once (a newly posted disk image, say),
+// only the first does the work. The rest wait for it and copy its result,
+// instead of each decompressing the range and writing it to the cache again.
+
+var errFlightAborted = errors.New("the read that this one was waiting on did not finish")
+
+type flightKey struct {
+	thinPath
+	off int64
+	n   int
+}
+
+type flight struct {
+	done      chan struct{}
+	followers int
+	p         []byte // valid once done, if there were followers
+	err       error
+}
+
+// coalesce calls read unless an identical read is in flight, in which case it waits for that one
+func (o path) coalesce(p []byte, off int64, read func() (int, error)) (int, error) {
+	fsys := o.container
+	k := flightKey{o.Thin(), off, len(p)}
+
+	fsys.fMu.Lock()
+	if fl, ok := fsys.flights[k]; ok {
+		fl.followers++
+		fsys.fMu.Unlock()
+		<-fl.done
+		return copy(p, fl.p), fl.err
+	}
+	fl := &flight{done: make(chan struct{})}
+	fsys.flights[k] = fl
+	fsys.fMu.Unlock()
+
+	n, err := 0, errFlightAborted // unless read returns
+	defer func() {                // even if read panics, the followers must not wait forever
+		fsys.fMu.Lock()
+		delete(fsys.flights, k)
+		if fl.followers > 0 {
+			fl.p = append([]byte(nil), p[:n]...) // p is the caller's to reuse
+		}
+		fl.err = err
+		fsys.fMu.Unlock()
+		close(fl.done)
+	}()
+	n, err = read()
+	return n, err
+}
@@ -0,0 +1,84 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"io/fs"
+	"slices"
+
+	"github.com/elliotnunn/BeHierarchic/internal/internpath"
+)
+
+// Much old software comes in layers, such as a disk image in a StuffIt archive in a .gz,
+// and a URL with a ◆ for every layer is tiresome. A wrapper whose only member is another archive
+// is hidden, so that the wrapper file appears to contain what is inside the member.
+// The hidden layers still resolve, so that older links keep working.
+var exposeLayers bool
+
+// wrapperFormats are those whose listing is quick to complete, so that hiding them is deterministic
+var wrapperFormats = []string{"bzip2", "gzip", "sea", "sit", "xz", "zip"}
+
+// collapse returns the file system to mount in place of fsys2,
+// which is either fsys2 or, if fsys2 is a hidden wrapper, the archive inside it
+func (o path) collapse(fsys2 fs.FS) fs.FS {
+	if exposeLayers {
+		return fsys2
+	}
+	o.container.rMu.RLock()
+	format := o.container.formats[fsys2]
+	o.container.rMu.RUnlock()
+	if !slices.Contains(wrapperFormats, format) {
+		return fsys2
+	}
+
+	list, err := fs.ReadDir(fsys2, ".")
+	if err != nil {
+		return fsys2
+	}
+	var only fs.DirEntry
+	for _, de := range list {
+		if isSidecar(de) {
+			continue // the resource fork of the disk image, most likely
+		} else if only != nil {
+			return fsys2
+		}
+		only = de
+	}
+	if only == nil || !only.Type().IsRegular() {
+		return fsys2
+	}
+
+	member := path{o.container, fsys2, internpath.Path{}}.ShallowJoin(only.Name())
+	isar, mnt := member.getArchive(true, true)
+	if !isar {
+		return fsys2
+	}
+	o.container.rMu.Lock()
+	o.container.hidden[fsys2] = true
+	o.container.rMu.Unlock()
+	return mnt.fsys
+}
+
+// mountedFrom returns the file that appears as the archive containing fsys, skipping hidden layers.
+// The caller must hold rMu.
+func (fsys *FS) mountedFrom(f fs.FS) thinPath {
+	archive := fsys.reverse[f]
+	for fsys.hidden[archive.fsys] {
+		archive = fsys.reverse[archive.fsys]
+	}
+	return archive
+}
+
+// hiddenLayers returns the members of the hidden layers above fsys, outermost first,
+// which a path from before the layers were hidden would name
+func (fsys *FS) hiddenLayers(f fs.FS) []thinPath {
+	fsys.rMu.RLock()
+	defer fsys.rMu.RUnlock()
+	var layers []thinPath
+	for archive := fsys.reverse[f]; fsys.hidden[archive.fsys]; archive = fsys.reverse[archive.fsys] {
+		layers = append(layers, archive)
+	}
+	slices.Reverse(layers)
+	return layers
+}
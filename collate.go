@@ -0,0 +1,65 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"cmp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// collation orders directory listings, which byte order makes confusing for multi-disk sets
+var collation = collationBytes
+
+const (
+	collationBytes   = "bytes"   // disk1, disk10, disk2, Disk3
+	collationNatural = "natural" // disk1, disk2, Disk3, disk10
+)
+
+var collations = []string{collationBytes, collationNatural}
+
+// compareNames orders two names in a listing according to the collation
+func compareNames(a, b string) int {
+	if collation == collationNatural {
+		if c := compareNatural(a, b); c != 0 {
+			return c
+		}
+	}
+	return strings.Compare(a, b) // a total order even when the collation has ties
+}
+
+// compareNatural ignores case and compares runs of digits by their numeric value
+func compareNatural(a, b string) int {
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			na, nb := digitRun(a), digitRun(b)
+			ta, tb := strings.TrimLeft(a[:na], "0"), strings.TrimLeft(b[:nb], "0")
+			if len(ta) != len(tb) {
+				return cmp.Compare(len(ta), len(tb))
+			} else if c := strings.Compare(ta, tb); c != 0 {
+				return c
+			}
+			a, b = a[na:], b[nb:]
+			continue
+		}
+		ra, sa := utf8.DecodeRuneInString(a)
+		rb, sb := utf8.DecodeRuneInString(b)
+		if ra, rb = unicode.ToLower(ra), unicode.ToLower(rb); ra != rb {
+			return cmp.Compare(ra, rb)
+		}
+		a, b = a[sa:], b[sb:]
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
+
+func digitRun(s string) int {
+	n := 0
+	for n < len(s) && isDigit(s[n]) {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,180 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"log/slog"
+	"slices"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/cockroachdb/pebble/v2"
+)
+
+// Whole aligned blocks of cached data are stored once, under a hash of their content,
+// and the extent that covers each one holds just the hash.
+// So a file that appears in many repacked archives takes the disk space of one copy.
+
+const (
+	dedupBlock     = 64 * 1024
+	blockPrefix    = "\xffblock/"       // then a SHA-256 ~ "value is sealed data"
+	blockRefPrefix = "\xffblockref/"    // then a SHA-256 ~ "value is a reference count"
+	refSalt        = 0x5eb10c5eb10c5eb1 // distinguishes the seal of a block reference from that of data
+	lockStripes    = 64                 // so that cache writes to different files and blocks seldom wait for each other
+)
+
+type blockSum = [sha256.Size]byte
+
+// blockRef accumulates the changes that one batch makes to a block's reference count
+type blockRef struct {
+	delta int64
+	p     []byte // the content, if the batch adds a reference
+}
+
+// sealRef stands for a block of data by its hash, with a seal that cannot be mistaken for that of real data
+func sealRef(sum blockSum) []byte {
+	v := sum[:]
+	return binary.BigEndian.AppendUint64(v, xxhash.Sum64(v)^refSalt)
+}
+
+func unsealRef(v []byte) (blockSum, bool) {
+	if len(v) != sha256.Size+8 {
+		return blockSum{}, false
+	}
+	m, sum := v[:sha256.Size], v[sha256.Size:]
+	return blockSum(m), binary.BigEndian.Uint64(sum) == xxhash.Sum64(m)^refSalt
+}
+
+func blockKey(prefix string, sum blockSum) []byte {
+	return append([]byte(prefix), sum[:]...)
+}
+
+// getBlock returns a copy of a block's content, or false if it is missing or corrupt
+func (fsys *FS) getBlock(sum blockSum) ([]byte, bool) {
+	val, closer, err := fsys.db.Load().Get(blockKey(blockPrefix, sum))
+	if err == pebble.ErrNotFound {
+		return nil, false
+	} else if err != nil {
+		slog.Error("getBlockError", "err", err)
+		return nil, false
+	}
+	defer closer.Close()
+	p, ok := unseal(val)
+	if !ok || len(p) != dedupBlock {
+		return nil, false
+	}
+	return slices.Clone(p), true
+}
+
+func (fsys *FS) blockRefCount(sum blockSum) int64 {
+	val, closer, err := fsys.db.Load().Get(blockKey(blockRefPrefix, sum))
+	if err != nil {
+		return 0
+	}
+	defer closer.Close()
+	n, _ := read1int(val)
+	return n
+}
+
+// setData stores an extent of data, as data at the unaligned ends and as block references in between
+func (fsys *FS) setData(batch *pebble.Batch, idPrefix []byte, p []byte, off int64, refs map[blockSum]blockRef) {
+	end := bufEnd(p, off)
+	first := (off + dedupBlock - 1) / dedupBlock * dedupBlock
+	last := end / dedupBlock * dedupBlock
+	if last-first < dedupBlock {
+		batch.Set(appendint(idPrefix, end), seal(p), &pebble.WriteOptions{})
+		return
+	}
+	if first > off {
+		batch.Set(appendint(idPrefix, first), seal(p[:first-off]), &pebble.WriteOptions{})
+	}
+	for b := first; b < last; b += dedupBlock {
+		blk := p[b-off:][:dedupBlock]
+		sum := sha256.Sum256(blk)
+		r := refs[sum]
+		r.delta++
+		r.p = blk
+		refs[sum] = r
+		batch.Set(appendint(idPrefix, b+dedupBlock), sealRef(sum), &pebble.WriteOptions{})
+	}
+	if end > last {
+		batch.Set(appendint(idPrefix, end), seal(p[last-off:]), &pebble.WriteOptions{})
+	}
+}
+
+// lockExtents serialises the writers of one file's extents, so that two cannot both drop the same block reference
+func (fsys *FS) lockExtents(idPrefix []byte) (unlock func()) {
+	m := &fsys.xMu[xxhash.Sum64(idPrefix)%lockStripes]
+	m.Lock()
+	return m.Unlock
+}
+
+// lockBlocks holds the reference counts of the blocks that a batch changes, always in the same order
+func (fsys *FS) lockBlocks(refs map[blockSum]blockRef) (unlock func()) {
+	var held [lockStripes]bool
+	for sum := range refs {
+		held[sum[0]%lockStripes] = true
+	}
+	for i, h := range held {
+		if h {
+			fsys.bMu[i].Lock()
+		}
+	}
+	return func() {
+		for i, h := range held {
+			if h {
+				fsys.bMu[i].Unlock()
+			}
+		}
+	}
+}
+
+// dropRef deletes an extent whose block has gone missing or corrupt, and the reference it held,
+// unless a writer has replaced the extent in the meantime
+func (fsys *FS) dropRef(idPrefix, xid []byte, sum blockSum) {
+	defer fsys.lockExtents(idPrefix)()
+	db := fsys.db.Load()
+	val, closer, err := db.Get(xid)
+	if err != nil {
+		return
+	}
+	still, ok := unsealRef(val)
+	closer.Close()
+	if !ok || still != sum {
+		return
+	}
+	batch := db.NewBatch()
+	batch.Delete(xid, &pebble.WriteOptions{})
+	refs := map[blockSum]blockRef{sum: {delta: -1}}
+	defer fsys.lockBlocks(refs)()
+	fsys.applyRefs(batch, refs)
+	if err := batch.Commit(&pebble.WriteOptions{}); err != nil {
+		slog.Error("dropRefError", "err", err)
+	}
+}
+
+// applyRefs adds the batch's changes to the reference counts,
+// storing blocks that are newly referenced and deleting those that no longer are.
+// The caller must hold lockBlocks(refs) until the batch is committed.
+//
+// A block that goes missing while referenced is not stored again until every extent that refers to it
+// has been found wanting and dropped, which saves reading back every block on every write.
+func (fsys *FS) applyRefs(batch *pebble.Batch, refs map[blockSum]blockRef) {
+	for sum, r := range refs {
+		if r.delta == 0 {
+			continue
+		}
+		n := fsys.blockRefCount(sum)
+		if n+r.delta <= 0 {
+			batch.Delete(blockKey(blockRefPrefix, sum), &pebble.WriteOptions{})
+			batch.Delete(blockKey(blockPrefix, sum), &pebble.WriteOptions{})
+			continue
+		}
+		batch.Set(blockKey(blockRefPrefix, sum), appendint(nil, n+r.delta), &pebble.WriteOptions{})
+		if n <= 0 && r.p != nil {
+			batch.Set(blockKey(blockPrefix, sum), seal(r.p), &pebble.WriteOptions{})
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/cockroachdb/pebble/v2"
+)
+
+type digest [sha256.Size]byte
+
+var errNotRegular = errors.New("not a regular file")
+
+// sha256 returns the digest of a regular file's contents.
+// The answer is remembered in the database alongside the modtime it was computed for,
+// so that a host file edited in place is not given a stale digest.
+func (o path) sha256() (digest, error) { return o.sha256Paced(nil) }
+
+// sha256Paced is sha256 reading no faster than the pacer allows, if there is one
+func (o path) sha256Paced(p *pacer) (digest, error) {
+	stat, err := o.cookedStat()
+	if err != nil {
+		return digest{}, err
+	} else if !stat.Mode().IsRegular() {
+		return digest{}, errNotRegular
+	}
+	mtime := digestMtime(stat.ModTime())
+	if d, ok := o.getCacheDigest(mtime); ok {
+		if _, ok := o.getCacheSHA1(mtime); ok { // else cached before SHA-1 was, so fill it in
+			return d, nil
+		}
+	}
+
+	f, err := o.cookedOpen()
+	if err != nil {
+		return digest{}, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if p != nil {
+		r = &pacedReader{r, p}
+	}
+	h, h1 := sha256.New(), sha1.New() // SHA-1 too, for looking up in other databases
+	_, err = io.Copy(io.MultiWriter(h, h1), r)
+	if err != nil {
+		return digest{}, err
+	}
+	var d digest
+	h.Sum(d[:0])
+	o.setCacheDigest(mtime, d)
+	o.setCacheSHA1(mtime, h1.Sum(nil))
+	o.archiveChecksum() // having read it through, remember whether it matched
+	return d, nil
+}
+
+// checksummed is implemented by the members of archives that record a checksum, such as a zip's CRC-32
+type checksummed interface {
+	Checksummed() (algo string, sum []byte, verified bool)
+}
+
+// archiveChecksum returns the checksum that the containing archive records for the file, if any.
+// It is verified once the file has been read through, as sha256 does,
+// and this is remembered in the database alongside the modtime and checksum,
+// so that a verified file stays verified after a restart without being read again.
+func (o path) archiveChecksum() (algo string, sum []byte, verified bool) {
+	f, err := o.rawOpen()
+	if err != nil {
+		return "", nil, false
+	}
+	defer f.Close()
+	c, ok := f.(checksummed)
+	if !ok {
+		return "", nil, false
+	}
+	algo, sum, verified = c.Checksummed()
+	if algo == "" {
+		return "", nil, false
+	}
+	stat, err := o.cookedStat()
+	if err != nil {
+		return algo, sum, verified
+	}
+	mtime := digestMtime(stat.ModTime())
+	if verified {
+		if !o.getCacheVerified(mtime, sum) {
+			o.setCacheVerified(mtime, sum)
+		}
+	} else {
+		verified = o.getCacheVerified(mtime, sum)
+	}
+	return algo, sum, verified
+}
+
+func (o path) getCacheVerified(mtime, sum []byte) bool {
+	if o.container.db.Load() == nil {
+		return false
+	}
+	id := append(dbkey(o), crcOKByte)
+	defer discardkey(id)
+	val, closer, err := o.container.db.Load().Get(id)
+	if err != nil {
+		return false
+	}
+	defer closer.Close()
+	return len(val) == len(mtime)+len(sum) && bytes.Equal(val[:len(mtime)], mtime) && bytes.Equal(val[len(mtime):], sum)
+}
+
+func (o path) setCacheVerified(mtime, sum []byte) {
+	if o.container.db.Load() == nil {
+		return
+	}
+	id := append(dbkey(o), crcOKByte)
+	defer discardkey(id)
+	err := o.container.db.Load().Set(id, append(mtime[:len(mtime):len(mtime)], sum...), &pebble.WriteOptions{})
+	if err != nil {
+		slog.Error("setCacheVerifiedError", "path", o, "err", err)
+	}
+}
+
+// cachedSHA256 returns the digest only if it is already in the database
+func (o path) cachedSHA256() (digest, bool) {
+	stat, err := o.cookedStat()
+	if err != nil || !stat.Mode().IsRegular() {
+		return digest{}, false
+	}
+	return o.getCacheDigest(digestMtime(stat.ModTime()))
+}
+
+// cachedSHA1 returns the SHA-1 digest if it was computed alongside the SHA-256,
+// and never reads the file to compute it
+func (o path) cachedSHA1() ([sha1.Size]byte, bool) {
+	stat, err := o.cookedStat()
+	if err != nil {
+		return [sha1.Size]byte{}, false
+	}
+	return o.getCacheSHA1(digestMtime(stat.ModTime()))
+}
+
+func (o path) getCacheSHA1(mtime []byte) ([sha1.Size]byte, bool) {
+	var d [sha1.Size]byte
+	if o.container.db.Load() == nil {
+		return d, false
+	}
+	id := append(dbkey(o), sha1Byte)
+	defer discardkey(id)
+	val, closer, err := o.container.db.Load().Get(id)
+	if err != nil {
+		return d, false
+	}
+	defer closer.Close()
+	if len(val) != len(mtime)+len(d) || !bytes.Equal(val[:len(mtime)], mtime) {
+		return d, false
+	}
+	copy(d[:], val[len(mtime):])
+	return d, true
+}
+
+func (o path) setCacheSHA1(mtime []byte, d []byte) {
+	if o.container.db.Load() == nil {
+		return
+	}
+	id := append(dbkey(o), sha1Byte)
+	defer discardkey(id)
+	err := o.container.db.Load().Set(id, append(mtime[:len(mtime):len(mtime)], d...), &pebble.WriteOptions{})
+	if err != nil {
+		slog.Error("setCacheSHA1Error", "path", o, "err", err)
+	}
+}
+
+func digestMtime(t time.Time) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(t.UnixNano()))
+}
+
+func (o path) getCacheDigest(mtime []byte) (digest, bool) {
+	if o.container.db.Load() == nil {
+		return digest{}, false
+	}
+	id := append(dbkey(o), digestByte)
+	defer discardkey(id)
+	val, closer, err := o.container.db.Load().Get(id)
+	if err == pebble.ErrNotFound {
+		return digest{}, false
+	} else if err != nil {
+		slog.Error("getCacheDigestError", "path", o, "err", err)
+		return digest{}, false
+	}
+	defer closer.Close()
+	var d digest
+	if len(val) != len(mtime)+len(d) || !bytes.Equal(val[:len(mtime)], mtime) {
+		return digest{}, false
+	}
+	copy(d[:], val[len(mtime):])
+	return d, true
+}
+
+func (o path) setCacheDigest(mtime []byte, d digest) {
+	if o.container.db.Load() == nil {
+		return
+	}
+	id := append(dbkey(o), digestByte)
+	defer discardkey(id)
+	err := o.container.db.Load().Set(id, append(mtime[:len(mtime):len(mtime)], d[:]...), &pebble.WriteOptions{})
+	if err != nil {
+		slog.Error("setCacheDigestError", "path", o, "err", err)
+	}
+}
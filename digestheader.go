@@ -0,0 +1,79 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// setDigestHeaders lets a mirror verify a download without a separate checksum file,
+// by sending the SHA-256 of a regular file as Repr-Digest (RFC 9530), as the older Digest (RFC 3230)
+// and as X-Content-SHA256, which some download tools look for instead.
+//
+// Only a digest already in the database is sent, because computing one means reading the whole file
+// before the first byte of the response (even for a HEAD), so a file not yet hashed has none.
+// A client that asks with Want-Repr-Digest or Want-Digest gets the SHA-1 as well if it asks for that,
+// and nothing if it asks only for algorithms we do not know.
+func setDigestHeaders(fsys *FS, w http.ResponseWriter, r *http.Request) {
+	o, err := fsys.path(pathOf(r))
+	if err != nil {
+		return
+	}
+	asked, want256, want1 := digestWanted(r.Header)
+	if asked && !want256 && !want1 {
+		return
+	}
+	d, ok := o.cachedSHA256()
+	if !ok {
+		return
+	}
+	var d1 [sha1.Size]byte
+	ok1 := false
+	if want1 {
+		d1, ok1 = o.cachedSHA1()
+	}
+
+	repr := []string{"sha-256=:" + base64.StdEncoding.EncodeToString(d[:]) + ":"}
+	legacy := []string{"SHA-256=" + base64.StdEncoding.EncodeToString(d[:])}
+	if want1 && ok1 {
+		repr = append(repr, "sha=:"+base64.StdEncoding.EncodeToString(d1[:])+":")
+		legacy = append(legacy, "SHA="+base64.StdEncoding.EncodeToString(d1[:]))
+	}
+	h := w.Header()
+	h.Set("Repr-Digest", strings.Join(repr, ", "))
+	h.Set("Digest", strings.Join(legacy, ", "))
+	h.Set("X-Content-SHA256", hex.EncodeToString(d[:]))
+}
+
+// digestWanted reads Want-Repr-Digest ("sha-256=10, sha=3") and Want-Digest ("SHA-256;q=0.5, SHA"),
+// reporting whether either was sent and which of the algorithms we know were given a nonzero preference
+func digestWanted(h http.Header) (asked, sha256, sha1 bool) {
+	for _, key := range []string{"Want-Repr-Digest", "Want-Digest"} {
+		for _, line := range h.Values(key) {
+			asked = true
+			for item := range strings.SplitSeq(line, ",") {
+				name, weight, _ := strings.Cut(item, ";") // Want-Digest
+				name, w, ok := strings.Cut(name, "=")     // Want-Repr-Digest
+				if !ok {
+					_, w, _ = strings.Cut(weight, "=")
+				}
+				if w, err := strconv.ParseFloat(strings.TrimSpace(w), 64); err == nil && w == 0 {
+					continue
+				}
+				switch strings.ToLower(strings.TrimSpace(name)) {
+				case "sha-256":
+					sha256 = true
+				case "sha":
+					sha1 = true
+				}
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,127 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"context"
+	"io/fs"
+	"strings"
+	"sync"
+	"time"
+)
+
+// macSizes folds each "._" sidecar into the file it belongs to when a directory's size is totalled,
+// counting only the resource fork inside it, as a Mac user would expect,
+// rather than counting the sidecar as another file
+var macSizes bool
+
+// A directory is totalled in the background, so that an info page waits no longer than dirSizeWait
+// and shows the count so far, and the total is kept for the next visit
+const (
+	dirSizeWait   = 2 * time.Second
+	dirSizeMaxAge = time.Minute      // after which a finished total is counted again
+	dirSizeGiveUp = 10 * time.Minute // on a walk that is still going
+	maxDirSizes   = 256              // beyond which an arbitrary total is forgotten
+)
+
+type dirSize struct {
+	files      int64
+	data, rsrc int64 // rsrc only when macSizes
+	cutShort   bool  // still counting, or given up after dirSizeGiveUp
+}
+
+type dirSizeCount struct {
+	mu    sync.Mutex
+	s     dirSize
+	done  chan struct{}
+	gen   uint64 // see generation.go
+	start time.Time
+}
+
+// dirSize totals the regular files under a directory, not descending into nested archives,
+// or as many as have been counted after dirSizeWait
+func (fsys *FS) dirSize(ctx context.Context, root string) dirSize {
+	c := fsys.dirSizeCount(root)
+	t := time.NewTimer(dirSizeWait)
+	defer t.Stop()
+	select {
+	case <-c.done:
+	case <-t.C:
+	case <-ctx.Done():
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.s
+}
+
+// dirSizeCount finds the count of a directory, starting one unless it is under way or recent
+func (fsys *FS) dirSizeCount(root string) *dirSizeCount {
+	gen := fsys.generation()
+	fsys.zMu.Lock()
+	defer fsys.zMu.Unlock()
+	if c, ok := fsys.dirSizes[root]; ok && c.gen == gen {
+		select {
+		case <-c.done:
+			if time.Since(c.start) < dirSizeMaxAge {
+				return c
+			}
+		default:
+			return c
+		}
+	}
+	if len(fsys.dirSizes) >= maxDirSizes {
+		for k := range fsys.dirSizes {
+			delete(fsys.dirSizes, k)
+			break
+		}
+	}
+	c := &dirSizeCount{s: dirSize{cutShort: true}, done: make(chan struct{}), gen: gen, start: time.Now()}
+	fsys.dirSizes[root] = c
+	go fsys.countDirSize(c, root)
+	return c
+}
+
+func (fsys *FS) countDirSize(c *dirSizeCount, root string) {
+	defer close(c.done)
+	ctx, cancel := context.WithTimeout(context.Background(), dirSizeGiveUp)
+	defer cancel()
+
+	cutShort := false
+	fs.WalkDir(fsys, root, func(name string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			cutShort = true
+			return fs.SkipAll
+		} else if err != nil {
+			return nil
+		} else if name != root && strings.HasSuffix(name, Special) {
+			return fs.SkipDir // the archive was counted as a file
+		} else if macSizes && d.Name() == netatalkDir {
+			return fs.SkipDir // counted below with the files they belong to
+		} else if !d.Type().IsRegular() || macSizes && isSidecar(d) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		var rsrc int64
+		if macSizes {
+			if o, err := fsys.path(name); err == nil {
+				if ad, err := o.sidecarInfo(); err == nil && ad.hasFork {
+					rsrc = ad.forkSize
+				}
+			}
+		}
+		c.mu.Lock()
+		c.s.files++
+		c.s.data += info.Size()
+		c.s.rsrc += rsrc
+		c.mu.Unlock()
+		return nil
+	})
+	c.mu.Lock()
+	c.s.cutShort = cutShort
+	c.mu.Unlock()
+}
@@ -0,0 +1,223 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/elliotnunn/BeHierarchic/internal/scratch"
+	"github.com/elliotnunn/BeHierarchic/internal/webdavfs"
+)
+
+// dropbox is a directory within the sharepoint where the curator may upload new archives.
+// Everything else is read-only. Empty means no uploads.
+var dropbox string
+
+// dropboxRefused answers 401 to a write without the curator's login,
+// and 413 to an upload that says up front that it is larger than the scratch budget,
+// and otherwise leaves the request to the WebDAV handler
+func dropboxRefused(w http.ResponseWriter, r *http.Request) bool {
+	switch r.Method {
+	case "PUT", "MKCOL", "DELETE", "COPY", "MOVE":
+	default:
+		return false
+	}
+	if !isCurator(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="BeHierarchic curators"`)
+		http.Error(w, "curator login required to change the drop-box", http.StatusUnauthorized)
+		return true
+	} else if scratchSpace != nil && r.ContentLength > scratchSpace.Limit() {
+		http.Error(w, "upload larger than the scratch budget", http.StatusRequestEntityTooLarge)
+		return true
+	}
+	return false
+}
+
+// sharepoint is the host directory that the root FS was made from
+var sharepoint string
+
+// CanWrite is true below the drop-box, but not for the drop-box itself,
+// which must never be deleted, moved or replaced
+func (fsys *FS) CanWrite(name string) bool {
+	if dropbox == "" || !fs.ValidPath(name) || !strings.HasPrefix(name, dropbox+"/") {
+		return false
+	}
+	for _, component := range strings.Split(name, "/") {
+		if strings.HasSuffix(component, Special) {
+			return false // inside an archive
+		}
+	}
+	return true
+}
+
+func (fsys *FS) hostPath(name string) (string, error) {
+	if !fsys.CanWrite(name) {
+		return "", fs.ErrPermission
+	}
+	return filepath.Join(sharepoint, filepath.FromSlash(name)), nil
+}
+
+// Put writes an uploaded file under a temporary name (which getArchive knows to ignore),
+// then links it into place so that it is never overwritten or seen half-finished.
+func (fsys *FS) Put(name string, r io.Reader) (err error) {
+	defer func() {
+		if err != nil {
+			err = &fs.PathError{Op: "put", Path: name, Err: err}
+		}
+	}()
+
+	host, err := fsys.hostPath(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(host); err == nil {
+		return fs.ErrExist
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(host), "."+filepath.Base(host)+".*.part")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	var w io.Writer = tmp
+	if scratchSpace != nil { // an upload counts against the scratch budget until it is in place
+		m := scratchSpace.Meter(tmp)
+		defer m.Close()
+		w = m
+	}
+	n, err := io.Copy(w, r)
+	if errors.Is(err, scratch.ErrFull) {
+		tmp.Close()
+		return webdavfs.ErrTooLarge
+	} else if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(tmp.Name(), 0o644)
+	if err != nil {
+		return err
+	}
+	err = os.Link(tmp.Name(), host)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("dropboxPut", "path", name, "size", n)
+	fsys.forget(name)
+	go fsys.probeUpload(name)
+	return nil
+}
+
+func (fsys *FS) Mkdir(name string) error {
+	host, err := fsys.hostPath(name)
+	if err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	err = os.Mkdir(host, 0o755)
+	if err != nil {
+		return err
+	}
+	slog.Info("dropboxMkdir", "path", name)
+	return nil
+}
+
+func (fsys *FS) Remove(name string) error {
+	host, err := fsys.hostPath(name)
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	err = os.Remove(host)
+	if err != nil {
+		return err
+	}
+	slog.Info("dropboxRemove", "path", name)
+	fsys.forget(name)
+	return nil
+}
+
+func (fsys *FS) Rename(oldname, newname string) error {
+	oldhost, err := fsys.hostPath(oldname)
+	if err != nil {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: err}
+	}
+	newhost, err := fsys.hostPath(newname)
+	if err != nil {
+		return &fs.PathError{Op: "rename", Path: newname, Err: err}
+	}
+	if _, err := os.Lstat(newhost); err == nil {
+		return &fs.PathError{Op: "rename", Path: newname, Err: fs.ErrExist}
+	}
+	err = os.Rename(oldhost, newhost)
+	if err != nil {
+		return err
+	}
+	slog.Info("dropboxRename", "from", oldname, "to", newname)
+	fsys.forget(oldname)
+	fsys.forget(newname)
+	go fsys.probeUpload(newname)
+	return nil
+}
+
+// forget discards anything learned about host files that were previously at or below this name,
+// and starts a new generation, so that sessions already inside them keep what they saw (see generation.go)
+func (fsys *FS) forget(name string) {
+	o, err := fsys.path(name)
+	if err != nil || o.fsys != fsys.root {
+		return
+	}
+	fsys.wMu.Lock() // so that no path resolves through a forgotten mount after it is gone
+	fsys.mMu.Lock()
+	for tp := range fsys.mounts {
+		if tp.fsys == fsys.root && tp.name.IsWithin(o.name) {
+			delete(fsys.mounts, tp)
+		}
+	}
+	clear(fsys.resolved) // cheaper than working out which went through this name
+	gen := fsys.gen.Add(1)
+	fsys.mMu.Unlock()
+	fsys.wMu.Unlock()
+	fsys.sMu.Lock()
+	fsys.changed = time.Now()
+	fsys.sMu.Unlock()
+	slog.Info("generation", "gen", gen, "path", name)
+	fsys.iMu.Lock()
+	for p := range fsys.idCache {
+		if p.IsWithin(o.name) {
+			delete(fsys.idCache, p)
+		}
+	}
+	fsys.iMu.Unlock()
+	fsys.eMu.Lock()
+	clear(fsys.dirETags) // they are keyed by mount, not by name, so which are inside is not known cheaply
+	fsys.eMu.Unlock()
+}
+
+// probeUpload does for a new upload what Prefetch did for the files present at startup
+func (fsys *FS) probeUpload(name string) {
+	fs.WalkDir(fsys.root, name, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		o, err := fsys.path(name)
+		if err != nil {
+			return nil
+		}
+		if isar, ar := o.getArchive(true, true); isar {
+			ar.prefetchThisFS(1, nil, false)
+		}
+		return nil
+	})
+}
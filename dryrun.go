@@ -0,0 +1,146 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// With prefetch -dryrun, the sharepoint is walked as by a first prefetch, but without a cache,
+// so nothing is written anywhere. Instead a line is printed for each archive (and with -v, each file) saying
+// which reader claimed it (or why none did) and what a real prefetch would have kept in the cache.
+// This is the place to start when a file is not being recognised as an archive.
+
+type dryRun struct {
+	mu      sync.Mutex
+	w       io.Writer
+	verbose bool // also list the files that are not archives
+	notes   map[thinPath]*dryRunNote
+
+	files, archives, failures int
+	cached                    int64
+}
+
+// dryRunNote gathers what happens to one file between the start of its probe and the end of its mount
+type dryRunNote struct {
+	format   string
+	disabled bool  // by a -format rule
+	err      error // while probing or mounting
+	cached   int64 // header bytes
+	size     bool  // whether a size that was hard to work out would be kept
+}
+
+// note finds or makes the note for o, to be changed while holding the lock
+func (d *dryRun) note(o path, f func(n *dryRunNote)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := d.notes[o.Thin()]
+	if n == nil {
+		n = new(dryRunNote)
+		d.notes[o.Thin()] = n
+	}
+	f(n)
+}
+
+func (o path) traceFormat(format string, disabled bool) {
+	if d := o.container.dryRun; d != nil {
+		d.note(o, func(n *dryRunNote) { n.format, n.disabled = format, disabled })
+	}
+}
+
+func (o path) traceError(err error) {
+	if d := o.container.dryRun; d != nil {
+		d.note(o, func(n *dryRunNote) { n.err = err })
+	}
+}
+
+func (o path) traceCache(bytes int) {
+	if d := o.container.dryRun; d != nil {
+		d.note(o, func(n *dryRunNote) { n.cached += int64(bytes) })
+	}
+}
+
+func (o path) traceSize() {
+	if d := o.container.dryRun; d != nil {
+		d.note(o, func(n *dryRunNote) { n.size = true })
+	}
+}
+
+// traceDone prints the line for a file once prefetch has finished with it (but not with its contents)
+func (o path) traceDone(isArchive bool, t time.Duration) {
+	d := o.container.dryRun
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := d.notes[o.Thin()]
+	delete(d.notes, o.Thin())
+	if n == nil {
+		n = new(dryRunNote)
+	}
+	d.files++
+	d.cached += n.cached
+
+	var verdict string
+	switch {
+	case n.err != nil:
+		d.failures++
+		verdict = fmt.Sprintf("failed %s: %v", cmp.Or(n.format, "probe"), n.err)
+	case n.disabled:
+		verdict = fmt.Sprintf("%s, disabled by -format", n.format)
+	case isArchive:
+		d.archives++
+		verdict = "mount " + n.format
+	case !d.verbose:
+		return
+	case n.format != "": // recognised but could not be opened, without an error to show for it
+		verdict = fmt.Sprintf("failed %s", n.format)
+	default:
+		verdict = "not an archive"
+	}
+
+	var extra []string
+	if n.cached > 0 {
+		extra = append(extra, fmt.Sprintf("cache %s header bytes", thouSep(n.cached)))
+	}
+	if n.size {
+		extra = append(extra, "cache size")
+	}
+	if d.verbose {
+		extra = append(extra, t.Round(time.Millisecond).String())
+	}
+	if len(extra) > 0 {
+		verdict += " (" + strings.Join(extra, ", ") + ")"
+	}
+	fmt.Fprintf(d.w, "%s\t%s\n", o, verdict)
+}
+
+func (d *dryRun) summary() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fmt.Fprintf(d.w, "%s files, %s archives, %s failures, %s header bytes that would be cached\n",
+		thouSep(int64(d.files)), thouSep(int64(d.archives)), thouSep(int64(d.failures)), thouSep(d.cached))
+}
+
+func dryRunCmd(target string, verbose bool) error {
+	s, err := os.Stat(target)
+	if err != nil {
+		return err
+	} else if !s.IsDir() {
+		return fmt.Errorf("%s: not a directory", target)
+	}
+
+	fsys := Wrapper(os.DirFS(target), "") // no cache, so nothing written
+	fsys.dryRun = &dryRun{w: os.Stdout, verbose: verbose, notes: make(map[thinPath]*dryRunNote)}
+	fsys.prefetch(false)
+	fsys.dryRun.summary()
+	return nil
+}
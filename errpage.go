@@ -0,0 +1,98 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// A page that fails should say which archive on the way to it was the problem,
+// so that the user can report something more useful than "404".
+
+type pageError struct {
+	status int
+	title  string
+	chain  []warpStep // the archives on the way to the page, outermost first
+	err    error
+}
+
+type warpStep struct {
+	name string // textual path of the archive file
+	note string // empty if it mounted
+}
+
+// diagnose works out why the page for pathname could not be shown
+func (fsys *FS) diagnose(pathname string, err error) pageError {
+	e := pageError{status: http.StatusNotFound, title: "Not found", err: err}
+
+	warps := strings.Split(pathname, Special+"/")
+	if strings.HasSuffix(pathname, Special) {
+		warps[len(warps)-1] = strings.TrimSuffix(warps[len(warps)-1], Special)
+		warps = append(warps, "")
+	}
+	for i := range warps[:len(warps)-1] {
+		name := strings.Join(warps[:i+1], Special+"/")
+		step := warpStep{name: name}
+		if s, err := fsys.Stat(name); err != nil {
+			step.note = "missing"
+		} else if !s.Mode().IsRegular() {
+			step.note = "not a file"
+		} else if _, err := fsys.Stat(name + Special); err != nil {
+			e.status, e.title = http.StatusUnprocessableEntity, "Archive could not be parsed"
+			step.note = "not an archive that BeHierarchic can read"
+		}
+		e.chain = append(e.chain, step)
+		if step.note != "" {
+			break
+		}
+	}
+
+	if e.status == http.StatusNotFound && !errors.Is(err, fs.ErrNotExist) && (fsys.prefetchStatus().Running || isIncomplete(err)) {
+		e.status, e.title = http.StatusServiceUnavailable, "Temporarily unavailable while indexing"
+	}
+	return e
+}
+
+// failPage replaces a bare http.Error for the pages that users browse
+func failPage(fsys *FS, w http.ResponseWriter, pathname string, err error) {
+	errorPage(fsys, w, pathname, fsys.diagnose(pathname, err))
+}
+
+func errorPage(fsys *FS, w http.ResponseWriter, pathname string, e pageError) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if e.status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "60")
+	}
+	w.WriteHeader(e.status)
+
+	fmt.Fprintf(w, "<!doctype html>\n")
+	fmt.Fprintf(w, "<meta name=\"viewport\" content=\"width=device-width\">\n")
+	fmt.Fprintf(w, "<h1>%s</h1>", htmlReplacer.Replace(e.title))
+	fmt.Fprint(w, "<h2>")
+	breadcrumb(w, pathname)
+	fmt.Fprint(w, "</h2>\n")
+	if len(e.chain) > 0 {
+		fmt.Fprint(w, "<pre>")
+		for _, step := range e.chain {
+			note := step.note
+			if note == "" {
+				note = "ok"
+			}
+			fmt.Fprintf(w, `<a href="/%s">%s</a>  %s`+"\n",
+				urlenc(step.name), htmlReplacer.Replace(step.name), htmlReplacer.Replace(note))
+		}
+		fmt.Fprint(w, "</pre>\n")
+	}
+	if e.err != nil {
+		fmt.Fprintf(w, "<p>Details: <code>%s</code></p>\n", htmlReplacer.Replace(e.err.Error()))
+	}
+	if e.status == http.StatusServiceUnavailable {
+		prefetchFooter(fsys, w)
+	}
+}
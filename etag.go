@@ -0,0 +1,101 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"encoding/binary"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+type dirETag struct {
+	etag    string
+	modtime time.Time
+}
+
+// dirETag derives a weak ETag from the names and modification times of a directory's children,
+// and a Last-Modified time from the newest of them.
+//
+// Directories inside archives cannot change once mounted, so their answer is remembered,
+// letting a revalidating client skip the ReadDir entirely (see cachedDirETag),
+// unless the listing was partial and will grow. A partial listing is tagged by its names alone,
+// because the times of the directories in it are not settled until the archive has been read.
+func (o path) dirETag(list []fs.DirEntry, partial bool, extra ...string) dirETag {
+	var h xxhash.Digest
+	var newest time.Time
+	var tbuf [8]byte
+	for _, de := range list {
+		h.WriteString(de.Name())
+		h.Write([]byte{0})
+		if _, ok := de.(mountpointDirEntry); ok || partial {
+			continue // do not mount the archive just to learn the same mtime as its file
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		mtime := info.ModTime()
+		if mtime.After(newest) {
+			newest = mtime
+		}
+		binary.BigEndian.PutUint64(tbuf[:], uint64(mtime.UnixNano()))
+		h.Write(tbuf[:])
+	}
+	for _, s := range extra {
+		h.WriteString(s)
+		h.Write([]byte{0})
+	}
+	ret := dirETag{
+		etag:    `W/"` + strconv.FormatUint(h.Sum64(), 36) + `"`,
+		modtime: newest,
+	}
+
+	if o.isImmutable() && !partial {
+		o.container.eMu.Lock()
+		if len(o.container.dirETags) >= maxDirETags {
+			for k := range o.container.dirETags {
+				delete(o.container.dirETags, k)
+				break
+			}
+		}
+		o.container.dirETags[o.Thin()] = ret
+		o.container.eMu.Unlock()
+	}
+	return ret
+}
+
+const maxDirETags = 1 << 16 // beyond which an arbitrary one is forgotten
+
+func (o path) cachedDirETag() (dirETag, bool) {
+	if !o.isImmutable() {
+		return dirETag{}, false
+	}
+	o.container.eMu.Lock()
+	defer o.container.eMu.Unlock()
+	e, ok := o.container.dirETags[o.Thin()]
+	return e, ok
+}
+
+// isImmutable is true for paths inside a mounted archive
+func (o path) isImmutable() bool { return o.fsys != o.container.root }
+
+// notModified reports whether a conditional GET can be answered with 304 from the ETag alone
+func (e dirETag) notModified(r *http.Request) bool {
+	inm := r.Header.Get("If-None-Match")
+	if inm == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(inm, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(e.etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
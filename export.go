@@ -0,0 +1,286 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	gopath "path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// exportAPI packages a subtree for transfer into a preservation system, streamed as a tar file.
+//
+//	GET /api/v1/export?root=PATH[&format=bagit|ocfl]
+//
+// The result is a BagIt 1.0 bag (RFC 8493) or an OCFL 1.1 object with a single version,
+// both with SHA-256 manifests. Digests already in the cache are not recomputed,
+// and the others are computed as the file streams past, then cached along with a SHA-1 as sha256 would.
+// Archives nested within the subtree are exported as files, not descended into.
+func exportAPI(fsys *FS, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	root := strings.Trim(r.URL.Query().Get("root"), "/")
+	if root == "" {
+		root = "."
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = exportFormats[0]
+	} else if !slices.Contains(exportFormats, format) {
+		http.Error(w, "format must be one of "+strings.Join(exportFormats, ", "), http.StatusBadRequest)
+		return
+	}
+	if stat, err := fs.Stat(fsys, root); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if !stat.IsDir() {
+		http.Error(w, "can only export a directory", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.tar"`, exportName(root), format))
+	if r.Method == "HEAD" {
+		return
+	}
+
+	err := export(r.Context(), fsys, root, format, w)
+	if err != nil && r.Context().Err() == nil {
+		slog.Error("exportErr", "root", root, "format", format, "err", err)
+	}
+}
+
+var exportFormats = []string{"bagit", "ocfl"}
+
+// export writes a directory as a tar file in one of the exportFormats
+func export(ctx context.Context, fsys *FS, root, format string, w io.Writer) error {
+	ex := exporter{fsys: fsys, tw: tar.NewWriter(w), prefix: exportName(root) + "/", now: time.Now()}
+	var err error
+	if format == "bagit" {
+		err = ex.bagit(ctx, root)
+	} else {
+		err = ex.ocfl(ctx, root)
+	}
+	if err != nil {
+		return err
+	}
+	return ex.tw.Close()
+}
+
+// exportName names the download of a directory, without an extension
+func exportName(root string) string {
+	name := strings.TrimSuffix(gopath.Base(root), Special)
+	if name == "." {
+		name = "BeHierarchic"
+	}
+	return strings.NewReplacer(`"`, "", `\`, "").Replace(name)
+}
+
+type exporter struct {
+	fsys   *FS
+	tw     *tar.Writer
+	prefix string
+	now    time.Time
+}
+
+type exported struct {
+	rel    string
+	digest string
+}
+
+func (ex *exporter) bagit(ctx context.Context, root string) error {
+	const declaration = "BagIt-Version: 1.0\nTag-File-Character-Encoding: UTF-8\n"
+	err := ex.text("bagit.txt", declaration)
+	if err != nil {
+		return err
+	}
+	files, oxum, err := ex.payload(ctx, root, "data/")
+	if err != nil {
+		return err
+	}
+
+	var manifest strings.Builder
+	for _, f := range files {
+		fmt.Fprintf(&manifest, "%s  data/%s\n", f.digest, bagitEscape(f.rel))
+	}
+	info := fmt.Sprintf("Bagging-Date: %s\nPayload-Oxum: %s\nSource-Organization: BeHierarchic\nExternal-Identifier: %s\n",
+		ex.now.Format(time.DateOnly), oxum, bagitEscape(root))
+
+	var tagmanifest strings.Builder
+	fmt.Fprintf(&tagmanifest, "%x  bagit.txt\n", sha256.Sum256([]byte(declaration)))
+	for _, tag := range [...][2]string{
+		{"bag-info.txt", info},
+		{"manifest-sha256.txt", manifest.String()},
+	} {
+		if err := ex.text(tag[0], tag[1]); err != nil {
+			return err
+		}
+		fmt.Fprintf(&tagmanifest, "%x  %s\n", sha256.Sum256([]byte(tag[1])), tag[0])
+	}
+	return ex.text("tagmanifest-sha256.txt", tagmanifest.String())
+}
+
+func (ex *exporter) ocfl(ctx context.Context, root string) error {
+	const spec = "ocfl_object_1.1"
+	err := ex.text("0="+spec, spec+"\n")
+	if err != nil {
+		return err
+	}
+	files, _, err := ex.payload(ctx, root, "v1/content/")
+	if err != nil {
+		return err
+	}
+
+	manifest := make(map[string][]string)
+	state := make(map[string][]string)
+	for _, f := range files {
+		manifest[f.digest] = append(manifest[f.digest], "v1/content/"+f.rel)
+		state[f.digest] = append(state[f.digest], f.rel)
+	}
+	type version struct {
+		Created string              `json:"created"`
+		State   map[string][]string `json:"state"`
+		Message string              `json:"message"`
+	}
+	inventory, err := json.MarshalIndent(struct {
+		ID              string              `json:"id"`
+		Type            string              `json:"type"`
+		DigestAlgorithm string              `json:"digestAlgorithm"`
+		Head            string              `json:"head"`
+		Manifest        map[string][]string `json:"manifest"`
+		Versions        map[string]version  `json:"versions"`
+	}{
+		ID:              "behierarchic:" + root,
+		Type:            "https://ocfl.io/1.1/spec/#inventory",
+		DigestAlgorithm: "sha256",
+		Head:            "v1",
+		Manifest:        manifest,
+		Versions: map[string]version{"v1": {
+			Created: ex.now.UTC().Format(time.RFC3339),
+			State:   state,
+			Message: "Exported by BeHierarchic",
+		}},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	sidecar := fmt.Sprintf("%x inventory.json\n", sha256.Sum256(inventory))
+	for _, dir := range [...]string{"", "v1/"} {
+		if err := ex.text(dir+"inventory.json", string(inventory)); err != nil {
+			return err
+		}
+		if err := ex.text(dir+"inventory.json.sha256", sidecar); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// payload writes every regular file under root into the tar, returning each digest
+// and the BagIt Payload-Oxum ("octets.streamcount")
+func (ex *exporter) payload(ctx context.Context, root, under string) (files []exported, oxum string, err error) {
+	var octets int64
+	err = fs.WalkDir(ex.fsys, root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if err := ctx.Err(); err != nil {
+			return err
+		} else if name != root && strings.HasSuffix(name, Special) {
+			return fs.SkipDir // export nested archives as files
+		} else if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel := name
+		if root != "." {
+			rel = name[len(root)+1:]
+		}
+		o, err := ex.fsys.path(name)
+		if err != nil {
+			return err
+		}
+		digest, size, err := ex.file(o, under+rel)
+		if err != nil {
+			return err
+		}
+		files = append(files, exported{rel, digest})
+		octets += size
+		return nil
+	})
+	return files, fmt.Sprintf("%d.%d", octets, len(files)), err
+}
+
+func (ex *exporter) file(o path, name string) (string, int64, error) {
+	stat, err := o.cookedStat()
+	if err != nil {
+		return "", 0, err
+	}
+	f, err := o.cookedOpen()
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	err = ex.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     ex.prefix + name,
+		Size:     stat.Size(),
+		Mode:     0o644,
+		ModTime:  stat.ModTime(),
+	})
+	if err != nil {
+		return "", 0, err
+	}
+
+	mtime := digestMtime(stat.ModTime())
+	if d, ok := o.getCacheDigest(mtime); ok {
+		if _, ok := o.getCacheSHA1(mtime); ok {
+			_, err = io.Copy(ex.tw, f)
+			return hex.EncodeToString(d[:]), stat.Size(), err
+		}
+	}
+
+	h, h1 := sha256.New(), sha1.New() // as sha256Paced does
+	_, err = io.Copy(io.MultiWriter(ex.tw, h, h1), f)
+	if err != nil {
+		return "", 0, err
+	}
+	var d digest
+	h.Sum(d[:0])
+	o.setCacheDigest(mtime, d)
+	o.setCacheSHA1(mtime, h1.Sum(nil))
+	return hex.EncodeToString(d[:]), stat.Size(), nil
+}
+
+func (ex *exporter) text(name, content string) error {
+	err := ex.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     ex.prefix + name,
+		Size:     int64(len(content)),
+		Mode:     0o644,
+		ModTime:  ex.now,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(ex.tw, content)
+	return err
+}
+
+// bagitEscape percent-encodes the characters that RFC 8493 forbids in a manifest filepath
+var bagitEscape = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace
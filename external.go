@@ -0,0 +1,360 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	gopath "path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
+)
+
+// Archives that no built-in reader recognises can be handed to another program, such as unar,
+// so that a gap in the formats does not stop anyone browsing while a reader is written.
+// Turn a built-in reader off with -format to send its archives to the program instead.
+//
+// The archive is {in} in the command, or else its standard input.
+// Whatever the command leaves in the directory {out} becomes the contents of the archive,
+// or without {out}, its standard output is the single file inside,
+// which is run again whenever it is read, like any other compressed stream.
+//
+// An extraction is given externalTimeout, and before it starts, externalExpansion times the size of the archive
+// is reserved from the scratch budget. A command that writes more than that is stopped, and the rest is given back.
+
+const (
+	externalTimeout   = 10 * time.Minute
+	externalExpansion = 4
+	externalMinimum   = 16 << 20    // reserved for even a tiny archive
+	externalPoll      = time.Second // how often the size of the output is checked
+)
+
+var errExternalTooLarge = errors.New("output larger than reserved from the scratch budget")
+
+// externalRule sends archives with matching names to a command
+type externalRule struct {
+	glob string // matched against the base name, ignoring case
+	argv []string
+}
+
+var externalRules []externalRule
+
+// setExternal parses an -external flag of the form GLOB=COMMAND
+func setExternal(s string) error {
+	glob, command, _ := strings.Cut(s, "=")
+	argv := strings.Fields(command)
+	if glob == "" || len(argv) == 0 {
+		return fmt.Errorf("%s: expected GLOB=COMMAND", s)
+	}
+	glob = strings.ToLower(glob)
+	if _, err := gopath.Match(glob, ""); err != nil {
+		return fmt.Errorf("%s: %w", s, err)
+	}
+	if _, err := exec.LookPath(argv[0]); err != nil {
+		return err
+	}
+	externalRules = append(externalRules, externalRule{glob, argv})
+	return nil
+}
+
+func (r externalRule) extracts() bool {
+	return slices.ContainsFunc(r.argv, func(arg string) bool { return strings.Contains(arg, "{out}") })
+}
+
+func (r externalRule) wantsName() bool {
+	return slices.ContainsFunc(r.argv, func(arg string) bool { return strings.Contains(arg, "{in}") })
+}
+
+// probeExternal is the last resort of probeArchive
+func (o path) probeExternal(info fs.FileInfo) (fsysGenerator, error) {
+	base := strings.ToLower(o.name.Base())
+	i := slices.IndexFunc(externalRules, func(r externalRule) bool {
+		ok, _ := gopath.Match(r.glob, base)
+		return ok
+	})
+	if i < 0 {
+		return nil, nil
+	}
+	rule := externalRules[i]
+	return o.allowFormat("external", info, func() (fs.FS, error) {
+		fsys := fskeleton.New()
+		if rule.extracts() {
+			go o.extractExternal(fsys, rule, info.Size())
+			return fsys, nil
+		}
+		innerName := strings.TrimSuffix(o.name.Base(), gopath.Ext(o.name.Base()))
+		if innerName == "" {
+			innerName = o.name.Base()
+		}
+		opener := func() (io.ReadCloser, error) { return o.streamExternal(rule) }
+		fsys.CreateReadCloser(innerName, 0, opener, fskeleton.SizeUnknown, 0, info.ModTime())
+		fsys.NoMore()
+		return fsys, nil
+	})
+}
+
+// externalCommand prepares the command with its input, which done cleans up after
+func (o path) externalCommand(ctx context.Context, rule externalRule, out string) (cmd *exec.Cmd, done func(), err error) {
+	var in string
+	var stdin io.ReadCloser
+	done = func() {}
+	if rule.wantsName() {
+		in, done, err = o.externalInput()
+	} else {
+		stdin, err = o.cookedOpen()
+		done = func() { stdin.Close() }
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	argv := make([]string, len(rule.argv))
+	for i, arg := range rule.argv {
+		argv[i] = strings.NewReplacer("{in}", in, "{out}", out).Replace(arg)
+	}
+	cmd = exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Dir = out
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	return cmd, done, nil
+}
+
+// externalInput names the archive as a file on disk, copying it to scratch space if it is not one already
+func (o path) externalInput() (name string, done func(), err error) {
+	if o.fsys == o.container.root && sharepoint != "" {
+		return filepath.Join(sharepoint, filepath.FromSlash(o.name.String())), func() {}, nil
+	}
+	f, err := o.cookedOpen()
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return "", nil, err
+	}
+	d, err := scratchSpace.MkdirTemp()
+	if err != nil {
+		return "", nil, err
+	}
+	err = d.Reserve(max(stat.Size(), 0))
+	if err == nil {
+		name = filepath.Join(d.Path(), o.name.Base()) // keeping the extension, which some programs go by
+		var w *os.File
+		w, err = os.Create(name)
+		if err == nil {
+			_, err = io.Copy(w, f)
+			if cerr := w.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	if err != nil {
+		d.Close()
+		return "", nil, err
+	}
+	return name, func() { d.Close() }, nil
+}
+
+// extractExternal runs the command once, to fill a scratch directory that lasts as long as the server
+func (o path) extractExternal(fsys *fskeleton.FS, rule externalRule, archiveSize int64) {
+	defer fsys.NoMore()
+	out, err := scratchSpace.MkdirTemp()
+	if err != nil {
+		slog.Warn("externalFail", "path", o, "err", err)
+		return
+	}
+	budget := max(archiveSize*externalExpansion, externalMinimum)
+	if err := out.Reserve(budget); err != nil {
+		slog.Warn("externalFail", "path", o, "cmd", rule.argv[0], "err", err)
+		out.Close()
+		return
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	ctx, stop := context.WithTimeout(ctx, externalTimeout)
+	defer stop()
+	var stderr tailBuffer
+	cmd, done, err := o.externalCommand(ctx, rule, out.Path())
+	if err == nil {
+		cmd.Stderr = &stderr
+		if err = cmd.Start(); err != nil {
+			done()
+		}
+	}
+	if err == nil {
+		go func() {
+			t := time.NewTicker(externalPoll)
+			defer t.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-t.C:
+				}
+				if n, _ := diskUsage(out.Path()); n > budget {
+					cancel(errExternalTooLarge)
+				}
+			}
+		}()
+		err = cmd.Wait()
+		done()
+		if cause := context.Cause(ctx); cause != nil {
+			err = cause // rather than "signal: killed"
+		}
+		stop()
+	}
+
+	var size int64
+	if err == nil {
+		size, err = diskUsage(out.Path())
+	}
+	if err == nil && size > budget {
+		err = errExternalTooLarge
+	}
+	if err == nil {
+		out.Release(budget - size)
+	}
+	if err != nil {
+		slog.Warn("externalFail", "path", o, "cmd", rule.argv[0], "err", err, "stderr", stderr.String())
+		out.Close()
+		return
+	}
+
+	var id int64
+	filepath.WalkDir(out.Path(), func(p string, d fs.DirEntry, err error) error {
+		rel, _ := filepath.Rel(out.Path(), p)
+		name := filepath.ToSlash(rel)
+		if err != nil || name == "." || !fs.ValidPath(name) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		id++
+		switch {
+		case d.IsDir():
+			fsys.Mkdir(name, id, info.Mode().Perm(), info.ModTime())
+		case d.Type()&fs.ModeSymlink != 0:
+			if target, err := os.Readlink(p); err == nil {
+				fsys.Symlink(name, id, filepath.ToSlash(target), info.Mode().Perm(), info.ModTime())
+			}
+		case d.Type().IsRegular():
+			opener := func() (io.ReadCloser, error) { return os.Open(p) }
+			fsys.CreateReadCloser(name, id, opener, info.Size(), info.Mode().Perm(), info.ModTime())
+		}
+		return nil
+	})
+}
+
+// diskUsage totals the regular files in a directory
+func diskUsage(dir string) (size int64, err error) {
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			info, err := d.Info()
+			if err == nil {
+				size += info.Size()
+			}
+		}
+		return err
+	})
+	return size, err
+}
+
+// streamExternal runs the command afresh for each reader of its standard output
+func (o path) streamExternal(rule externalRule) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd, done, err := o.externalCommand(ctx, rule, "")
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	r := &externalReader{cmd: cmd, cancel: cancel, done: done}
+	cmd.Stderr = &r.stderr
+	r.stdout, err = cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		cancel()
+		done()
+		return nil, err
+	}
+	return r, nil
+}
+
+type externalReader struct {
+	cmd    *exec.Cmd
+	stdout io.Reader
+	stderr tailBuffer
+	cancel context.CancelFunc
+	done   func()
+	once   sync.Once
+	err    error
+}
+
+func (r *externalReader) Read(p []byte) (int, error) {
+	n, err := r.stdout.Read(p)
+	if err == io.EOF {
+		if werr := r.wait(); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (r *externalReader) Close() error {
+	r.cancel()
+	r.wait()
+	return nil
+}
+
+// wait reaps the command, and an unsuccessful exit becomes an error
+func (r *externalReader) wait() error {
+	r.once.Do(func() {
+		err := r.cmd.Wait()
+		r.done()
+		if err != nil {
+			r.err = fmt.Errorf("%s: %w: %s", r.cmd.Args[0], err, r.stderr.String())
+		}
+	})
+	return r.err
+}
+
+// tailBuffer keeps the end of a command's error messages
+type tailBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	const keep = 1024
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > keep {
+		b.buf = b.buf[len(b.buf)-keep:]
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strings.TrimSpace(string(b.buf))
+}
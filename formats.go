@@ -0,0 +1,129 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/elliotnunn/BeHierarchic/internal/cpm"
+)
+
+// Some collections hold containers that are better served as opaque downloads,
+// such as enormous zips or disk images nested inside disk images.
+
+// formatNames are the names that probeArchive gives to what it finds
+var formatNames = []string{
+	"appledouble", "applesingle", "apm", "arc", "arj", "binhex", "bzip2", "cpm", "cue", "diskcopy", "dos33", "external",
+	"gzip", "hfs", "imd", "lha", "mfs", "newton", "nufx", "palm", "prodos", "rar", "rsrc", "sea", "sit", "tar", "teledisk",
+	"wim", "xz", "zip", "zoo",
+}
+
+// formatRule disables a format, or only above a size, or only inside another format
+type formatRule struct {
+	outer   string // empty to match anywhere
+	format  string
+	maxSize int64 // negative to disable entirely
+}
+
+var formatRules []formatRule
+
+// setFormatRule parses a -format flag of the form [OUTER/]FORMAT=off or [OUTER/]FORMAT<SIZE
+func setFormatRule(s string) error {
+	var rule formatRule
+	spec, limit, isSize := strings.Cut(s, "<")
+	if !isSize {
+		var off string
+		var ok bool
+		spec, off, ok = strings.Cut(s, "=")
+		if !ok || off != "off" {
+			return fmt.Errorf("%s: expected FORMAT=off or FORMAT<SIZE", s)
+		}
+		rule.maxSize = -1
+	} else {
+		n, err := parseByteSize(limit)
+		if err != nil {
+			return fmt.Errorf("%s: %w", s, err)
+		}
+		rule.maxSize = n
+	}
+	rule.outer, rule.format, _ = strings.Cut(spec, "/")
+	if rule.format == "" {
+		rule.outer, rule.format = "", rule.outer
+	}
+	for _, f := range []string{rule.outer, rule.format} {
+		if f != "" && !slices.Contains(formatNames, f) {
+			return fmt.Errorf("%s: unknown format, expected one of %s", f, strings.Join(formatNames, ", "))
+		}
+	}
+	formatRules = append(formatRules, rule)
+	return nil
+}
+
+// parseByteSize accepts a number of bytes with an optional K, M, G or T suffix (powers of 1024)
+func parseByteSize(s string) (int64, error) {
+	digits, shift := strings.TrimSuffix(strings.ToUpper(s), "B"), 0
+	if digits != "" {
+		if i := strings.IndexByte("KMGT", digits[len(digits)-1]); i >= 0 {
+			digits, shift = digits[:len(digits)-1], 10*(i+1)
+		}
+	}
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || n < 0 || n > 1<<(63-shift)-1 {
+		return 0, fmt.Errorf("%q: not a size", s)
+	}
+	return n << shift, nil
+}
+
+// allowFormat applies the -format rules to an archive that probeArchive has recognised,
+// and remembers the format of the mounted file system so that the rules can match nested archives
+func (o path) allowFormat(format string, info fs.FileInfo, gen fsysGenerator) (fsysGenerator, error) {
+	o.container.rMu.RLock()
+	outer := o.container.formats[o.fsys]
+	o.container.rMu.RUnlock()
+
+	size := info.Size()
+	for _, rule := range formatRules {
+		if rule.format != format || rule.outer != "" && rule.outer != outer {
+			continue
+		}
+		if rule.maxSize >= 0 && size < 0 { // e.g. inside a gzip, so only ask if there is a rule
+			stat, err := o.cookedStat()
+			if err != nil {
+				return nil, err
+			}
+			size = stat.Size()
+		}
+		if rule.maxSize < 0 || size > rule.maxSize {
+			slog.Debug("formatDisabled", "path", o, "format", format, "size", size)
+			o.traceFormat(format, true)
+			return nil, nil
+		}
+	}
+	o.traceFormat(format, false)
+
+	return func() (fs.FS, error) {
+		fsys, err := gen()
+		if fsys != nil {
+			o.container.rMu.Lock()
+			o.container.formats[fsys] = format
+			o.container.rMu.Unlock()
+		}
+		return fsys, err
+	}, nil
+}
+
+// setCPMFormat parses a -cpmformat flag, which is tried before the built-in formats of the same size
+func setCPMFormat(s string) error {
+	f, err := cpm.ParseFormat(s)
+	if err != nil {
+		return err
+	}
+	cpm.Formats = slices.Insert(cpm.Formats, 0, f)
+	return nil
+}
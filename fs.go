@@ -9,10 +9,13 @@ import (
 	"log/slog"
 	gopath "path"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/cockroachdb/pebble/v2"
 	"github.com/elliotnunn/BeHierarchic/internal/fileid"
 	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
+	"github.com/elliotnunn/BeHierarchic/internal/guard"
 	"github.com/elliotnunn/BeHierarchic/internal/internpath"
 )
 
@@ -24,13 +27,51 @@ type FS struct {
 
 	rMu     sync.RWMutex
 	reverse map[fs.FS]thinPath
+	formats map[fs.FS]string // see formats.go
+	hidden  map[fs.FS]bool   // see collapse.go
 
-	db *pebble.DB
+	wMu      sync.RWMutex
+	resolved map[string]resolved // see path.go
+
+	gen      atomic.Uint64 // see generation.go
+	sMu      sync.Mutex
+	sessions map[string]*session
+	changed  time.Time // when gen last went up
+
+	db    atomic.Pointer[pebble.DB] // nil until the cache is open, see cache.go
+	cMu   sync.Mutex
+	dbErr error
+	nMu   sync.Mutex              // inode allocation
+	bMu   [lockStripes]sync.Mutex // block reference counts, by block hash, see dedup.go
+	xMu   [lockStripes]sync.Mutex // writers of a file's extents, by file key
+
+	fMu     sync.Mutex
+	flights map[flightKey]*flight
+
+	tMu     sync.Mutex
+	extents map[thinPath]bool // see tiers.go
+
+	aMu      sync.Mutex
+	accessed map[string]accessNote // waiting to be written, see atime.go
+
+	pMu    sync.RWMutex
+	pins   map[string][]byte // see pin.go
+	pinDir string
 
 	iMu     sync.RWMutex
 	idCache map[internpath.Path]fileid.ID
 
-	scoreGood, scoreBad int64
+	eMu      sync.Mutex
+	dirETags map[thinPath]dirETag
+
+	zMu      sync.Mutex
+	dirSizes map[string]*dirSizeCount // see dirsize.go
+
+	scoreGood, scoreBad, scoreCorrupt int64
+
+	progress prefetchProgress
+	sizeQ    sizeQueue
+	dryRun   *dryRun // see dryrun.go
 
 	root fs.FS
 }
@@ -40,6 +81,7 @@ type FS struct {
 // if non-nil pointer, meaning depends on data as below...
 type mount struct {
 	lock sync.Mutex
+	gen  uint64 // see generation.go
 	data any
 	// nil          = not sure yet (temporary state)
 	// func()       = archive creator-function
@@ -54,10 +96,18 @@ func Wrapper(fsys fs.FS, cachePath string) *FS {
 	const blockShift = 12 // 4 kb -- must match the AppleDouble resourcefork padding!
 
 	fsys2 := &FS{
-		root:    fsys,
-		mounts:  make(map[thinPath]*mount),
-		reverse: make(map[fs.FS]thinPath),
-		idCache: make(map[internpath.Path]fileid.ID),
+		root:     fsys,
+		mounts:   make(map[thinPath]*mount),
+		reverse:  make(map[fs.FS]thinPath),
+		formats:  make(map[fs.FS]string),
+		hidden:   make(map[fs.FS]bool),
+		resolved: make(map[string]resolved),
+		sessions: make(map[string]*session),
+		idCache:  make(map[internpath.Path]fileid.ID),
+		dirETags: make(map[thinPath]dirETag),
+		flights:  make(map[flightKey]*flight),
+		extents:  make(map[thinPath]bool),
+		dirSizes: make(map[string]*dirSizeCount),
 	}
 	fsys2.setupDB(cachePath)
 	return fsys2
@@ -120,7 +170,7 @@ lockloop:
 			continue
 		case !ok && i == 1:
 			// Unknown file, create a blank mount struct
-			b = new(mount)
+			b = &mount{gen: o.container.generation()}
 			o.container.mounts[o.Thin()] = b
 			mu.unlock()
 			break lockloop
@@ -141,7 +191,7 @@ lockloop:
 again:
 	switch t := b.data.(type) {
 	default: // not yet decided
-		gen, err := o.probeArchive()
+		gen, err := guard.Call("probe", o.probeArchive)
 		if errors.Is(err, fs.ErrNotExist) {
 			o.container.mMu.Lock()
 			delete(o.container.mounts, o.Thin())
@@ -149,6 +199,7 @@ again:
 			goto notEvenAFile
 		} else if err != nil {
 			slog.Warn("archiveProbeError", "path", o, "err", err)
+			o.traceError(err)
 		}
 		if err != nil || gen == nil {
 			goto notAnArchive
@@ -162,9 +213,10 @@ again:
 			return true, path{}
 		}
 
-		fsys2, err := t()
+		fsys2, err := guard.Call("mount", t)
 		if err != nil {
 			slog.Warn("archiveInstantiateError", "path", o, "err", err)
+			o.traceError(err)
 		}
 		if err != nil || fsys2 == nil {
 			goto notAnArchive
@@ -173,7 +225,9 @@ again:
 		o.container.rMu.Lock()
 		o.container.reverse[fsys2] = o.Thin()
 		o.container.rMu.Unlock()
-		b.data = fsys2
+		o.setPatience(fsys2)
+		o.setZone(fsys2)
+		b.data = o.collapse(fsys2)
 		goto again
 	}
 
@@ -0,0 +1,151 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// When a host file changes under a client that is browsing inside it, the client should not
+// see half of one version and half of the other. Each forget starts a new generation,
+// and each mount remembers the generation it was probed in.
+// A session (a client address and User-Agent, because WebDAV clients keep no cookies)
+// remembers the archives it has been into, and goes on resolving paths through them until it goes idle,
+// while new sessions see the re-probed archives.
+// But a file's contents still come from the host, so the old listings cannot be used once their host file
+// has changed: a session that tries gets errStaleSession (a 404) and starts afresh, rather than old names with new bytes.
+
+const (
+	sessionIdle     = time.Minute      // after which a session starts afresh
+	sessionMaxStale = 15 * time.Minute // after a change, for a client that never goes idle
+	maxSessions     = 1024
+	maxSessionPaths = 256
+)
+
+type session struct {
+	mu       sync.Mutex
+	gen      uint64 // when the session began
+	last     time.Time
+	resolved map[string]resolved
+}
+
+// generation counts the times that host files have been forgotten
+func (fsys *FS) generation() uint64 { return fsys.gen.Load() }
+
+// sessionFor finds or starts the session that a request belongs to
+func (fsys *FS) sessionFor(r *http.Request) *session {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	key := host + "\x00" + r.UserAgent()
+	now := time.Now()
+	gen := fsys.generation()
+
+	fsys.sMu.Lock()
+	defer fsys.sMu.Unlock()
+	s, ok := fsys.sessions[key]
+	if ok {
+		s.mu.Lock()
+		idle := now.Sub(s.last) > sessionIdle
+		stale := s.gen < gen && now.Sub(fsys.changed) > sessionMaxStale
+		s.last = now
+		s.mu.Unlock()
+		if !idle && !stale {
+			return s
+		}
+	}
+	if len(fsys.sessions) >= maxSessions {
+		for k, old := range fsys.sessions {
+			old.mu.Lock()
+			if now.Sub(old.last) > sessionIdle {
+				delete(fsys.sessions, k)
+			}
+			old.mu.Unlock()
+		}
+		if len(fsys.sessions) >= maxSessions {
+			return nil // too many clients to keep them all consistent
+		}
+	}
+	s = &session{gen: gen, last: now, resolved: make(map[string]resolved)}
+	fsys.sessions[key] = s
+	return s
+}
+
+var errStaleSession = fmt.Errorf("archive changed on the host since it was opened, so reload: %w", fs.ErrNotExist)
+
+// reset starts a session afresh, forgetting the archives it has been into
+func (s *session) reset(gen uint64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gen = gen
+	clear(s.resolved)
+}
+
+// lookup finds the longest prefix of the archives in a path that the session has already been through
+func (s *session) lookup(warps []string) (int, resolved, bool) {
+	if s == nil {
+		return 0, resolved{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(warps) - 1; i > 0; i-- {
+		if r, ok := s.resolved[strings.Join(warps[:i], Special+"/")]; ok {
+			return i, r, true
+		}
+	}
+	return 0, resolved{}, false
+}
+
+func (s *session) remember(key string, r resolved) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.resolved) < maxSessionPaths {
+		s.resolved[key] = r
+	}
+}
+
+// A view is the FS as seen by one session
+type view struct {
+	*FS
+	s *session
+}
+
+// viewFor gives the FS as seen by the session that a request belongs to
+func (fsys *FS) viewFor(r *http.Request) view {
+	return view{fsys, fsys.sessionFor(r)}
+}
+
+func (v view) path(name string) (path, error)             { return v.FS.pathIn(v.s, name) }
+func (v view) Open(name string) (fs.File, error)          { return v.FS.open(v.s, name) }
+func (v view) Stat(name string) (fs.FileInfo, error)      { return v.FS.stat(v.s, name) }
+func (v view) ReadDir(name string) ([]fs.DirEntry, error) { return v.FS.readDir(v.s, name) }
+
+// mountGeneration gives the generation in which an archive was probed
+func (o path) mountGeneration() (uint64, bool) {
+	o.container.mMu.RLock()
+	b := o.container.mounts[o.Thin()]
+	o.container.mMu.RUnlock()
+	if b == nil {
+		return 0, false
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if _, ok := b.data.(fs.FS); !ok {
+		return 0, false
+	}
+	return b.gen, true
+}
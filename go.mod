@@ -7,8 +7,10 @@ require (
 	github.com/cespare/xxhash/v2 v2.3.0
 	github.com/cockroachdb/pebble/v2 v2.1.4
 	github.com/dgryski/go-tinylfu v0.1.0
+	github.com/klauspost/compress v1.18.3
 	github.com/therootcompany/xz v1.0.1
 	golang.org/x/sys v0.40.0
+	golang.org/x/text v0.33.0
 )
 
 require (
@@ -25,7 +27,6 @@ require (
 	github.com/getsentry/sentry-go v0.41.0 // indirect
 	github.com/gogo/protobuf v1.3.2 // indirect
 	github.com/golang/snappy v1.0.0 // indirect
-	github.com/klauspost/compress v1.18.3 // indirect
 	github.com/kr/pretty v0.3.1 // indirect
 	github.com/kr/text v0.2.0 // indirect
 	github.com/minio/minlz v1.0.1 // indirect
@@ -38,6 +39,5 @@ require (
 	github.com/rogpeppe/go-internal v1.14.1 // indirect
 	go.yaml.in/yaml/v2 v2.4.3 // indirect
 	golang.org/x/exp v0.0.0-20260112195511-716be5621a96 // indirect
-	golang.org/x/text v0.33.0 // indirect
 	google.golang.org/protobuf v1.36.11 // indirect
 )
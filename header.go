@@ -0,0 +1,49 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"io/fs"
+	"log/slog"
+	gopath "path"
+	"slices"
+
+	"github.com/elliotnunn/BeHierarchic/internal/internpath"
+)
+
+// A curator can annotate a directory of the sharepoint (with its provenance or licensing, say)
+// by putting an HTML fragment in it, which is shown above the listing instead of in it
+const (
+	dirHeaderName = ".behierarchic-header.html"
+	maxDirHeader  = 64 << 10
+)
+
+// dirHeader returns the fragment for a directory of the sharepoint itself,
+// but not one within an archive or the drop-box, whose files were put there by somebody else
+func (o path) dirHeader() []byte {
+	if o.fsys != o.container.root {
+		return nil
+	} else if dropbox != "" && o.name.IsWithin(internpath.Make(dropbox)) {
+		return nil
+	}
+	name := gopath.Join(o.name.String(), dirHeaderName)
+	stat, err := fs.Stat(o.fsys, name)
+	if err != nil || !stat.Mode().IsRegular() {
+		return nil
+	} else if stat.Size() > maxDirHeader {
+		slog.Warn("dirHeaderTooBig", "path", name, "size", stat.Size())
+		return nil
+	}
+	data, err := fs.ReadFile(o.fsys, name)
+	if err != nil {
+		slog.Warn("dirHeaderFail", "path", name, "err", err)
+		return nil
+	}
+	return data
+}
+
+// withoutDirHeader drops the fragment from a listing, after it has been counted in the ETag
+func withoutDirHeader(list []fs.DirEntry) []fs.DirEntry {
+	return slices.DeleteFunc(slices.Clone(list), func(de fs.DirEntry) bool { return de.Name() == dirHeaderName })
+}
@@ -0,0 +1,253 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// infoPage gathers what is known about one file or directory at "PATH/.info"
+func infoPage(fsys *FS, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	pathname := strings.Trim(strings.TrimSuffix(r.URL.Path, "/.info"), "/")
+	if pathname == "" {
+		pathname = "."
+	}
+	stat, err := fsys.Stat(pathname)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	o, err := fsys.path(pathname)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	page := new(bytes.Buffer)
+	fmt.Fprintf(page, "<!doctype html>\n")
+	fmt.Fprintf(page, "<meta name=\"viewport\" content=\"width=device-width\">\n")
+	fmt.Fprint(page, "<h1>BeHierarchic Info</h1>")
+	fmt.Fprint(page, "<h2>")
+	breadcrumb(page, pathname)
+	fmt.Fprint(page, "</h2>")
+	fmt.Fprint(page, "<table>\n")
+	row := func(k, format string, args ...any) {
+		fmt.Fprintf(page, "<tr><th align=left>%s<td>%s\n", k, fmt.Sprintf(format, args...))
+	}
+
+	row("Name", "%s", htmlReplacer.Replace(stat.Name()))
+	if stat.IsDir() {
+		row("Kind", "directory")
+		s := fsys.dirSize(r.Context(), pathname)
+		atLeast := ""
+		if s.cutShort {
+			atLeast = "at least "
+		}
+		row("Contents", "%s%s files, %s bytes", atLeast, thouSep(s.files), thouSep(s.data+s.rsrc))
+		if s.rsrc > 0 {
+			row("Data forks", "%s bytes", thouSep(s.data))
+			row("Resource forks", "%s bytes", thouSep(s.rsrc))
+		}
+	} else {
+		row("Kind", "%s", stat.Mode().Type().String())
+		row("Data fork", "%d bytes", stat.Size())
+	}
+	row("Modified", "%s", stat.ModTime().UTC().Format(time.RFC3339))
+	if raw, err := o.rawStat(); err == nil {
+		if wc, ok := raw.(wallClocker); ok && wc.WallClock() {
+			row("Time zone", "%s, as the archive recorded the time without one", htmlReplacer.Replace(raw.ModTime().Location().String()))
+		}
+	}
+	if i, ok := stat.(inoder); ok {
+		row("Inode", "%d", i.Inode())
+	}
+	if gen, ok := o.mountGeneration(); ok {
+		row("Indexed", "in generation %d of %d", gen, fsys.generation())
+	}
+
+	if ad, err := o.sidecarInfo(); err == nil {
+		if ad.hasFork {
+			row("Resource fork", "%d bytes", ad.forkSize)
+		}
+		if ad.hasFinderInfo && !stat.IsDir() {
+			row("Type/creator", "%s/%s", htmlReplacer.Replace(macRoman(ad.fileType[:])),
+				htmlReplacer.Replace(macRoman(ad.creator[:])))
+		}
+		for _, d := range ad.dates {
+			row(d.what, "%s", d.t.UTC().Format(time.RFC3339))
+		}
+	}
+
+	if stat.Mode().IsRegular() {
+		// reading a large file to hash it would hold up the page, so only a digest already cached is shown
+		if d, ok := o.cachedSHA256(); ok {
+			row("SHA-256", "<code>%x</code>", d[:])
+			if d1, ok := o.cachedSHA1(); ok {
+				row("SHA-1", "<code>%x</code>", d1[:])
+				if links := lookupLinks(stat.Name(), d1[:], d[:]); len(links) > 0 {
+					row("Look up", "%s", strings.Join(links, " &middot; "))
+				}
+			}
+		} else {
+			row("SHA-256", "not yet computed")
+		}
+		if algo, sum, verified := o.archiveChecksum(); algo != "" {
+			status := "not yet verified"
+			if verified {
+				status = "verified"
+			}
+			row("Stored "+strings.ToUpper(algo), "<code>%x</code> (%s)", sum, status)
+		}
+	}
+
+	if v, err := o.validate(); err == nil && v != nil {
+		var checks []string
+		for _, c := range v.Checks {
+			if c.OK {
+				checks = append(checks, c.What+" OK")
+			} else {
+				checks = append(checks, c.What+" <b>mismatch</b>")
+			}
+		}
+		row("Integrity", "%s: %s", v.Format, strings.Join(checks, ", "))
+	}
+
+	if chain := archiveChain(pathname); len(chain) > 0 {
+		var links []string
+		for _, ar := range chain {
+			links = append(links, fmt.Sprintf(`<a href="/%s/.info">%s</a>`,
+				urlenc(ar), htmlReplacer.Replace(ar[strings.LastIndex(ar, "/")+1:])))
+		}
+		row("Within", "%s", strings.Join(links, " &rarr; "))
+	}
+
+	if stat.Mode().IsRegular() {
+		if isar, _ := o.getArchive(true, false); isar {
+			row("Probe", `archive, browse at <a href="/%s/">%s/</a>`,
+				urlenc(pathname+Special), htmlReplacer.Replace(stat.Name()+Special))
+		} else {
+			row("Probe", "not an archive")
+		}
+	}
+	fmt.Fprint(page, "</table>\n")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(page.Bytes()))
+}
+
+// archiveChain lists the archive files that a path is nested within, outermost first
+func archiveChain(name string) (chain []string) {
+	warps := strings.Split(name, Special+"/")
+	for i := range len(warps) - 1 {
+		chain = append(chain, strings.Join(warps[:i+1], Special+"/"))
+	}
+	return chain
+}
+
+type sidecarInfo struct {
+	hasFork       bool
+	forkSize      int64
+	hasFinderInfo bool
+	fileType      [4]byte
+	creator       [4]byte
+	dates         []macDate
+}
+
+type macDate struct {
+	what string
+	t    time.Time
+}
+
+var errNoSidecar = errors.New("no AppleDouble sidecar")
+
+// sidecarInfo reads the "._name" file beside a file, whether generated by an archive or left by macOS
+func (o path) sidecarInfo() (ad sidecarInfo, err error) {
+	if o.name.Base() == "." || strings.HasPrefix(o.name.Base(), "._") {
+		return ad, errNoSidecar
+	}
+	sidecar := path{o.container, o.fsys, o.name.Dir().Join("._" + o.name.Base())}
+	f, err := sidecar.cookedOpen()
+	if err != nil {
+		return ad, err
+	}
+	defer f.Close()
+	ra, ok := f.(io.ReaderAt)
+	if !ok {
+		return ad, errNoSidecar
+	}
+
+	header := make([]byte, 26)
+	if n, _ := ra.ReadAt(header, 0); n < len(header) || string(header[:3]) != "\x00\x05\x16" {
+		return ad, ErrNotAppleDouble
+	}
+	recList := make([]byte, 12*min(binary.BigEndian.Uint16(header[24:]), 255))
+	if n, _ := ra.ReadAt(recList, 26); n < len(recList) {
+		return ad, ErrNotAppleDouble
+	}
+	for ; len(recList) > 0; recList = recList[12:] {
+		id := binary.BigEndian.Uint32(recList)
+		offset := int64(binary.BigEndian.Uint32(recList[4:]))
+		size := int64(binary.BigEndian.Uint32(recList[8:]))
+		switch id {
+		case 2: // resource fork
+			ad.hasFork, ad.forkSize = true, size
+		case 8: // file dates info
+			if size < 16 {
+				continue
+			}
+			buf := make([]byte, 16)
+			if n, _ := ra.ReadAt(buf, offset); n < len(buf) {
+				continue
+			}
+			for i, what := range [...]string{"Created", "Modified (Mac)", "Backed up"} {
+				secs := int32(binary.BigEndian.Uint32(buf[4*i:]))
+				if secs == math.MinInt32 || secs == math.MaxInt32 {
+					continue // unknown, or clamped out of range
+				}
+				ad.dates = append(ad.dates, macDate{what, appleDoubleEpoch.Add(time.Duration(secs) * time.Second)})
+			}
+		case 9: // Finder info
+			if size < 8 {
+				continue
+			}
+			buf := make([]byte, 8)
+			if n, _ := ra.ReadAt(buf, offset); n < len(buf) {
+				continue
+			}
+			ad.hasFinderInfo = true
+			copy(ad.fileType[:], buf)
+			copy(ad.creator[:], buf[4:])
+		}
+	}
+	return ad, nil
+}
+
+var appleDoubleEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// macRoman renders a four-character code, which is MacRoman text
+func macRoman(b []byte) string {
+	var s strings.Builder
+	for _, c := range b {
+		if c < 0x20 || c == 0x7f {
+			fmt.Fprintf(&s, "\\x%02x", c)
+		} else {
+			s.WriteRune(charmap.Macintosh.DecodeByte(c))
+		}
+	}
+	return s.String()
+}
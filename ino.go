@@ -0,0 +1,75 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"encoding/binary"
+	"log/slog"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/cockroachdb/pebble/v2"
+)
+
+// inoder is the optional interface of a FileInfo with an inode number, like hfs's Sys() value.
+// Every FileInfo returned by FS satisfies it. The numbers are unique within the FS and,
+// given a cache database, survive a restart, so a frontend that needs inodes (NFS, FUSE, SFTP)
+// or a client that compares them (rsync) does not see the whole tree as new each time.
+type inoder interface{ Inode() uint64 }
+
+var inoNextKey = []byte("\xffino/next")
+
+const firstIno = 2 // by convention 1 is reserved
+
+// inode looks up the number allotted to this path the first time it was asked for.
+// The dbkey already survives a restart, but it is too long to be an inode,
+// and hashing it down to 64 bits would risk collisions, so numbers are handed out in sequence.
+func (o path) inode(isDir bool) uint64 {
+	id := append(dbkey(o), inoByte)
+	if isDir && o.fsys != o.container.root {
+		// an archive's directories can share an identity with each other or with a file
+		id = o.name.AppendTo(append(id, '/'))
+	}
+	defer discardkey(id)
+	db := o.container.db.Load()
+	if db == nil {
+		return max(xxhash.Sum64(id), firstIno)
+	}
+
+	if ino, ok := getIno(db, id); ok {
+		return ino
+	}
+
+	o.container.nMu.Lock()
+	defer o.container.nMu.Unlock()
+	if ino, ok := getIno(db, id); ok { // lost a race
+		return ino
+	}
+	ino, ok := getIno(db, inoNextKey)
+	if !ok {
+		ino = firstIno
+	}
+	b := db.NewBatch()
+	b.Set(id, binary.BigEndian.AppendUint64(nil, ino), nil)
+	b.Set(inoNextKey, binary.BigEndian.AppendUint64(nil, ino+1), nil)
+	err := b.Commit(pebble.Sync) // a lost allocation would be handed out again
+	if err != nil {
+		slog.Error("setInodeError", "path", o, "err", err)
+	}
+	return ino
+}
+
+func getIno(db *pebble.DB, key []byte) (uint64, bool) {
+	val, closer, err := db.Get(key)
+	if err == pebble.ErrNotFound {
+		return 0, false
+	} else if err != nil {
+		slog.Error("getInodeError", "key", key, "err", err)
+		return 0, false
+	}
+	defer closer.Close()
+	if len(val) != 8 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(val), true
+}
@@ -0,0 +1,135 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/elliotnunn/BeHierarchic/internal/spinner"
+)
+
+// layerCounters snapshots the cumulative counters of each cache layer.
+// The layers have no notion of a request, so a request's share is the difference
+// between two snapshots, which is only exact if no other request (or the startup prefetch) overlapped it.
+type layerCounters struct {
+	spinner                spinner.Counters
+	pebbleHits, pebbleMiss int64 // bytes
+	pebbleCorrupt          int64
+}
+
+func (fsys *FS) readCounters() layerCounters {
+	return layerCounters{
+		spinner:       spinner.ReadCounters(),
+		pebbleHits:    atomic.LoadInt64(&fsys.scoreGood),
+		pebbleMiss:    atomic.LoadInt64(&fsys.scoreBad),
+		pebbleCorrupt: atomic.LoadInt64(&fsys.scoreCorrupt),
+	}
+}
+
+func (c layerCounters) sub(d layerCounters) layerCounters {
+	return layerCounters{
+		spinner:       c.spinner.Sub(d.spinner),
+		pebbleHits:    c.pebbleHits - d.pebbleHits,
+		pebbleMiss:    c.pebbleMiss - d.pebbleMiss,
+		pebbleCorrupt: c.pebbleCorrupt - d.pebbleCorrupt,
+	}
+}
+
+func (c layerCounters) serverTiming(dur time.Duration, approx bool) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "total;dur=%.1f", float64(dur)/float64(time.Millisecond))
+	if approx {
+		b.WriteString(`;desc="concurrent requests, counts approximate"`)
+	}
+	fmt.Fprintf(&b, `, blockcache;desc="hit=%d miss=%d"`, c.spinner.BlockHits, c.spinner.BlockMisses)
+	fmt.Fprintf(&b, `, readercache;desc="hit=%d miss=%d"`, c.spinner.ReaderHits, c.spinner.ReaderMisses)
+	fmt.Fprintf(&b, `, decompress;desc="bytes=%d"`, c.spinner.BytesDecompressed)
+	fmt.Fprintf(&b, `, tiers;desc="diskhit=%d promoted=%d"`, c.spinner.LowerHits, c.spinner.Promotions)
+	fmt.Fprintf(&b, `, pebble;desc="hitbytes=%d missbytes=%d corrupt=%d"`, c.pebbleHits, c.pebbleMiss, c.pebbleCorrupt)
+	return b.String()
+}
+
+var inFlight, started atomic.Int64
+
+// instrument adds a Server-Timing header (the work done before the response began)
+// and trailer (the total, if the response is chunked), and writes an access log line
+func instrument(fsys *FS, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		alone := inFlight.Add(1) == 1
+		seq := started.Add(1)
+		t := time.Now()
+		before := fsys.readCounters()
+		overlapped := func() bool { return !alone || inFlight.Load() > 1 || started.Load() != seq }
+
+		iw := &instrumentedWriter{ResponseWriter: w, status: http.StatusOK}
+		iw.onHeader = func() {
+			c := fsys.readCounters().sub(before)
+			w.Header().Set("Server-Timing", c.serverTiming(time.Since(t), overlapped()))
+		}
+		h.ServeHTTP(iw, r)
+
+		c := fsys.readCounters().sub(before)
+		dur := time.Since(t)
+		approx := overlapped()
+		inFlight.Add(-1)
+		iw.Header().Set(http.TrailerPrefix+"Server-Timing", c.serverTiming(dur, approx))
+		if recordAccess && r.Method == "GET" && (iw.status == http.StatusOK || iw.status == http.StatusPartialContent) &&
+			!strings.HasSuffix(r.URL.Path, "/") {
+			fsys.noteAccess(pathOf(r), t)
+		}
+
+		slog.Info("access",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", iw.status,
+			"bytes", iw.bytes,
+			"dur", dur,
+			"approx", approx,
+			"blockHit", c.spinner.BlockHits,
+			"blockMiss", c.spinner.BlockMisses,
+			"readerHit", c.spinner.ReaderHits,
+			"readerMiss", c.spinner.ReaderMisses,
+			"decompressed", c.spinner.BytesDecompressed,
+			"diskTierHit", c.spinner.LowerHits,
+			"promoted", c.spinner.Promotions,
+			"pebbleHitBytes", c.pebbleHits,
+			"pebbleMissBytes", c.pebbleMiss,
+			"pebbleCorrupt", c.pebbleCorrupt)
+	})
+}
+
+type instrumentedWriter struct {
+	http.ResponseWriter
+	onHeader func()
+	status   int
+	bytes    int64
+}
+
+func (w *instrumentedWriter) WriteHeader(status int) {
+	if w.onHeader != nil {
+		w.status = status
+		if status >= 200 { // not an informational response
+			w.onHeader()
+			w.onHeader = nil
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *instrumentedWriter) Write(p []byte) (int, error) {
+	if w.onHeader != nil {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach Flush on the underlying writer
+func (w *instrumentedWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
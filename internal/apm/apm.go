@@ -15,11 +15,13 @@ import (
 	"time"
 
 	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
+	"github.com/elliotnunn/BeHierarchic/internal/guard"
 	"github.com/elliotnunn/BeHierarchic/internal/sectionreader"
 )
 
 // Apple Partition Map
-func New(disk io.ReaderAt) (fs.FS, error) {
+func New(disk io.ReaderAt) (retfs fs.FS, reterr error) {
+	defer guard.Recover(&reterr, "APM", nil)
 	var ddm [514]byte
 	n, err := disk.ReadAt(ddm[:], 0)
 	if n < len(ddm) {
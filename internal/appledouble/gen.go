@@ -7,6 +7,8 @@ import (
 	"math"
 	"path"
 	"time"
+
+	"github.com/elliotnunn/BeHierarchic/internal/walltime"
 )
 
 // Everything that we would want to put in an AppleDouble file, except for a resource fork, because that's big.
@@ -39,7 +41,10 @@ type AppleDouble struct {
 	// but was oft corrupted, abandoned in System 7 and overloaded with XFlags.
 }
 
-func MacTime(t uint32) time.Time { return macEpoch.Add(time.Second * time.Duration(t)) }
+// MacTime converts the classic Mac OS's seconds since 1904, which follow the clock on the wall
+func MacTime(t uint32) time.Time {
+	return macEpoch.Add(time.Second * time.Duration(t)).In(walltime.Zone)
+}
 
 func Sidecar(name string) string {
 	a, b := path.Split(name)
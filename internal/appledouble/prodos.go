@@ -0,0 +1,22 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package appledouble
+
+// ProDOSType maps a ProDOS file type and auxiliary type to a Macintosh type and creator,
+// as AppleShare and GS/OS do: a few well-known types get their Mac equivalents,
+// and the rest become 'p' followed by the file type and the auxiliary type, with creator 'pdos'
+func ProDOSType(fileType byte, auxType uint16) (typ, creator [4]byte) {
+	creator = [4]byte{'p', 'd', 'o', 's'}
+	switch {
+	case fileType == 0x00 && auxType == 0:
+		return [4]byte{'B', 'I', 'N', 'A'}, creator
+	case fileType == 0x04 && auxType == 0:
+		return [4]byte{'T', 'E', 'X', 'T'}, creator
+	case fileType == 0xb3:
+		return [4]byte{'P', 'S', '1', '6'}, creator
+	case fileType == 0xff:
+		return [4]byte{'P', 'S', 'Y', 'S'}, creator
+	}
+	return [4]byte{'p', fileType, byte(auxType >> 8), byte(auxType)}, creator
+}
@@ -0,0 +1,117 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+// Package applesingle unpacks an AppleSingle file (RFC 1740) into its data fork and an AppleDouble sidecar,
+// which is how Apple's developer tools and source releases often travelled as .as and .ast files.
+package applesingle
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/elliotnunn/BeHierarchic/internal/appledouble"
+	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
+	"github.com/elliotnunn/BeHierarchic/internal/sectionreader"
+	"golang.org/x/text/encoding/charmap"
+)
+
+var ErrFormat = errors.New("not a valid AppleSingle file")
+
+const (
+	headerSize = 26
+	entrySize  = 12
+	maxEntries = 255
+)
+
+// Entry IDs
+const (
+	dataFork   = 1
+	rsrcFork   = 2
+	realName   = 3
+	fileDates  = 8
+	finderInfo = 9
+)
+
+// IsAppleSingle checks the magic number and the version, which is 1 or 2
+func IsAppleSingle(head []byte) bool {
+	return len(head) >= 8 && string(head[:4]) == "\x00\x05\x16\x00" &&
+		(string(head[4:8]) == "\x00\x01\x00\x00" || string(head[4:8]) == "\x00\x02\x00\x00")
+}
+
+type entry struct{ off, size int64 }
+
+// New2 presents the data fork with its real name, or failing that the given name, and the sidecar beside it
+func New2(headerReader, dataReader io.ReaderAt, name string, mtime time.Time) (fs.FS, error) {
+	h := make([]byte, headerSize)
+	if n, err := headerReader.ReadAt(h, 0); n != len(h) {
+		if err == io.EOF {
+			err = ErrFormat
+		}
+		return nil, err
+	} else if !IsAppleSingle(h) {
+		return nil, ErrFormat
+	}
+	count := int(binary.BigEndian.Uint16(h[24:]))
+	if count > maxEntries {
+		return nil, ErrFormat
+	}
+	list := make([]byte, count*entrySize)
+	if n, _ := headerReader.ReadAt(list, headerSize); n != len(list) {
+		return nil, ErrFormat
+	}
+	entries := make(map[uint32]entry)
+	for i := range count {
+		e := list[i*entrySize:]
+		entries[binary.BigEndian.Uint32(e)] = entry{int64(binary.BigEndian.Uint32(e[4:])), int64(binary.BigEndian.Uint32(e[8:]))}
+	}
+	read := func(id uint32, max int64) []byte {
+		e, ok := entries[id]
+		if !ok || e.size > max {
+			return nil
+		}
+		buf := make([]byte, e.size)
+		if n, _ := headerReader.ReadAt(buf, e.off); n != len(buf) {
+			return nil
+		}
+		return buf
+	}
+
+	if b := read(realName, 255); len(b) > 0 {
+		s, _ := charmap.Macintosh.NewDecoder().String(string(b))
+		if s = strings.NewReplacer("/", ":", "\x00", "").Replace(s); fs.ValidPath(s) {
+			name = s
+		}
+	}
+
+	var meta appledouble.AppleDouble
+	meta.ModTime = mtime
+	if b := read(finderInfo, 32); len(b) >= 16 {
+		meta.LoadFInfo((*[16]byte)(b))
+		if len(b) == 32 {
+			meta.LoadFXInfo((*[16]byte)(b[16:]))
+		}
+	}
+	if b := read(fileDates, 16); len(b) == 16 {
+		for i, t := range []*time.Time{&meta.CreateTime, &meta.ModTime, &meta.BkTime, &meta.AccTime} {
+			if secs := int32(binary.BigEndian.Uint32(b[4*i:])); secs != math.MinInt32 {
+				*t = appleDoubleEpoch.Add(time.Duration(secs) * time.Second)
+			}
+		}
+	}
+
+	fsys := fskeleton.New()
+	data := entries[dataFork]
+	fsys.CreateReaderAt(name, dataFork, sectionreader.Section(dataReader, data.off, data.size), data.size, 0, meta.ModTime)
+	rsrc := entries[rsrcFork]
+	ad, adsize := meta.WithResourceFork(sectionreader.Section(dataReader, rsrc.off, rsrc.size), rsrc.size)
+	fsys.CreateReaderAt(appledouble.Sidecar(name), rsrcFork, ad, adsize, 0, meta.ModTime)
+	fsys.NoMore()
+	return fsys, nil
+}
+
+var appleDoubleEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
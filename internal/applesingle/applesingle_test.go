@@ -0,0 +1,76 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package applesingle
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/fs"
+	"testing"
+	"time"
+)
+
+func TestAppleSingle(t *testing.T) {
+	data := []byte("on run\rend run\r")
+	rsrc := bytes.Repeat([]byte{0xa5}, 300)
+	name := []byte("Main.c/old \xa5")
+	finfo := []byte("TEXTMPS \x00\x00\x00\x00\x00\x00\x00\x00")
+	dates := make([]byte, 16)
+	binary.BigEndian.PutUint32(dates[4:], 3600)
+
+	type ent struct {
+		id   uint32
+		body []byte
+	}
+	ents := []ent{{realName, name}, {fileDates, dates}, {finderInfo, finfo}, {rsrcFork, rsrc}, {dataFork, data}}
+	f := []byte("\x00\x05\x16\x00\x00\x02\x00\x00")
+	f = append(f, make([]byte, 16)...)
+	f = binary.BigEndian.AppendUint16(f, uint16(len(ents)))
+	off := len(f) + entrySize*len(ents)
+	var bodies []byte
+	for _, e := range ents {
+		f = binary.BigEndian.AppendUint32(f, e.id)
+		f = binary.BigEndian.AppendUint32(f, uint32(off+len(bodies)))
+		f = binary.BigEndian.AppendUint32(f, uint32(len(e.body)))
+		bodies = append(bodies, e.body...)
+	}
+	f = append(f, bodies...)
+
+	if !IsAppleSingle(f) {
+		t.Fatal("magic number not recognised")
+	}
+	fsys, err := New2(bytes.NewReader(f), bytes.NewReader(f), "Main.c.as", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = "Main.c:old •"
+	got, err := fs.ReadFile(fsys, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("data fork: got %q, want %q", got, data)
+	}
+	stat, err := fs.Stat(fsys, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mt := stat.ModTime(); !mt.Equal(appleDoubleEpoch.Add(time.Hour)) {
+		t.Errorf("modtime: got %v", mt)
+	}
+	ad, err := fs.ReadFile(fsys, "._"+want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(ad, finfo[:8]) || !bytes.Contains(ad, rsrc) {
+		t.Error("sidecar lacks the Finder info or the resource fork")
+	}
+}
+
+func TestNotAppleSingle(t *testing.T) {
+	double := []byte("\x00\x05\x16\x07\x00\x02\x00\x00")
+	if IsAppleSingle(double) {
+		t.Error("AppleDouble mistaken for AppleSingle")
+	}
+}
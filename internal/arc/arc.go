@@ -0,0 +1,186 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+// Package arc reads the ARC archives of System Enhancement Associates,
+// including the subdirectories of ARC 6 and the compatible PKPAK and PAK.
+package arc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"strings"
+
+	"github.com/elliotnunn/BeHierarchic/internal/dostime"
+	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
+	"github.com/elliotnunn/BeHierarchic/internal/guard"
+	"github.com/elliotnunn/BeHierarchic/internal/sectionreader"
+	"golang.org/x/text/encoding/charmap"
+)
+
+var (
+	ErrFormat = errors.New("not a valid ARC archive")
+	ErrMethod = errors.New("ARC: unimplemented compression method")
+)
+
+const (
+	marker     = 0x1a
+	nameSize   = 13
+	headerSize = 29 // method 1 lacks the final size field
+	subdir     = 30 // ARC 6: a nested archive of the files in a directory
+	endSubdir  = 31
+)
+
+// IsArchive checks the first entry header, which has a weak magic number but a fussy filename field
+func IsArchive(head []byte) bool {
+	if len(head) < 2+nameSize || head[0] != marker || !knownMethod(head[1]) {
+		return false
+	}
+	name, _, ok := strings.Cut(string(head[2:][:nameSize]), "\x00")
+	if !ok || name == "" {
+		return false
+	}
+	for _, c := range []byte(name) {
+		if c <= ' ' || c == 0x7f || strings.IndexByte(`"*+,/:;<=>?[\]|`, c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func knownMethod(m byte) bool {
+	return 1 <= m && m <= 11 || m == subdir
+}
+
+// New opens an archive
+func New(r io.ReaderAt) (fs.FS, error) {
+	return New2(r, r)
+}
+
+// New2 routes headers and data requests through different readers, to help exotic caching schemes
+func New2(headerReader, dataReader io.ReaderAt) (retfs fs.FS, reterr error) {
+	defer guard.Recover(&reterr, "ARC", nil)
+	h := make([]byte, headerSize)
+	if n, err := headerReader.ReadAt(h, 0); n < 2+nameSize {
+		if err == io.EOF {
+			err = ErrFormat
+		}
+		return nil, err
+	} else if !IsArchive(h) {
+		return nil, ErrFormat
+	}
+
+	fsys := fskeleton.New()
+	go populate(fsys, headerReader, dataReader)
+	return fsys, nil
+}
+
+func populate(fsys *fskeleton.FS, headerReader, dataReader io.ReaderAt) {
+	defer fsys.NoMore()
+	defer guard.Log("ARC", nil)
+	walk(fsys, headerReader, dataReader, 0, math.MaxInt64, nil)
+}
+
+// walk adds the entries from off up to an end marker.
+// An ARC 6 subdirectory entry contains a nested archive, so walk recurses.
+// A damaged archive stops the walk early, keeping what is already known.
+func walk(fsys *fskeleton.FS, headerReader, dataReader io.ReaderAt, off, end int64, dirs []string) {
+	le := binary.LittleEndian
+	for off < end {
+		h := make([]byte, headerSize)
+		n, _ := headerReader.ReadAt(h, off)
+		if n < 2 || h[0] != marker {
+			return
+		}
+		method := h[1]
+		if method == 0 || method == endSubdir {
+			return
+		}
+
+		hdrlen := int64(headerSize)
+		if method == 1 {
+			hdrlen -= 4
+		}
+		if int64(n) < hdrlen {
+			return
+		}
+		name, _, _ := strings.Cut(string(h[2:][:nameSize]), "\x00")
+		name, _ = charmap.CodePage437.NewDecoder().String(name)
+		packed := int64(le.Uint32(h[15:]))
+		mtime := dostime.Time(le.Uint16(h[19:]), le.Uint16(h[21:]))
+		size := packed
+		if method != 1 {
+			size = int64(le.Uint32(h[25:]))
+		}
+		id := off
+		start := off + hdrlen
+		off = start + packed
+
+		if 20 <= method && method < subdir { // ARC 6 information items
+			continue
+		}
+		pathname := strings.Join(append(dirs[:len(dirs):len(dirs)], name), "/")
+		if !fs.ValidPath(pathname) || strings.Contains(name, "/") {
+			continue
+		}
+
+		section := sectionreader.Section(dataReader, start, packed)
+		switch method {
+		case subdir:
+			fsys.Mkdir(pathname, id, 0, mtime)
+			walk(fsys, headerReader, dataReader, start, off, append(dirs, name))
+		case 1, 2: // stored
+			fsys.CreateReaderAt(pathname, id, section, size, 0, mtime)
+		case 3, 4, 5, 6, 7, 8, 9:
+			opener := func() (io.ReadCloser, error) {
+				return decompressor(method, io.NewSectionReader(section, 0, packed), size)
+			}
+			fsys.CreateReadCloser(pathname, id, opener, size, 0, mtime)
+		default:
+			fsys.CreateError(pathname, id, fmt.Errorf("%w %d", ErrMethod, method), size, 0, mtime)
+		}
+	}
+}
+
+func decompressor(method byte, r io.Reader, size int64) (io.ReadCloser, error) {
+	var rc io.ReadCloser
+	switch method {
+	case 3: // packed
+		return readCloser{unrle(r, size), nopCloser}, nil
+	case 4: // squeezed
+		rc = unsqueeze(r)
+		return readCloser{unrle(rc, size), rc.Close}, nil
+	case 5: // crunched, old style
+		return uncrunch(r, oldHash, size), nil
+	case 6: // crunched, old style, after run-length encoding
+		rc = uncrunch(r, oldHash, -1)
+		return readCloser{unrle(rc, size), rc.Close}, nil
+	case 7: // crunched, old style with the new hash, after run-length encoding
+		rc = uncrunch(r, newHash, -1)
+		return readCloser{unrle(rc, size), rc.Close}, nil
+	case 8: // crunched
+		var bits [1]byte
+		if _, err := io.ReadFull(r, bits[:]); err != nil {
+			return nil, err
+		} else if bits[0] != 12 {
+			return nil, fmt.Errorf("%w: crunched with %d bits", ErrMethod, bits[0])
+		}
+		rc = unlzw(r, 12, -1) // the size before run-length decoding is unknown
+		return readCloser{unrle(rc, size), rc.Close}, nil
+	case 9: // squashed
+		return unlzw(r, 13, size), nil
+	}
+	return nil, ErrMethod
+}
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (rc readCloser) Close() error { return rc.close() }
+
+func nopCloser() error { return nil }
@@ -0,0 +1,163 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package arc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func entry(method byte, name string, packed []byte, size int) []byte {
+	h := []byte{marker, method}
+	h = append(h, make([]byte, nameSize)...)
+	copy(h[2:], name)
+	h = binary.LittleEndian.AppendUint32(h, uint32(len(packed)))
+	h = binary.LittleEndian.AppendUint16(h, 0x2a55) // 21 Feb 2001
+	h = binary.LittleEndian.AppendUint16(h, 0x6000) // 12:00
+	h = binary.LittleEndian.AppendUint16(h, 0)      // CRC
+	if method != 1 {
+		h = binary.LittleEndian.AppendUint32(h, uint32(size))
+	}
+	return append(h, packed...)
+}
+
+// lzwLiterals packs 9-bit codes, least significant bit first
+func lzwLiterals(codes ...int) []byte {
+	var b []byte
+	n := 0
+	for _, c := range codes {
+		for i := range 9 {
+			if n%8 == 0 {
+				b = append(b, 0)
+			}
+			b[len(b)-1] |= byte(c>>i&1) << (n % 8)
+			n++
+		}
+	}
+	return b
+}
+
+func TestArchive(t *testing.T) {
+	squeezed := []byte{2, 0} // two nodes
+	for _, n := range []int16{-('x' + 1), 1, -(speof + 1), -('y' + 1)} {
+		squeezed = binary.LittleEndian.AppendUint16(squeezed, uint16(n))
+	}
+	squeezed = append(squeezed, 0b010110) // x y x EOF
+
+	var ar []byte
+	ar = append(ar, entry(2, "STORED.TXT", []byte("hello"), 5)...)
+	ar = append(ar, entry(3, "PACKED.TXT", []byte("a\x90\x05\x90\x00b"), 7)...)
+	ar = append(ar, entry(4, "SQUEEZED", squeezed, 3)...)
+	ar = append(ar, entry(8, "CRUNCHED", append([]byte{12}, lzwLiterals('a', dle, 5)...), 5)...)
+	ar = append(ar, entry(9, "SQUASHED", lzwLiterals('a', 'b', 257), 4)...)
+	ar = append(ar, entry(subdir, "DIR", append(entry(1, "OLD", []byte("old"), 3), marker, 0), 0)...)
+	ar = append(ar, marker, 0)
+
+	if !IsArchive(ar) {
+		t.Fatal("IsArchive failed")
+	}
+	fsys, err := New(bytes.NewReader(ar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"STORED.TXT": "hello",
+		"PACKED.TXT": "aaaaa\x90b",
+		"SQUEEZED":   "xyx",
+		"CRUNCHED":   "aaaaa",
+		"SQUASHED":   "abab",
+		"DIR/OLD":    "old",
+	}
+	for name, content := range want {
+		got, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if string(got) != content {
+			t.Errorf("%s: got %q, want %q", name, got, content)
+		}
+	}
+	if err := fstest.TestFS(fsys, "STORED.TXT", "PACKED.TXT", "SQUEEZED", "CRUNCHED", "SQUASHED", "DIR/OLD"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUnimplemented(t *testing.T) {
+	ar := append(entry(10, "CRUSHED", []byte{0}, 1), marker, 0)
+	fsys, err := New(bytes.NewReader(ar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.ReadFile(fsys, "CRUSHED"); !errors.Is(err, ErrMethod) {
+		t.Errorf("got %v, want ErrMethod", err)
+	}
+}
+
+// crunch is the compressing half of old-style crunching
+func crunch(data []byte, hash func(uint16, byte) uint16) []byte {
+	t := newCrunchTable(hash)
+	find := func(pred uint16, foll byte) (uint16, bool) {
+		for i := range uint16(crunchTabSize) {
+			if t.used[i] && t.pred[i] == pred && t.foll[i] == foll {
+				return i, true
+			}
+		}
+		return 0, false
+	}
+	var codes []uint16
+	w, _ := find(noPred, data[0])
+	for _, c := range data[1:] {
+		if code, ok := find(w, c); ok {
+			w = code
+			continue
+		}
+		codes = append(codes, w)
+		t.add(w, c)
+		w, _ = find(noPred, c)
+	}
+	codes = append(codes, w)
+
+	var b []byte
+	for i := 0; i < len(codes); i += 2 {
+		b = append(b, byte(codes[i]>>4), byte(codes[i]<<4))
+		if i+1 < len(codes) {
+			b[len(b)-1] |= byte(codes[i+1] >> 8)
+			b = append(b, byte(codes[i+1]))
+		}
+	}
+	return b
+}
+
+func TestOldCrunch(t *testing.T) {
+	text := []byte("TOBEORNOTTOBEORTOBEORNOT, that is the question, whether 'tis nobler in the mind to suffer")
+	rle := []byte("a\x90\x05b") // aaaaab
+
+	var ar []byte
+	ar = append(ar, entry(5, "METHOD5", crunch(text, oldHash), len(text))...)
+	ar = append(ar, entry(6, "METHOD6", crunch(rle, oldHash), 6)...)
+	ar = append(ar, entry(7, "METHOD7", crunch(text, newHash), len(text))...)
+	ar = append(ar, marker, 0)
+
+	fsys, err := New(bytes.NewReader(ar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"METHOD5": string(text), "METHOD6": "aaaaab", "METHOD7": string(text)} {
+		got, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if string(got) != content {
+			t.Errorf("%s: got %q, want %q", name, got, content)
+		}
+	}
+}
+
+func TestNotArchive(t *testing.T) {
+	if IsArchive([]byte("\x1a\x02 not a filename")) {
+		t.Error("accepted a bad filename")
+	}
+}
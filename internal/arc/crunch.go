@@ -0,0 +1,169 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package arc
+
+import (
+	"bufio"
+	"io"
+)
+
+// ARC before version 5 "crunched" with a 12-bit LZW of its own:
+// the codes never change width, and each string goes in the table wherever a hash of it lands.
+// Method 5 has no run-length encoding, method 6 has it,
+// and method 7 has it and uses a faster hash function.
+
+const (
+	crunchTabSize = 4096
+	noPred        = 0xffff
+)
+
+type crunchTable struct {
+	used [crunchTabSize]bool
+	next [crunchTabSize]uint16 // collision list, 0 at the end
+	pred [crunchTabSize]uint16
+	foll [crunchTabSize]byte
+	n    int
+	hash func(pred uint16, foll byte) uint16
+}
+
+// oldHash is the middle 12 bits of the square
+func oldHash(pred uint16, foll byte) uint16 {
+	local := uint32(pred + uint16(foll) | 0x800)
+	return uint16(local * local >> 6 & 0xfff)
+}
+
+func newHash(pred uint16, foll byte) uint16 {
+	return uint16(uint32(pred+uint16(foll)) * 15073 & 0xfff)
+}
+
+func newCrunchTable(hash func(uint16, byte) uint16) *crunchTable {
+	t := &crunchTable{hash: hash}
+	for c := range 256 {
+		t.add(noPred, byte(c))
+	}
+	return t
+}
+
+// add puts a string at its hash, or if that is taken, at the next free slot 101 past the end of the collision list
+func (t *crunchTable) add(pred uint16, foll byte) {
+	if t.n == crunchTabSize {
+		return
+	}
+	i := t.hash(pred, foll)
+	if t.used[i] {
+		for t.next[i] != 0 {
+			i = t.next[i]
+		}
+		j := (i + 101) % crunchTabSize
+		for t.used[j] {
+			j = (j + 1) % crunchTabSize
+		}
+		t.next[i] = j
+		i = j
+	}
+	t.used[i], t.next[i], t.pred[i], t.foll[i] = true, 0, pred, foll
+	t.n++
+}
+
+// uncrunch decodes methods 5 to 7, less the run-length encoding.
+// A negative size means to read up to the end of src.
+func uncrunch(src io.Reader, hash func(uint16, byte) uint16, size int64) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go uncrunchcopy(pw, src, hash, size)
+	return pr
+}
+
+func uncrunchcopy(dst *io.PipeWriter, src io.Reader, hash func(uint16, byte) uint16, size int64) {
+	var reterr error
+	br := bufio.NewReaderSize(src, 4096)
+	bw := bufio.NewWriterSize(dst, 4096)
+	defer func() {
+		if reterr == nil || reterr == io.EOF {
+			reterr = bw.Flush()
+		}
+		dst.CloseWithError(reterr)
+	}()
+
+	// Two codes to three bytes, most significant bits first, and a lone last code in two bytes
+	var inbuf byte
+	half := false
+	getcode := func() (uint16, bool) {
+		if half {
+			half = false
+			c, err := br.ReadByte()
+			if err != nil {
+				reterr = err
+				return 0, false
+			}
+			return uint16(inbuf&0xf)<<8 | uint16(c), true
+		}
+		a, err := br.ReadByte()
+		if err == nil {
+			inbuf, err = br.ReadByte()
+		}
+		if err != nil {
+			reterr = err
+			return 0, false
+		}
+		half = true
+		return uint16(a)<<4 | uint16(inbuf>>4), true
+	}
+
+	write := func(b byte) bool {
+		if size == 0 {
+			return false
+		}
+		if reterr = bw.WriteByte(b); reterr != nil {
+			return false
+		}
+		size--
+		return true
+	}
+
+	t := newCrunchTable(hash)
+	oldcode, ok := getcode()
+	if !ok {
+		return
+	} else if !t.used[oldcode] {
+		reterr = errLZW
+		return
+	}
+	finchar := t.foll[oldcode]
+	if !write(finchar) {
+		return
+	}
+
+	var stack []byte
+	for {
+		newcode, ok := getcode()
+		if !ok {
+			return
+		}
+		code := newcode
+		if !t.used[code] {
+			code = oldcode
+			stack = append(stack, finchar)
+		}
+		for t.pred[code] != noPred {
+			stack = append(stack, t.foll[code])
+			code = t.pred[code]
+			if len(stack) > crunchTabSize {
+				reterr = errLZW
+				return
+			}
+		}
+		finchar = t.foll[code]
+		stack = append(stack, finchar)
+
+		for i := len(stack) - 1; i >= 0; i-- {
+			if !write(stack[i]) {
+				return
+			}
+		}
+		stack = stack[:0]
+
+		t.add(oldcode, finchar)
+		oldcode = newcode
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package arc
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+var errLZW = errors.New("ARC: corrupt LZW data")
+
+// unlzw decodes "crunched" and "squashed" data, which use the algorithm of Unix compress 4.0,
+// so it reproduces that program's habit of discarding the rest of a block of codes
+// whenever the code width changes.
+// A negative size means to read up to the end of src.
+func unlzw(src io.Reader, maxbits int, size int64) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go unlzwcopy(pw, src, maxbits, size)
+	return pr
+}
+
+func unlzwcopy(dst *io.PipeWriter, src io.Reader, maxbits int, size int64) {
+	var reterr error
+	br := bufio.NewReaderSize(src, 4096)
+	bw := bufio.NewWriterSize(dst, 4096)
+	defer func() {
+		if reterr == nil || reterr == io.EOF {
+			reterr = bw.Flush()
+		}
+		dst.CloseWithError(reterr)
+	}()
+
+	const clearCode = 256
+	maxmaxcode := 1 << maxbits
+	nbits := 9
+	maxcode := 1<<nbits - 1
+	freeEnt := 257
+	clearflag := false
+
+	prefixtab := make([]uint16, maxmaxcode)
+	suffixtab := make([]byte, maxmaxcode)
+	for i := range 256 {
+		suffixtab[i] = byte(i)
+	}
+
+	var buffer [16]byte
+	boffset, bsize := 0, 0
+	getcode := func() (int, bool) {
+		needNewBuf := boffset >= bsize
+		if freeEnt > maxcode {
+			nbits++
+			if nbits == maxbits {
+				maxcode = maxmaxcode
+			} else {
+				maxcode = 1<<nbits - 1
+			}
+			needNewBuf = true
+		}
+		if clearflag {
+			nbits = 9
+			maxcode = 1<<nbits - 1
+			clearflag = false
+			needNewBuf = true
+		}
+		if needNewBuf {
+			n, err := io.ReadFull(br, buffer[:nbits])
+			if n == 0 {
+				reterr = err
+				return 0, false
+			}
+			clear(buffer[n:])
+			boffset = 0
+			bsize = n*8 - (nbits - 1) // ensure no over-reading
+		}
+		byteoffset, bitoffset := boffset/8, boffset%8
+		code := (uint32(buffer[byteoffset]) |
+			uint32(buffer[byteoffset+1])<<8 |
+			uint32(buffer[byteoffset+2])<<16) >> bitoffset & (1<<nbits - 1)
+		boffset += nbits
+		return int(code), true
+	}
+
+	write := func(b byte) bool {
+		if size == 0 {
+			return false
+		}
+		if reterr = bw.WriteByte(b); reterr != nil {
+			return false
+		}
+		size--
+		return true
+	}
+
+	oldcode, ok := getcode()
+	if !ok || oldcode > 255 {
+		return
+	}
+	finchar := byte(oldcode)
+	if !write(finchar) {
+		return
+	}
+
+	var stack []byte
+	for {
+		code, ok := getcode()
+		if !ok {
+			return
+		}
+		if code == clearCode {
+			clear(prefixtab[:256])
+			clearflag = true
+			freeEnt = 256
+			if code, ok = getcode(); !ok {
+				return
+			}
+		}
+		incode := code
+
+		if code >= freeEnt {
+			if code > freeEnt {
+				reterr = errLZW
+				return
+			}
+			stack = append(stack, finchar)
+			code = oldcode
+		}
+		for code >= 256 {
+			stack = append(stack, suffixtab[code])
+			code = int(prefixtab[code])
+		}
+		finchar = suffixtab[code]
+		stack = append(stack, finchar)
+
+		for i := len(stack) - 1; i >= 0; i-- {
+			if !write(stack[i]) {
+				return
+			}
+		}
+		stack = stack[:0]
+
+		if freeEnt < maxmaxcode {
+			prefixtab[freeEnt] = uint16(oldcode)
+			suffixtab[freeEnt] = finchar
+			freeEnt++
+		}
+		oldcode = incode
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package arc
+
+import (
+	"bufio"
+	"io"
+)
+
+const dle = 0x90
+
+// unrle undoes the run-length encoding that precedes the other compression methods:
+// 0x90 n repeats the previous byte until it has appeared n times, and 0x90 0x00 is a literal 0x90.
+func unrle(r io.Reader, size int64) io.Reader {
+	return &rleReader{r: bufio.NewReaderSize(r, 4096), left: size}
+}
+
+type rleReader struct {
+	r      *bufio.Reader
+	left   int64
+	last   byte
+	repeat int
+}
+
+func (rr *rleReader) Read(p []byte) (n int, err error) {
+	for n < len(p) && rr.left > 0 {
+		if rr.repeat > 0 {
+			p[n] = rr.last
+			n++
+			rr.left--
+			rr.repeat--
+			continue
+		}
+		c, err := rr.r.ReadByte()
+		if err != nil {
+			return n, unexpected(err)
+		}
+		if c != dle {
+			rr.last = c
+			rr.repeat = 1
+			continue
+		}
+		count, err := rr.r.ReadByte()
+		if err != nil {
+			return n, unexpected(err)
+		}
+		if count == 0 {
+			p[n] = dle // not remembered as the byte to repeat
+			n++
+			rr.left--
+		} else {
+			rr.repeat = int(count) - 1
+		}
+	}
+	if rr.left == 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,81 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package arc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var errSqueeze = errors.New("ARC: corrupt Huffman tree")
+
+const speof = 256 // the end of a squeezed stream
+
+// unsqueeze decodes Richard Greenlaw's Huffman coding: a tree of up to 256 nodes,
+// whose negative children are leaves, then codes read least significant bit first.
+func unsqueeze(r io.Reader) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go unsqueezecopy(pw, r)
+	return pr
+}
+
+func unsqueezecopy(dst *io.PipeWriter, src io.Reader) {
+	var reterr error
+	br := bufio.NewReaderSize(src, 4096)
+	bw := bufio.NewWriterSize(dst, 4096)
+	defer func() {
+		if reterr == nil || reterr == io.EOF {
+			reterr = bw.Flush()
+		}
+		dst.CloseWithError(reterr)
+	}()
+
+	var count uint16
+	if reterr = binary.Read(br, binary.LittleEndian, &count); reterr != nil {
+		reterr = unexpected(reterr)
+		return
+	} else if count > 256 {
+		reterr = errSqueeze
+		return
+	} else if count == 0 {
+		return // empty
+	}
+	nodes := make([][2]int16, count)
+	if reterr = binary.Read(br, binary.LittleEndian, nodes); reterr != nil {
+		reterr = unexpected(reterr)
+		return
+	}
+
+	var cur byte
+	nbits := 0
+	for {
+		node := int16(0)
+		for node >= 0 {
+			if int(node) >= len(nodes) {
+				reterr = errSqueeze
+				return
+			}
+			if nbits == 0 {
+				cur, reterr = br.ReadByte()
+				if reterr != nil {
+					reterr = unexpected(reterr)
+					return
+				}
+				nbits = 8
+			}
+			node = nodes[node][cur&1]
+			cur >>= 1
+			nbits--
+		}
+		c := -(int(node) + 1)
+		if c == speof {
+			return
+		}
+		if reterr = bw.WriteByte(byte(c)); reterr != nil {
+			return
+		}
+	}
+}
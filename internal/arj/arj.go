@@ -0,0 +1,175 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+// Package arj reads the archives of Robert Jung's ARJ, as documented in his UNARJ.
+package arj
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/elliotnunn/BeHierarchic/internal/dostime"
+	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
+	"github.com/elliotnunn/BeHierarchic/internal/guard"
+	"github.com/elliotnunn/BeHierarchic/internal/lzh"
+	"github.com/elliotnunn/BeHierarchic/internal/sectionreader"
+	"golang.org/x/text/encoding/charmap"
+)
+
+var (
+	ErrFormat    = errors.New("not a valid ARJ archive")
+	ErrMethod    = errors.New("ARJ: unimplemented compression method")
+	ErrEncrypted = errors.New("ARJ: garbled (encrypted) file")
+	ErrVolume    = errors.New("ARJ: file continues in another volume")
+)
+
+const (
+	maxHeader = 2600
+	firstSize = 30 // the fixed part of the basic header
+
+	flagGarbled = 0x01
+	flagVolume  = 0x04 // continued in the next volume
+	flagExtFile = 0x08 // continued from the previous volume
+
+	typeBinary = 0
+	typeText   = 1
+	typeMain   = 2
+	typeDir    = 3
+
+	hostMSDOS = 0
+)
+
+// IsArchive checks the magic number and the size of the main header that follows
+func IsArchive(head []byte) bool {
+	if len(head) < 4 || head[0] != 0x60 || head[1] != 0xea {
+		return false
+	}
+	size := binary.LittleEndian.Uint16(head[2:])
+	return firstSize <= size && size <= maxHeader
+}
+
+// New opens an archive
+func New(r io.ReaderAt) (fs.FS, error) {
+	return New2(r, r)
+}
+
+// New2 routes headers and data requests through different readers, to help exotic caching schemes
+func New2(headerReader, dataReader io.ReaderAt) (retfs fs.FS, reterr error) {
+	defer guard.Recover(&reterr, "ARJ", nil)
+	h, _, next, err := readHeader(headerReader, 0)
+	if err != nil {
+		return nil, err
+	} else if h == nil || h[6] != typeMain {
+		return nil, ErrFormat
+	}
+	fsys := fskeleton.New()
+	go populate(fsys, headerReader, dataReader, next)
+	return fsys, nil
+}
+
+func populate(fsys *fskeleton.FS, headerReader, dataReader io.ReaderAt, off int64) {
+	defer fsys.NoMore()
+	defer guard.Log("ARJ", &off)
+	le := binary.LittleEndian
+	for {
+		h, name, start, err := readHeader(headerReader, off)
+		if err != nil || h == nil {
+			return // the end, or damage
+		}
+		id := off
+		packed := int64(le.Uint32(h[12:]))
+		size := int64(le.Uint32(h[16:]))
+		mtime := dostime.Time(le.Uint16(h[10:]), le.Uint16(h[8:]))
+		flags, method, typ := h[4], h[5], h[6]
+		off = start + packed
+
+		if h[3] == hostMSDOS || !utf8.ValidString(name) {
+			name, _ = charmap.CodePage437.NewDecoder().String(name)
+		}
+		name = strings.Trim(strings.ReplaceAll(name, "\\", "/"), "/")
+		if !fs.ValidPath(name) {
+			continue
+		}
+
+		section := sectionreader.Section(dataReader, start, packed)
+		switch {
+		case typ == typeDir:
+			fsys.Mkdir(name, id, 0, mtime)
+		case typ != typeBinary && typ != typeText:
+			continue // volume labels and the like
+		case flags&flagGarbled != 0:
+			fsys.CreateError(name, id, ErrEncrypted, size, 0, mtime)
+		case flags&(flagVolume|flagExtFile) != 0:
+			fsys.CreateError(name, id, ErrVolume, size, 0, mtime)
+		case method == 0: // stored
+			fsys.CreateReaderAt(name, id, section, size, 0, mtime)
+		case method <= 3:
+			opener := func() (io.ReadCloser, error) {
+				return lzh.NewReader(io.NewSectionReader(section, 0, packed), lzh.ARJ, size), nil
+			}
+			fsys.CreateReadCloser(name, id, opener, size, 0, mtime)
+		case method == 4:
+			opener := func() (io.ReadCloser, error) {
+				return decodeFastest(io.NewSectionReader(section, 0, packed), size), nil
+			}
+			fsys.CreateReadCloser(name, id, opener, size, 0, mtime)
+		default:
+			fsys.CreateError(name, id, fmt.Errorf("%w %d", ErrMethod, method), size, 0, mtime)
+		}
+	}
+}
+
+// readHeader returns the basic header starting at off (nil at the end of the archive),
+// the filename within it, and the offset after its extended headers
+func readHeader(r io.ReaderAt, off int64) (h []byte, name string, next int64, err error) {
+	le := binary.LittleEndian
+	prefix := make([]byte, 4)
+	if n, err := r.ReadAt(prefix, off); n != len(prefix) {
+		if err == io.EOF {
+			err = ErrFormat
+		}
+		return nil, "", 0, err
+	} else if prefix[0] != 0x60 || prefix[1] != 0xea {
+		return nil, "", 0, ErrFormat
+	}
+	size := int(le.Uint16(prefix[2:]))
+	if size == 0 {
+		return nil, "", 0, nil
+	} else if size < firstSize || size > maxHeader {
+		return nil, "", 0, ErrFormat
+	}
+	h = make([]byte, size)
+	if n, err := r.ReadAt(h, off+4); n != len(h) {
+		if err == io.EOF {
+			err = ErrFormat
+		}
+		return nil, "", 0, err
+	}
+	if int(h[0]) < firstSize || int(h[0]) >= size {
+		return nil, "", 0, ErrFormat
+	}
+	name, _, _ = strings.Cut(string(h[h[0]:]), "\x00")
+
+	next = off + 4 + int64(size) + 4 // and the CRC-32
+	for range 64 {
+		ext := make([]byte, 2)
+		if n, err := r.ReadAt(ext, next); n != len(ext) {
+			if err == io.EOF {
+				err = ErrFormat
+			}
+			return nil, "", 0, err
+		}
+		next += 2
+		extSize := int64(le.Uint16(ext))
+		if extSize == 0 {
+			return h, name, next, nil
+		}
+		next += extSize + 4
+	}
+	return nil, "", 0, ErrFormat
+}
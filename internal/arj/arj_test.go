@@ -0,0 +1,110 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package arj
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/fs"
+	"os"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/elliotnunn/BeHierarchic/internal/bittest"
+)
+
+func header(typ, method byte, name string, packed []byte, size int) []byte {
+	le := binary.LittleEndian
+	h := make([]byte, firstSize)
+	h[0] = firstSize
+	h[1], h[2] = 11, 1
+	h[5], h[6] = method, typ
+	le.PutUint16(h[8:], 0x6000)  // 12:00
+	le.PutUint16(h[10:], 0x2a55) // 21 Feb 2001
+	le.PutUint32(h[12:], uint32(len(packed)))
+	le.PutUint32(h[16:], uint32(size))
+	h = append(h, name+"\x00\x00"...) // and an empty comment
+
+	ret := []byte{0x60, 0xea}
+	ret = le.AppendUint16(ret, uint16(len(h)))
+	ret = append(ret, h...)
+	ret = append(ret, 0, 0, 0, 0) // CRC
+	ret = append(ret, 0, 0)       // no extended header
+	return append(ret, packed...)
+}
+
+func TestArchive(t *testing.T) {
+	// method 4: "ab" then a match of 4 from 2 back
+	var w bittest.Writer
+	w.Put(0, 1)
+	w.Put('a', 8)
+	w.Put(0, 1)
+	w.Put('b', 8)
+	w.Put(0b10, 2) // length code 1+1 = 2, so 4 bytes
+	w.Put(1, 1)
+	w.Put(0, 1) // distance in 9 bits: 1, so from 2 back
+	w.Put(1, 9)
+
+	var ar []byte
+	ar = append(ar, header(typeMain, 0, "TEST.ARJ", nil, 0)...)
+	ar = append(ar, header(typeDir, 0, "DIR", nil, 0)...)
+	ar = append(ar, header(typeBinary, 0, `DIR\STORED.TXT`, []byte("hello"), 5)...)
+	ar = append(ar, header(typeBinary, 4, "FASTEST.TXT", w.Bytes(), 6)...)
+	ar = append(ar, 0x60, 0xea, 0, 0)
+
+	if !IsArchive(ar) {
+		t.Fatal("IsArchive failed")
+	}
+	fsys, err := New(bytes.NewReader(ar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"DIR/STORED.TXT": "hello",
+		"FASTEST.TXT":    "ababab",
+	}
+	for name, content := range want {
+		got, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if string(got) != content {
+			t.Errorf("%s: got %q, want %q", name, got, content)
+		}
+	}
+	stat, err := fs.Stat(fsys, "FASTEST.TXT")
+	if err != nil {
+		t.Fatal(err)
+	} else if want := time.Date(2001, 2, 21, 12, 0, 0, 0, time.UTC); !stat.ModTime().Equal(want) {
+		t.Errorf("got time %v, want %v", stat.ModTime(), want)
+	}
+	if err := fstest.TestFS(fsys, "DIR/STORED.TXT", "FASTEST.TXT"); err != nil {
+		t.Error(err)
+	}
+}
+
+// sample.arj has the header and file CRCs that ARJ writes, and members stored and packed by methods 1 and 4
+func TestSample(t *testing.T) {
+	ar, err := os.ReadFile("testdata/sample.arj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := New(bytes.NewReader(ar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"DIR/STORED.TXT": "hello\r\n",
+		"FASTEST.TXT":    "ababab",
+		"METHOD1.TXT":    "abcabc",
+	} {
+		got, err := fs.ReadFile(fsys, name)
+		if err != nil || string(got) != want {
+			t.Errorf("%s: got %q, %v", name, got, err)
+		}
+	}
+	if err := fstest.TestFS(fsys, "DIR/STORED.TXT", "FASTEST.TXT", "METHOD1.TXT"); err != nil {
+		t.Error(err)
+	}
+}
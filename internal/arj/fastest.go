@@ -0,0 +1,116 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package arj
+
+import (
+	"bufio"
+	"io"
+)
+
+const (
+	threshold = 3
+	dictSize  = 26624
+)
+
+// decodeFastest undoes method 4, an LZ77 variant with no Huffman tables,
+// whose lengths and distances are each a unary bit width followed by that many bits
+func decodeFastest(src io.Reader, size int64) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go fastestcopy(pw, src, size)
+	return pr
+}
+
+func fastestcopy(dst *io.PipeWriter, src io.Reader, size int64) {
+	var reterr error
+	bw := bufio.NewWriterSize(dst, 4096)
+	defer func() {
+		if reterr == nil {
+			reterr = bw.Flush()
+		}
+		dst.CloseWithError(reterr)
+	}()
+
+	br := bitReader{r: bufio.NewReaderSize(src, 4096)}
+	window := make([]byte, dictSize)
+	pos := 0
+	put := func(b byte) bool {
+		window[pos] = b
+		pos++
+		if pos == dictSize {
+			pos = 0
+		}
+		if reterr = bw.WriteByte(b); reterr != nil {
+			return false
+		}
+		size--
+		return true
+	}
+
+	for size > 0 {
+		c := br.variable(0, 7)
+		if br.err != nil {
+			reterr = br.err
+			return
+		}
+		if c == 0 {
+			if !put(byte(br.bits(8))) {
+				return
+			}
+			continue
+		}
+		n := c - 1 + threshold
+		from := pos - br.variable(9, 13) - 1
+		if from < 0 {
+			from += dictSize
+		}
+		for ; n > 0 && size > 0; n-- {
+			if !put(window[from]) {
+				return
+			}
+			from++
+			if from == dictSize {
+				from = 0
+			}
+		}
+	}
+}
+
+// bitReader reads most significant bit first, and pads the end of the stream with zeros
+type bitReader struct {
+	r     io.ByteReader
+	buf   uint32
+	n     int
+	extra int
+	err   error
+}
+
+func (br *bitReader) bits(n int) int {
+	for br.n < n {
+		b, err := br.r.ReadByte()
+		if err != nil {
+			br.extra++
+			if br.extra > 4 {
+				br.err = io.ErrUnexpectedEOF
+			}
+		}
+		br.buf = br.buf<<8 | uint32(b)
+		br.n += 8
+	}
+	br.n -= n
+	return int(br.buf >> br.n & (1<<n - 1))
+}
+
+// variable reads a number whose width, from start to stop bits, is given by a prefix of 1 bits
+func (br *bitReader) variable(start, stop int) int {
+	plus, pwr := 0, 1<<start
+	width := start
+	for ; width < stop; width++ {
+		if br.bits(1) == 0 {
+			break
+		}
+		plus += pwr
+		pwr <<= 1
+	}
+	return plus + br.bits(width)
+}
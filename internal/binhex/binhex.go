@@ -0,0 +1,228 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+// Package binhex decodes BinHex 4.0, as described in Peter Lewis's "BinHex 4.0 Definition":
+// a header and both forks, each followed by a CRC, run-length compressed and then written six bits to a character.
+package binhex
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/elliotnunn/BeHierarchic/internal/crc16"
+	"golang.org/x/text/encoding/charmap"
+)
+
+var ErrFormat = errors.New("not a valid BinHex 4.0 file")
+
+const (
+	marker   = "(This file must be converted with BinHex"
+	alphabet = "!\"#$%&'()*+,-012345689@ABCDEFGHIJKLMNPQRSTUVXYZ[`abcdefhijklmpqr"
+	rleFlag  = 0x90
+)
+
+var values = func() (v [256]byte) {
+	for i := range v {
+		v[i] = 0xff
+	}
+	for i := range len(alphabet) {
+		v[alphabet[i]] = byte(i)
+	}
+	return
+}()
+
+// IsBinHex looks for the line that must precede the encoded data, which mail headers and the like may precede
+func IsBinHex(head []byte) bool {
+	return bytes.Contains(head, []byte(marker))
+}
+
+// decoder undoes the six-bit encoding and then the run-length compression
+type decoder struct {
+	r       *bufio.Reader
+	started bool
+	acc     uint32
+	nbits   int
+	last    byte
+	repeat  int
+}
+
+// NewReader returns the decoded stream, which begins with the header
+func NewReader(r io.Reader) io.Reader {
+	return &decoder{r: bufio.NewReader(r)}
+}
+
+// start skips to the colon that opens the data, after the marker, whatever the line endings
+func (d *decoder) start() error {
+	for matched := 0; matched < len(marker); {
+		c, err := d.r.ReadByte()
+		if err == io.EOF {
+			return ErrFormat
+		} else if err != nil {
+			return err
+		}
+		switch {
+		case c == marker[matched]:
+			matched++
+		case c == marker[0]:
+			matched = 1
+		default:
+			matched = 0
+		}
+	}
+	if _, err := d.r.ReadSlice(':'); err == io.EOF {
+		return ErrFormat
+	} else if err != nil && err != bufio.ErrBufferFull {
+		return err
+	}
+	d.started = true
+	return nil
+}
+
+// byte8 returns the next byte of the run-length compressed stream
+func (d *decoder) byte8() (byte, error) {
+	for d.nbits < 8 {
+		c, err := d.r.ReadByte()
+		if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF // the closing colon is missing
+		} else if err != nil {
+			return 0, err
+		}
+		switch {
+		case c == ':':
+			d.r.UnreadByte() // and stay at the end
+			return 0, io.EOF
+		case c == '\r' || c == '\n' || c == ' ' || c == '\t':
+			continue
+		case values[c] == 0xff:
+			return 0, ErrFormat
+		}
+		d.acc = d.acc<<6 | uint32(values[c])
+		d.nbits += 6
+	}
+	d.nbits -= 8
+	return byte(d.acc >> d.nbits), nil
+}
+
+func (d *decoder) Read(p []byte) (n int, err error) {
+	if !d.started {
+		if err := d.start(); err != nil {
+			return 0, err
+		}
+	}
+	for n < len(p) {
+		if d.repeat > 0 {
+			p[n] = d.last
+			n++
+			d.repeat--
+			continue
+		}
+		b, err := d.byte8()
+		if err != nil {
+			return n, err
+		}
+		if b == rleFlag {
+			count, err := d.byte8()
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			if err != nil {
+				return n, err
+			}
+			if count == 0 {
+				d.last = rleFlag // a literal
+			} else if count > 1 {
+				d.repeat = int(count) - 2 // including the copy about to be written
+			} else {
+				continue // a run of one is just the byte already written
+			}
+			p[n] = d.last
+			n++
+			continue
+		}
+		p[n] = b
+		d.last = b
+		n++
+	}
+	return n, nil
+}
+
+type Header struct {
+	Name          string
+	Type, Creator [4]byte
+	Flags         uint16
+	DataLen       int64
+	RsrcLen       int64
+}
+
+// Result says which of the three CRCs matched. A CRC that could not be reached is not OK.
+type Result struct {
+	Header
+	HeaderOK, DataOK, RsrcOK bool
+}
+
+// readHeader reads the header that begins the decoded stream, and returns its length
+func readHeader(br *bufio.Reader) (hdr Header, length int64, ok bool, err error) {
+	be := binary.BigEndian
+	nameLen, err := br.ReadByte()
+	if err != nil {
+		return hdr, 0, false, eof(err)
+	} else if nameLen == 0 || nameLen > 63 {
+		return hdr, 0, false, ErrFormat
+	}
+	h := make([]byte, 1+int(nameLen)+1+4+4+2+4+4+2)
+	h[0] = nameLen
+	if _, err := io.ReadFull(br, h[1:]); err != nil {
+		return hdr, 0, false, eof(err)
+	}
+	f := h[1+nameLen+1:]
+	hdr.Name, _ = charmap.Macintosh.NewDecoder().String(string(h[1:][:nameLen]))
+	hdr.Type, hdr.Creator = [4]byte(f[0:4]), [4]byte(f[4:8])
+	hdr.Flags = be.Uint16(f[8:])
+	hdr.DataLen, hdr.RsrcLen = int64(be.Uint32(f[10:])), int64(be.Uint32(f[14:]))
+	ok = crc16.Checksum(h[:len(h)-2]) == be.Uint16(f[18:])
+	return hdr, int64(len(h)), ok, nil
+}
+
+// Verify decodes a whole file to check its CRCs, returning [io.ErrUnexpectedEOF] if it is truncated
+func Verify(r io.Reader) (res Result, err error) {
+	br := bufio.NewReader(NewReader(r))
+	be := binary.BigEndian
+
+	res.Header, _, res.HeaderOK, err = readHeader(br)
+	if err != nil {
+		return res, err
+	}
+
+	for _, fork := range []struct {
+		size int64
+		ok   *bool
+	}{{res.DataLen, &res.DataOK}, {res.RsrcLen, &res.RsrcOK}} {
+		h := crcWriter{}
+		if _, err := io.CopyN(&h, br, fork.size); err != nil {
+			return res, eof(err)
+		}
+		var sum [2]byte
+		if _, err := io.ReadFull(br, sum[:]); err != nil {
+			return res, eof(err)
+		}
+		*fork.ok = h.crc == be.Uint16(sum[:])
+	}
+	return res, nil
+}
+
+func eof(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+type crcWriter struct{ crc uint16 }
+
+func (w *crcWriter) Write(p []byte) (int, error) {
+	w.crc = crc16.Update(w.crc, p)
+	return len(p), nil
+}
@@ -0,0 +1,134 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package binhex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/elliotnunn/BeHierarchic/internal/checksumreader"
+	"github.com/elliotnunn/BeHierarchic/internal/crc16"
+)
+
+// encode writes the six-bit encoding of an already run-length compressed stream
+func encode(stream []byte) string {
+	var sb strings.Builder
+	sb.WriteString("(This file must be converted with BinHex 4.0)\r\r:")
+	var acc uint32
+	nbits := 0
+	for i, b := range stream {
+		acc = acc<<8 | uint32(b)
+		nbits += 8
+		for nbits >= 6 {
+			nbits -= 6
+			sb.WriteByte(alphabet[acc>>nbits&63])
+		}
+		if i%48 == 47 {
+			sb.WriteString("\r")
+		}
+	}
+	if nbits > 0 {
+		sb.WriteByte(alphabet[acc<<(6-nbits)&63])
+	}
+	sb.WriteString(":\r")
+	return sb.String()
+}
+
+// fixture has a data fork of "AAAAB" (compressed as a run) and an empty resource fork
+func fixture() []byte {
+	be := binary.BigEndian
+	h := []byte{6}
+	h = append(h, "ReadMe"...)
+	h = append(h, 0)
+	h = append(h, "TEXTttxt"...)
+	h = be.AppendUint16(h, 0)
+	h = be.AppendUint32(h, 6)
+	h = be.AppendUint32(h, 0)
+	h = be.AppendUint16(h, crc16.Checksum(h))
+	data := []byte("AAAA\x90B")
+	h = append(h, 'A', rleFlag, 4, rleFlag, 0, 'B')
+	h = be.AppendUint16(h, crc16.Checksum(data))
+	h = be.AppendUint16(h, 0)
+	return h
+}
+
+func TestDecode(t *testing.T) {
+	got, err := io.ReadAll(NewReader(strings.NewReader("From: someone\r\n" + encode(fixture()))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(got, []byte("AAAA\x90B")) {
+		t.Errorf("expected the run-length compression undone, got %q", got)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	res, err := Verify(strings.NewReader(encode(fixture())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.HeaderOK || !res.DataOK || !res.RsrcOK {
+		t.Errorf("expected every CRC to match, got %+v", res)
+	}
+	if res.Name != "ReadMe" || string(res.Type[:]) != "TEXT" || res.DataLen != 6 {
+		t.Errorf("wrong header %+v", res.Header)
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	f := fixture()
+	f[len(f)-5] = 'C' // the final data byte
+	res, err := Verify(strings.NewReader(encode(f)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.HeaderOK || res.DataOK || !res.RsrcOK {
+		t.Errorf("expected only the data fork CRC to mismatch, got %+v", res)
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	s := encode(fixture())
+	_, err := Verify(strings.NewReader(s[:len(s)-12]))
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestFS(t *testing.T) {
+	r := strings.NewReader(encode(fixture()))
+	fsys, err := New2(r, r, "fallback", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := fs.ReadFile(fsys, "ReadMe")
+	if err != nil {
+		t.Fatal(err)
+	} else if string(got) != "AAAA\x90B" {
+		t.Errorf("got %q", got)
+	}
+	if err := fstest.TestFS(fsys, "ReadMe", "._ReadMe"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFSCorrupt(t *testing.T) {
+	f := fixture()
+	f[len(f)-5] = 'C' // the final data byte
+	r := strings.NewReader(encode(f))
+	fsys, err := New2(r, r, "fallback", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.ReadFile(fsys, "ReadMe"); !errors.Is(err, checksumreader.ErrChecksum) {
+		t.Errorf("expected a checksum error, got %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package binhex
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/elliotnunn/BeHierarchic/internal/appledouble"
+	"github.com/elliotnunn/BeHierarchic/internal/checksumreader"
+	"github.com/elliotnunn/BeHierarchic/internal/crc16"
+	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
+)
+
+// Entry IDs
+const (
+	dataFork = 1
+	sidecar  = 2
+)
+
+// New2 presents the data fork under the name in the header, or failing that the given name,
+// and the resource fork and Finder information in the sidecar beside it.
+// BinHex has no dates, so both have the given modtime.
+// Either fork must be decoded from the start of the file, so reading one fails if its CRC does not match.
+func New2(headerReader, dataReader io.ReaderAt, name string, mtime time.Time) (fs.FS, error) {
+	hdr, hdrLen, ok, err := readHeader(bufio.NewReader(NewReader(io.NewSectionReader(headerReader, 0, math.MaxInt64))))
+	if err == io.ErrUnexpectedEOF || err == nil && !ok {
+		return nil, ErrFormat
+	} else if err != nil {
+		return nil, err
+	}
+	if s := strings.ReplaceAll(hdr.Name, "/", ":"); fs.ValidPath(s) {
+		name = s
+	}
+
+	var meta appledouble.AppleDouble
+	meta.ModTime = mtime
+	var finfo [16]byte
+	copy(finfo[0:], hdr.Type[:])
+	copy(finfo[4:], hdr.Creator[:])
+	binary.BigEndian.PutUint16(finfo[8:], hdr.Flags)
+	meta.LoadFInfo(&finfo)
+
+	fork := func(skip, size int64) func() (io.ReadCloser, error) {
+		return func() (io.ReadCloser, error) {
+			br := bufio.NewReader(NewReader(io.NewSectionReader(dataReader, 0, math.MaxInt64)))
+			if _, err := io.CopyN(io.Discard, br, skip); err != nil {
+				return nil, eof(err)
+			}
+			return &forkReader{r: br, left: size}, nil
+		}
+	}
+
+	fsys := fskeleton.New()
+	fsys.CreateReadCloser(name, dataFork, fork(hdrLen, hdr.DataLen), hdr.DataLen, 0, mtime)
+	ad, adsize := meta.WithSequentialResourceFork(fork(hdrLen+hdr.DataLen+2, hdr.RsrcLen), hdr.RsrcLen)
+	fsys.CreateReadCloser(appledouble.Sidecar(name), sidecar, ad, adsize, 0, mtime)
+	fsys.NoMore()
+	return fsys, nil
+}
+
+// forkReader checks the CRC that follows the fork
+type forkReader struct {
+	r    *bufio.Reader
+	left int64
+	crc  uint16
+	err  error
+}
+
+func (f *forkReader) Read(p []byte) (int, error) {
+	if f.left == 0 {
+		if f.err == nil {
+			f.err = f.check()
+		}
+		return 0, f.err
+	}
+	if int64(len(p)) > f.left {
+		p = p[:f.left]
+	}
+	n, err := f.r.Read(p)
+	f.crc = crc16.Update(f.crc, p[:n])
+	f.left -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (f *forkReader) check() error {
+	var sum [2]byte
+	if _, err := io.ReadFull(f.r, sum[:]); err != nil {
+		return eof(err)
+	}
+	if binary.BigEndian.Uint16(sum[:]) != f.crc {
+		return checksumreader.ErrChecksum
+	}
+	return io.EOF
+}
+
+func (f *forkReader) Close() error { return nil }
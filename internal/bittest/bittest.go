@@ -0,0 +1,28 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+// Package bittest packs bit fields most significant first,
+// as the LZH family of compressors writes them, for the tests of their decompressors.
+package bittest
+
+// Writer appends bit fields to a byte slice, leaving the last byte zero-padded
+type Writer struct {
+	b []byte
+	n int
+}
+
+// Put appends the low n bits of v
+func (w *Writer) Put(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if w.n%8 == 0 {
+			w.b = append(w.b, 0)
+		}
+		if v>>i&1 != 0 {
+			w.b[len(w.b)-1] |= 0x80 >> (w.n % 8)
+		}
+		w.n++
+	}
+}
+
+// Bytes returns the bits so far
+func (w *Writer) Bytes() []byte { return w.b }
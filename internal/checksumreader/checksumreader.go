@@ -0,0 +1,103 @@
+// Copyright Elliot Nunn. Portions copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package checksumreader checks the contents of an archive member against the checksum that the archive records,
+// whether the member is read sequentially or, awkwardly, at random.
+package checksumreader
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+	"io"
+	"sync"
+)
+
+var ErrChecksum = errors.New("checksum error")
+
+// New wraps an [io.Reader] or [io.ReadCloser] of size bytes, returning [ErrChecksum] at the end if the hash is not want.
+// If want is nil then nothing is checked. Otherwise matched (if not nil) is called when the checksum matches.
+func New(r io.Reader, size int64, h hash.Hash, want []byte, matched func()) io.ReadCloser {
+	rc, ok := r.(io.ReadCloser)
+	if !ok {
+		rc = io.NopCloser(r)
+	}
+	return &reader{rc: rc, remain: size, want: want, hash: h, matched: matched}
+}
+
+type reader struct {
+	rc      io.ReadCloser
+	remain  int64
+	want    []byte
+	hash    hash.Hash // nil means hash check failed
+	matched func()
+}
+
+func (r *reader) Read(b []byte) (n int, err error) {
+	if r.hash == nil {
+		return 0, ErrChecksum
+	}
+	n, err = r.rc.Read(b)
+	r.hash.Write(b[:n])
+	r.remain -= int64(n)
+	if r.remain == 0 && r.want != nil {
+		if !bytes.Equal(r.hash.Sum(nil), r.want) {
+			r.hash = nil
+			return n, ErrChecksum
+		} else if r.matched != nil {
+			r.matched()
+			r.matched = nil
+		}
+	}
+	return
+}
+
+func (r *reader) Close() error { return r.rc.Close() }
+
+// NewAt wraps an [io.ReaderAt] of size bytes so that, if it is read from start to finish,
+// the final read returns [ErrChecksum] if the hash is not want.
+// Reads out of order are not checked, unless they happen to continue where the checked part left off.
+// If want is nil then nothing is checked. Otherwise matched (if not nil) is called when the checksum matches.
+func NewAt(r io.ReaderAt, size int64, h hash.Hash, want []byte, matched func()) io.ReaderAt {
+	return &readerAt{r: r, size: size, want: want, hash: h, matched: matched}
+}
+
+type readerAt struct {
+	mu       sync.Mutex
+	r        io.ReaderAt
+	size     int64
+	progress int64
+	want     []byte    // nil if all is well
+	hash     hash.Hash // nil means hash check complete
+	matched  func()
+}
+
+func (r *readerAt) ReadAt(p []byte, off int64) (n int, err error) {
+	n, err = r.r.ReadAt(p, off)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.hash != nil && r.want != nil { // still hashing, try to absorb this data
+		if off <= r.progress && off+int64(n) > r.progress {
+			r.hash.Write(p[r.progress-off : n])
+			r.progress = off + int64(n)
+		}
+
+		if r.progress == r.size {
+			if bytes.Equal(r.hash.Sum(nil), r.want) {
+				r.want = nil
+				if r.matched != nil {
+					r.matched()
+				}
+			}
+			r.hash = nil
+		}
+	}
+
+	if r.hash == nil && r.want != nil && off+int64(n) == r.size {
+		err = ErrChecksum
+	}
+	return n, err
+}
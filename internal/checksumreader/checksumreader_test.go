@@ -0,0 +1,60 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package checksumreader
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"strings"
+	"testing"
+)
+
+const data = "the quick brown fox jumps over the lazy dog"
+
+func crc(s string) []byte { return binary.BigEndian.AppendUint32(nil, crc32.ChecksumIEEE([]byte(s))) }
+
+func TestSequential(t *testing.T) {
+	matched := false
+	r := New(strings.NewReader(data), int64(len(data)), crc32.NewIEEE(), crc(data), func() { matched = true })
+	got, err := io.ReadAll(r)
+	if err != nil || string(got) != data || !matched {
+		t.Errorf("got %q, %v, matched=%v", got, err, matched)
+	}
+
+	matched = false
+	r = New(strings.NewReader(data), int64(len(data)), crc32.NewIEEE(), crc("something else"), func() { matched = true })
+	_, err = io.ReadAll(r)
+	if !errors.Is(err, ErrChecksum) || matched {
+		t.Errorf("got %v, matched=%v, want ErrChecksum", err, matched)
+	}
+
+	r = New(strings.NewReader(data), int64(len(data)), crc32.NewIEEE(), nil, nil)
+	if _, err = io.ReadAll(r); err != nil {
+		t.Errorf("unchecked read: %v", err)
+	}
+}
+
+func TestRandomAccess(t *testing.T) {
+	matched := false
+	r := NewAt(strings.NewReader(data), int64(len(data)), crc32.NewIEEE(), crc(data), func() { matched = true })
+	buf := make([]byte, 10)
+	r.ReadAt(buf, 20)                                       // out of order, not absorbed
+	for off := int64(0); off < int64(len(data)); off += 7 { // overlapping reads
+		n := min(10, len(data)-int(off))
+		if _, err := r.ReadAt(buf[:n], off); err != nil && err != io.EOF {
+			t.Fatalf("ReadAt %d: %v", off, err)
+		}
+	}
+	if !matched {
+		t.Error("checksum not matched after reading the whole file")
+	}
+
+	r = NewAt(strings.NewReader(data), int64(len(data)), crc32.NewIEEE(), crc("something else"), nil)
+	buf = make([]byte, len(data))
+	if _, err := r.ReadAt(buf, 0); !errors.Is(err, ErrChecksum) {
+		t.Errorf("got %v, want ErrChecksum", err)
+	}
+}
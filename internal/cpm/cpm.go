@@ -0,0 +1,309 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+// Package cpm reads the file systems of CP/M 2.2 and 3 from flat disk images.
+//
+// A CP/M disk says nothing about its own geometry, which the BIOS of each machine knew,
+// so the image is tried against a table of common formats of the right size,
+// and a format is accepted only if every entry of its directory makes sense.
+// Files in user area 0 are at the root, and if other user areas are in use
+// then every user area is a subdirectory named by its number.
+package cpm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
+	"github.com/elliotnunn/BeHierarchic/internal/multireaderat"
+	"github.com/elliotnunn/BeHierarchic/internal/sectionreader"
+)
+
+var ErrFormat = errors.New("not a CP/M disk image of any known format")
+
+// Format is what a CP/M BIOS knows about a disk: its geometry and its disk parameter block
+type Format struct {
+	Name       string
+	SectorSize int
+	Sectors    int // per track, counting both sides of a double-sided disk
+	Tracks     int // counting both sides
+	BlockSize  int // 1024 to 16384
+	DirEntries int
+	BootTracks int // reserved before the directory
+	Skew       int // between logical sectors, or zero if the sectors are in order
+}
+
+// Formats are tried in order, so where two are the same size the likelier is first.
+// More can be added with [ParseFormat].
+var Formats = []Format{
+	{Name: "ibm-3740", SectorSize: 128, Sectors: 26, Tracks: 77, BlockSize: 1024, DirEntries: 64, BootTracks: 2, Skew: 6},
+	{Name: "kaypro2", SectorSize: 512, Sectors: 10, Tracks: 40, BlockSize: 1024, DirEntries: 64, BootTracks: 1},
+	{Name: "osborne1", SectorSize: 1024, Sectors: 5, Tracks: 40, BlockSize: 1024, DirEntries: 64, BootTracks: 3},
+	{Name: "kaypro4", SectorSize: 512, Sectors: 10, Tracks: 80, BlockSize: 2048, DirEntries: 64, BootTracks: 1},
+	{Name: "cpcdata", SectorSize: 512, Sectors: 9, Tracks: 40, BlockSize: 1024, DirEntries: 64, BootTracks: 0},
+	{Name: "cpcsystem", SectorSize: 512, Sectors: 9, Tracks: 40, BlockSize: 1024, DirEntries: 64, BootTracks: 2},
+	{Name: "pcw", SectorSize: 512, Sectors: 9, Tracks: 40, BlockSize: 1024, DirEntries: 64, BootTracks: 1},
+}
+
+// ParseFormat reads NAME:SECTORSIZE:SECTORS:TRACKS:BLOCKSIZE:DIRENTRIES:BOOTTRACKS[:SKEW]
+func ParseFormat(s string) (Format, error) {
+	fields := strings.Split(s, ":")
+	if len(fields) != 7 && len(fields) != 8 || fields[0] == "" {
+		return Format{}, fmt.Errorf("%s: expected NAME:SECTORSIZE:SECTORS:TRACKS:BLOCKSIZE:DIRENTRIES:BOOTTRACKS[:SKEW]", s)
+	}
+	f := Format{Name: fields[0]}
+	for i, p := range []*int{&f.SectorSize, &f.Sectors, &f.Tracks, &f.BlockSize, &f.DirEntries, &f.BootTracks, &f.Skew} {
+		if i+1 == len(fields) {
+			break
+		}
+		n, err := strconv.Atoi(fields[i+1])
+		if err != nil || n < 0 {
+			return Format{}, fmt.Errorf("%s: %q is not a number", s, fields[i+1])
+		}
+		*p = n
+	}
+	if f.SectorSize < 128 || f.SectorSize&(f.SectorSize-1) != 0 || f.Sectors == 0 || f.Tracks <= f.BootTracks ||
+		f.BlockSize < 1024 || f.BlockSize > 16384 || f.BlockSize&(f.BlockSize-1) != 0 ||
+		f.DirEntries == 0 || f.DirEntries*entrySize > 16*f.BlockSize || f.Skew >= f.Sectors {
+		return Format{}, fmt.Errorf("%s: not a possible disk parameter block", s)
+	}
+	return f, nil
+}
+
+const entrySize = 32
+
+// Size is of a whole image
+func (f Format) Size() int64 { return int64(f.SectorSize) * int64(f.Sectors) * int64(f.Tracks) }
+
+func (f Format) blocks() int {
+	return (f.Tracks - f.BootTracks) * f.Sectors * f.SectorSize / f.BlockSize
+}
+
+func (f Format) dirBlocks() int {
+	return (f.DirEntries*entrySize + f.BlockSize - 1) / f.BlockSize
+}
+
+// sectorOffset is where a logical sector after the boot tracks lies in the image, allowing for the skew
+func (f Format) sectorOffset(logical int) int64 {
+	track, s := f.BootTracks+logical/f.Sectors, logical%f.Sectors
+	if f.Skew != 0 {
+		s = f.skewTable()[s]
+	}
+	return (int64(track)*int64(f.Sectors) + int64(s)) * int64(f.SectorSize)
+}
+
+func (f Format) skewTable() []int {
+	t := make([]int, f.Sectors)
+	used := make([]bool, f.Sectors)
+	p := 0
+	for i := range t {
+		for used[p] {
+			p = (p + 1) % f.Sectors
+		}
+		t[i], used[p] = p, true
+		p = (p + f.Skew) % f.Sectors
+	}
+	return t
+}
+
+// blockReader returns the bytes of some blocks, in order, as one reader
+func (f Format) blockReader(r io.ReaderAt, blocks []int) multireaderat.SizeReaderAt {
+	var parts []multireaderat.SizeReaderAt
+	var runStart, runEnd int64 = -1, -1
+	perBlock := f.BlockSize / f.SectorSize
+	for _, b := range blocks {
+		for i := range perBlock {
+			off := f.sectorOffset(b*perBlock + i)
+			if off == runEnd {
+				runEnd += int64(f.SectorSize)
+				continue
+			}
+			if runStart >= 0 {
+				parts = append(parts, sectionreader.Section(r, runStart, runEnd-runStart))
+			}
+			runStart, runEnd = off, off+int64(f.SectorSize)
+		}
+	}
+	if runStart >= 0 {
+		parts = append(parts, sectionreader.Section(r, runStart, runEnd-runStart))
+	}
+	return multireaderat.New(parts...)
+}
+
+// Probe finds the first format of the right size whose directory makes sense.
+// If partial, as for a floppy image that left out the empty tracks at the end, the image may be smaller.
+func Probe(r io.ReaderAt, size int64, partial bool) (Format, bool) {
+	for _, f := range Formats {
+		if size != f.Size() && !(partial && size < f.Size()) {
+			continue
+		}
+		dir, err := f.readDir(r)
+		if err != nil {
+			continue
+		}
+		if n, ok := f.checkDir(dir); ok && n > 0 {
+			return f, true
+		}
+	}
+	return Format{}, false
+}
+
+func (f Format) readDir(r io.ReaderAt) ([]byte, error) {
+	blocks := make([]int, f.dirBlocks())
+	for i := range blocks {
+		blocks[i] = i
+	}
+	dir := make([]byte, f.DirEntries*entrySize)
+	_, err := f.blockReader(r, blocks).ReadAt(dir, 0)
+	return dir, err
+}
+
+// checkDir counts the files and insists that every entry is plausible
+func (f Format) checkDir(dir []byte) (files int, ok bool) {
+	for e := range slices.Chunk(dir, entrySize) {
+		switch {
+		case e[0] == 0xe5: // unused
+			continue
+		case e[0] == 0x20 || e[0] == 0x21: // CP/M 3 disk label or timestamps
+			continue
+		case e[0] > 15:
+			return 0, false
+		}
+		for _, c := range e[1:12] {
+			if c&0x7f < ' ' || c&0x7f == 0x7f {
+				return 0, false
+			}
+		}
+		if e[1]&0x7f == ' ' || e[12] > 31 || e[14] > 63 || e[15] > 128 {
+			return 0, false
+		}
+		for _, b := range f.pointers(e) {
+			if b != 0 && (b < f.dirBlocks() || b >= f.blocks()) {
+				return 0, false
+			}
+		}
+		if extent(e) == 0 {
+			files++
+		}
+	}
+	return files, true
+}
+
+// pointers lists the blocks of an entry, 8-bit if the disk has few enough blocks and otherwise 16-bit
+func (f Format) pointers(e []byte) []int {
+	var ptrs []int
+	if f.blocks() <= 256 {
+		for _, b := range e[16:32] {
+			ptrs = append(ptrs, int(b))
+		}
+	} else {
+		for i := 16; i < 32; i += 2 {
+			ptrs = append(ptrs, int(binary.LittleEndian.Uint16(e[i:])))
+		}
+	}
+	return ptrs
+}
+
+func extent(e []byte) int { return int(e[14])<<5 | int(e[12]) }
+
+type file struct {
+	user    byte
+	name    string
+	entries [][]byte
+}
+
+// New2 presents the files on the disk
+func New2(headerReader, dataReader io.ReaderAt, f Format, mtime time.Time) (fs.FS, error) {
+	dir, err := f.readDir(headerReader)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := f.checkDir(dir); !ok {
+		return nil, ErrFormat
+	}
+
+	var files []*file
+	index := make(map[string]*file)
+	users := make(map[byte]bool)
+	for e := range slices.Chunk(dir, entrySize) {
+		if e[0] > 15 {
+			continue
+		}
+		name := fileName(e[1:12])
+		key := string(e[:1]) + name
+		fl, ok := index[key]
+		if !ok {
+			fl = &file{user: e[0], name: name}
+			index[key] = fl
+			files = append(files, fl)
+			users[e[0]] = true
+		}
+		fl.entries = append(fl.entries, bytes.Clone(e))
+	}
+	subdirs := len(users) > 1 || !users[0] && len(users) > 0
+
+	fsys := fskeleton.New()
+	if subdirs {
+		for u := range byte(16) {
+			if users[u] {
+				fsys.Mkdir(strconv.Itoa(int(u)), int64(f.DirEntries+int(u)), 0, mtime)
+			}
+		}
+	}
+	for i, fl := range files {
+		slices.SortStableFunc(fl.entries, func(a, b []byte) int { return extent(a) - extent(b) })
+		var blocks []int
+		for _, e := range fl.entries {
+			for _, b := range f.pointers(e) {
+				if b != 0 {
+					blocks = append(blocks, b)
+				}
+			}
+		}
+		last := fl.entries[len(fl.entries)-1]
+		size := (int64(extent(last))*128 + int64(last[15])) * 128
+		if lrbc := int64(last[13]); lrbc != 0 && lrbc < 128 && last[15] != 0 { // CP/M 3 last record byte count
+			size -= 128 - lrbc
+		}
+		data := f.blockReader(dataReader, blocks)
+		size = min(size, data.Size())
+
+		name := fl.name
+		if !fs.ValidPath(name) {
+			continue
+		}
+		if subdirs {
+			name = strconv.Itoa(int(fl.user)) + "/" + name
+		}
+		fsys.CreateReaderAt(name, int64(i), sectionreader.Section(data, 0, size), size, 0, mtime)
+	}
+	fsys.NoMore()
+	return fsys, nil
+}
+
+// fileName makes NAME.EXT from the 8+3 bytes of an entry, without the attribute bits
+func fileName(b []byte) string {
+	clean := func(b []byte) string {
+		s := make([]byte, len(b))
+		for i, c := range b {
+			s[i] = c & 0x7f
+			if s[i] == '/' {
+				s[i] = '_'
+			}
+		}
+		return strings.TrimRight(string(s), " ")
+	}
+	name, ext := clean(b[:8]), clean(b[8:11])
+	if ext != "" {
+		name += "." + ext
+	}
+	return name
+}
@@ -0,0 +1,121 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package cpm
+
+import (
+	"bytes"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func entry(user byte, name string, ext, rc byte, blocks ...byte) []byte {
+	e := make([]byte, entrySize)
+	e[0] = user
+	copy(e[1:12], name)
+	e[12], e[15] = ext, rc
+	copy(e[16:], blocks)
+	return e
+}
+
+// image lays out a disk in logical order, then applies the format's skew as a real disk would have it
+func image(f Format, dir [][]byte, blocks map[int][]byte) []byte {
+	img := bytes.Repeat([]byte{0xe5}, int(f.Size()))
+	d := bytes.Repeat([]byte{0xe5}, f.DirEntries*entrySize)
+	for i, e := range dir {
+		copy(d[i*entrySize:], e)
+	}
+	put := func(block int, data []byte) {
+		per := f.BlockSize / f.SectorSize
+		for i := range per {
+			if off := i * f.SectorSize; off < len(data) {
+				copy(img[f.sectorOffset(block*per+i):][:f.SectorSize], data[off:])
+			}
+		}
+	}
+	for b := range f.dirBlocks() {
+		put(b, d[b*f.BlockSize:])
+	}
+	for b, data := range blocks {
+		put(b, data)
+	}
+	return img
+}
+
+func TestKaypro(t *testing.T) {
+	f := Formats[1]
+	big := bytes.Repeat([]byte("0123456789abcdef"), 20*1024/16)
+	blocks := map[int][]byte{}
+	var ptrs []byte
+	for i := range 20 {
+		blocks[2+i] = big[i*1024 : (i+1)*1024]
+		ptrs = append(ptrs, byte(2+i))
+	}
+	blocks[30] = []byte("hello from user 3\x1a")
+	img := image(f, [][]byte{
+		entry(0, "BIG     TXT", 1, 32, ptrs[16:]...), // second extent first, as a directory might
+		entry(0, "BIG     TXT", 0, 128, ptrs[:16]...),
+		entry(3, "HELLO   \xc4OC", 0, 1, 30), // with an attribute bit
+	}, blocks)
+
+	got, ok := Probe(bytes.NewReader(img), int64(len(img)), false)
+	if !ok || got.Name != "kaypro2" {
+		t.Fatalf("probed as %q %v", got.Name, ok)
+	}
+	fsys, err := New2(bytes.NewReader(img), bytes.NewReader(img), got, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := fs.ReadFile(fsys, "0/BIG.TXT"); err != nil || !bytes.Equal(data, big) {
+		t.Errorf("BIG.TXT: %d bytes, %v", len(data), err)
+	}
+	if data, err := fs.ReadFile(fsys, "3/HELLO.DOC"); err != nil || !bytes.HasPrefix(data, []byte("hello from user 3")) || len(data) != 128 {
+		t.Errorf("HELLO.DOC: %q, %v", data, err)
+	}
+	if err := fstest.TestFS(fsys, "0/BIG.TXT", "3/HELLO.DOC"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSkew(t *testing.T) {
+	f := Formats[0]
+	if s := f.skewTable(); s[0] != 0 || s[1] != 6 || s[5] != 4 || s[13] != 1 {
+		t.Errorf("wrong skew table %v", s)
+	}
+	data := bytes.Repeat([]byte("8-inch, "), 1024/8)
+	img := image(f, [][]byte{entry(0, "README     ", 0, 8, 2)}, map[int][]byte{2: data})
+	got, ok := Probe(bytes.NewReader(img), int64(len(img)), false)
+	if !ok || got.Name != "ibm-3740" {
+		t.Fatalf("probed as %q %v", got.Name, ok)
+	}
+	fsys, err := New2(bytes.NewReader(img), bytes.NewReader(img), got, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := fs.ReadFile(fsys, "README"); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("README: %q, %v", got, err)
+	}
+}
+
+func TestNotCPM(t *testing.T) {
+	img := bytes.Repeat([]byte("random garbage, not a directory "), int(Formats[1].Size())/32)
+	if _, ok := Probe(bytes.NewReader(img), int64(len(img)), false); ok {
+		t.Error("accepted garbage")
+	}
+	blank := bytes.Repeat([]byte{0xe5}, int(Formats[1].Size()))
+	if _, ok := Probe(bytes.NewReader(blank), int64(len(blank)), false); ok {
+		t.Error("accepted a blank disk, which has nothing to show")
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	f, err := ParseFormat("mine:256:16:80:2048:128:2:3")
+	if err != nil || f.Size() != 256*16*80 || f.Skew != 3 {
+		t.Errorf("got %+v, %v", f, err)
+	}
+	if _, err := ParseFormat("bad:100:16:80:2048:128:2"); err == nil {
+		t.Error("accepted a sector size that is not a power of two")
+	}
+}
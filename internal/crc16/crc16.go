@@ -0,0 +1,32 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+// Package crc16 implements the CCITT CRC-16 as XMODEM uses it (polynomial 0x1021, initial value zero),
+// which is also the checksum of MacBinary II and BinHex 4.0.
+package crc16
+
+var table = func() (t [256]uint16) {
+	for i := range t {
+		crc := uint16(i) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		t[i] = crc
+	}
+	return
+}()
+
+// Update adds p to a running checksum, which starts at zero
+func Update(crc uint16, p []byte) uint16 {
+	for _, b := range p {
+		crc = crc<<8 ^ table[byte(crc>>8)^b]
+	}
+	return crc
+}
+
+// Checksum returns the checksum of p
+func Checksum(p []byte) uint16 { return Update(0, p) }
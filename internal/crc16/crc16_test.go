@@ -0,0 +1,15 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package crc16
+
+import "testing"
+
+func TestCheck(t *testing.T) {
+	if got := Checksum([]byte("123456789")); got != 0x31c3 {
+		t.Errorf("expected the standard check value 0x31c3, got %#04x", got)
+	}
+	if got := Update(Checksum([]byte("1234")), []byte("56789")); got != 0x31c3 {
+		t.Errorf("expected incremental update to match, got %#04x", got)
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+// Package cue splits CD images into their tracks: the raw images described by a cue sheet,
+// and raw images of a single data track without one.
+// A data track becomes a .iso file of its 2048-byte user data, to be probed like any disk image,
+// and an audio track becomes a .wav file.
+package cue
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
+	"github.com/elliotnunn/BeHierarchic/internal/guard"
+	"github.com/elliotnunn/BeHierarchic/internal/multireaderat"
+	"github.com/elliotnunn/BeHierarchic/internal/sectionreader"
+)
+
+var ErrFormat = errors.New("not a valid cue sheet")
+
+const (
+	rawSector  = 2352
+	userData   = 2048
+	framesPerS = 75
+	maxSheet   = 64 * 1024 // far longer than 99 tracks need
+)
+
+// sync is the pattern that starts every raw data sector
+const sync = "\x00\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\x00"
+
+// IsRaw checks for a raw data sector at the start of an image
+func IsRaw(head []byte) bool {
+	return len(head) >= 16 && string(head[:12]) == sync && (head[15] == 1 || head[15] == 2)
+}
+
+// Opener opens a file named by a cue sheet, relative to the sheet
+type Opener func(name string) (io.ReaderAt, int64, error)
+
+type track struct {
+	num      int
+	mode     string // as in the cue sheet, e.g. "MODE1/2352"
+	file     string
+	bigEnd   bool // audio samples in "MOTOROLA" order
+	index0   int64
+	index1   int64 // in sectors from the start of the file
+	haveIdx0 bool
+}
+
+// New reads a cue sheet and opens the files it names
+func New(sheet io.Reader, open Opener, mtime time.Time) (retfs fs.FS, reterr error) {
+	defer guard.Recover(&reterr, "cue sheet", nil)
+	tracks, err := parse(io.LimitReader(sheet, maxSheet))
+	if err != nil {
+		return nil, err
+	}
+
+	type file struct {
+		r    io.ReaderAt
+		size int64
+		err  error
+	}
+	files := make(map[string]file)
+	fsys := fskeleton.New()
+	defer fsys.NoMore()
+	for i, t := range tracks {
+		f, ok := files[t.file]
+		if !ok {
+			f.r, f.size, f.err = open(t.file)
+			files[t.file] = f
+		}
+		secSize, dataOff := sectorLayout(t.mode)
+		name := fmt.Sprintf("Track %02d", t.num)
+		if t.mode == "AUDIO" {
+			name += ".wav"
+		} else {
+			name += ".iso"
+		}
+		if f.err != nil {
+			fsys.CreateError(name, int64(t.num), f.err, 0, 0, mtime)
+			continue
+		} else if secSize == 0 {
+			fsys.CreateError(name, int64(t.num), fmt.Errorf("cue: unsupported track mode %s", t.mode), 0, 0, mtime)
+			continue
+		}
+
+		// A track runs until the pregap of the next track in the same file
+		start := t.index1 * int64(secSize)
+		end := f.size
+		if i+1 < len(tracks) && tracks[i+1].file == t.file {
+			next := tracks[i+1]
+			if next.haveIdx0 {
+				end = next.index0 * int64(secSize)
+			} else {
+				end = next.index1 * int64(secSize)
+			}
+		}
+		end = min(end, f.size)
+		if end <= start {
+			fsys.CreateError(name, int64(t.num), ErrFormat, 0, 0, mtime)
+			continue
+		}
+		sectors := (end - start) / int64(secSize)
+
+		var r multireaderat.SizeReaderAt
+		if t.mode == "AUDIO" {
+			var pcm multireaderat.SizeReaderAt = sectionreader.Section(f.r, start, sectors*rawSector)
+			if t.bigEnd {
+				pcm = swapped{pcm}
+			}
+			r = multireaderat.New(bytes.NewReader(wavHeader(pcm.Size())), pcm)
+		} else {
+			r = userSectors(f.r, start, sectors, secSize, dataOff)
+		}
+		fsys.CreateReaderAt(name, int64(t.num), r, r.Size(), 0, mtime)
+	}
+	return fsys, nil
+}
+
+// NewRaw opens an image of a single data track in raw sectors
+func NewRaw(r io.ReaderAt, size int64, mtime time.Time) (fs.FS, error) {
+	head := make([]byte, 16)
+	if n, err := r.ReadAt(head, 0); n != len(head) {
+		return nil, err
+	} else if !IsRaw(head) {
+		return nil, ErrFormat
+	}
+	mode := fmt.Sprintf("MODE%d/2352", head[15])
+	_, dataOff := sectorLayout(mode)
+	data := userSectors(r, 0, size/rawSector, rawSector, dataOff)
+	fsys := fskeleton.New()
+	fsys.CreateReaderAt("Track 01.iso", 1, data, data.Size(), 0, mtime)
+	fsys.NoMore()
+	return fsys, nil
+}
+
+// sectorLayout gives the stored size of a sector and the offset of the user data within it
+func sectorLayout(mode string) (size, dataOff int) {
+	switch mode {
+	case "AUDIO":
+		return rawSector, 0
+	case "MODE1/2048", "MODE2/2048":
+		return userData, 0
+	case "MODE1/2352":
+		return rawSector, 16 // sync and header
+	case "MODE2/2352":
+		return rawSector, 24 // sync, header and XA subheader, assuming form 1
+	case "MODE2/2336":
+		return 2336, 8
+	}
+	return 0, 0
+}
+
+func parse(r io.Reader) ([]track, error) {
+	var tracks []track
+	var file string
+	var bigEnd bool
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(sc.Text(), "\ufeff"))
+		cmd, rest, _ := strings.Cut(line, " ")
+		rest = strings.TrimSpace(rest)
+		switch strings.ToUpper(cmd) {
+		case "FILE":
+			var kind string
+			if strings.HasPrefix(rest, `"`) {
+				end := strings.LastIndex(rest, `"`)
+				if end <= 0 {
+					return nil, ErrFormat
+				}
+				file, kind = rest[1:end], strings.TrimSpace(rest[end+1:])
+			} else {
+				file, kind, _ = strings.Cut(rest, " ")
+			}
+			bigEnd = strings.EqualFold(kind, "MOTOROLA")
+		case "TRACK":
+			numStr, mode, _ := strings.Cut(rest, " ")
+			num, err := strconv.Atoi(numStr)
+			if err != nil || file == "" {
+				return nil, ErrFormat
+			}
+			tracks = append(tracks, track{num: num, mode: strings.ToUpper(strings.TrimSpace(mode)), file: file, bigEnd: bigEnd})
+		case "INDEX":
+			if len(tracks) == 0 {
+				return nil, ErrFormat
+			}
+			numStr, msf, _ := strings.Cut(rest, " ")
+			num, err := strconv.Atoi(numStr)
+			if err != nil {
+				return nil, ErrFormat
+			}
+			frames, err := parseMSF(strings.TrimSpace(msf))
+			if err != nil {
+				return nil, err
+			}
+			t := &tracks[len(tracks)-1]
+			switch num {
+			case 0:
+				t.index0, t.haveIdx0 = frames, true
+			case 1:
+				t.index1 = frames
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	} else if len(tracks) == 0 {
+		return nil, ErrFormat
+	}
+	return tracks, nil
+}
+
+// parseMSF converts minutes:seconds:frames to a sector count
+func parseMSF(s string) (int64, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, ErrFormat
+	}
+	var n [3]int64
+	for i, p := range parts {
+		v, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return 0, ErrFormat
+		}
+		n[i] = int64(v)
+	}
+	return (n[0]*60+n[1])*framesPerS + n[2], nil
+}
+
+// wavHeader describes Red Book audio: 44.1 kHz, 16-bit, stereo
+func wavHeader(dataSize int64) []byte {
+	le := binary.LittleEndian
+	h := []byte("RIFF\x00\x00\x00\x00WAVEfmt ")
+	h = le.AppendUint32(h, 16)
+	h = le.AppendUint16(h, 1) // PCM
+	h = le.AppendUint16(h, 2)
+	h = le.AppendUint32(h, 44100)
+	h = le.AppendUint32(h, 44100*4)
+	h = le.AppendUint16(h, 4)
+	h = le.AppendUint16(h, 16)
+	h = append(h, "data"...)
+	h = le.AppendUint32(h, uint32(dataSize))
+	le.PutUint32(h[4:], uint32(len(h)-8)+uint32(dataSize))
+	return h
+}
+
+// swapped reverses the byte order of 16-bit samples
+type swapped struct{ multireaderat.SizeReaderAt }
+
+func (s swapped) ReadAt(p []byte, off int64) (int, error) {
+	start := off &^ 1
+	buf := make([]byte, (off+int64(len(p))-start+1)&^1)
+	n, err := s.SizeReaderAt.ReadAt(buf, start)
+	for i := 0; i+1 < n; i += 2 {
+		buf[i], buf[i+1] = buf[i+1], buf[i]
+	}
+	n = max(0, min(n-int(off-start), len(p)))
+	copy(p, buf[off-start:])
+	if n < len(p) && err == nil {
+		err = io.EOF
+	}
+	return n, err
+}
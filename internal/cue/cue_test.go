@@ -0,0 +1,109 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package cue
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func rawData(payload string) []byte {
+	sec := make([]byte, rawSector)
+	copy(sec, sync)
+	sec[15] = 1
+	copy(sec[16:], payload)
+	return sec
+}
+
+func mixedMode() []byte {
+	var bin []byte
+	bin = append(bin, rawData("first")...)
+	bin = append(bin, rawData("second")...)
+	for range 2 { // pregap of the audio track
+		bin = append(bin, make([]byte, rawSector)...)
+	}
+	bin = append(bin, bytes.Repeat([]byte{1, 2}, rawSector/2)...)
+	return bin
+}
+
+const sheet = `FILE "GAME.BIN" BINARY
+  TRACK 01 MODE1/2352
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    INDEX 00 00:00:02
+    INDEX 01 00:00:04
+`
+
+func TestMixedMode(t *testing.T) {
+	bin := mixedMode()
+	open := func(name string) (io.ReaderAt, int64, error) {
+		if name != "GAME.BIN" {
+			return nil, 0, fs.ErrNotExist
+		}
+		return bytes.NewReader(bin), int64(len(bin)), nil
+	}
+	fsys, err := New(strings.NewReader(sheet), open, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iso, err := fs.ReadFile(fsys, "Track 01.iso")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(iso) != 2*userData || !bytes.HasPrefix(iso, []byte("first")) || !bytes.HasPrefix(iso[userData:], []byte("second")) {
+		t.Errorf("wrong data track: %d bytes beginning %q", len(iso), iso[:8])
+	}
+
+	wav, err := fs.ReadFile(fsys, "Track 02.wav")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(wav) != 44+rawSector || string(wav[:4]) != "RIFF" || !bytes.Equal(wav[44:48], []byte{1, 2, 1, 2}) {
+		t.Errorf("wrong audio track: %d bytes beginning %q", len(wav), wav[:48])
+	}
+
+	if err := fstest.TestFS(fsys, "Track 01.iso", "Track 02.wav"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMotorola(t *testing.T) {
+	pcm := bytes.Repeat([]byte{1, 2}, rawSector/2)
+	open := func(name string) (io.ReaderAt, int64, error) { return bytes.NewReader(pcm), int64(len(pcm)), nil }
+	fsys, err := New(strings.NewReader("FILE x.bin MOTOROLA\nTRACK 01 AUDIO\nINDEX 01 00:00:00\n"), open, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := fsys.Open("Track 01.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, 3)
+	if _, err := f.(io.ReaderAt).ReadAt(got, 45); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(got, []byte{1, 2, 1}) {
+		t.Errorf("got % x, want 01 02 01", got)
+	}
+}
+
+func TestRaw(t *testing.T) {
+	bin := append(rawData("first"), rawData("second")...)
+	if !IsRaw(bin) {
+		t.Fatal("IsRaw failed")
+	}
+	fsys, err := NewRaw(bytes.NewReader(bin), int64(len(bin)), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	iso, err := fs.ReadFile(fsys, "Track 01.iso")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(iso) != 2*userData || !bytes.HasPrefix(iso[userData:], []byte("second")) {
+		t.Errorf("wrong data track: %d bytes", len(iso))
+	}
+}
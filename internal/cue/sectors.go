@@ -0,0 +1,52 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package cue
+
+import "io"
+
+// userSectors strips the headers and error correction from raw sectors
+func userSectors(r io.ReaderAt, start, count int64, size, dataOff int) *sectors {
+	return &sectors{r: r, start: start, count: count, size: int64(size), dataOff: int64(dataOff)}
+}
+
+type sectors struct {
+	r             io.ReaderAt
+	start, count  int64
+	size, dataOff int64
+}
+
+func (s *sectors) Size() int64 { return s.count * userData }
+
+func (s *sectors) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if s.size == userData && s.dataOff == 0 { // no gaps to skip
+		want := min(int64(len(p)), max(0, s.Size()-off))
+		n, err = s.r.ReadAt(p[:want], s.start+off)
+		if n == len(p) {
+			return n, nil
+		} else if err == nil {
+			err = io.EOF
+		}
+		return n, err
+	}
+	for n < len(p) {
+		sec, within := off/userData, off%userData
+		if sec >= s.count {
+			return n, io.EOF
+		}
+		chunk := p[n:min(len(p), n+int(userData-within))]
+		k, err := s.r.ReadAt(chunk, s.start+sec*s.size+s.dataOff+within)
+		n += k
+		off += int64(k)
+		if k < len(chunk) {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,113 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+// Package diskcopy reads the uncompressed floppy images of Disk Copy 4.2,
+// which ShrinkWrap, DiskDup and others also wrote: an 84-byte header, the raw blocks, then their tags.
+package diskcopy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
+	"github.com/elliotnunn/BeHierarchic/internal/sectionreader"
+	"golang.org/x/text/encoding/charmap"
+)
+
+var ErrFormat = errors.New("not a valid Disk Copy 4.2 image")
+
+const HeaderSize = 84
+
+type Header struct {
+	Name         string // of the volume, usually
+	DataSize     int64
+	TagSize      int64
+	DataChecksum uint32
+	TagChecksum  uint32
+	DiskFormat   byte // 0 = 400K, 1 = 800K, 2 = 720K, 3 = 1440K
+	FormatByte   byte
+}
+
+// ParseHeader checks the fields that are fixed or constrained, because there is no magic number
+func ParseHeader(h []byte) (Header, error) {
+	if len(h) < HeaderSize || h[0] == 0 || h[0] > 63 || string(h[82:84]) != "\x01\x00" || h[80] > 3 {
+		return Header{}, ErrFormat
+	}
+	be := binary.BigEndian
+	hdr := Header{
+		DataSize:     int64(be.Uint32(h[64:])),
+		TagSize:      int64(be.Uint32(h[68:])),
+		DataChecksum: be.Uint32(h[72:]),
+		TagChecksum:  be.Uint32(h[76:]),
+		DiskFormat:   h[80],
+		FormatByte:   h[81],
+	}
+	if hdr.DataSize == 0 || hdr.DataSize%512 != 0 || hdr.TagSize%12 != 0 {
+		return Header{}, ErrFormat
+	}
+	hdr.Name, _ = charmap.Macintosh.NewDecoder().String(string(h[1:][:h[0]]))
+	return hdr, nil
+}
+
+// Checksum is Disk Copy's own: add each big-endian word, then rotate the 32-bit sum right by one bit
+func Checksum(r io.Reader) (uint32, error) {
+	br := bufio.NewReader(r)
+	var sum uint32
+	var w [2]byte
+	for {
+		_, err := io.ReadFull(br, w[:])
+		if err == io.EOF {
+			return sum, nil
+		} else if err != nil {
+			return sum, err
+		}
+		sum += uint32(binary.BigEndian.Uint16(w[:]))
+		sum = sum>>1 | sum<<31
+	}
+}
+
+// Verify checks the data and tag checksums against the image.
+// The tag checksum famously leaves out the first 12 bytes of tags, and is zero if there are none.
+func (hdr Header) Verify(r io.ReaderAt) (dataOK, tagOK bool, err error) {
+	sum, err := Checksum(io.NewSectionReader(r, HeaderSize, hdr.DataSize))
+	if err != nil {
+		return false, false, err
+	}
+	dataOK = sum == hdr.DataChecksum
+	if hdr.TagSize == 0 {
+		return dataOK, hdr.TagChecksum == 0, nil
+	}
+	sum, err = Checksum(io.NewSectionReader(r, HeaderSize+hdr.DataSize+12, hdr.TagSize-12))
+	if err != nil {
+		return dataOK, false, err
+	}
+	return dataOK, sum == hdr.TagChecksum, nil
+}
+
+// New2 presents the raw blocks as a single file named after the volume, ready to be probed in turn
+func New2(headerReader, dataReader io.ReaderAt, mtime time.Time) (fs.FS, error) {
+	h := make([]byte, HeaderSize)
+	if n, err := headerReader.ReadAt(h, 0); n != len(h) {
+		if err == io.EOF {
+			err = ErrFormat
+		}
+		return nil, err
+	}
+	hdr, err := ParseHeader(h)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.NewReplacer("/", ":", "\x00", "").Replace(hdr.Name)
+	if !fs.ValidPath(name) {
+		name = "disk"
+	}
+	fsys := fskeleton.New()
+	fsys.CreateReaderAt(name, HeaderSize, sectionreader.Section(dataReader, HeaderSize, hdr.DataSize), hdr.DataSize, 0, mtime)
+	fsys.NoMore()
+	return fsys, nil
+}
@@ -0,0 +1,72 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package diskcopy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestImage(t *testing.T) {
+	h := make([]byte, HeaderSize)
+	h[0] = byte(copy(h[1:], "System Tools"))
+	binary.BigEndian.PutUint32(h[64:], 1024)
+	h[80], h[81] = 1, 0x22
+	h[82] = 1
+	disk := bytes.Repeat([]byte("blocks.."), 128)
+	img := append(h, disk...)
+
+	fsys, err := New2(bytes.NewReader(img), bytes.NewReader(img), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := fs.ReadFile(fsys, "System Tools")
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(got, disk) {
+		t.Error("wrong disk contents")
+	}
+	if err := fstest.TestFS(fsys, "System Tools"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNotImage(t *testing.T) {
+	if _, err := ParseHeader(make([]byte, HeaderSize)); err == nil {
+		t.Error("accepted an empty header")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	disk := bytes.Repeat([]byte("blocks.."), 128)
+	tags := bytes.Repeat([]byte("tag"), 8)
+	dataSum, _ := Checksum(bytes.NewReader(disk))
+	tagSum, _ := Checksum(bytes.NewReader(tags[12:]))
+	hdr := Header{DataSize: int64(len(disk)), TagSize: int64(len(tags)), DataChecksum: dataSum, TagChecksum: tagSum}
+	img := append(append(make([]byte, HeaderSize), disk...), tags...)
+
+	dataOK, tagOK, err := hdr.Verify(bytes.NewReader(img))
+	if err != nil || !dataOK || !tagOK {
+		t.Errorf("expected both checksums to match, got %v %v %v", dataOK, tagOK, err)
+	}
+	img[HeaderSize+100] ^= 1
+	img[len(img)-1] ^= 1
+	dataOK, tagOK, err = hdr.Verify(bytes.NewReader(img))
+	if err != nil || dataOK || tagOK {
+		t.Errorf("expected both checksums to mismatch, got %v %v %v", dataOK, tagOK, err)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	sum, err := Checksum(bytes.NewReader([]byte{0x00, 0x01, 0x00, 0x01}))
+	if err != nil {
+		t.Fatal(err)
+	} else if sum != 0xc0000000 { // 1 ror 1 = 0x80000000, then 0x80000001 ror 1
+		t.Errorf("got %#x", sum)
+	}
+}
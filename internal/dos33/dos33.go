@@ -0,0 +1,294 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+// Package dos33 reads the file system of Apple DOS 3.3 from images of 5.25-inch disks,
+// whether the sectors are in DOS order (as in most .dsk and .do files) or ProDOS order (.po).
+//
+// DOS 3.3 keeps no dates, so every file takes the date of the image.
+// The type letter of a file goes in its AppleDouble sidecar as the ProDOS type that CiderPress would give it,
+// and the headers that DOS puts in front of binary and BASIC files are taken off,
+// with the load address of a binary file kept as the auxiliary type.
+package dos33
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"math/bits"
+	"strings"
+	"time"
+
+	"github.com/elliotnunn/BeHierarchic/internal/appledouble"
+	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
+	"github.com/elliotnunn/BeHierarchic/internal/guard"
+	"github.com/elliotnunn/BeHierarchic/internal/multireaderat"
+	"github.com/elliotnunn/BeHierarchic/internal/sectionreader"
+)
+
+var ErrFormat = errors.New("not a DOS 3.3 disk image")
+
+const (
+	SectorSize     = 256
+	sectors        = 16 // per track
+	trackSize      = sectors * SectorSize
+	vtocTrack      = 17
+	entrySize      = 35
+	entriesPer     = 7
+	pairsPer       = 122 // track/sector pairs in a T/S list sector
+	maxCatalog     = 64  // sectors, in case of a loop
+	maxTSLists     = 128
+	minTracks      = 35
+	maxTracks      = 50
+	catalogEntries = 0x0b
+)
+
+// Order is the interleave of the sectors in an image
+type Order int
+
+const (
+	DOSOrder    Order = iota // sectors in the order that DOS numbers them
+	ProDOSOrder              // pairs of sectors making ProDOS blocks
+)
+
+func (o Order) String() string {
+	if o == ProDOSOrder {
+		return "ProDOS order"
+	}
+	return "DOS order"
+}
+
+// where a DOS sector is in a track of a ProDOS-order image
+var prodosInterleave = [sectors]int{0, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1, 15}
+
+func (o Order) offset(track, sector int) int64 {
+	if o == ProDOSOrder {
+		sector = prodosInterleave[sector]
+	}
+	return int64(track)*trackSize + int64(sector)*SectorSize
+}
+
+type disk struct {
+	r      io.ReaderAt
+	order  Order
+	tracks int
+}
+
+func (d disk) sector(track, sector byte) ([]byte, bool) {
+	if int(track) >= d.tracks || sector >= sectors {
+		return nil, false
+	}
+	b := make([]byte, SectorSize)
+	_, err := d.r.ReadAt(b, d.order.offset(int(track), int(sector)))
+	return b, err == nil
+}
+
+// Probe checks the volume table of contents in track 17,
+// then follows the catalog in both orders to see which makes sense
+func Probe(r io.ReaderAt, size int64) (Order, bool) {
+	tracks := int(size / trackSize)
+	if size%trackSize != 0 || tracks < minTracks || tracks > maxTracks {
+		return 0, false
+	}
+	vtoc := make([]byte, SectorSize)
+	if _, err := r.ReadAt(vtoc, vtocTrack*trackSize); err != nil { // sector 0 is in the same place in either order
+		return 0, false
+	}
+	if vtoc[0x27] != pairsPer || vtoc[0x34] < minTracks || int(vtoc[0x34]) > tracks || vtoc[0x35] != sectors ||
+		binary.LittleEndian.Uint16(vtoc[0x36:]) != SectorSize || vtoc[1] == 0 || vtoc[1] >= vtoc[0x34] || vtoc[2] >= sectors {
+		return 0, false
+	}
+	best, bestLen := DOSOrder, 0
+	for _, o := range []Order{DOSOrder, ProDOSOrder} {
+		d := disk{r, o, int(vtoc[0x34])}
+		if n := len(d.catalog(vtoc[1], vtoc[2])); n > bestLen {
+			best, bestLen = o, n
+		}
+	}
+	return best, bestLen > 0
+}
+
+// catalog follows the chain of catalog sectors, stopping at the first that is implausible
+func (d disk) catalog(track, sector byte) [][]byte {
+	var chain [][]byte
+	seen := make(map[[2]byte]bool)
+	for track != 0 && len(chain) < maxCatalog && !seen[[2]byte{track, sector}] {
+		seen[[2]byte{track, sector}] = true
+		b, ok := d.sector(track, sector)
+		if !ok || !d.plausible(b) {
+			break
+		}
+		chain = append(chain, b)
+		track, sector = b[1], b[2]
+	}
+	return chain
+}
+
+// plausible checks the link and the entries of a catalog sector
+func (d disk) plausible(b []byte) bool {
+	if int(b[1]) >= d.tracks || b[2] >= sectors {
+		return false
+	}
+	for i := range entriesPer {
+		e := b[catalogEntries+i*entrySize:][:entrySize]
+		if e[0] == 0 || e[0] == 0xff { // never used, or deleted
+			continue
+		}
+		if int(e[0]) >= d.tracks || e[1] >= sectors || e[2]&0x7f&(e[2]&0x7f-1) != 0 { // one type bit at most
+			return false
+		}
+	}
+	return true
+}
+
+// New2 presents the files on the disk
+func New2(headerReader, dataReader io.ReaderAt, order Order, mtime time.Time) (retfs fs.FS, reterr error) {
+	defer guard.Recover(&reterr, "DOS 3.3", nil)
+	vtoc := make([]byte, SectorSize)
+	if _, err := headerReader.ReadAt(vtoc, vtocTrack*trackSize); err != nil {
+		return nil, err
+	}
+	d := disk{headerReader, order, int(vtoc[0x34])}
+	chain := d.catalog(vtoc[1], vtoc[2])
+	if len(chain) == 0 {
+		return nil, ErrFormat
+	}
+
+	fsys := fskeleton.New()
+	id := int64(0)
+	for _, b := range chain {
+		for i := range entriesPer {
+			e := b[catalogEntries+i*entrySize:][:entrySize]
+			if e[0] == 0 || e[0] == 0xff {
+				continue
+			}
+			name := fileName(e[3:33])
+			if name == "" || !fs.ValidPath(name) {
+				continue
+			}
+			data, size, meta := d.file(dataReader, e)
+			fsys.CreateReaderAt(name, id, data, size, 0, mtime)
+			ad, adsize := meta.WithResourceFork(nil, 0)
+			fsys.CreateReaderAt(appledouble.Sidecar(name), id+1, ad, adsize, 0, mtime)
+			id += 2
+		}
+	}
+	fsys.NoMore()
+	return fsys, nil
+}
+
+// ProDOS types for the DOS types T I A B S R and the new A and B, one bit each except for T
+var prodosTypes = [8]byte{0x04, 0xfa, 0xfc, 0x06, 0xf2, 0xfe, 0xf3, 0xf4}
+
+// file gathers the sectors of a file from its track/sector lists, and takes off the header that DOS adds
+func (d disk) file(dataReader io.ReaderAt, e []byte) (io.ReaderAt, int64, appledouble.AppleDouble) {
+	var pairs [][2]byte
+	track, sector := e[0], e[1]
+	seen := make(map[[2]byte]bool)
+	for track != 0 && len(seen) < maxTSLists && !seen[[2]byte{track, sector}] {
+		seen[[2]byte{track, sector}] = true
+		b, ok := d.sector(track, sector)
+		if !ok {
+			break
+		}
+		first := int(binary.LittleEndian.Uint16(b[5:])) // the file sector of the first pair
+		for len(pairs) < first {
+			pairs = append(pairs, [2]byte{})
+		}
+		pairs = pairs[:first]
+		for p := range pairsPer {
+			pairs = append(pairs, [2]byte(b[0x0c+2*p:]))
+		}
+		track, sector = b[1], b[2]
+	}
+	for len(pairs) > 0 && pairs[len(pairs)-1] == [2]byte{} {
+		pairs = pairs[:len(pairs)-1]
+	}
+
+	var parts []multireaderat.SizeReaderAt
+	holes := false
+	var runStart, runEnd int64 = -1, -1
+	flush := func() {
+		if runStart >= 0 {
+			parts = append(parts, sectionreader.Section(dataReader, runStart, runEnd-runStart))
+		}
+		runStart, runEnd = -1, -1
+	}
+	for _, p := range pairs {
+		if p == [2]byte{} || int(p[0]) >= d.tracks || p[1] >= sectors { // a hole in a random-access file
+			flush()
+			parts = append(parts, zeros(SectorSize))
+			holes = true
+		} else if off := d.order.offset(int(p[0]), int(p[1])); off == runEnd {
+			runEnd += SectorSize
+		} else {
+			flush()
+			runStart, runEnd = off, off+SectorSize
+		}
+	}
+	flush()
+	data := multireaderat.New(parts...)
+
+	var meta appledouble.AppleDouble
+	meta.Locked = e[2]&0x80 != 0
+	typ := e[2] & 0x7f
+	var auxType uint16
+
+	start, size := int64(0), data.Size()
+	head := make([]byte, 4)
+	n, _ := data.ReadAt(head, 0)
+	le := binary.LittleEndian
+	switch typ {
+	case 0x00: // text ends at the first zero in the last sector, unless it has random-access records
+		if !holes && size > 0 {
+			last := make([]byte, SectorSize)
+			data.ReadAt(last, size-SectorSize)
+			if i := strings.IndexByte(string(last), 0); i >= 0 {
+				size -= SectorSize - int64(i)
+			}
+		}
+	case 0x01, 0x02: // BASIC, with a length
+		if n >= 2 {
+			start, size = 2, min(int64(le.Uint16(head)), size-2)
+		}
+		if typ == 0x02 {
+			auxType = 0x0801
+		}
+	case 0x04: // binary, with a load address and a length
+		if n == 4 {
+			auxType = le.Uint16(head)
+			start, size = 4, min(int64(le.Uint16(head[2:])), size-4)
+		}
+	}
+	meta.Type, meta.Creator = appledouble.ProDOSType(prodosTypes[bits.Len8(typ)], auxType)
+	return sectionreader.Section(data, start, size), size, meta
+}
+
+// fileName strips the high bit from the name, which is padded with spaces
+func fileName(b []byte) string {
+	s := make([]byte, len(b))
+	for i, c := range b {
+		s[i] = c & 0x7f
+		if s[i] == '/' {
+			s[i] = ':'
+		}
+	}
+	return strings.TrimRight(string(s), " ")
+}
+
+// zeros is a sector that a random-access file never wrote
+type zeros int64
+
+func (z zeros) Size() int64 { return int64(z) }
+
+func (z zeros) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(z) {
+		return 0, io.EOF
+	}
+	n := int(min(int64(len(p)), int64(z)-off))
+	clear(p[:n])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
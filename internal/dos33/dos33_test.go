@@ -0,0 +1,146 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package dos33
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/fs"
+	"testing"
+	"time"
+)
+
+type image []byte
+
+func (img image) sector(track, sector int) []byte {
+	return img[DOSOrder.offset(track, sector):][:SectorSize]
+}
+
+// reorder makes a ProDOS-order image from a DOS-order one
+func (img image) reorder() image {
+	po := make(image, len(img))
+	for t := range len(img) / trackSize {
+		for s := range sectors {
+			copy(po[ProDOSOrder.offset(t, s):][:SectorSize], img.sector(t, s))
+		}
+	}
+	return po
+}
+
+func highASCII(s string) []byte {
+	b := []byte(s)
+	for i := range b {
+		b[i] |= 0x80
+	}
+	return b
+}
+
+// testImage is laid out as INIT leaves a disk, with the catalog running down track 17
+func testImage() image {
+	img := make(image, 35*trackSize)
+	vtoc := img.sector(vtocTrack, 0)
+	vtoc[1], vtoc[2], vtoc[3] = vtocTrack, 15, 3
+	vtoc[0x27], vtoc[0x34], vtoc[0x35] = pairsPer, 35, sectors
+	binary.LittleEndian.PutUint16(vtoc[0x36:], SectorSize)
+	for s := 15; s > 1; s-- {
+		c := img.sector(vtocTrack, s)
+		c[1], c[2] = vtocTrack, byte(s-1)
+	}
+
+	n := 0
+	add := func(name string, typ byte, ts int, pairs ...[2]byte) {
+		c := img.sector(vtocTrack, 15-n/entriesPer)
+		e := c[catalogEntries+n%entriesPer*entrySize:][:entrySize]
+		e[0], e[1], e[2] = byte(ts), 0, typ
+		copy(e[3:], bytes.Repeat([]byte{0xa0}, 30))
+		copy(e[3:], highASCII(name))
+		if len(pairs) > 0 {
+			list := img.sector(ts, 0)
+			for i, p := range pairs {
+				list[0x0c+2*i], list[0x0c+2*i+1] = p[0], p[1]
+			}
+		}
+		n++
+	}
+
+	add("HELLO", 0x82, 18, [2]byte{18, 1}) // locked
+	copy(img.sector(18, 1), []byte{3, 0, 'R', 'U', 'N'})
+	add("GAME", 0x04, 19, [2]byte{19, 1}, [2]byte{19, 2})
+	bin := img.sector(19, 1)
+	binary.LittleEndian.PutUint16(bin, 0x0803)
+	binary.LittleEndian.PutUint16(bin[2:], SectorSize)
+	copy(bin[4:], bytes.Repeat([]byte{'x'}, SectorSize-4))
+	copy(img.sector(19, 2), "yyyy")
+	add("NOTES", 0x00, 20, [2]byte{20, 1})
+	copy(img.sector(20, 1), append(highASCII("HI\r"), 0, 'z'))
+	add("RANDOM", 0x00, 21, [2]byte{21, 1}, [2]byte{}, [2]byte{21, 2})
+	copy(img.sector(21, 1), "first")
+	copy(img.sector(21, 2), "third")
+	add("SLASH/NAME", 0x00, 22)
+	add("DELETED", 0x00, 0xff)
+	return img
+}
+
+func TestDisk(t *testing.T) {
+	dsk := testImage()
+	for _, tc := range []struct {
+		img   image
+		order Order
+	}{{dsk, DOSOrder}, {dsk.reorder(), ProDOSOrder}} {
+		order, ok := Probe(bytes.NewReader(tc.img), int64(len(tc.img)))
+		if !ok || order != tc.order {
+			t.Fatalf("%v: probed as %v, %v", tc.order, order, ok)
+		}
+		fsys, err := New2(bytes.NewReader(tc.img), bytes.NewReader(tc.img), order, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		random := make([]byte, 3*SectorSize)
+		copy(random, "first")
+		copy(random[2*SectorSize:], "third")
+		for name, want := range map[string][]byte{
+			"HELLO":      []byte("RUN"),
+			"GAME":       append(bytes.Repeat([]byte{'x'}, SectorSize-4), "yyyy"...),
+			"NOTES":      highASCII("HI\r"),
+			"RANDOM":     random,
+			"SLASH:NAME": {},
+		} {
+			got, err := fs.ReadFile(fsys, name)
+			if err != nil {
+				t.Errorf("%v: %s: %v", order, name, err)
+			} else if !bytes.Equal(got, want) {
+				t.Errorf("%v: %s: got %q, want %q", order, name, got, want)
+			}
+		}
+		if _, err := fs.Stat(fsys, "DELETED"); err == nil {
+			t.Error("deleted file still listed")
+		}
+
+		for name, want := range map[string]string{
+			"._HELLO": "p\xfc\x08\x01pdos",
+			"._GAME":  "p\x06\x08\x03pdos",
+			"._NOTES": "TEXTpdos",
+		} {
+			ad, err := fs.ReadFile(fsys, name)
+			if err != nil {
+				t.Error(err)
+			} else if !bytes.Contains(ad, []byte(want)) {
+				t.Errorf("%v: %s: type and creator %q missing", order, name, want)
+			}
+		}
+	}
+}
+
+func TestNotDisk(t *testing.T) {
+	for name, img := range map[string]image{
+		"empty":     make(image, 35*trackSize),
+		"odd size":  testImage()[:35*trackSize-SectorSize],
+		"13-sector": func() image { img := testImage(); img.sector(vtocTrack, 0)[0x35] = 13; return img }(),
+	} {
+		if _, ok := Probe(bytes.NewReader(img), int64(len(img))); ok {
+			t.Errorf("%s: probed as a DOS 3.3 disk", name)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+// Package dostime converts the packed date and time of the MS-DOS directory entry,
+// which the DOS-era archivers copied into their own headers.
+package dostime
+
+import (
+	"time"
+
+	"github.com/elliotnunn/BeHierarchic/internal/walltime"
+)
+
+// Time has a resolution of two seconds and no time zone, so it is given in [walltime.Zone]
+func Time(date, tim uint16) time.Time {
+	return time.Date(
+		// date bits 0-4: day of month; 5-8: month; 9-15: years since 1980
+		int(date>>9+1980),
+		time.Month(date>>5&0xf),
+		int(date&0x1f),
+
+		// time bits 0-4: second/2; 5-10: minute; 11-15: hour
+		int(tim>>11),
+		int(tim>>5&0x3f),
+		int(tim&0x1f*2),
+		0, // nanoseconds
+
+		walltime.Zone,
+	)
+}
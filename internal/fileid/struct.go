@@ -1,4 +1,13 @@
 package fileid
 
+import "io/fs"
+
 // ID is persistent across file rename and write operations
 type ID [12]byte
+
+// FS is implemented by a filesystem that is not the OS but can identify its own files,
+// such as by a checksum of their contents
+type FS interface {
+	fs.FS
+	FileID(name string) (ID, error)
+}
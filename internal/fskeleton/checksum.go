@@ -0,0 +1,54 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package fskeleton
+
+import (
+	"io/fs"
+	"sync/atomic"
+
+	"github.com/elliotnunn/BeHierarchic/internal/internpath"
+)
+
+// Checksum is what an archive records about the contents of a member,
+// such as the CRC-32 of a zip file, so that a client can verify a file without the archive.
+type Checksum struct {
+	Algo string // e.g. "crc32"
+	Sum  []byte // big-endian
+
+	verified atomic.Bool
+}
+
+// Verified is for the reader of the member to call when the contents have been read through and matched
+func (c *Checksum) Verified() { c.verified.Store(true) }
+
+// SetChecksum attaches a checksum to a regular file that has already been created
+func (fsys *FS) SetChecksum(name string, c *Checksum) error {
+	iname, ok := internpath.TryMake(name)
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	x, err := fsys.extra(iname, ok)
+	if err != nil {
+		return &fs.PathError{Op: "checksum", Path: name, Err: err}
+	}
+	x.sum = c
+	return nil
+}
+
+// Checksummed reports the checksum recorded for the file, if any,
+// and whether it has been seen to match the contents since the archive was opened
+func (id *fileID) Checksummed() (algo string, sum []byte, verified bool) {
+	id.fsys.mu.Lock()
+	var c *Checksum
+	if x := id.fsys.extras[id.index]; x != nil {
+		c = x.sum
+	}
+	id.fsys.mu.Unlock()
+	if c == nil {
+		return "", nil, false
+	}
+	return c.Algo, c.Sum, c.verified.Load()
+}
+
+func (f *file) Checksummed() (string, []byte, bool)   { return f.id.Checksummed() }
+func (f *rafile) Checksummed() (string, []byte, bool) { return f.id.Checksummed() }
@@ -96,7 +96,7 @@ func (fsys *FS) Mkdir(name string, id int64, perms fs.FileMode, mtime time.Time)
 		if fsys.files[idx].mode.Type() != typeImplicitDir {
 			return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
 		}
-		fsys.files[idx].mode = permsFromStdlib(perms) | typeDir
+		fsys.files[idx].mode = permsFromStdlib(perms) | typeDir | clockOf(mtime)
 		fsys.files[idx].time = timeFromStdlib(mtime)
 		fsys.files[idx].id = id
 		fsys.cond.Broadcast()
@@ -109,7 +109,7 @@ func (fsys *FS) Mkdir(name string, id int64, perms fs.FileMode, mtime time.Time)
 		fsys.put(parentIdx, f{
 			name: iname,
 			time: timeFromStdlib(mtime),
-			mode: permsFromStdlib(perms) | typeDir,
+			mode: permsFromStdlib(perms) | typeDir | clockOf(mtime),
 			id:   id,
 		})
 		fsys.cond.Broadcast()
@@ -194,7 +194,7 @@ func (fsys *FS) createRegularFileCommon(name string, id int64, data any, size in
 	f := f{
 		name:      iname,
 		time:      timeFromStdlib(mtime),
-		mode:      permsFromStdlib(perms) | typeRegular,
+		mode:      permsFromStdlib(perms) | typeRegular | clockOf(mtime),
 		id:        id,
 		lastChild: packFileSize(size), // overloaded field
 		data:      data,
@@ -237,7 +237,7 @@ func (fsys *FS) Symlink(name string, id int64, target string, perms fs.FileMode,
 	fsys.put(parentIdx, f{
 		name: iname,
 		time: timeFromStdlib(mtime),
-		mode: permsFromStdlib(perms) | typeLink,
+		mode: permsFromStdlib(perms) | typeLink | clockOf(mtime),
 		id:   id,
 		data: internpath.Make(target),
 	})
@@ -250,7 +250,16 @@ func (fsys *FS) Symlink(name string, id int64, target string, perms fs.FileMode,
 // NoMore unblocks any blocked [fs.ReadDirFile.ReadDir] calls.
 func (fsys *FS) NoMore() {
 	fsys.mu.Lock()
+	if !fsys.done {
+		fsys.buildBloom()
+		if fsys.watch != nil {
+			close(fsys.watch)
+		}
+	}
 	fsys.done = true
+	if fsys.patience != nil {
+		fsys.patience.Stop()
+	}
 	fsys.cond.Broadcast()
 	fsys.mu.Unlock()
 }
@@ -20,14 +20,16 @@ func (d *dir) ReadDir(count int) (slice []fs.DirEntry, err error) {
 	fsys := d.ent.fsys
 	fsys.mu.Lock()
 	defer fsys.mu.Unlock()
-	for !fsys.done { // wait for the dir to be completed before returning anything at all
-		fsys.cond.Wait()
-	}
+	// wait for the dir to be completed before returning anything at all, unless out of patience
+	complete := fsys.waitDone()
 
 	errAtEnd := io.EOF
 	if count <= 0 { // "read to end and don't give me EOF"
 		errAtEnd = nil
 	}
+	if !complete {
+		errAtEnd = ErrIncomplete
+	}
 
 	if d.next == 0xffffffff {
 		return nil, errAtEnd // reached end of directory
@@ -6,6 +6,8 @@ package fskeleton
 import (
 	"io/fs"
 	"time"
+
+	"github.com/elliotnunn/BeHierarchic/internal/walltime"
 )
 
 // FileInfo will always be satisfied wherever [fs.FileInfo] is satisfied.
@@ -57,7 +59,7 @@ func (f *fileID) Size() int64 {
 func (f *fileID) Mode() fs.FileMode {
 	f.fsys.mu.Lock()
 	defer f.fsys.mu.Unlock()
-	for !f.fsys.done && f.fsys.files[f.index].mode.Type() == typeImplicitDir {
+	for !f.fsys.done && !f.fsys.impatient && f.fsys.files[f.index].mode.Type() == typeImplicitDir {
 		f.fsys.cond.Wait()
 	}
 	return f.fsys.files[f.index].mode.Stdlib()
@@ -66,10 +68,21 @@ func (f *fileID) Mode() fs.FileMode {
 func (f *fileID) ModTime() time.Time {
 	f.fsys.mu.Lock()
 	defer f.fsys.mu.Unlock()
-	for !f.fsys.done && f.fsys.files[f.index].mode.Type() == typeImplicitDir {
+	for !f.fsys.done && !f.fsys.impatient && f.fsys.files[f.index].mode.Type() == typeImplicitDir {
 		f.fsys.cond.Wait()
 	}
-	return timeToStdlib(f.fsys.files[f.index].time)
+	t := timeToStdlib(f.fsys.files[f.index].time)
+	if f.fsys.files[f.index].mode&wallClock != 0 && !t.IsZero() {
+		t = walltime.In(t.In(walltime.Zone), f.fsys.zone)
+	}
+	return t
+}
+
+// WallClock reports whether the modification time was recorded by the clock on the wall, with no time zone
+func (f *fileID) WallClock() bool {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+	return f.fsys.files[f.index].mode&wallClock != 0
 }
 
 func (f *fileID) Sys() any { return nil }
@@ -77,7 +90,7 @@ func (f *fileID) Sys() any { return nil }
 func (f *fileID) ID() int64 {
 	f.fsys.mu.Lock()
 	defer f.fsys.mu.Unlock()
-	for !f.fsys.done && f.fsys.files[f.index].mode.Type() == typeImplicitDir {
+	for !f.fsys.done && !f.fsys.impatient && f.fsys.files[f.index].mode.Type() == typeImplicitDir {
 		f.fsys.cond.Wait()
 	}
 	return f.fsys.files[f.index].id
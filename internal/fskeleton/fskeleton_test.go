@@ -14,6 +14,8 @@ import (
 	"testing"
 	"testing/fstest"
 	"time"
+
+	"github.com/elliotnunn/BeHierarchic/internal/walltime"
 )
 
 func TestBlockedOpen(t *testing.T) {
@@ -462,3 +464,128 @@ func listFS(fsys *FS, waitFull bool) string {
 		}
 	}
 }
+
+func TestDefinitelyAbsent(t *testing.T) {
+	fsys := New()
+	var names []string
+	for i := range 1000 {
+		name := fmt.Sprintf("dir%d/file%d", i%10, i)
+		names = append(names, name, fmt.Sprintf("dir%d", i%10))
+		fsys.CreateReader(name, 0, emptyFile, 0, 0, time.Time{})
+	}
+	if fsys.DefinitelyAbsent(".DS_Store") {
+		t.Error("cannot be sure of absence before NoMore")
+	}
+	fsys.NoMore()
+	for _, name := range names {
+		if fsys.DefinitelyAbsent(name) {
+			t.Errorf("%s exists but is reported absent", name)
+		}
+	}
+	falsePositives := 0
+	for i := range 1000 {
+		name := fmt.Sprintf("dir%d/.DS_Store%d", i%10, i)
+		if !fsys.DefinitelyAbsent(name) {
+			falsePositives++
+		}
+		_, err := fsys.Stat(name)
+		expectErr(t, fs.ErrNotExist, err)
+	}
+	if falsePositives > 50 {
+		t.Errorf("too many false positives: %d/1000", falsePositives)
+	}
+}
+
+func TestDefinitelyAbsentSymlink(t *testing.T) {
+	fsys := New()
+	fsys.Mkdir("real", 0, 0, time.Time{})
+	fsys.CreateReader("real/file", 0, emptyFile, 0, 0, time.Time{})
+	fsys.Symlink("link", 0, "real", 0, time.Time{})
+	fsys.NoMore()
+	if fsys.DefinitelyAbsent("link/file") {
+		t.Error("a path through a symlink is reported absent")
+	}
+}
+
+func TestPatience(t *testing.T) {
+	fsys := New()
+	fsys.CreateReader("a", 0, emptyFile, 0, 0, time.Time{})
+	fsys.SetPatience(150 * time.Millisecond)
+	mustBlock(t, func() { fs.ReadDir(fsys, ".") })
+	time.Sleep(100 * time.Millisecond)
+	var list []fs.DirEntry
+	var err error
+	mustNotBlock(t, func() { list, err = fs.ReadDir(fsys, ".") })
+	expectErr(t, ErrIncomplete, err)
+	if len(list) != 1 || list[0].Name() != "a" {
+		t.Errorf("expected the partial listing [a], got %v", list)
+	}
+
+	fsys.CreateReader("b", 0, emptyFile, 0, 0, time.Time{})
+	fsys.NoMore()
+	list, err = fs.ReadDir(fsys, ".")
+	expectErr(t, nil, err)
+	if len(list) != 2 {
+		t.Errorf("expected the full listing [a b], got %v", list)
+	}
+}
+
+func TestReadDirNow(t *testing.T) {
+	fsys := New()
+	fsys.CreateReader("d/b", 0, emptyFile, 0, 0, time.Time{})
+	fsys.CreateReader("d/a", 0, emptyFile, 0, 0, time.Time{})
+	var list []fs.DirEntry
+	var err error
+	mustNotBlock(t, func() { list, err = fsys.ReadDirNow("d") })
+	expectErr(t, ErrIncomplete, err)
+	if len(list) != 2 || list[0].Name() != "a" || list[1].Name() != "b" {
+		t.Errorf("expected the partial listing [a b], got %v", list)
+	}
+	mustBlock(t, func() { fs.ReadDir(fsys, "d") }) // nobody else was made impatient
+
+	fsys.NoMore()
+	list, err = fsys.ReadDirNow("d")
+	expectErr(t, nil, err)
+	if len(list) != 2 {
+		t.Errorf("expected the full listing [a b], got %v", list)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	fsys := New()
+	watch := fsys.Watch()
+	fsys.CreateReader("d/f", 0, emptyFile, 0, 0, time.Time{})
+	if !fsys.Complete("d/f") {
+		t.Error("a created file should be complete")
+	}
+	if fsys.Complete("d") || fsys.Complete("nonexistent") {
+		t.Error("a directory should not be complete before NoMore")
+	}
+	mustBlock(t, func() { <-watch })
+	fsys.NoMore()
+	mustNotBlock(t, func() { <-watch })
+	mustNotBlock(t, func() { <-fsys.Watch() })
+	if !fsys.Complete("d") {
+		t.Error("a directory should be complete after NoMore")
+	}
+}
+
+func TestZone(t *testing.T) {
+	fsys := New()
+	fsys.CreateReader("wall", 0, emptyFile, 0, 0, time.Date(2001, 2, 3, 12, 0, 0, 0, walltime.Zone))
+	fsys.CreateReader("utc", 0, emptyFile, 0, 0, time.Date(2001, 2, 3, 12, 0, 0, 0, time.UTC))
+	fsys.NoMore()
+	fsys.SetZone(time.FixedZone("JST", 9*60*60))
+	for name, want := range map[string]int{"wall": 3, "utc": 12} {
+		stat, err := fs.Stat(fsys, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := stat.ModTime().UTC().Hour(); got != want {
+			t.Errorf("%s: got %d:00 UTC, want %d:00", name, got, want)
+		}
+		if wc := stat.(interface{ WallClock() bool }).WallClock(); wc != (name == "wall") {
+			t.Errorf("%s: WallClock() = %v", name, wc)
+		}
+	}
+}
@@ -11,6 +11,7 @@ type mode uint16
 
 // More compact representation than io/fs
 const (
+	wallClock       = 1 << 15 // see [walltime]
 	bornSizeUnknown = 1 << 14
 
 	typeRegular     = 0 << 12
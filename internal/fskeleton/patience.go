@@ -0,0 +1,102 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package fskeleton
+
+import (
+	"errors"
+	"io/fs"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/elliotnunn/BeHierarchic/internal/internpath"
+)
+
+// ErrIncomplete is returned alongside the entries found so far
+// when a directory is read after the patience has run out, but before [FS.NoMore].
+var ErrIncomplete = errors.New("listing incomplete, archive still being read")
+
+// SetPatience limits how long [fs.ReadDirFile.ReadDir] and the lookup of an unusual path will wait for [FS.NoMore],
+// counting from this call. Afterwards they do their best with the files created so far, and population continues.
+// By default they wait forever.
+func (fsys *FS) SetPatience(d time.Duration) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	if fsys.done {
+		return
+	}
+	fsys.impatient = false
+	fsys.patience = time.AfterFunc(d, func() {
+		fsys.mu.Lock()
+		fsys.impatient = true
+		fsys.cond.Broadcast()
+		fsys.mu.Unlock()
+	})
+}
+
+// waitDone must be called with the lock held, and reports whether the FS is complete
+func (fsys *FS) waitDone() bool {
+	for !fsys.done && !fsys.impatient {
+		fsys.cond.Wait()
+	}
+	return fsys.done
+}
+
+// Complete reports whether the entry for name is final:
+// true of a file that has been created, but of a directory only after [FS.NoMore],
+// because an archive can list its files in any order.
+func (fsys *FS) Complete(name string) bool {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	if fsys.done {
+		return true
+	}
+	iname, ok := internpath.TryMake(name)
+	if !ok {
+		return false
+	}
+	idx, ok := fsys.lists[iname]
+	return ok && !fsys.files[idx].mode.IsDir()
+}
+
+// Watch returns a channel that is closed by [FS.NoMore]
+func (fsys *FS) Watch() <-chan struct{} {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	if fsys.watch == nil {
+		fsys.watch = make(chan struct{})
+		if fsys.done {
+			close(fsys.watch)
+		}
+	}
+	return fsys.watch
+}
+
+// ReadDirNow is [fs.ReadDir] without the wait for [FS.NoMore], whatever the patience,
+// for a caller who would rather have the entries created so far (with [ErrIncomplete]) than wait.
+// Unlike [FS.SetPatience], it makes nobody else impatient.
+func (fsys *FS) ReadDirNow(name string) ([]fs.DirEntry, error) {
+	fsys.mu.Lock()
+	if fsys.done {
+		fsys.mu.Unlock()
+		return fs.ReadDir(fsys, name)
+	}
+	var list []fs.DirEntry
+	iname, ok := internpath.TryMake(name)
+	idx, found := fsys.lists[iname]
+	if ok && found && fsys.files[idx].mode.IsDir() {
+		if last := fsys.files[idx].lastChild; last != 0 {
+			for next := fsys.files[last].sibling; ; next = fsys.files[next].sibling {
+				list = append(list, &fileID{fsys, next})
+				if next == last {
+					break
+				}
+			}
+		}
+	}
+	fsys.mu.Unlock()
+
+	slices.SortFunc(list, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	return list, &fs.PathError{Op: "readdir", Path: name, Err: ErrIncomplete}
+}
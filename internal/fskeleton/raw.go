@@ -0,0 +1,77 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package fskeleton
+
+import (
+	"errors"
+	"io/fs"
+
+	"github.com/elliotnunn/BeHierarchic/internal/internpath"
+)
+
+// Raw says where the data of a file lies within the archive it came from, perhaps compressed,
+// so that a client can fetch it with a Range request and decompress it itself.
+type Raw struct {
+	Offset, Size int64
+	Method       string // e.g. "store" or "deflate"
+}
+
+// ErrNoRaw is returned for a file whose data does not lie in one piece in the archive
+var ErrNoRaw = errors.New("no single raw byte range")
+
+// extra is what a few files have beyond what f can hold
+type extra struct {
+	sum *Checksum
+	raw func() (Raw, error)
+}
+
+// extra must be called with the lock held, and returns the extra for a regular file, creating it
+func (fsys *FS) extra(iname internpath.Path, ok bool) (*extra, error) {
+	idx, exist := fsys.lists[iname]
+	if !ok || !exist {
+		return nil, fs.ErrNotExist
+	} else if fsys.files[idx].mode.Type() != typeRegular {
+		return nil, fs.ErrInvalid
+	}
+	if fsys.extras == nil {
+		fsys.extras = make(map[uint32]*extra)
+	}
+	x := fsys.extras[idx]
+	if x == nil {
+		x = new(extra)
+		fsys.extras[idx] = x
+	}
+	return x, nil
+}
+
+// SetRaw attaches the location of a regular file's data, which has already been created.
+// The location is only computed when asked for, because it might need another read of the archive.
+func (fsys *FS) SetRaw(name string, raw func() (Raw, error)) error {
+	iname, ok := internpath.TryMake(name)
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	x, err := fsys.extra(iname, ok)
+	if err != nil {
+		return &fs.PathError{Op: "raw", Path: name, Err: err}
+	}
+	x.raw = raw
+	return nil
+}
+
+// Raw reports where the file's data lies in the archive, or [ErrNoRaw]
+func (id *fileID) Raw() (Raw, error) {
+	id.fsys.mu.Lock()
+	var raw func() (Raw, error)
+	if x := id.fsys.extras[id.index]; x != nil {
+		raw = x.raw
+	}
+	id.fsys.mu.Unlock()
+	if raw == nil {
+		return Raw{}, ErrNoRaw
+	}
+	return raw()
+}
+
+func (f *file) Raw() (Raw, error)   { return f.id.Raw() }
+func (f *rafile) Raw() (Raw, error) { return f.id.Raw() }
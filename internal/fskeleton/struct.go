@@ -5,6 +5,8 @@ package fskeleton
 
 import (
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/elliotnunn/BeHierarchic/internal/internpath"
 )
@@ -16,6 +18,15 @@ type FS struct {
 	files []f
 	lists map[internpath.Path]uint32
 	done  bool
+
+	patience  *time.Timer // see SetPatience
+	impatient bool
+	zone      *time.Location // see SetZone
+	watch     chan struct{}  // see Watch
+
+	extras map[uint32]*extra // see SetChecksum and SetRaw, rare enough not to go in f
+
+	absent atomic.Pointer[bloom] // set when done
 }
 
 type f struct {
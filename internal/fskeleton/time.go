@@ -6,6 +6,8 @@ package fskeleton
 import (
 	"math"
 	"time"
+
+	"github.com/elliotnunn/BeHierarchic/internal/walltime"
 )
 
 var (
@@ -37,3 +39,20 @@ func timeToStdlib(t int64) time.Time {
 		return time.Unix(0, t).UTC()
 	}
 }
+
+// clockOf remembers, in the mode, whether a time was recorded by the clock on the wall
+func clockOf(t time.Time) mode {
+	if walltime.Is(t) {
+		return wallClock
+	}
+	return 0
+}
+
+// SetZone sets the time zone of the times that the archive recorded by the clock on the wall,
+// as marked by [walltime.Zone] when the files were created.
+// Until then they are given in [walltime.Zone], which is UTC in all but name.
+func (fsys *FS) SetZone(loc *time.Location) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	fsys.zone = loc
+}
@@ -113,6 +113,10 @@ func (fsys *FS) lookup(name string, followLastLink bool) (uint32, error) {
 		return 0, fs.ErrInvalid
 	}
 
+	if fsys.DefinitelyAbsent(name) {
+		return 0, fs.ErrNotExist
+	}
+
 	// Fast path: applies to any regular file or directory returned by [Walk]
 	if iname, ok := internpath.TryMake(name); ok {
 		if idx, ok := fsys.lists[iname]; ok {
@@ -126,8 +130,8 @@ func (fsys *FS) lookup(name string, followLastLink bool) (uint32, error) {
 	}
 
 	// Slow path: for the sake of great simplification, ensure the FS is "complete" before this lookup
-	for !fsys.done {
-		fsys.cond.Wait()
+	if !fsys.waitDone() {
+		return 0, ErrIncomplete
 	}
 
 	var (
@@ -0,0 +1,65 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+// Package guard converts the panics of parsers fed malformed data into errors,
+// so that one bad header costs one archive rather than a whole request or the whole server.
+//
+// A parser that returns an error defers [Recover].
+// A goroutine that populates a file system in the background has nobody to return an error to,
+// so it defers [Log] instead.
+package guard
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+)
+
+// ErrCorrupt is wrapped by every [*Error]
+var ErrCorrupt = errors.New("corrupt data")
+
+// Error records a panic caught while parsing
+type Error struct {
+	Format string // e.g. "HFS"
+	Offset int64  // where the parser had got to, or -1 if unknown
+	Value  any    // as passed to panic
+}
+
+func (e *Error) Error() string {
+	if e.Offset < 0 {
+		return fmt.Sprintf("%s: %v: %v", e.Format, ErrCorrupt, e.Value)
+	}
+	return fmt.Sprintf("%s: %v near offset %#x: %v", e.Format, ErrCorrupt, e.Offset, e.Value)
+}
+
+func (e *Error) Unwrap() error { return ErrCorrupt }
+
+func newError(format string, off *int64, value any) *Error {
+	e := &Error{Format: format, Offset: -1, Value: value}
+	if off != nil {
+		e.Offset = *off
+	}
+	return e
+}
+
+// Recover must be called by defer. It turns a panic into an [*Error] stored in *err.
+// If off is not nil then it is read at the moment of the panic.
+func Recover(err *error, format string, off *int64) {
+	if r := recover(); r != nil {
+		*err = newError(format, off, r)
+	}
+}
+
+// Log must be called by defer. It turns a panic into a log message.
+func Log(format string, off *int64) {
+	if r := recover(); r != nil {
+		slog.Warn("parserPanic", "err", newError(format, off, r), "stack", string(debug.Stack()))
+	}
+}
+
+// Call runs f, turning a panic into an [*Error]
+func Call[T any](format string, f func() (T, error)) (ret T, err error) {
+	defer Recover(&err, format, nil)
+	return f()
+}
@@ -0,0 +1,50 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package guard
+
+import (
+	"errors"
+	"testing"
+)
+
+func parse(b []byte) (n int, err error) {
+	off := int64(0)
+	defer Recover(&err, "test", &off)
+	for {
+		n += int(b[off])
+		off++
+	}
+}
+
+func TestRecover(t *testing.T) {
+	_, err := parse([]byte{1, 2, 3})
+	var e *Error
+	if !errors.Is(err, ErrCorrupt) || !errors.As(err, &e) {
+		t.Fatalf("got %v, want an *Error", err)
+	}
+	if e.Offset != 3 {
+		t.Errorf("got offset %d, want 3", e.Offset)
+	}
+}
+
+func TestCall(t *testing.T) {
+	_, err := Call("test", func() (int, error) { panic("boom") })
+	if !errors.Is(err, ErrCorrupt) {
+		t.Errorf("got %v", err)
+	}
+	n, err := Call("test", func() (int, error) { return 5, nil })
+	if n != 5 || err != nil {
+		t.Errorf("got %d, %v", n, err)
+	}
+}
+
+func TestLog(t *testing.T) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer Log("test", nil)
+		panic("boom")
+	}()
+	<-done // and the test binary did not crash
+}
@@ -18,6 +18,7 @@ import (
 
 	"github.com/elliotnunn/BeHierarchic/internal/appledouble"
 	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
+	"github.com/elliotnunn/BeHierarchic/internal/guard"
 	"github.com/elliotnunn/BeHierarchic/internal/multireaderat"
 	"github.com/elliotnunn/BeHierarchic/internal/sectionreader"
 )
@@ -29,6 +30,7 @@ func New(r io.ReaderAt) (fs.FS, error) {
 
 // New2 routes headers and data requests through different readers, to help exotic caching schemes
 func New2(headerReader, dataReader io.ReaderAt) (retfs fs.FS, reterr error) {
+	defer guard.Recover(&reterr, "HFS", nil)
 	var mdb [512]byte
 	_, err := headerReader.ReadAt(mdb[:], 0x400)
 	if err != nil {
@@ -79,6 +81,11 @@ func New2(headerReader, dataReader io.ReaderAt) (retfs fs.FS, reterr error) {
 	fsys := fskeleton.New()
 	defer fsys.NoMore()
 
+	bb := make([]byte, 0x400)
+	n, _ := headerReader.ReadAt(bb, 0)
+	info := volumeInfo(bb[:n], mdb[:])
+	fsys.CreateReaderAt(volumeInfoName, 0, strings.NewReader(info), int64(len(info)), 0, appledouble.MacTime(binary.BigEndian.Uint32(mdb[0x06:])))
+
 	// Make sure fskeleton finds out about forks in the order that they exist on disk
 	// (and hope for no fragmented files)
 	deferred := make(map[int64]func())
@@ -267,9 +274,9 @@ func tryGetSizeCheaply(f io.ReaderAt) (int64, bool) {
 	switch as := f.(type) {
 	case interface{ Size() int64 }:
 		return as.Size(), true
-	case fs.File:
+	case interface{ Stat() (fs.FileInfo, error) }: // not only fs.File, but anything that can Stat
 		stat, err := as.Stat()
-		if err != nil {
+		if err != nil || stat.Size() < 0 { // e.g. fskeleton.SizeUnknown
 			return 0, false
 		}
 		return stat.Size(), true
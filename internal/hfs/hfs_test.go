@@ -102,7 +102,7 @@ func TestComplex(t *testing.T) {
 	}
 
 	fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
-		if d.IsDir() {
+		if d.IsDir() || p == volumeInfoName { // not a fork
 			return nil
 		}
 
@@ -229,3 +229,19 @@ func appleDoubleResFork(buf []byte) []byte {
 	}
 	return nil
 }
+
+func TestVolumeInfo(t *testing.T) {
+	fsys, err := New(bytes.NewReader(testImages["complex"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := fs.ReadFile(fsys, volumeInfoName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Volume name:            Macintosh HD\n", "Boot blocks:"} {
+		if !strings.Contains(string(info), want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package hfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/elliotnunn/BeHierarchic/internal/appledouble"
+)
+
+// volumeInfoName is a synthetic file at the root, because only the files are otherwise exposed
+const volumeInfoName = ".volume-info.txt"
+
+// volumeInfo describes the volume from its Master Directory Block and boot blocks
+func volumeInfo(bb []byte, mdb []byte) string {
+	be := binary.BigEndian
+	pstring := func(b []byte) string {
+		if len(b) == 0 || int(b[0]) >= len(b) {
+			return ""
+		}
+		return stringFromRoman(b[1:][:b[0]])
+	}
+	date := func(t uint32) string {
+		if t == 0 {
+			return "never"
+		}
+		return appledouble.MacTime(t).Format(time.DateTime)
+	}
+
+	nblocks := int64(be.Uint16(mdb[0x12:]))
+	blksize := int64(be.Uint32(mdb[0x14:]))
+	free := int64(be.Uint16(mdb[0x22:]))
+
+	var s strings.Builder
+	line := func(k string, v any) { fmt.Fprintf(&s, "%-24s%v\n", k+":", v) }
+	line("Format", "HFS")
+	line("Volume name", pstring(mdb[0x24:][:28]))
+	line("Created", date(be.Uint32(mdb[0x02:])))
+	line("Modified", date(be.Uint32(mdb[0x06:])))
+	line("Backed up", date(be.Uint32(mdb[0x40:])))
+	line("Allocation block size", fmt.Sprintf("%d bytes", blksize))
+	line("Allocation blocks", nblocks)
+	line("Used", fmt.Sprintf("%d bytes", (nblocks-free)*blksize))
+	line("Free", fmt.Sprintf("%d bytes", free*blksize))
+	line("Files", be.Uint32(mdb[0x54:]))
+	line("Folders", be.Uint32(mdb[0x58:]))
+	line("Locked", be.Uint16(mdb[0x0a:])&0x8080 != 0) // by hardware or software
+	line("Blessed folder ID", be.Uint32(mdb[0x5c:]))
+	if len(bb) >= 0x7a && string(bb[:2]) == "LK" {
+		line("Boot blocks", fmt.Sprintf("version %#x", be.Uint16(bb[0x06:])))
+		line("System", pstring(bb[0x0a:][:16]))
+		line("Startup application", pstring(bb[0x1a:][:16]))
+		line("Clipboard file", pstring(bb[0x6a:][:16]))
+	} else {
+		line("Boot blocks", "none")
+	}
+	return s.String()
+}
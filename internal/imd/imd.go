@@ -0,0 +1,149 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+// Package imd reads the floppy images of Dave Dunfield's ImageDisk,
+// presenting the sectors as a flat image so that the file system on the disk can be probed in turn.
+//
+// The file is a text comment ending in 0x1A, then for each track a header, maps of the sector numbers,
+// and the sectors themselves, each either stored, or compressed to a single byte that fills it.
+// Sectors are laid out track by track in the order the image records them, and by sector number within a track.
+// A sector that could not be read is filled with zeros.
+package imd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"time"
+
+	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
+	"github.com/elliotnunn/BeHierarchic/internal/guard"
+	"github.com/elliotnunn/BeHierarchic/internal/multireaderat"
+	"github.com/elliotnunn/BeHierarchic/internal/sectionreader"
+)
+
+var ErrFormat = errors.New("not a valid ImageDisk image")
+
+const (
+	maxComment = 1 << 16
+	maxTracks  = 256 * 2
+)
+
+// IsImage checks the signature at the start of the comment
+func IsImage(head []byte) bool {
+	return len(head) >= 4 && string(head[:4]) == "IMD "
+}
+
+type sector struct {
+	num  byte
+	off  int64 // in the image file, or -1 to fill
+	fill byte
+}
+
+// New2 presents the sectors as a single file with the given name
+func New2(headerReader, dataReader io.ReaderAt, name string, mtime time.Time) (retfs fs.FS, reterr error) {
+	defer guard.Recover(&reterr, "ImageDisk", nil)
+	br := bufio.NewReader(io.NewSectionReader(headerReader, 0, 1<<40))
+	head, err := br.Peek(4)
+	if err != nil || !IsImage(head) {
+		return nil, ErrFormat
+	}
+	var off int64
+	for {
+		c, err := br.ReadByte()
+		if err != nil || off > maxComment {
+			return nil, ErrFormat
+		}
+		off++
+		if c == 0x1a {
+			break
+		}
+	}
+
+	var parts []multireaderat.SizeReaderAt
+	var runStart, runEnd int64 = -1, -1 // stored sectors that follow one another, to be one part
+	flush := func() {
+		if runStart >= 0 {
+			parts = append(parts, sectionreader.Section(dataReader, runStart, runEnd-runStart))
+		}
+		runStart, runEnd = -1, -1
+	}
+	for range maxTracks {
+		h := make([]byte, 5)
+		if _, err := io.ReadFull(br, h); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		off += 5
+		nsec, sizeCode, heads := int(h[3]), h[4], h[2]
+		if h[0] > 5 || sizeCode > 6 || heads&0x3e != 0 {
+			return nil, fmt.Errorf("%w: track header % x", ErrFormat, h)
+		}
+		size := int64(128) << sizeCode
+		maps := nsec // sector numbers, then optionally cylinders and heads, which are not needed
+		if heads&0x80 != 0 {
+			maps += nsec
+		}
+		if heads&0x40 != 0 {
+			maps += nsec
+		}
+		m := make([]byte, maps)
+		if _, err := io.ReadFull(br, m); err != nil {
+			return nil, err
+		}
+		off += int64(maps)
+
+		sectors := make([]sector, nsec)
+		for i := range sectors {
+			kind, err := br.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			off++
+			s := sector{num: m[i], off: -1}
+			switch {
+			case kind == 0: // unavailable
+			case kind > 8:
+				return nil, fmt.Errorf("%w: sector type %d", ErrFormat, kind)
+			case kind%2 == 1: // stored, perhaps deleted or with a read error
+				s.off = off
+				if _, err := br.Discard(int(size)); err != nil {
+					return nil, err
+				}
+				off += size
+			default: // compressed
+				if s.fill, err = br.ReadByte(); err != nil {
+					return nil, err
+				}
+				off++
+			}
+			sectors[i] = s
+		}
+		slices.SortStableFunc(sectors, func(a, b sector) int { return int(a.num) - int(b.num) })
+
+		for _, s := range sectors {
+			if s.off >= 0 && s.off == runEnd {
+				runEnd += size
+				continue
+			}
+			flush()
+			if s.off >= 0 {
+				runStart, runEnd = s.off, s.off+size
+			} else {
+				parts = append(parts, bytes.NewReader(bytes.Repeat([]byte{s.fill}, int(size))))
+			}
+		}
+	}
+	flush()
+
+	img := multireaderat.New(parts...)
+	fsys := fskeleton.New()
+	fsys.CreateReaderAt(name, 0, img, img.Size(), 0, mtime)
+	fsys.NoMore()
+	return fsys, nil
+}
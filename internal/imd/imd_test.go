@@ -0,0 +1,56 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package imd
+
+import (
+	"bytes"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestImage(t *testing.T) {
+	img := []byte("IMD 1.18: 01/01/2000 00:00:00\r\nA test disk\x1a")
+	// one track of three 128-byte sectors recorded out of order: 2 stored, 1 compressed, 3 unavailable
+	img = append(img, 0, 0, 0, 3, 0)
+	img = append(img, 2, 1, 3)
+	img = append(img, 1)
+	img = append(img, bytes.Repeat([]byte{'b'}, 128)...)
+	img = append(img, 2, 'a')
+	img = append(img, 0)
+	// a second track of two stored sectors, in order
+	img = append(img, 0, 1, 0, 2, 0)
+	img = append(img, 1, 2)
+	img = append(img, 1)
+	img = append(img, bytes.Repeat([]byte{'c'}, 128)...)
+	img = append(img, 1)
+	img = append(img, bytes.Repeat([]byte{'d'}, 128)...)
+
+	fsys, err := New2(bytes.NewReader(img), bytes.NewReader(img), "disk.img", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := fs.ReadFile(fsys, "disk.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []byte
+	for _, c := range []byte{'a', 'b', 0, 'c', 'd'} {
+		want = append(want, bytes.Repeat([]byte{c}, 128)...)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("wrong sectors: got %q", got)
+	}
+	if err := fstest.TestFS(fsys, "disk.img"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNotImage(t *testing.T) {
+	img := []byte("IMD comment with no end")
+	if _, err := New2(bytes.NewReader(img), bytes.NewReader(img), "disk.img", time.Time{}); err == nil {
+		t.Error("accepted an image without the end of its comment")
+	}
+}
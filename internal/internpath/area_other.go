@@ -0,0 +1,11 @@
+//go:build !unix
+
+package internpath
+
+// areaSize is small enough to allocate up front where there is no mmap, as in a browser,
+// and enough for the paths of a few large archives
+const areaSize = 1 << 26
+
+func mapArea() *[areaSize]byte {
+	return new([areaSize]byte)
+}
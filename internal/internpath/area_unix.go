@@ -0,0 +1,19 @@
+//go:build unix
+
+package internpath
+
+import "golang.org/x/sys/unix"
+
+const areaSize = 1 << 31
+
+// mapArea makes a large mapping to hide the enormous allocation from the Go runtime
+func mapArea() *[areaSize]byte {
+	data, err := unix.Mmap(-1, 0, // fd and offset for an anonymous map
+		areaSize,
+		unix.PROT_READ|unix.PROT_WRITE,
+		unix.MAP_PRIVATE|unix.MAP_ANON)
+	if err != nil {
+		panic("mmap failed: " + err.Error())
+	}
+	return (*[areaSize]byte)(data)
+}
@@ -4,12 +4,13 @@ package internpath
 
 import (
 	"fmt"
+	"io"
+	"iter"
 	"math/bits"
+	"slices"
 	"strings"
 	"sync"
 	"unsafe"
-
-	"golang.org/x/sys/unix"
 )
 
 /*
@@ -47,11 +48,12 @@ func (p *Path) setFlag(flag uint32, to bool) {
 	}
 }
 
-const (
-	areaSize = 1 << 31
-	// prependFlag    = 1 << 31
-	// prependSpecial = "._"
-)
+// areaSize is in area_unix.go and area_other.go
+
+// const (
+// 	prependFlag    = 1 << 31
+// 	prependSpecial = "._"
+// )
 
 var (
 	mu    sync.RWMutex
@@ -71,15 +73,8 @@ func MemoryUnknownToRuntime() int {
 	return int(bump)
 }
 
-func init() { // make a large mapping to hide the enormous allocation from the Go runtime
-	data, err := unix.Mmap(-1, 0, // fd and offset for an anonymous map
-		areaSize,
-		unix.PROT_READ|unix.PROT_WRITE,
-		unix.MAP_PRIVATE|unix.MAP_ANON)
-	if err != nil {
-		panic("mmap failed: " + err.Error())
-	}
-	array = (*[areaSize]byte)(data)
+func init() {
+	array = mapArea()
 
 	// root entry
 	putg(0) // offset
@@ -163,6 +158,82 @@ func (p Path) String() string {
 	return unsafe.String(&accum[len(accum)-n], n)
 }
 
+// Len returns the length of the full path, without building it
+func (p Path) Len() int {
+	if p == (Path{}) {
+		return 1 // "."
+	}
+	n := -1
+	for comp := range p.Ancestry() {
+		n += 1 + comp.BaseLen()
+	}
+	return n
+}
+
+// AppendTo appends the full path to buf, growing it at most once
+func (p Path) AppendTo(buf []byte) []byte {
+	if p == (Path{}) {
+		return append(buf, '.')
+	}
+	start := len(buf)
+	buf = slices.Grow(buf, p.Len())[:start+p.Len()]
+	right := len(buf)
+	for comp := range p.Ancestry() {
+		right -= comp.PutBaseRight(buf[start:right])
+		if right > start {
+			right--
+			buf[right] = '/'
+		}
+	}
+	return buf
+}
+
+// WriteTo writes the full path without allocating, so that it can be hashed cheaply
+func (p Path) WriteTo(w io.Writer) (int64, error) {
+	if p == (Path{}) {
+		n, err := io.WriteString(w, ".")
+		return int64(n), err
+	}
+	return p.writeTo(w)
+}
+
+func (p Path) writeTo(w io.Writer) (n int64, err error) {
+	if parent := p.Dir(); parent != (Path{}) {
+		n, err = parent.writeTo(w)
+		if err != nil {
+			return n, err
+		}
+		m, err := w.Write(slash)
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	m, err := w.Write(p.baseBytes())
+	return n + int64(m), err
+}
+
+var slash = []byte{'/'}
+
+func (p Path) baseBytes() []byte {
+	a := array[p.offset():]
+	a, _ = get[uint64](a) // skip the offset field
+	a, l := get[int](a)
+	return a[:l:l]
+}
+
+// Ancestry yields the path, then its parent and so on, stopping before root.
+// It does not allocate.
+func (p Path) Ancestry() iter.Seq[Path] {
+	return func(yield func(Path) bool) {
+		for comp := p; comp != (Path{}); comp = comp.Dir() {
+			if !yield(comp) {
+				return
+			}
+		}
+	}
+}
+
 // Dir returns the containing directory
 //
 // Taking the Dir of root will return root.
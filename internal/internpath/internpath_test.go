@@ -2,6 +2,7 @@ package internpath
 
 import (
 	"fmt"
+	"io"
 	"math/rand/v2"
 	"path"
 	gopath "path"
@@ -166,3 +167,38 @@ func TestLarge(t *testing.T) {
 	}
 	t.Log(Stats())
 }
+
+func TestAppendTo(t *testing.T) {
+	cases := []string{".", "a", "a/b", "a◆/b/c", "looooooooooooooooooooooooong/name"}
+	for _, want := range cases {
+		p := Make(want)
+		if got := string(p.AppendTo([]byte("prefix:"))); got != "prefix:"+want {
+			t.Errorf("Make(%q).AppendTo: got %q", want, got)
+		}
+		var sb strings.Builder
+		n, err := p.WriteTo(&sb)
+		if err != nil || sb.String() != want || n != int64(len(want)) {
+			t.Errorf("Make(%q).WriteTo: got %q, %d, %v", want, sb.String(), n, err)
+		}
+		if p.Len() != len(want) {
+			t.Errorf("Make(%q).Len: got %d", want, p.Len())
+		}
+	}
+}
+
+func TestNoAllocs(t *testing.T) {
+	p := Make("some/deep/path/to/a/file")
+	buf := make([]byte, 0, 64)
+	if n := testing.AllocsPerRun(100, func() { p.AppendTo(buf) }); n != 0 {
+		t.Errorf("AppendTo: %v allocations", n)
+	}
+	if n := testing.AllocsPerRun(100, func() { p.WriteTo(io.Discard) }); n != 0 {
+		t.Errorf("WriteTo: %v allocations", n)
+	}
+	if n := testing.AllocsPerRun(100, func() {
+		for range p.Ancestry() {
+		}
+	}); n != 0 {
+		t.Errorf("Ancestry: %v allocations", n)
+	}
+}
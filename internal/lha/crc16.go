@@ -0,0 +1,38 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package lha
+
+import "encoding/binary"
+
+var crctab = func() (t [256]uint16) {
+	for i := range t {
+		k := uint16(i)
+		for range 8 {
+			if k&1 != 0 {
+				k = k>>1 ^ 0xa001
+			} else {
+				k >>= 1
+			}
+		}
+		t[i] = k
+	}
+	return
+}()
+
+// crc16 is CRC-16/ARC as a [hash.Hash], for [checksumreader]
+type crc16 uint16
+
+func (c *crc16) Write(p []byte) (int, error) {
+	check := uint16(*c)
+	for _, ch := range p {
+		check = crctab[byte(check)^ch] ^ check>>8
+	}
+	*c = crc16(check)
+	return len(p), nil
+}
+
+func (c *crc16) Sum(b []byte) []byte { return binary.BigEndian.AppendUint16(b, uint16(*c)) }
+func (c *crc16) Reset()              { *c = 0 }
+func (c *crc16) Size() int           { return 2 }
+func (c *crc16) BlockSize() int      { return 1 }
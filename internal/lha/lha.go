@@ -0,0 +1,263 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+// Package lha reads the archives of Haruyasu Yoshizaki's LHA and its relatives,
+// with header levels 0, 1 and 2, as documented by LHa for UNIX.
+package lha
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/elliotnunn/BeHierarchic/internal/checksumreader"
+	"github.com/elliotnunn/BeHierarchic/internal/dostime"
+	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
+	"github.com/elliotnunn/BeHierarchic/internal/guard"
+	"github.com/elliotnunn/BeHierarchic/internal/lzh"
+	"github.com/elliotnunn/BeHierarchic/internal/lzhuf"
+	"github.com/elliotnunn/BeHierarchic/internal/sectionreader"
+	"golang.org/x/text/encoding/charmap"
+)
+
+var (
+	ErrFormat = errors.New("not a valid LHA archive")
+	ErrMethod = errors.New("LHA: unimplemented compression method")
+)
+
+const (
+	fixedSize     = 22 // the fields common to every header level, up to the name or the CRC
+	maxHeader     = 1 << 16
+	maxEntries    = 1 << 20
+	maxExtensions = 256
+
+	extCommon    = 0x00
+	extFilename  = 0x01
+	extDirectory = 0x02
+	extUnixTime  = 0x54
+)
+
+// IsArchive checks for the method at the start of the first header, such as "-lh5-"
+func IsArchive(head []byte) bool {
+	return len(head) >= 7 && head[2] == '-' && head[3] == 'l' && (head[4] == 'h' || head[4] == 'z') && head[6] == '-'
+}
+
+// New opens an archive
+func New(r io.ReaderAt) (fs.FS, error) {
+	return New2(r, r)
+}
+
+// New2 routes headers and data requests through different readers, to help exotic caching schemes
+func New2(headerReader, dataReader io.ReaderAt) (retfs fs.FS, reterr error) {
+	defer guard.Recover(&reterr, "LHA", nil)
+	if e, _, err := readHeader(headerReader, 0); err != nil {
+		return nil, err
+	} else if e == nil {
+		return nil, ErrFormat
+	}
+	fsys := fskeleton.New()
+	go populate(fsys, headerReader, dataReader)
+	return fsys, nil
+}
+
+type entry struct {
+	method  string // e.g. "lh5"
+	name    string
+	dataOff int64
+	packed  int64
+	size    int64
+	mtime   time.Time
+	crc     uint16 // CRC-16/ARC of the contents
+	next    int64
+}
+
+func populate(fsys *fskeleton.FS, headerReader, dataReader io.ReaderAt) {
+	defer fsys.NoMore()
+	var off int64
+	defer guard.Log("LHA", &off)
+	for range maxEntries {
+		e, next, err := readHeader(headerReader, off)
+		if err != nil || e == nil {
+			return // the end, or damage
+		}
+		id := off
+		off = next
+		if !fs.ValidPath(e.name) || e.name == "." {
+			continue
+		}
+
+		section := sectionreader.Section(dataReader, e.dataOff, e.packed)
+		want := binary.BigEndian.AppendUint16(nil, e.crc)
+		sum := &fskeleton.Checksum{Algo: "crc16-arc", Sum: want}
+		switch e.method {
+		case "lhd":
+			fsys.Mkdir(e.name, id, 0, e.mtime)
+			continue
+		case "lh0", "lz4":
+			fsys.CreateReaderAt(e.name, id, checksumreader.NewAt(section, e.size, new(crc16), want, sum.Verified), e.size, 0, e.mtime)
+		case "lh1":
+			opener := func() (io.ReadCloser, error) {
+				r := lzhuf.NewReader(io.NewSectionReader(section, 0, e.packed), e.size)
+				return checksumreader.New(r, e.size, new(crc16), want, sum.Verified), nil
+			}
+			fsys.CreateReadCloser(e.name, id, opener, e.size, 0, e.mtime)
+		case "lh4", "lh5", "lh6", "lh7":
+			m := map[string]lzh.Method{"lh4": lzh.LH4, "lh5": lzh.LH5, "lh6": lzh.LH6, "lh7": lzh.LH7}[e.method]
+			opener := func() (io.ReadCloser, error) {
+				r := lzh.NewReader(io.NewSectionReader(section, 0, e.packed), m, e.size)
+				return checksumreader.New(r, e.size, new(crc16), want, sum.Verified), nil
+			}
+			fsys.CreateReadCloser(e.name, id, opener, e.size, 0, e.mtime)
+		default:
+			fsys.CreateError(e.name, id, fmt.Errorf("%w -%s-", ErrMethod, e.method), e.size, 0, e.mtime)
+			continue
+		}
+		fsys.SetChecksum(e.name, sum)
+	}
+}
+
+// readHeader returns the header at off (nil at the end of the archive) and the offset of the next
+func readHeader(r io.ReaderAt, off int64) (e *entry, next int64, err error) {
+	le := binary.LittleEndian
+	h := make([]byte, fixedSize)
+	if n, err := r.ReadAt(h, off); n == 0 || h[0] == 0 {
+		if err == io.EOF {
+			err = nil // archives may end without the zero byte
+		}
+		return nil, 0, err
+	} else if n != len(h) {
+		return nil, 0, ErrFormat
+	}
+	if !IsArchive(h) {
+		return nil, 0, ErrFormat
+	}
+	e = &entry{
+		method: string(h[3:6]),
+		packed: int64(le.Uint32(h[7:])),
+		size:   int64(le.Uint32(h[11:])),
+	}
+	level := h[20]
+
+	var hsize int64
+	switch level {
+	case 0, 1:
+		hsize = 2 + int64(h[0])
+	case 2:
+		hsize = int64(le.Uint16(h))
+	default:
+		return nil, 0, ErrFormat
+	}
+	if hsize < fixedSize {
+		return nil, 0, ErrFormat
+	}
+	h = make([]byte, hsize)
+	if n, _ := r.ReadAt(h, off); n != len(h) {
+		return nil, 0, ErrFormat
+	}
+
+	var os byte
+	var name, dir []byte
+	var extOff int64 // of the size of the first extended header
+	var unixTime uint32
+	if level == 2 {
+		unixTime = le.Uint32(h[15:])
+		e.crc = le.Uint16(h[21:])
+		os = h[23]
+		extOff = 24
+	} else {
+		e.mtime = dostime.Time(le.Uint16(h[17:]), le.Uint16(h[15:]))
+		nameLen := int64(h[21])
+		if fixedSize+nameLen+2 > hsize {
+			return nil, 0, ErrFormat
+		}
+		name = h[fixedSize:][:nameLen]
+		e.crc = le.Uint16(h[fixedSize+nameLen:])
+		after := fixedSize + nameLen + 2 // and the CRC
+		if after < hsize {
+			os = h[after]
+		}
+		if level == 1 {
+			extOff = hsize - 2
+		}
+	}
+	e.dataOff = off + hsize
+
+	// the extended headers of levels 1 and 2, which are inside the header or (level 1) counted as data
+	extR := io.ReaderAt(io.NewSectionReader(r, off, hsize))
+	if level == 1 {
+		extR = io.NewSectionReader(r, off, hsize+e.packed)
+	}
+	for i := 0; extOff > 0; i++ {
+		if i == maxExtensions {
+			return nil, 0, ErrFormat
+		}
+		sz := make([]byte, 2)
+		if n, _ := extR.ReadAt(sz, extOff); n != 2 {
+			return nil, 0, ErrFormat
+		}
+		size := int64(le.Uint16(sz))
+		if size == 0 {
+			break
+		} else if size < 3 {
+			return nil, 0, ErrFormat
+		}
+		ext := make([]byte, size)
+		if n, _ := extR.ReadAt(ext, extOff+2); n != len(ext) {
+			return nil, 0, ErrFormat
+		}
+		if level == 1 {
+			e.dataOff += size
+			e.packed -= size
+			if e.packed < 0 {
+				return nil, 0, ErrFormat
+			}
+		}
+		extOff += size
+		body := ext[1 : size-2]
+		switch ext[0] {
+		case extFilename:
+			name = body
+		case extDirectory:
+			dir = body
+		case extUnixTime:
+			if len(body) >= 4 {
+				unixTime = le.Uint32(body)
+			}
+		}
+	}
+	if unixTime != 0 {
+		e.mtime = time.Unix(int64(unixTime), 0)
+	}
+
+	e.name = decodeName(dir, name, os)
+	return e, e.dataOff + e.packed, nil
+}
+
+// decodeName joins the directory and the name, whose separators may be 0xff or backslashes,
+// in the character set of the operating system that made the archive
+func decodeName(dir, name []byte, os byte) string {
+	full := string(name)
+	for len(dir) > 0 && dir[len(dir)-1] == 0xff { // as LHa for UNIX ends it
+		dir = dir[:len(dir)-1]
+	}
+	if len(dir) > 0 {
+		full = string(dir) + "\xff" + full
+	}
+	full = strings.NewReplacer("\xff", "/", "\\", "/").Replace(full)
+	if !utf8.ValidString(full) {
+		switch os {
+		case 'm': // MacLHA
+			full, _ = charmap.Macintosh.NewDecoder().String(full)
+		case 'A': // Amiga
+			full, _ = charmap.ISO8859_1.NewDecoder().String(full)
+		default:
+			full, _ = charmap.CodePage437.NewDecoder().String(full)
+		}
+	}
+	return strings.Trim(full, "/")
+}
@@ -0,0 +1,183 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package lha
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/elliotnunn/BeHierarchic/internal/checksumreader"
+)
+
+func crcOf(data []byte) uint16 {
+	var c crc16
+	c.Write(data)
+	return uint16(c)
+}
+
+func level0(method, name string, data []byte) []byte {
+	h := []byte{0, 0}
+	h = append(h, "-"+method+"-"...)
+	h = binary.LittleEndian.AppendUint32(h, uint32(len(data)))
+	h = binary.LittleEndian.AppendUint32(h, uint32(len(data)))
+	h = binary.LittleEndian.AppendUint32(h, 0x5a216000) // 2025-01-01 12:00
+	h = append(h, 0x20, 0, byte(len(name)))
+	h = append(h, name...)
+	h = binary.LittleEndian.AppendUint16(h, crcOf(data))
+	h[0] = byte(len(h) - 2)
+	return append(h, data...)
+}
+
+func ext(typ byte, body string) []byte {
+	e := binary.LittleEndian.AppendUint16(nil, uint16(len(body)+3))
+	return append(append(e, typ), body...)
+}
+
+func level1(method, name string, data []byte, exts ...[]byte) []byte {
+	var extBytes []byte
+	for _, e := range exts {
+		extBytes = append(extBytes, e...)
+	}
+	h := []byte{0, 0}
+	h = append(h, "-"+method+"-"...)
+	h = binary.LittleEndian.AppendUint32(h, uint32(len(data)+len(extBytes)))
+	h = binary.LittleEndian.AppendUint32(h, uint32(len(data)))
+	h = binary.LittleEndian.AppendUint32(h, 0x5a216000)
+	h = append(h, 0x20, 1, byte(len(name)))
+	h = append(h, name...)
+	h = binary.LittleEndian.AppendUint16(h, crcOf(data))
+	h = append(h, 'M')  // OS
+	h[0] = byte(len(h)) // and the size of the first extension, less the two bytes before the method
+	h = append(h, extBytes...)
+	h = append(h, 0, 0) // no more extensions
+	return append(h, data...)
+}
+
+// level2 takes the contents both as stored and as they should come out
+func level2(method string, mtime uint32, packed, data []byte, exts ...[]byte) []byte {
+	h := []byte{0, 0}
+	h = append(h, "-"+method+"-"...)
+	h = binary.LittleEndian.AppendUint32(h, uint32(len(packed)))
+	h = binary.LittleEndian.AppendUint32(h, uint32(len(data)))
+	h = binary.LittleEndian.AppendUint32(h, mtime)
+	h = append(h, 0x20, 2)
+	h = binary.LittleEndian.AppendUint16(h, crcOf(data))
+	h = append(h, 'U')
+	for _, e := range exts {
+		h = append(h, e...)
+	}
+	h = append(h, 0, 0)
+	binary.LittleEndian.PutUint16(h, uint16(len(h)))
+	return append(h, packed...)
+}
+
+func TestLevels(t *testing.T) {
+	var a []byte
+	a = append(a, level0("lh0", "DOS\\README.TXT", []byte("level zero"))...)
+	a = append(a, level1("lh0", "one.txt", []byte("level one"))...)
+	a = append(a, level1("lh0", "", []byte("level one, long name"), ext(extFilename, "a longer name.txt"), ext(extDirectory, "sub\xffdir"))...)
+	a = append(a, level2("lh0", 1700000000, []byte("level two"), []byte("level two"), ext(extFilename, "two.txt"))...)
+	a = append(a, level2("lhd", 1700000000, nil, nil, ext(extDirectory, "empty\xff"))...)
+	a = append(a, level0("lzs", "old.bin", []byte("xyzzy"))...)
+	a = append(a, 0)
+	if !IsArchive(a) {
+		t.Fatal("magic number not recognised")
+	}
+
+	fsys, err := New(bytes.NewReader(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"DOS/README.TXT":            "level zero",
+		"one.txt":                   "level one",
+		"sub/dir/a longer name.txt": "level one, long name",
+		"two.txt":                   "level two",
+	} {
+		got, err := fs.ReadFile(fsys, name)
+		if err != nil || string(got) != want {
+			t.Errorf("%s: got %q, %v", name, got, err)
+		}
+	}
+	if stat, err := fs.Stat(fsys, "empty"); err != nil || !stat.IsDir() {
+		t.Errorf("directory: %v, %v", stat, err)
+	}
+	if stat, err := fs.Stat(fsys, "two.txt"); err != nil || !stat.ModTime().Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("level 2 modtime: %v, %v", stat, err)
+	}
+	if stat, err := fs.Stat(fsys, "one.txt"); err != nil || !stat.ModTime().Equal(time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("level 1 modtime: %v, %v", stat, err)
+	}
+	if _, err := fs.ReadFile(fsys, "old.bin"); !errors.Is(err, ErrMethod) {
+		t.Errorf("unimplemented method: expected ErrMethod, got %v", err)
+	}
+}
+
+// The compressed streams were made by the encoders in the tests of packages lzhuf and lzh
+var (
+	lh1Packed, _ = hex.DecodeString("e97b7f187acc1f1c402bfffee30be52c0c93066eb2c0")
+	lh1Data      = []byte("Fall leaves, fall leaves, fall leaves.\n")
+	lh5Packed, _ = hex.DecodeString("00052a4950189bfc88c086c0")
+	lh5Data      = []byte("abcabc")
+)
+
+func TestMethods(t *testing.T) {
+	var a []byte
+	a = append(a, level2("lh1", 1700000000, lh1Packed, lh1Data, ext(extFilename, "lh1.txt"))...)
+	a = append(a, level2("lh5", 1700000000, lh5Packed, lh5Data, ext(extFilename, "lh5.txt"))...)
+	bad := level2("lh5", 1700000000, lh5Packed, lh5Data, ext(extFilename, "bad.txt"))
+	bad[21] ^= 1 // the CRC
+	a = append(a, bad...)
+	a = append(a, level0("lh0", "bad.bin", []byte("stored"))...)
+	a[len(a)-1] ^= 1 // the contents
+	a = append(a, 0)
+
+	fsys, err := New(bytes.NewReader(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string][]byte{"lh1.txt": lh1Data, "lh5.txt": lh5Data} {
+		got, err := fs.ReadFile(fsys, name)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("%s: got %q, %v", name, got, err)
+		}
+	}
+	for _, name := range []string{"bad.txt", "bad.bin"} {
+		if _, err := fs.ReadFile(fsys, name); !errors.Is(err, checksumreader.ErrChecksum) {
+			t.Errorf("%s: expected ErrChecksum, got %v", name, err)
+		}
+	}
+}
+
+// sample.lzh has a member of each header level, with the header sums and CRCs that LHA writes
+func TestSample(t *testing.T) {
+	a, err := os.ReadFile("testdata/sample.lzh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := New(bytes.NewReader(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string][]byte{
+		"DOS/README.TXT":  []byte("level zero\r\n"),
+		"abcabc.txt":      lh5Data,
+		"docs/leaves.txt": lh1Data,
+	} {
+		got, err := fs.ReadFile(fsys, name)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("%s: got %q, %v", name, got, err)
+		}
+	}
+	if err := fstest.TestFS(fsys, "DOS/README.TXT", "abcabc.txt", "docs/leaves.txt"); err != nil {
+		t.Error(err)
+	}
+}
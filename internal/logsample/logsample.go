@@ -0,0 +1,130 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+// Package logsample stops a corrupt collection from drowning the log.
+// One bad disk image can make a parser warn about every one of its million members,
+// identically, so only the first few of each message in a period are let through,
+// and the rest are counted and reported as a single summary when the period is over.
+package logsample
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Suppressed is the message of the summary record, which has attributes "like" (the message suppressed) and "count"
+const Suppressed = "logSuppressed"
+
+// Handler lets through at most burst warnings (or errors) with the same level and message per period.
+// Records below [slog.LevelWarn] are the server's own progress and are always let through.
+type Handler struct {
+	inner slog.Handler
+	s     *state // shared with the handlers derived by WithAttrs and WithGroup
+}
+
+type state struct {
+	burst  int
+	period time.Duration
+	inner  slog.Handler // undecorated, for the summaries
+
+	mu   sync.Mutex
+	keys map[key]*count
+}
+
+type key struct {
+	level slog.Level
+	msg   string
+}
+
+type count struct {
+	since      time.Time
+	passed     int
+	suppressed int64
+}
+
+// New wraps a handler. Summaries are written when a message recurs in a later period,
+// or by [Handler.Summarize], which should be called every period so that none are left unsaid.
+func New(inner slog.Handler, burst int, period time.Duration) *Handler {
+	return &Handler{inner, &state{burst: burst, period: period, inner: inner, keys: make(map[key]*count)}}
+}
+
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < slog.LevelWarn {
+		return h.inner.Handle(ctx, r)
+	}
+	s := h.s
+	k := key{r.Level, r.Message}
+	s.mu.Lock()
+	c, ok := s.keys[k]
+	if !ok {
+		c = &count{since: r.Time}
+		s.keys[k] = c
+	}
+	var summary int64
+	if r.Time.Sub(c.since) >= s.period {
+		summary = c.suppressed
+		*c = count{since: r.Time}
+	}
+	pass := c.passed < s.burst
+	if pass {
+		c.passed++
+	} else {
+		c.suppressed++
+	}
+	s.mu.Unlock()
+
+	if summary > 0 {
+		s.summarize(ctx, k, summary, r.Time)
+	}
+	if !pass {
+		return nil
+	}
+	return h.inner.Handle(ctx, r)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &Handler{h.inner.WithAttrs(attrs), h.s}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{h.inner.WithGroup(name), h.s}
+}
+
+// Summarize reports the records suppressed in every period that is over,
+// and forgets the messages that have gone quiet
+func (h *Handler) Summarize(ctx context.Context) {
+	s := h.s
+	now := time.Now()
+	type due struct {
+		k key
+		n int64
+	}
+	var dues []due
+	s.mu.Lock()
+	for k, c := range s.keys {
+		if now.Sub(c.since) < s.period {
+			continue
+		}
+		if c.suppressed > 0 {
+			dues = append(dues, due{k, c.suppressed})
+		}
+		delete(s.keys, k)
+	}
+	s.mu.Unlock()
+
+	for _, d := range dues {
+		s.summarize(ctx, d.k, d.n, now)
+	}
+}
+
+func (s *state) summarize(ctx context.Context, k key, n int64, t time.Time) {
+	r := slog.NewRecord(t, k.level, Suppressed, 0)
+	r.AddAttrs(slog.String("like", k.msg), slog.Int64("count", n))
+	s.inner.Handle(ctx, r)
+}
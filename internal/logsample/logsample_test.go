@@ -0,0 +1,63 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package logsample
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBurst(t *testing.T) {
+	var buf bytes.Buffer
+	h := New(slog.NewTextHandler(&buf, nil), 3, time.Hour)
+	log := slog.New(h)
+	for range 100 {
+		log.Warn("probeError", "path", "x")
+	}
+	log.Warn("otherError")
+	if n := strings.Count(buf.String(), "probeError"); n != 3 {
+		t.Errorf("got %d of a repeated message, want 3:\n%s", n, buf.String())
+	}
+	if !strings.Contains(buf.String(), "otherError") {
+		t.Errorf("a different message was suppressed:\n%s", buf.String())
+	}
+
+	h.s.period = 0 // as if the hour were up
+	buf.Reset()
+	h.Summarize(t.Context())
+	if got := buf.String(); !strings.Contains(got, Suppressed) || !strings.Contains(got, "like=probeError count=97") {
+		t.Errorf("summary wrong: %s", got)
+	}
+}
+
+func TestNextPeriod(t *testing.T) {
+	var buf bytes.Buffer
+	h := New(slog.NewTextHandler(&buf, nil), 1, time.Millisecond)
+	log := slog.New(h).With("attr", 1)
+	log.Warn("probeError")
+	log.Warn("probeError")
+	time.Sleep(2 * time.Millisecond)
+	log.Warn("probeError")
+	got := buf.String()
+	if n := strings.Count(got, "msg=probeError"); n != 2 {
+		t.Errorf("got %d let through, want 2:\n%s", n, got)
+	}
+	if !strings.Contains(got, "like=probeError count=1") {
+		t.Errorf("no summary of the suppressed record:\n%s", got)
+	}
+}
+
+func TestInfoUnlimited(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(New(slog.NewTextHandler(&buf, nil), 1, time.Hour))
+	for range 5 {
+		log.Info("prefetchDir")
+	}
+	if n := strings.Count(buf.String(), "prefetchDir"); n != 5 {
+		t.Errorf("got %d, want 5", n)
+	}
+}
@@ -0,0 +1,275 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+// Package lzh decompresses the static-Huffman LZ77 format of Haruhiko Okumura's ar002,
+// which LHA calls -lh4- to -lh7-, ZOO calls method 2 and ARJ calls methods 1 to 3.
+package lzh
+
+import (
+	"bufio"
+	"errors"
+	"io"
+
+	"github.com/elliotnunn/BeHierarchic/internal/guard"
+)
+
+var ErrCorrupt = errors.New("lzh: corrupt data")
+
+// Method sets the dictionary size, which fixes the number of position codes and their bit width
+type Method struct {
+	dictBits int
+	np, pbit int
+}
+
+var (
+	LH4 = Method{dictBits: 12, np: 13, pbit: 4}
+	LH5 = Method{dictBits: 13, np: 14, pbit: 4}
+	LH6 = Method{dictBits: 15, np: 16, pbit: 5}
+	LH7 = Method{dictBits: 16, np: 17, pbit: 5}
+	ARJ = Method{dictBits: 16, np: 17, pbit: 5} // the dictionary is really 26624 bytes
+)
+
+const (
+	threshold = 3 // shortest match
+	nc        = 256 + 256 + 2 - threshold
+	nt        = 16 + 3
+	cbit      = 9
+	tbit      = 5
+)
+
+// NewReader decompresses exactly size bytes
+func NewReader(r io.Reader, m Method, size int64) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go decompress(pw, r, m, size)
+	return pr
+}
+
+func decompress(dst *io.PipeWriter, src io.Reader, m Method, size int64) {
+	var reterr error
+	bw := bufio.NewWriterSize(dst, 4096)
+	defer func() {
+		if reterr == nil {
+			reterr = bw.Flush()
+		}
+		dst.CloseWithError(reterr)
+	}()
+	defer guard.Recover(&reterr, "LZH", nil) // a malformed table can send the decoder out of bounds
+
+	d := decoder{br: bitReader{r: bufio.NewReaderSize(src, 4096)}, m: m}
+	window := make([]byte, 1<<m.dictBits)
+	mask := len(window) - 1
+	pos := 0
+	for size > 0 {
+		c, err := d.decodeC()
+		if err != nil {
+			reterr = err
+			return
+		}
+		if c < 256 {
+			window[pos] = byte(c)
+			pos = (pos + 1) & mask
+			if reterr = bw.WriteByte(byte(c)); reterr != nil {
+				return
+			}
+			size--
+			continue
+		}
+		n := int64(c - 256 + threshold)
+		dist, err := d.decodeP()
+		if err != nil {
+			reterr = err
+			return
+		}
+		from := (pos - dist - 1) & mask
+		for ; n > 0 && size > 0; n-- {
+			b := window[from]
+			window[pos] = b
+			from = (from + 1) & mask
+			pos = (pos + 1) & mask
+			if reterr = bw.WriteByte(b); reterr != nil {
+				return
+			}
+			size--
+		}
+	}
+}
+
+type decoder struct {
+	br        bitReader
+	m         Method
+	blocksize int
+	c, p      huffman
+}
+
+func (d *decoder) decodeC() (int, error) {
+	if d.blocksize == 0 {
+		d.blocksize = int(d.br.bits(16))
+		t, err := d.readPtLen(nt, tbit, 3)
+		if err != nil {
+			return 0, err
+		}
+		if d.c, err = d.readCLen(&t); err != nil {
+			return 0, err
+		}
+		if d.p, err = d.readPtLen(d.m.np, d.m.pbit, -1); err != nil {
+			return 0, err
+		}
+		if d.br.err != nil {
+			return 0, d.br.err
+		}
+	}
+	d.blocksize--
+	return d.c.decode(&d.br)
+}
+
+func (d *decoder) decodeP() (int, error) {
+	j, err := d.p.decode(&d.br)
+	if err != nil || j == 0 {
+		return j, err
+	}
+	return 1<<(j-1) + int(d.br.bits(j-1)), nil
+}
+
+// readPtLen reads the lengths of the position codes, or of the codes that encode the literal/length code lengths
+func (d *decoder) readPtLen(nn, nbit, special int) (huffman, error) {
+	n := int(d.br.bits(nbit))
+	if n == 0 {
+		return huffman{single: int(d.br.bits(nbit))}, nil
+	} else if n > nn {
+		return huffman{}, ErrCorrupt
+	}
+	lens := make([]uint8, nn)
+	for i := 0; i < n; {
+		c := int(d.br.bits(3))
+		if c == 7 {
+			for d.br.bits(1) == 1 {
+				c++
+				if c > 16 {
+					return huffman{}, ErrCorrupt
+				}
+			}
+		}
+		lens[i] = uint8(c)
+		i++
+		if i == special {
+			for z := d.br.bits(2); z > 0 && i < nn; z-- {
+				lens[i] = 0
+				i++
+			}
+		}
+	}
+	return makeHuffman(lens)
+}
+
+// readCLen reads the lengths of the literal/length codes, themselves encoded with t
+func (d *decoder) readCLen(t *huffman) (huffman, error) {
+	n := int(d.br.bits(cbit))
+	if n == 0 {
+		return huffman{single: int(d.br.bits(cbit))}, nil
+	} else if n > nc {
+		return huffman{}, ErrCorrupt
+	}
+	lens := make([]uint8, nc)
+	for i := 0; i < n; {
+		c, err := t.decode(&d.br)
+		if err != nil {
+			return huffman{}, err
+		}
+		if c > 2 {
+			lens[i] = uint8(c - 2)
+			i++
+			continue
+		}
+		switch c {
+		case 0:
+			c = 1
+		case 1:
+			c = int(d.br.bits(4)) + 3
+		case 2:
+			c = int(d.br.bits(cbit)) + 20
+		}
+		i += c // already zero
+	}
+	return makeHuffman(lens)
+}
+
+// huffman is a canonical code: shorter codes first, and within a length, lower symbols first.
+// A table that says it has a single symbol spends no bits on it.
+type huffman struct {
+	count  [17]uint16 // codes of each length
+	first  [17]int    // index into symbols of the first code of each length
+	syms   []uint16
+	single int
+}
+
+func makeHuffman(lens []uint8) (huffman, error) {
+	var h huffman
+	for _, l := range lens {
+		h.count[l]++
+	}
+	h.count[0] = 0
+	avail := 1
+	for l := 1; l <= 16; l++ {
+		avail = avail<<1 - int(h.count[l])
+		if avail < 0 {
+			return huffman{}, ErrCorrupt
+		}
+		h.first[l] = h.first[l-1] + int(h.count[l-1])
+	}
+	h.syms = make([]uint16, h.first[16]+int(h.count[16]))
+	next := h.first
+	for sym, l := range lens {
+		if l != 0 {
+			h.syms[next[l]] = uint16(sym)
+			next[l]++
+		}
+	}
+	return h, nil
+}
+
+func (h *huffman) decode(br *bitReader) (int, error) {
+	if h.syms == nil {
+		return h.single, nil
+	}
+	code, base := 0, 0 // base is the first code of this length
+	for l := 1; l <= 16; l++ {
+		code = code<<1 | int(br.bits(1))
+		base <<= 1
+		if code-base < int(h.count[l]) {
+			return int(h.syms[h.first[l]+code-base]), nil
+		}
+		base += int(h.count[l])
+	}
+	if br.err != nil {
+		return 0, br.err
+	}
+	return 0, ErrCorrupt
+}
+
+// bitReader reads most significant bit first, and pads the end of the stream with zeros
+type bitReader struct {
+	r     io.ByteReader
+	buf   uint32
+	n     int
+	extra int // zero bytes already padded
+	err   error
+}
+
+func (br *bitReader) bits(n int) uint32 {
+	if n == 0 {
+		return 0
+	}
+	for br.n < n {
+		b, err := br.r.ReadByte()
+		if err != nil {
+			br.extra++
+			if br.extra > 4 {
+				br.err = io.ErrUnexpectedEOF
+			}
+		}
+		br.buf = br.buf<<8 | uint32(b)
+		br.n += 8
+	}
+	br.n -= n
+	return br.buf >> br.n & (1<<n - 1)
+}
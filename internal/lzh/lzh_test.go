@@ -0,0 +1,61 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package lzh
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/elliotnunn/BeHierarchic/internal/bittest"
+)
+
+// A single block with codes for "a", "b", "c" and a three-byte match
+func TestBlock(t *testing.T) {
+	var w bittest.Writer
+	w.Put(5, 16) // codes in block
+
+	// code length code: symbols 0, 1, 2 and 4 of length 2
+	w.Put(5, tbit)
+	w.Put(2, 3)
+	w.Put(2, 3)
+	w.Put(2, 3)
+	w.Put(1, 2) // one zero after the third
+	w.Put(2, 3)
+
+	// literal/length code: "a", "b", "c" and 256 of length 2
+	w.Put(257, cbit)
+	w.Put(0b10, 2) // 97 zeros
+	w.Put(97-20, cbit)
+	w.Put(0b11, 2) // length 2
+	w.Put(0b11, 2)
+	w.Put(0b11, 2)
+	w.Put(0b10, 2) // 156 zeros
+	w.Put(156-20, cbit)
+	w.Put(0b11, 2)
+
+	// position code: a single symbol, so no bits
+	w.Put(0, LH5.pbit)
+	w.Put(2, LH5.pbit)
+
+	w.Put(0b00, 2) // a
+	w.Put(0b01, 2) // b
+	w.Put(0b10, 2) // c
+	w.Put(0b11, 2) // match of 3
+	w.Put(0, 1)    // distance 2 (3 back)
+
+	got, err := io.ReadAll(NewReader(bytes.NewReader(w.Bytes()), LH5, 6))
+	if err != nil {
+		t.Fatal(err)
+	} else if string(got) != "abcabc" {
+		t.Errorf("got %q, want %q", got, "abcabc")
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	_, err := io.ReadAll(NewReader(bytes.NewReader([]byte{0, 1, 0xff, 0xff, 0xff}), LH5, 100))
+	if err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+// Package lzhuf decompresses the adaptive-Huffman LZSS format of Haruhiko Okumura's LZHUF.C (1988),
+// which Teledisk uses for its "advanced compression".
+package lzhuf
+
+import (
+	"bufio"
+	"errors"
+	"io"
+
+	"github.com/elliotnunn/BeHierarchic/internal/guard"
+)
+
+var ErrCorrupt = errors.New("lzhuf: corrupt data")
+
+const (
+	ringSize  = 4096
+	maxMatch  = 60
+	threshold = 2
+	nChar     = 256 - threshold + maxMatch // literals and match lengths
+	nodes     = nChar*2 - 1
+	root      = nodes - 1
+	maxFreq   = 0x8000
+)
+
+// dCode and dLen decode the upper 6 bits of a match position from its first byte
+var dCode, dLen [256]byte
+
+func init() {
+	i, code := 0, byte(0)
+	for _, g := range [...]struct{ codes, each, len int }{{1, 32, 3}, {3, 16, 4}, {8, 8, 5}, {12, 4, 6}, {24, 2, 7}, {16, 1, 8}} {
+		for range g.codes {
+			for range g.each {
+				dCode[i], dLen[i] = code, byte(g.len)
+				i++
+			}
+			code++
+		}
+	}
+}
+
+// NewReader decompresses exactly size bytes, or if size is negative then until the input runs out
+func NewReader(r io.Reader, size int64) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go decompress(pw, r, size)
+	return pr
+}
+
+func decompress(dst *io.PipeWriter, src io.Reader, size int64) {
+	var reterr error
+	bw := bufio.NewWriterSize(dst, 4096)
+	defer func() {
+		if reterr == nil {
+			reterr = bw.Flush()
+		}
+		dst.CloseWithError(reterr)
+	}()
+	defer guard.Recover(&reterr, "LZHUF", nil)
+
+	d := newDecoder(bufio.NewReaderSize(src, 4096))
+	var window [ringSize]byte
+	for i := range ringSize - maxMatch {
+		window[i] = ' '
+	}
+	pos := ringSize - maxMatch
+	for size != 0 {
+		c := d.decodeChar()
+		if d.br.extra > 0 { // ran out of input
+			if size > 0 {
+				reterr = io.ErrUnexpectedEOF
+			}
+			return
+		}
+		if c < 256 {
+			window[pos] = byte(c)
+			pos = (pos + 1) & (ringSize - 1)
+			if reterr = bw.WriteByte(byte(c)); reterr != nil {
+				return
+			}
+			size--
+			continue
+		}
+		from := (pos - d.decodePosition() - 1) & (ringSize - 1)
+		for k := c - 255 + threshold; k > 0 && size != 0; k-- {
+			b := window[from]
+			window[pos] = b
+			from = (from + 1) & (ringSize - 1)
+			pos = (pos + 1) & (ringSize - 1)
+			if reterr = bw.WriteByte(b); reterr != nil {
+				return
+			}
+			size--
+		}
+	}
+}
+
+// decoder holds the adaptive Huffman tree, which changes after every symbol
+type decoder struct {
+	br   bitReader
+	freq [nodes + 1]uint32
+	prnt [nodes + nChar]int // parents, and for leaves (offset by nodes) the node holding them
+	son  [nodes]int         // the first of two children, or a leaf offset by nodes
+}
+
+func newDecoder(r io.ByteReader) *decoder {
+	d := &decoder{br: bitReader{r: r}}
+	for i := range nChar {
+		d.freq[i] = 1
+		d.son[i] = i + nodes
+		d.prnt[i+nodes] = i
+	}
+	for i, j := 0, nChar; j <= root; i, j = i+2, j+1 {
+		d.freq[j] = d.freq[i] + d.freq[i+1]
+		d.son[j] = i
+		d.prnt[i], d.prnt[i+1] = j, j
+	}
+	d.freq[nodes] = 0xffff
+	d.prnt[root] = 0
+	return d
+}
+
+func (d *decoder) decodeChar() int {
+	c := d.son[root]
+	for c < nodes {
+		c = d.son[c+int(d.br.bits(1))]
+	}
+	c -= nodes
+	d.update(c)
+	return c
+}
+
+func (d *decoder) decodePosition() int {
+	i := int(d.br.bits(8))
+	c := int(dCode[i]) << 6
+	i = i<<(dLen[i]-2) | int(d.br.bits(int(dLen[i])-2))
+	return c | i&0x3f
+}
+
+// reconst halves the frequencies and rebuilds the tree when the root's gets too high
+func (d *decoder) reconst() {
+	j := 0
+	for i := range nodes {
+		if d.son[i] >= nodes {
+			d.freq[j] = (d.freq[i] + 1) / 2
+			d.son[j] = d.son[i]
+			j++
+		}
+	}
+	for i, j := 0, nChar; j < nodes; i, j = i+2, j+1 {
+		fr := d.freq[i] + d.freq[i+1]
+		k := j - 1
+		for fr < d.freq[k] {
+			k--
+		}
+		k++
+		copy(d.freq[k+1:j+1], d.freq[k:j])
+		d.freq[k] = fr
+		copy(d.son[k+1:j+1], d.son[k:j])
+		d.son[k] = i
+	}
+	for i := range nodes {
+		k := d.son[i]
+		d.prnt[k] = i
+		if k < nodes {
+			d.prnt[k+1] = i
+		}
+	}
+}
+
+// update increments the frequency of a symbol and keeps the tree sorted by frequency
+func (d *decoder) update(c int) {
+	if d.freq[root] == maxFreq {
+		d.reconst()
+	}
+	c = d.prnt[c+nodes]
+	for {
+		d.freq[c]++
+		k := d.freq[c]
+		if l := c + 1; k > d.freq[l] {
+			for k > d.freq[l+1] {
+				l++
+			}
+			d.freq[c] = d.freq[l]
+			d.freq[l] = k
+
+			i := d.son[c]
+			d.prnt[i] = l
+			if i < nodes {
+				d.prnt[i+1] = l
+			}
+			j := d.son[l]
+			d.son[l] = i
+			d.prnt[j] = c
+			if j < nodes {
+				d.prnt[j+1] = c
+			}
+			d.son[c] = j
+			c = l
+		}
+		c = d.prnt[c]
+		if c == 0 {
+			return
+		}
+	}
+}
+
+// bitReader reads most significant bit first, and pads the end of the stream with zeros
+type bitReader struct {
+	r     io.ByteReader
+	buf   uint32
+	n     int
+	extra int // zero bytes already padded
+}
+
+func (br *bitReader) bits(n int) uint32 {
+	if n == 0 {
+		return 0
+	}
+	for br.n < n {
+		b, err := br.r.ReadByte()
+		if err != nil {
+			br.extra++
+			if br.extra > 4 {
+				panic(ErrCorrupt) // the tree is walking off into nothing
+			}
+		}
+		br.buf = br.buf<<8 | uint32(b)
+		br.n += 8
+	}
+	br.n -= n
+	return br.buf >> br.n & (1<<n - 1)
+}
@@ -0,0 +1,125 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package lzhuf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/elliotnunn/BeHierarchic/internal/bittest"
+)
+
+// encoder mirrors the decoder's tree, as LZHUF.C's Encode does
+type encoder struct {
+	d decoder
+	w bittest.Writer
+}
+
+func (e *encoder) char(c int) {
+	var path []int // from the leaf up
+	for k := e.d.prnt[c+nodes]; k != root; k = e.d.prnt[k] {
+		path = append(path, k-e.d.son[e.d.prnt[k]])
+	}
+	for i := len(path) - 1; i >= 0; i-- {
+		e.w.Put(path[i], 1)
+	}
+	e.d.update(c)
+}
+
+func (e *encoder) position(p int) {
+	hi := p >> 6
+	b := 0
+	for dCode[b] != byte(hi) {
+		b++
+	}
+	l := int(dLen[b])
+	e.w.Put(b>>(8-l), l)
+	e.w.Put(p&0x3f, 6)
+}
+
+func compress(src []byte) []byte {
+	e := &encoder{d: *newDecoder(nil)}
+	var window [ringSize]byte
+	for i := range ringSize - maxMatch {
+		window[i] = ' '
+	}
+	pos := ringSize - maxMatch
+	emit := func(b byte) {
+		window[pos] = b
+		pos = (pos + 1) & (ringSize - 1)
+	}
+	for i := 0; i < len(src); {
+		bestLen, bestFrom := 0, 0
+		for back := 1; back <= ringSize-maxMatch && back <= i; back++ {
+			l := 0
+			for l < maxMatch && i+l < len(src) && src[i-back+l] == src[i+l] && l < back {
+				l++
+			}
+			if l > bestLen {
+				bestLen, bestFrom = l, (pos-back)&(ringSize-1)
+			}
+		}
+		if bestLen > threshold {
+			e.char(bestLen + 255 - threshold)
+			e.position((pos - bestFrom - 1) & (ringSize - 1))
+			for range bestLen {
+				emit(src[i])
+				i++
+			}
+		} else {
+			e.char(int(src[i]))
+			emit(src[i])
+			i++
+		}
+	}
+	return e.w.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	var src bytes.Buffer
+	for i := range 5000 { // enough symbols for the tree to be rebuilt
+		fmt.Fprintf(&src, "line %d of a disk image, %x\n", i%97, i*i)
+	}
+	packed := compress(src.Bytes())
+
+	got, err := io.ReadAll(NewReader(bytes.NewReader(packed), int64(src.Len())))
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(got, src.Bytes()) {
+		t.Fatalf("sized: wrong output, %d bytes", len(got))
+	}
+
+	got, err = io.ReadAll(NewReader(bytes.NewReader(packed), -1))
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.HasPrefix(got, src.Bytes()) {
+		t.Fatalf("unsized: wrong output, %d bytes", len(got))
+	}
+}
+
+// leaves.lzh is as LZHUF.EXE writes it, the original size and then the stream
+func TestSample(t *testing.T) {
+	f, err := os.ReadFile("testdata/leaves.lzh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64(binary.LittleEndian.Uint32(f))
+	got, err := io.ReadAll(NewReader(bytes.NewReader(f[4:]), size))
+	if err != nil {
+		t.Fatal(err)
+	} else if want := "Fall leaves, fall leaves, fall leaves.\n"; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	_, err := io.ReadAll(NewReader(bytes.NewReader([]byte{0x12, 0x34}), 1000))
+	if err == nil {
+		t.Error("expected an error")
+	}
+}
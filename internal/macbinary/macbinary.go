@@ -0,0 +1,74 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+// Package macbinary recognises the 128-byte header of MacBinary I, II and III,
+// which carries a file's Finder metadata and the lengths of the forks that follow it.
+package macbinary
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/elliotnunn/BeHierarchic/internal/crc16"
+	"golang.org/x/text/encoding/charmap"
+)
+
+var ErrFormat = errors.New("not a valid MacBinary file")
+
+const HeaderSize = 128
+
+type Header struct {
+	Name          string
+	Type, Creator [4]byte
+	DataLen       int64
+	RsrcLen       int64
+	SecondaryLen  int64 // of an optional header that follows this one, which nothing writes
+	Version       int   // 1, 2 or 3
+	HasCRC        bool  // MacBinary II and III protect the header with a CRC
+	CRCOK         bool
+}
+
+// ParseHeader checks the bytes that every version leaves zero.
+// A MacBinary II or III header with a wrong CRC is still returned, for the caller to judge.
+func ParseHeader(h []byte) (Header, error) {
+	be := binary.BigEndian
+	if len(h) < HeaderSize || h[0] != 0 || h[1] == 0 || h[1] > 63 || h[74] != 0 || h[82] != 0 {
+		return Header{}, ErrFormat
+	}
+	hdr := Header{
+		Type:         [4]byte(h[65:69]),
+		Creator:      [4]byte(h[69:73]),
+		DataLen:      int64(be.Uint32(h[83:])),
+		RsrcLen:      int64(be.Uint32(h[87:])),
+		SecondaryLen: int64(be.Uint16(h[120:])),
+		Version:      1,
+	}
+	if hdr.DataLen > 0x7fffffff || hdr.RsrcLen > 0x7fffffff {
+		return Header{}, ErrFormat
+	}
+	hdr.Name, _ = charmap.Macintosh.NewDecoder().String(string(h[2:][:h[1]]))
+
+	switch {
+	case string(h[102:106]) == "mBIN":
+		hdr.Version, hdr.HasCRC = 3, true
+	case h[122] >= 129:
+		hdr.Version, hdr.HasCRC = 2, true
+	default:
+		for _, b := range h[99:126] {
+			if b != 0 {
+				return Header{}, ErrFormat // not MacBinary I either
+			}
+		}
+		hdr.SecondaryLen = 0
+	}
+	if hdr.HasCRC {
+		hdr.CRCOK = crc16.Checksum(h[:124]) == be.Uint16(h[124:])
+	}
+	return hdr, nil
+}
+
+// Size is the length of the whole file, with each part padded to a multiple of 128 bytes
+func (hdr Header) Size() int64 {
+	pad := func(n int64) int64 { return (n + 127) &^ 127 }
+	return HeaderSize + pad(hdr.SecondaryLen) + pad(hdr.DataLen) + pad(hdr.RsrcLen)
+}
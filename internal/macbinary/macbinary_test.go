@@ -0,0 +1,67 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package macbinary
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/elliotnunn/BeHierarchic/internal/crc16"
+)
+
+func header(version int) []byte {
+	h := make([]byte, HeaderSize)
+	h[1] = byte(copy(h[2:], "ReadMe"))
+	copy(h[65:], "TEXTttxt")
+	binary.BigEndian.PutUint32(h[83:], 300)
+	binary.BigEndian.PutUint32(h[87:], 10)
+	if version >= 2 {
+		h[122], h[123] = 129, 129
+	}
+	if version == 3 {
+		copy(h[102:], "mBIN")
+		h[122] = 130
+	}
+	if version >= 2 {
+		binary.BigEndian.PutUint16(h[124:], crc16.Checksum(h[:124]))
+	}
+	return h
+}
+
+func TestVersions(t *testing.T) {
+	for v := 1; v <= 3; v++ {
+		hdr, err := ParseHeader(header(v))
+		if err != nil {
+			t.Fatalf("MacBinary %d: %v", v, err)
+		}
+		if hdr.Version != v || hdr.HasCRC != (v >= 2) || hdr.CRCOK != (v >= 2) {
+			t.Errorf("MacBinary %d: got %+v", v, hdr)
+		}
+		if hdr.Name != "ReadMe" || string(hdr.Type[:]) != "TEXT" || hdr.DataLen != 300 || hdr.RsrcLen != 10 {
+			t.Errorf("MacBinary %d: wrong fields %+v", v, hdr)
+		}
+		if hdr.Size() != 128+384+128 {
+			t.Errorf("MacBinary %d: expected size 640, got %d", v, hdr.Size())
+		}
+	}
+}
+
+func TestBadCRC(t *testing.T) {
+	h := header(2)
+	h[10] ^= 1
+	hdr, err := ParseHeader(h)
+	if err != nil {
+		t.Fatal(err)
+	} else if !hdr.HasCRC || hdr.CRCOK {
+		t.Errorf("expected a CRC mismatch, got %+v", hdr)
+	}
+}
+
+func TestNotMacBinary(t *testing.T) {
+	h := header(1)
+	h[110] = 1 // neither a MacBinary I header nor a later one
+	if _, err := ParseHeader(h); err != ErrFormat {
+		t.Errorf("expected ErrFormat, got %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package macfont
+
+import (
+	"encoding/binary"
+	"image"
+)
+
+// bitmapFont is a 'FONT' or 'NFNT' resource: one strike of every glyph side by side,
+// with a location table giving each glyph's columns and an offset/width table giving its placement
+type bitmapFont struct {
+	first, last  int
+	kernMax      int
+	height       int
+	asc, leading int
+	descent      int
+	rowBytes     int
+	depth        int // bits per pixel, in NFNTs that have colour or greys
+	strike       []byte
+	loc          []uint16 // one more than there are glyphs, to give the last glyph's right edge
+	ow           []uint16 // 0xffff where a character has no glyph
+}
+
+func parseBitmap(data []byte) (*bitmapFont, error) {
+	be := binary.BigEndian
+	if len(data) < 26 {
+		return nil, ErrFormat
+	}
+	f := &bitmapFont{
+		first:    int(be.Uint16(data[2:])),
+		last:     int(be.Uint16(data[4:])),
+		kernMax:  int(int16(be.Uint16(data[8:]))),
+		height:   int(be.Uint16(data[14:])),
+		asc:      int(be.Uint16(data[18:])),
+		descent:  int(be.Uint16(data[20:])),
+		leading:  int(be.Uint16(data[22:])),
+		rowBytes: 2 * int(be.Uint16(data[24:])),
+		depth:    1 << (be.Uint16(data) >> 2 & 3),
+	}
+	if f.first > f.last || f.last > 255 || f.height > 1024 {
+		return nil, ErrFormat
+	}
+
+	// The offset/width table is found by a word offset, whose high word is in nDescent if positive
+	owOff := int64(be.Uint16(data[16:]))
+	if nDescent := int16(be.Uint16(data[10:])); nDescent > 0 {
+		owOff |= int64(nDescent) << 16
+	}
+	owOff = 16 + 2*owOff
+
+	n := f.last - f.first + 3 // including the missing-character glyph and the end of the table
+	strikeEnd := 26 + f.rowBytes*f.height
+	if strikeEnd+2*n > len(data) || owOff+int64(2*n) > int64(len(data)) {
+		return nil, ErrFormat
+	}
+	f.strike = data[26:strikeEnd]
+	f.loc = make([]uint16, n)
+	f.ow = make([]uint16, n)
+	binary.Decode(data[strikeEnd:], be, f.loc)
+	binary.Decode(data[owOff:], be, f.ow)
+	return f, nil
+}
+
+// glyph returns the index into the tables for a character, falling back on the missing-character glyph
+func (f *bitmapFont) glyph(r rune) (int, bool) {
+	i := int(r) - f.first
+	if r < 0 || i < 0 || i > f.last-f.first || f.ow[i] == 0xffff {
+		i = f.last - f.first + 1
+	}
+	return i, f.ow[i] != 0xffff
+}
+
+func (f *bitmapFont) ascent() int     { return f.asc }
+func (f *bitmapFont) lineHeight() int { return f.asc + f.descent + max(f.leading, 2) }
+
+func (f *bitmapFont) advance(r rune) int {
+	if i, ok := f.glyph(r); ok {
+		return int(f.ow[i] & 0xff)
+	}
+	return 0
+}
+
+func (f *bitmapFont) draw(c *canvas, x, baseline int, r rune) {
+	i, ok := f.glyph(r)
+	if !ok {
+		return
+	}
+	x += f.kernMax + int(f.ow[i]>>8)
+	top := baseline - f.asc
+	maxVal := 1<<f.depth - 1
+	for col := int(f.loc[i]); col < int(f.loc[i+1]); col++ {
+		bit := col * f.depth
+		if bit/8 >= f.rowBytes {
+			break
+		}
+		for row := range f.height {
+			b := f.strike[row*f.rowBytes+bit/8]
+			val := int(b>>(8-f.depth-bit%8)) & maxVal
+			c.ink(x+col-int(f.loc[i]), top+row, float64(val)/float64(maxVal))
+		}
+	}
+}
+
+// bitmapSpecimen shows every character in the font, then a sentence, at double size
+func bitmapSpecimen(data []byte) (image.Image, error) {
+	f, err := parseBitmap(data)
+	if err != nil {
+		return nil, err
+	}
+	var lines []line
+	for start := f.first; start <= f.last; start += 32 {
+		var chars []rune
+		for ch := start; ch < start+32 && ch <= f.last; ch++ {
+			if ch >= 0x20 && ch != 0x7f { // control characters have no sensible glyph
+				chars = append(chars, rune(ch))
+			}
+		}
+		if len(chars) > 0 {
+			lines = append(lines, line{f, string(chars)})
+		}
+	}
+	lines = append(lines, line{f, pangram})
+	return specimen(lines).scaled(2), nil
+}
@@ -0,0 +1,121 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+// Package macfont renders specimen sheets of classic Mac OS fonts:
+// the bitmap 'FONT' and 'NFNT' resources described in Inside Macintosh: Text,
+// and the TrueType outlines of 'sfnt' resources. PostScript-flavoured (CFF) outlines are not supported.
+package macfont
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+var (
+	ErrFormat = errors.New("not a valid font")
+	ErrCFF    = errors.New("font: PostScript outlines are not supported")
+)
+
+const (
+	margin  = 12
+	pangram = "The quick brown fox jumps over the lazy dog."
+)
+
+// IsFont checks for a resource type that [PNG] can render
+func IsFont(resType string) bool {
+	return resType == "FONT" || resType == "NFNT" || resType == "sfnt"
+}
+
+// PNG renders a specimen sheet of the font in a resource of the given type
+func PNG(resType string, data []byte) ([]byte, error) {
+	var img image.Image
+	var err error
+	switch resType {
+	case "FONT", "NFNT":
+		img, err = bitmapSpecimen(data)
+	case "sfnt":
+		img, err = outlineSpecimen(data)
+	default:
+		return nil, ErrFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	err = png.Encode(buf, img)
+	return buf.Bytes(), err
+}
+
+// face is a font at one size, in pixels
+type face interface {
+	ascent() int
+	lineHeight() int
+	advance(r rune) int
+	draw(c *canvas, x, baseline int, r rune)
+}
+
+type line struct {
+	f    face
+	text string
+}
+
+// specimen lays out lines of text, one above the other
+func specimen(lines []line) *canvas {
+	w, h := 0, margin
+	for _, l := range lines {
+		adv := 0
+		for _, r := range l.text {
+			adv += l.f.advance(r)
+		}
+		w = max(w, adv)
+		h += l.f.lineHeight()
+	}
+	c := newCanvas(w+2*margin, h+margin)
+	y := margin
+	for _, l := range lines {
+		x := margin
+		for _, r := range l.text {
+			l.f.draw(c, x, y+l.f.ascent(), r)
+			x += l.f.advance(r)
+		}
+		y += l.f.lineHeight()
+	}
+	return c
+}
+
+// canvas is black ink on white paper, where overlapping ink does not accumulate
+type canvas struct{ *image.Gray }
+
+func newCanvas(w, h int) *canvas {
+	c := &canvas{image.NewGray(image.Rect(0, 0, max(w, 1), max(h, 1)))}
+	for i := range c.Pix {
+		c.Pix[i] = 0xff
+	}
+	return c
+}
+
+// ink darkens a pixel by a coverage between 0 and 1
+func (c *canvas) ink(x, y int, coverage float64) {
+	if !(image.Point{x, y}.In(c.Rect)) || coverage <= 0 {
+		return
+	}
+	v := uint8(255 - min(coverage, 1)*255)
+	if i := c.PixOffset(x, y); v < c.Pix[i] {
+		c.Pix[i] = v
+	}
+}
+
+// scaled enlarges the canvas by an integer factor, so that pixels stay crisp
+func (c *canvas) scaled(n int) *image.Gray {
+	b := c.Rect
+	dst := image.NewGray(image.Rect(0, 0, b.Dx()*n, b.Dy()*n))
+	for y := range dst.Rect.Dy() {
+		for x := range dst.Rect.Dx() {
+			dst.SetGray(x, y, color.Gray{c.Pix[c.PixOffset(x/n, y/n)]})
+		}
+	}
+	return dst
+}
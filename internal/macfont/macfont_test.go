@@ -0,0 +1,152 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package macfont
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image/png"
+	"testing"
+)
+
+// bitmapFixture has an 'A' that is a solid 4x5 block, no 'B', and a 2-pixel missing-character glyph
+func bitmapFixture() []byte {
+	be := binary.BigEndian
+	var h []byte
+	for _, v := range []uint16{0x9000, 'A', 'B', 5, 0, 0, 4, 5, 14, 4, 1, 0, 1} {
+		h = be.AppendUint16(h, v)
+	}
+	for range 5 {
+		h = append(h, 0b1111_0011, 0) // A, then the missing-character glyph
+	}
+	for _, v := range []uint16{0, 4, 4, 6} { // location table
+		h = be.AppendUint16(h, v)
+	}
+	for _, v := range []uint16{0x0005, 0xffff, 0x0003, 0xffff} { // offset/width table
+		h = be.AppendUint16(h, v)
+	}
+	return h
+}
+
+func TestBitmap(t *testing.T) {
+	f, err := parseBitmap(bitmapFixture())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, b := f.advance('A'), f.advance('B'); a != 5 || b != 3 {
+		t.Errorf("expected advances of 5 and 3 (missing character), got %d and %d", a, b)
+	}
+	c := newCanvas(10, 10)
+	f.draw(c, 0, 4, 'A')
+	if c.GrayAt(0, 0).Y != 0 || c.GrayAt(3, 4).Y != 0 {
+		t.Error("expected the glyph to be inked")
+	}
+	if c.GrayAt(4, 0).Y != 0xff || c.GrayAt(0, 5).Y != 0xff {
+		t.Error("expected ink only within the glyph")
+	}
+}
+
+func TestBitmapPNG(t *testing.T) {
+	data, err := PNG("NFNT", bitmapFixture())
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	} else if img.Bounds().Dx() < 2*margin || img.Bounds().Dy() < 2*margin {
+		t.Errorf("implausible specimen size %v", img.Bounds())
+	}
+}
+
+func TestBitmapTruncated(t *testing.T) {
+	data := bitmapFixture()
+	_, err := parseBitmap(data[:len(data)-2])
+	if err != ErrFormat {
+		t.Errorf("expected ErrFormat, got %v", err)
+	}
+}
+
+// outlineFixture maps 'A' to a square from (100,0) to (900,800) in a 1000-unit em
+func outlineFixture() []byte {
+	be := binary.BigEndian
+	u16s := func(vs ...int) []byte {
+		var b []byte
+		for _, v := range vs {
+			b = be.AppendUint16(b, uint16(v))
+		}
+		return b
+	}
+	head := make([]byte, 54)
+	be.PutUint16(head[18:], 1000)
+	maxp := u16s(0, 0x5000, 2)
+	hhea := make([]byte, 36)
+	be.PutUint16(hhea[34:], 2)
+	hmtx := u16s(500, 0, 1000, 100)
+	cmap := append(u16s(0, 1, 1, 0, 0, 12, 0, 262, 0), make([]byte, 256)...)
+	cmap[12+6+'A'] = 1
+	glyph := append(u16s(1, 100, 0, 900, 800, 3, 0), 1, 1, 1, 1)
+	glyph = append(glyph, u16s(100, 800, 0, -800, 0, 0, 800, 0)...)
+	loca := u16s(0, 0, len(glyph)/2)
+
+	tables := []struct {
+		tag  string
+		data []byte
+	}{{"cmap", cmap}, {"glyf", glyph}, {"head", head}, {"hhea", hhea}, {"hmtx", hmtx}, {"loca", loca}, {"maxp", maxp}}
+	font := u16s(1, 0, len(tables), 0, 0, 0)
+	off := len(font) + 16*len(tables)
+	var body []byte
+	for _, t := range tables {
+		font = append(font, t.tag...)
+		font = be.AppendUint32(font, 0)
+		font = be.AppendUint32(font, uint32(off+len(body)))
+		font = be.AppendUint32(font, uint32(len(t.data)))
+		body = append(body, t.data...)
+		for len(body)%4 != 0 {
+			body = append(body, 0)
+		}
+	}
+	return append(font, body...)
+}
+
+func TestOutline(t *testing.T) {
+	f, err := parseOutline(outlineFixture())
+	if err != nil {
+		t.Fatal(err)
+	}
+	face := outlineFace{f, 100}
+	if a, b := face.advance('A'), face.advance('B'); a != 100 || b != 50 {
+		t.Errorf("expected advances of 100 and 50 (.notdef), got %d and %d", a, b)
+	}
+	c := newCanvas(100, 100)
+	face.draw(c, 0, 90, 'A')
+	if y := c.GrayAt(50, 50).Y; y != 0 {
+		t.Errorf("expected the inside of the square to be black, got %d", y)
+	}
+	if y := c.GrayAt(5, 50).Y; y != 0xff {
+		t.Errorf("expected the outside of the square to be white, got %d", y)
+	}
+	if y := c.GrayAt(50, 5).Y; y != 0xff {
+		t.Errorf("expected above the square to be white, got %d", y)
+	}
+}
+
+func TestOutlinePNG(t *testing.T) {
+	data, err := PNG("sfnt", outlineFixture())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCFF(t *testing.T) {
+	data := outlineFixture()
+	copy(data, "OTTO")
+	if _, err := PNG("sfnt", data); !errors.Is(err, ErrCFF) {
+		t.Errorf("expected ErrCFF, got %v", err)
+	}
+}
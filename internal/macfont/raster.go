@@ -0,0 +1,141 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package macfont
+
+import (
+	"math"
+	"slices"
+)
+
+// A small scanline rasterizer: outlines are flattened to edges, then each pixel row is sampled
+// at several heights and inked where the nonzero winding rule says it is inside
+
+const (
+	subRows  = 4
+	curveSeg = 8 // line segments per quadratic curve
+)
+
+type edge struct {
+	x0, y0, x1, y1 float64
+}
+
+// appendContour flattens a TrueType contour, in which two off-curve points imply an on-curve point between them
+func appendContour(edges []edge, pts []point) []edge {
+	if len(pts) < 2 {
+		return edges
+	}
+	mid := func(a, b point) point { return point{(a.x + b.x) / 2, (a.y + b.y) / 2, true} }
+
+	// Start on an on-curve point, implying one if there is none, and come back round to it
+	var start point
+	var rest []point
+	if i := slices.IndexFunc(pts, func(p point) bool { return p.on }); i >= 0 {
+		start = pts[i]
+		rest = append(slices.Clone(pts[i+1:]), pts[:i+1]...)
+	} else {
+		start = mid(pts[len(pts)-1], pts[0])
+		rest = append(slices.Clone(pts), start)
+	}
+
+	pen := start
+	var ctrl point
+	haveCtrl := false
+	for _, p := range rest {
+		switch {
+		case p.on && !haveCtrl:
+			edges = append(edges, edge{pen.x, pen.y, p.x, p.y})
+			pen = p
+		case p.on:
+			edges = appendQuad(edges, pen, ctrl, p)
+			pen, haveCtrl = p, false
+		case !haveCtrl:
+			ctrl, haveCtrl = p, true
+		default:
+			m := mid(ctrl, p)
+			edges = appendQuad(edges, pen, ctrl, m)
+			pen, ctrl = m, p
+		}
+	}
+	return edges
+}
+
+func appendQuad(edges []edge, a, c, b point) []edge {
+	prev := a
+	for i := 1; i <= curveSeg; i++ {
+		t := float64(i) / curveSeg
+		u := 1 - t
+		p := point{u*u*a.x + 2*u*t*c.x + t*t*b.x, u*u*a.y + 2*u*t*c.y + t*t*b.y, true}
+		edges = append(edges, edge{prev.x, prev.y, p.x, p.y})
+		prev = p
+	}
+	return edges
+}
+
+// fill inks the inside of the edges, with antialiasing from partial horizontal coverage
+func fill(c *canvas, edges []edge) {
+	if len(edges) == 0 {
+		return
+	}
+	top, bottom := math.Inf(1), math.Inf(-1)
+	for _, e := range edges {
+		top = min(top, e.y0, e.y1)
+		bottom = max(bottom, e.y0, e.y1)
+	}
+	y0 := max(int(math.Floor(top)), c.Rect.Min.Y)
+	y1 := min(int(math.Ceil(bottom)), c.Rect.Max.Y)
+	if y0 >= y1 {
+		return
+	}
+
+	w := c.Rect.Dx()
+	cov := make([]float64, w)
+	type crossing struct {
+		x   float64
+		dir int
+	}
+	var xs []crossing
+	for y := y0; y < y1; y++ {
+		clear(cov)
+		for s := range subRows {
+			sy := float64(y) + (float64(s)+0.5)/subRows
+			xs = xs[:0]
+			for _, e := range edges {
+				if e.y0 == e.y1 || sy < min(e.y0, e.y1) || sy >= max(e.y0, e.y1) {
+					continue
+				}
+				dir := 1
+				if e.y1 < e.y0 {
+					dir = -1
+				}
+				xs = append(xs, crossing{e.x0 + (sy-e.y0)*(e.x1-e.x0)/(e.y1-e.y0), dir})
+			}
+			slices.SortFunc(xs, func(a, b crossing) int {
+				if a.x < b.x {
+					return -1
+				} else if a.x > b.x {
+					return 1
+				}
+				return 0
+			})
+			wind := 0
+			for i := range xs {
+				wind += xs[i].dir
+				if wind != 0 && i+1 < len(xs) {
+					addSpan(cov, xs[i].x, xs[i+1].x)
+				}
+			}
+		}
+		for x, v := range cov {
+			c.ink(c.Rect.Min.X+x, y, v/subRows)
+		}
+	}
+}
+
+// addSpan adds the fraction of each pixel covered by the span from xa to xb
+func addSpan(cov []float64, xa, xb float64) {
+	xa, xb = max(xa, 0), min(xb, float64(len(cov)))
+	for x := int(xa); x < len(cov) && float64(x) < xb; x++ {
+		cov[x] += min(xb, float64(x+1)) - max(xa, float64(x))
+	}
+}
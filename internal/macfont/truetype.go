@@ -0,0 +1,410 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package macfont
+
+import (
+	"encoding/binary"
+	"image"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// TrueType as in Apple's TrueType Reference Manual, reading just enough tables to draw unhinted outlines
+
+const maxComposite = 8 // nesting of composite glyphs
+
+type outlineFont struct {
+	glyf, loca, hmtx []byte
+	unitsPerEm       int
+	longLoca         bool
+	numGlyphs        int
+	numHMetrics      int
+	cmap             func(r rune) int
+}
+
+func parseOutline(data []byte) (*outlineFont, error) {
+	be := binary.BigEndian
+	if len(data) < 12 {
+		return nil, ErrFormat
+	}
+	switch string(data[:4]) {
+	case "\x00\x01\x00\x00", "true":
+	case "OTTO":
+		return nil, ErrCFF
+	default:
+		return nil, ErrFormat
+	}
+	tables := make(map[string][]byte)
+	n := int(be.Uint16(data[4:]))
+	if 12+16*n > len(data) {
+		return nil, ErrFormat
+	}
+	for i := range n {
+		rec := data[12+16*i:]
+		off, size := int64(be.Uint32(rec[8:])), int64(be.Uint32(rec[12:]))
+		if off+size > int64(len(data)) {
+			return nil, ErrFormat
+		}
+		tables[string(rec[:4])] = data[off:][:size]
+	}
+
+	head, maxp, hhea := tables["head"], tables["maxp"], tables["hhea"]
+	if len(head) < 54 || len(maxp) < 6 || len(hhea) < 36 {
+		return nil, ErrFormat
+	}
+	f := &outlineFont{
+		glyf:        tables["glyf"],
+		loca:        tables["loca"],
+		hmtx:        tables["hmtx"],
+		unitsPerEm:  int(be.Uint16(head[18:])),
+		longLoca:    be.Uint16(head[50:]) != 0,
+		numGlyphs:   int(be.Uint16(maxp[4:])),
+		numHMetrics: int(be.Uint16(hhea[34:])),
+	}
+	if f.unitsPerEm == 0 || f.numHMetrics == 0 || len(f.hmtx) < 4*f.numHMetrics {
+		return nil, ErrFormat
+	}
+	f.cmap = parseCmap(tables["cmap"])
+	if f.cmap == nil {
+		return nil, ErrFormat
+	}
+	return f, nil
+}
+
+// parseCmap prefers a Unicode mapping, but old Mac fonts often have only a Mac Roman one
+func parseCmap(cmap []byte) func(rune) int {
+	be := binary.BigEndian
+	if len(cmap) < 4 {
+		return nil
+	}
+	var unicode, roman []byte
+	for i := range int(be.Uint16(cmap[2:])) {
+		if 4+8*i+8 > len(cmap) {
+			break
+		}
+		rec := cmap[4+8*i:]
+		off := int(be.Uint32(rec[4:]))
+		if off+4 > len(cmap) {
+			continue
+		}
+		switch platform, encoding := be.Uint16(rec), be.Uint16(rec[2:]); {
+		case platform == 0, platform == 3 && encoding == 1:
+			unicode = cmap[off:]
+		case platform == 1 && encoding == 0:
+			roman = cmap[off:]
+		}
+	}
+	if lookup := cmapSubtable(unicode); lookup != nil {
+		return lookup
+	} else if lookup := cmapSubtable(roman); lookup != nil {
+		enc := charmap.Macintosh.NewEncoder()
+		return func(r rune) int {
+			b, err := enc.Bytes([]byte(string(r)))
+			if err != nil || len(b) != 1 {
+				return 0
+			}
+			return lookup(rune(b[0]))
+		}
+	}
+	return nil
+}
+
+// cmapSubtable handles formats 0, 4 and 6, which cover every Mac and Windows TrueType font in practice
+func cmapSubtable(t []byte) func(rune) int {
+	be := binary.BigEndian
+	if len(t) < 6 {
+		return nil
+	}
+	switch be.Uint16(t) {
+	case 0:
+		if len(t) < 6+256 {
+			return nil
+		}
+		return func(r rune) int {
+			if r < 0 || r > 255 {
+				return 0
+			}
+			return int(t[6+r])
+		}
+	case 4:
+		if len(t) < 14 {
+			return nil
+		}
+		segs := int(be.Uint16(t[6:])) / 2
+		if 16+8*segs > len(t) {
+			return nil
+		}
+		ends, starts := t[14:], t[16+2*segs:]
+		deltas, ranges := t[16+4*segs:], t[16+6*segs:]
+		return func(r rune) int {
+			for i := range segs {
+				end, start := rune(be.Uint16(ends[2*i:])), rune(be.Uint16(starts[2*i:]))
+				if r > end {
+					continue
+				} else if r < start {
+					return 0
+				}
+				delta, ro := int(be.Uint16(deltas[2*i:])), int(be.Uint16(ranges[2*i:]))
+				if ro == 0 {
+					return (int(r) + delta) & 0xffff
+				}
+				at := 2*i + ro + 2*int(r-start) // relative to this segment's idRangeOffset
+				if at+2 > len(ranges) {
+					return 0
+				}
+				if g := int(be.Uint16(ranges[at:])); g != 0 {
+					return (g + delta) & 0xffff
+				}
+				return 0
+			}
+			return 0
+		}
+	case 6:
+		if len(t) < 10 {
+			return nil
+		}
+		first, count := rune(be.Uint16(t[6:])), rune(be.Uint16(t[8:]))
+		if 10+2*int(count) > len(t) {
+			return nil
+		}
+		return func(r rune) int {
+			if r < first || r >= first+count {
+				return 0
+			}
+			return int(be.Uint16(t[10+2*(r-first):]))
+		}
+	}
+	return nil
+}
+
+func (f *outlineFont) advanceUnits(g int) int {
+	i := min(g, f.numHMetrics-1)
+	return int(binary.BigEndian.Uint16(f.hmtx[4*i:]))
+}
+
+func (f *outlineFont) glyphData(g int) []byte {
+	be := binary.BigEndian
+	if g < 0 || g >= f.numGlyphs {
+		return nil
+	}
+	var start, end int
+	if f.longLoca {
+		if 4*g+8 > len(f.loca) {
+			return nil
+		}
+		start, end = int(be.Uint32(f.loca[4*g:])), int(be.Uint32(f.loca[4*g+4:]))
+	} else {
+		if 2*g+4 > len(f.loca) {
+			return nil
+		}
+		start, end = 2*int(be.Uint16(f.loca[2*g:])), 2*int(be.Uint16(f.loca[2*g+2:]))
+	}
+	if start >= end || end > len(f.glyf) {
+		return nil
+	}
+	return f.glyf[start:end]
+}
+
+type point struct {
+	x, y float64
+	on   bool
+}
+
+// contours returns a glyph's outline in font units, flattening composite glyphs
+func (f *outlineFont) contours(g, depth int) [][]point {
+	be := binary.BigEndian
+	d := f.glyphData(g)
+	if len(d) < 10 || depth > maxComposite {
+		return nil
+	}
+	nContours := int(int16(be.Uint16(d)))
+	if nContours < 0 {
+		return f.composite(d[10:], depth)
+	}
+
+	p := 10
+	if p+2*nContours+2 > len(d) {
+		return nil
+	}
+	ends := make([]int, nContours)
+	for i := range ends {
+		ends[i] = int(be.Uint16(d[p:]))
+		p += 2
+	}
+	if nContours == 0 {
+		return nil
+	}
+	nPoints := ends[nContours-1] + 1
+	p += 2 + int(be.Uint16(d[p:])) // skip the instructions
+
+	flags := make([]byte, 0, nPoints)
+	for len(flags) < nPoints {
+		if p >= len(d) {
+			return nil
+		}
+		fl := d[p]
+		p++
+		flags = append(flags, fl)
+		if fl&8 != 0 { // repeat
+			if p >= len(d) {
+				return nil
+			}
+			for range d[p] {
+				flags = append(flags, fl)
+			}
+			p++
+		}
+	}
+	flags = flags[:nPoints]
+
+	pts := make([]point, nPoints)
+	// Each axis is short (one byte, with the sign in the second flag) or, unless the same, two bytes
+	for axis, bits := range [2][2]byte{{0x02, 0x10}, {0x04, 0x20}} {
+		short, same := bits[0], bits[1]
+		v := 0
+		for i, fl := range flags {
+			switch {
+			case fl&short != 0:
+				if p >= len(d) {
+					return nil
+				}
+				if fl&same != 0 {
+					v += int(d[p])
+				} else {
+					v -= int(d[p])
+				}
+				p++
+			case fl&same == 0:
+				if p+2 > len(d) {
+					return nil
+				}
+				v += int(int16(be.Uint16(d[p:])))
+				p += 2
+			}
+			if axis == 0 {
+				pts[i].x = float64(v)
+			} else {
+				pts[i].y = float64(v)
+			}
+			pts[i].on = fl&1 != 0
+		}
+	}
+
+	var cs [][]point
+	start := 0
+	for _, end := range ends {
+		if end < start || end >= nPoints {
+			return cs
+		}
+		cs = append(cs, pts[start:end+1])
+		start = end + 1
+	}
+	return cs
+}
+
+func (f *outlineFont) composite(d []byte, depth int) [][]point {
+	be := binary.BigEndian
+	const (
+		argsAreWords = 0x0001
+		argsAreXY    = 0x0002
+		haveScale    = 0x0008
+		moreComps    = 0x0020
+		haveXYScale  = 0x0040
+		haveTwoByTwo = 0x0080
+		fixed214     = 1 << 14
+	)
+	var cs [][]point
+	for {
+		if len(d) < 4 {
+			return cs
+		}
+		flags, g := be.Uint16(d), int(be.Uint16(d[2:]))
+		d = d[4:]
+		var dx, dy float64
+		if flags&argsAreWords != 0 {
+			if len(d) < 4 {
+				return cs
+			}
+			dx, dy = float64(int16(be.Uint16(d))), float64(int16(be.Uint16(d[2:])))
+			d = d[4:]
+		} else {
+			if len(d) < 2 {
+				return cs
+			}
+			dx, dy = float64(int8(d[0])), float64(int8(d[1]))
+			d = d[2:]
+		}
+		if flags&argsAreXY == 0 {
+			dx, dy = 0, 0 // anchored by point numbers, which is rare enough to ignore
+		}
+		a, b, c, e := 1.0, 0.0, 0.0, 1.0
+		f214 := func(i int) float64 { return float64(int16(be.Uint16(d[2*i:]))) / fixed214 }
+		switch {
+		case flags&haveScale != 0 && len(d) >= 2:
+			a = f214(0)
+			e = a
+			d = d[2:]
+		case flags&haveXYScale != 0 && len(d) >= 4:
+			a, e = f214(0), f214(1)
+			d = d[4:]
+		case flags&haveTwoByTwo != 0 && len(d) >= 8:
+			a, b, c, e = f214(0), f214(1), f214(2), f214(3)
+			d = d[8:]
+		}
+		for _, contour := range f.contours(g, depth+1) {
+			t := make([]point, len(contour))
+			for i, pt := range contour {
+				t[i] = point{a*pt.x + c*pt.y + dx, b*pt.x + e*pt.y + dy, pt.on}
+			}
+			cs = append(cs, t)
+		}
+		if flags&moreComps == 0 {
+			return cs
+		}
+	}
+}
+
+// outlineFace is an outline font at a size in pixels per em
+type outlineFace struct {
+	f    *outlineFont
+	size float64
+}
+
+func (o outlineFace) scale() float64  { return o.size / float64(o.f.unitsPerEm) }
+func (o outlineFace) ascent() int     { return int(o.size * 0.9) }
+func (o outlineFace) lineHeight() int { return int(o.size*1.25) + 1 }
+func (o outlineFace) advance(r rune) int {
+	return int(float64(o.f.advanceUnits(o.f.cmap(r)))*o.scale() + 0.5)
+}
+
+func (o outlineFace) draw(c *canvas, x, baseline int, r rune) {
+	s := o.scale()
+	var edges []edge
+	for _, contour := range o.f.contours(o.f.cmap(r), 0) {
+		for i := range contour {
+			contour[i].x = float64(x) + contour[i].x*s
+			contour[i].y = float64(baseline) - contour[i].y*s
+		}
+		edges = appendContour(edges, contour)
+	}
+	fill(c, edges)
+}
+
+// outlineSpecimen shows the printable ASCII characters, then a sentence at several sizes
+func outlineSpecimen(data []byte) (image.Image, error) {
+	f, err := parseOutline(data)
+	if err != nil {
+		return nil, err
+	}
+	big := outlineFace{f, 32}
+	lines := []line{
+		{big, "ABCDEFGHIJKLMNOPQRSTUVWXYZ"},
+		{big, "abcdefghijklmnopqrstuvwxyz"},
+		{big, "0123456789 !\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"},
+	}
+	for _, size := range []float64{9, 12, 18, 24} {
+		lines = append(lines, line{outlineFace{f, size}, pangram})
+	}
+	return specimen(lines).Gray, nil
+}
@@ -0,0 +1,193 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+// Implement fs.FS for the Macintosh File System of the first 400K floppies,
+// which HFS replaced in 1985. MFS has a single flat directory:
+// the folders that the Finder showed were kept only in each file's Finder info,
+// so every file is at the root.
+package mfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/elliotnunn/BeHierarchic/internal/appledouble"
+	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
+	"github.com/elliotnunn/BeHierarchic/internal/guard"
+	"github.com/elliotnunn/BeHierarchic/internal/multireaderat"
+	"github.com/elliotnunn/BeHierarchic/internal/sectionreader"
+)
+
+const (
+	mdbOffset  = 0x400
+	mapOffset  = 0x40 // the allocation block map follows the Master Directory Block
+	sectorSize = 512
+	entryFixed = 0x32 // the length of a directory entry before the name
+	firstBlock = 2    // the number of the first allocation block
+)
+
+// IsVolume checks the signature and the sizes in the first bytes of the Master Directory Block
+func IsVolume(mdb []byte) bool {
+	if len(mdb) < mapOffset || mdb[0] != 0xd2 || mdb[1] != 0xd7 {
+		return false
+	}
+	be := binary.BigEndian
+	drDirSt, drBlLen := be.Uint16(mdb[0x0e:]), be.Uint16(mdb[0x10:])
+	drNmAlBlks, drAlBlkSiz := be.Uint16(mdb[0x12:]), be.Uint32(mdb[0x14:])
+	return drDirSt > 2 && drBlLen > 0 && drNmAlBlks > 0 && drAlBlkSiz >= sectorSize && drAlBlkSiz%sectorSize == 0 &&
+		mdb[0x24] > 0 && mdb[0x24] < 28 // volume name
+}
+
+// New opens an MFS image
+func New(r io.ReaderAt) (fs.FS, error) {
+	return New2(r, r)
+}
+
+// New2 routes headers and data requests through different readers, to help exotic caching schemes
+func New2(headerReader, dataReader io.ReaderAt) (retfs fs.FS, reterr error) {
+	defer guard.Recover(&reterr, "MFS", nil)
+	be := binary.BigEndian
+	mdb := make([]byte, mapOffset)
+	if _, err := headerReader.ReadAt(mdb, mdbOffset); err != nil {
+		return nil, fmt.Errorf("MFS Master Directory Block unreadable: %w", err)
+	}
+	if !IsVolume(mdb) {
+		return nil, errors.New("MFS magic number absent")
+	}
+	drDirSt, drBlLen := int64(be.Uint16(mdb[0x0e:])), int64(be.Uint16(mdb[0x10:]))
+	drNmAlBlks, drAlBlkSiz := int(be.Uint16(mdb[0x12:])), int64(be.Uint32(mdb[0x14:]))
+	drAlBlSt := int64(be.Uint16(mdb[0x1c:]))
+
+	mdb = make([]byte, mapOffset+(drNmAlBlks*12+7)/8)
+	if _, err := headerReader.ReadAt(mdb, mdbOffset); err != nil {
+		return nil, fmt.Errorf("MFS allocation block map unreadable: %w", err)
+	}
+	v := &volume{
+		blockMap: mdb[mapOffset:],
+		nblocks:  drNmAlBlks,
+		blkSize:  drAlBlkSiz,
+		blkStart: drAlBlSt * sectorSize,
+	}
+
+	dir := make([]byte, drBlLen*sectorSize)
+	if _, err := headerReader.ReadAt(dir, drDirSt*sectorSize); err != nil {
+		return nil, fmt.Errorf("MFS directory unreadable: %w", err)
+	}
+
+	fsys := fskeleton.New()
+	defer fsys.NoMore()
+
+	bb := make([]byte, mdbOffset)
+	n, _ := headerReader.ReadAt(bb, 0)
+	info := volumeInfo(bb[:n], mdb)
+	fsys.CreateReaderAt(volumeInfoName, 0, strings.NewReader(info), int64(len(info)), 0, appledouble.MacTime(be.Uint32(mdb[0x02:])))
+
+	// Make sure fskeleton finds out about forks in the order that they exist on disk
+	deferred := make(map[int64]func())
+
+	for sector := range slices.Chunk(dir, sectorSize) {
+		for off := 0; off+entryFixed < len(sector); {
+			e := sector[off:]
+			if e[0]&0x80 == 0 { // no more entries in this sector
+				break
+			}
+			entryLen := (entryFixed + 1 + int(e[entryFixed]) + 1) &^ 1
+			if off+entryLen > len(sector) {
+				break
+			}
+			off += entryLen
+
+			name := strings.ReplaceAll(stringFromRoman(e[entryFixed+1:][:e[entryFixed]]), "/", ":")
+			if name == "" || !fs.ValidPath(name) {
+				continue
+			}
+			flNum := be.Uint32(e[0x12:])
+
+			var meta appledouble.AppleDouble
+			meta.LoadFInfo((*[16]byte)(e[0x02:]))
+			meta.CreateTime = appledouble.MacTime(be.Uint32(e[0x2a:]))
+			meta.ModTime = appledouble.MacTime(be.Uint32(e[0x2e:]))
+			meta.Locked = e[0]&1 != 0
+
+			dfStart, dfSize := be.Uint16(e[0x16:]), int64(be.Uint32(e[0x18:]))
+			rfStart, rfSize := be.Uint16(e[0x20:]), int64(be.Uint32(e[0x22:]))
+			dfReader, rfReader := v.fork(dataReader, dfStart, dfSize), v.fork(dataReader, rfStart, rfSize)
+			dfSize, rfSize = dfReader.Size(), rfReader.Size() // if the chain was short
+			adReader, adSize := meta.WithResourceFork(rfReader, rfSize)
+
+			dfID := fileID(dfStart, dfSize, false, flNum)
+			rfID := fileID(rfStart, rfSize, true, flNum)
+
+			deferred[dfID] = func() { fsys.CreateReaderAt(name, dfID, dfReader, dfSize, 0, meta.ModTime) }
+			deferred[rfID] = func() { fsys.CreateReaderAt(appledouble.Sidecar(name), rfID, adReader, adSize, 0, meta.ModTime) }
+		}
+	}
+
+	for _, offset := range slices.Sorted(maps.Keys(deferred)) {
+		deferred[offset]()
+	}
+	return fsys, nil
+}
+
+// fileID makes a durable 64-bit ID for fskeleton, as the hfs package does:
+// high [15b x zero] [16b x firstalloc/zero] [1b x isAppleDouble] [32b x file number] low
+func fileID(firstBlock uint16, size int64, isAppleDouble bool, flNum uint32) int64 {
+	if size == 0 {
+		firstBlock = 0
+	}
+	n := int64(firstBlock) << 33
+	if isAppleDouble {
+		n |= 1 << 32
+	}
+	n |= int64(flNum)
+	return n
+}
+
+type volume struct {
+	blockMap []byte
+	nblocks  int
+	blkSize  int64
+	blkStart int64 // in bytes
+}
+
+// next reads the 12-bit entry of the allocation block map: the next block of the file, or 1 at the end
+func (v *volume) next(n uint16) uint16 {
+	i := int(n) - firstBlock
+	if i < 0 || i >= v.nblocks {
+		return 0
+	}
+	b := v.blockMap[i*3/2:]
+	if i%2 == 0 {
+		return uint16(b[0])<<4 | uint16(b[1])>>4
+	}
+	return uint16(b[0]&0xf)<<8 | uint16(b[1])
+}
+
+// fork follows a chain of allocation blocks, stopping early if the chain is broken
+func (v *volume) fork(r io.ReaderAt, start uint16, size int64) multireaderat.SizeReaderAt {
+	var parts []multireaderat.SizeReaderAt
+	var runStart, runEnd int64 = -1, -1
+	got := int64(0)
+	for i, n := 0, start; got < size && i < v.nblocks && n >= firstBlock && int(n-firstBlock) < v.nblocks; i, n = i+1, v.next(n) {
+		off := v.blkStart + int64(n-firstBlock)*v.blkSize
+		if off != runEnd {
+			if runStart >= 0 {
+				parts = append(parts, sectionreader.Section(r, runStart, runEnd-runStart))
+			}
+			runStart = off
+		}
+		runEnd = off + v.blkSize
+		got += v.blkSize
+	}
+	if runStart >= 0 {
+		parts = append(parts, sectionreader.Section(r, runStart, runEnd-runStart))
+	}
+	data := multireaderat.New(parts...)
+	return sectionreader.Section(data, 0, min(size, data.Size()))
+}
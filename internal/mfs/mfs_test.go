@@ -0,0 +1,148 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package mfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+const (
+	testBlkSize = 1024
+	testAlBlSt  = 16 // in sectors, after the directory
+)
+
+// testImage makes a 400K floppy with a file whose data fork is fragmented,
+// and whose blocks are given out of order to make sure the chain is followed
+func testImage() []byte {
+	be := binary.BigEndian
+	img := make([]byte, 400*1024)
+	mdb := img[mdbOffset:]
+	mdb[0], mdb[1] = 0xd2, 0xd7
+	be.PutUint16(mdb[0x0c:], 2)  // files
+	be.PutUint16(mdb[0x0e:], 4)  // directory start
+	be.PutUint16(mdb[0x10:], 12) // directory length
+	be.PutUint16(mdb[0x12:], 391)
+	be.PutUint32(mdb[0x14:], testBlkSize)
+	be.PutUint16(mdb[0x1c:], testAlBlSt)
+	mdb[0x24] = byte(copy(mdb[0x25:], "Untitled"))
+
+	setNext := func(n, next uint16) {
+		i := int(n) - firstBlock
+		b := mdb[mapOffset+i*3/2:]
+		if i%2 == 0 {
+			b[0], b[1] = byte(next>>4), b[1]&0xf|byte(next<<4)
+		} else {
+			b[0], b[1] = b[0]&0xf0|byte(next>>8), byte(next)
+		}
+	}
+	block := func(n int) []byte { return img[testAlBlSt*sectorSize+(n-firstBlock)*testBlkSize:][:testBlkSize] }
+
+	// "Read Me": data in blocks 5 then 2 then 3, resource fork in block 7
+	setNext(5, 2)
+	setNext(2, 3)
+	setNext(3, 1)
+	setNext(7, 1)
+	copy(block(5), bytes.Repeat([]byte{'a'}, testBlkSize))
+	copy(block(2), bytes.Repeat([]byte{'b'}, testBlkSize))
+	copy(block(3), "cc")
+	copy(block(7), "resource")
+	// "Empty": no blocks at all
+
+	dir := img[4*sectorSize:]
+	entry := func(name string, flags byte, num uint32, dfStart uint16, dfLen uint32, rfStart uint16, rfLen uint32) []byte {
+		e := make([]byte, entryFixed+1+len(name))
+		e[0] = flags
+		copy(e[0x02:], "TEXTttxt")
+		be.PutUint32(e[0x12:], num)
+		be.PutUint16(e[0x16:], dfStart)
+		be.PutUint32(e[0x18:], dfLen)
+		be.PutUint16(e[0x20:], rfStart)
+		be.PutUint32(e[0x22:], rfLen)
+		be.PutUint32(e[0x2e:], 0x9a000000) // 1985
+		e[entryFixed] = byte(copy(e[entryFixed+1:], name))
+		if len(e)%2 != 0 {
+			e = append(e, 0)
+		}
+		return e
+	}
+	copy(dir, entry("Read Me", 0x81, 1, 5, 2*testBlkSize+2, 7, 8))
+	copy(dir[sectorSize:], entry("Empty/Stuff", 0x80, 2, 0, 0, 0, 0)) // the second sector, the rest of the first unused
+	return img
+}
+
+func TestVolume(t *testing.T) {
+	img := testImage()
+	if !IsVolume(img[mdbOffset:]) {
+		t.Fatal("signature not recognised")
+	}
+	fsys, err := New(bytes.NewReader(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(fsys, "Read Me", "._Read Me", "Empty:Stuff", volumeInfoName); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := fs.ReadFile(fsys, "Read Me")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Repeat("a", testBlkSize) + strings.Repeat("b", testBlkSize) + "cc"
+	if string(got) != want {
+		t.Errorf("data fork not followed along its chain: %q...", got[:min(len(got), 8)])
+	}
+
+	ad, err := fs.ReadFile(fsys, "._Read Me")
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.HasSuffix(ad, []byte("resource")) {
+		t.Error("resource fork missing from sidecar")
+	} else if !bytes.Contains(ad, []byte("TEXTttxt")) {
+		t.Error("Finder info missing from sidecar")
+	}
+
+	s, err := fs.Stat(fsys, "Read Me")
+	if err != nil {
+		t.Fatal(err)
+	} else if s.ModTime().Year() != 1985 {
+		t.Errorf("modtime %v, want 1985", s.ModTime())
+	}
+
+	info, err := fs.ReadFile(fsys, volumeInfoName)
+	if err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(string(info), "Untitled") {
+		t.Errorf("volume name missing:\n%s", info)
+	}
+}
+
+func TestBrokenChain(t *testing.T) {
+	img := testImage()
+	mdb := img[mdbOffset:]
+	i := 2 - firstBlock // block 2 points back to 5, making a loop
+	mdb[mapOffset+i*3/2], mdb[mapOffset+i*3/2+1] = 0, 5<<4
+	fsys, err := New(bytes.NewReader(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.ReadFile(fsys, "Read Me"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNotVolume(t *testing.T) {
+	img := testImage()
+	img[mdbOffset], img[mdbOffset+1] = 'B', 'D'
+	if IsVolume(img[mdbOffset:]) {
+		t.Error("HFS signature taken for MFS")
+	}
+	if _, err := New(bytes.NewReader(img)); err == nil {
+		t.Error("opened without a signature")
+	}
+}
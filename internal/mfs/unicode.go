@@ -0,0 +1,151 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package mfs
+
+func stringFromRoman(mac []byte) string {
+	var buf []byte
+	for _, roman := range mac {
+		if roman < 128 {
+			buf = append(buf, roman)
+		} else {
+			triple := u8list[roman-128]
+			for triple != 0 {
+				buf = append(buf, byte(triple))
+				triple >>= 8
+			}
+		}
+	}
+	return string(buf)
+}
+
+var u8list = [...]uint32{
+	0x0088cc41, // LATIN CAPITAL LETTER A + COMBINING DIAERESIS
+	0x008acc41, // LATIN CAPITAL LETTER A + COMBINING RING ABOVE
+	0x00a7cc43, // LATIN CAPITAL LETTER C + COMBINING CEDILLA
+	0x0081cc45, // LATIN CAPITAL LETTER E + COMBINING ACUTE ACCENT
+	0x0083cc4e, // LATIN CAPITAL LETTER N + COMBINING TILDE
+	0x0088cc4f, // LATIN CAPITAL LETTER O + COMBINING DIAERESIS
+	0x0088cc55, // LATIN CAPITAL LETTER U + COMBINING DIAERESIS
+	0x0081cc61, // LATIN SMALL LETTER A + COMBINING ACUTE ACCENT
+	0x0080cc61, // LATIN SMALL LETTER A + COMBINING GRAVE ACCENT
+	0x0082cc61, // LATIN SMALL LETTER A + COMBINING CIRCUMFLEX ACCENT
+	0x0088cc61, // LATIN SMALL LETTER A + COMBINING DIAERESIS
+	0x0083cc61, // LATIN SMALL LETTER A + COMBINING TILDE
+	0x008acc61, // LATIN SMALL LETTER A + COMBINING RING ABOVE
+	0x00a7cc63, // LATIN SMALL LETTER C + COMBINING CEDILLA
+	0x0081cc65, // LATIN SMALL LETTER E + COMBINING ACUTE ACCENT
+	0x0080cc65, // LATIN SMALL LETTER E + COMBINING GRAVE ACCENT
+	0x0082cc65, // LATIN SMALL LETTER E + COMBINING CIRCUMFLEX ACCENT
+	0x0088cc65, // LATIN SMALL LETTER E + COMBINING DIAERESIS
+	0x0081cc69, // LATIN SMALL LETTER I + COMBINING ACUTE ACCENT
+	0x0080cc69, // LATIN SMALL LETTER I + COMBINING GRAVE ACCENT
+	0x0082cc69, // LATIN SMALL LETTER I + COMBINING CIRCUMFLEX ACCENT
+	0x0088cc69, // LATIN SMALL LETTER I + COMBINING DIAERESIS
+	0x0083cc6e, // LATIN SMALL LETTER N + COMBINING TILDE
+	0x0081cc6f, // LATIN SMALL LETTER O + COMBINING ACUTE ACCENT
+	0x0080cc6f, // LATIN SMALL LETTER O + COMBINING GRAVE ACCENT
+	0x0082cc6f, // LATIN SMALL LETTER O + COMBINING CIRCUMFLEX ACCENT
+	0x0088cc6f, // LATIN SMALL LETTER O + COMBINING DIAERESIS
+	0x0083cc6f, // LATIN SMALL LETTER O + COMBINING TILDE
+	0x0081cc75, // LATIN SMALL LETTER U + COMBINING ACUTE ACCENT
+	0x0080cc75, // LATIN SMALL LETTER U + COMBINING GRAVE ACCENT
+	0x0082cc75, // LATIN SMALL LETTER U + COMBINING CIRCUMFLEX ACCENT
+	0x0088cc75, // LATIN SMALL LETTER U + COMBINING DIAERESIS
+	0x00a080e2, // DAGGER
+	0x0000b0c2, // DEGREE SIGN
+	0x0000a2c2, // CENT SIGN
+	0x0000a3c2, // POUND SIGN
+	0x0000a7c2, // SECTION SIGN
+	0x00a280e2, // BULLET
+	0x0000b6c2, // PILCROW SIGN
+	0x00009fc3, // LATIN SMALL LETTER SHARP S
+	0x0000aec2, // REGISTERED SIGN
+	0x0000a9c2, // COPYRIGHT SIGN
+	0x00a284e2, // TRADE MARK SIGN
+	0x0000b4c2, // ACUTE ACCENT
+	0x0000a8c2, // DIAERESIS
+	0x00b8cc3d, // EQUALS SIGN + COMBINING LONG SOLIDUS OVERLAY
+	0x000086c3, // LATIN CAPITAL LETTER AE
+	0x000098c3, // LATIN CAPITAL LETTER O WITH STROKE
+	0x009e88e2, // INFINITY
+	0x0000b1c2, // PLUS-MINUS SIGN
+	0x00a489e2, // LESS-THAN OR EQUAL TO
+	0x00a589e2, // GREATER-THAN OR EQUAL TO
+	0x0000a5c2, // YEN SIGN
+	0x0000b5c2, // MICRO SIGN
+	0x008288e2, // PARTIAL DIFFERENTIAL
+	0x009188e2, // N-ARY SUMMATION
+	0x008f88e2, // N-ARY PRODUCT
+	0x000080cf, // GREEK SMALL LETTER PI
+	0x00ab88e2, // INTEGRAL
+	0x0000aac2, // FEMININE ORDINAL INDICATOR
+	0x0000bac2, // MASCULINE ORDINAL INDICATOR
+	0x0000a9ce, // GREEK CAPITAL LETTER OMEGA
+	0x0000a6c3, // LATIN SMALL LETTER AE
+	0x0000b8c3, // LATIN SMALL LETTER O WITH STROKE
+	0x0000bfc2, // INVERTED QUESTION MARK
+	0x0000a1c2, // INVERTED EXCLAMATION MARK
+	0x0000acc2, // NOT SIGN
+	0x009a88e2, // SQUARE ROOT
+	0x000092c6, // LATIN SMALL LETTER F WITH HOOK
+	0x008889e2, // ALMOST EQUAL TO
+	0x008688e2, // INCREMENT
+	0x0000abc2, // LEFT-POINTING DOUBLE ANGLE QUOTATION MARK
+	0x0000bbc2, // RIGHT-POINTING DOUBLE ANGLE QUOTATION MARK
+	0x00a680e2, // HORIZONTAL ELLIPSIS
+	0x0000a0c2, // NO-BREAK SPACE
+	0x0080cc41, // LATIN CAPITAL LETTER A + COMBINING GRAVE ACCENT
+	0x0083cc41, // LATIN CAPITAL LETTER A + COMBINING TILDE
+	0x0083cc4f, // LATIN CAPITAL LETTER O + COMBINING TILDE
+	0x000092c5, // LATIN CAPITAL LIGATURE OE
+	0x000093c5, // LATIN SMALL LIGATURE OE
+	0x009380e2, // EN DASH
+	0x009480e2, // EM DASH
+	0x009c80e2, // LEFT DOUBLE QUOTATION MARK
+	0x009d80e2, // RIGHT DOUBLE QUOTATION MARK
+	0x009880e2, // LEFT SINGLE QUOTATION MARK
+	0x009980e2, // RIGHT SINGLE QUOTATION MARK
+	0x0000b7c3, // DIVISION SIGN
+	0x008a97e2, // LOZENGE
+	0x0088cc79, // LATIN SMALL LETTER Y + COMBINING DIAERESIS
+	0x0088cc59, // LATIN CAPITAL LETTER Y + COMBINING DIAERESIS
+	0x008481e2, // FRACTION SLASH
+	0x00ac82e2, // EURO SIGN
+	0x00b980e2, // SINGLE LEFT-POINTING ANGLE QUOTATION MARK
+	0x00ba80e2, // SINGLE RIGHT-POINTING ANGLE QUOTATION MARK
+	0x0081acef, // LATIN SMALL LIGATURE FI
+	0x0082acef, // LATIN SMALL LIGATURE FL
+	0x00a180e2, // DOUBLE DAGGER
+	0x0000b7c2, // MIDDLE DOT
+	0x009a80e2, // SINGLE LOW-9 QUOTATION MARK
+	0x009e80e2, // DOUBLE LOW-9 QUOTATION MARK
+	0x00b080e2, // PER MILLE SIGN
+	0x0082cc41, // LATIN CAPITAL LETTER A + COMBINING CIRCUMFLEX ACCENT
+	0x0082cc45, // LATIN CAPITAL LETTER E + COMBINING CIRCUMFLEX ACCENT
+	0x0081cc41, // LATIN CAPITAL LETTER A + COMBINING ACUTE ACCENT
+	0x0088cc45, // LATIN CAPITAL LETTER E + COMBINING DIAERESIS
+	0x0080cc45, // LATIN CAPITAL LETTER E + COMBINING GRAVE ACCENT
+	0x0081cc49, // LATIN CAPITAL LETTER I + COMBINING ACUTE ACCENT
+	0x0082cc49, // LATIN CAPITAL LETTER I + COMBINING CIRCUMFLEX ACCENT
+	0x0088cc49, // LATIN CAPITAL LETTER I + COMBINING DIAERESIS
+	0x0080cc49, // LATIN CAPITAL LETTER I + COMBINING GRAVE ACCENT
+	0x0081cc4f, // LATIN CAPITAL LETTER O + COMBINING ACUTE ACCENT
+	0x0082cc4f, // LATIN CAPITAL LETTER O + COMBINING CIRCUMFLEX ACCENT
+	0x00bfa3ef, // U+F8FF
+	0x0080cc4f, // LATIN CAPITAL LETTER O + COMBINING GRAVE ACCENT
+	0x0081cc55, // LATIN CAPITAL LETTER U + COMBINING ACUTE ACCENT
+	0x0082cc55, // LATIN CAPITAL LETTER U + COMBINING CIRCUMFLEX ACCENT
+	0x0080cc55, // LATIN CAPITAL LETTER U + COMBINING GRAVE ACCENT
+	0x0000b1c4, // LATIN SMALL LETTER DOTLESS I
+	0x000086cb, // MODIFIER LETTER CIRCUMFLEX ACCENT
+	0x00009ccb, // SMALL TILDE
+	0x0000afc2, // MACRON
+	0x000098cb, // BREVE
+	0x000099cb, // DOT ABOVE
+	0x00009acb, // RING ABOVE
+	0x0000b8c2, // CEDILLA
+	0x00009dcb, // DOUBLE ACUTE ACCENT
+	0x00009bcb, // OGONEK
+	0x000087cb, // CARON
+}
@@ -52,6 +52,9 @@ type multiRA struct {
 func (m *multiRA) Size() int64 { return m.size }
 
 func (m *multiRA) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 || off >= m.size {
+		return 0, io.EOF
+	}
 	wantN := len(p)
 
 	// Skip past the requested offset.
@@ -76,10 +79,13 @@ func (m *multiRA) ReadAt(p []byte, off int64) (n int, err error) {
 			readP = readP[:partSize-needSkip]
 		}
 		pn, err0 := parts[0].ReadAt(readP, needSkip)
-		if err0 != nil {
+		n += pn
+		if pn < len(readP) { // but EOF is allowed with a full read
+			if err0 == nil || err0 == io.EOF {
+				err0 = io.ErrUnexpectedEOF // the part was shorter than its Size
+			}
 			return n, err0
 		}
-		n += pn
 		p = p[pn:]
 		if int64(pn)+needSkip == partSize {
 			parts = parts[1:]
@@ -88,7 +94,7 @@ func (m *multiRA) ReadAt(p []byte, off int64) (n int, err error) {
 	}
 
 	if n != wantN {
-		err = io.ErrUnexpectedEOF
+		err = io.EOF
 	}
 	return
 }
@@ -0,0 +1,50 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package multireaderat
+
+import (
+	"io"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestBounds(t *testing.T) {
+	m := New(strings.NewReader("ab"), strings.NewReader(""), strings.NewReader("cd"))
+	cases := []struct {
+		off  int64
+		n    int
+		want string
+		err  error
+	}{
+		{0, 4, "abcd", nil},
+		{1, 2, "bc", nil},
+		{2, 2, "cd", nil},
+		{1, 4, "bcd", io.EOF},
+		{4, 1, "", io.EOF},
+		{-1, 1, "", io.EOF},
+		{math.MaxInt64, 1, "", io.EOF},
+	}
+	for _, c := range cases {
+		p := make([]byte, c.n)
+		n, err := m.ReadAt(p, c.off)
+		if string(p[:n]) != c.want || err != c.err {
+			t.Errorf("ReadAt(%d bytes, %d) = %q, %v; want %q, %v", c.n, c.off, p[:n], err, c.want, c.err)
+		}
+	}
+}
+
+// short claims a larger Size than it can deliver
+type short struct{ *strings.Reader }
+
+func (s short) Size() int64 { return s.Reader.Size() + 1 }
+
+func TestShortPart(t *testing.T) {
+	m := New(short{strings.NewReader("ab")}, strings.NewReader("cd"))
+	p := make([]byte, 5)
+	n, err := m.ReadAt(p, 0)
+	if n != 2 || err != io.ErrUnexpectedEOF {
+		t.Errorf("got %d, %v; want 2, %v", n, err, io.ErrUnexpectedEOF)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+// Package newton reads Apple Newton packages, as described in "Newton Formats" (Apple, 1996).
+package newton
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
+	"github.com/elliotnunn/BeHierarchic/internal/guard"
+	"github.com/elliotnunn/BeHierarchic/internal/sectionreader"
+	"github.com/elliotnunn/BeHierarchic/internal/walltime"
+)
+
+var ErrFormat = errors.New("not a valid Newton package")
+
+const (
+	headerSize = 52
+	partSize   = 32
+	maxParts   = 4096 // far more than a Newton could hold
+)
+
+// IsPackage checks the 8-byte signature
+func IsPackage(head []byte) bool {
+	return len(head) >= 8 && string(head[:7]) == "package" && (head[7] == '0' || head[7] == '1')
+}
+
+var newtonEpoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// New opens a package
+func New(r io.ReaderAt) (fs.FS, error) {
+	return New2(r, r)
+}
+
+// New2 routes headers and data requests through different readers, to help exotic caching schemes.
+// Each part is named by its index and type ("0.form"),
+// and "package.txt" describes the package and its parts in words.
+func New2(headerReader, dataReader io.ReaderAt) (retfs fs.FS, reterr error) {
+	defer guard.Recover(&reterr, "Newton package", nil)
+	h := make([]byte, headerSize)
+	if n, err := headerReader.ReadAt(h, 0); n != len(h) {
+		return nil, err
+	} else if !IsPackage(h) {
+		return nil, ErrFormat
+	}
+	be := binary.BigEndian
+	dirSize := int64(be.Uint32(h[44:]))
+	numParts := int64(be.Uint32(h[48:]))
+	if numParts > maxParts || dirSize < headerSize+partSize*numParts {
+		return nil, ErrFormat
+	}
+	dir := make([]byte, dirSize)
+	if n, err := headerReader.ReadAt(dir, 0); n != len(dir) {
+		return nil, err
+	}
+	variable := dir[headerSize+partSize*numParts:]
+	mtime := newtonEpoch.Add(time.Duration(be.Uint32(h[32:])) * time.Second).In(walltime.Zone)
+
+	var desc strings.Builder
+	fmt.Fprintf(&desc, "Name: %s\n", unicodeString(variable, h[24:]))
+	fmt.Fprintf(&desc, "Copyright: %s\n", unicodeString(variable, h[20:]))
+	fmt.Fprintf(&desc, "Version: %d\n", be.Uint32(h[16:]))
+	fmt.Fprintf(&desc, "Created: %s\n", mtime.Format(time.RFC3339))
+	fmt.Fprintf(&desc, "Flags: %#08x\n", be.Uint32(h[12:]))
+	fmt.Fprintf(&desc, "Size: %d\n", be.Uint32(h[28:]))
+
+	fsys := fskeleton.New()
+	defer fsys.NoMore()
+	for i := range numParts {
+		p := dir[headerSize+partSize*i:][:partSize]
+		offset := dirSize + int64(be.Uint32(p[0:]))
+		size := int64(be.Uint32(p[4:]))
+		typ := string(bytes.Map(func(r rune) rune {
+			if r < 0x20 || r >= 0x7f || r == '/' {
+				return '_'
+			}
+			return r
+		}, p[12:16]))
+		name := fmt.Sprintf("%d.%s", i, typ)
+		fmt.Fprintf(&desc, "\nPart %d: %s\n", i, name)
+		fmt.Fprintf(&desc, "  Size: %d\n", size)
+		fmt.Fprintf(&desc, "  Flags: %#08x\n", be.Uint32(p[20:]))
+		if info := infoRef(variable, p[24:]); len(info) > 0 {
+			fmt.Fprintf(&desc, "  Info: %q\n", info)
+		}
+		fsys.CreateReaderAt(name, offset, sectionreader.Section(dataReader, offset, size), size, 0, mtime)
+	}
+
+	text := desc.String()
+	fsys.CreateReaderAt("package.txt", 0, strings.NewReader(text), int64(len(text)), 0, mtime)
+	return fsys, nil
+}
+
+// infoRef dereferences an (offset, length) pair into the variable-length area of the directory
+func infoRef(variable []byte, ref []byte) []byte {
+	off := int(binary.BigEndian.Uint16(ref[0:]))
+	n := int(binary.BigEndian.Uint16(ref[2:]))
+	if off+n > len(variable) {
+		return nil
+	}
+	return variable[off:][:n]
+}
+
+// unicodeString decodes the UTF-16 strings that a package uses for its name and copyright
+func unicodeString(variable []byte, ref []byte) string {
+	b := infoRef(variable, ref)
+	u := make([]uint16, 0, len(b)/2)
+	for ; len(b) >= 2; b = b[2:] {
+		c := binary.BigEndian.Uint16(b)
+		if c == 0 {
+			break
+		}
+		u = append(u, c)
+	}
+	return string(utf16.Decode(u))
+}
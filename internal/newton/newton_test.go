@@ -0,0 +1,86 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package newton
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"unicode/utf16"
+)
+
+func utf16z(s string) []byte {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(s + "\x00")) {
+		b = binary.BigEndian.AppendUint16(b, c)
+	}
+	return b
+}
+
+func TestPackage(t *testing.T) {
+	be := binary.BigEndian
+	name, copyright := utf16z("Hello:SIG"), utf16z("© 1996 Apple")
+	variable := append(append([]byte{}, name...), copyright...)
+	parts := [][]byte{[]byte("form part data"), []byte("book")}
+	dirSize := headerSize + partSize*len(parts) + len(variable)
+
+	h := make([]byte, headerSize)
+	copy(h, "package0xxxx")
+	be.PutUint32(h[16:], 3)
+	be.PutUint16(h[20:], uint16(len(name)))
+	be.PutUint16(h[22:], uint16(len(copyright)))
+	be.PutUint16(h[24:], 0)
+	be.PutUint16(h[26:], uint16(len(name)))
+	be.PutUint32(h[32:], 0xae000000)
+	be.PutUint32(h[44:], uint32(dirSize))
+	be.PutUint32(h[48:], uint32(len(parts)))
+
+	pkg := h
+	off := 0
+	for i, typ := range []string{"form", "book"} {
+		p := make([]byte, partSize)
+		be.PutUint32(p[0:], uint32(off))
+		be.PutUint32(p[4:], uint32(len(parts[i])))
+		copy(p[12:], typ)
+		pkg = append(pkg, p...)
+		off += len(parts[i])
+	}
+	pkg = append(pkg, variable...)
+	for _, p := range parts {
+		pkg = append(pkg, p...)
+	}
+	be.PutUint32(pkg[28:], uint32(len(pkg)))
+
+	fsys, err := New(bytes.NewReader(pkg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fstest.TestFS(fsys, "0.form", "1.book", "package.txt")
+	if err != nil {
+		t.Error(err)
+	}
+	got, err := fs.ReadFile(fsys, "0.form")
+	if err != nil || string(got) != "form part data" {
+		t.Errorf("got %q, %v", got, err)
+	}
+	desc, _ := fs.ReadFile(fsys, "package.txt")
+	for _, want := range []string{"Name: Hello:SIG\n", "Copyright: © 1996 Apple\n", "Version: 3\n"} {
+		if !strings.Contains(string(desc), want) {
+			t.Errorf("package.txt lacks %q:\n%s", want, desc)
+		}
+	}
+}
+
+func TestNotPackage(t *testing.T) {
+	if IsPackage([]byte("package9")) || IsPackage([]byte("packag")) {
+		t.Error("IsPackage accepted a bad signature")
+	}
+	_, err := New(bytes.NewReader([]byte("package0 but far too short")))
+	if err == nil {
+		t.Error("New accepted a truncated package")
+	}
+}
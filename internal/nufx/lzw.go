@@ -0,0 +1,262 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package nufx
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+)
+
+var errLZW = errors.New("NuFX: corrupt LZW data")
+
+const (
+	chunkSize = 4096
+	clearCode = 0x100 // LZW/2 only
+	firstCode = 0x101
+	maxCodes  = 1 << 12
+)
+
+// lzwReader undoes ShrinkIt's compression, which works on 4096-byte chunks:
+// each is run-length encoded with an escape byte, then compressed with LZW unless that would make it bigger.
+// LZW/1 starts a new table for every chunk. LZW/2 carries the table over until it is cleared,
+// and gives the compressed length of each chunk.
+type lzwReader struct {
+	r     *bufio.Reader
+	lzw2  bool
+	esc   byte
+	table lzwTable
+	left  int64
+	chunk [chunkSize]byte
+	rle   [chunkSize]byte
+	avail []byte
+	err   error
+}
+
+func newLZW(r io.Reader, lzw2 bool, size int64) (io.ReadCloser, error) {
+	br := bufio.NewReaderSize(r, chunkSize)
+	head := make([]byte, 4) // CRC, volume number, escape byte
+	if lzw2 {
+		head = head[2:] // no CRC
+	}
+	if _, err := io.ReadFull(br, head); err != nil {
+		return nil, unexpected(err)
+	}
+	z := &lzwReader{r: br, lzw2: lzw2, esc: head[len(head)-1], left: size}
+	z.table.reset()
+	return z, nil
+}
+
+func (z *lzwReader) Read(p []byte) (int, error) {
+	if z.left == 0 {
+		return 0, io.EOF
+	}
+	if len(z.avail) == 0 {
+		if z.err == nil {
+			z.err = z.next()
+		}
+		if z.err != nil {
+			return 0, z.err
+		}
+	}
+	n := copy(p[:min(int64(len(p)), z.left)], z.avail)
+	z.avail = z.avail[n:]
+	z.left -= int64(n)
+	return n, nil
+}
+
+func (z *lzwReader) Close() error { return nil }
+
+// next decodes a chunk
+func (z *lzwReader) next() error {
+	le := binary.LittleEndian
+	var rleLen int
+	var compressed bool
+	var src io.ByteReader = z.r
+	if z.lzw2 {
+		var h [2]byte
+		if _, err := io.ReadFull(z.r, h[:]); err != nil {
+			return unexpected(err)
+		}
+		rleLen, compressed = int(le.Uint16(h[:])&0x1fff), h[1]&0x80 != 0
+		if compressed {
+			if _, err := io.ReadFull(z.r, h[:]); err != nil {
+				return unexpected(err)
+			}
+			packed := int(le.Uint16(h[:])) - 4 // the length counts the four header bytes
+			if packed < 0 {
+				return errLZW
+			}
+			buf := make([]byte, packed)
+			if _, err := io.ReadFull(z.r, buf); err != nil {
+				return unexpected(err)
+			}
+			src = bytes.NewReader(buf)
+		} else {
+			z.table.reset()
+		}
+	} else {
+		var h [3]byte
+		if _, err := io.ReadFull(z.r, h[:]); err != nil {
+			return unexpected(err)
+		}
+		rleLen, compressed = int(le.Uint16(h[:])), h[2] != 0
+		z.table.reset()
+	}
+	if rleLen > chunkSize {
+		return errLZW
+	}
+
+	dst := z.rle[:rleLen]
+	if compressed {
+		if err := z.table.expand(&bitReader{r: src}, dst); err != nil {
+			return err
+		}
+	} else if _, err := io.ReadFull(z.r, dst); err != nil {
+		return unexpected(err)
+	}
+
+	if rleLen == chunkSize { // not worth run-length encoding
+		copy(z.chunk[:], dst)
+	} else if err := unrle(z.chunk[:], dst, z.esc); err != nil {
+		return err
+	}
+	z.avail = z.chunk[:]
+	return nil
+}
+
+// unrle expands "esc char count" to count+1 copies of char, leaving zeros after a short chunk
+func unrle(dst, src []byte, esc byte) error {
+	clear(dst)
+	n := 0
+	for i := 0; i < len(src); {
+		c := src[i]
+		rep := 1
+		if c == esc {
+			if i+2 >= len(src) {
+				return errLZW
+			}
+			c, rep = src[i+1], int(src[i+2])+1
+			i += 3
+		} else {
+			i++
+		}
+		if n+rep > len(dst) {
+			return errLZW
+		}
+		for range rep {
+			dst[n] = c
+			n++
+		}
+	}
+	return nil
+}
+
+type lzwTable struct {
+	prefix [maxCodes]uint16
+	suffix [maxCodes]byte
+	stack  []byte
+	entry  int // the next free code
+	old    int
+	finalc byte
+	fresh  bool // the next code is a literal that starts the table
+}
+
+func (t *lzwTable) reset() {
+	t.entry = firstCode
+	t.fresh = true
+}
+
+// width gives the bits in the next code, which grow one code sooner than they must
+func (t *lzwTable) width() int {
+	return min(12, bits.Len(uint(t.entry+1)))
+}
+
+// expand fills dst exactly
+func (t *lzwTable) expand(br *bitReader, dst []byte) error {
+	n := 0
+	for n < len(dst) {
+		code, err := br.read(t.width())
+		if err != nil {
+			return err
+		}
+		if code == clearCode && !t.fresh {
+			t.reset()
+			continue
+		}
+		if t.fresh {
+			if code > 0xff {
+				return errLZW
+			}
+			dst[n] = byte(code)
+			n++
+			t.old, t.finalc, t.fresh = code, byte(code), false
+			continue
+		}
+
+		t.stack = t.stack[:0]
+		ptr := code
+		if code >= t.entry { // the string about to be defined
+			if code != t.entry {
+				return errLZW
+			}
+			t.stack = append(t.stack, t.finalc)
+			ptr = t.old
+		}
+		for ptr > 0xff {
+			t.stack = append(t.stack, t.suffix[ptr])
+			ptr = int(t.prefix[ptr])
+		}
+		t.finalc = byte(ptr)
+		if n+1+len(t.stack) > len(dst) {
+			return errLZW
+		}
+		dst[n] = t.finalc
+		n++
+		for i := len(t.stack) - 1; i >= 0; i-- {
+			dst[n] = t.stack[i]
+			n++
+		}
+
+		if t.entry < maxCodes {
+			t.suffix[t.entry] = t.finalc
+			t.prefix[t.entry] = uint16(t.old)
+			t.entry++
+		}
+		t.old = code
+	}
+	return nil
+}
+
+// bitReader reads codes least significant bit first
+type bitReader struct {
+	r     io.ByteReader
+	bits  uint32
+	nbits int
+}
+
+func (br *bitReader) read(width int) (int, error) {
+	for br.nbits < width {
+		c, err := br.r.ReadByte()
+		if err != nil {
+			return 0, unexpected(err)
+		}
+		br.bits |= uint32(c) << br.nbits
+		br.nbits += 8
+	}
+	code := int(br.bits & (1<<width - 1))
+	br.bits >>= width
+	br.nbits -= width
+	return code, nil
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
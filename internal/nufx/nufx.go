@@ -0,0 +1,268 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+// Package nufx reads the NuFX archives of ShrinkIt and GS-ShrinkIt for the Apple II,
+// usually named .shk, or .sdk when they hold a disk image.
+// Threads that are stored or compressed with LZW/1 or LZW/2 can be read.
+// The CRCs in the archive are not checked.
+package nufx
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/elliotnunn/BeHierarchic/internal/appledouble"
+	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
+	"github.com/elliotnunn/BeHierarchic/internal/guard"
+	"github.com/elliotnunn/BeHierarchic/internal/sectionreader"
+	"github.com/elliotnunn/BeHierarchic/internal/walltime"
+	"golang.org/x/text/encoding/charmap"
+)
+
+var (
+	ErrFormat = errors.New("not a valid NuFX archive")
+	ErrMethod = errors.New("NuFX: unimplemented thread format")
+)
+
+const (
+	masterID       = "N\xf5F\xe9l\xe5" // "NuFile" with alternate bytes in high ASCII
+	recordID       = "N\xf5F\xd8"      // "NuFX"
+	masterSize     = 48
+	minAttribCount = 56 // up to the filename_length field, which version 0 records have sooner for lack of option_size
+	threadSize     = 16
+	maxThreads     = 256
+)
+
+// Thread classes and kinds
+const (
+	classData     = 2
+	classFilename = 3
+
+	kindDataFork = 0
+	kindDisk     = 1
+	kindRsrcFork = 2
+)
+
+// Thread formats
+const (
+	formatStored = 0
+	formatLZW1   = 2
+	formatLZW2   = 3
+)
+
+// IsArchive checks for the master header
+func IsArchive(head []byte) bool {
+	return len(head) >= len(masterID) && string(head[:len(masterID)]) == masterID
+}
+
+// New opens an archive
+func New(r io.ReaderAt) (fs.FS, error) {
+	return New2(r, r)
+}
+
+// New2 routes headers and data requests through different readers, to help exotic caching schemes
+func New2(headerReader, dataReader io.ReaderAt) (retfs fs.FS, reterr error) {
+	defer guard.Recover(&reterr, "NuFX", nil)
+	h := make([]byte, masterSize)
+	if n, err := headerReader.ReadAt(h, 0); n < len(h) {
+		if err == io.EOF {
+			err = ErrFormat
+		}
+		return nil, err
+	} else if !IsArchive(h) {
+		return nil, ErrFormat
+	}
+	count := binary.LittleEndian.Uint32(h[8:])
+
+	fsys := fskeleton.New()
+	go populate(fsys, headerReader, dataReader, count)
+	return fsys, nil
+}
+
+type thread struct {
+	class, format, kind uint16
+	size, packed        int64
+	off                 int64 // of the data
+}
+
+// populate adds the records one by one.
+// A damaged archive stops early, keeping what is already known.
+func populate(fsys *fskeleton.FS, headerReader, dataReader io.ReaderAt, count uint32) {
+	defer fsys.NoMore()
+	defer guard.Log("NuFX", nil)
+	le := binary.LittleEndian
+	off := int64(masterSize)
+	for range count {
+		h := make([]byte, minAttribCount)
+		if n, _ := headerReader.ReadAt(h, off); n < len(h) || string(h[:len(recordID)]) != recordID {
+			return
+		}
+		attribCount := int64(le.Uint16(h[6:]))
+		nthreads := le.Uint32(h[10:])
+		if attribCount < minAttribCount || nthreads > maxThreads {
+			return
+		}
+		h = make([]byte, attribCount+2) // and the filename_length field
+		if n, _ := headerReader.ReadAt(h, off); n < len(h) {
+			return
+		}
+		sep := h[16]
+		access := le.Uint32(h[18:])
+		fileType := le.Uint32(h[22:])
+		auxType := le.Uint32(h[26:])
+		storageType := le.Uint16(h[30:])
+		ctime, mtime := datetime(h[32:]), datetime(h[40:])
+		nameLen := int64(le.Uint16(h[attribCount:]))
+		name := make([]byte, nameLen)
+		if n, _ := headerReader.ReadAt(name, off+int64(len(h))); n < len(name) {
+			return
+		}
+
+		th := make([]byte, threadSize*int64(nthreads))
+		if n, _ := headerReader.ReadAt(th, off+int64(len(h))+nameLen); n < len(th) {
+			return
+		}
+		id := off
+		off += int64(len(h)) + nameLen + int64(len(th))
+		threads := make([]thread, nthreads)
+		for i := range threads {
+			t := th[threadSize*i:]
+			threads[i] = thread{
+				class:  le.Uint16(t),
+				format: le.Uint16(t[2:]),
+				kind:   le.Uint16(t[4:]),
+				size:   int64(le.Uint32(t[8:])),
+				packed: int64(le.Uint32(t[12:])),
+				off:    off,
+			}
+			off += threads[i].packed
+		}
+
+		for _, t := range threads {
+			if t.class == classFilename && t.kind == 0 {
+				name = make([]byte, min(t.size, t.packed))
+				if n, _ := headerReader.ReadAt(name, t.off); n < len(name) {
+					return
+				}
+			}
+		}
+		pathname := convertPath(name, sep)
+		if pathname == "" {
+			continue
+		}
+
+		var data, rsrc *thread
+		for i, t := range threads {
+			if t.class != classData {
+				continue
+			}
+			switch t.kind {
+			case kindDataFork, kindDisk:
+				data = &threads[i]
+				if t.kind == kindDisk && storageType > 0 && storageType <= 4096 && auxType > 0 {
+					data.size = int64(storageType) * int64(auxType) // the number and size of blocks
+				}
+			case kindRsrcFork:
+				rsrc = &threads[i]
+			}
+		}
+
+		if data == nil && rsrc == nil {
+			if fileType == 0x0f || storageType == 0x0d { // a directory
+				fsys.Mkdir(pathname, id, 0, mtime)
+			}
+			continue
+		}
+		if data != nil {
+			create(fsys, pathname, id, data, dataReader, mtime)
+		} else {
+			fsys.CreateReaderAt(pathname, id, strings.NewReader(""), 0, 0, mtime)
+		}
+		if data != nil && data.kind == kindDisk {
+			continue
+		}
+
+		var meta appledouble.AppleDouble
+		meta.CreateTime, meta.ModTime = ctime, mtime
+		meta.Locked = access&0x02 == 0 // write-enable
+		meta.Type, meta.Creator = appledouble.ProDOSType(byte(fileType), uint16(auxType))
+		sidecar := appledouble.Sidecar(pathname)
+		if rsrc == nil {
+			ad, size := meta.WithResourceFork(nil, 0)
+			fsys.CreateReaderAt(sidecar, id+1, ad, size, 0, mtime)
+		} else if opener, err := open(rsrc, dataReader); err != nil {
+			fsys.CreateError(sidecar, id+1, err, rsrc.size, 0, mtime)
+		} else {
+			ad, size := meta.WithSequentialResourceFork(opener, rsrc.size)
+			fsys.CreateReadCloser(sidecar, id+1, ad, size, 0, mtime)
+		}
+	}
+}
+
+func create(fsys *fskeleton.FS, name string, id int64, t *thread, dataReader io.ReaderAt, mtime time.Time) {
+	if t.format == formatStored {
+		size := min(t.size, t.packed)
+		fsys.CreateReaderAt(name, id, sectionreader.Section(dataReader, t.off, size), size, 0, mtime)
+	} else if opener, err := open(t, dataReader); err != nil {
+		fsys.CreateError(name, id, err, t.size, 0, mtime)
+	} else {
+		fsys.CreateReadCloser(name, id, opener, t.size, 0, mtime)
+	}
+}
+
+func open(t *thread, dataReader io.ReaderAt) (func() (io.ReadCloser, error), error) {
+	switch t.format {
+	case formatStored, formatLZW1, formatLZW2:
+	default:
+		return nil, fmt.Errorf("%w %d", ErrMethod, t.format)
+	}
+	return func() (io.ReadCloser, error) {
+		r := io.NewSectionReader(dataReader, t.off, t.packed)
+		switch t.format {
+		case formatLZW1:
+			return newLZW(r, false, t.size)
+		case formatLZW2:
+			return newLZW(r, true, t.size)
+		}
+		return io.NopCloser(io.LimitReader(r, t.size)), nil
+	}, nil
+}
+
+// convertPath splits a name at the separator that the record declares,
+// and drops any record whose name would escape the archive
+func convertPath(name []byte, sep byte) string {
+	s, _ := charmap.Macintosh.NewDecoder().Bytes(name)
+	if sep == 0 {
+		sep = ':'
+	}
+	var parts []string
+	for p := range strings.SplitSeq(string(s), string(rune(sep))) {
+		if p != "" {
+			parts = append(parts, strings.ReplaceAll(p, "/", ":"))
+		}
+	}
+	pathname := strings.Join(parts, "/")
+	if !fs.ValidPath(pathname) || pathname == "." {
+		return ""
+	}
+	return pathname
+}
+
+// datetime reads the eight-byte ProDOS 16 format: second, minute, hour, year since 1900,
+// day and month counting from zero, then two bytes that are ignored
+func datetime(b []byte) time.Time {
+	sec, minute, hour, year, day, month := int(b[0]), int(b[1]), int(b[2]), int(b[3]), int(b[4]), int(b[5])
+	if sec > 59 || minute > 59 || hour > 23 || day > 30 || month > 11 || year == 0 && month == 0 && day == 0 {
+		return time.Time{}
+	}
+	year += 1900
+	if year < 1940 {
+		year += 100 // as ShrinkIt does after 1999
+	}
+	return time.Date(year, time.Month(month+1), day+1, hour, minute, sec, 0, walltime.Zone)
+}
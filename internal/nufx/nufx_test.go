@@ -0,0 +1,313 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package nufx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"math/bits"
+	"strings"
+	"testing"
+	"time"
+)
+
+const esc = 0xdb
+
+// rle encodes a chunk as ShrinkIt does, or leaves it alone if that does not help
+func rle(chunk []byte) []byte {
+	var out []byte
+	for i := 0; i < len(chunk); {
+		c, n := chunk[i], 1
+		for i+n < len(chunk) && chunk[i+n] == c && n < 256 {
+			n++
+		}
+		if n >= 4 || c == esc {
+			out = append(out, esc, c, byte(n-1))
+		} else {
+			out = append(out, chunk[i:i+n]...)
+		}
+		i += n
+	}
+	if len(out) >= chunkSize {
+		return chunk
+	}
+	return out
+}
+
+// lzwEncoder mirrors lzwTable, adding a string whenever a code follows another
+type lzwEncoder struct {
+	dict  map[string]int
+	entry int
+	prev  string
+	fresh bool
+}
+
+func newEncoder() *lzwEncoder {
+	e := new(lzwEncoder)
+	e.reset()
+	return e
+}
+
+func (e *lzwEncoder) reset() {
+	e.dict, e.entry, e.fresh = make(map[string]int), firstCode, true
+}
+
+func (e *lzwEncoder) encode(data []byte, clearFirst bool) []byte {
+	var out []byte
+	var acc uint32
+	nacc := 0
+	emit := func(code int) {
+		acc |= uint32(code) << nacc
+		nacc += min(12, bits.Len(uint(e.entry+1)))
+		for nacc >= 8 {
+			out = append(out, byte(acc))
+			acc >>= 8
+			nacc -= 8
+		}
+	}
+	if clearFirst {
+		emit(clearCode)
+		e.reset()
+	}
+	for i := 0; i < len(data); {
+		n := 1
+		for i+n < len(data) {
+			if _, ok := e.dict[string(data[i:i+n+1])]; !ok {
+				break
+			}
+			n++
+		}
+		s := string(data[i : i+n])
+		code, ok := e.dict[s]
+		if !ok {
+			code = int(s[0])
+		}
+		emit(code)
+		if !e.fresh && e.entry < maxCodes {
+			e.dict[e.prev+s[:1]] = e.entry
+			e.entry++
+		}
+		e.prev, e.fresh = s, false
+		i += n
+	}
+	if nacc > 0 {
+		out = append(out, byte(acc))
+	}
+	return out
+}
+
+func chunks(data []byte) [][]byte {
+	var ret [][]byte
+	for len(data) > 0 {
+		c := make([]byte, chunkSize)
+		copy(c, data)
+		ret = append(ret, c)
+		data = data[min(len(data), chunkSize):]
+	}
+	return ret
+}
+
+// lzw1 compresses every chunk but the second
+func lzw1(data []byte) []byte {
+	out := []byte{0, 0, 0, esc} // CRC unchecked
+	for i, c := range chunks(data) {
+		r := rle(c)
+		if i == 1 {
+			out = binary.LittleEndian.AppendUint16(out, uint16(len(r)))
+			out = append(out, 0)
+			out = append(out, r...)
+			continue
+		}
+		out = binary.LittleEndian.AppendUint16(out, uint16(len(r)))
+		out = append(out, 1)
+		out = append(out, newEncoder().encode(r, false)...)
+	}
+	return out
+}
+
+// lzw2 compresses every chunk but the second, and clears the table before the fourth
+func lzw2(data []byte) []byte {
+	out := []byte{0, esc}
+	e := newEncoder()
+	for i, c := range chunks(data) {
+		r := rle(c)
+		if i == 1 {
+			out = binary.LittleEndian.AppendUint16(out, uint16(len(r)))
+			out = append(out, r...)
+			e.reset()
+			continue
+		}
+		packed := e.encode(r, i == 3)
+		out = binary.LittleEndian.AppendUint16(out, uint16(len(r))|0x8000)
+		out = binary.LittleEndian.AppendUint16(out, uint16(len(packed)+4))
+		out = append(out, packed...)
+	}
+	return out
+}
+
+type testThread struct {
+	class, format, kind uint16
+	size                int
+	data                []byte
+}
+
+type testRecord struct {
+	name            string
+	fileType, aux   uint32
+	storage, access uint16
+	threads         []testThread
+}
+
+var when = []byte{5, 4, 3, 92, 1, 6, 0, 0} // 1992-07-02 03:04:05
+
+func archive(recs ...testRecord) []byte {
+	le := binary.LittleEndian
+	buf := []byte(masterID)
+	buf = le.AppendUint16(buf, 0) // CRC unchecked
+	buf = le.AppendUint32(buf, uint32(len(recs)))
+	buf = append(buf, make([]byte, masterSize-len(buf))...)
+	for _, r := range recs {
+		threads := append([]testThread{{class: classFilename, size: len(r.name), data: []byte(r.name + "\x00\x00\x00\x00")}}, r.threads...)
+		h := []byte(recordID)
+		h = le.AppendUint16(h, 0)  // CRC
+		h = le.AppendUint16(h, 58) // attrib_count
+		h = le.AppendUint16(h, 3)  // version
+		h = le.AppendUint32(h, uint32(len(threads)))
+		h = le.AppendUint16(h, 1) // ProDOS
+		h = le.AppendUint16(h, '/')
+		h = le.AppendUint32(h, uint32(r.access))
+		h = le.AppendUint32(h, r.fileType)
+		h = le.AppendUint32(h, r.aux)
+		h = le.AppendUint16(h, r.storage)
+		h = append(h, when...)
+		h = append(h, when...)
+		h = append(h, when...)
+		h = le.AppendUint16(h, 0) // option_size
+		h = le.AppendUint16(h, 0) // filename_length
+		for _, t := range threads {
+			h = le.AppendUint16(h, t.class)
+			h = le.AppendUint16(h, t.format)
+			h = le.AppendUint16(h, t.kind)
+			h = le.AppendUint16(h, 0)
+			h = le.AppendUint32(h, uint32(t.size))
+			h = le.AppendUint32(h, uint32(len(t.data)))
+		}
+		buf = append(buf, h...)
+		for _, t := range threads {
+			buf = append(buf, t.data...)
+		}
+	}
+	return buf
+}
+
+func testText() []byte {
+	var b bytes.Buffer
+	for i := range 900 {
+		b.WriteString(strings.Repeat("SHRINKIT ", i%7))
+		b.WriteString("10 PRINT \"HELLO, APPLE II\"\r20 GOTO 10\r")
+		b.WriteString(strings.Repeat("\x00", i%11))
+	}
+	b.WriteByte(esc)
+	return b.Bytes()
+}
+
+func TestArchive(t *testing.T) {
+	text := testText()
+	if len(text) < 4*chunkSize {
+		t.Fatal("test text too short to exercise the chunks", len(text))
+	}
+	rsrc := []byte("resource fork")
+	disk := bytes.Repeat([]byte{0xe5}, 280*512)
+	fsys, err := New(bytes.NewReader(archive(
+		testRecord{name: "GAMES/HELLO", fileType: 0xfc, aux: 0x0801, access: 0xe3, storage: 2,
+			threads: []testThread{{class: classData, format: formatLZW1, kind: kindDataFork, size: len(text), data: lzw1(text)}}},
+		testRecord{name: "GAMES/HELLO2", fileType: 0x04, access: 0x21, storage: 5,
+			threads: []testThread{
+				{class: classData, format: formatLZW2, kind: kindDataFork, size: len(text), data: lzw2(text)},
+				{class: classData, format: formatStored, kind: kindRsrcFork, size: len(rsrc), data: rsrc},
+			}},
+		testRecord{name: "EMPTY.DIR", fileType: 0x0f, storage: 0x0d},
+		testRecord{name: "DISK", aux: 280, storage: 512,
+			threads: []testThread{{class: classData, format: formatLZW2, kind: kindDisk, data: lzw2(disk)}}},
+		testRecord{name: "SQUEEZED",
+			threads: []testThread{{class: classData, format: 1, kind: kindDataFork, size: 1, data: []byte{0}}}},
+	)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"GAMES/HELLO", "GAMES/HELLO2"} {
+		got, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			t.Fatal(name, err)
+		} else if !bytes.Equal(got, text) {
+			t.Errorf("%s: wrong contents", name)
+		}
+		s, err := fs.Stat(fsys, name)
+		if err != nil {
+			t.Fatal(err)
+		} else if want := time.Date(1992, 7, 2, 3, 4, 5, 0, time.UTC); !s.ModTime().Equal(want) {
+			t.Errorf("%s: modtime %v, want %v", name, s.ModTime(), want)
+		}
+	}
+
+	ad, err := fs.ReadFile(fsys, "GAMES/._HELLO")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(ad, []byte("p\xfc\x08\x01pdos")) {
+		t.Error("ProDOS type missing from sidecar")
+	}
+	ad, err = fs.ReadFile(fsys, "GAMES/._HELLO2")
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.HasSuffix(ad, rsrc) {
+		t.Error("resource fork missing from sidecar")
+	} else if !bytes.Contains(ad, []byte("TEXTpdos")) {
+		t.Error("TXT file not mapped to TEXT")
+	}
+
+	if s, err := fs.Stat(fsys, "EMPTY.DIR"); err != nil || !s.IsDir() {
+		t.Error("directory record not made a directory", err)
+	}
+	if got, err := fs.ReadFile(fsys, "DISK"); err != nil {
+		t.Error(err)
+	} else if !bytes.Equal(got, disk) {
+		t.Error("disk image: wrong contents", len(got))
+	}
+	if _, err := fs.Stat(fsys, "._DISK"); err == nil {
+		t.Error("disk image should have no sidecar")
+	}
+	if _, err := fs.ReadFile(fsys, "SQUEEZED"); !errors.Is(err, ErrMethod) {
+		t.Errorf("squeezed thread: got %v, want %v", err, ErrMethod)
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	text := testText()
+	packed := lzw1(text)
+	packed[len(packed)/2] ^= 0xff
+	fsys, err := New(bytes.NewReader(archive(testRecord{name: "BAD",
+		threads: []testThread{{class: classData, format: formatLZW1, kind: kindDataFork, size: len(text), data: packed}}})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := fs.ReadFile(fsys, "BAD")
+	if err == nil && bytes.Equal(got, text) {
+		t.Error("corruption went unnoticed")
+	}
+}
+
+func TestNotArchive(t *testing.T) {
+	for _, b := range [][]byte{nil, []byte("NuFile"), make([]byte, 100)} {
+		if IsArchive(b) {
+			t.Errorf("%q: should not be an archive", b)
+		}
+		if _, err := New(bytes.NewReader(b)); !errors.Is(err, ErrFormat) {
+			t.Errorf("%q: got %v, want %v", b, err, ErrFormat)
+		}
+	}
+}
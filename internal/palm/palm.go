@@ -0,0 +1,170 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+// Package palm reads Palm OS databases: record databases (.pdb) and resource databases (.prc).
+package palm
+
+import (
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
+	"github.com/elliotnunn/BeHierarchic/internal/guard"
+	"github.com/elliotnunn/BeHierarchic/internal/sectionreader"
+	"github.com/elliotnunn/BeHierarchic/internal/walltime"
+	"golang.org/x/text/encoding/charmap"
+)
+
+var ErrFormat = errors.New("not a valid Palm database")
+
+const (
+	headerSize     = 78
+	attrResourceDB = 0x0001
+)
+
+// Probe checks the header of a file that is already suspected (by its extension) to be a Palm database
+func Probe(r io.ReaderAt, size int64) bool {
+	var h [headerSize]byte
+	if n, _ := r.ReadAt(h[:], 0); n != len(h) {
+		return false
+	}
+	_, err := parseHeader(h[:], size)
+	return err == nil
+}
+
+type header struct {
+	mtime      time.Time
+	resource   bool
+	appInfo    int64
+	sortInfo   int64
+	numRecords int
+}
+
+func parseHeader(h []byte, size int64) (header, error) {
+	if !slices.Contains(h[:32], 0) { // name must be NUL-terminated
+		return header{}, ErrFormat
+	}
+	for _, c := range h[60:68] { // type and creator
+		if c < 0x20 || c == 0x7f {
+			return header{}, ErrFormat
+		}
+	}
+	hdr := header{
+		mtime:      palmTime(binary.BigEndian.Uint32(h[40:])),
+		resource:   binary.BigEndian.Uint16(h[32:])&attrResourceDB != 0,
+		appInfo:    int64(binary.BigEndian.Uint32(h[52:])),
+		sortInfo:   int64(binary.BigEndian.Uint32(h[56:])),
+		numRecords: int(binary.BigEndian.Uint16(h[76:])),
+	}
+	if headerSize+int64(hdr.numRecords)*hdr.entrySize() > size ||
+		hdr.appInfo > size || hdr.sortInfo > size {
+		return header{}, ErrFormat
+	}
+	return hdr, nil
+}
+
+func (h header) entrySize() int64 {
+	if h.resource {
+		return 10
+	}
+	return 8
+}
+
+// palmTime follows pilot-link: seconds since 1904 if the high bit is set, otherwise since 1970
+func palmTime(t uint32) time.Time {
+	if t == 0 {
+		return time.Time{}
+	} else if t&0x80000000 != 0 {
+		return time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(t) * time.Second).In(walltime.Zone) // local, like the Mac
+	}
+	return time.Unix(int64(t), 0).UTC()
+}
+
+// New opens a database
+func New(r io.ReaderAt, size int64) (fs.FS, error) {
+	return New2(r, r, size)
+}
+
+// New2 routes headers and data requests through different readers, to help exotic caching schemes.
+// Records are named by their index ("0", "1"...) and resources by type and ID ("code/1"),
+// as in a Mac resource fork. The application and sort info blocks are "appinfo" and "sortinfo".
+func New2(headerReader, dataReader io.ReaderAt, size int64) (retfs fs.FS, reterr error) {
+	defer guard.Recover(&reterr, "Palm database", nil)
+	var h [headerSize]byte
+	if n, err := headerReader.ReadAt(h[:], 0); n != len(h) {
+		return nil, err
+	}
+	hdr, err := parseHeader(h[:], size)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]byte, int64(hdr.numRecords)*hdr.entrySize())
+	if n, err := headerReader.ReadAt(list, headerSize); n != len(list) {
+		return nil, err
+	}
+
+	type entry struct {
+		name   string
+		offset int64
+	}
+	var entries []entry
+	for i := range hdr.numRecords {
+		e := list[int64(i)*hdr.entrySize():][:hdr.entrySize()]
+		if hdr.resource {
+			name := typeName(e[:4]) + "/" + strconv.Itoa(int(binary.BigEndian.Uint16(e[4:])))
+			entries = append(entries, entry{name, int64(binary.BigEndian.Uint32(e[6:]))})
+		} else {
+			entries = append(entries, entry{strconv.Itoa(i), int64(binary.BigEndian.Uint32(e[0:]))})
+		}
+	}
+	if hdr.appInfo != 0 {
+		entries = append(entries, entry{"appinfo", hdr.appInfo})
+	}
+	if hdr.sortInfo != 0 {
+		entries = append(entries, entry{"sortinfo", hdr.sortInfo})
+	}
+
+	// Each block runs until the next one begins
+	slices.SortStableFunc(entries, func(a, b entry) int { return cmp.Compare(a.offset, b.offset) })
+	fsys := fskeleton.New()
+	defer fsys.NoMore()
+	for i, e := range entries {
+		end := size
+		if i+1 < len(entries) {
+			end = entries[i+1].offset
+		}
+		if e.offset < headerSize || e.offset > end {
+			return nil, ErrFormat
+		}
+		fsys.CreateReaderAt(e.name, e.offset, sectionreader.Section(dataReader, e.offset, end-e.offset), end-e.offset, 0, hdr.mtime)
+	}
+	return fsys, nil
+}
+
+// typeName makes a filename out of a four-character code, which is in the Palm (Windows-1252) charset
+func typeName(t []byte) string {
+	var s strings.Builder
+	for _, c := range t {
+		switch {
+		case c == '/':
+			s.WriteByte(':')
+		case c < 0x20 || c == 0x7f:
+			s.WriteByte('_')
+		default:
+			s.WriteRune(charmap.Windows1252.DecodeByte(c))
+		}
+	}
+	name := s.String()
+	if strings.HasPrefix(name, ".") {
+		name = "_" + name[1:]
+	}
+	return name
+}
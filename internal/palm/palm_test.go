@@ -0,0 +1,99 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package palm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+// build makes a database with the given entries (each a 10- or 8-byte list entry minus its offset) and contents
+func build(resource bool, appInfo []byte, keys [][]byte, contents [][]byte) []byte {
+	var h [headerSize]byte
+	copy(h[:], "Test DB\x00")
+	if resource {
+		binary.BigEndian.PutUint16(h[32:], attrResourceDB)
+	}
+	binary.BigEndian.PutUint32(h[40:], 0xb378d300) // June 1999
+	copy(h[60:], "appldata")
+	binary.BigEndian.PutUint16(h[76:], uint16(len(keys)))
+
+	entrySize := 8
+	if resource {
+		entrySize = 10
+	}
+	off := headerSize + entrySize*len(keys) + 2 // traditional padding
+	var list, data bytes.Buffer
+	if appInfo != nil {
+		binary.BigEndian.PutUint32(h[52:], uint32(off))
+		data.Write(appInfo)
+		off += len(appInfo)
+	}
+	for i, k := range keys {
+		if resource {
+			list.Write(k)
+			binary.Write(&list, binary.BigEndian, uint32(off))
+		} else {
+			binary.Write(&list, binary.BigEndian, uint32(off))
+			list.Write(k)
+		}
+		data.Write(contents[i])
+		off += len(contents[i])
+	}
+	return append(append(append(h[:], list.Bytes()...), 0, 0), data.Bytes()...)
+}
+
+func TestRecords(t *testing.T) {
+	db := build(false, []byte("APPINFO"),
+		[][]byte{{0x40, 0, 0, 1}, {0x40, 0, 0, 2}, {0x40, 0, 0, 3}},
+		[][]byte{[]byte("first"), []byte(""), []byte("third record")})
+	if !Probe(bytes.NewReader(db), int64(len(db))) {
+		t.Fatal("Probe rejected a valid database")
+	}
+	fsys, err := New(bytes.NewReader(db), int64(len(db)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fstest.TestFS(fsys, "0", "1", "2", "appinfo")
+	if err != nil {
+		t.Error(err)
+	}
+	for name, want := range map[string]string{"0": "first", "1": "", "2": "third record", "appinfo": "APPINFO"} {
+		got, err := fs.ReadFile(fsys, name)
+		if err != nil || string(got) != want {
+			t.Errorf("%s: got %q, %v, want %q", name, got, err, want)
+		}
+	}
+	if s, _ := fs.Stat(fsys, "0"); s.ModTime().Year() != 1999 {
+		t.Errorf("expected a 1999 modtime, got %v", s.ModTime())
+	}
+}
+
+func TestResources(t *testing.T) {
+	db := build(true, nil,
+		[][]byte{[]byte("code\x00\x01"), []byte("tFRM\x03\xe8"), []byte("a/b.\x00\x00")},
+		[][]byte{[]byte("CODE"), []byte("FORM"), []byte("slash")})
+	fsys, err := New(bytes.NewReader(db), int64(len(db)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fstest.TestFS(fsys, "code/1", "tFRM/1000", "a:b./0")
+	if err != nil {
+		t.Error(err)
+	}
+	got, err := fs.ReadFile(fsys, "tFRM/1000")
+	if err != nil || string(got) != "FORM" {
+		t.Errorf("got %q, %v", got, err)
+	}
+}
+
+func TestNotPalm(t *testing.T) {
+	junk := bytes.Repeat([]byte{0xff}, 200)
+	if Probe(bytes.NewReader(junk), int64(len(junk))) {
+		t.Error("Probe accepted junk")
+	}
+}
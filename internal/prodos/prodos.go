@@ -0,0 +1,317 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+// Package prodos reads the ProDOS file system of the Apple II from disk images in ProDOS block order,
+// as in .po and .hdv files and most .2mg files.
+//
+// The volume directory header is in block 2, and the volume name is not used.
+// Blocks that a file never wrote are read as zeros. The files of GS/OS with a resource fork
+// have it in the AppleDouble sidecar, along with the ProDOS file type as AppleShare maps it to the Macintosh.
+package prodos
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"slices"
+	"time"
+
+	"github.com/elliotnunn/BeHierarchic/internal/appledouble"
+	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
+	"github.com/elliotnunn/BeHierarchic/internal/guard"
+	"github.com/elliotnunn/BeHierarchic/internal/multireaderat"
+	"github.com/elliotnunn/BeHierarchic/internal/sectionreader"
+	"github.com/elliotnunn/BeHierarchic/internal/walltime"
+)
+
+var ErrFormat = errors.New("not a ProDOS volume")
+
+const (
+	BlockSize   = 512
+	volumeBlock = 2 // the key block of the volume directory
+	entryLength = 0x27
+	maxDepth    = 64
+)
+
+// Storage types, in the high nibble of the first byte of an entry
+const (
+	seedling  = 1
+	sapling   = 2
+	tree      = 3
+	extended  = 5 // data and resource forks
+	subdir    = 0xd
+	volHeader = 0xf
+)
+
+// IsVolume checks the volume directory header in the 512 bytes of block 2
+func IsVolume(block []byte) bool {
+	if len(block) < BlockSize || binary.LittleEndian.Uint16(block) != 0 { // no previous block
+		return false
+	}
+	h := block[4:]
+	nameLen := int(h[0] & 0xf)
+	if h[0]>>4 != volHeader || nameLen == 0 || !validName(h[1:1+nameLen]) {
+		return false
+	}
+	le := binary.LittleEndian
+	return h[0x1f] == entryLength && h[0x20] == BlockSize/entryLength &&
+		le.Uint16(h[0x23:]) > volumeBlock && le.Uint16(h[0x25:]) > le.Uint16(h[0x23:]) // bitmap and total blocks
+}
+
+// validName insists on the characters that ProDOS allows
+func validName(name []byte) bool {
+	if len(name) == 0 || name[0] < 'A' || name[0] > 'Z' {
+		return false
+	}
+	for _, c := range name {
+		if !('A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// Parse2IMG finds the ProDOS-order image in a .2mg file, whose header is 64 bytes
+func Parse2IMG(head []byte) (offset, size int64, ok bool) {
+	le := binary.LittleEndian
+	if len(head) < 64 || string(head[:4]) != "2IMG" || le.Uint32(head[12:]) != 1 { // 1 for ProDOS order
+		return 0, 0, false
+	}
+	return int64(le.Uint32(head[24:])), int64(le.Uint32(head[28:])), true
+}
+
+// New2 presents the files on the volume
+func New2(headerReader, dataReader io.ReaderAt, size int64) (retfs fs.FS, reterr error) {
+	defer guard.Recover(&reterr, "ProDOS", nil)
+	block := make([]byte, BlockSize)
+	if _, err := headerReader.ReadAt(block, volumeBlock*BlockSize); err != nil {
+		return nil, err
+	} else if !IsVolume(block) {
+		return nil, ErrFormat
+	}
+
+	v := &volume{
+		fsys:     fskeleton.New(),
+		hr:       headerReader,
+		dr:       dataReader,
+		blocks:   size / BlockSize,
+		visited:  make(map[uint16]bool),
+		perBlock: int(block[4+0x20]),
+	}
+	go func() {
+		defer v.fsys.NoMore()
+		defer guard.Log("ProDOS", nil)
+		v.walk(volumeBlock, "", 0)
+	}()
+	return v.fsys, nil
+}
+
+type volume struct {
+	fsys     *fskeleton.FS
+	hr, dr   io.ReaderAt
+	blocks   int64
+	perBlock int
+	visited  map[uint16]bool // directory blocks, in case of a loop
+}
+
+func (v *volume) block(n uint16) ([]byte, bool) {
+	if n == 0 || int64(n) >= v.blocks {
+		return nil, false
+	}
+	b := make([]byte, BlockSize)
+	_, err := v.hr.ReadAt(b, int64(n)*BlockSize)
+	return b, err == nil
+}
+
+// walk adds the entries of the directory whose key block is given.
+// A damaged directory stops early, keeping what is already known.
+func (v *volume) walk(key uint16, dir string, depth int) {
+	if depth > maxDepth {
+		return
+	}
+	le := binary.LittleEndian
+	first := true
+	for n := key; n != 0; {
+		if v.visited[n] {
+			return
+		}
+		v.visited[n] = true
+		b, ok := v.block(n)
+		if !ok {
+			return
+		}
+		for i := range v.perBlock {
+			if first { // the directory header
+				first = false
+				continue
+			}
+			off := 4 + i*entryLength
+			if off+entryLength > BlockSize {
+				break
+			}
+			v.entry(b[off:][:entryLength], dir, depth)
+		}
+		n = le.Uint16(b[2:])
+	}
+}
+
+func (v *volume) entry(e []byte, dir string, depth int) {
+	le := binary.LittleEndian
+	storage := e[0] >> 4
+	nameLen := int(e[0] & 0xf)
+	if storage == 0 || nameLen == 0 || !validName(e[1:1+nameLen]) {
+		return // deleted or damaged
+	}
+	name := lowercase(e[1:1+nameLen], le.Uint16(e[0x1c:]))
+	if dir != "" {
+		name = dir + "/" + name
+	}
+	fileType := e[0x10]
+	key := le.Uint16(e[0x11:])
+	eof := int64(e[0x15]) | int64(e[0x16])<<8 | int64(e[0x17])<<16
+	ctime := datetime(e[0x18:])
+	access := e[0x1e]
+	auxType := le.Uint16(e[0x1f:])
+	mtime := datetime(e[0x21:])
+	id := int64(key) << 1
+
+	var meta appledouble.AppleDouble
+	meta.CreateTime, meta.ModTime = ctime, mtime
+	meta.Locked = access&0x02 == 0 // write-enable
+	meta.Type, meta.Creator = appledouble.ProDOSType(fileType, auxType)
+
+	switch storage {
+	case subdir:
+		v.fsys.Mkdir(name, id, 0, mtime)
+		v.walk(key, name, depth+1)
+	case seedling, sapling, tree:
+		v.fsys.CreateReaderAt(name, id, v.fork(storage, key, eof), eof, 0, mtime)
+		ad, size := meta.WithResourceFork(nil, 0)
+		v.fsys.CreateReaderAt(appledouble.Sidecar(name), id|1, ad, size, 0, mtime)
+	case extended:
+		b, ok := v.block(key)
+		if !ok {
+			return
+		}
+		mini := func(m []byte) (byte, uint16, int64) {
+			return m[0] & 0xf, le.Uint16(m[1:]), int64(m[5]) | int64(m[6])<<8 | int64(m[7])<<16
+		}
+		dstorage, dkey, deof := mini(b)
+		rstorage, rkey, reof := mini(b[256:])
+		if b[8] == 18 && b[9] == 1 { // Finder information that GS/OS kept
+			meta.LoadFInfo((*[16]byte)(b[10:26]))
+			if b[26] == 18 && b[27] == 2 {
+				meta.LoadFXInfo((*[16]byte)(b[28:44]))
+			}
+		}
+		v.fsys.CreateReaderAt(name, id, v.fork(dstorage, dkey, deof), deof, 0, mtime)
+		ad, size := meta.WithResourceFork(v.fork(rstorage, rkey, reof), reof)
+		v.fsys.CreateReaderAt(appledouble.Sidecar(name), id|1, ad, size, 0, mtime)
+	}
+}
+
+// fork gathers the data blocks of a seedling, sapling or tree
+func (v *volume) fork(storage byte, key uint16, eof int64) multireaderat.SizeReaderAt {
+	nblocks := int((eof + BlockSize - 1) / BlockSize)
+	var blocks []uint16
+	switch storage {
+	case seedling:
+		blocks = []uint16{key}
+	case sapling:
+		blocks = v.index(key)
+	case tree:
+		for _, idx := range v.index(key)[:128] { // a master index block holds at most 128 pointers
+			if len(blocks) >= nblocks {
+				break
+			}
+			if idx == 0 {
+				blocks = append(blocks, make([]uint16, 256)...) // sparse
+			} else {
+				blocks = append(blocks, v.index(idx)...)
+			}
+		}
+	}
+	blocks = blocks[:min(len(blocks), nblocks)]
+
+	var parts []multireaderat.SizeReaderAt
+	var runStart, runEnd int64 = -1, -1
+	flush := func() {
+		if runStart >= 0 {
+			parts = append(parts, sectionreader.Section(v.dr, runStart*BlockSize, (runEnd-runStart)*BlockSize))
+		}
+		runStart, runEnd = -1, -1
+	}
+	for _, b := range blocks {
+		switch {
+		case b == 0 || int64(b) >= v.blocks: // sparse, or else damaged
+			flush()
+			parts = append(parts, zeros(BlockSize))
+		case int64(b) == runEnd:
+			runEnd++
+		default:
+			flush()
+			runStart, runEnd = int64(b), int64(b)+1
+		}
+	}
+	flush()
+	data := multireaderat.New(parts...)
+	return sectionreader.Section(data, 0, min(eof, data.Size()))
+}
+
+// index reads the 256 pointers of an index block, whose low bytes come before the high bytes
+func (v *volume) index(n uint16) []uint16 {
+	ptrs := make([]uint16, 256)
+	b, ok := v.block(n)
+	if !ok {
+		return ptrs
+	}
+	for i := range ptrs {
+		ptrs[i] = uint16(b[i]) | uint16(b[256+i])<<8
+	}
+	return ptrs
+}
+
+// lowercase applies the flags that GS/OS keeps in the version fields, when the top bit is set
+func lowercase(name []byte, flags uint16) string {
+	s := slices.Clone(name)
+	if flags&0x8000 != 0 {
+		for i := range s {
+			if flags&(0x4000>>i) != 0 && 'A' <= s[i] && s[i] <= 'Z' {
+				s[i] += 'a' - 'A'
+			}
+		}
+	}
+	return string(s)
+}
+
+// datetime reads the date word, with a seven-bit year that counts from 1940 to 2039, then the minute and hour
+func datetime(b []byte) time.Time {
+	date := binary.LittleEndian.Uint16(b)
+	year, month, day := int(date>>9), int(date>>5&0xf), int(date&0x1f)
+	minute, hour := int(b[2]), int(b[3])
+	if date == 0 || month < 1 || month > 12 || day < 1 || minute > 59 || hour > 23 {
+		return time.Time{}
+	}
+	if year < 40 {
+		year += 100
+	}
+	return time.Date(1900+year, time.Month(month), day, hour, minute, 0, 0, walltime.Zone)
+}
+
+// zeros is a block that was never written
+type zeros int64
+
+func (z zeros) Size() int64 { return int64(z) }
+
+func (z zeros) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(z) {
+		return 0, io.EOF
+	}
+	n := int(min(int64(len(p)), int64(z)-off))
+	clear(p[:n])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,176 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package prodos
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"testing"
+	"time"
+)
+
+type image []byte
+
+func (img image) block(n int) []byte { return img[n*BlockSize:][:BlockSize] }
+
+// header writes a volume or subdirectory header as the first entry of a key block
+func (img image) header(blk int, storage byte, name string, next uint16) {
+	b := img.block(blk)
+	binary.LittleEndian.PutUint16(b[2:], next)
+	h := b[4:]
+	h[0] = storage<<4 | byte(len(name))
+	copy(h[1:], name)
+	h[0x1f], h[0x20] = entryLength, BlockSize/entryLength
+	binary.LittleEndian.PutUint16(h[0x23:], 6) // bitmap
+	binary.LittleEndian.PutUint16(h[0x25:], uint16(len(img)/BlockSize))
+}
+
+// stamp is 1992-07-02 03:04
+var stamp = []byte{0x02 | 7<<5, 92 << 1, 4, 3}
+
+func (img image) entry(blk, i int, storage byte, name string, fileType byte, key uint16, eof int, lower uint16) {
+	e := img.block(blk)[4+i*entryLength:][:entryLength]
+	e[0] = storage<<4 | byte(len(name))
+	copy(e[1:], name)
+	e[0x10] = fileType
+	binary.LittleEndian.PutUint16(e[0x11:], key)
+	e[0x15], e[0x16], e[0x17] = byte(eof), byte(eof>>8), byte(eof>>16)
+	copy(e[0x18:], stamp)
+	binary.LittleEndian.PutUint16(e[0x1c:], lower)
+	e[0x1e] = 0xc3
+	copy(e[0x21:], stamp)
+}
+
+func (img image) index(blk int, ptrs ...uint16) {
+	b := img.block(blk)
+	for i, p := range ptrs {
+		b[i], b[256+i] = byte(p), byte(p>>8)
+	}
+}
+
+func fill(b []byte, c byte) []byte {
+	for i := range b {
+		b[i] = c
+	}
+	return b
+}
+
+func testImage() image {
+	img := make(image, 280*BlockSize)
+	img.header(2, volHeader, "TEST.DISK", 3)
+	img.entry(2, 1, seedling, "README", 0x04, 10, 5, 0xffff) // all lowercase
+	img.entry(2, 2, sapling, "SPARSE", 0x06, 11, 3*BlockSize+100, 0)
+	img.entry(2, 3, subdir, "SUB", 0x0f, 20, BlockSize, 0)
+	img.entry(3, 0, extended, "FORKED", 0xb3, 30, BlockSize, 0) // continues into the second block
+	img.header(20, 0xe, "SUB", 0)
+	img.entry(20, 1, seedling, "INNER", 0x00, 21, 3, 0)
+
+	copy(img.block(10), "hello")
+	img.index(11, 12, 0, 13, 14) // the second block was never written
+	fill(img.block(12), 'a')
+	fill(img.block(13), 'c')
+	fill(img.block(14), 'd')
+	copy(img.block(21), "abc")
+
+	k := img.block(30)
+	k[0], k[1], k[5] = seedling, 31, 4       // data fork
+	k[256], k[257], k[261] = seedling, 32, 8 // resource fork
+	k[8], k[9] = 18, 1
+	copy(k[10:], "APPLpdos")
+	copy(img.block(31), "data")
+	copy(img.block(32), "resource")
+	return img
+}
+
+func TestVolume(t *testing.T) {
+	img := testImage()
+	if !IsVolume(img.block(2)) {
+		t.Fatal("volume header not recognised")
+	}
+	fsys, err := New2(bytes.NewReader(img), bytes.NewReader(img), int64(len(img)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sparse := append(append(append(fill(make([]byte, BlockSize), 'a'), make([]byte, BlockSize)...),
+		fill(make([]byte, BlockSize), 'c')...), fill(make([]byte, 100), 'd')...)
+	for name, want := range map[string][]byte{
+		"readme":    []byte("hello"),
+		"SPARSE":    sparse,
+		"SUB/INNER": []byte("abc"),
+		"FORKED":    []byte("data"),
+	} {
+		got, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			t.Error(name, err)
+		} else if !bytes.Equal(got, want) {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+
+	if s, err := fs.Stat(fsys, "SUB"); err != nil || !s.IsDir() {
+		t.Error("subdirectory missing", err)
+	}
+	if s, err := fs.Stat(fsys, "readme"); err != nil {
+		t.Error(err)
+	} else if want := time.Date(1992, 7, 2, 3, 4, 0, 0, time.UTC); !s.ModTime().Equal(want) {
+		t.Errorf("modtime %v, want %v", s.ModTime(), want)
+	}
+
+	ad, err := fs.ReadFile(fsys, "._readme")
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Contains(ad, []byte("TEXTpdos")) {
+		t.Error("TXT file not mapped to TEXT")
+	}
+	ad, err = fs.ReadFile(fsys, "._FORKED")
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.HasSuffix(ad, []byte("resource")) {
+		t.Error("resource fork missing from sidecar")
+	} else if !bytes.Contains(ad, []byte("APPLpdos")) {
+		t.Error("Finder information not used")
+	}
+}
+
+func TestLoop(t *testing.T) {
+	img := testImage()
+	binary.LittleEndian.PutUint16(img.block(3)[2:], 2) // back to the first block
+	img.entry(20, 2, subdir, "AGAIN", 0x0f, 20, BlockSize, 0)
+	fsys, err := New2(bytes.NewReader(img), bytes.NewReader(img), int64(len(img)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.ReadFile(fsys, "SUB/INNER"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNotVolume(t *testing.T) {
+	img := testImage()
+	img.block(2)[4] = 0xe9 // a subdirectory header
+	if IsVolume(img.block(2)) {
+		t.Error("subdirectory header taken for a volume")
+	}
+	if _, err := New2(bytes.NewReader(img), bytes.NewReader(img), int64(len(img))); !errors.Is(err, ErrFormat) {
+		t.Errorf("got %v, want %v", err, ErrFormat)
+	}
+}
+
+func Test2IMG(t *testing.T) {
+	h := make([]byte, 64)
+	copy(h, "2IMG")
+	binary.LittleEndian.PutUint32(h[12:], 1)
+	binary.LittleEndian.PutUint32(h[24:], 64)
+	binary.LittleEndian.PutUint32(h[28:], 280*BlockSize)
+	if off, size, ok := Parse2IMG(h); !ok || off != 64 || size != 280*BlockSize {
+		t.Errorf("got %d %d %v", off, size, ok)
+	}
+	binary.LittleEndian.PutUint32(h[12:], 0) // DOS order
+	if _, _, ok := Parse2IMG(h); ok {
+		t.Error("DOS-order image accepted")
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+// Package rar lists the archives of Eugene Roshal's RAR, versions 1.5 to 4 and version 5,
+// following the technical notes that come with RAR and the UnRAR source.
+//
+// Only stored files can be read: the compression methods are not implemented,
+// but every file is listed with its size and date, which is often enough for browsing.
+// A multi-volume set is read through from its first volume.
+package rar
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elliotnunn/BeHierarchic/internal/checksumreader"
+	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
+	"github.com/elliotnunn/BeHierarchic/internal/guard"
+	"github.com/elliotnunn/BeHierarchic/internal/multireaderat"
+	"github.com/elliotnunn/BeHierarchic/internal/sectionreader"
+)
+
+var (
+	ErrFormat    = errors.New("not a valid RAR archive")
+	ErrMethod    = errors.New("RAR: unimplemented compression method")
+	ErrEncrypted = errors.New("RAR: encrypted file")
+	ErrVolume    = errors.New("RAR: missing volume")
+)
+
+const (
+	sig4 = "Rar!\x1a\x07\x00"
+	sig5 = "Rar!\x1a\x07\x01\x00"
+
+	maxVolumes = 10000
+)
+
+// IsArchive checks the magic number of either version
+func IsArchive(head []byte) bool {
+	return strings.HasPrefix(string(head), sig4) || strings.HasPrefix(string(head), sig5)
+}
+
+// Opener opens another volume of a set, named relative to the first
+type Opener func(name string) (io.ReaderAt, int64, error)
+
+// IsLaterVolume reports whether an archive is the second or later volume of a set,
+// which is read as part of the first volume rather than on its own.
+// Before RAR 3.0 the first volume was not marked as such, so a version 4 volume is taken to be a later one
+// if its first file is continued from the volume before, or if its name says so.
+func IsLaterVolume(r io.ReaderAt, name string) bool {
+	m, err := mainHeader(r)
+	if err != nil || !m.volume {
+		return false
+	} else if m.v5 || m.first {
+		return !m.first
+	}
+	v := &volume4{r: r, off: m.start}
+	v.next()
+	return v.firstBefore || laterVolumeName(name)
+}
+
+// laterVolumeName is true of "name.part2.rar" and "name.r00", but not "name.part1.rar" or "name.rar"
+func laterVolumeName(name string) bool {
+	if m := newNumbering.FindStringSubmatch(name); m != nil {
+		n, _ := strconv.Atoi(m[2])
+		return n > 1
+	}
+	return oldNumbering.MatchString(name)
+}
+
+// member is a file as it is recorded in one volume, perhaps only a part of it
+type member struct {
+	name          string
+	id            int64
+	dir           bool
+	off, packed   int64 // of the data in this volume
+	size          int64
+	mtime         time.Time
+	crc           uint32
+	hasCRC        bool
+	stored        bool
+	method        int
+	encrypted     bool
+	before, after bool // split across volumes
+}
+
+// volume walks the members of one volume
+type volume interface {
+	next() (m *member, ok bool) // ok is false at the end of the volume
+	continues() bool            // at the end, whether another volume follows, by the end block or a file split after
+}
+
+type mainInfo struct {
+	v5                   bool
+	volume, first, newNm bool
+	encryptedHeaders     bool
+	start                int64 // of the first header after the main header
+}
+
+// New opens an archive that is not part of a multi-volume set
+func New(r io.ReaderAt) (fs.FS, error) {
+	return New2(r, r, "", nil)
+}
+
+// New2 routes headers and data requests through different readers, to help exotic caching schemes.
+// The name of the first volume and an Opener are needed to read a multi-volume set.
+func New2(headerReader, dataReader io.ReaderAt, name string, open Opener) (retfs fs.FS, reterr error) {
+	defer guard.Recover(&reterr, "RAR", nil)
+	m, err := mainHeader(headerReader)
+	if err != nil {
+		return nil, err
+	}
+	fsys := fskeleton.New()
+	go populate(fsys, m, headerReader, dataReader, name, open)
+	return fsys, nil
+}
+
+func populate(fsys *fskeleton.FS, m mainInfo, headerReader, dataReader io.ReaderAt, name string, open Opener) {
+	defer fsys.NoMore()
+	var at int64 // volume number and header offset
+	defer guard.Log("RAR", &at)
+
+	var pending []*member
+	var parts []multireaderat.SizeReaderAt
+	for n := 0; n < maxVolumes; n++ {
+		if n > 0 {
+			if open == nil || !m.volume {
+				break
+			}
+			name = nextVolumeName(name, m.newNm)
+			r, _, err := open(name)
+			if err != nil {
+				break
+			}
+			headerReader, dataReader = r, r
+			if m, err = mainHeader(r); err != nil {
+				break
+			}
+		}
+		if m.encryptedHeaders {
+			return // nothing can be listed without the password
+		}
+
+		var v volume
+		if m.v5 {
+			v = &volume5{r: headerReader, off: m.start}
+		} else {
+			v = &volume4{r: headerReader, off: m.start}
+		}
+		for {
+			f, ok := v.next()
+			if !ok {
+				break
+			}
+			f.id |= int64(n) << 40
+			at = f.id
+			if f.before {
+				if len(pending) == 0 || pending[0].name != f.name {
+					pending, parts = nil, nil
+					continue // the start is in a volume that we did not read
+				}
+			} else {
+				pending, parts = nil, nil
+			}
+			pending = append(pending, f)
+			parts = append(parts, sectionreader.Section(dataReader, f.off, f.packed))
+			if f.after {
+				continue
+			}
+			create(fsys, pending, parts)
+			pending, parts = nil, nil
+		}
+		if !v.continues() {
+			break
+		}
+	}
+	if len(pending) > 0 { // the set ended in the middle of a file
+		first := pending[0]
+		fsys.CreateError(first.name, first.id, ErrVolume, first.size, 0, first.mtime)
+	}
+}
+
+// create makes a file out of its parts, of which the first holds its identity and the last its CRC
+func create(fsys *fskeleton.FS, pending []*member, parts []multireaderat.SizeReaderAt) {
+	first, last := pending[0], pending[len(pending)-1]
+	switch {
+	case first.dir:
+		fsys.Mkdir(first.name, first.id, 0, first.mtime)
+	case first.encrypted:
+		fsys.CreateError(first.name, first.id, ErrEncrypted, first.size, 0, first.mtime)
+	case !first.stored:
+		fsys.CreateError(first.name, first.id, fmt.Errorf("%w %d", ErrMethod, first.method), first.size, 0, first.mtime)
+	default:
+		data := multireaderat.New(parts...)
+		size := data.Size()
+		if !last.hasCRC {
+			fsys.CreateReaderAt(first.name, first.id, data, size, 0, first.mtime)
+			return
+		}
+		want := binary.BigEndian.AppendUint32(nil, last.crc)
+		sum := &fskeleton.Checksum{Algo: "crc32", Sum: want}
+		fsys.CreateReaderAt(first.name, first.id, checksumreader.NewAt(data, size, crc32.NewIEEE(), want, sum.Verified), size, 0, first.mtime)
+		fsys.SetChecksum(first.name, sum)
+	}
+}
+
+func mainHeader(r io.ReaderAt) (mainInfo, error) {
+	head := make([]byte, len(sig5))
+	if n, err := r.ReadAt(head, 0); n != len(head) {
+		if err == io.EOF {
+			err = ErrFormat
+		}
+		return mainInfo{}, err
+	}
+	switch {
+	case strings.HasPrefix(string(head), sig5):
+		return mainHeader5(r)
+	case strings.HasPrefix(string(head), sig4):
+		return mainHeader4(r)
+	}
+	return mainInfo{}, ErrFormat
+}
+
+var (
+	newNumbering = regexp.MustCompile(`(?i)^(.*\.part)([0-9]+)(\.rar)$`)
+	oldNumbering = regexp.MustCompile(`(?i)^(.*\.)([a-z])([0-9]{2})$`)
+)
+
+// nextVolumeName follows "name.part1.rar" to "name.part2.rar",
+// or in the old style "name.rar" to "name.r00" to "name.r01" and after "name.r99" to "name.s00"
+func nextVolumeName(name string, newStyle bool) string {
+	if m := newNumbering.FindStringSubmatch(name); newStyle && m != nil {
+		n, _ := strconv.Atoi(m[2])
+		return fmt.Sprintf("%s%0*d%s", m[1], len(m[2]), n+1, m[3])
+	}
+	if base, ok := strings.CutSuffix(name, ".rar"); ok {
+		return base + ".r00"
+	} else if base, ok := strings.CutSuffix(name, ".RAR"); ok {
+		return base + ".R00"
+	}
+	if m := oldNumbering.FindStringSubmatch(name); m != nil {
+		n, _ := strconv.Atoi(m[3])
+		letter := m[2]
+		if n == 99 {
+			letter, n = string(letter[0]+1), -1
+		}
+		return fmt.Sprintf("%s%s%02d", m[1], letter, n+1)
+	}
+	return name + ".r00"
+}
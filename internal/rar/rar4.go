@@ -0,0 +1,233 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package rar
+
+import (
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/elliotnunn/BeHierarchic/internal/dostime"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Block types and flags of versions 1.5 to 4
+const (
+	block4Main = 0x73
+	block4File = 0x74
+	block4End  = 0x7b
+
+	flag4LongBlock = 0x8000 // ADD_SIZE follows HEAD_SIZE
+
+	main4Volume       = 0x0001
+	main4NewNumbering = 0x0010
+	main4Password     = 0x0080
+	main4FirstVolume  = 0x0100
+
+	file4SplitBefore = 0x0001
+	file4SplitAfter  = 0x0002
+	file4Password    = 0x0004
+	file4Directory   = 0x00e0 // all three bits of the dictionary size
+	file4Large       = 0x0100
+	file4Unicode     = 0x0200
+
+	end4NextVolume = 0x0001
+
+	method4Store = 0x30
+	file4Fixed   = 32 // bytes of a file header before the name
+)
+
+func mainHeader4(r io.ReaderAt) (mainInfo, error) {
+	h := make([]byte, 7)
+	if n, _ := r.ReadAt(h, int64(len(sig4))); n != len(h) || h[2] != block4Main {
+		return mainInfo{}, ErrFormat
+	}
+	flags := binary.LittleEndian.Uint16(h[3:])
+	size := int64(binary.LittleEndian.Uint16(h[5:]))
+	if size < 7 {
+		return mainInfo{}, ErrFormat
+	}
+	return mainInfo{
+		volume:           flags&main4Volume != 0,
+		first:            flags&main4FirstVolume != 0,
+		newNm:            flags&main4NewNumbering != 0,
+		encryptedHeaders: flags&main4Password != 0,
+		start:            int64(len(sig4)) + size,
+	}, nil
+}
+
+type volume4 struct {
+	r           io.ReaderAt
+	off         int64
+	more        bool // the end block asks for the next volume, which only RAR 3.0 and later record
+	seen        bool // a file header
+	firstBefore bool // the first file header is split before
+	lastAfter   bool // the last file header is split after, which is enough when there is no end block
+}
+
+func (v *volume4) continues() bool { return v.more || v.lastAfter }
+
+func (v *volume4) next() (*member, bool) {
+	le := binary.LittleEndian
+	for {
+		h := make([]byte, 11)
+		n, _ := v.r.ReadAt(h, v.off)
+		if n < 7 {
+			return nil, false
+		}
+		typ, flags, size := h[2], le.Uint16(h[3:]), int64(le.Uint16(h[5:]))
+		if size < 7 {
+			return nil, false
+		}
+		start := v.off
+		next := start + size
+		if flags&flag4LongBlock != 0 || typ == block4File {
+			if n < 11 {
+				return nil, false
+			}
+			next += int64(le.Uint32(h[7:]))
+		}
+		v.off = next
+
+		switch typ {
+		case block4End:
+			v.more = flags&end4NextVolume != 0
+			return nil, false
+		case block4File:
+			if !v.seen {
+				v.seen, v.firstBefore = true, flags&file4SplitBefore != 0
+			}
+			v.lastAfter = flags&file4SplitAfter != 0
+			if size < file4Fixed {
+				return nil, false
+			}
+			h = make([]byte, size)
+			if n, _ := v.r.ReadAt(h, start); n != len(h) {
+				return nil, false
+			}
+			if flags&file4Large != 0 && len(h) >= file4Fixed+8 {
+				v.off += int64(le.Uint32(h[32:])) << 32
+			}
+			if f := file4(h, start); f != nil {
+				return f, true
+			}
+		}
+	}
+}
+
+// file4 interprets a file header, or returns nil if it is not usable
+func file4(h []byte, start int64) *member {
+	le := binary.LittleEndian
+	flags := le.Uint16(h[3:])
+	packed, size := int64(le.Uint32(h[7:])), int64(le.Uint32(h[11:]))
+	host := h[15]
+	ftime := le.Uint32(h[20:])
+	nameSize := int(le.Uint16(h[26:]))
+	nameAt := file4Fixed
+	if flags&file4Large != 0 {
+		if len(h) < nameAt+8 {
+			return nil
+		}
+		packed |= int64(le.Uint32(h[32:])) << 32
+		size |= int64(le.Uint32(h[36:])) << 32
+		nameAt += 8
+	}
+	if len(h) < nameAt+nameSize {
+		return nil
+	}
+	rawName := h[nameAt:][:nameSize]
+
+	var name string
+	if ascii, enc, ok := strings.Cut(string(rawName), "\x00"); ok && flags&file4Unicode != 0 {
+		name = decodeUnicode([]byte(ascii), []byte(enc))
+	} else if flags&file4Unicode != 0 || host == hostUnix && utf8.Valid(rawName) {
+		name = string(rawName)
+	} else {
+		name, _ = charmap.CodePage437.NewDecoder().String(string(rawName))
+	}
+	if host != hostUnix {
+		name = strings.ReplaceAll(name, "\\", "/")
+	}
+	name = strings.Trim(name, "/")
+	if !fs.ValidPath(name) || name == "." {
+		return nil
+	}
+
+	return &member{
+		name:      name,
+		id:        start,
+		dir:       flags&file4Directory == file4Directory,
+		off:       start + int64(len(h)),
+		packed:    packed,
+		size:      size,
+		mtime:     dostime.Time(uint16(ftime>>16), uint16(ftime)),
+		crc:       le.Uint32(h[16:]),
+		hasCRC:    true,
+		stored:    h[25] == method4Store,
+		method:    int(h[25]) - method4Store,
+		encrypted: flags&file4Password != 0,
+		before:    flags&file4SplitBefore != 0,
+		after:     flags&file4SplitAfter != 0,
+	}
+}
+
+const hostUnix = 3
+
+// decodeUnicode expands the compact UTF-16 encoding of a name, which refers back to its ASCII version
+func decodeUnicode(ascii, enc []byte) string {
+	if len(enc) == 0 {
+		return string(ascii)
+	}
+	high := uint16(enc[0])
+	var out []uint16
+	var flags byte
+	bits := 0
+	for i := 1; i < len(enc); {
+		if bits == 0 {
+			flags, bits = enc[i], 8
+			i++
+			if i == len(enc) {
+				break
+			}
+		}
+		switch flags >> 6 {
+		case 0:
+			out = append(out, uint16(enc[i]))
+			i++
+		case 1:
+			out = append(out, uint16(enc[i])|high<<8)
+			i++
+		case 2:
+			if i+1 >= len(enc) {
+				i = len(enc)
+				break
+			}
+			out = append(out, uint16(enc[i])|uint16(enc[i+1])<<8)
+			i += 2
+		case 3:
+			length := int(enc[i])
+			i++
+			if length&0x80 != 0 {
+				if i == len(enc) {
+					break
+				}
+				correction := enc[i]
+				i++
+				for length = length&0x7f + 2; length > 0 && len(out) < len(ascii); length-- {
+					out = append(out, uint16(ascii[len(out)]+correction)|high<<8)
+				}
+			} else {
+				for length += 2; length > 0 && len(out) < len(ascii); length-- {
+					out = append(out, uint16(ascii[len(out)]))
+				}
+			}
+		}
+		flags <<= 2
+		bits -= 2
+	}
+	return string(utf16.Decode(out))
+}
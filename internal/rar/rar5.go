@@ -0,0 +1,241 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package rar
+
+import (
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// Header types and flags of version 5
+const (
+	block5Main       = 1
+	block5File       = 2
+	block5Encryption = 4
+	block5End        = 5
+
+	head5Extra = 0x0001
+	head5Data  = 0x0002
+	head5Prev  = 0x0008 // data continues from the previous volume
+	head5Next  = 0x0010 // data continues in the next volume
+
+	main5Volume       = 0x0001
+	main5VolumeNumber = 0x0002 // absent from the first volume
+
+	file5Directory = 0x0001
+	file5UnixTime  = 0x0002
+	file5CRC       = 0x0004
+
+	end5NotLast = 0x0001
+
+	extra5Encryption = 1
+	extra5Time       = 3
+
+	time5Unix  = 0x0001
+	time5MTime = 0x0002
+	time5Nano  = 0x0010
+
+	maxHeader5 = 2 << 20 // as UnRAR allows
+)
+
+// reader5 consumes the fields of a header
+type reader5 struct {
+	b   []byte
+	bad bool
+}
+
+func (r *reader5) vint() uint64 {
+	var n uint64
+	for i := 0; i < 10; i++ {
+		if len(r.b) == 0 {
+			break
+		}
+		c := r.b[0]
+		r.b = r.b[1:]
+		n |= uint64(c&0x7f) << (7 * i)
+		if c&0x80 == 0 {
+			return n
+		}
+	}
+	r.bad = true
+	return 0
+}
+
+func (r *reader5) bytes(n uint64) []byte {
+	if uint64(len(r.b)) < n {
+		r.bad = true
+		r.b = nil
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *reader5) u32() uint32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+// header5 reads the header at off, returning its type and flags, its fields after them,
+// its extra area, the size of the data that follows, and the offset of that data
+func header5(r io.ReaderAt, off int64) (typ, flags uint64, fields, extra []byte, dataSize uint64, dataOff int64, ok bool) {
+	pre := make([]byte, 7) // CRC and up to three bytes of size
+	n, _ := r.ReadAt(pre, off)
+	sr := reader5{b: pre[4:n]}
+	size := sr.vint()
+	if sr.bad || n < 5 || size == 0 || size > maxHeader5 {
+		return
+	}
+	start := off + 4 + int64(3-len(sr.b))
+	h := make([]byte, size)
+	if n, _ := r.ReadAt(h, start); n != len(h) {
+		return
+	}
+	hr := reader5{b: h}
+	typ, flags = hr.vint(), hr.vint()
+	var extraSize uint64
+	if flags&head5Extra != 0 {
+		extraSize = hr.vint()
+	}
+	if flags&head5Data != 0 {
+		dataSize = hr.vint()
+	}
+	if hr.bad || extraSize > uint64(len(hr.b)) {
+		return
+	}
+	fields, extra = hr.b[:uint64(len(hr.b))-extraSize], hr.b[uint64(len(hr.b))-extraSize:]
+	return typ, flags, fields, extra, dataSize, start + int64(size), true
+}
+
+func mainHeader5(r io.ReaderAt) (mainInfo, error) {
+	typ, _, fields, _, _, next, ok := header5(r, int64(len(sig5)))
+	if ok && typ == block5Encryption {
+		return mainInfo{v5: true, encryptedHeaders: true}, nil
+	} else if !ok || typ != block5Main {
+		return mainInfo{}, ErrFormat
+	}
+	fr := reader5{b: fields}
+	flags := fr.vint()
+	return mainInfo{
+		v5:     true,
+		volume: flags&main5Volume != 0,
+		first:  flags&main5VolumeNumber == 0,
+		newNm:  true,
+		start:  next,
+	}, nil
+}
+
+type volume5 struct {
+	r         io.ReaderAt
+	off       int64
+	more      bool
+	lastAfter bool
+}
+
+func (v *volume5) continues() bool { return v.more || v.lastAfter }
+
+func (v *volume5) next() (*member, bool) {
+	for {
+		start := v.off
+		typ, flags, fields, extra, dataSize, dataOff, ok := header5(v.r, start)
+		if !ok || dataSize > 1<<62 {
+			return nil, false
+		}
+		v.off = dataOff + int64(dataSize)
+
+		switch typ {
+		case block5End:
+			fr := reader5{b: fields}
+			v.more = fr.vint()&end5NotLast != 0
+			return nil, false
+		case block5File:
+			v.lastAfter = flags&head5Next != 0
+			if f := file5(fields, extra, flags, start); f != nil {
+				f.off, f.packed = dataOff, int64(dataSize)
+				return f, true
+			}
+		}
+	}
+}
+
+// file5 interprets a file header, or returns nil if it is not usable
+func file5(fields, extra []byte, flags uint64, start int64) *member {
+	fr := reader5{b: fields}
+	fileFlags := fr.vint()
+	size := fr.vint()
+	fr.vint() // attributes
+	f := &member{id: start}
+	if fileFlags&file5UnixTime != 0 {
+		f.mtime = time.Unix(int64(fr.u32()), 0)
+	}
+	if fileFlags&file5CRC != 0 {
+		f.crc, f.hasCRC = fr.u32(), true
+	}
+	compression := fr.vint()
+	fr.vint() // host OS
+	name := string(fr.bytes(fr.vint()))
+	if fr.bad || size > 1<<62 {
+		return nil
+	}
+	name = strings.Trim(name, "/")
+	if !fs.ValidPath(name) || name == "." {
+		return nil
+	}
+	f.name = name
+	f.size = int64(size)
+	f.dir = fileFlags&file5Directory != 0
+	f.method = int(compression >> 7 & 7)
+	f.stored = f.method == 0
+	f.before = flags&head5Prev != 0
+	f.after = flags&head5Next != 0
+
+	for er := (reader5{b: extra}); len(er.b) > 0 && !er.bad; {
+		rec := reader5{b: er.bytes(er.vint())}
+		switch rec.vint() {
+		case extra5Encryption:
+			f.encrypted = true
+		case extra5Time:
+			tflags := rec.vint()
+			if tflags&time5MTime == 0 {
+				continue
+			}
+			if tflags&time5Unix != 0 {
+				secs := rec.u32()
+				var nanos uint32
+				if tflags&time5Nano != 0 {
+					skip := 0
+					for _, bit := range []uint64{0x4, 0x8} { // ctime and atime come after
+						if tflags&bit != 0 {
+							skip++
+						}
+					}
+					rest := rec.bytes(uint64(4 * skip))
+					if rest != nil {
+						nanos = rec.u32()
+					}
+				}
+				if !rec.bad {
+					f.mtime = time.Unix(int64(secs), int64(nanos))
+				}
+			} else if b := rec.bytes(8); b != nil {
+				f.mtime = filetime(binary.LittleEndian.Uint64(b))
+			}
+		}
+	}
+	return f
+}
+
+// filetime converts Windows 100-nanosecond ticks since 1601
+func filetime(t uint64) time.Time {
+	const ticksTo1970 = 116444736000000000
+	ticks := int64(t - ticksTo1970)
+	return time.Unix(ticks/1e7, ticks%1e7*100)
+}
@@ -0,0 +1,241 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package rar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+	"time"
+)
+
+// file4Block builds a version 4 file header and its data
+func file4Block(name string, flags uint16, method byte, data []byte, crc uint32) []byte {
+	le := binary.LittleEndian
+	h := make([]byte, file4Fixed)
+	h[2] = block4File
+	le.PutUint16(h[3:], flags|flag4LongBlock)
+	le.PutUint16(h[5:], uint16(file4Fixed+len(name)))
+	le.PutUint32(h[7:], uint32(len(data)))
+	le.PutUint32(h[11:], uint32(len(data)))
+	le.PutUint32(h[16:], crc)
+	le.PutUint32(h[20:], 0x5a216000) // 2025-01-01 12:00
+	h[25] = method
+	le.PutUint16(h[26:], uint16(len(name)))
+	h = append(h, name...)
+	return append(h, data...)
+}
+
+func archive4(mainFlags, endFlags uint16, blocks ...[]byte) []byte {
+	a := []byte(sig4)
+	a = append(a, 0, 0, block4Main, byte(mainFlags), byte(mainFlags>>8), 13, 0, 0, 0, 0, 0, 0, 0)
+	for _, b := range blocks {
+		a = append(a, b...)
+	}
+	return append(a, 0, 0, block4End, byte(endFlags), byte(endFlags>>8), 7, 0)
+}
+
+// noEnd removes the end block, which archives older than RAR 2.0 do not have
+func noEnd(a []byte) []byte { return a[:len(a)-7] }
+
+func vint(b []byte, n uint64) []byte {
+	for n >= 0x80 {
+		b = append(b, byte(n)|0x80)
+		n >>= 7
+	}
+	return append(b, byte(n))
+}
+
+func header5Block(typ, flags uint64, fields, extra, data []byte) []byte {
+	var h []byte
+	h = vint(h, typ)
+	if len(extra) > 0 {
+		flags |= head5Extra
+	}
+	if data != nil {
+		flags |= head5Data
+	}
+	h = vint(h, flags)
+	if len(extra) > 0 {
+		h = vint(h, uint64(len(extra)))
+	}
+	if data != nil {
+		h = vint(h, uint64(len(data)))
+	}
+	h = append(h, fields...)
+	h = append(h, extra...)
+	b := make([]byte, 4)
+	b = vint(b, uint64(len(h)))
+	b = append(b, h...)
+	return append(b, data...)
+}
+
+func file5Block(name string, flags uint64, method uint64, size uint64, data []byte, crc uint32) []byte {
+	var f []byte
+	f = vint(f, file5UnixTime|file5CRC)
+	f = vint(f, size)
+	f = vint(f, 0)
+	f = binary.LittleEndian.AppendUint32(f, 1700000000)
+	f = binary.LittleEndian.AppendUint32(f, crc)
+	f = vint(f, method<<7)
+	f = vint(f, 1)
+	f = vint(f, uint64(len(name)))
+	f = append(f, name...)
+	return header5Block(block5File, flags, f, nil, data)
+}
+
+func archive5(mainFlags, endFlags uint64, blocks ...[]byte) []byte {
+	a := []byte(sig5)
+	a = append(a, header5Block(block5Main, 0, vint(nil, mainFlags), nil, nil)...)
+	for _, b := range blocks {
+		a = append(a, b...)
+	}
+	return append(a, header5Block(block5End, 0, vint(nil, endFlags), nil, nil)...)
+}
+
+func TestRAR4(t *testing.T) {
+	hello := []byte("hello, world\n")
+	a := archive4(0, 0,
+		file4Block("dir\\hello.txt", 0, method4Store, hello, crc32.ChecksumIEEE(hello)),
+		file4Block("packed.bin", 0, method4Store+3, []byte("xyzzy"), 0),
+		file4Block("caf\xe9\x00\x00\xc0\x02", file4Unicode, method4Store, nil, 0),
+		file4Block("dir", file4Directory, method4Store, nil, 0),
+	)
+	fsys, err := New(bytes.NewReader(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := fs.ReadFile(fsys, "dir/hello.txt")
+	if err != nil || !bytes.Equal(got, hello) {
+		t.Errorf("stored file: %q, %v", got, err)
+	}
+	stat, err := fs.Stat(fsys, "dir/hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	} else if want := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC); !stat.ModTime().Equal(want) {
+		t.Errorf("modtime %v, want %v", stat.ModTime(), want)
+	}
+	if _, err := fs.ReadFile(fsys, "packed.bin"); !errors.Is(err, ErrMethod) {
+		t.Errorf("compressed file: expected ErrMethod, got %v", err)
+	}
+	if _, err := fs.Stat(fsys, "café"); err != nil {
+		t.Errorf("unicode name: %v", err)
+	}
+}
+
+func TestRAR5(t *testing.T) {
+	hello := []byte("hello, world\n")
+	a := archive5(0, 0,
+		file5Block("dir/hello.txt", 0, 0, uint64(len(hello)), hello, crc32.ChecksumIEEE(hello)),
+		file5Block("packed.bin", 0, 3, 100, []byte("xyzzy"), 0),
+	)
+	fsys, err := New(bytes.NewReader(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := fs.ReadFile(fsys, "dir/hello.txt")
+	if err != nil || !bytes.Equal(got, hello) {
+		t.Errorf("stored file: %q, %v", got, err)
+	}
+	if stat, err := fs.Stat(fsys, "packed.bin"); err != nil || stat.Size() != 100 {
+		t.Errorf("compressed file: %v, %v", stat, err)
+	}
+}
+
+func TestVolumes(t *testing.T) {
+	whole := []byte("the first part and the second part")
+	crc := crc32.ChecksumIEEE(whole)
+	for _, tc := range []struct {
+		name    string
+		volumes map[string][]byte
+	}{
+		{"set.rar", map[string][]byte{
+			"set.rar": archive4(main4Volume|main4FirstVolume, end4NextVolume,
+				file4Block("split.txt", file4SplitAfter, method4Store, whole[:15], 0)),
+			"set.r00": archive4(main4Volume, 0,
+				file4Block("split.txt", file4SplitBefore, method4Store, whole[15:], crc)),
+		}},
+		{"old.rar", map[string][]byte{ // before RAR 3.0: no first volume flag, no end block asking for the next
+			"old.rar": noEnd(archive4(main4Volume, 0,
+				file4Block("split.txt", file4SplitAfter, method4Store, whole[:15], 0))),
+			"old.r00": noEnd(archive4(main4Volume, 0,
+				file4Block("split.txt", file4SplitBefore, method4Store, whole[15:], crc))),
+		}},
+		{"set.part1.rar", map[string][]byte{
+			"set.part1.rar": archive5(main5Volume, end5NotLast,
+				file5Block("split.txt", head5Next, 0, uint64(len(whole)), whole[:15], 0)),
+			"set.part2.rar": archive5(main5Volume|main5VolumeNumber, 0,
+				file5Block("split.txt", head5Prev, 0, uint64(len(whole)), whole[15:], crc)),
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			open := func(name string) (io.ReaderAt, int64, error) {
+				v, ok := tc.volumes[name]
+				if !ok {
+					return nil, 0, os.ErrNotExist
+				}
+				return bytes.NewReader(v), int64(len(v)), nil
+			}
+			first := tc.volumes[tc.name]
+			for name, v := range tc.volumes {
+				if IsLaterVolume(bytes.NewReader(v), name) != (name != tc.name) {
+					t.Errorf("%s: wrong IsLaterVolume", name)
+				}
+			}
+			fsys, err := New2(bytes.NewReader(first), bytes.NewReader(first), tc.name, open)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := fs.ReadFile(fsys, "split.txt")
+			if err != nil || !bytes.Equal(got, whole) {
+				t.Errorf("split file: %q, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestIsLaterVolume(t *testing.T) {
+	unsplit := archive4(main4Volume, 0, file4Block("whole.txt", 0, method4Store, []byte("whole"), 0))
+	before := archive4(main4Volume, 0, file4Block("split.txt", file4SplitBefore, method4Store, []byte("part"), 0))
+	for _, tc := range []struct {
+		name  string
+		a     []byte
+		later bool
+	}{
+		{"set.rar", unsplit, false},
+		{"set.r00", unsplit, true},
+		{"set.part1.rar", unsplit, false},
+		{"set.part2.rar", unsplit, true},
+		{"renamed.bin", unsplit, false},
+		{"renamed.bin", before, true},
+		{"set.r00", archive4(0, 0), false}, // not a volume at all
+	} {
+		if got := IsLaterVolume(bytes.NewReader(tc.a), tc.name); got != tc.later {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.later)
+		}
+	}
+}
+
+func TestNextVolumeName(t *testing.T) {
+	for in, want := range map[string]string{
+		"a.part1.rar":  "a.part2.rar",
+		"a.part09.rar": "a.part10.rar",
+		"a.rar":        "a.r00",
+		"a.r41":        "a.r42",
+		"a.r99":        "a.s00",
+		"A.RAR":        "A.R00",
+	} {
+		if got := nextVolumeName(in, true); got != want {
+			t.Errorf("%s: got %s, want %s", in, got, want)
+		}
+	}
+	if got := nextVolumeName("a.part1.rar", false); got != "a.part1.r00" {
+		t.Errorf("old numbering of a new-looking name: got %s", got)
+	}
+}
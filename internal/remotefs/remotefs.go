@@ -0,0 +1,402 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+// Package remotefs presents the share point of another BeHierarchic server as an [fs.FS],
+// listing it from the server's recursive manifest and reading files with HTTP range requests.
+//
+// Archives are not descended into on the server side (the manifest lists them as files),
+// so the client mounts them itself and keeps the decompressed results in its own cache.
+// Symlinks are left out, because the manifest does not say where they point.
+//
+// The manifest of a big server takes a while to fetch, so it is not read until a file is first looked up,
+// and a fetch that takes longer than manifestTimeout is given up on (to be tried again at the next lookup).
+package remotefs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/elliotnunn/BeHierarchic/internal/fileid"
+)
+
+const maxManifestLine = 1 << 20
+
+// manifestTimeout bounds one fetch of the manifest, which the server streams as it walks the subtree
+var manifestTimeout = 10 * time.Minute
+
+type FS struct {
+	base   *url.URL
+	client *http.Client
+
+	fetchMu sync.Mutex // held for a whole fetch of the manifest, so that there is one at a time
+
+	mu   sync.RWMutex
+	tree map[string]*node // nil until the manifest is first read
+	etag string
+}
+
+type node struct {
+	name     string
+	isDir    bool
+	size     int64
+	mtime    time.Time
+	sha256   []byte
+	children []*node
+}
+
+var _ fileid.FS = (*FS)(nil)
+
+// New presents the server at base, which may include a path to serve a subtree.
+// It does not contact the server: the manifest is read at the first lookup.
+func New(base string, client *http.Client) (*FS, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("%s: not an HTTP URL", base)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/"
+	u.RawPath = ""
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &FS{base: u, client: client}, nil
+}
+
+// load reads the manifest if it has not been read yet
+func (fsys *FS) load() error {
+	fsys.mu.RLock()
+	loaded := fsys.tree != nil
+	fsys.mu.RUnlock()
+	if loaded {
+		return nil
+	}
+	fsys.fetchMu.Lock()
+	defer fsys.fetchMu.Unlock()
+	if fsys.tree != nil { // read by another lookup while this one waited
+		return nil
+	}
+	_, err := fsys.fetch()
+	return err
+}
+
+// Refresh re-reads the manifest if it has changed, returning the paths that were added, removed or changed
+func (fsys *FS) Refresh() (changed []string, err error) {
+	fsys.fetchMu.Lock()
+	defer fsys.fetchMu.Unlock()
+	return fsys.fetch()
+}
+
+// fetch must be called with fetchMu held
+func (fsys *FS) fetch() (changed []string, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), manifestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", fsys.url(".manifest"), nil)
+	if err != nil {
+		return nil, err
+	}
+	fsys.mu.RLock()
+	if fsys.tree != nil && fsys.etag != "" {
+		req.Header.Set("If-None-Match", fsys.etag)
+	}
+	fsys.mu.RUnlock()
+
+	resp, err := fsys.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil, nil
+	case http.StatusOK:
+	default:
+		return nil, fmt.Errorf("%s: %s", req.URL, resp.Status)
+	}
+
+	tree, err := parseManifest(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", req.URL, err)
+	}
+	fsys.mu.Lock()
+	old := fsys.tree
+	fsys.tree, fsys.etag = tree, resp.Header.Get("ETag")
+	fsys.mu.Unlock()
+
+	for name, n := range tree {
+		if o, ok := old[name]; !ok || !n.same(o) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range old {
+		if _, ok := tree[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	slices.Sort(changed)
+	return changed, nil
+}
+
+// same ignores the modtimes of directories, which change whenever their contents do
+func (n *node) same(o *node) bool {
+	if n.isDir && o.isDir {
+		return true
+	}
+	return n.isDir == o.isDir && n.size == o.size && n.mtime.Equal(o.mtime) && bytes.Equal(n.sha256, o.sha256)
+}
+
+// parseManifest builds the tree, tolerating a manifest that omits a file's parent directories
+func parseManifest(r io.Reader) (map[string]*node, error) {
+	type entry struct {
+		Path    string `json:"path"`
+		IsDir   bool   `json:"dir"`
+		Symlink bool   `json:"symlink"`
+		Size    int64  `json:"size"`
+		MTime   string `json:"mtime"`
+		SHA256  string `json:"sha256"`
+		Count   *int   `json:"count"`
+	}
+	tree := map[string]*node{".": {name: ".", isDir: true}}
+	var mkdirs func(name string) *node
+	mkdirs = func(name string) *node {
+		if n, ok := tree[name]; ok {
+			return n
+		}
+		n := &node{name: path.Base(name), isDir: true}
+		tree[name] = n
+		parent := mkdirs(path.Dir(name))
+		parent.children = append(parent.children, n)
+		return n
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, maxManifestLine)
+	complete := false
+	for sc.Scan() {
+		var e entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, err
+		}
+		if e.Count != nil {
+			complete = true
+			break
+		} else if e.Symlink || !fs.ValidPath(e.Path) || e.Path == "." {
+			continue
+		}
+		var n *node
+		if e.IsDir {
+			n = mkdirs(e.Path)
+		} else if _, ok := tree[e.Path]; ok {
+			continue
+		} else {
+			n = &node{name: path.Base(e.Path), size: e.Size}
+			n.sha256, _ = hex.DecodeString(e.SHA256)
+			tree[e.Path] = n
+			parent := mkdirs(path.Dir(e.Path))
+			parent.children = append(parent.children, n)
+		}
+		n.mtime, _ = time.Parse(time.RFC3339Nano, e.MTime)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	} else if !complete {
+		return nil, io.ErrUnexpectedEOF // the count line comes last
+	}
+	for _, n := range tree {
+		slices.SortFunc(n.children, func(a, b *node) int { return strings.Compare(a.name, b.name) })
+	}
+	return tree, nil
+}
+
+func (fsys *FS) url(name string) string {
+	segs := strings.Split(name, "/")
+	for i := range segs {
+		segs[i] = url.PathEscape(segs[i])
+	}
+	return fsys.base.String() + strings.Join(segs, "/")
+}
+
+func (fsys *FS) lookup(op, name string) (*node, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	} else if err := fsys.load(); err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	fsys.mu.RLock()
+	n, ok := fsys.tree[name]
+	fsys.mu.RUnlock()
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return n, nil
+}
+
+func (fsys *FS) Open(name string) (fs.File, error) {
+	n, err := fsys.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if n.isDir {
+		return &dir{node: n}, nil
+	}
+	return &file{fsys: fsys, name: name, node: n}, nil
+}
+
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	n, err := fsys.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// FileID is derived from the server's checksum, so that a file whose contents change is not mistaken
+// for the one that the cache remembers
+func (fsys *FS) FileID(name string) (fileid.ID, error) {
+	n, err := fsys.lookup("fileid", name)
+	if err != nil {
+		return fileid.ID{}, err
+	}
+	var id fileid.ID
+	if len(n.sha256) >= len(id) {
+		copy(id[:], n.sha256)
+		return id, nil
+	}
+	var h xxhash.Digest
+	h.WriteString(name)
+	binary.Write(&h, binary.BigEndian, n.size)
+	binary.Write(&h, binary.BigEndian, n.mtime.UnixNano())
+	binary.BigEndian.PutUint64(id[len(id)-8:], h.Sum64())
+	return id, nil
+}
+
+func (n *node) Name() string       { return n.name }
+func (n *node) Size() int64        { return n.size }
+func (n *node) ModTime() time.Time { return n.mtime }
+func (n *node) IsDir() bool        { return n.isDir }
+func (n *node) Sys() any           { return nil }
+func (n *node) Mode() fs.FileMode {
+	if n.isDir {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
+func (n *node) Type() fs.FileMode          { return n.Mode().Type() }
+func (n *node) Info() (fs.FileInfo, error) { return n, nil }
+
+type dir struct {
+	node *node
+	at   int
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) { return d.node, nil }
+func (d *dir) Read([]byte) (int, error)   { return 0, errors.New("is a directory") }
+func (d *dir) Close() error               { return nil }
+
+func (d *dir) ReadDir(count int) ([]fs.DirEntry, error) {
+	rest := d.node.children[d.at:]
+	if count > 0 {
+		if len(rest) == 0 {
+			return nil, io.EOF
+		}
+		rest = rest[:min(count, len(rest))]
+	}
+	d.at += len(rest)
+	ret := make([]fs.DirEntry, len(rest))
+	for i, n := range rest {
+		ret[i] = n
+	}
+	return ret, nil
+}
+
+type file struct {
+	fsys *FS
+	name string
+	node *node
+	seek int64
+}
+
+func (f *file) Stat() (fs.FileInfo, error) { return f.node, nil }
+func (f *file) Close() error               { return nil }
+
+func (f *file) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.seek)
+	f.seek += int64(n)
+	return n, err
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.seek
+	case io.SeekEnd:
+		offset += f.node.size
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	f.seek = offset
+	return offset, nil
+}
+
+// ReadAt makes one range request, which a server that ignores ranges answers with the whole file
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrInvalid}
+	} else if off >= f.node.size {
+		return 0, io.EOF
+	}
+	want := p[:min(int64(len(p)), f.node.size-off)]
+	if len(want) == 0 {
+		return 0, nil
+	}
+
+	req, err := http.NewRequest("GET", f.fsys.url(f.name), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+int64(len(want))-1))
+	resp, err := f.fsys.client.Do(req)
+	if err != nil {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: err}
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		if _, err := io.CopyN(io.Discard, resp.Body, off); err != nil {
+			return 0, &fs.PathError{Op: "read", Path: f.name, Err: err}
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		return 0, io.EOF // shorter than the manifest said
+	default:
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: errors.New(resp.Status)}
+	}
+
+	n, err := io.ReadFull(resp.Body, want)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	} else if err == nil && len(want) < len(p) {
+		err = io.EOF
+	}
+	if err != nil && err != io.EOF {
+		err = &fs.PathError{Op: "read", Path: f.name, Err: err}
+	}
+	return n, err
+}
@@ -0,0 +1,152 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package remotefs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var content = []byte("The quick brown fox jumps over the lazy dog")
+
+// server imitates the manifest and the WebDAV GETs of a BeHierarchic server
+func server(t *testing.T, manifest *string) *httptest.Server {
+	mtime := time.Date(1999, 12, 31, 0, 0, 0, 0, time.UTC)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/share/.manifest":
+			etag := fmt.Sprintf(`W/"%x"`, sha256.Sum256([]byte(*manifest)))
+			w.Header().Set("ETag", etag)
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			io.WriteString(w, *manifest)
+		case "/share/dir/fox file.txt":
+			http.ServeContent(w, r, "", mtime, bytes.NewReader(content))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func manifestOf(lines ...string) string {
+	return strings.Join(lines, "\n") + fmt.Sprintf("\n{\"count\":%d}\n", len(lines))
+}
+
+func TestFS(t *testing.T) {
+	sum := sha256.Sum256(content)
+	manifest := manifestOf(
+		`{"path":"dir","dir":true,"mtime":"1999-12-31T00:00:00Z"}`,
+		fmt.Sprintf(`{"path":"dir/fox file.txt","size":%d,"mtime":"1999-12-31T00:00:00Z","sha256":"%x"}`, len(content), sum),
+		`{"path":"implied/empty.txt","size":0,"mtime":"1999-12-31T00:00:00Z"}`,
+		`{"path":"link","symlink":true,"mtime":"1999-12-31T00:00:00Z"}`,
+	)
+	srv := server(t, &manifest)
+	fsys, err := New(srv.URL+"/share", srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(fsys, "dir/fox file.txt", "implied/empty.txt"); err != nil {
+		t.Error(err)
+	}
+	if _, err := fs.Stat(fsys, "link"); err == nil {
+		t.Error("expected the symlink to be left out")
+	}
+
+	f, _ := fsys.Open("dir/fox file.txt")
+	p := make([]byte, 5)
+	n, err := f.(io.ReaderAt).ReadAt(p, 16)
+	if n != 5 || err != nil || string(p) != "fox j" {
+		t.Errorf("ReadAt got %d %v %q", n, err, p[:n])
+	}
+	n, err = f.(io.ReaderAt).ReadAt(p, int64(len(content))-3)
+	if n != 3 || err != io.EOF || string(p[:n]) != "dog" {
+		t.Errorf("ReadAt at the end got %d %v %q", n, err, p[:n])
+	}
+
+	id, err := fsys.FileID("dir/fox file.txt")
+	if err != nil || hex.EncodeToString(id[:]) != hex.EncodeToString(sum[:len(id)]) {
+		t.Errorf("expected the FileID to come from the checksum, got %x %v", id, err)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	manifest := manifestOf(`{"path":"a","size":1,"mtime":"1999-12-31T00:00:00Z"}`)
+	srv := server(t, &manifest)
+	fsys, err := New(srv.URL+"/share/", srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat(fsys, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := fsys.Refresh(); changed != nil || err != nil {
+		t.Errorf("expected an unchanged manifest, got %v %v", changed, err)
+	}
+	manifest = manifestOf(`{"path":"b","size":1,"mtime":"1999-12-31T00:00:00Z"}`)
+	if changed, err := fsys.Refresh(); !slices.Equal(changed, []string{"a", "b"}) || err != nil {
+		t.Errorf("expected a and b to have changed, got %v %v", changed, err)
+	}
+	if _, err := fs.Stat(fsys, "a"); err == nil {
+		t.Error("expected a to be gone")
+	} else if _, err := fs.Stat(fsys, "b"); err != nil {
+		t.Error("expected b to be present")
+	}
+}
+
+func TestTruncatedManifest(t *testing.T) {
+	manifest := `{"path":"a","size":1,"mtime":"1999-12-31T00:00:00Z"}` + "\n"
+	srv := server(t, &manifest)
+	fsys, err := New(srv.URL+"/share", srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat(fsys, "a"); err == nil || errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected an error for a manifest without its count line, got %v", err)
+	}
+}
+
+func TestLazyManifest(t *testing.T) {
+	defer func(d time.Duration) { manifestTimeout = d }(manifestTimeout)
+	manifestTimeout = 100 * time.Millisecond
+
+	var fetches int
+	hang := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		select {
+		case <-hang:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(hang)
+
+	fsys, err := New(srv.URL+"/share", srv.Client())
+	if err != nil || fetches != 0 {
+		t.Fatalf("expected New not to fetch the manifest, got %d fetches and %v", fetches, err)
+	}
+	start := time.Now()
+	if _, err := fs.Stat(fsys, "a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a slow manifest to time out, got %v", err)
+	} else if time.Since(start) > 10*manifestTimeout {
+		t.Errorf("the timeout took %v", time.Since(start))
+	}
+}
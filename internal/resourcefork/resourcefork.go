@@ -4,6 +4,7 @@
 package resourcefork
 
 import (
+	"bytes"
 	"cmp"
 	"encoding/binary"
 	"errors"
@@ -14,18 +15,34 @@ import (
 	"time"
 
 	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
+	"github.com/elliotnunn/BeHierarchic/internal/guard"
+	"github.com/elliotnunn/BeHierarchic/internal/macfont"
 	"github.com/elliotnunn/BeHierarchic/internal/sectionreader"
 )
 
 var ErrFormat = errors.New("not a valid resource fork")
 
+// IsResourceFork checks for the header of a bare resource fork,
+// whose data starts at offset 256 and is followed by a map big enough for its own header
+func IsResourceFork(head []byte) bool {
+	if len(head) < 16 {
+		return false
+	}
+	be := binary.BigEndian
+	dataOffset, mapOffset := be.Uint32(head), be.Uint32(head[4:])
+	dataSize, mapSize := be.Uint32(head[8:]), be.Uint32(head[12:])
+	return dataOffset == 256 && uint64(mapOffset) >= uint64(dataOffset)+uint64(dataSize) &&
+		mapSize >= 30 && mapSize < 1<<24
+}
+
 // New opens a resource fork
 func New(r io.ReaderAt) (fs.FS, error) {
 	return New2(r, r)
 }
 
 // New2 routes headers and data requests through different readers, to help exotic caching schemes
-func New2(headerReader, dataReader io.ReaderAt) (fs.FS, error) {
+func New2(headerReader, dataReader io.ReaderAt) (retfs fs.FS, reterr error) {
+	defer guard.Recover(&reterr, "resource fork", nil)
 	forkOffset := resourceForkOffset(headerReader) // AppleDouble
 
 	var rfHeader [16]byte
@@ -116,7 +133,11 @@ func New2(headerReader, dataReader io.ReaderAt) (fs.FS, error) {
 			path3 = path1 + "/named/" + filenameFrom(r.ne[1:][:nlen])
 		}
 
-		fsys.CreateReaderAt(path2, r.offset, sectionreader.Section(dataReader, r.offset, size), size, 0, time.Time{})
+		section := sectionreader.Section(dataReader, r.offset, size)
+		fsys.CreateReaderAt(path2, r.offset, section, size, 0, time.Time{})
+		if typ := string(r.te[:4]); macfont.IsFont(typ) {
+			fsys.CreateReader(path2+".png", -r.offset, fontPreview(typ, section, size), fskeleton.SizeUnknown, 0, time.Time{})
+		}
 		if path3 != "" {
 			fsys.Symlink(path3, 0, path2, 0, time.Time{})
 		}
@@ -124,6 +145,21 @@ func New2(headerReader, dataReader io.ReaderAt) (fs.FS, error) {
 	return fsys, nil
 }
 
+// fontPreview renders a specimen sheet when the file is opened, not when the fork is listed
+func fontPreview(typ string, r io.ReaderAt, size int64) func() (io.Reader, error) {
+	return func() (io.Reader, error) {
+		data := make([]byte, size)
+		if n, err := r.ReadAt(data, 0); n != len(data) {
+			return nil, err
+		}
+		img, err := guard.Call("font", func() ([]byte, error) { return macfont.PNG(typ, data) })
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(img), nil
+	}
+}
+
 func resourceForkOffset(r io.ReaderAt) int64 {
 	header := make([]byte, 3)
 	n, _ := r.ReadAt(header, 0)
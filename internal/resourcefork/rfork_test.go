@@ -78,3 +78,14 @@ func TestNamed(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestIsResourceFork(t *testing.T) {
+	for name, rf := range map[string][]byte{"large": large, "empty": empty, "named": named} {
+		if !IsResourceFork(rf) {
+			t.Errorf("%s.rsrc not recognised", name)
+		}
+	}
+	if IsResourceFork(make([]byte, 16)) {
+		t.Error("zeros mistaken for a resource fork")
+	}
+}
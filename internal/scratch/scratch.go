@@ -0,0 +1,221 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+// Package scratch hands out temporary files for decompressed data too large to hold in memory,
+// all in one directory and within one size budget, so that no feature needs its own temp-file handling.
+//
+// Space is reserved up front, when the file is created, so that a feature can fall back
+// to streaming instead of failing halfway through. Files are deleted when closed,
+// and on systems that allow it they are unlinked as soon as they are created,
+// so that not even a crash leaves them behind.
+// Directories for other programs to fill are the exception, lasting until they are closed.
+// A Meter is for data of unknown size kept elsewhere, such as uploads and the results of jobs.
+package scratch
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+)
+
+var (
+	ErrFull  = errors.New("scratch space budget exhausted")
+	ErrRange = errors.New("write outside the reserved scratch space")
+)
+
+type Space struct {
+	dir   string
+	limit int64
+
+	mu   sync.Mutex
+	used int64
+}
+
+// New prepares a directory of scratch files with a total size limit
+func New(dir string, limit int64) (*Space, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+	return &Space{dir: dir, limit: limit}, nil
+}
+
+// Used is the total of the sizes reserved by the open files
+func (s *Space) Used() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.used
+}
+
+// Limit is the total size allowed at once
+func (s *Space) Limit() int64 { return s.limit }
+
+// Reserve takes size bytes from the budget for data kept somewhere else while it is written,
+// such as an upload on its way into the sharepoint, or fails with [ErrFull].
+// The bytes go back with [Space.Release].
+func (s *Space) Reserve(size int64) error {
+	if size < 0 {
+		return fmt.Errorf("scratch: negative size %d", size)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if size > s.limit-s.used {
+		return ErrFull
+	}
+	s.used += size
+	return nil
+}
+
+// Create reserves size bytes in a new empty file, or fails with [ErrFull]
+func (s *Space) Create(size int64) (*File, error) {
+	if err := s.Reserve(size); err != nil {
+		return nil, err
+	}
+
+	f, err := os.CreateTemp(s.dir, "*.tmp")
+	if err != nil {
+		s.Release(size)
+		return nil, err
+	}
+	if runtime.GOOS != "windows" { // which cannot delete an open file
+		os.Remove(f.Name())
+	}
+	return &File{f: f, s: s, size: size}, nil
+}
+
+// Release returns bytes taken by [Space.Reserve]
+func (s *Space) Release(size int64) {
+	s.mu.Lock()
+	s.used -= size
+	s.mu.Unlock()
+}
+
+// A Meter counts data written somewhere else against the budget, a step at a time as it arrives,
+// so that it can grow to a size not known in advance, but not past the budget
+type Meter struct {
+	s                 *Space
+	w                 io.Writer
+	mu                sync.Mutex
+	reserved, written int64
+}
+
+// meterStep is how much of the budget a Meter takes at a time
+const meterStep = 1 << 20
+
+// Meter wraps w, whose writes fail with [ErrFull] once the budget is exhausted
+func (s *Space) Meter(w io.Writer) *Meter {
+	return &Meter{s: s, w: w}
+}
+
+func (m *Meter) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for m.written+int64(len(p)) > m.reserved {
+		step := max(meterStep, int64(len(p)))
+		if err := m.s.Reserve(step); err != nil {
+			return 0, err
+		}
+		m.reserved += step
+	}
+	n, err := m.w.Write(p)
+	m.written += int64(n)
+	return n, err
+}
+
+// Close returns the space to the budget, without closing the writer
+func (m *Meter) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.s.Release(m.reserved)
+	m.reserved = 0
+	return nil
+}
+
+// File is a scratch file of fixed size, which can only be written within that size
+type File struct {
+	f    *os.File
+	s    *Space
+	size int64
+	once sync.Once
+}
+
+func (f *File) Size() int64 { return f.size }
+
+func (f *File) ReadAt(p []byte, off int64) (int, error) { return f.f.ReadAt(p, off) }
+
+func (f *File) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 || int64(len(p)) > f.size-off {
+		return 0, ErrRange
+	}
+	return f.f.WriteAt(p, off)
+}
+
+// Close deletes the file and returns its space to the budget
+func (f *File) Close() (err error) {
+	f.once.Do(func() {
+		err = f.f.Close()
+		os.Remove(f.f.Name()) // already gone, except on Windows
+		f.s.Release(f.size)
+	})
+	return err
+}
+
+// Dir is a scratch directory for another program to fill, which cannot be unlinked early,
+// so it is only deleted when closed
+type Dir struct {
+	path string
+	s    *Space
+	mu   sync.Mutex
+	size int64
+}
+
+// MkdirTemp makes an empty directory, whose contents count against the budget once they are reserved
+func (s *Space) MkdirTemp() (*Dir, error) {
+	path, err := os.MkdirTemp(s.dir, "*.dir")
+	if err != nil {
+		return nil, err
+	}
+	return &Dir{path: path, s: s}, nil
+}
+
+func (d *Dir) Path() string { return d.path }
+
+// Reserve adds size bytes to the directory's share of the budget, or fails with [ErrFull]
+func (d *Dir) Reserve(size int64) error {
+	if size < 0 {
+		return fmt.Errorf("scratch: negative size %d", size)
+	}
+	d.s.mu.Lock()
+	defer d.s.mu.Unlock()
+	if size > d.s.limit-d.s.used {
+		return ErrFull
+	}
+	d.s.used += size
+	d.mu.Lock()
+	d.size += size
+	d.mu.Unlock()
+	return nil
+}
+
+// Release gives back size bytes of the directory's share, once it is known to need less than it reserved
+func (d *Dir) Release(size int64) {
+	d.mu.Lock()
+	size = min(size, d.size)
+	d.size -= size
+	d.mu.Unlock()
+	d.s.Release(size)
+}
+
+// Close deletes the directory and everything in it, and returns its space to the budget
+func (d *Dir) Close() error {
+	err := os.RemoveAll(d.path)
+	d.mu.Lock()
+	size := d.size
+	d.size = 0
+	d.mu.Unlock()
+	d.s.Release(size)
+	return err
+}
@@ -0,0 +1,146 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package scratch
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBudget(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := s.Create(60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create(41); err != ErrFull {
+		t.Errorf("over budget: got %v, want ErrFull", err)
+	}
+	b, err := s.Create(40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Used() != 100 {
+		t.Errorf("used %d, want 100", s.Used())
+	}
+	a.Close()
+	a.Close() // harmless
+	b.Close()
+	if s.Used() != 0 {
+		t.Errorf("used %d after closing, want 0", s.Used())
+	}
+	if list, _ := os.ReadDir(dir); len(list) != 0 {
+		t.Errorf("left %d files behind", len(list))
+	}
+}
+
+func TestReserve(t *testing.T) {
+	s, err := New(t.TempDir(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reserve(80); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create(21); err != ErrFull {
+		t.Errorf("file over the reservation: got %v, want ErrFull", err)
+	}
+	if err := s.Reserve(21); err != ErrFull {
+		t.Errorf("over budget: got %v, want ErrFull", err)
+	}
+	s.Release(80)
+	if s.Used() != 0 {
+		t.Errorf("used %d after releasing, want 0", s.Used())
+	}
+}
+
+func TestMeter(t *testing.T) {
+	s, err := New(t.TempDir(), 3*meterStep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := s.Meter(io.Discard)
+	if _, err := m.Write(make([]byte, meterStep+1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Write(make([]byte, meterStep)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Write(make([]byte, meterStep)); err != ErrFull {
+		t.Errorf("over budget: got %v, want ErrFull", err)
+	}
+	m.Close()
+	m.Close() // harmless
+	if s.Used() != 0 {
+		t.Errorf("used %d after closing, want 0", s.Used())
+	}
+}
+
+func TestDir(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := s.MkdirTemp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(d.Path(), "x"), []byte("hello"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Reserve(70); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Reserve(31); err != ErrFull {
+		t.Errorf("over budget: got %v, want ErrFull", err)
+	}
+	if s.Used() != 70 {
+		t.Errorf("used %d, want 70", s.Used())
+	}
+	d.Release(65) // it only needed the 5 it has
+	if s.Used() != 5 {
+		t.Errorf("used %d after releasing, want 5", s.Used())
+	}
+	d.Release(10) // no more than it had
+	if s.Used() != 0 {
+		t.Errorf("used %d after releasing, want 0", s.Used())
+	}
+	d.Close()
+	d.Close() // harmless
+	if s.Used() != 0 {
+		t.Errorf("used %d after closing, want 0", s.Used())
+	}
+	if list, _ := os.ReadDir(dir); len(list) != 0 {
+		t.Errorf("left %d files behind", len(list))
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	s, err := New(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := s.Create(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := io.NewOffsetWriter(f, 0).Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("!"), 5); err != ErrRange {
+		t.Errorf("write past the end: got %v, want ErrRange", err)
+	}
+	got, err := io.ReadAll(io.NewSectionReader(f, 0, f.Size()))
+	if string(got) != "hello" || err != nil {
+		t.Errorf("got %q, %v", got, err)
+	}
+}
@@ -15,7 +15,7 @@ func Section(r io.ReaderAt, off int64, n int64) *ReaderAt {
 			break
 		}
 		outer, outerOff, outerN := t.Outer()
-		if off+n > outerN {
+		if off < 0 || n < 0 || n > outerN-off { // written to avoid overflow
 			break
 		}
 		r, off = outer, off+outerOff
@@ -75,3 +75,12 @@ func expectRead(t *testing.T, r io.ReaderAt, off int64, n int, expect string) {
 		t.Errorf("ReadAt(%d bytes at offset %d) -> expected %q got %q", n, off, expect, gots)
 	}
 }
+
+func TestUnwrapOverflow(t *testing.T) {
+	var abcd io.ReaderAt = strings.NewReader("abcd")
+	r := Section(io.NewSectionReader(abcd, 0, 3), 1, math.MaxInt64)
+	expectRead(t, r, 0, 4, "bc EOF") // must not escape the outer section
+	if unwrap, _, _ := r.Outer(); unwrap == abcd {
+		t.Error("unwrapped a section that extends past the outer section")
+	}
+}
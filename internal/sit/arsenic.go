@@ -35,6 +35,8 @@ import (
 	"fmt"
 	"io"
 	"math/bits"
+
+	"github.com/elliotnunn/BeHierarchic/internal/guard"
 )
 
 const (
@@ -350,14 +352,12 @@ func arseniccopy(dst *io.PipeWriter, src io.Reader, dstsize uint32) {
 	sa.br = bufio.NewReaderSize(src, 4096)
 	sa.bw = bufio.NewWriterSize(dst, 4096)
 
+	var reterr error
 	defer func() {
 		sa.bw.Flush()
-		if r := recover(); r != nil {
-			dst.CloseWithError(fmt.Errorf("internal StuffIt/Arsenic panic: %v", r))
-		} else {
-			dst.Close()
-		}
+		dst.CloseWithError(reterr)
 	}()
+	defer guard.Recover(&reterr, "StuffIt Arsenic", nil)
 
 	sa.Range = 1 << 25
 	sa.bitbuf = FillBigEndian(InitialBigEndian, sa.br)
@@ -3,6 +3,9 @@ package sit
 import (
 	"encoding/binary"
 	"io"
+
+	"github.com/elliotnunn/BeHierarchic/internal/checksumreader"
+	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
 )
 
 var crctab [256]uint16
@@ -21,32 +24,49 @@ func init() {
 	}
 }
 
-type crc16reader struct {
-	r         io.ReadCloser
-	len       int64
-	want, got uint16
+// crc16 is CRC-16/ARC as a [hash.Hash], for [checksumreader]
+type crc16 uint16
+
+func (c *crc16) Write(p []byte) (int, error) {
+	check := uint16(*c)
+	for _, ch := range p {
+		check = crctab[byte(check)^ch] ^ check>>8
+	}
+	*c = crc16(check)
+	return len(p), nil
 }
 
-func (r *crc16reader) Read(p []byte) (n int, err error) {
-	n, err = r.r.Read(p)
-	r.update(p[:n])
+func (c *crc16) Sum(b []byte) []byte { return binary.BigEndian.AppendUint16(b, uint16(*c)) }
+func (c *crc16) Reset()              { *c = 0 }
+func (c *crc16) Size() int           { return 2 }
+func (c *crc16) BlockSize() int      { return 1 }
 
-	if r.len == 0 && r.got != r.want {
-		err = ErrChecksum
+// checked verifies a fork against its CRC, telling sum (if not nil) when it matches
+func checked(r io.Reader, unpacksz uint32, cksum uint16, sum *fskeleton.Checksum) io.ReadCloser {
+	var matched func()
+	if sum != nil {
+		matched = sum.Verified
 	}
-	return
+	return checksumreader.New(r, int64(unpacksz), new(crc16), binary.BigEndian.AppendUint16(nil, cksum), matched)
 }
 
-func (r *crc16reader) update(buffer []byte) {
-	check := r.got
-	for _, ch := range buffer {
-		check = crctab[byte(check)^ch] ^ check>>8
+// checkedAt is [checked] for a stored fork, which is checked only if it is read from start to finish
+func checkedAt(r io.ReaderAt, unpacksz uint32, cksum uint16, sum *fskeleton.Checksum) io.ReaderAt {
+	var matched func()
+	if sum != nil {
+		matched = sum.Verified
 	}
-	r.got = check
-	r.len -= int64(len(buffer))
+	return checksumreader.NewAt(r, int64(unpacksz), new(crc16), binary.BigEndian.AppendUint16(nil, cksum), matched)
 }
 
-func (r *crc16reader) Close() error { return r.r.Close() }
+// checksum records the CRC of a fork for [fskeleton.FS.SetChecksum],
+// except that Arsenic checks its own stream and leaves the field meaningless
+func checksum(algo AlgID, cksum uint16) *fskeleton.Checksum {
+	if algo == 15 {
+		return nil
+	}
+	return &fskeleton.Checksum{Algo: "crc16-arc", Sum: binary.BigEndian.AppendUint16(nil, cksum)}
+}
 
 func checkCRC16(buf []byte, crcField int) bool {
 	want := binary.BigEndian.Uint16(buf[crcField:])
@@ -11,6 +11,7 @@ import (
 
 	"github.com/elliotnunn/BeHierarchic/internal/appledouble"
 	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
+	"github.com/elliotnunn/BeHierarchic/internal/guard"
 	"github.com/elliotnunn/BeHierarchic/internal/sectionreader"
 )
 
@@ -81,6 +82,7 @@ func (f *file) Next() int64 {
 
 func newFormat(fsys *fskeleton.FS, headerReader, dataReader io.ReaderAt, offset, filesize int64) {
 	defer fsys.NoMore()
+	defer guard.Log("StuffIt", &offset)
 	var (
 		pass2 []file
 		known = make(map[int64]file)
@@ -136,14 +138,15 @@ func addToFS(fsys *fskeleton.FS, f file, dataReader io.ReaderAt, known map[int64
 		rOffset := f.HeaderEnd
 		if macstuff.Rsrc.Algo == 0 && f.RCrypt == "" {
 			adfile, adsize := meta.WithResourceFork(
-				sectionreader.Section(dataReader, rOffset, int64(macstuff.Rsrc.Unpacked)),
+				checkedAt(sectionreader.Section(dataReader, rOffset, int64(macstuff.Rsrc.Unpacked)),
+					macstuff.Rsrc.Unpacked, macstuff.Rsrc.CRC, nil),
 				int64(macstuff.Rsrc.Unpacked))
 			fsys.CreateReaderAt(appledouble.Sidecar(name),
 				fileID(f.Offset, true),
 				adfile, adsize, 0, meta.ModTime)
 		} else {
 			adfile, adsize := meta.WithSequentialResourceFork(func() (io.ReadCloser, error) {
-				return readerFor(macstuff.Rsrc.Algo, f.RCrypt, macstuff.Rsrc.Unpacked, macstuff.Rsrc.CRC,
+				return readerFor(macstuff.Rsrc.Algo, f.RCrypt, macstuff.Rsrc.Unpacked, macstuff.Rsrc.CRC, nil,
 					io.NewSectionReader(dataReader, rOffset, int64(macstuff.Rsrc.Packed)))
 			}, int64(macstuff.Rsrc.Unpacked))
 			fsys.CreateReadCloser(appledouble.Sidecar(name),
@@ -152,20 +155,28 @@ func addToFS(fsys *fskeleton.FS, f file, dataReader io.ReaderAt, known map[int64
 		}
 
 		dOffset := f.HeaderEnd + int64(macstuff.Rsrc.Packed)
+		var sum *fskeleton.Checksum
+		if f.DCrypt == "" {
+			sum = checksum(f.Common.Data.Algo, f.Common.Data.CRC)
+		}
 		if f.Common.Data.Algo == 0 && f.DCrypt == "" {
 			fsys.CreateReaderAt(name,
 				fileID(f.Offset, false),
-				sectionreader.Section(dataReader, dOffset, int64(f.Common.Data.Unpacked)), // readerAt
+				checkedAt(sectionreader.Section(dataReader, dOffset, int64(f.Common.Data.Unpacked)),
+					f.Common.Data.Unpacked, f.Common.Data.CRC, sum), // readerAt
 				int64(f.Common.Data.Unpacked), 0, meta.ModTime)
 		} else {
 			fsys.CreateReadCloser(name,
 				fileID(f.Offset, false),
 				func() (io.ReadCloser, error) {
-					return readerFor(f.Common.Data.Algo, f.DCrypt, f.Common.Data.Unpacked, f.Common.Data.CRC,
+					return readerFor(f.Common.Data.Algo, f.DCrypt, f.Common.Data.Unpacked, f.Common.Data.CRC, sum,
 						io.NewSectionReader(dataReader, dOffset, int64(f.Common.Data.Packed)))
 				}, // reader
 				int64(f.Common.Data.Unpacked), 0, meta.ModTime)
 		}
+		if sum != nil {
+			fsys.SetChecksum(name, sum)
+		}
 	}
 
 	return true
@@ -10,6 +10,7 @@ import (
 
 	"github.com/elliotnunn/BeHierarchic/internal/appledouble"
 	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
+	"github.com/elliotnunn/BeHierarchic/internal/guard"
 	"github.com/elliotnunn/BeHierarchic/internal/sectionreader"
 )
 
@@ -44,6 +45,7 @@ func (id AlgID) isDirEnd() bool   { return id == 33 }
 
 func oldFormat(fsys *fskeleton.FS, headerReader, dataReader io.ReaderAt, offset, filesize int64) {
 	defer fsys.NoMore()
+	defer guard.Log("StuffIt", &offset)
 	type forlater struct {
 		offset int64
 		hdr    *header
@@ -89,14 +91,16 @@ func oldFormat(fsys *fskeleton.FS, headerReader, dataReader io.ReaderAt, offset,
 			copy(meta.Creator[:], hdr.FinderInfo[4:])
 			rOffset := int64(offset + 112)
 			if hdr.RAlgo == 0 {
-				adfile, adsize := meta.WithResourceFork(io.NewSectionReader(dataReader, rOffset, int64(hdr.RUnpackLen)), int64(hdr.RUnpackLen))
+				adfile, adsize := meta.WithResourceFork(
+					checkedAt(io.NewSectionReader(dataReader, rOffset, int64(hdr.RUnpackLen)), hdr.RUnpackLen, hdr.RCRC, nil),
+					int64(hdr.RUnpackLen))
 				fsys.CreateReaderAt(appledouble.Sidecar(name),
 					fileID(offset, true),
 					adfile, adsize, 0, meta.ModTime)
 			} else {
 				adfile, adsize := meta.WithSequentialResourceFork(func() (io.ReadCloser, error) {
 					raw := io.NewSectionReader(dataReader, rOffset, int64(hdr.RPackLen))
-					return readerFor(hdr.RAlgo, "", hdr.RUnpackLen, hdr.RCRC, raw)
+					return readerFor(hdr.RAlgo, "", hdr.RUnpackLen, hdr.RCRC, nil, raw)
 				}, int64(hdr.RUnpackLen))
 				fsys.CreateReadCloser(appledouble.Sidecar(name),
 					fileID(offset, true),
@@ -104,20 +108,24 @@ func oldFormat(fsys *fskeleton.FS, headerReader, dataReader io.ReaderAt, offset,
 			}
 
 			dOffset := offset + 112 + int64(hdr.RPackLen)
+			sum := checksum(hdr.DAlgo, hdr.DCRC)
 			if hdr.DAlgo == 0 {
 				fsys.CreateReaderAt(name,
 					fileID(offset, false),
-					sectionreader.Section(dataReader, dOffset, int64(hdr.DUnpackLen)), // readerAt
+					checkedAt(sectionreader.Section(dataReader, dOffset, int64(hdr.DUnpackLen)), hdr.DUnpackLen, hdr.DCRC, sum), // readerAt
 					int64(hdr.DUnpackLen), 0, meta.ModTime)
 			} else {
 				fsys.CreateReadCloser(name,
 					fileID(offset, false),
 					func() (io.ReadCloser, error) {
 						raw := io.NewSectionReader(dataReader, dOffset, int64(hdr.DPackLen))
-						return readerFor(hdr.DAlgo, "", hdr.DUnpackLen, hdr.DCRC, raw)
+						return readerFor(hdr.DAlgo, "", hdr.DUnpackLen, hdr.DCRC, sum, raw)
 					}, // reader
 					int64(hdr.DUnpackLen), 0, meta.ModTime)
 			}
+			if sum != nil {
+				fsys.SetChecksum(name, sum)
+			}
 		}
 	}
 
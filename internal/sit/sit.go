@@ -20,14 +20,16 @@ import (
 	"io/fs"
 	"slices"
 
+	"github.com/elliotnunn/BeHierarchic/internal/checksumreader"
 	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
+	"github.com/elliotnunn/BeHierarchic/internal/guard"
 )
 
 var (
 	ErrHeader   = errors.New("StuffIt: invalid header")
 	ErrPassword = errors.New("StuffIt: password protected file")
 	ErrAlgo     = errors.New("StuffIt: unimplemented compression algorithm")
-	ErrChecksum = errors.New("StuffIt: invalid checksum")
+	ErrChecksum = checksumreader.ErrChecksum
 )
 
 // New opens a StuffIt file
@@ -36,7 +38,8 @@ func New(r io.ReaderAt) (fs.FS, error) {
 }
 
 // New2 routes headers and data requests through different readers, to help exotic caching schemes
-func New2(headerReader, dataReader io.ReaderAt) (fs.FS, error) {
+func New2(headerReader, dataReader io.ReaderAt) (retfs fs.FS, reterr error) {
+	defer guard.Recover(&reterr, "StuffIt", nil)
 	var (
 		buf []byte
 		err error
@@ -118,7 +121,7 @@ func creepBy(buf []byte, reader io.Reader, by int) ([]byte, error) {
 	}
 }
 
-func readerFor(algo AlgID, crypto string, unpacksz uint32, cksum uint16, r io.Reader) (io.ReadCloser, error) {
+func readerFor(algo AlgID, crypto string, unpacksz uint32, cksum uint16, sum *fskeleton.Checksum, r io.Reader) (io.ReadCloser, error) {
 	if crypto != "" {
 		return nil, ErrPassword
 	}
@@ -126,17 +129,17 @@ func readerFor(algo AlgID, crypto string, unpacksz uint32, cksum uint16, r io.Re
 	// corpus includes algo 0, 2, 3, 5, 13, 15
 	switch algo {
 	case 0: // no compression
-		return &crc16reader{r: io.NopCloser(r), len: int64(unpacksz), want: cksum}, nil
+		return checked(r, unpacksz, cksum, sum), nil
 	// case 1: // RLE compression
 	case 2: // LZC compression
-		return &crc16reader{r: lzc(r, unpacksz), len: int64(unpacksz), want: cksum}, nil
+		return checked(lzc(r, unpacksz), unpacksz, cksum, sum), nil
 	case 3: // Huffman compression
-		return &crc16reader{r: huffman(r, unpacksz), len: int64(unpacksz), want: cksum}, nil
+		return checked(huffman(r, unpacksz), unpacksz, cksum, sum), nil
 	// case 5: // LZ with adaptive Huffman
 	// case 6: // Fixed Huffman table
 	// case 8: // Miller-Wegman encoding
 	case 13: // anonymous
-		return &crc16reader{r: sit13(r, unpacksz), len: int64(unpacksz), want: cksum}, nil
+		return checked(sit13(r, unpacksz), unpacksz, cksum, sum), nil
 	// case 14: // anonymous
 	case 15: // Arsenic
 		return arsenic(r, unpacksz), nil // has its own internal checksum
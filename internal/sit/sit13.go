@@ -34,6 +34,8 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+
+	"github.com/elliotnunn/BeHierarchic/internal/guard"
 )
 
 type SIT13Buffer struct {
@@ -404,13 +406,9 @@ func sit13(r io.Reader, dstsize uint32) io.ReadCloser {
 }
 
 func sit13copy(dst *io.PipeWriter, src io.Reader, dstsize uint32) {
-	defer func() {
-		if r := recover(); r != nil {
-			dst.CloseWithError(fmt.Errorf("internal StuffIt/SIT13 panic: %v", r))
-		} else {
-			dst.Close()
-		}
-	}()
+	var reterr error
+	defer func() { dst.CloseWithError(reterr) }()
+	defer guard.Recover(&reterr, "StuffIt SIT13", nil)
 
 	var s SIT13Data
 	s.br = bufio.NewReaderSize(src, 4096)
@@ -0,0 +1,49 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package spinner
+
+import "sync/atomic"
+
+// Counters are cumulative since startup, so take the difference across an interval of interest
+type Counters struct {
+	BlockHits, BlockMisses   int64 // cache of decompressed blocks
+	ReaderHits, ReaderMisses int64 // cache of open decompressors
+	BytesDecompressed        int64
+	GroupEvictions           int64 // readers closed to keep a group within its share
+	LowerHits, Promotions    int64 // reads answered by the lower tier, and blocks written to it
+}
+
+var counters struct {
+	blockHits, blockMisses   atomic.Int64
+	readerHits, readerMisses atomic.Int64
+	bytesDecompressed        atomic.Int64
+	groupEvictions           atomic.Int64
+	lowerHits, promotions    atomic.Int64
+}
+
+func ReadCounters() Counters {
+	return Counters{
+		BlockHits:         counters.blockHits.Load(),
+		BlockMisses:       counters.blockMisses.Load(),
+		ReaderHits:        counters.readerHits.Load(),
+		ReaderMisses:      counters.readerMisses.Load(),
+		BytesDecompressed: counters.bytesDecompressed.Load(),
+		GroupEvictions:    counters.groupEvictions.Load(),
+		LowerHits:         counters.lowerHits.Load(),
+		Promotions:        counters.promotions.Load(),
+	}
+}
+
+func (c Counters) Sub(d Counters) Counters {
+	return Counters{
+		BlockHits:         c.BlockHits - d.BlockHits,
+		BlockMisses:       c.BlockMisses - d.BlockMisses,
+		ReaderHits:        c.ReaderHits - d.ReaderHits,
+		ReaderMisses:      c.ReaderMisses - d.ReaderMisses,
+		BytesDecompressed: c.BytesDecompressed - d.BytesDecompressed,
+		GroupEvictions:    c.GroupEvictions - d.GroupEvictions,
+		LowerHits:         c.LowerHits - d.LowerHits,
+		Promotions:        c.Promotions - d.Promotions,
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"io"
 	"io/fs"
 	"log/slog"
+	"slices"
 	"sync"
 	"time"
 
@@ -30,16 +31,45 @@ func ReadAt(id Opener, p []byte, off int64) (n int, err error) {
 		return 0, fs.ErrInvalid
 	}
 	c := make(chan readAtDone)
+	if Lower != nil && len(p) > 0 && Lower.Holds(id) {
+		readAtCalls <- readAtCall{id: id, p: p, off: off, done: c, ramOnly: true}
+		if d := <-c; d.n == len(p) {
+			return d.n, nil
+		} else if Lower.ReadAt(id, p, off) == len(p) {
+			counters.lowerHits.Add(1)
+			return len(p), nil
+		}
+	}
 	readAtCalls <- readAtCall{id: id, p: p, off: off, done: c}
 	d := <-c
 	return d.n, d.err
 }
 
+// A Tier is a slower cache beneath the spinner's blocks in RAM, such as a database on local disk,
+// and above the original file, which must be read again from the start.
+// Blocks that were used often in RAM are promoted to it when they are evicted.
+type Tier interface {
+	Holds(id Opener) bool                      // cheaply, whether there is any point calling ReadAt
+	ReadAt(id Opener, p []byte, off int64) int // the number of bytes found, starting at off
+	Promote(id Opener, off int64, p []byte)    // called from a background goroutine
+}
+
+// Lower is the next tier down, if any, and must be set before the first ReadAt
+var Lower Tier
+
 type Opener interface {
 	Open() (fs.File, error)
 	fmt.Stringer // used for debug messages
 }
 
+// A Grouper is an [Opener] that shares a limit on open readers with the rest of its group,
+// such as the other members of one archive.
+// The group key must be comparable.
+type Grouper interface {
+	Opener
+	ReaderGroup() any
+}
+
 const (
 	blockSize   = 4096 // must match the AppleDouble resourcefork padding
 	blockMask   = -blockSize
@@ -47,24 +77,49 @@ const (
 
 	readerCacheN = 64 // open readers (remember some decompressors carry big state buffers)
 
+	// MaxReaders is the number of decompressors kept open at once.
+	// More concurrent sequential readers than this will thrash.
+	MaxReaders = readerCacheN
+
+	// MaxReadersPerGroup is the share of the open decompressors that one group may keep.
+	// Beyond this a group evicts its own least recently used reader, rather than another group's.
+	MaxReadersPerGroup = readerCacheN / 4
+
 	becausePopular = 1
 	becauseBusy    = 2
+
+	promoteHits = 2 // cache hits that make a block worth promoting to the lower tier
 )
 
 var (
 	readAtCalls = make(chan readAtCall, 16)
+	promotions  = make(chan promotion, 256) // dropped if the lower tier is too slow to keep up
 	blockPool   = sync.Pool{New: func() any { return new(block) }}
+	zeroBlock   block // shared by the cache entries of every all-zero block, so never written
 	seed        = maphash.MakeSeed()
 )
 
 type (
 	block [blockSize]byte
 
+	cachedBlock struct {
+		p    *block
+		n    int // short at the end of the file
+		hits int
+	}
+	promotion struct {
+		id  Opener
+		off int64
+		p   []byte
+	}
+
 	readAtCall struct {
 		id   Opener
 		p    []byte
 		off  int64
 		done chan<- readAtDone
+
+		ramOnly bool // answer with n == len(p) from the cache, or not at all
 	}
 	readAtDone struct {
 		n   int
@@ -101,19 +156,39 @@ type (
 	}
 )
 
-func init() { go multiplexer() }
+func init() { go multiplexer(); go promoter() }
 func multiplexer() {
 	var (
 		wkrs         = make(map[Opener]*wkrState)
 		evictWkr     Opener
 		blockReturns = make(chan blockReturn)
-		blkCache     = tinylfu.New[blkCacheKey, *block](
+		blkCache     = tinylfu.New[blkCacheKey, *cachedBlock](
 			blockCacheN, blockCacheN*10, blkHash,
 			tinylfu.OnEvict(blkEvict))
 		wkrPopularity = tinylfu.New[Opener, struct{}](
 			readerCacheN, readerCacheN*10, wkrHash,
 			tinylfu.OnEvict(func(k Opener, _ struct{}) { evictWkr = k }))
+		groups = make(map[any][]Opener) // popular readers of each group, least recently used first
 	)
+	unpopular := func(id Opener) {
+		if g, ok := id.(Grouper); ok {
+			members := slices.DeleteFunc(groups[g.ReaderGroup()], func(o Opener) bool { return o == id })
+			if len(members) == 0 {
+				delete(groups, g.ReaderGroup())
+			} else {
+				groups[g.ReaderGroup()] = members
+			}
+		}
+		wkr := wkrs[id]
+		if wkr == nil {
+			return // already dropped by its group
+		}
+		wkr.whyKeep &^= becausePopular
+		if wkr.whyKeep == 0 {
+			close(wkr.ch)
+			delete(wkrs, id)
+		}
+	}
 	for {
 		var (
 			wkr *wkrState
@@ -131,8 +206,15 @@ func multiplexer() {
 			}
 			continue
 		case job := <-readAtCalls:
+			if job.ramOnly {
+				job.done <- readAtDone{n: readFromRAM(blkCache, job)}
+				continue
+			}
 			id, wkr = job.id, wkrs[job.id]
-			if wkr == nil {
+			if wkr != nil {
+				counters.readerHits.Add(1)
+			} else {
+				counters.readerMisses.Add(1)
 				wkr = new(wkrState)
 				wkrs[job.id] = wkr
 				ch := make(chan blockRequest, 1)
@@ -146,14 +228,17 @@ func multiplexer() {
 			wkrPopularity.Add(id, struct{}{}) // might set evictWkr
 			wkr.whyKeep |= becausePopular
 			if evictWkr != nil {
-				exwkr := wkrs[evictWkr]
-				exwkr.whyKeep &^= becausePopular
-				if exwkr.whyKeep == 0 {
-					close(exwkr.ch)
-					delete(wkrs, evictWkr)
-				}
+				unpopular(evictWkr)
 			}
 			evictWkr = nil
+			if g, ok := id.(Grouper); ok {
+				members := slices.DeleteFunc(groups[g.ReaderGroup()], func(o Opener) bool { return o == id })
+				groups[g.ReaderGroup()] = append(members, id)
+				if len(members) >= MaxReadersPerGroup {
+					unpopular(members[0])
+					counters.groupEvictions.Add(1)
+				}
+			}
 
 			r := readAtState{
 				readAtCall: job,
@@ -161,7 +246,11 @@ func multiplexer() {
 			}
 			for off := job.off & blockMask; off >= 0 && off < bufEnd(job.off, job.p); off += blockSize {
 				if blk, ok := blkCache.Get(blkCacheKey{job.id, off}); ok {
-					r.putBlock(off, blk)
+					blk.hits++
+					r.putBlock(off, blk.p)
+					counters.blockHits.Add(1)
+				} else {
+					counters.blockMisses.Add(1)
 				}
 			}
 			wkr.readAts = append(wkr.readAts, r)
@@ -172,12 +261,13 @@ func multiplexer() {
 				panic(fmt.Sprintf("did not get the block we requested: %d not %d", done.off, wkr.seek))
 			}
 			if done.p != nil {
-				blkCache.Add(blkCacheKey{done.id, wkr.seek}, done.p)
+				blkCache.Add(blkCacheKey{done.id, wkr.seek}, &cachedBlock{p: done.p, n: done.n})
 				for i := range wkr.readAts {
 					wkr.readAts[i].putBlock(wkr.seek, done.p)
 				}
 			}
 			wkr.seek += int64(done.n)
+			counters.bytesDecompressed.Add(int64(done.n))
 			if done.err != nil {
 				if done.err == io.EOF && wkr.err == io.EOF && wkr.errAt != wkr.seek {
 					slog.Error("conflictingEOF", "path", id, "was", wkr.errAt, "becomes", wkr.seek)
@@ -295,6 +385,9 @@ func work(id Opener, ctrl <-chan blockRequest, result chan<- blockReturn) {
 		if n == 0 {
 			blockPoolPut(blk)
 			blk = nil
+		} else if isZero(blk[:n]) {
+			blockPoolPut(blk)
+			blk = &zeroBlock
 		}
 		result <- blockReturn{id: id, off: off, p: blk, n: n, err: err}
 		off += int64(n)
@@ -309,13 +402,57 @@ func errWithPath(err error, path string) error {
 	return fmt.Errorf("%w: %s", err, path)
 }
 
-func blkHash(k blkCacheKey) uint64       { return maphash.Comparable(seed, k) }
-func blkEvict(_ blkCacheKey, buf *block) { blockPoolPut(buf) }
+// readFromRAM returns len(p) if every block is cached, without starting any work
+func readFromRAM(blkCache *tinylfu.T[blkCacheKey, *cachedBlock], job readAtCall) int {
+	r := readAtState{readAtCall: job, progress: newBitmap(nBlocksTouched(job.off, job.p))}
+	for off := job.off & blockMask; off >= 0 && off < bufEnd(job.off, job.p); off += blockSize {
+		blk, ok := blkCache.Get(blkCacheKey{job.id, off})
+		if !ok || off+int64(blk.n) < bufEnd(job.off, job.p) && blk.n < blockSize {
+			return 0
+		}
+		blk.hits++
+		r.putBlock(off, blk.p)
+	}
+	return len(job.p)
+}
+
+func blkHash(k blkCacheKey) uint64 { return maphash.Comparable(seed, k) }
+func blkEvict(k blkCacheKey, blk *cachedBlock) {
+	if Lower != nil && blk.hits >= promoteHits {
+		select {
+		case promotions <- promotion{k.id, k.offset, append([]byte(nil), blk.p[:blk.n]...)}:
+		default:
+		}
+	}
+	blockPoolPut(blk.p)
+}
+
+func promoter() {
+	for pr := range promotions {
+		Lower.Promote(pr.id, pr.off, pr.p)
+		counters.promotions.Add(1)
+	}
+}
 
 func wkrHash(k Opener) uint64 { return maphash.Comparable(seed, k) }
 
-func blockPoolGet() *block  { return blockPool.Get().(*block) }
-func blockPoolPut(b *block) { blockPool.Put(b) }
+func blockPoolGet() *block { return blockPool.Get().(*block) }
+
+func blockPoolPut(b *block) {
+	if b != &zeroBlock {
+		blockPool.Put(b)
+	}
+}
+
+// isZero finds the blank blocks of sparse files and disk images, which can all share zeroBlock
+func isZero(p []byte) bool {
+	for _, b := range p {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
 
 func bufEnd(off int64, p []byte) int64 { return off + int64(len(p)) }
 
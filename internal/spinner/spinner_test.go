@@ -274,3 +274,62 @@ func bufCorrect(offset int64, buf []byte) bool {
 	}
 	return true
 }
+
+type groupedFile struct {
+	reopenableFile
+	group string
+}
+
+func (g groupedFile) ReaderGroup() any { return g.group }
+
+func TestGroupLimit(t *testing.T) {
+	fsys := new(fsys)
+	files := make([]groupedFile, MaxReadersPerGroup+1)
+	buf := make([]byte, 1)
+	for i := range files {
+		files[i] = groupedFile{reopenableFile{fsys, fmt.Sprintf("fast%d", 100000+i)}, "big.sit"}
+		ReadAt(files[i], buf, 0)
+	}
+
+	opened := fsys.openCount
+	ReadAt(files[len(files)-1], buf, blockSize)
+	if fsys.openCount != opened {
+		t.Error("expected the most recent reader in the group to stay open")
+	}
+	ReadAt(files[0], buf, blockSize)
+	if fsys.openCount == opened {
+		t.Error("expected the least recent reader in the group to have been closed")
+	}
+}
+
+type fakeTier struct{ holds Opener }
+
+func (t fakeTier) Holds(id Opener) bool { return id == t.holds }
+func (t fakeTier) ReadAt(id Opener, p []byte, off int64) int {
+	for i := range p {
+		p[i] = byteAtOffset(off + int64(i))
+	}
+	return len(p)
+}
+func (t fakeTier) Promote(Opener, int64, []byte) {}
+
+func TestLowerTier(t *testing.T) {
+	fsys := new(fsys)
+	held, notHeld := reopenableFile{fsys, "fast100000"}, reopenableFile{fsys, "fast100001"}
+	Lower = fakeTier{held}
+	defer func() { Lower = nil }()
+
+	buf := make([]byte, 100)
+	before := ReadCounters()
+	if n, err := ReadAt(held, buf, 50000); n != len(buf) || err != nil || !bufCorrect(50000, buf) {
+		t.Error("bad read from the lower tier", n, err)
+	} else if fsys.openCount != 0 {
+		t.Error("expected the lower tier to spare opening the file")
+	}
+	if n, err := ReadAt(notHeld, buf, 50000); n != len(buf) || err != nil || !bufCorrect(50000, buf) {
+		t.Error("bad read past the lower tier", n, err)
+	}
+	if c := ReadCounters().Sub(before); c.LowerHits != 1 {
+		t.Errorf("expected 1 lower tier hit, got %d", c.LowerHits)
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
+	"github.com/elliotnunn/BeHierarchic/internal/guard"
 )
 
 func New(r io.ReaderAt) fs.FS {
@@ -34,6 +35,7 @@ func populate(fsys *fskeleton.FS, headerReader, dataReader io.ReaderAt) error {
 	var gnuLongName, gnuLongLink string
 	var rawHdr block
 	off := int64(0)
+	defer guard.Log("tar", &off)
 
 	for {
 		n, err := headerReader.ReadAt(rawHdr[:], off)
@@ -132,6 +134,10 @@ func populate(fsys *fskeleton.FS, headerReader, dataReader io.ReaderAt) error {
 			switch hdr.Typeflag {
 			case TypeReg, TypeGNUSparse:
 				fsys.CreateReaderAt(cleanPath, off, reader, logisize, fs.FileMode(hdr.Mode), hdr.ModTime)
+				if len(sph) == 0 {
+					raw := fskeleton.Raw{Offset: off, Size: hdr.Size, Method: "store"}
+					fsys.SetRaw(cleanPath, func() (fskeleton.Raw, error) { return raw, nil })
+				}
 			case TypeDir:
 				fsys.Mkdir(cleanPath, off, fs.FileMode(hdr.Mode), hdr.ModTime)
 			case TypeSymlink:
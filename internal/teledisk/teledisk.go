@@ -0,0 +1,215 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+// Package teledisk reads the floppy images of Sydex's Teledisk (.TD0),
+// presenting the sectors as a flat image so that the file system on the disk can be probed in turn.
+//
+// After a 12-byte header, everything is compressed with LZHUF if the signature is "td" rather than "TD".
+// There follows an optional comment, then for each track a header and its sectors,
+// each stored raw, as a repeated pair of bytes, or as runs of literals and repeated patterns.
+// Sectors are laid out track by track in the order the image records them, and by sector number within a track.
+// A sector recorded without data is filled with zeros.
+package teledisk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"time"
+
+	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
+	"github.com/elliotnunn/BeHierarchic/internal/guard"
+	"github.com/elliotnunn/BeHierarchic/internal/lzhuf"
+)
+
+var (
+	ErrFormat = errors.New("not a valid Teledisk image")
+	ErrOldLZW = errors.New("Teledisk: the LZW compression of versions before 2.0 is unimplemented")
+)
+
+const (
+	headerSize = 12
+	maxTracks  = 256 * 2
+)
+
+// IsImage checks the signature and the CRC of the header
+func IsImage(head []byte) bool {
+	if len(head) < headerSize || string(head[:2]) != "TD" && string(head[:2]) != "td" {
+		return false
+	}
+	return crc(head[:10]) == binary.LittleEndian.Uint16(head[10:])
+}
+
+// crc is Teledisk's CRC-16, polynomial 0xA097 with an initial value of zero
+func crc(p []byte) uint16 {
+	var c uint16
+	for _, b := range p {
+		c ^= uint16(b) << 8
+		for range 8 {
+			if c&0x8000 != 0 {
+				c = c<<1 ^ 0xa097
+			} else {
+				c <<= 1
+			}
+		}
+	}
+	return c
+}
+
+// New2 presents the sectors as a single file with the given name.
+// The whole image is decompressed once now to learn its size, and again each time the file is read.
+func New2(headerReader, dataReader io.ReaderAt, name string, mtime time.Time) (fs.FS, error) {
+	var size int64
+	err := decode(headerReader, func(p []byte) error {
+		size += int64(len(p))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	fsys := fskeleton.New()
+	fsys.CreateReadCloser(name, 0, func() (io.ReadCloser, error) {
+		pr, pw := io.Pipe()
+		go func() {
+			bw := bufio.NewWriter(pw)
+			err := decode(dataReader, func(p []byte) error {
+				_, err := bw.Write(p)
+				return err
+			})
+			if err == nil {
+				err = bw.Flush()
+			}
+			pw.CloseWithError(err)
+		}()
+		return pr, nil
+	}, size, 0, mtime)
+	fsys.NoMore()
+	return fsys, nil
+}
+
+type sector struct {
+	num  byte
+	data []byte
+}
+
+// decode passes each track's sectors to emit, in order
+func decode(ra io.ReaderAt, emit func([]byte) error) (reterr error) {
+	defer guard.Recover(&reterr, "Teledisk", nil)
+	h := make([]byte, headerSize)
+	if n, err := ra.ReadAt(h, 0); n != len(h) {
+		if err == io.EOF {
+			err = ErrFormat
+		}
+		return err
+	}
+	if !IsImage(h) {
+		return ErrFormat
+	}
+	var r io.Reader = io.NewSectionReader(ra, headerSize, 1<<40)
+	if string(h[:2]) == "td" {
+		if h[4] < 20 {
+			return ErrOldLZW
+		}
+		lz := lzhuf.NewReader(r, -1)
+		defer lz.Close()
+		r = lz
+	}
+	br := bufio.NewReader(r)
+	le := binary.LittleEndian
+
+	if h[7]&0x80 != 0 { // comment
+		c := make([]byte, 10)
+		if _, err := io.ReadFull(br, c); err != nil {
+			return err
+		}
+		if _, err := br.Discard(int(le.Uint16(c[2:]))); err != nil {
+			return err
+		}
+	}
+
+	for range maxTracks {
+		th := make([]byte, 4)
+		if _, err := io.ReadFull(br, th); err != nil {
+			return err
+		}
+		nsec := int(th[0])
+		if nsec == 0xff { // end of image
+			return nil
+		}
+		sectors := make([]sector, nsec)
+		for i := range sectors {
+			sh := make([]byte, 6)
+			if _, err := io.ReadFull(br, sh); err != nil {
+				return err
+			}
+			sizeCode, flags := sh[3], sh[4]
+			if sizeCode > 6 {
+				return fmt.Errorf("%w: sector size code %d", ErrFormat, sizeCode)
+			}
+			s := sector{num: sh[2], data: make([]byte, 128<<sizeCode)}
+			if flags&0x30 == 0 { // data follows
+				if err := readSector(br, s.data); err != nil {
+					return err
+				}
+			}
+			sectors[i] = s
+		}
+		slices.SortStableFunc(sectors, func(a, b sector) int { return int(a.num) - int(b.num) })
+		for _, s := range sectors {
+			if err := emit(s.data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readSector fills dst from one of the three encodings of a sector's data
+func readSector(br *bufio.Reader, dst []byte) error {
+	b := make([]byte, 3)
+	if _, err := io.ReadFull(br, b); err != nil {
+		return err
+	}
+	n := int(binary.LittleEndian.Uint16(b)) - 1
+	if n < 0 {
+		return fmt.Errorf("%w: empty sector data", ErrFormat)
+	}
+	src := make([]byte, n)
+	if _, err := io.ReadFull(br, src); err != nil {
+		return err
+	}
+
+	var out []byte
+	switch b[2] {
+	case 0: // raw
+		out = src
+	case 1: // a 16-bit count and a pair of bytes to repeat
+		for len(src) >= 4 {
+			out = append(out, bytes.Repeat(src[2:4], int(binary.LittleEndian.Uint16(src)))...)
+			src = src[4:]
+		}
+	case 2: // runs: literal bytes, or a pattern of 2**k bytes repeated
+		for len(src) >= 2 {
+			kind, count := src[0], int(src[1])
+			src = src[2:]
+			if kind == 0 {
+				count = min(count, len(src))
+				out = append(out, src[:count]...)
+				src = src[count:]
+			} else {
+				plen := min(1<<kind, len(src))
+				out = append(out, bytes.Repeat(src[:plen], count)...)
+				src = src[plen:]
+			}
+		}
+	default:
+		return fmt.Errorf("%w: sector encoding %d", ErrFormat, b[2])
+	}
+	copy(dst, out)
+	return nil
+}
@@ -0,0 +1,64 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package teledisk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestCRC(t *testing.T) {
+	if got := crc([]byte("123456789")); got != 0x0fb3 {
+		t.Errorf("got %#04x", got)
+	}
+}
+
+func TestImage(t *testing.T) {
+	h := []byte{'T', 'D', 0, 0, 21, 2, 1, 0x80, 0, 1}
+	img := binary.LittleEndian.AppendUint16(h, crc(h))
+	img = append(img, 0, 0, 5, 0, 0, 0, 0, 0, 0, 0, 'h', 'i', 'y', 'a', '!') // comment
+	img = append(img, 4, 0, 0, 0)                                            // track of four 128-byte sectors, out of order
+
+	sector := func(num byte, method byte, data ...byte) {
+		img = append(img, 0, 0, num, 0, 0, 0)
+		img = binary.LittleEndian.AppendUint16(img, uint16(len(data)+1))
+		img = append(img, method)
+		img = append(img, data...)
+	}
+	sector(2, 0, bytes.Repeat([]byte{'r'}, 128)...) // raw
+	sector(1, 1, 64, 0, 'a', 'b')                   // repeated pair
+	sector(3, 2, 0, 2, 'x', 'y', 1, 63, 'z', 'z')   // literals, then a run of 2-byte pattern
+	img = append(img, 0, 0, 4, 0, 0x20, 0)          // no data
+	img = append(img, 0xff, 0, 0, 0)                // end
+
+	fsys, err := New2(bytes.NewReader(img), bytes.NewReader(img), "disk.img", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := fs.ReadFile(fsys, "disk.img")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := bytes.Repeat([]byte("ab"), 64)
+	want = append(want, bytes.Repeat([]byte{'r'}, 128)...)
+	want = append(want, 'x', 'y')
+	want = append(want, bytes.Repeat([]byte("zz"), 63)...)
+	want = append(want, make([]byte, 128)...)
+	if !bytes.Equal(got, want) {
+		t.Errorf("wrong sectors: got %q", got)
+	}
+	if err := fstest.TestFS(fsys, "disk.img"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNotImage(t *testing.T) {
+	if IsImage([]byte("TD\x00\x00\x15\x02\x01\x00\x00\x01\x00\x00")) {
+		t.Error("accepted a header with a bad CRC")
+	}
+}
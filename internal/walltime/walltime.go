@@ -0,0 +1,22 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+// Package walltime marks the times that an archive recorded by the clock on the wall, in no particular time zone,
+// as MS-DOS and the classic Mac OS did, so that a zone can be chosen for them later.
+package walltime
+
+import "time"
+
+// Zone holds a wall-clock time whose fields are as recorded. It is UTC in all but name.
+var Zone = time.FixedZone("wall", 0)
+
+// Is reports whether a time was recorded by the clock on the wall
+func Is(t time.Time) bool { return t.Location() == Zone }
+
+// In reads the fields of a wall-clock time in a real zone, or leaves it alone if loc is nil
+func In(t time.Time, loc *time.Location) time.Time {
+	if loc == nil || t.IsZero() {
+		return t
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+}
@@ -0,0 +1,26 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package walltime
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIn(t *testing.T) {
+	wall := time.Date(1995, 8, 24, 9, 30, 0, 0, Zone)
+	if !Is(wall) || Is(wall.UTC()) {
+		t.Error("Is does not tell the wall clock from UTC")
+	}
+	tokyo := time.FixedZone("JST", 9*60*60)
+	if got, want := In(wall, tokyo), time.Date(1995, 8, 24, 0, 30, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("9:30 in Tokyo: got %v, want %v", got.UTC(), want)
+	}
+	if got := In(wall, nil); !got.Equal(wall) {
+		t.Errorf("no zone: got %v", got)
+	}
+	if got := In(time.Time{}, tokyo); !got.IsZero() {
+		t.Errorf("zero time: got %v", got)
+	}
+}
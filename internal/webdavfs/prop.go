@@ -109,29 +109,58 @@ var liveProps = map[xml.Name]struct {
 	},
 }
 
+// LivePropsFS is implemented by a file system with live properties of its own, outside the DAV: namespace.
+// They are listed by propname and returned when asked for by name, but as RFC 4918 asks,
+// allprop leaves them out unless they are named in include.
+type LivePropsFS interface {
+	fs.FS
+	// LiveProps returns the inner XML of each property of the named file
+	LiveProps(name string, fi fs.FileInfo) (map[xml.Name]string, error)
+}
+
+// extraProps returns the live properties outside the DAV: namespace, if the file system has any
+func extraProps(fsys fs.FS, name string, fi fs.FileInfo) (map[xml.Name]string, error) {
+	lp, ok := fsys.(LivePropsFS)
+	if !ok {
+		return nil, nil
+	}
+	return lp.LiveProps(name, fi)
+}
+
 // TODO(nigeltao) merge props and allprop?
 
 // props returns the status of the properties named pnames for resource name.
 //
 // Each Propstat has a unique status and each property name will only be part
 // of one Propstat element.
-func props(fs fs.FS, name string, pnames []xml.Name) ([]Propstat, error) {
-	f, err := fs.Open(name)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-	fi, err := f.Stat()
+// fi is the result of an earlier Stat or ReadDir, or nil.
+func props(fs fs.FS, name string, fi fs.FileInfo, pnames []xml.Name) ([]Propstat, error) {
+	fi, err := statFor(fs, name, fi)
 	if err != nil {
 		return nil, err
 	}
 	isDir := fi.IsDir()
+	var extra map[xml.Name]string
+	for _, pn := range pnames {
+		if pn.Space != "DAV:" { // only then is it worth asking
+			extra, err = extraProps(fs, name, fi)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
 
 	pstatOK := Propstat{Status: http.StatusOK}
 	pstatNotFound := Propstat{Status: http.StatusNotFound}
 	for _, pn := range pnames {
 		// Must either be a live property or we don't know it.
-		if prop := liveProps[pn]; prop.findFn != nil && (prop.dir || !isDir) {
+		if innerXML, ok := extra[pn]; ok {
+			pstatOK.Props = append(pstatOK.Props, property{
+				XMLName:  pn, // keeps its own namespace
+				InnerXML: []byte(innerXML),
+			})
+		} else if prop := liveProps[pn]; prop.findFn != nil && (prop.dir || !isDir) {
 			innerXML, err := prop.findFn(fs, name, fi)
 			if err != nil {
 				return nil, err
@@ -150,25 +179,32 @@ func props(fs fs.FS, name string, pnames []xml.Name) ([]Propstat, error) {
 }
 
 // propnames returns the property names defined for resource name.
-func propnames(fs fs.FS, name string) ([]xml.Name, error) {
-	f, err := fs.Open(name)
+func propnames(fs fs.FS, name string, fi fs.FileInfo) ([]xml.Name, error) {
+	fi, err := statFor(fs, name, fi)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-	fi, err := f.Stat()
+	pnames := davPropnames(fi)
+	extra, err := extraProps(fs, name, fi)
 	if err != nil {
 		return nil, err
 	}
-	isDir := fi.IsDir()
+	for pn := range extra {
+		pnames = append(pnames, pn)
+	}
+	return pnames, nil
+}
 
+// davPropnames returns the names of the DAV: properties that apply to fi.
+func davPropnames(fi fs.FileInfo) []xml.Name {
+	isDir := fi.IsDir()
 	pnames := make([]xml.Name, 0, len(liveProps))
 	for pn, prop := range liveProps {
 		if prop.findFn != nil && (prop.dir || !isDir) {
 			pnames = append(pnames, pn)
 		}
 	}
-	return pnames, nil
+	return pnames
 }
 
 // allprop returns the properties defined for resource name and the properties
@@ -179,11 +215,12 @@ func propnames(fs fs.FS, name string) ([]xml.Name, error) {
 // returned if they are named in 'include'.
 //
 // See http://www.webdav.org/specs/rfc4918.html#METHOD_PROPFIND
-func allprop(fs fs.FS, name string, include []xml.Name) ([]Propstat, error) {
-	pnames, err := propnames(fs, name)
+func allprop(fs fs.FS, name string, fi fs.FileInfo, include []xml.Name) ([]Propstat, error) {
+	fi, err := statFor(fs, name, fi)
 	if err != nil {
 		return nil, err
 	}
+	pnames := davPropnames(fi)
 	// Add names from include if they are not already covered in pnames.
 	nameset := make(map[xml.Name]bool)
 	for _, pn := range pnames {
@@ -194,7 +231,17 @@ func allprop(fs fs.FS, name string, include []xml.Name) ([]Propstat, error) {
 			pnames = append(pnames, pn)
 		}
 	}
-	return props(fs, name, pnames)
+	return props(fs, name, fi, pnames)
+}
+
+// statFor reuses a FileInfo already in hand, so that a PROPFIND of a directory
+// with thousands of archive members need not open each one just to Stat it.
+// Only a symlink needs another look, because its properties are its target's.
+func statFor(fsys fs.FS, name string, fi fs.FileInfo) (fs.FileInfo, error) {
+	if fi != nil && fi.Mode()&fs.ModeSymlink == 0 {
+		return fi, nil
+	}
+	return fs.Stat(fsys, name)
 }
 
 func escapeXML(s string) string {
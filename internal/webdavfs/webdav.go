@@ -48,7 +48,15 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			status, err = h.handleGetHead(w, r)
 		case "PROPFIND":
 			status, err = h.handlePropfind(w, r)
-		case "DELETE", "POST", "PUT", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK", "PROPPATCH":
+		case "PUT":
+			status, err = h.handlePut(w, r)
+		case "MKCOL":
+			status, err = h.handleMkcol(w, r)
+		case "DELETE":
+			status, err = h.handleDelete(w, r)
+		case "COPY", "MOVE":
+			status, err = h.handleCopyMove(w, r)
+		case "POST", "LOCK", "UNLOCK", "PROPPATCH":
 			status, err = http.StatusMethodNotAllowed, nil
 		}
 	}
@@ -77,6 +85,9 @@ func (h *Handler) handleOptions(w http.ResponseWriter, r *http.Request) (status
 			allow = "OPTIONS, PROPFIND, GET"
 		}
 	}
+	if wfs, ok := h.FS.(WriteFS); ok && wfs.CanWrite(reqPath) {
+		allow += ", PUT, MKCOL, DELETE, COPY, MOVE"
+	}
 	w.Header().Set("Allow", allow)
 	// http://www.webdav.org/specs/rfc4918.html#dav.compliance.classes
 	w.Header().Set("DAV", "1") // locking not supported
@@ -112,7 +123,9 @@ func (h *Handler) handleGetHead(w http.ResponseWriter, r *http.Request) (status
 		return http.StatusInternalServerError, err
 	}
 	w.Header().Set("ETag", etag)
-	w.Header().Set("Content-Type", "application/octet-stream")
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
 	http.ServeContent(w, r, "", fi.ModTime(), errLogger{f.(io.ReadSeeker), reqPath})
 	return 0, nil
 }
@@ -156,6 +169,10 @@ func (h *Handler) handlePropfind(w http.ResponseWriter, r *http.Request) (status
 		return status, err
 	}
 
+	minimal := preferMinimal(r)
+	if minimal && r.Header.Get("Prefer") != "" {
+		w.Header().Set("Preference-Applied", "return=minimal")
+	}
 	mw := multistatusWriter{w: w}
 
 	walkFn := func(reqPath string, info os.FileInfo, err error) error {
@@ -165,7 +182,7 @@ func (h *Handler) handlePropfind(w http.ResponseWriter, r *http.Request) (status
 
 		var pstats []Propstat
 		if pf.Propname != nil {
-			pnames, err := propnames(h.FS, reqPath)
+			pnames, err := propnames(h.FS, reqPath, info)
 			if err != nil {
 				return handlePropfindError(err, info)
 			}
@@ -175,13 +192,16 @@ func (h *Handler) handlePropfind(w http.ResponseWriter, r *http.Request) (status
 			}
 			pstats = append(pstats, pstat)
 		} else if pf.Allprop != nil {
-			pstats, err = allprop(h.FS, reqPath, pf.Prop)
+			pstats, err = allprop(h.FS, reqPath, info, pf.Include)
 		} else {
-			pstats, err = props(h.FS, reqPath, pf.Prop)
+			pstats, err = props(h.FS, reqPath, info, pf.Prop)
 		}
 		if err != nil {
 			return handlePropfindError(err, info)
 		}
+		if minimal {
+			pstats = omitNotFound(pstats)
+		}
 		href := reqPath
 		if href == "." {
 			href = ""
@@ -203,6 +223,40 @@ func (h *Handler) handlePropfind(w http.ResponseWriter, r *http.Request) (status
 	return 0, nil
 }
 
+// preferMinimal reports whether the client asked for unknown properties to be left out,
+// either with RFC 7240's "Prefer: return=minimal" or Microsoft's older "Brief: t"
+func preferMinimal(r *http.Request) bool {
+	if strings.EqualFold(strings.TrimSpace(r.Header.Get("Brief")), "t") {
+		return true
+	}
+	for _, v := range r.Header.Values("Prefer") {
+		for pref := range strings.SplitSeq(v, ",") {
+			pref, _, _ = strings.Cut(pref, ";") // parameters
+			name, val, _ := strings.Cut(pref, "=")
+			if strings.EqualFold(strings.TrimSpace(name), "return") &&
+				strings.EqualFold(strings.Trim(strings.TrimSpace(val), `"`), "minimal") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// omitNotFound drops the 404 propstat, which Windows and Office clients make large
+// by asking for dozens of properties that a read-only file system never has
+func omitNotFound(pstats []Propstat) []Propstat {
+	kept := pstats[:0]
+	for _, p := range pstats {
+		if p.Status != http.StatusNotFound {
+			kept = append(kept, p)
+		}
+	}
+	if len(kept) == 0 {
+		kept = append(kept, Propstat{Status: http.StatusOK})
+	}
+	return kept
+}
+
 func makePropstatResponse(href string, pstats []Propstat) *response {
 	resp := response{
 		Href:     []string{(&url.URL{Path: href}).EscapedPath()},
@@ -244,9 +298,12 @@ func StatusText(code int) string {
 }
 
 var (
-	errInvalidDepth      = errors.New("webdav: invalid depth")
-	errInvalidPropfind   = errors.New("webdav: invalid propfind")
-	errInvalidResponse   = errors.New("webdav: invalid response")
-	errNoFileSystem      = errors.New("webdav: no file system")
-	errUnsupportedMethod = errors.New("webdav: unsupported method")
+	errInvalidDepth            = errors.New("webdav: invalid depth")
+	errInvalidDestination      = errors.New("webdav: invalid destination")
+	errInvalidOverwrite        = errors.New("webdav: invalid overwrite")
+	errDestinationWithinSource = errors.New("webdav: destination is within source")
+	errInvalidPropfind         = errors.New("webdav: invalid propfind")
+	errInvalidResponse         = errors.New("webdav: invalid response")
+	errNoFileSystem            = errors.New("webdav: no file system")
+	errUnsupportedMethod       = errors.New("webdav: unsupported method")
 )
@@ -5,6 +5,7 @@
 package webdavfs
 
 import (
+	"encoding/xml"
 	"errors"
 	"io"
 	"io/fs"
@@ -155,3 +156,252 @@ func TestFilenameEscape(t *testing.T) {
 		}
 	}
 }
+
+type putMapFS struct {
+	fstest.MapFS
+}
+
+func (fsys putMapFS) CanWrite(name string) bool { return strings.HasPrefix(name, "in/") }
+
+func (fsys putMapFS) Put(name string, r io.Reader) error {
+	if !fsys.CanWrite(name) {
+		return fs.ErrPermission
+	} else if _, ok := fsys.MapFS[name]; ok {
+		return fs.ErrExist
+	} else if strings.HasSuffix(name, ".huge") {
+		return ErrTooLarge
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	fsys.MapFS[name] = &fstest.MapFile{Data: data}
+	return nil
+}
+
+func (fsys putMapFS) Mkdir(name string) error {
+	if !fsys.CanWrite(name) {
+		return fs.ErrPermission
+	} else if _, ok := fsys.MapFS[name]; ok {
+		return fs.ErrExist
+	}
+	fsys.MapFS[name] = &fstest.MapFile{Mode: fs.ModeDir}
+	return nil
+}
+
+func (fsys putMapFS) Remove(name string) error {
+	if !fsys.CanWrite(name) {
+		return fs.ErrPermission
+	} else if _, ok := fsys.MapFS[name]; !ok {
+		return fs.ErrNotExist
+	}
+	for other := range fsys.MapFS {
+		if strings.HasPrefix(other, name+"/") {
+			return errors.New("directory not empty")
+		}
+	}
+	delete(fsys.MapFS, name)
+	return nil
+}
+
+func (fsys putMapFS) Rename(oldname, newname string) error {
+	if !fsys.CanWrite(oldname) || !fsys.CanWrite(newname) {
+		return fs.ErrPermission
+	} else if _, ok := fsys.MapFS[newname]; ok {
+		return fs.ErrExist
+	}
+	for other, f := range fsys.MapFS {
+		if other == oldname || strings.HasPrefix(other, oldname+"/") {
+			delete(fsys.MapFS, other)
+			fsys.MapFS[newname+other[len(oldname):]] = f
+		}
+	}
+	return nil
+}
+
+func TestWriteMethods(t *testing.T) {
+	fsys := putMapFS{fstest.MapFS{
+		"in":       &fstest.MapFile{Mode: fs.ModeDir},
+		"readonly": &fstest.MapFile{Data: []byte("x")},
+	}}
+	srv := httptest.NewServer(&Handler{FS: fsys})
+	defer srv.Close()
+
+	testCases := []struct {
+		method, path, body string
+		want               int
+	}{
+		{"PUT", "/in/new.sit", "hello", http.StatusCreated},
+		{"PUT", "/in/new.sit", "again", http.StatusConflict},
+		{"PUT", "/elsewhere.sit", "hello", http.StatusForbidden},
+		{"PUT", "/in/new.huge", "hello", http.StatusRequestEntityTooLarge},
+		{"MKCOL", "/in/sub", "", http.StatusCreated},
+		{"MKCOL", "/in/sub", "", http.StatusMethodNotAllowed},
+		{"MKCOL", "/sub", "", http.StatusForbidden},
+		{"DELETE", "/in", "", http.StatusForbidden}, // and in/sub survives for the COPY below
+		{"DELETE", "/readonly", "", http.StatusForbidden},
+		{"COPY /readonly", "/in/sub/copied", "", http.StatusCreated},
+		{"COPY /in/sub", "/in/sub2", "", http.StatusCreated},
+		{"MOVE /in/sub2", "/in/sub3", "", http.StatusCreated},
+		{"MOVE /in/new.sit", "/in/sub3/copied", "F", http.StatusPreconditionFailed},
+		{"MOVE /in/new.sit", "/in/sub3/copied", "", http.StatusNoContent},
+		{"MOVE /in/sub3", "/moved", "", http.StatusForbidden},
+		{"DELETE", "/in/sub3", "", http.StatusNoContent},
+		{"DELETE", "/in/sub3", "", http.StatusNotFound},
+	}
+	for _, tc := range testCases {
+		method, src, isCopyMove := strings.Cut(tc.method, " ")
+		target := tc.path
+		if isCopyMove {
+			target = src
+		}
+		var body io.Reader = strings.NewReader(tc.body)
+		if isCopyMove {
+			body = nil
+		}
+		req, err := http.NewRequest(method, srv.URL+target, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isCopyMove {
+			req.Header.Set("Destination", srv.URL+tc.path)
+			req.Header.Set("Overwrite", tc.body)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tc.want {
+			t.Errorf("%s %s: got status %d, want %d", tc.method, tc.path, res.StatusCode, tc.want)
+		}
+	}
+	if got := string(fsys.MapFS["in/sub/copied"].Data); got != "x" {
+		t.Errorf("COPY stored %q, want %q", got, "x")
+	}
+	for name := range fsys.MapFS {
+		if strings.HasPrefix(name, "in/sub3") || name == "in/new.sit" {
+			t.Errorf("%s should have been moved or deleted", name)
+		}
+	}
+}
+
+func TestPreferMinimal(t *testing.T) {
+	fsys := fstest.MapFS{"file": &fstest.MapFile{Data: []byte("x")}}
+	srv := httptest.NewServer(&Handler{FS: fsys})
+	defer srv.Close()
+
+	body := `<?xml version="1.0"?><propfind xmlns="DAV:" xmlns:Z="urn:schemas-microsoft-com:">` +
+		`<prop><getcontentlength/><Z:Win32FileAttributes/></prop></propfind>`
+	testCases := []struct {
+		header, value string
+		minimal       bool
+	}{
+		{"", "", false},
+		{"Prefer", "return=minimal", true},
+		{"Prefer", "respond-async, return=minimal; foo", true},
+		{"Prefer", "return=representation", false},
+		{"Brief", "t", true},
+		{"Brief", "f", false},
+	}
+	for _, tc := range testCases {
+		req, err := http.NewRequest("PROPFIND", srv.URL+"/file", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Depth", "0")
+		if tc.header != "" {
+			req.Header.Set(tc.header, tc.value)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if !strings.Contains(string(b), "getcontentlength") {
+			t.Errorf("%s: %s: lost a known property", tc.header, tc.value)
+		}
+		if got := strings.Contains(string(b), "404"); got == tc.minimal {
+			t.Errorf("%s: %s: 404 propstat present=%v, want %v", tc.header, tc.value, got, !tc.minimal)
+		}
+	}
+}
+
+type openCounter struct {
+	fstest.MapFS
+	opens int
+}
+
+func (fsys *openCounter) Open(name string) (fs.File, error) {
+	fsys.opens++
+	return fsys.MapFS.Open(name)
+}
+
+func TestPropfindWithoutOpen(t *testing.T) {
+	fsys := &openCounter{MapFS: fstest.MapFS{}}
+	for _, name := range []string{"a", "b", "c", "d"} {
+		fsys.MapFS["dir/"+name] = &fstest.MapFile{Data: []byte(name)}
+	}
+	srv := httptest.NewServer(&Handler{FS: fsys})
+	defer srv.Close()
+
+	req, err := http.NewRequest("PROPFIND", srv.URL+"/dir/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Depth", "1")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if n := strings.Count(string(b), "<href>"); n != 5 {
+		t.Errorf("got %d responses, want 5", n)
+	}
+	if fsys.opens > 2 { // the Stat and the ReadDir of the directory
+		t.Errorf("opened files %d times to list a directory of 4", fsys.opens)
+	}
+}
+
+type chainFS struct{ fstest.MapFS }
+
+func (chainFS) LiveProps(name string, fi fs.FileInfo) (map[xml.Name]string, error) {
+	return map[xml.Name]string{{Space: "urn:test:", Local: "chain"}: "<link>" + name + "</link>"}, nil
+}
+
+func TestLiveProps(t *testing.T) {
+	fsys := chainFS{fstest.MapFS{"file": &fstest.MapFile{Data: []byte("x")}}}
+	srv := httptest.NewServer(&Handler{FS: fsys})
+	defer srv.Close()
+
+	propfind := func(body string) string {
+		req, err := http.NewRequest("PROPFIND", srv.URL+"/file", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Depth", "0")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		return string(b)
+	}
+
+	want := `<chain xmlns="urn:test:"><link>file</link></chain>`
+	if b := propfind(`<?xml version="1.0"?><propfind xmlns="DAV:" xmlns:T="urn:test:"><prop><T:chain/></prop></propfind>`); !strings.Contains(b, want) {
+		t.Errorf("asked by name: %s", b)
+	}
+	if b := propfind(`<?xml version="1.0"?><propfind xmlns="DAV:"><propname/></propfind>`); !strings.Contains(b, `<chain xmlns="urn:test:"></chain>`) {
+		t.Errorf("missing from propname: %s", b)
+	}
+	if b := propfind(`<?xml version="1.0"?><propfind xmlns="DAV:"><allprop/></propfind>`); strings.Contains(b, "chain") {
+		t.Errorf("allprop should leave it out: %s", b)
+	}
+	if b := propfind(`<?xml version="1.0"?><propfind xmlns="DAV:" xmlns:T="urn:test:"><allprop/><include><T:chain/></include></propfind>`); !strings.Contains(b, want) {
+		t.Errorf("missing from allprop with include: %s", b)
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package webdavfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	gopath "path"
+	"strings"
+)
+
+// ErrTooLarge is for a WriteFS to refuse an upload that would take more space than it allows
+var ErrTooLarge = errors.New("upload too large")
+
+// WriteFS is implemented by a file system with a writable area, such as an upload drop-box.
+// Outside that area the methods must return an error wrapping fs.ErrPermission,
+// and the rest of the file system stays read-only.
+type WriteFS interface {
+	fs.FS
+
+	// CanWrite reports whether name is within the writable area, for OPTIONS.
+	// It must be false for the top of the area, lest DELETE empty the area before failing to remove it.
+	CanWrite(name string) bool
+
+	// Put creates a new file from r, which must not be seen by readers until it is complete.
+	// An existing file should cause an error wrapping fs.ErrExist.
+	Put(name string, r io.Reader) error
+
+	// Mkdir creates a single new directory
+	Mkdir(name string) error
+
+	// Remove deletes a single file or empty directory
+	Remove(name string) error
+
+	// Rename moves a file or directory within the writable area.
+	// An existing newname should cause an error wrapping fs.ErrExist.
+	Rename(oldname, newname string) error
+}
+
+func (h *Handler) handlePut(w http.ResponseWriter, r *http.Request) (status int, err error) {
+	reqPath, status, err := pathConvert(r.URL.Path)
+	if err != nil {
+		return status, err
+	}
+	wfs, ok := h.FS.(WriteFS)
+	if !ok {
+		return http.StatusMethodNotAllowed, nil
+	}
+	err = wfs.Put(reqPath, r.Body)
+	if err != nil {
+		return writeErrorStatus(err), err
+	}
+	return http.StatusCreated, nil
+}
+
+func (h *Handler) handleMkcol(w http.ResponseWriter, r *http.Request) (status int, err error) {
+	reqPath, status, err := pathConvert(r.URL.Path)
+	if err != nil {
+		return status, err
+	}
+	wfs, ok := h.FS.(WriteFS)
+	if !ok {
+		return http.StatusMethodNotAllowed, nil
+	}
+	if r.ContentLength > 0 {
+		return http.StatusUnsupportedMediaType, nil
+	}
+	err = wfs.Mkdir(reqPath)
+	if errors.Is(err, fs.ErrExist) {
+		return http.StatusMethodNotAllowed, err // RFC 4918 9.3.1
+	} else if err != nil {
+		return writeErrorStatus(err), err
+	}
+	return http.StatusCreated, nil
+}
+
+// writeErrorStatus follows RFC 4918 9.7.1: a missing parent collection is a 409 Conflict
+func writeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, fs.ErrPermission):
+		return http.StatusForbidden
+	case errors.Is(err, ErrTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, fs.ErrExist):
+		return http.StatusConflict
+	case errors.Is(err, fs.ErrInvalid):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// A failure is one member of a collection that could not be deleted or copied
+type failure struct {
+	name   string
+	status int
+}
+
+func (h *Handler) handleDelete(w http.ResponseWriter, r *http.Request) (status int, err error) {
+	reqPath, status, err := pathConvert(r.URL.Path)
+	if err != nil {
+		return status, err
+	}
+	wfs, ok := h.FS.(WriteFS)
+	if !ok {
+		return http.StatusMethodNotAllowed, nil
+	} else if !wfs.CanWrite(reqPath) {
+		return http.StatusForbidden, nil
+	}
+	if _, err := fs.Stat(wfs, reqPath); err != nil {
+		return http.StatusNotFound, err
+	}
+	return writeFailures(w, reqPath, removeAll(wfs, reqPath), http.StatusNoContent)
+}
+
+// handleCopyMove follows RFC 4918 9.8 and 9.9.
+// A COPY source may be anywhere (even inside an archive) but a MOVE source must be writable.
+func (h *Handler) handleCopyMove(w http.ResponseWriter, r *http.Request) (status int, err error) {
+	src, status, err := pathConvert(r.URL.Path)
+	if err != nil {
+		return status, err
+	}
+	wfs, ok := h.FS.(WriteFS)
+	if !ok {
+		return http.StatusMethodNotAllowed, nil
+	}
+
+	u, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil || r.Header.Get("Destination") == "" {
+		return http.StatusBadRequest, errInvalidDestination
+	} else if u.Host != "" && u.Host != r.Host {
+		return http.StatusBadGateway, errInvalidDestination
+	}
+	dst, status, err := pathConvert(u.Path)
+	if err != nil {
+		return status, err
+	}
+
+	overwrite := true
+	switch r.Header.Get("Overwrite") {
+	case "F":
+		overwrite = false
+	case "T", "":
+	default:
+		return http.StatusBadRequest, errInvalidOverwrite
+	}
+
+	depthInfinity := true
+	if r.Method == "COPY" {
+		switch r.Header.Get("Depth") {
+		case "0":
+			depthInfinity = false
+		case "infinity", "":
+		default:
+			return http.StatusBadRequest, errInvalidDepth
+		}
+	}
+
+	if !wfs.CanWrite(dst) || r.Method == "MOVE" && !wfs.CanWrite(src) {
+		return http.StatusForbidden, nil
+	} else if src == dst || strings.HasPrefix(dst, src+"/") {
+		return http.StatusForbidden, errDestinationWithinSource
+	}
+	if _, err := fs.Stat(wfs, src); err != nil {
+		return http.StatusNotFound, err
+	}
+
+	created := true
+	if _, err := fs.Stat(wfs, dst); err == nil {
+		if !overwrite {
+			return http.StatusPreconditionFailed, nil
+		}
+		created = false
+		if f := removeAll(wfs, dst); len(f) != 0 {
+			return writeFailures(w, dst, f, 0)
+		}
+	}
+
+	success := http.StatusCreated
+	if !created {
+		success = http.StatusNoContent
+	}
+	if r.Method == "MOVE" {
+		err := wfs.Rename(src, dst)
+		if err != nil {
+			return writeErrorStatus(err), err
+		}
+		return success, nil
+	}
+	return writeFailures(w, dst, copyAll(wfs, src, dst, depthInfinity), success)
+}
+
+// removeAll deletes depth-first, leaving the ancestors of any failure in place
+func removeAll(wfs WriteFS, name string) (failures []failure) {
+	fi, err := fs.Stat(wfs, name)
+	if err != nil {
+		return []failure{{name, http.StatusNotFound}}
+	}
+	if fi.IsDir() {
+		list, err := fs.ReadDir(wfs, name)
+		if err != nil {
+			return []failure{{name, writeErrorStatus(err)}}
+		}
+		for _, de := range list {
+			child := gopath.Join(name, de.Name())
+			if !wfs.CanWrite(child) {
+				continue // a virtual member (e.g. a mounted archive) goes away with its real file
+			}
+			failures = append(failures, removeAll(wfs, child)...)
+		}
+		if len(failures) != 0 {
+			return failures
+		}
+	}
+	err = wfs.Remove(name)
+	if err != nil {
+		return []failure{{name, writeErrorStatus(err)}}
+	}
+	return nil
+}
+
+func copyAll(wfs WriteFS, src, dst string, depthInfinity bool) (failures []failure) {
+	fi, err := fs.Stat(wfs, src)
+	if err != nil {
+		return []failure{{dst, http.StatusNotFound}}
+	}
+	if !fi.IsDir() {
+		f, err := wfs.Open(src)
+		if err != nil {
+			return []failure{{dst, http.StatusNotFound}}
+		}
+		defer f.Close()
+		err = wfs.Put(dst, f)
+		if err != nil {
+			return []failure{{dst, writeErrorStatus(err)}}
+		}
+		return nil
+	}
+
+	err = wfs.Mkdir(dst)
+	if err != nil {
+		return []failure{{dst, writeErrorStatus(err)}}
+	}
+	if !depthInfinity {
+		return nil
+	}
+	list, err := fs.ReadDir(wfs, src)
+	if err != nil {
+		return []failure{{dst, writeErrorStatus(err)}}
+	}
+	for _, de := range list {
+		child := gopath.Join(dst, de.Name())
+		if !wfs.CanWrite(child) {
+			continue // a virtual member that will reappear by itself
+		}
+		failures = append(failures, copyAll(wfs, gopath.Join(src, de.Name()), child, true)...)
+	}
+	return failures
+}
+
+// writeFailures answers with the success status if nothing failed,
+// a plain error status if only the request target failed,
+// or a multistatus listing the members that failed (RFC 4918 9.6.1)
+func writeFailures(w http.ResponseWriter, target string, failures []failure, success int) (status int, err error) {
+	switch {
+	case len(failures) == 0:
+		return success, nil
+	case len(failures) == 1 && failures[0].name == target:
+		return failures[0].status, nil
+	}
+
+	mw := multistatusWriter{w: w}
+	for _, f := range failures {
+		err := mw.write(&response{
+			Href:   []string{(&url.URL{Path: "/" + f.name}).EscapedPath()},
+			Status: fmt.Sprintf("HTTP/1.1 %d %s", f.status, StatusText(f.status)),
+		})
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+	}
+	err = mw.close()
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
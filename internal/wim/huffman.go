@@ -0,0 +1,39 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package wim
+
+// huffman is a canonical prefix code, decoded by looking up the next maxLen bits at once.
+// Shorter codes come first, and codes of the same length are in symbol order.
+type huffman struct {
+	maxLen int
+	table  []uint32 // symbol<<8 | length
+}
+
+func makeHuffman(lens []uint8, maxLen int) (huffman, error) {
+	h := huffman{maxLen: maxLen, table: make([]uint32, 1<<maxLen)}
+	code := 0
+	for l := 1; l <= maxLen; l++ {
+		for sym, sl := range lens {
+			if int(sl) != l {
+				continue
+			}
+			span := 1 << (maxLen - l)
+			if code+span > len(h.table) {
+				return huffman{}, ErrCorrupt
+			}
+			for i := range span {
+				h.table[code+i] = uint32(sym)<<8 | uint32(l)
+			}
+			code += span
+		}
+	}
+	return h, nil
+}
+
+// lookup returns the symbol and its length for the next maxLen bits,
+// or a zero length if there is no such code
+func (h *huffman) lookup(bits uint32) (int, int) {
+	e := h.table[bits]
+	return int(e >> 8), int(e & 0xff)
+}
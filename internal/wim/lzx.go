@@ -0,0 +1,265 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package wim
+
+import "encoding/binary"
+
+// LZX as WIM uses it: each 32 KiB chunk is compressed separately, with a 32 KiB window,
+// and the x86 call translation of the E8 byte is always applied with a nominal file size.
+// The bitstream must be buffered exactly as wimlib does, because an uncompressed block
+// discards whatever is left of the buffer to realign the stream.
+
+const (
+	lzxWindow       = 32768
+	lzxOffsetSlots  = 30
+	lzxMainSyms     = 256 + 8*lzxOffsetSlots
+	lzxLengthSyms   = 249
+	lzxPreSyms      = 20
+	lzxAlignedSyms  = 8
+	lzxMinMatch     = 2
+	lzxE8FileSize   = 12000000
+	lzxVerbatim     = 1
+	lzxAligned      = 2
+	lzxUncompressed = 3
+)
+
+var lzxSlotBase, lzxSlotBits = func() (base, nbits [lzxOffsetSlots]int) {
+	for s := range lzxOffsetSlots {
+		if s >= 4 {
+			nbits[s] = (s - 2) / 2
+			base[s] = base[s-1] + 1<<nbits[s-1]
+		} else {
+			base[s] = s
+		}
+	}
+	return
+}()
+
+type lzxBits struct {
+	src  []byte
+	pos  int
+	buf  uint64
+	left int
+}
+
+// ensure loads a 16-bit word if fewer than n bits remain (n is at most 16)
+func (b *lzxBits) ensure(n int) {
+	if b.left >= n {
+		return
+	}
+	var w uint16
+	if b.pos+2 <= len(b.src) {
+		w = binary.LittleEndian.Uint16(b.src[b.pos:])
+	}
+	b.pos += 2
+	b.buf = b.buf<<16 | uint64(w)
+	b.left += 16
+}
+
+func (b *lzxBits) bits(n int) int {
+	if n == 0 {
+		return 0
+	}
+	b.ensure(n)
+	b.left -= n
+	return int(b.buf>>b.left) & (1<<n - 1)
+}
+
+func (b *lzxBits) decode(h *huffman) (int, error) {
+	b.ensure(h.maxLen)
+	sym, n := h.lookup(uint32(b.buf>>(b.left-h.maxLen)) & (1<<h.maxLen - 1))
+	if n == 0 {
+		return 0, ErrCorrupt
+	}
+	b.left -= n
+	return sym, nil
+}
+
+func lzx(dst, src []byte) error {
+	b := &lzxBits{src: src}
+	var mainLens [lzxMainSyms]uint8
+	var lengthLens [lzxLengthSyms]uint8
+	recent := [3]int{1, 1, 1}
+
+	out := 0
+	for out < len(dst) {
+		typ := b.bits(3)
+		size := lzxWindow
+		if b.bits(1) == 0 {
+			size = b.bits(16)
+		}
+		if size == 0 {
+			return ErrCorrupt
+		}
+		end := min(out+size, len(dst))
+
+		switch typ {
+		case lzxUncompressed:
+			b.ensure(1)
+			b.left = 0 // realign, discarding 16 bits if already aligned
+			if b.pos+12 > len(src) {
+				return ErrCorrupt
+			}
+			for i := range recent {
+				recent[i] = int(binary.LittleEndian.Uint32(src[b.pos+4*i:]))
+			}
+			b.pos += 12
+			if b.pos+end-out > len(src) {
+				return ErrCorrupt
+			}
+			copy(dst[out:end], src[b.pos:])
+			b.pos += size + size&1
+			out = end
+			continue
+		case lzxVerbatim, lzxAligned:
+		default:
+			return ErrCorrupt
+		}
+
+		var aligned huffman
+		if typ == lzxAligned {
+			var lens [lzxAlignedSyms]uint8
+			for i := range lens {
+				lens[i] = uint8(b.bits(3))
+			}
+			var err error
+			if aligned, err = makeHuffman(lens[:], 7); err != nil {
+				return err
+			}
+		}
+		if err := b.readLens(mainLens[:256]); err != nil {
+			return err
+		}
+		if err := b.readLens(mainLens[256:]); err != nil {
+			return err
+		}
+		if err := b.readLens(lengthLens[:]); err != nil {
+			return err
+		}
+		mainCode, err := makeHuffman(mainLens[:], 16)
+		if err != nil {
+			return err
+		}
+		lengthCode, err := makeHuffman(lengthLens[:], 16)
+		if err != nil {
+			return err
+		}
+
+		for out < end {
+			sym, err := b.decode(&mainCode)
+			if err != nil {
+				return err
+			}
+			if sym < 256 {
+				dst[out] = byte(sym)
+				out++
+				continue
+			}
+			sym -= 256
+			length := sym & 7
+			slot := sym >> 3
+			if length == 7 {
+				extra, err := b.decode(&lengthCode)
+				if err != nil {
+					return err
+				}
+				length += extra
+			}
+			length += lzxMinMatch
+
+			var offset int
+			if slot < len(recent) {
+				offset = recent[slot]
+				recent[slot] = recent[0]
+			} else {
+				nbits := lzxSlotBits[slot]
+				var extra int
+				if typ == lzxAligned && nbits >= 3 {
+					extra = b.bits(nbits-3) << 3
+					a, err := b.decode(&aligned)
+					if err != nil {
+						return err
+					}
+					extra += a
+				} else {
+					extra = b.bits(nbits)
+				}
+				offset = lzxSlotBase[slot] + extra - 2
+				recent[2], recent[1] = recent[1], recent[0]
+			}
+			recent[0] = offset
+
+			if offset <= 0 || offset > out {
+				return ErrCorrupt
+			}
+			for range min(length, len(dst)-out) {
+				dst[out] = dst[out-offset]
+				out++
+			}
+		}
+	}
+	undoE8(dst)
+	return nil
+}
+
+// readLens updates code lengths with deltas and runs, themselves encoded with a "pretree"
+func (b *lzxBits) readLens(lens []uint8) error {
+	var preLens [lzxPreSyms]uint8
+	for i := range preLens {
+		preLens[i] = uint8(b.bits(4))
+	}
+	pre, err := makeHuffman(preLens[:], 15)
+	if err != nil {
+		return err
+	}
+	delta := func(old uint8, presym int) uint8 {
+		return uint8((int(old) - presym + 17) % 17)
+	}
+	for i := 0; i < len(lens); {
+		presym, err := b.decode(&pre)
+		if err != nil {
+			return err
+		}
+		run, val := 1, uint8(0)
+		switch presym {
+		case 17:
+			run = 4 + b.bits(4)
+		case 18:
+			run = 20 + b.bits(5)
+		case 19:
+			run = 4 + b.bits(1)
+			presym, err = b.decode(&pre)
+			if err != nil {
+				return err
+			} else if presym > 17 {
+				return ErrCorrupt
+			}
+			val = delta(lens[i], presym)
+		default:
+			val = delta(lens[i], presym)
+		}
+		for ; run > 0 && i < len(lens); run-- {
+			lens[i] = val
+			i++
+		}
+	}
+	return nil
+}
+
+// undoE8 converts the absolute call targets back to relative
+func undoE8(data []byte) {
+	le := binary.LittleEndian
+	for i := 0; i < len(data)-10; i++ {
+		if data[i] != 0xe8 {
+			continue
+		}
+		abs := int32(le.Uint32(data[i+1:]))
+		if abs >= 0 && abs < lzxE8FileSize {
+			le.PutUint32(data[i+1:], uint32(abs-int32(i)))
+		} else if abs < 0 && abs >= -int32(i) {
+			le.PutUint32(data[i+1:], uint32(abs+lzxE8FileSize))
+		}
+		i += 4
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package wim
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"github.com/elliotnunn/BeHierarchic/internal/sectionreader"
+)
+
+const maxInMemory = 1 << 30 // for the metadata and lookup table
+
+// resource gives random access to a resource, which is either stored
+// or divided into independently compressed chunks, listed in a table at its start
+func (w *wim) resource(r io.ReaderAt, res reshdr) (io.ReaderAt, error) {
+	switch {
+	case res.flags&resSpanned != 0:
+		return nil, ErrSplit
+	case res.flags&resSolid != 0:
+		return nil, ErrMethod // packed together with other resources, as in an ESD
+	case res.flags&resCompressed == 0:
+		return sectionreader.Section(r, res.offset, res.size), nil
+	case w.methodErr != nil:
+		return nil, w.methodErr
+	case w.decompress == nil:
+		return nil, ErrFormat
+	}
+	return &chunked{w: w, r: sectionreader.Section(r, res.offset, res.packed), packed: res.packed, size: res.size}, nil
+}
+
+// readAll reads a resource that the reader needs whole
+func (w *wim) readAll(r io.ReaderAt, res reshdr) ([]byte, error) {
+	if res.size > maxInMemory {
+		return nil, ErrFormat
+	}
+	ra, err := w.resource(r, res)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, res.size)
+	n, err := ra.ReadAt(buf, 0)
+	if n == len(buf) {
+		return buf, nil
+	} else if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return nil, err
+}
+
+type chunked struct {
+	w            *wim
+	r            io.ReaderAt
+	packed, size int64
+
+	mu      sync.Mutex
+	starts  []int64 // of each compressed chunk, and the end of the last one
+	cur     int64   // index of the chunk in buf
+	buf     []byte
+	loadErr error
+}
+
+func (c *chunked) nchunks() int64 { return (c.size + c.w.chunkSize - 1) / c.w.chunkSize }
+
+// loadTable reads the chunk table, whose offsets are relative to the end of the table
+// and omit the first chunk, which always starts there.
+func (c *chunked) loadTable() error {
+	n := c.nchunks()
+	entSize := int64(4)
+	if c.size > 0xffffffff {
+		entSize = 8
+	}
+	tableSize := (n - 1) * entSize
+	if n == 0 {
+		tableSize = 0
+	}
+	if tableSize > c.packed || tableSize > maxInMemory {
+		return ErrFormat
+	}
+	table := make([]byte, tableSize)
+	if k, err := c.r.ReadAt(table, 0); int64(k) != tableSize {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	c.starts = make([]int64, 0, n+1)
+	c.starts = append(c.starts, tableSize)
+	for ; len(table) > 0; table = table[entSize:] {
+		var off int64
+		if entSize == 4 {
+			off = int64(binary.LittleEndian.Uint32(table))
+		} else {
+			off = int64(binary.LittleEndian.Uint64(table))
+		}
+		if tableSize+off < c.starts[len(c.starts)-1] || tableSize+off > c.packed {
+			return ErrFormat
+		}
+		c.starts = append(c.starts, tableSize+off)
+	}
+	c.starts = append(c.starts, c.packed)
+	return nil
+}
+
+// chunk decompresses one chunk into c.buf (the lock must be held)
+func (c *chunked) chunk(i int64) error {
+	if c.buf != nil && c.cur == i {
+		return nil
+	}
+	usize := min(c.w.chunkSize, c.size-i*c.w.chunkSize)
+	src := make([]byte, c.starts[i+1]-c.starts[i])
+	if n, err := c.r.ReadAt(src, c.starts[i]); n != len(src) {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	dst := make([]byte, usize)
+	if int64(len(src)) == usize { // did not compress
+		copy(dst, src)
+	} else if err := c.w.decompress(dst, src); err != nil {
+		return err
+	}
+	c.cur, c.buf = i, dst
+	return nil
+}
+
+func (c *chunked) ReadAt(p []byte, off int64) (n int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.starts == nil && c.loadErr == nil {
+		c.loadErr = c.loadTable()
+	}
+	if c.loadErr != nil {
+		return 0, c.loadErr
+	}
+	for len(p) > 0 && off < c.size {
+		i := off / c.w.chunkSize
+		if err := c.chunk(i); err != nil {
+			return n, err
+		}
+		k := copy(p, c.buf[off-i*c.w.chunkSize:])
+		p = p[k:]
+		n += k
+		off += int64(k)
+	}
+	if len(p) > 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
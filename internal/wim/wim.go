@@ -0,0 +1,296 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+// Package wim reads Windows Imaging (WIM) files, as described in Microsoft's
+// "Windows Imaging File Format" and implemented by wimlib.
+// Each image is a directory named by its index. Resources compressed with XPRESS or LZX are supported,
+// but not the LZMS and solid resources of ESD files, nor images split across several files.
+package wim
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
+	"github.com/elliotnunn/BeHierarchic/internal/guard"
+)
+
+var (
+	ErrFormat  = errors.New("not a valid WIM file")
+	ErrMethod  = errors.New("WIM: unimplemented compression method")
+	ErrSplit   = errors.New("WIM: resource is in another part of a split WIM")
+	ErrCorrupt = errors.New("WIM: corrupt compressed data")
+)
+
+const (
+	headerSize = 208
+	magic      = "MSWIM\x00\x00\x00"
+
+	flagCompressed = 0x00000002
+	flagXpress     = 0x00020000
+	flagLZX        = 0x00040000
+
+	resFree       = 0x01
+	resMetadata   = 0x02
+	resCompressed = 0x04
+	resSpanned    = 0x08
+	resSolid      = 0x10
+
+	attrDirectory = 0x10
+	attrReparse   = 0x400
+
+	lookupEntrySize = 50
+	dentrySize      = 102
+	maxDepth        = 256
+)
+
+// IsWIM checks the 8-byte signature, which ESD files share
+func IsWIM(head []byte) bool {
+	return len(head) >= len(magic) && string(head[:len(magic)]) == magic
+}
+
+// New opens a WIM file
+func New(r io.ReaderAt) (fs.FS, error) {
+	return New2(r, r)
+}
+
+// New2 routes headers and data requests through different readers, to help exotic caching schemes
+func New2(headerReader, dataReader io.ReaderAt) (retfs fs.FS, reterr error) {
+	defer guard.Recover(&reterr, "WIM", nil)
+	h := make([]byte, headerSize)
+	if n, err := headerReader.ReadAt(h, 0); n != len(h) {
+		if err == io.EOF {
+			err = ErrFormat
+		}
+		return nil, err
+	} else if !IsWIM(h) || binary.LittleEndian.Uint32(h[8:]) != headerSize {
+		return nil, ErrFormat
+	}
+
+	le := binary.LittleEndian
+	w := &wim{chunkSize: int64(le.Uint32(h[20:]))}
+	switch flags := le.Uint32(h[16:]); {
+	case flags&flagCompressed == 0:
+	case flags&flagXpress != 0:
+		w.decompress = xpress
+	case flags&flagLZX != 0:
+		w.decompress = lzx
+		if w.chunkSize != lzxWindow {
+			w.methodErr = fmt.Errorf("%w: LZX with %d-byte chunks", ErrMethod, w.chunkSize)
+		}
+	default:
+		w.methodErr = fmt.Errorf("%w: flags %#x", ErrMethod, flags)
+	}
+	if w.chunkSize == 0 {
+		w.chunkSize = 32768
+	} else if w.chunkSize > 1<<26 {
+		return nil, ErrFormat
+	}
+	part := le.Uint16(h[40:])
+
+	fsys := fskeleton.New()
+	go w.populate(fsys, headerReader, dataReader, h, part)
+	return fsys, nil
+}
+
+type wim struct {
+	chunkSize  int64
+	decompress func(dst, src []byte) error
+	methodErr  error
+}
+
+type reshdr struct {
+	packed, offset, size int64
+	flags                byte
+}
+
+func parseReshdr(b []byte) reshdr {
+	le := binary.LittleEndian
+	return reshdr{
+		packed: int64(le.Uint64(b) & (1<<56 - 1)),
+		flags:  b[7],
+		offset: int64(le.Uint64(b[8:])),
+		size:   int64(le.Uint64(b[16:])),
+	}
+}
+
+func (w *wim) populate(fsys *fskeleton.FS, headerReader, dataReader io.ReaderAt, h []byte, part uint16) {
+	defer fsys.NoMore()
+	defer guard.Log("WIM", nil)
+	le := binary.LittleEndian
+
+	if xml, err := w.readAll(headerReader, parseReshdr(h[72:])); err == nil && len(xml) >= 2 {
+		if le.Uint16(xml) == 0xfeff { // byte order mark
+			xml = xml[2:]
+		}
+		u := make([]uint16, len(xml)/2)
+		binary.Decode(xml, le, u)
+		text := string(utf16.Decode(u))
+		fsys.CreateReaderAt("images.xml", 0, bytes.NewReader([]byte(text)), int64(len(text)), 0, time.Time{})
+	}
+
+	table, err := w.readAll(headerReader, parseReshdr(h[48:]))
+	if err != nil {
+		return
+	}
+	streams := make(map[[20]byte]reshdr)
+	var metadata []reshdr
+	for ; len(table) >= lookupEntrySize; table = table[lookupEntrySize:] {
+		res := parseReshdr(table)
+		if le.Uint16(table[24:]) != part {
+			res.flags |= resSpanned // not in this file, so unreadable
+		}
+		if res.flags&resMetadata != 0 {
+			metadata = append(metadata, res)
+			continue
+		}
+		streams[[20]byte(table[30:50])] = res
+	}
+
+	for i, res := range metadata {
+		meta, err := w.readAll(headerReader, res)
+		if err != nil {
+			continue
+		}
+		img := image{
+			w:       w,
+			fsys:    fsys,
+			meta:    meta,
+			streams: streams,
+			data:    dataReader,
+			idBase:  int64(i+1) << 40,
+			seen:    make(map[int64]bool),
+		}
+		img.walk(strconv.Itoa(i+1), rootOffset(meta), 0)
+	}
+}
+
+// rootOffset skips the security data that precedes the root directory entry
+func rootOffset(meta []byte) int64 {
+	if len(meta) < 8 {
+		return int64(len(meta))
+	}
+	return (int64(binary.LittleEndian.Uint32(meta)) + 7) &^ 7
+}
+
+type image struct {
+	w       *wim
+	fsys    *fskeleton.FS
+	meta    []byte
+	streams map[[20]byte]reshdr
+	data    io.ReaderAt
+	idBase  int64
+	seen    map[int64]bool // directory listings, which a damaged image could link in a loop
+}
+
+// walk adds the directory entry at off, and if it is a directory, its children
+func (img *image) walk(name string, off int64, depth int) {
+	d, ok := img.dentry(off)
+	if !ok || depth > maxDepth {
+		return
+	}
+	if depth > 0 {
+		if !fs.ValidPath(d.name) || strings.Contains(d.name, "/") {
+			return
+		}
+		name += "/" + d.name
+	}
+	id := img.idBase + off
+	switch {
+	case d.attr&attrReparse != 0:
+		return // junctions and symlinks, which would need the reparse data interpreted
+	case d.attr&attrDirectory != 0:
+		img.fsys.Mkdir(name, id, 0, d.mtime)
+		if img.seen[d.subdir] {
+			return
+		}
+		img.seen[d.subdir] = true
+		for child := d.subdir; child != 0; {
+			c, ok := img.dentry(child)
+			if !ok {
+				break
+			}
+			img.walk(name, child, depth+1)
+			child = c.next
+		}
+	case d.hash == [20]byte{}:
+		img.fsys.CreateReaderAt(name, id, bytes.NewReader(nil), 0, 0, d.mtime)
+	default:
+		res, ok := img.streams[d.hash]
+		if !ok {
+			img.fsys.CreateError(name, id, fs.ErrNotExist, 0, 0, d.mtime)
+		} else if r, err := img.w.resource(img.data, res); err != nil {
+			img.fsys.CreateError(name, id, err, res.size, 0, d.mtime)
+		} else {
+			img.fsys.CreateReaderAt(name, id, r, res.size, 0, d.mtime)
+		}
+	}
+}
+
+type dentry struct {
+	attr   uint32
+	subdir int64
+	next   int64 // sibling
+	mtime  time.Time
+	hash   [20]byte
+	name   string
+}
+
+// dentry parses the directory entry at off, which is followed by its alternate data streams
+func (img *image) dentry(off int64) (d dentry, ok bool) {
+	le := binary.LittleEndian
+	if off <= 0 || off+dentrySize > int64(len(img.meta)) {
+		return d, false
+	}
+	b := img.meta[off:]
+	length := int64(le.Uint64(b))
+	if length < dentrySize || off+length > int64(len(img.meta)) {
+		return d, false // includes the zero length that ends a directory
+	}
+	d.attr = le.Uint32(b[8:])
+	d.subdir = int64(le.Uint64(b[16:]))
+	d.mtime = filetime(le.Uint64(b[56:]))
+	d.hash = [20]byte(b[64:84])
+	nstreams := int(le.Uint16(b[96:]))
+	namelen := int64(le.Uint16(b[100:]))
+	if dentrySize+namelen > length {
+		return d, false
+	}
+	u := make([]uint16, namelen/2)
+	binary.Decode(b[dentrySize:], le, u)
+	d.name = string(utf16.Decode(u))
+
+	d.next = off + (length+7)&^7
+	for range nstreams {
+		if d.next+38 > int64(len(img.meta)) {
+			return d, false
+		}
+		s := img.meta[d.next:]
+		slen := int64(le.Uint64(s))
+		if slen < 38 {
+			return d, false
+		}
+		if le.Uint16(s[36:]) == 0 && d.hash == [20]byte{} { // the unnamed stream
+			d.hash = [20]byte(s[16:36])
+		}
+		d.next += (slen + 7) &^ 7
+	}
+	return d, true
+}
+
+// filetime converts 100 ns intervals since 1601
+func filetime(t uint64) time.Time {
+	if t == 0 {
+		return time.Time{}
+	}
+	const unixEpoch = 11644473600
+	return time.Unix(int64(t/1e7)-unixEpoch, int64(t%1e7)*100).UTC()
+}
@@ -0,0 +1,194 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package wim
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"unicode/utf16"
+)
+
+// bitWriter packs bits most significant first into 16-bit little-endian words
+type bitWriter struct {
+	words []uint16
+	n     int
+}
+
+func (w *bitWriter) put(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if w.n%16 == 0 {
+			w.words = append(w.words, 0)
+		}
+		if v>>i&1 != 0 {
+			w.words[len(w.words)-1] |= 0x8000 >> (w.n % 16)
+		}
+		w.n++
+	}
+}
+
+func (w *bitWriter) bytes() []byte {
+	var b []byte
+	for _, word := range w.words {
+		b = binary.LittleEndian.AppendUint16(b, word)
+	}
+	return b
+}
+
+func TestXpress(t *testing.T) {
+	src := make([]byte, 256)
+	src['a'/2] |= 2 << 4 // odd symbols in the high nibble
+	src['b'/2] |= 2
+	src[256/2] |= 1 // a match of 3 at offset 1
+	var w bitWriter
+	w.put(0b10, 2) // a
+	w.put(0b11, 2) // b
+	w.put(0b0, 1)  // match
+	w.put(0, 32)
+	src = append(src, w.bytes()...)
+
+	dst := make([]byte, 5)
+	if err := xpress(dst, src); err != nil {
+		t.Fatal(err)
+	} else if string(dst) != "abbbb" {
+		t.Errorf("got %q, want %q", dst, "abbbb")
+	}
+}
+
+// putLens writes a pretree of 20 five-bit codes, then each length as a delta from zero
+func putLens(w *bitWriter, lens []uint8) {
+	for range lzxPreSyms {
+		w.put(5, 4)
+	}
+	for _, l := range lens {
+		w.put((17-int(l))%17, 5)
+	}
+}
+
+func TestLZX(t *testing.T) {
+	var w bitWriter
+	// verbatim block: "abc", then a match of 3 at the most recent offset (1)
+	w.put(lzxVerbatim, 3)
+	w.put(0, 1)
+	w.put(6, 16)
+	main := make([]uint8, lzxMainSyms)
+	main['a'], main['b'], main['c'], main[256+1] = 2, 2, 2, 2
+	putLens(&w, main[:256])
+	putLens(&w, main[256:])
+	putLens(&w, make([]uint8, lzxLengthSyms))
+	w.put(0b00, 2)
+	w.put(0b01, 2)
+	w.put(0b10, 2)
+	w.put(0b11, 2)
+
+	// uncompressed block, which is realigned
+	w.put(lzxUncompressed, 3)
+	w.put(0, 1)
+	w.put(5, 16)
+	src := w.bytes()
+	src = append(src, make([]byte, 12)...) // recent offsets
+	src = append(src, "xyzzy\x00"...)      // padded to even length
+
+	dst := make([]byte, 11)
+	if err := lzx(dst, src); err != nil {
+		t.Fatal(err)
+	} else if string(dst) != "abccccxyzzy" {
+		t.Errorf("got %q, want %q", dst, "abccccxyzzy")
+	}
+}
+
+func TestUndoE8(t *testing.T) {
+	data := []byte("\xe8\x10\x00\x00\x00......\xe8\x10\x00\x00\x00...........")
+	undoE8(data)
+	if got := binary.LittleEndian.Uint32(data[12:]); got != 0x10-11 {
+		t.Errorf("got %#x, want %#x", got, 0x10-11)
+	}
+}
+
+func makeDentry(attr uint32, subdir int64, hash [20]byte, name string) []byte {
+	le := binary.LittleEndian
+	u := utf16.Encode([]rune(name))
+	d := make([]byte, dentrySize)
+	le.PutUint32(d[8:], attr)
+	le.PutUint64(d[16:], uint64(subdir))
+	le.PutUint64(d[56:], 126000000000000000) // 2000
+	copy(d[64:], hash[:])
+	le.PutUint16(d[100:], uint16(2*len(u)))
+	for _, c := range u {
+		d = le.AppendUint16(d, c)
+	}
+	d = append(d, 0, 0)
+	le.PutUint64(d, uint64(len(d)))
+	for len(d)%8 != 0 {
+		d = append(d, 0)
+	}
+	return d
+}
+
+func TestWIM(t *testing.T) {
+	le := binary.LittleEndian
+	content := []byte("hello from windows\n")
+	hash := sha1.Sum(content)
+
+	// metadata: security data, root, its children, "sub"'s children
+	meta := le.AppendUint32(nil, 8)
+	meta = le.AppendUint32(meta, 0)
+	rootAt := int64(len(meta))
+	rootLen := int64(len(makeDentry(attrDirectory, 0, [20]byte{}, "")))
+	childrenAt := rootAt + rootLen + 8
+	children := makeDentry(0, 0, hash, "hello.txt")
+	subAt := childrenAt + int64(len(children)) + int64(len(makeDentry(attrDirectory, 0, [20]byte{}, "sub"))) + 8
+	children = append(children, makeDentry(attrDirectory, subAt, [20]byte{}, "sub")...)
+	meta = append(meta, makeDentry(attrDirectory, childrenAt, [20]byte{}, "")...)
+	meta = append(meta, make([]byte, 8)...)
+	meta = append(meta, children...)
+	meta = append(meta, make([]byte, 8)...)
+	meta = append(meta, makeDentry(0, 0, [20]byte{}, "empty")...)
+	meta = append(meta, make([]byte, 8)...)
+
+	reshdr := func(b []byte, flags byte, off, size int) []byte {
+		b = le.AppendUint64(b, uint64(size)|uint64(flags)<<56)
+		b = le.AppendUint64(b, uint64(off))
+		return le.AppendUint64(b, uint64(size))
+	}
+	file := make([]byte, headerSize)
+	metaAt := len(file)
+	file = append(file, meta...)
+	contentAt := len(file)
+	file = append(file, content...)
+	tableAt := len(file)
+	file = reshdr(file, resMetadata, metaAt, len(meta))
+	file = le.AppendUint16(file, 1)
+	file = le.AppendUint32(file, 1)
+	file = append(file, make([]byte, 20)...)
+	file = reshdr(file, 0, contentAt, len(content))
+	file = le.AppendUint16(file, 1)
+	file = le.AppendUint32(file, 1)
+	file = append(file, hash[:]...)
+
+	copy(file, magic)
+	le.PutUint32(file[8:], headerSize)
+	le.PutUint32(file[20:], 32768)
+	le.PutUint16(file[40:], 1)
+	le.PutUint16(file[42:], 1)
+	le.PutUint32(file[44:], 1)
+	reshdr(file[48:48], 0, tableAt, 2*lookupEntrySize)
+
+	fsys, err := New(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := fs.ReadFile(fsys, "1/hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(got, content) {
+		t.Errorf("got %q, want %q", got, content)
+	}
+	if err := fstest.TestFS(fsys, "1/hello.txt", "1/sub/empty"); err != nil {
+		t.Error(err)
+	}
+}
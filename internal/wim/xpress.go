@@ -0,0 +1,91 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package wim
+
+import "encoding/binary"
+
+// xpress decodes a chunk of "LZ77+Huffman" as specified in [MS-XCA]:
+// a table of 512 four-bit code lengths, then a bitstream of 16-bit little-endian words
+// interleaved with the bytes of long match lengths
+func xpress(dst, src []byte) error {
+	if len(src) < 256+4 {
+		return ErrCorrupt
+	}
+	var lens [512]uint8
+	for i, b := range src[:256] {
+		lens[2*i], lens[2*i+1] = b&0xf, b>>4
+	}
+	h, err := makeHuffman(lens[:], 15)
+	if err != nil {
+		return err
+	}
+
+	pos := 256
+	read16 := func() uint32 {
+		if pos+2 > len(src) {
+			pos += 2
+			return 0 // past the end, which a valid stream never consumes
+		}
+		v := binary.LittleEndian.Uint16(src[pos:])
+		pos += 2
+		return uint32(v)
+	}
+	nextBits := read16()<<16 | read16()
+	extraBits := 16
+	consume := func(n int) {
+		nextBits <<= n
+		extraBits -= n
+		if extraBits < 0 {
+			nextBits |= read16() << -extraBits
+			extraBits += 16
+		}
+	}
+
+	out := 0
+	for out < len(dst) {
+		sym, n := h.lookup(nextBits >> (32 - 15))
+		if n == 0 {
+			return ErrCorrupt
+		}
+		consume(n)
+		if sym < 256 {
+			dst[out] = byte(sym)
+			out++
+			continue
+		}
+		sym -= 256
+		length := sym & 15
+		offsetBits := sym >> 4
+		if length == 15 {
+			if pos >= len(src) {
+				return ErrCorrupt
+			}
+			length = int(src[pos])
+			pos++
+			if length == 255 {
+				if pos+2 > len(src) {
+					return ErrCorrupt
+				}
+				length = int(binary.LittleEndian.Uint16(src[pos:]))
+				pos += 2
+				if length < 15 {
+					return ErrCorrupt
+				}
+				length -= 15
+			}
+			length += 15
+		}
+		length += 3
+		offset := int(nextBits>>(32-offsetBits)) + 1<<offsetBits
+		consume(offsetBits)
+		if offset > out {
+			return ErrCorrupt
+		}
+		for range min(length, len(dst)-out) {
+			dst[out] = dst[out-offset]
+			out++
+		}
+	}
+	return nil
+}
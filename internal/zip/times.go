@@ -7,6 +7,8 @@ package zip
 import (
 	"encoding/binary"
 	"time"
+
+	"github.com/elliotnunn/BeHierarchic/internal/walltime"
 )
 
 // msDosTimeToTime converts an MS-DOS date and time into a time.Time.
@@ -25,7 +27,7 @@ func msDosTimeToTime(dosDate, dosTime uint16) time.Time {
 		int(dosTime&0x1f*2),
 		0, // nanoseconds
 
-		time.UTC,
+		walltime.Zone, // an extra field may give the time in UTC instead
 	)
 }
 
@@ -24,13 +24,15 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/elliotnunn/BeHierarchic/internal/checksumreader"
 	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
+	"github.com/elliotnunn/BeHierarchic/internal/guard"
 )
 
 var (
 	ErrFormat    = errors.New("zip: not a valid zip file")
 	ErrAlgorithm = errors.New("zip: unsupported compression algorithm")
-	ErrChecksum  = errors.New("zip: checksum error")
+	ErrChecksum  = checksumreader.ErrChecksum
 	ErrNoSpanned = errors.New("zip: spanned archives not supported")
 )
 
@@ -40,7 +42,8 @@ func New(r io.ReaderAt, size int64) (fs.FS, error) {
 }
 
 // New2 routes headers and data requests through different readers, to help exotic caching schemes
-func New2(headerReader, dataReader io.ReaderAt, size int64) (fs.FS, error) {
+func New2(headerReader, dataReader io.ReaderAt, size int64) (retfs fs.FS, reterr error) {
+	defer guard.Recover(&reterr, "ZIP", nil)
 	eocd, err := getEOCD(headerReader, size)
 	if err != nil {
 		return nil, err
@@ -182,10 +185,10 @@ func New2(headerReader, dataReader io.ReaderAt, size int64) (fs.FS, error) {
 		}
 
 		var mode fs.FileMode
-		switch os {
-		case 3, 19: // Unix, Mac OS X
+		switch {
+		case (os == 3 || os == 19) && attrs>>16 != 0: // Unix, Mac OS X
 			mode = unixModeToFileMode(attrs >> 16)
-		case 0, 11, 14: // DOS, NTFS, VFAT
+		case os == 0 || os == 3 || os == 11 || os == 14 || os == 19: // DOS, NTFS, VFAT, or a Unix zipper that only set DOS attributes
 			mode = msdosModeToFileMode(attrs)
 		default:
 			if isdir {
@@ -196,12 +199,18 @@ func New2(headerReader, dataReader io.ReaderAt, size int64) (fs.FS, error) {
 
 		}
 
+		// Old Windows zippers mark some empty directories only by their DOS attributes
+		// and some entries with data have stray directory attributes
+		if mode.IsDir() && unpacked == 0 {
+			isdir = true
+		} else if mode.IsDir() && !isdir {
+			mode &^= fs.ModeDir
+		}
+
 		if mode&fs.ModeSymlink != 0 {
-			packedReader := &localHeaderReader{r: headerReader, offset: baseCorrection + loc, size: packed}
-			targbuf := make([]byte, packed)
-			n, _ := packedReader.ReadAt(targbuf, 0)
+			targbuf, ok := readSymlink(&localHeaderReader{r: headerReader, offset: baseCorrection + loc, size: packed}, method, packed, unpacked)
 			targ := ""
-			if n == len(targbuf) {
+			if ok {
 				targ = unicode(string(targbuf))
 				targ = path.Join(name, "..", targ)
 			}
@@ -214,23 +223,30 @@ func New2(headerReader, dataReader io.ReaderAt, size int64) (fs.FS, error) {
 		} else {
 			tasks = append(tasks, task{loc, func() {
 				fileOffset := baseCorrection + loc
+				sum := &fskeleton.Checksum{Algo: "crc32", Sum: binary.BigEndian.AppendUint32(nil, crc32)}
+				defer fsys.SetChecksum(name, sum)
+				located := &localHeaderReader{r: headerReader, offset: fileOffset, size: packed}
+				defer fsys.SetRaw(name, func() (fskeleton.Raw, error) {
+					start, err := located.start()
+					return fskeleton.Raw{Offset: start, Size: packed, Method: methodName(method)}, err
+				})
 				switch method {
 				case 0:
 					packedReader := &localHeaderReader{r: dataReader, offset: fileOffset, size: packed}
-					r := newChecksumReaderAt(packedReader, unpacked, crc32)
+					r := newChecksumReaderAt(packedReader, unpacked, crc32, sum)
 					fsys.CreateReaderAt(name, baseCorrection+loc, r, unpacked, mode, mtime)
 				case 8:
 					readerFunc := func() (io.ReadCloser, error) {
 						packedReader := &localHeaderReader{r: dataReader, offset: fileOffset, size: packed}
 						r := flate.NewReader(io.NewSectionReader(packedReader, 0, packed))
-						return newChecksumReader(r, unpacked, crc32), nil
+						return newChecksumReader(r, unpacked, crc32, sum), nil
 					}
 					fsys.CreateReadCloser(name, baseCorrection+loc, readerFunc, unpacked, mode, mtime)
 				case 12:
 					readerFunc := func() (io.Reader, error) {
 						packedReader := &localHeaderReader{r: dataReader, offset: fileOffset, size: packed}
 						r := bzip2.NewReader(io.NewSectionReader(packedReader, 0, packed))
-						return newChecksumReader(r, unpacked, crc32), nil
+						return newChecksumReader(r, unpacked, crc32, sum), nil
 					}
 					fsys.CreateReader(name, baseCorrection+loc, readerFunc, unpacked, mode, mtime)
 				default:
@@ -246,6 +262,46 @@ func New2(headerReader, dataReader io.ReaderAt, size int64) (fs.FS, error) {
 	return fsys, nil
 }
 
+// methodName follows the names in APPNOTE.TXT 4.4.5
+func methodName(method uint16) string {
+	switch method {
+	case 0:
+		return "store"
+	case 8:
+		return "deflate"
+	case 9:
+		return "deflate64"
+	case 12:
+		return "bzip2"
+	case 14:
+		return "lzma"
+	case 93:
+		return "zstd"
+	case 95:
+		return "xz"
+	default:
+		return fmt.Sprintf("method %d", method)
+	}
+}
+
+// readSymlink reads a symlink target, which is usually stored but some zippers deflate
+func readSymlink(packedReader io.ReaderAt, method uint16, packed, unpacked int64) ([]byte, bool) {
+	if unpacked > 4096 { // PATH_MAX, and protects from a lying header
+		return nil, false
+	}
+	var r io.Reader = io.NewSectionReader(packedReader, 0, packed)
+	switch method {
+	case 0:
+	case 8:
+		r = flate.NewReader(r)
+	default:
+		return nil, false
+	}
+	targ := make([]byte, unpacked)
+	_, err := io.ReadFull(r, targ)
+	return targ, err == nil
+}
+
 type localHeaderReader struct {
 	r      io.ReaderAt
 	offset int64
@@ -254,14 +310,10 @@ type localHeaderReader struct {
 	err    error
 }
 
-func (g *localHeaderReader) ReadAt(p []byte, off int64) (int, error) {
-	if off < 0 {
-		return 0, fs.ErrInvalid
-	}
-	if off >= g.size {
-		return 0, io.EOF
-	}
+func (g *localHeaderReader) Size() int64 { return g.size }
 
+// start returns the offset of the packed data, which follows the variable-length local header
+func (g *localHeaderReader) start() (int64, error) {
 	g.once.Do(func() {
 		buf := make([]byte, 30)
 		n, err := g.r.ReadAt(buf, g.offset)
@@ -275,9 +327,20 @@ func (g *localHeaderReader) ReadAt(p []byte, off int64) (int, error) {
 			int64(binary.LittleEndian.Uint16(buf[26:])) + // filename field
 			int64(binary.LittleEndian.Uint16(buf[28:])) // extra field
 	})
+	return g.offset, g.err
+}
 
-	if g.err != nil {
-		return 0, g.err
+func (g *localHeaderReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fs.ErrInvalid
+	}
+	if off >= g.size {
+		return 0, io.EOF
+	}
+
+	start, err := g.start()
+	if err != nil {
+		return 0, err
 	}
 
 	tooLong := false
@@ -286,7 +349,7 @@ func (g *localHeaderReader) ReadAt(p []byte, off int64) (int, error) {
 		tooLong = true
 	}
 
-	n, err := g.r.ReadAt(p, g.offset+off)
+	n, err := g.r.ReadAt(p, start+off)
 	if err == nil && tooLong {
 		err = io.EOF
 	}
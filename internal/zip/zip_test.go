@@ -7,13 +7,18 @@ package zip
 import (
 	gozip "archive/zip"
 	"bytes"
+	"compress/flate"
 	"embed"
+	"encoding/binary"
 	"encoding/hex"
+	"hash/crc32"
 	"io"
 	"io/fs"
 	"path"
 	"strings"
 	"testing"
+
+	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
 )
 
 //go:embed testdata
@@ -175,3 +180,93 @@ func TestEOCD(t *testing.T) {
 		return nil
 	})
 }
+
+func TestQuirks(t *testing.T) {
+	f, _ := zips.Open("testdata/mine/quirks.zip")
+	inf, _ := f.Stat()
+	defer f.Close()
+	fsys, err := New2(f.(io.ReaderAt), f.(io.ReaderAt), inf.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target, err := fs.ReadLink(fsys, "deflated")
+	if err != nil || target != "targetdeflated" {
+		t.Errorf("deflated symlink: target %q, %v", target, err)
+	}
+	if inf, err := fs.Stat(fsys, "nodirslash"); err != nil || !inf.IsDir() {
+		t.Errorf("empty directory without a slash: %v, %v", inf, err)
+	}
+	if inf, err := fs.Stat(fsys, "dosonly"); err != nil || inf.Mode() != 0o444 {
+		t.Errorf("DOS attributes from a Unix zipper: %v, %v", inf, err)
+	}
+}
+
+func TestChecksummed(t *testing.T) {
+	f, _ := zips.Open("testdata/mine/quirks.zip")
+	inf, _ := f.Stat()
+	defer f.Close()
+	fsys, err := New2(f.(io.ReaderAt), f.(io.ReaderAt), inf.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	member, err := fsys.Open("targetdeflated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer member.Close()
+	c, ok := member.(interface {
+		Checksummed() (string, []byte, bool)
+	})
+	if !ok {
+		t.Fatal("member does not report its checksum")
+	}
+	if _, _, verified := c.Checksummed(); verified {
+		t.Error("verified before being read")
+	}
+	data, err := io.ReadAll(member)
+	if err != nil {
+		t.Fatal(err)
+	}
+	algo, sum, verified := c.Checksummed()
+	if algo != "crc32" || binary.BigEndian.Uint32(sum) != crc32.ChecksumIEEE(data) || !verified {
+		t.Errorf("got %s %x verified=%v, want crc32 %08x verified", algo, sum, verified, crc32.ChecksumIEEE(data))
+	}
+}
+
+func TestRaw(t *testing.T) {
+	f, _ := zips.Open("testdata/mine/quirks.zip")
+	inf, _ := f.Stat()
+	defer f.Close()
+	fsys, err := New2(f.(io.ReaderAt), f.(io.ReaderAt), inf.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	member, err := fsys.Open("targetdeflated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer member.Close()
+	raw, err := member.(interface{ Raw() (fskeleton.Raw, error) }).Raw()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := io.ReadAll(member)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var packed io.Reader = io.NewSectionReader(f.(io.ReaderAt), raw.Offset, raw.Size)
+	switch raw.Method {
+	case "store":
+	case "deflate":
+		packed = flate.NewReader(packed)
+	default:
+		t.Fatalf("unexpected method %q", raw.Method)
+	}
+	got, err := io.ReadAll(packed)
+	if err != nil || !bytes.Equal(got, want) {
+		t.Errorf("raw %+v does not unpack to the contents: %v", raw, err)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package zoo
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+var errLZW = errors.New("ZOO: corrupt LZW data")
+
+const (
+	clearCode = 256
+	eofCode   = 257
+	firstFree = 258
+	maxBits   = 13
+)
+
+// lzd decodes ZOO's own LZW, whose codes of 9 to 13 bits are packed least significant bit first
+// without the block alignment of Unix compress, and whose stream ends with an explicit code
+func lzd(src io.Reader, size int64) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go lzdcopy(pw, src, size)
+	return pr
+}
+
+func lzdcopy(dst *io.PipeWriter, src io.Reader, size int64) {
+	var reterr error
+	br := bufio.NewReaderSize(src, 4096)
+	bw := bufio.NewWriterSize(dst, 4096)
+	defer func() {
+		if reterr == nil {
+			reterr = bw.Flush()
+		}
+		dst.CloseWithError(reterr)
+	}()
+
+	var bitbuf uint32
+	bitcount := 0
+	nbits := 9
+	getcode := func() (int, bool) {
+		for bitcount < nbits {
+			b, err := br.ReadByte()
+			if err != nil {
+				reterr = io.ErrUnexpectedEOF
+				return 0, false
+			}
+			bitbuf |= uint32(b) << bitcount
+			bitcount += 8
+		}
+		code := int(bitbuf & (1<<nbits - 1))
+		bitbuf >>= nbits
+		bitcount -= nbits
+		return code, true
+	}
+
+	write := func(b byte) bool {
+		if size == 0 {
+			return false
+		}
+		if reterr = bw.WriteByte(b); reterr != nil {
+			return false
+		}
+		size--
+		return true
+	}
+
+	var (
+		prefix   [1 << maxBits]uint16
+		suffix   [1 << maxBits]byte
+		stack    []byte
+		freeCode = firstFree
+		maxCode  = 1 << nbits
+		oldCode  = -1 // no previous code, as after a clear
+		finChar  byte
+	)
+	for size > 0 {
+		code, ok := getcode()
+		if !ok {
+			return
+		}
+		switch {
+		case code == eofCode:
+			return
+		case code == clearCode:
+			nbits, maxCode, freeCode, oldCode = 9, 1<<9, firstFree, -1
+			continue
+		case oldCode < 0:
+			if code > 255 {
+				reterr = errLZW
+				return
+			}
+			finChar, oldCode = byte(code), code
+			if !write(finChar) {
+				return
+			}
+			continue
+		}
+
+		inCode := code
+		if code >= freeCode {
+			if code > freeCode {
+				reterr = errLZW
+				return
+			}
+			stack = append(stack, finChar)
+			code = oldCode
+		}
+		for code > 255 {
+			stack = append(stack, suffix[code])
+			code = int(prefix[code])
+		}
+		finChar = byte(code)
+		stack = append(stack, finChar)
+		for i := len(stack) - 1; i >= 0; i-- {
+			if !write(stack[i]) {
+				return
+			}
+		}
+		stack = stack[:0]
+
+		if freeCode < 1<<maxBits {
+			prefix[freeCode] = uint16(oldCode)
+			suffix[freeCode] = finChar
+			freeCode++
+			if freeCode >= maxCode && nbits < maxBits {
+				nbits++
+				maxCode <<= 1
+			}
+		}
+		oldCode = inCode
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+// Package zoo reads the archives of Rahul Dhesi's ZOO.
+package zoo
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+
+	"github.com/elliotnunn/BeHierarchic/internal/dostime"
+	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
+	"github.com/elliotnunn/BeHierarchic/internal/guard"
+	"github.com/elliotnunn/BeHierarchic/internal/lzh"
+	"github.com/elliotnunn/BeHierarchic/internal/sectionreader"
+	"golang.org/x/text/encoding/charmap"
+)
+
+var (
+	ErrFormat = errors.New("not a valid ZOO archive")
+	ErrMethod = errors.New("ZOO: unimplemented compression method")
+)
+
+const (
+	tag        = 0xfdc4a7dc
+	headerSize = 34
+	entrySize  = 58 // including the fields that only a type 2 entry has, up to the long name
+	maxEntries = 1 << 20
+)
+
+// IsArchive checks the text at the start of the header, which ZOO always writes
+func IsArchive(head []byte) bool {
+	return len(head) >= 4 && string(head[:4]) == "ZOO "
+}
+
+// New opens an archive
+func New(r io.ReaderAt) (fs.FS, error) {
+	return New2(r, r)
+}
+
+// New2 routes headers and data requests through different readers, to help exotic caching schemes
+func New2(headerReader, dataReader io.ReaderAt) (retfs fs.FS, reterr error) {
+	defer guard.Recover(&reterr, "ZOO", nil)
+	h := make([]byte, headerSize)
+	if n, err := headerReader.ReadAt(h, 0); n != len(h) {
+		if err == io.EOF {
+			err = ErrFormat
+		}
+		return nil, err
+	}
+	le := binary.LittleEndian
+	if !IsArchive(h) || le.Uint32(h[20:]) != tag {
+		return nil, ErrFormat
+	}
+	fsys := fskeleton.New()
+	go populate(fsys, headerReader, dataReader, int64(le.Uint32(h[24:])))
+	return fsys, nil
+}
+
+// populate follows the linked list of directory entries,
+// stopping at the empty entry that ends it or at the first sign of damage
+func populate(fsys *fskeleton.FS, headerReader, dataReader io.ReaderAt, off int64) {
+	defer fsys.NoMore()
+	defer guard.Log("ZOO", &off)
+	le := binary.LittleEndian
+	seen := make(map[int64]bool)
+	for range maxEntries {
+		if seen[off] {
+			return // a loop
+		}
+		seen[off] = true
+		e := make([]byte, entrySize+256+256)
+		n, _ := headerReader.ReadAt(e, off)
+		if n < 51 || le.Uint32(e) != tag {
+			return
+		}
+		next := int64(le.Uint32(e[6:]))
+		if next == 0 {
+			return
+		}
+		id := off
+		off = next
+
+		typ, method := e[4], e[5]
+		start := int64(le.Uint32(e[10:]))
+		mtime := dostime.Time(le.Uint16(e[14:]), le.Uint16(e[16:]))
+		size := int64(le.Uint32(e[20:]))
+		packed := int64(le.Uint32(e[24:]))
+		if e[30]&1 != 0 { // deleted
+			continue
+		}
+
+		name, _, _ := strings.Cut(string(e[38:51]), "\x00")
+		if typ == 2 && n >= entrySize && le.Uint16(e[51:]) >= 2 {
+			namlen, dirlen := int(e[56]), int(e[57])
+			v := e[entrySize:]
+			if namlen+dirlen <= n-entrySize {
+				if namlen > 0 {
+					name, _, _ = strings.Cut(string(v[:namlen]), "\x00")
+				}
+				if dirlen > 0 {
+					dir, _, _ := strings.Cut(string(v[namlen:][:dirlen]), "\x00")
+					dir = strings.Trim(strings.ReplaceAll(dir, "\\", "/"), "/")
+					if dir != "" && dir != "." {
+						name = dir + "/" + name
+					}
+				}
+			}
+		}
+		name, _ = charmap.CodePage437.NewDecoder().String(name)
+		if !fs.ValidPath(name) {
+			continue
+		}
+
+		section := sectionreader.Section(dataReader, start, packed)
+		switch method {
+		case 0: // stored
+			fsys.CreateReaderAt(name, id, section, size, 0, mtime)
+		case 1: // LZW
+			opener := func() (io.ReadCloser, error) {
+				return lzd(io.NewSectionReader(section, 0, packed), size), nil
+			}
+			fsys.CreateReadCloser(name, id, opener, size, 0, mtime)
+		case 2: // LZH
+			opener := func() (io.ReadCloser, error) {
+				return lzh.NewReader(io.NewSectionReader(section, 0, packed), lzh.LH5, size), nil
+			}
+			fsys.CreateReadCloser(name, id, opener, size, 0, mtime)
+		default:
+			fsys.CreateError(name, id, fmt.Errorf("%w %d", ErrMethod, method), size, 0, mtime)
+		}
+	}
+}
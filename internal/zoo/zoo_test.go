@@ -0,0 +1,91 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package zoo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+// lzwCodes packs 9-bit codes, least significant bit first
+func lzwCodes(codes ...int) []byte {
+	var b []byte
+	n := 0
+	for _, c := range codes {
+		for i := range 9 {
+			if n%8 == 0 {
+				b = append(b, 0)
+			}
+			b[len(b)-1] |= byte(c>>i&1) << (n % 8)
+			n++
+		}
+	}
+	return b
+}
+
+type file struct {
+	method    byte
+	name, dir string
+	packed    []byte
+	size      int
+}
+
+func build(files []file) []byte {
+	le := binary.LittleEndian
+	ar := make([]byte, headerSize)
+	copy(ar, "ZOO 2.10 Archive.\x1a")
+	le.PutUint32(ar[20:], tag)
+	le.PutUint32(ar[24:], headerSize)
+
+	entry := func(f file, next, data int) []byte {
+		e := make([]byte, entrySize)
+		le.PutUint32(e, tag)
+		e[4], e[5] = 2, f.method
+		le.PutUint32(e[6:], uint32(next))
+		le.PutUint32(e[10:], uint32(data))
+		le.PutUint16(e[14:], 0x2a55) // 21 Feb 2001
+		le.PutUint32(e[20:], uint32(f.size))
+		le.PutUint32(e[24:], uint32(len(f.packed)))
+		copy(e[38:], "SHORT.NAM")
+		le.PutUint16(e[51:], uint16(2+len(f.name)+len(f.dir)))
+		e[56], e[57] = byte(len(f.name)), byte(len(f.dir))
+		return append(append(e, f.name...), f.dir...)
+	}
+	for _, f := range files {
+		elen := len(entry(f, 0, 0))
+		next := len(ar) + elen + len(f.packed)
+		ar = append(ar, entry(f, next, len(ar)+elen)...)
+		ar = append(ar, f.packed...)
+	}
+	return append(ar, entry(file{}, 0, 0)...)
+}
+
+func TestArchive(t *testing.T) {
+	ar := build([]file{
+		{0, "stored.txt", "", []byte("hello"), 5},
+		{1, "lzw.txt", "sub/dir", lzwCodes(clearCode, 'a', 'b', 258, eofCode), 4},
+	})
+	fsys, err := New(bytes.NewReader(ar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"stored.txt":      "hello",
+		"sub/dir/lzw.txt": "abab",
+	}
+	for name, content := range want {
+		got, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if string(got) != content {
+			t.Errorf("%s: got %q, want %q", name, got, content)
+		}
+	}
+	if err := fstest.TestFS(fsys, "stored.txt", "sub/dir/lzw.txt"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,394 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elliotnunn/BeHierarchic/internal/scratch"
+)
+
+// A job runs one of the expensive downloads in the background, instead of holding a connection open for an hour.
+//
+//	POST   /api/v1/jobs?kind=repack|export|validate&root=PATH[&format=FORMAT]
+//	GET    /api/v1/jobs
+//	GET    /api/v1/jobs/ID
+//	GET    /api/v1/jobs/ID/result
+//	DELETE /api/v1/jobs/ID
+//
+// POST answers 202 with the job's status, whose URL is also in the Location header.
+// The status has the number of bytes written so far, and once the job is done, the URL of the result,
+// which is kept for jobKeep and then deleted. DELETE cancels a job or deletes its result early.
+// The formats are those of /api/v1/repack (which the public address also streams), /api/v1/export and /api/v1/validate.
+//
+// Only the admin address serves these (see admin.go), and the results count against the scratch budget,
+// so a job whose result would not fit fails instead of filling the disk.
+
+const (
+	jobsPath  = "/api/v1/jobs"
+	jobKeep   = 24 * time.Hour
+	maxQueued = 100
+)
+
+// jobWorkers is how many jobs run at once, the rest waiting their turn
+var jobWorkers = 1
+
+// jobDir holds the results, which are unlinked as soon as they are created where the system allows
+var jobDir = os.TempDir()
+
+type jobKind struct {
+	formats  []string // the first is the default
+	dirOnly  bool
+	ctype    func(format string) string
+	filename func(root, format string) string
+	run      func(ctx context.Context, fsys *FS, root, format string, w io.Writer) error
+}
+
+var jobKinds = map[string]jobKind{
+	"repack": {
+		formats:  repackFormats,
+		dirOnly:  true,
+		ctype:    repackType,
+		filename: func(root, format string) string { return exportName(root) + "." + format },
+		run:      repack,
+	},
+	"export": {
+		formats:  exportFormats,
+		dirOnly:  true,
+		ctype:    func(string) string { return "application/x-tar" },
+		filename: func(root, format string) string { return exportName(root) + "-" + format + ".tar" },
+		run:      export,
+	},
+	"validate": {
+		formats:  []string{"ndjson"},
+		ctype:    func(string) string { return "application/x-ndjson" },
+		filename: func(root, format string) string { return exportName(root) + "-validate.ndjson" },
+		run: func(ctx context.Context, fsys *FS, root, format string, w io.Writer) error {
+			return validateTree(ctx, fsys, root, w)
+		},
+	},
+}
+
+type job struct {
+	id, kind, root, format string
+	created                time.Time
+	bytes                  atomic.Int64
+	ctx                    context.Context
+	cancel                 context.CancelFunc
+
+	// under jobs.mu
+	state             string // queued, running, done, failed or cancelled
+	started, finished time.Time
+	err               error
+	result            *jobResult
+}
+
+// jobResult is the output of a finished job, closed once the job is forgotten and nobody is still downloading it
+type jobResult struct {
+	f         *os.File
+	meter     *scratch.Meter
+	readers   int // under jobs.mu
+	forgotten bool
+}
+
+// release must be called with jobs.mu held
+func (res *jobResult) release() {
+	if res.forgotten && res.readers == 0 {
+		closeJobFile(res.f)
+		res.meter.Close()
+	}
+}
+
+var jobs struct {
+	mu    sync.Mutex
+	all   map[string]*job
+	queue chan *job
+	start sync.Once
+}
+
+type jobStatus struct {
+	ID       string    `json:"id"`
+	Kind     string    `json:"kind"`
+	Root     string    `json:"root"`
+	Format   string    `json:"format"`
+	State    string    `json:"state"`
+	Created  time.Time `json:"created"`
+	Started  time.Time `json:"started,omitzero"`
+	Finished time.Time `json:"finished,omitzero"`
+	Bytes    int64     `json:"bytes"`
+	Error    string    `json:"error,omitempty"`
+	Status   string    `json:"status"`
+	Result   string    `json:"result,omitempty"`
+}
+
+// status must be called with jobs.mu held
+func (j *job) status() jobStatus {
+	s := jobStatus{
+		ID:       j.id,
+		Kind:     j.kind,
+		Root:     j.root,
+		Format:   j.format,
+		State:    j.state,
+		Created:  j.created,
+		Started:  j.started,
+		Finished: j.finished,
+		Bytes:    j.bytes.Load(),
+		Status:   jobsPath + "/" + j.id,
+	}
+	if j.err != nil {
+		s.Error = j.err.Error()
+	}
+	if j.state == "done" {
+		s.Result = s.Status + "/result"
+	}
+	return s
+}
+
+func jobsAPI(fsys *FS, w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, jobsPath), "/")
+	id, sub, _ := strings.Cut(rest, "/")
+	switch {
+	case id == "" && r.Method == "POST":
+		submitJob(fsys, w, r)
+	case id == "" && (r.Method == "GET" || r.Method == "HEAD"):
+		jobs.mu.Lock()
+		list := make([]jobStatus, 0, len(jobs.all))
+		for _, j := range jobs.all {
+			list = append(list, j.status())
+		}
+		jobs.mu.Unlock()
+		slices.SortFunc(list, func(a, b jobStatus) int { return a.Created.Compare(b.Created) })
+		writeJSON(w, r, http.StatusOK, list)
+	case id == "":
+		http.Error(w, "use GET or POST", http.StatusMethodNotAllowed)
+	default:
+		jobs.mu.Lock()
+		j := jobs.all[id]
+		jobs.mu.Unlock()
+		if j == nil {
+			http.Error(w, "no such job", http.StatusNotFound)
+			return
+		}
+		switch {
+		case sub == "" && (r.Method == "GET" || r.Method == "HEAD"):
+			jobs.mu.Lock()
+			s := j.status()
+			jobs.mu.Unlock()
+			writeJSON(w, r, http.StatusOK, s)
+		case sub == "" && r.Method == "DELETE":
+			j.cancel()
+			forgetJob(j)
+			w.WriteHeader(http.StatusNoContent)
+		case sub == "result" && (r.Method == "GET" || r.Method == "HEAD"):
+			serveJobResult(j, w, r)
+		case sub == "" || sub == "result":
+			http.Error(w, "use GET or DELETE", http.StatusMethodNotAllowed)
+		default:
+			http.Error(w, "no such job", http.StatusNotFound)
+		}
+	}
+}
+
+func submitJob(fsys *FS, w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	kind, ok := jobKinds[q.Get("kind")]
+	if !ok {
+		http.Error(w, "kind must be one of repack, export, validate", http.StatusBadRequest)
+		return
+	}
+	root := strings.Trim(q.Get("root"), "/")
+	if root == "" {
+		root = "."
+	}
+	format := q.Get("format")
+	if format == "" {
+		format = kind.formats[0]
+	} else if !slices.Contains(kind.formats, format) {
+		http.Error(w, "format must be one of "+strings.Join(kind.formats, ", "), http.StatusBadRequest)
+		return
+	}
+	if stat, err := fs.Stat(fsys, root); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if kind.dirOnly && !stat.IsDir() {
+		http.Error(w, "can only do that to a directory", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{id: rand.Text()[:16], kind: q.Get("kind"), root: root, format: format,
+		created: time.Now(), ctx: ctx, cancel: cancel, state: "queued"}
+
+	jobs.start.Do(func() {
+		jobs.all = make(map[string]*job)
+		jobs.queue = make(chan *job, maxQueued)
+		for range max(jobWorkers, 1) {
+			go jobWorker(fsys)
+		}
+		go jobJanitor()
+	})
+	jobs.mu.Lock()
+	select {
+	case jobs.queue <- j:
+		jobs.all[j.id] = j
+	default:
+		jobs.mu.Unlock()
+		cancel()
+		http.Error(w, "too many jobs waiting", http.StatusServiceUnavailable)
+		return
+	}
+	s := j.status()
+	jobs.mu.Unlock()
+
+	slog.Info("jobQueued", "id", j.id, "kind", j.kind, "root", root, "format", format)
+	w.Header().Set("Location", s.Status)
+	writeJSON(w, r, http.StatusAccepted, s)
+}
+
+func jobWorker(fsys *FS) {
+	for j := range jobs.queue {
+		if j.ctx.Err() != nil {
+			continue // cancelled while queued
+		}
+		runJob(j.ctx, fsys, j)
+	}
+}
+
+func runJob(ctx context.Context, fsys *FS, j *job) {
+	f, err := os.CreateTemp(jobDir, "job-*.tmp")
+	if err == nil && runtime.GOOS != "windows" { // which cannot delete an open file
+		os.Remove(f.Name())
+	}
+	jobs.mu.Lock()
+	j.state, j.started = "running", time.Now()
+	jobs.mu.Unlock()
+	slog.Info("jobStart", "id", j.id)
+
+	var meter *scratch.Meter
+	if err == nil {
+		meter = scratchSpace.Meter(f)
+		err = jobKinds[j.kind].run(ctx, fsys, j.root, j.format, countingWriter{meter, &j.bytes})
+	}
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	} else if errors.Is(err, scratch.ErrFull) {
+		err = errJobTooLarge
+	}
+
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	j.finished = time.Now()
+	switch {
+	case errors.Is(err, context.Canceled):
+		j.state = "cancelled"
+	case err != nil:
+		j.state, j.err = "failed", err
+	default:
+		j.state, j.result = "done", &jobResult{f: f, meter: meter}
+		f = nil
+	}
+	if f != nil {
+		closeJobFile(f)
+		meter.Close()
+	}
+	slog.Info("jobFinish", "id", j.id, "state", j.state, "bytes", j.bytes.Load(), "dur", j.finished.Sub(j.started), "err", err)
+}
+
+var errJobTooLarge = errors.New("result larger than the scratch budget")
+
+func serveJobResult(j *job, w http.ResponseWriter, r *http.Request) {
+	jobs.mu.Lock()
+	res, state, finished := j.result, j.state, j.finished
+	if res != nil {
+		res.readers++ // so that forgetJob leaves it open until this is done
+	}
+	jobs.mu.Unlock()
+	if res == nil {
+		http.Error(w, "job is "+state, http.StatusConflict)
+		return
+	}
+	defer func() {
+		jobs.mu.Lock()
+		res.readers--
+		res.release()
+		jobs.mu.Unlock()
+	}()
+	kind := jobKinds[j.kind]
+	w.Header().Set("Content-Type", kind.ctype(j.format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, kind.filename(j.root, j.format)))
+	http.ServeContent(w, r, "", finished, io.NewSectionReader(res.f, 0, j.bytes.Load()))
+}
+
+// forgetJob removes a job and its result
+func forgetJob(j *job) {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	delete(jobs.all, j.id)
+	if j.result != nil {
+		j.result.forgotten = true
+		j.result.release()
+		j.result = nil
+	}
+}
+
+func closeJobFile(f *os.File) {
+	f.Close()
+	if runtime.GOOS == "windows" {
+		os.Remove(f.Name())
+	}
+}
+
+// jobJanitor forgets jobs finished more than jobKeep ago
+func jobJanitor() {
+	for range time.Tick(time.Minute) {
+		var old []*job
+		jobs.mu.Lock()
+		for _, j := range jobs.all {
+			if !j.finished.IsZero() && time.Since(j.finished) > jobKeep {
+				old = append(old, j)
+			}
+		}
+		jobs.mu.Unlock()
+		for _, j := range old {
+			forgetJob(j)
+		}
+	}
+}
+
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if r.Method == "HEAD" {
+		return
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+}
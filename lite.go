@@ -0,0 +1,233 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/pebble/v2"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Directory pages for browsers on period machines: HTML 3.2 without a viewport or a search form,
+// in a charset they understand, and linking by inode number ("/.i/1f")
+// because the nested archive paths overflow the URL buffers of some old browsers.
+
+const shortPrefix = "/.i/"
+
+// liteRequested is true for ?lite=1, or for a browser that predates Mozilla/5.0
+func liteRequested(r *http.Request) bool {
+	switch r.URL.Query().Get("lite") {
+	case "1":
+		return true
+	case "0":
+		return false
+	}
+	ua := r.UserAgent()
+	if v, ok := strings.CutPrefix(ua, "Mozilla/"); ok {
+		return len(v) > 0 && '1' <= v[0] && v[0] <= '4'
+	}
+	for _, old := range [...]string{"Lynx", "iCab", "Mosaic", "MacWeb", "Cyberdog", "Opera/3", "Opera/4"} {
+		if strings.Contains(ua, old) {
+			return true
+		}
+	}
+	return false
+}
+
+// liteCharset prefers UTF-8 if the browser admits to it, and otherwise the native charset of the platform
+func liteCharset(r *http.Request) (string, *charmap.Charmap) {
+	accept := strings.ToLower(r.Header.Get("Accept-Charset"))
+	for _, cs := range strings.Split(accept, ",") {
+		cs, _, _ = strings.Cut(cs, ";")
+		switch strings.TrimSpace(cs) {
+		case "utf-8":
+			return "utf-8", nil
+		case "x-mac-roman", "macintosh":
+			return "x-mac-roman", charmap.Macintosh
+		}
+	}
+	ua := r.UserAgent()
+	if strings.Contains(ua, "Mac") {
+		return "x-mac-roman", charmap.Macintosh
+	}
+	return "iso-8859-1", charmap.ISO8859_1
+}
+
+func liteDirPage(fsys *FS, w http.ResponseWriter, r *http.Request, pathname string) {
+	v := fsys.viewFor(r) // see generation.go
+	var hurried bool
+	if o, err := v.path(pathname); err == nil {
+		hurried = o.hurry(r.Context())
+	}
+	f, err := v.Open(pathname)
+	if err != nil {
+		failPage(fsys, w, pathname, err)
+		return
+	}
+	defer f.Close()
+	if d, ok := f.(*dir); ok {
+		d.hurried = hurried
+	}
+	d, ok := f.(fs.ReadDirFile)
+	if !ok {
+		http.Error(w, "could not assert fs.ReadDirFile", http.StatusNotFound)
+		return
+	}
+	list, listErr := d.ReadDir(-1)
+	indexing := isIncomplete(listErr)
+	if indexing {
+		listErr = nil // see below
+	}
+	list = withoutDirHeader(list)
+
+	title := "/"
+	if pathname != "." {
+		title = pathname
+	}
+	page := new(bytes.Buffer)
+	fmt.Fprint(page, `<!DOCTYPE HTML PUBLIC "-//W3C//DTD HTML 3.2 Final//EN">`+"\n")
+	fmt.Fprintf(page, "<HTML><HEAD><TITLE>%s</TITLE></HEAD><BODY>\n", htmlReplacer.Replace(title))
+	fmt.Fprint(page, "<H1>BeHierarchic</H1>\n<H2>")
+	fmt.Fprint(page, `<A HREF="/?lite=1">/</A>`)
+	if pathname != "." {
+		steps := strings.Split(pathname, "/")
+		for i := range steps {
+			fmt.Fprintf(page, `<A HREF="%s">%s</A>/`,
+				fsys.shortURL(strings.Join(steps[:i+1], "/"), true), htmlReplacer.Replace(steps[i]))
+		}
+	}
+	fmt.Fprint(page, "</H2>\n")
+	if o, err := fsys.path(pathname); err == nil {
+		page.Write(o.dirHeader())
+	}
+	fmt.Fprint(page, "<PRE>\n")
+	for _, de := range list {
+		slash := ""
+		if de.IsDir() {
+			slash = "/"
+		}
+		fmt.Fprintf(page, `<A HREF="%s">%s%s</A>`+"\n",
+			fsys.shortURL(childName(pathname, de.Name()), de.IsDir()),
+			htmlReplacer.Replace(de.Name()), slash)
+	}
+	if listErr != nil {
+		fmt.Fprintln(page, htmlReplacer.Replace(listErr.Error()))
+	}
+	fmt.Fprint(page, "</PRE>\n")
+	if indexing {
+		fmt.Fprint(page, "<P>Still indexing this archive, so the listing is incomplete. The page will refresh.</P>\n")
+		w.Header().Set("Refresh", "5")
+	}
+	fmt.Fprint(page, "</BODY></HTML>\n")
+
+	charset, cm := liteCharset(r)
+	body := page.Bytes()
+	if cm != nil {
+		body = liteEncode(cm, body)
+	}
+	w.Header().Set("Content-Type", "text/html; charset="+charset)
+	w.Header().Set("Vary", "User-Agent, Accept-Charset")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Write(body)
+}
+
+// liteEncode converts UTF-8 to a legacy charset, composing accents first because HFS names are decomposed.
+// Unmappable characters become "?", except that the mountpoint diamond has a lookalike in MacRoman.
+func liteEncode(cm *charmap.Charmap, utf []byte) []byte {
+	utf = norm.NFC.Bytes(utf)
+	ret := make([]byte, 0, len(utf))
+	for _, r := range string(utf) {
+		if b, ok := cm.EncodeRune(r); ok {
+			ret = append(ret, b)
+		} else if b, ok := cm.EncodeRune('◊'); ok && string(r) == Special {
+			ret = append(ret, b)
+		} else {
+			ret = append(ret, '?')
+		}
+	}
+	return ret
+}
+
+func childName(dir, name string) string {
+	if dir == "." {
+		return name
+	}
+	return dir + "/" + name
+}
+
+var shortPathKey = []byte("\xffino/path/")
+
+// shortURL links to a file by its inode number, noting the path for shortPage.
+// Without a database it falls back on the full path.
+func (fsys *FS) shortURL(name string, isDir bool) string {
+	long := "/" + urlenc(name)
+	if isDir {
+		long += "/?lite=1"
+	}
+	o, err := fsys.path(name)
+	if err != nil || fsys.db.Load() == nil {
+		return long
+	}
+	ino := o.inode(isDir)
+	key := binary.BigEndian.AppendUint64(bytes.Clone(shortPathKey), ino)
+	val, closer, err := fsys.db.Load().Get(key)
+	if err == nil {
+		same := string(val) == name
+		closer.Close()
+		if same {
+			return shortPrefix + strconv.FormatUint(ino, 36)
+		}
+	}
+	err = fsys.db.Load().Set(key, []byte(name), &pebble.WriteOptions{})
+	if err != nil {
+		slog.Error("setShortURLError", "path", name, "err", err)
+		return long
+	}
+	return shortPrefix + strconv.FormatUint(ino, 36)
+}
+
+// shortPage serves "/.i/INODE" as the lite listing of a directory or the contents of a file
+func shortPage(fsys *FS, files http.Handler, w http.ResponseWriter, r *http.Request) {
+	ino, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, shortPrefix), "/"), 36, 64)
+	if err != nil || fsys.db.Load() == nil {
+		http.NotFound(w, r)
+		return
+	}
+	key := binary.BigEndian.AppendUint64(bytes.Clone(shortPathKey), ino)
+	val, closer, err := fsys.db.Load().Get(key)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	name := string(val)
+	closer.Close()
+
+	// The host file might have moved on since the link was made
+	stat, err := fs.Stat(fsys, name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	} else if i, ok := stat.(inoder); !ok || i.Inode() != ino {
+		http.NotFound(w, r)
+		return
+	}
+
+	if stat.IsDir() {
+		liteDirPage(fsys, w, r, name)
+		return
+	}
+	r2 := r.Clone(r.Context())
+	r2.URL.Path = "/" + name
+	r2.URL.RawPath = ""
+	files.ServeHTTP(w, r2)
+}
@@ -0,0 +1,32 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"time"
+
+	"github.com/elliotnunn/BeHierarchic/internal/logsample"
+)
+
+// logPeriod is how often each warning may appear logBurst times before the rest are counted instead
+const logPeriod = time.Minute
+
+// sampleLogs stops any one warning appearing more than burst times a minute,
+// so that a corrupt collection cannot bury everything else in the log
+func sampleLogs(burst int) {
+	w, flags := log.Writer(), log.Flags()
+	h := logsample.New(slog.Default().Handler(), burst, logPeriod)
+	slog.SetDefault(slog.New(h))
+	// SetDefault sends the log package through h, but the default handler it wraps writes to the log package
+	log.SetOutput(w)
+	log.SetFlags(flags)
+	go func() {
+		for range time.Tick(logPeriod) {
+			h.Summarize(context.Background())
+		}
+	}()
+}
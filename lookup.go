@@ -0,0 +1,53 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// lookup is a link from the info page to an external database,
+// with {sha1}, {sha256} and {name} in the URL replaced by those of the file
+type lookup struct {
+	name, template string
+}
+
+// lookups can be changed with -lookup, and only VirusTotal is known to take a bare hash in its URL
+var lookups = []lookup{
+	{"VirusTotal", "https://www.virustotal.com/gui/file/{sha1}"},
+}
+
+// setLookup parses a -lookup flag of the form NAME=TEMPLATE, where an empty template removes NAME
+func setLookup(s string) error {
+	name, template, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("%s: expected NAME=URL", s)
+	}
+	lookups = slices.DeleteFunc(lookups, func(l lookup) bool { return l.name == name })
+	if template == "" {
+		return nil
+	}
+	if !strings.Contains(template, "{") {
+		return fmt.Errorf("%s: URL contains none of {sha1}, {sha256} or {name}", template)
+	}
+	lookups = append(lookups, lookup{name, template})
+	return nil
+}
+
+func lookupLinks(name string, sha1, sha256 []byte) []string {
+	r := strings.NewReplacer(
+		"{sha1}", hex.EncodeToString(sha1),
+		"{sha256}", hex.EncodeToString(sha256),
+		"{name}", url.QueryEscape(name))
+	var links []string
+	for _, l := range lookups {
+		links = append(links, fmt.Sprintf(`<a href="%s">%s</a>`,
+			htmlReplacer.Replace(r.Replace(l.template)), htmlReplacer.Replace(l.name)))
+	}
+	return links
+}
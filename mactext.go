@@ -0,0 +1,25 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import "net/http"
+
+// macTextCharset is the WHATWG name for MacRoman, which browsers decode, CR line endings and all
+const macTextCharset = "macintosh"
+
+// setMacTextType labels a Mac file of type TEXT, such as MPW source, as MacRoman text,
+// so that a browser shows it rather than downloading it as binary.
+// It is always plain text, whatever the extension, lest a TEXT file named .html run scripts from an archive.
+func setMacTextType(fsys *FS, w http.ResponseWriter, r *http.Request) {
+	o, err := fsys.path(pathOf(r))
+	if err != nil {
+		return
+	}
+	ad, err := o.sidecarInfo()
+	if err != nil || !ad.hasFinderInfo || string(ad.fileType[:]) != "TEXT" {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset="+macTextCharset)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+}
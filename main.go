@@ -5,13 +5,19 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"cmp"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"io/fs"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 	"unsafe"
@@ -19,12 +25,28 @@ import (
 	_ "net/http/pprof"
 
 	"github.com/bmatcuk/doublestar/v4"
+	"github.com/elliotnunn/BeHierarchic/internal/remotefs"
+	"github.com/elliotnunn/BeHierarchic/internal/scratch"
 	"github.com/elliotnunn/BeHierarchic/internal/webdavfs"
 )
 
 const hello = `BeHierarchic, the Retrocomputing Archivist's File Server
 
-Usage:  BeHierarchic [INTERFACE][:PORT] CACHE SHAREPOINT`
+Usage:  BeHierarchic [FLAGS] [INTERFACE][:PORT] CACHE SHAREPOINT
+        BeHierarchic [FLAGS] [INTERFACE][:PORT] CACHE http://OTHER-SERVER/[SUBDIR]
+        BeHierarchic snapshot CACHE SHAREPOINT OUT
+        BeHierarchic prefetch [-new] CACHE SHAREPOINT
+        BeHierarchic prefetch -dryrun [-v] SHAREPOINT
+        BeHierarchic audit [-root SUBDIR] CACHE SHAREPOINT DAT
+        BeHierarchic pin [-remove] CACHE SHAREPOINT PATH...
+        BeHierarchic repack [-format zip|tar.zst] CACHE SHAREPOINT PATH OUT
+        BeHierarchic warm [-j N] [-max N] URL LOGFILE|http://OLD-SERVER/
+        BeHierarchic selftest [-v] [-keep]
+        BeHierarchic stats [-root SUBDIR] [-json] CACHE SHAREPOINT
+        BeHierarchic cache repair CACHE`
+
+// scratchSpace is shared by every feature that needs temporary files of decompressed data
+var scratchSpace *scratch.Space
 
 func main() {
 	err := cmdLine(os.Args)
@@ -35,98 +57,333 @@ func main() {
 }
 
 func cmdLine(args []string) error {
-	if len(args) != 4 {
+	if len(args) > 1 && args[1] == "snapshot" {
+		return snapshotCmd(args[2:])
+	} else if len(args) > 1 && args[1] == "prefetch" {
+		return prefetchCmd(args[2:])
+	} else if len(args) > 1 && args[1] == "audit" {
+		return auditCmd(args[2:])
+	} else if len(args) > 1 && args[1] == "selftest" {
+		return selftestCmd(args[2:])
+	} else if len(args) > 1 && args[1] == "warm" {
+		return warmCmd(args[2:])
+	} else if len(args) > 1 && args[1] == "pin" {
+		return pinCmd(args[2:])
+	} else if len(args) > 1 && args[1] == "repack" {
+		return repackCmd(args[2:])
+	} else if len(args) > 1 && args[1] == "cache" {
+		return cacheCmd(args[2:])
+	} else if len(args) > 1 && args[1] == "stats" {
+		return statsCmd(args[2:])
+	}
+
+	flags := flag.NewFlagSet("BeHierarchic", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), hello)
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&curator, "curator", "", "`USER:PASSWORD` allowed to save searches to the front page, to pin files and to upload to the drop-box")
+	flags.StringVar(&dropbox, "dropbox", "", "`SUBDIR` of the sharepoint where the -curator may upload new archives over WebDAV")
+	flags.BoolVar(&hideNoise, "hidenoise", false, "hide .DS_Store, Icon\\r, Thumbs.db and __MACOSX from listings and search")
+	flags.StringVar(&collation, "sort", collationBytes, "`ORDER` of directory listings: "+strings.Join(collations, ", "))
+	flags.StringVar(&adminAddr, "admin", "", "`[INTERFACE]:PORT` to serve the profiler, /readyz and the pin, jobs, export, validate, audit, access, tasks and prefetch APIs on, which are never served on the public port")
+	flags.BoolVar(&strictReads, "strict", false, "read each file inside an archive through to its checksum before serving any of it, and answer 502 if it does not match")
+	flags.BoolVar(&recordAccess, "atime", false, "record when each file was last downloaded, for /api/v1/access")
+	flags.BoolVar(&exposeLayers, "layers", false, "show every layer of an archive in a single-member wrapper, such as the .tar in a .tar.gz, instead of just the innermost")
+	flags.BoolVar(&collapseTwins, "collapsetwins", false, "hide x.gz and x.bz2 beside x from listings, and serve x gzip-encoded to clients that accept it")
+	flags.StringVar(&sidecarStyle, "appledouble", sidecarSibling, "`STYLE` of the AppleDouble files within archives: "+strings.Join(sidecarStyles, ", "))
+	flags.BoolVar(&macSizes, "macsizes", false, "count each \"._\" AppleDouble file as the resource fork of its sibling in directory sizes, not as a file of its own")
+	flags.BoolVar(&requireCache, "requirecache", false, "refuse to start if the cache cannot be opened, instead of serving without it and trying again now and then")
+	scratchDir := flags.String("scratch", filepath.Join(os.TempDir(), "BeHierarchic"), "`DIR` for temporary decompressed data")
+	scratchMiB := flags.Int64("scratchsize", 4096, "`MIB` of temporary decompressed data allowed at once")
+	flags.IntVar(&taskLimit, "tasks", taskLimit, "`N` goroutines that requests may spawn at once to list and search in parallel, across the server")
+	flags.IntVar(&jobWorkers, "jobs", jobWorkers, "`N` background jobs from /api/v1/jobs to run at once")
+	flags.IntVar(&backfillWorkers, "backfill", 0, "`N` host files at once to compute the missing SHA-256 digests of, with everything inside them, after the startup index (0 for none)")
+	flags.Float64Var(&backfillRate, "backfillrate", 16, "`MIB` per second that -backfill may read, or 0 for no limit")
+	flags.DurationVar(&mountTimeout, "mounttimeout", mountTimeout, "how long to wait for an archive to be read before listing its directories partially, or 0 to wait forever")
+	flags.Func("format", "`RULE` to serve some archives as plain files: FORMAT=off, FORMAT<SIZE, or OUTER/FORMAT=off to match only inside another format (repeatable)", setFormatRule)
+	flags.Func("external", "`GLOB=COMMAND` to open archives that no built-in reader recognises, such as '*.sitx=unar -q -o {out} {in}': the archive is {in} or standard input, and the contents are what the command leaves in {out} or else its standard output (repeatable)", setExternal)
+	flags.Func("cpmformat", "`NAME:SECTORSIZE:SECTORS:TRACKS:BLOCKSIZE:DIRENTRIES:BOOTTRACKS[:SKEW]` of a CP/M disk format to try before the built-in ones (repeatable)", setCPMFormat)
+	flags.Func("tz", "`[SUBTREE=]ZONE` such as Asia/Tokyo or Local, in which to read the times that archives recorded without a zone, as DOS and the classic Mac OS did (repeatable; the default is UTC)", setZoneHint)
+	flags.Func("lookup", "`NAME=URL` of an external database to link from info pages, with {sha1}, {sha256} or {name} in the URL, or empty to remove a default (repeatable)", setLookup)
+	budgetGiB := flags.Int64("readbudget", 256, "`GIB` that one request may read before it is refused or aborted, or 0 for no limit")
+	logBurst := flags.Int("logburst", 10, "`N` times a minute that any one warning may appear before the rest are summarised, or 0 for no limit")
+	refresh := flags.Duration("refresh", 5*time.Minute, "`INTERVAL` between checks for changes when the sharepoint is the URL of another BeHierarchic server")
+	err := flags.Parse(args[1:])
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 3 {
 		return errors.New(hello)
 	}
 
-	port, cache, target := args[1], args[2], args[3]
+	port, cache, target := flags.Arg(0), flags.Arg(1), flags.Arg(2)
+	dropbox = strings.Trim(filepath.ToSlash(dropbox), "/")
 
-	s, err := os.Stat(target)
+	var root fs.FS
+	var remote *remotefs.FS
+	if isRemoteSharepoint(target) {
+		if dropbox != "" {
+			return errors.New("a drop-box needs a sharepoint on this server")
+		}
+		remote, err = remotefs.New(target, nil)
+		if err != nil {
+			return err
+		}
+		root = remote
+	} else {
+		s, err := os.Stat(target)
+		if err != nil {
+			return err
+		} else if !s.IsDir() {
+			return fmt.Errorf("%s: not a directory", target)
+		}
+		sharepoint = target
+		root = os.DirFS(target)
+	}
+
+	if !slices.Contains(collations, collation) {
+		return fmt.Errorf("%s: sort order must be one of %s", collation, strings.Join(collations, ", "))
+	}
+	if !slices.Contains(sidecarStyles, sidecarStyle) {
+		return fmt.Errorf("%s: AppleDouble style must be one of %s", sidecarStyle, strings.Join(sidecarStyles, ", "))
+	}
+
+	if dropbox != "" {
+		if curator == "" {
+			return errors.New("a drop-box needs a -curator, who alone may upload to it")
+		} else if !fs.ValidPath(dropbox) || dropbox == "." {
+			return fmt.Errorf("%s: drop-box must be a subdirectory", dropbox)
+		}
+		s, err := os.Stat(filepath.Join(target, filepath.FromSlash(dropbox)))
+		if err != nil {
+			return err
+		} else if !s.IsDir() {
+			return fmt.Errorf("%s: drop-box is not a directory", dropbox)
+		}
+	}
+
+	if *logBurst > 0 {
+		sampleLogs(*logBurst)
+	}
+	readBudget = *budgetGiB << 30
+	scratchSpace, err = scratch.New(*scratchDir, *scratchMiB<<20)
 	if err != nil {
 		return err
-	} else if !s.IsDir() {
-		return fmt.Errorf("%s: not a directory", target)
 	}
+	jobDir = *scratchDir
 
-	fsys := Wrapper(os.DirFS(target), cache)
-	go fsys.Prefetch()
+	fsys := Wrapper(root, cache)
+	if err := fsys.cacheErr(); err != nil && requireCache {
+		return fmt.Errorf("%s: %w", cache, err)
+	}
+	go func() {
+		fsys.Prefetch()
+		if backfillWorkers > 0 {
+			fsys.backfillForever()
+		}
+	}()
+	if remote != nil {
+		go fsys.refreshRemote(remote, *refresh)
+	}
+
+	return serve(fsys, port)
+}
 
+// handler answers every request, with the APIs, the HTML pages or WebDAV
+func handler(fsys *FS) http.Handler {
 	webdav := webdavfs.Handler{FS: fsys}
-	http.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	return instrument(fsys, budgeted(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch {
+		case isAdminPath(r.URL.Path):
+			http.NotFound(w, r) // see admin.go
+		case r.URL.Path == "/api/v1/search":
+			searchAPI(fsys, w, r)
+		case r.URL.Path == "/api/v1/diff":
+			diffAPI(fsys, w, r)
+		case r.URL.Path == "/api/v1/raw":
+			rawAPI(fsys, w, r)
+		case r.URL.Path == "/api/v1/repack":
+			repackAPI(fsys, w, r)
+		case r.URL.Path == "/api/v1/recent":
+			recentAPI(fsys, w, r)
+		case strings.HasPrefix(r.URL.Path, shortPrefix):
+			shortPage(fsys, &webdav, w, r)
+		case strings.HasSuffix(r.URL.Path, "/.info"):
+			infoPage(fsys, w, r)
+		case strings.HasSuffix(r.URL.Path, "/.manifest"):
+			manifestPage(fsys, w, r)
 		case strings.HasSuffix(r.URL.Path, "/.glob.html"):
 			searchPage(fsys, w, r)
+		case (r.Method == "GET" || r.Method == "HEAD") && strings.HasSuffix(r.URL.Path, "/") && liteRequested(r):
+			liteDirPage(fsys, w, r, pathOf(r))
 		case (r.Method == "GET" || r.Method == "HEAD") && strings.HasSuffix(r.URL.Path, "/"):
 			dirPage(fsys, w, r)
+		case (r.Method == "GET" || r.Method == "HEAD") && strictReads && strictRefused(fsys, w, r):
+		case (r.Method == "GET" || r.Method == "HEAD") && collapseTwins && serveTwin(fsys, w, r):
+		case dropboxRefused(w, r):
 		default:
-			webdav.ServeHTTP(w, r)
+			if r.Method == "GET" || r.Method == "HEAD" {
+				setMacTextType(fsys, w, r)
+				setDigestHeaders(fsys, w, r)
+			}
+			h := webdav
+			h.FS = davView{fsys.viewFor(r)} // see generation.go and partial.go
+			h.ServeHTTP(w, r)
 		}
-	}))
-	return http.ListenAndServe(port, nil)
+	})))
 }
 
-func dirPage(fsys *FS, w http.ResponseWriter, r *http.Request) {
+func pathOf(r *http.Request) string {
 	pathname := strings.Trim(r.URL.Path, "/")
 	if pathname == "" {
 		pathname = "."
 	}
+	return pathname
+}
+
+func dirPage(fsys *FS, w http.ResponseWriter, r *http.Request) {
+	pathname := pathOf(r)
+
+	w.Header().Set("Vary", "User-Agent") // see liteRequested
 
-	f, err := fsys.Open(pathname)
+	// Cheap revalidation for directories inside archives, without even listing them,
+	// unless the page carries a progress footer that will have changed
+	v := fsys.viewFor(r) // see generation.go
+	if o, err := v.path(pathname); err == nil && !fsys.prefetchStatus().Running {
+		if e, ok := o.cachedDirETag(); ok && e.notModified(r) {
+			w.Header().Set("ETag", e.etag)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	var hurried bool
+	if o, err := v.path(pathname); err == nil {
+		hurried = o.hurry(r.Context()) // see partial.go
+	}
+
+	f, err := v.Open(pathname)
 	if err != nil {
-		http.Error(w, err.Error(), 404)
+		failPage(fsys, w, pathname, err)
 		return
 	}
-	d, ok := f.(fs.ReadDirFile)
-	if !ok {
+	defer f.Close()
+	var o path
+	switch d := f.(type) {
+	case *dir:
+		o, d.hurried = d.path, hurried
+	case *syntheticDir:
+		o = d.path
+	default:
 		http.Error(w, "could not assert fs.ReadDirFile", 404)
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	fmt.Fprintf(w, "<!doctype html>\n")
-	fmt.Fprintf(w, "<meta name=\"viewport\" content=\"width=device-width\">\n")
-	fmt.Fprint(w, "<h1>BeHierarchic</h1>")
-	fmt.Fprint(w, "<h2>")
-	breadcrumb(w, pathname)
-	fmt.Fprint(w, "</h2>")
-	fmt.Fprintf(w, `<form action=".glob.html" method="GET">`+
+	list, listErr := f.(fs.ReadDirFile).ReadDir(-1)
+	indexing := isIncomplete(listErr)
+	if indexing {
+		listErr = nil // see below
+	}
+	var extra []string
+	if pathname == "." {
+		for _, s := range fsys.savedSearches() {
+			extra = append(extra, s.Name, s.Root, s.Pattern, s.Sort, strconv.Itoa(s.Days))
+		}
+	}
+	e := o.dirETag(list, indexing, extra...)
+	list = withoutDirHeader(list)
+
+	page := new(bytes.Buffer)
+	fmt.Fprintf(page, "<!doctype html>\n")
+	fmt.Fprintf(page, "<meta name=\"viewport\" content=\"width=device-width\">\n")
+	fmt.Fprint(page, "<h1>BeHierarchic</h1>")
+	fmt.Fprint(page, "<h2>")
+	breadcrumb(page, pathname)
+	fmt.Fprint(page, "</h2>")
+	fmt.Fprintf(page, `<form action=".glob.html" method="GET">`+
 		`<input type="text" name="q" size="50" placeholder="Pattern e.g. **/*.sit">`+
 		`<button type="submit">Glob Search</button></form>`)
-	fmt.Fprintf(w, "<pre>")
-	for {
-		list, err := d.ReadDir(100)
-		for _, de := range list {
-			slash := ""
-			if de.IsDir() {
-				slash = "/"
-			}
-			fmt.Fprintf(w, `<a href="%s%s%s">%s%s</a>`+"\n",
-				r.URL.Path, urlenc(de.Name()), slash,
-				htmlReplacer.Replace(de.Name()), slash)
-		}
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			fmt.Println(htmlReplacer.Replace(err.Error()))
+	page.Write(o.dirHeader())
+	if pathname == "." {
+		listSavedSearches(fsys, page)
+	}
+	fmt.Fprintf(page, "<pre>")
+	for _, de := range list {
+		slash := ""
+		if de.IsDir() {
+			slash = "/"
 		}
+		fmt.Fprintf(page, `<a href="%s%s%s">%s%s</a>`+"\n",
+			r.URL.Path, urlenc(de.Name()), slash,
+			htmlReplacer.Replace(de.Name()), slash)
+	}
+	if listErr != nil {
+		fmt.Fprintln(page, htmlReplacer.Replace(listErr.Error()))
+	}
+	fmt.Fprintf(page, "</pre>\n")
+	if indexing {
+		fmt.Fprintf(page, "<p>Still indexing this archive, so the listing is incomplete. The page will refresh.</p>\n")
+		w.Header().Set("Refresh", "5")
 	}
-}
 
-func searchPage(fsys *FS, w http.ResponseWriter, r *http.Request) {
-	pattern := r.URL.Query().Get("q")
-	if !doublestar.ValidatePattern(pattern) {
-		http.Error(w, "not a valid glob pattern", http.StatusNotFound)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if prefetchFooter(fsys, page) || indexing {
+		w.Header().Set("Cache-Control", "no-cache")
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(page.Bytes()))
 		return
 	}
+	w.Header().Set("ETag", e.etag)
+	http.ServeContent(w, r, "", e.modtime, bytes.NewReader(page.Bytes()))
+}
 
+func searchPage(fsys *FS, w http.ResponseWriter, r *http.Request) {
 	searchroot := strings.TrimSuffix(r.URL.Path, "/.glob.html")
 	searchroot = strings.TrimPrefix(searchroot, "/")
 	if searchroot == "" {
 		searchroot = "."
 	}
+
+	if r.Method == "POST" {
+		saveSearchPost(fsys, w, r, searchroot)
+		return
+	}
+
+	q := r.URL.Query()
+	sq := searchQuery{root: searchroot, pattern: q.Get("q"), sort: q.Get("sort"), after: q.Get("after"), limit: searchPageSize}
+	pattern := sq.pattern
+	if !doublestar.ValidatePattern(pattern) {
+		http.Error(w, "not a valid glob pattern", http.StatusNotFound)
+		return
+	}
+	if sq.sort != "" && !slices.Contains(searchSorts, sq.sort) {
+		http.Error(w, "sort must be one of "+strings.Join(searchSorts, ", "), http.StatusBadRequest)
+		return
+	}
+	if s := q.Get("limit"); s != "" {
+		var err error
+		sq.limit, err = strconv.Atoi(s)
+		if err != nil || sq.limit <= 0 || sq.limit > searchPageSize {
+			http.Error(w, fmt.Sprintf("limit must be from 1 to %d", searchPageSize), http.StatusBadRequest)
+			return
+		}
+	}
+	if s := q.Get("days"); s != "" {
+		var err error
+		sq.days, err = strconv.Atoi(s)
+		if err != nil || sq.days < 0 {
+			http.Error(w, "days must be a whole number", http.StatusBadRequest)
+			return
+		}
+	}
 	o, err := fsys.path(searchroot)
+	if err == nil {
+		var s fs.FileInfo
+		if s, err = fsys.Stat(searchroot); err == nil && !s.IsDir() {
+			err = &fs.PathError{Op: "search", Path: searchroot, Err: errors.New("not a directory")}
+		}
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		failPage(fsys, w, searchroot, err)
 		return
 	}
 
@@ -139,35 +396,131 @@ func searchPage(fsys *FS, w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, "</h2>")
 	fmt.Fprintf(w, `<form action=".glob.html" method="GET">`+
 		`<input type="text" name="q" value="%s" size="50" placeholder="Pattern e.g. **/*.sit">`+
-		`<button type="submit">Glob Search</button></form>`,
-		pattern)
+		`<select name="sort">`,
+		htmlReplacer.Replace(pattern))
+	for _, s := range append([]string{""}, searchSorts...) {
+		selected := ""
+		if s == sq.sort {
+			selected = " selected"
+		}
+		fmt.Fprintf(w, `<option value="%s"%s>%s</option>`, s, selected, cmp.Or(s, "any order"))
+	}
+	days := ""
+	if sq.days > 0 {
+		days = strconv.Itoa(sq.days)
+	}
+	fmt.Fprintf(w, `</select>`+
+		`<input type="number" name="days" value="%s" min="1" style="width:6em" placeholder="Any age">`+
+		` days old at most <button type="submit">Glob Search</button></form>`, days)
+	saveSearchForm(fsys, w, sq)
+	if pattern != "" {
+		fmt.Fprintf(w, `<p><a href="%s">Link to these results</a> `+
+			`<button type="button" onclick="navigator.clipboard.writeText(this.previousElementSibling.href)">Copy link</button>`,
+			htmlReplacer.Replace(sq.URL()))
+	}
 	fmt.Fprintf(w, "<pre>")
 
 	n := 0
 	t := time.Now()
+	cutoff := t.AddDate(0, 0, -sq.days) // evaluated afresh each time, so that a saved search stays current
+	skipping := sq.after != ""
+	var last string
 
-	// cutleft := len(searchroot) + 1
-	// if searchroot == "." {
-	// 	cutleft = 0
-	// }
+	// Results in the walk's order stream out as they are found, but sorted ones must wait for the walk
+	results := func(yield func(timedMatch) bool) {
+		for buf := range o.glob(r.Context(), pattern) {
+			if !yield(timedMatch{path: unsafeString(buf)}) {
+				return
+			}
+		}
+	}
+	if sq.sort != "" {
+		matches, truncated := o.globByTime(r.Context(), pattern, sq.sort == "oldest", false, 0)
+		if truncated {
+			fmt.Fprintf(w, "Sorted among only the matches found in the first %v, up to %d\n", sortedSearchWait, maxSortedMatches)
+		}
+		results = func(yield func(timedMatch) bool) {
+			for _, m := range matches {
+				if !yield(m) {
+					return
+				}
+			}
+		}
+	}
 
 	bw := bufio.NewWriter(w)
 	defer bw.Flush()
-	for buf := range o.glob(pattern) {
+	for m := range results {
+		if skipping {
+			skipping = m.path != sq.after
+			continue
+		}
+		if sq.days > 0 {
+			if sq.sort == "" {
+				m.mtime = o.matchModTime(m.path)
+			}
+			if m.mtime.Before(cutoff) {
+				continue
+			}
+		}
+		if n == sq.limit {
+			next := sq
+			next.after = last
+			fmt.Fprintf(bw, "<a href=\"%s\">More results</a>\n", htmlReplacer.Replace(next.URL()))
+			break
+		}
+		if sq.sort != "" {
+			bw.WriteString(m.mtime.UTC().Format(time.DateTime) + "  ")
+		}
 		bw.WriteString(`<a href="/`)
-		httpEscapePath(bw, buf)
+		httpEscapePath(bw, []byte(m.path))
 		bw.WriteString(`">`)
-		htmlReplacer.WriteString(bw, unsafeString(buf))
+		htmlReplacer.WriteString(bw, m.path)
 		bw.WriteString(`</a>` + "\n")
+		last = strings.Clone(m.path)
 		n++
-		if n == 2000 {
-			fmt.Fprintln(bw, "Limited results")
-			break
-		}
+	}
+	if skipping {
+		fmt.Fprintf(bw, "The result that this page continues from, %s, is no longer found\n", htmlReplacer.Replace(sq.after))
 	}
 	fmt.Fprintf(bw, "%d results in %s", n, time.Since(t))
 }
 
+// searchPageSize is the most results on one search page, which links to the next
+const searchPageSize = 2000
+
+// searchQuery is everything about a page of search results, so that its URL can be bookmarked and shared.
+// Pages after the first continue from the last result of the previous one,
+// which relies on glob returning results in the same order each time.
+type searchQuery struct {
+	root, pattern string
+	sort          string // see searchSorts, or empty for the walk's order
+	days          int    // only matches modified in the last so many days, or 0 for all
+	after         string
+	limit         int
+}
+
+func (sq searchQuery) URL() string {
+	v := url.Values{"q": {sq.pattern}}
+	if sq.sort != "" {
+		v.Set("sort", sq.sort)
+	}
+	if sq.days > 0 {
+		v.Set("days", strconv.Itoa(sq.days))
+	}
+	if sq.after != "" {
+		v.Set("after", sq.after)
+	}
+	if sq.limit != searchPageSize {
+		v.Set("limit", strconv.Itoa(sq.limit))
+	}
+	dir := "/"
+	if sq.root != "." {
+		dir = "/" + urlenc(sq.root) + "/"
+	}
+	return dir + ".glob.html?" + v.Encode()
+}
+
 func unsafeString(s []byte) string {
 	return unsafe.String(&s[0], len(s))
 }
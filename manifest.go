@@ -0,0 +1,188 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	gopath "path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// manifestPage lists a subtree recursively, for a mirror to learn what has changed without crawling the HTML.
+//
+//	GET /PATH/.manifest
+//
+// Each file or directory is a line {"path":"...","size":N,"mtime":"...","sha256":"..."}, relative to PATH
+// and in byte order of the path, and the final line is {"count":N}.
+// The sha256 is given only if it is already in the cache, because computing it for a big subtree
+// would hold the request (and the disk) for hours: a download, a search by hash or -backfill puts it there.
+// A member of an archive that records a checksum, such as a zip's CRC-32, also has
+// "stored":{"algo":"crc32","sum":"...","verified":true}, verified having been checked while computing the sha256.
+// Archives nested within the subtree are listed as files, not descended into.
+//
+// The ETag covers the paths, sizes, modtimes and digests, so a mirror that revalidates gets a 304
+// until something changes or another digest is cached.
+func manifestPage(fsys *FS, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	root := strings.Trim(strings.TrimSuffix(r.URL.Path, "/.manifest"), "/")
+	if root == "" {
+		root = "."
+	}
+	if _, err := fs.Stat(fsys, root); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	ctx := r.Context()
+	list, err := manifestList(ctx, fsys, root)
+	if err != nil {
+		if ctx.Err() == nil {
+			walkFailed(w, err)
+		}
+		return
+	}
+
+	for i := range list {
+		if ctx.Err() != nil {
+			return
+		}
+		if m := &list[i]; m.regular {
+			if o, err := fsys.path(gopath.Join(root, m.Path)); err == nil {
+				if d, ok := o.cachedSHA256(); ok {
+					m.SHA256 = hex.EncodeToString(d[:])
+				}
+				if algo, sum, verified := o.archiveChecksum(); algo != "" {
+					m.Stored = &manifestChecksum{algo, hex.EncodeToString(sum), verified}
+				}
+			}
+		}
+	}
+	e := manifestETag(list)
+	w.Header().Set("ETag", e.etag)
+	if !e.modtime.IsZero() {
+		w.Header().Set("Last-Modified", e.modtime.UTC().Format(http.TimeFormat))
+	}
+	if e.notModified(r) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if r.Method == "HEAD" {
+		return
+	}
+
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	for i := range list {
+		if ctx.Err() != nil {
+			return
+		}
+		if enc.Encode(&list[i]) != nil {
+			return // client has gone away
+		}
+	}
+	enc.Encode(struct {
+		Count int `json:"count"`
+	}{len(list)})
+}
+
+type manifestEntry struct {
+	regular bool
+	mtime   time.Time
+	Path    string            `json:"path"`
+	IsDir   bool              `json:"dir,omitempty"`
+	Symlink bool              `json:"symlink,omitempty"`
+	Size    *int64            `json:"size,omitempty"`
+	MTime   string            `json:"mtime"`
+	SHA256  string            `json:"sha256,omitempty"`
+	Stored  *manifestChecksum `json:"stored,omitempty"`
+}
+
+// manifestChecksum is the checksum that an archive records for its member
+type manifestChecksum struct {
+	Algo     string `json:"algo"`
+	Sum      string `json:"sum"`
+	Verified bool   `json:"verified"`
+}
+
+// manifestList walks the subtree without reading any file, in the order that the manifest wants
+func manifestList(ctx context.Context, fsys *FS, root string) ([]manifestEntry, error) {
+	var list []manifestEntry
+	err := fs.WalkDir(fsys, root, func(name string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		} else if isIncomplete(err) {
+			return err // rather than an answer that looks whole
+		} else if err != nil || name == root {
+			return nil // report what we can
+		} else if strings.HasSuffix(name, Special) {
+			return fs.SkipDir // list nested archives as files
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		m := manifestEntry{
+			regular: info.Mode().IsRegular(),
+			mtime:   info.ModTime(),
+			Path:    name,
+			IsDir:   info.IsDir(),
+			Symlink: info.Mode()&fs.ModeSymlink != 0,
+			MTime:   info.ModTime().UTC().Format(time.RFC3339Nano),
+		}
+		if root != "." {
+			m.Path = name[len(root)+1:]
+		}
+		if m.regular {
+			size := info.Size()
+			m.Size = &size
+		}
+		list = append(list, m)
+		return nil
+	})
+	slices.SortFunc(list, func(a, b manifestEntry) int { return strings.Compare(a.Path, b.Path) })
+	return list, err
+}
+
+func manifestETag(list []manifestEntry) dirETag {
+	var h xxhash.Digest
+	var newest time.Time
+	var buf [16]byte
+	for _, m := range list {
+		h.WriteString(m.Path)
+		h.Write([]byte{0})
+		var size int64 = -1
+		if m.Size != nil {
+			size = *m.Size
+		}
+		binary.BigEndian.PutUint64(buf[:], uint64(size))
+		binary.BigEndian.PutUint64(buf[8:], uint64(m.mtime.UnixNano()))
+		h.Write(buf[:])
+		h.WriteString(m.SHA256)
+		if m.mtime.After(newest) {
+			newest = m.mtime
+		}
+	}
+	return dirETag{
+		etag:    `W/"` + strconv.FormatUint(h.Sum64(), 36) + `"`,
+		modtime: newest,
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Files inside archives keep the modtimes that the archive recorded, so sorting a search by time
+// can answer "what was the last file changed on this disk image", which the host's mtimes cannot.
+
+// searchSorts are the orders a search can be asked for, besides the walk's own
+var searchSorts = []string{"newest", "oldest"}
+
+// maxSortedMatches bounds the memory of a sorted search with no limit
+const maxSortedMatches = 100000
+
+// sortedSearchWait bounds the walk of a sorted search, which must stat every match before giving any,
+// so that asking for the newest files of the whole sharepoint does not mount every archive in it
+const sortedSearchWait = 30 * time.Second
+
+type timedMatch struct {
+	path  string
+	mtime time.Time
+}
+
+// globByTime returns the matches of a glob, newest first or oldest first, then by path,
+// leaving out directories if filesOnly.
+// Only the first limit are kept (if limit > 0), and no more than maxSortedMatches are considered,
+// nor any found after sortedSearchWait, either of which makes it truncated.
+func (o path) globByTime(ctx context.Context, pattern string, oldest, filesOnly bool, limit int) (matches []timedMatch, truncated bool) {
+	ctx, cancel := context.WithTimeout(ctx, sortedSearchWait)
+	defer cancel()
+
+	compare := func(a, b timedMatch) int {
+		c := b.mtime.Compare(a.mtime)
+		if oldest {
+			c = -c
+		}
+		return cmp.Or(c, strings.Compare(a.path, b.path))
+	}
+	keep := maxSortedMatches
+	if limit > 0 {
+		keep = min(limit, keep)
+	}
+
+	n := 0
+	for buf := range o.glob(ctx, pattern) {
+		if limit <= 0 && n == maxSortedMatches {
+			truncated = true
+			break
+		}
+		name := string(buf)
+		m := timedMatch{path: name}
+		if p, err := o.container.path(name); err == nil {
+			if stat, err := p.cookedStat(); err == nil {
+				if filesOnly && stat.IsDir() {
+					continue
+				}
+				m.mtime = stat.ModTime()
+			}
+		}
+		n++
+		matches = append(matches, m)
+		if len(matches) >= 2*keep {
+			slices.SortFunc(matches, compare)
+			matches = matches[:keep]
+		}
+	}
+	if ctx.Err() != nil {
+		truncated = true
+	}
+	slices.SortFunc(matches, compare)
+	if len(matches) > keep {
+		matches = matches[:keep]
+	}
+	return matches, truncated
+}
+
+// matchModTime is the modtime of a match of o.glob, or the zero time if it cannot be had
+func (o path) matchModTime(name string) time.Time {
+	if p, err := o.container.path(name); err == nil {
+		if stat, err := p.cookedStat(); err == nil {
+			return stat.ModTime()
+		}
+	}
+	return time.Time{}
+}
+
+// recentAPI lists the most recently modified files under a directory, including those inside archives.
+//
+//	GET /api/v1/recent[?root=PATH][&limit=N]
+//
+// Each file is a line {"path":"...","mtime":"..."}, newest first, and there are 100 unless limit says otherwise.
+// The walk is bounded by sortedSearchWait, and if it was cut short the final line is {"truncated":true}.
+func recentAPI(fsys *FS, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	limit := 100
+	if s := q.Get("limit"); s != "" {
+		var err error
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit <= 0 || limit > maxSortedMatches {
+			http.Error(w, "not a valid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	root := strings.Trim(q.Get("root"), "/")
+	if root == "" {
+		root = "."
+	}
+	o, err := fsys.path(root)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if r.Method == "HEAD" {
+		return
+	}
+	matches, truncated := o.globByTime(r.Context(), "**", false, true, limit)
+	if r.Context().Err() != nil {
+		return
+	}
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	for _, m := range matches {
+		enc.Encode(timedLine(m))
+	}
+	if truncated {
+		enc.Encode(struct {
+			Truncated bool `json:"truncated"`
+		}{true})
+	}
+}
+
+type timedMatchLine struct {
+	Path  string `json:"path"`
+	MTime string `json:"mtime,omitempty"`
+}
+
+func timedLine(m timedMatch) timedMatchLine {
+	l := timedMatchLine{Path: m.path}
+	if !m.mtime.IsZero() {
+		l.MTime = m.mtime.UTC().Format(time.RFC3339)
+	}
+	return l
+}
@@ -0,0 +1,44 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"bytes"
+	"io/fs"
+	"slices"
+	"strings"
+)
+
+// hideNoise drops the litter of Finder and Explorer from listings and search results.
+// The files can still be opened by name.
+var hideNoise bool
+
+// isNoise matches one path component, ignoring a "._" sidecar prefix and a mountpoint suffix
+func isNoise(name string) bool {
+	name = strings.TrimPrefix(strings.TrimSuffix(name, Special), "._")
+	switch name {
+	case ".DS_Store", "Icon\r", "Thumbs.db", "__MACOSX":
+		return true
+	}
+	return false
+}
+
+// isNoisyPath is true if any component of a slash-separated path is noise
+func isNoisyPath(p []byte) bool {
+	for len(p) > 0 {
+		component, rest, _ := bytes.Cut(p, []byte("/"))
+		if isNoise(string(component)) { // no allocation
+			return true
+		}
+		p = rest
+	}
+	return false
+}
+
+func hideNoisyEntries(listing []fs.DirEntry) []fs.DirEntry {
+	if !hideNoise {
+		return listing
+	}
+	return slices.DeleteFunc(listing, func(de fs.DirEntry) bool { return isNoise(de.Name()) })
+}
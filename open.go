@@ -13,7 +13,9 @@ import (
 	"github.com/elliotnunn/BeHierarchic/internal/spinner"
 )
 
-func (fsys *FS) Open(name string) (f fs.File, err error) {
+func (fsys *FS) Open(name string) (fs.File, error) { return fsys.open(nil, name) }
+
+func (fsys *FS) open(s *session, name string) (f fs.File, err error) {
 	defer func() {
 		if err != nil {
 			err = &fs.PathError{Op: "open", Path: name, Err: err}
@@ -24,11 +26,21 @@ func (fsys *FS) Open(name string) (f fs.File, err error) {
 		return nil, fs.ErrInvalid
 	}
 
-	o, err := fsys.path(name)
+	raw, kind, err := unpresent(name)
+	if err != nil {
+		return nil, err
+	}
+	o, err := fsys.pathIn(s, raw)
 	if err != nil {
 		return nil, err
 	}
 
+	switch kind {
+	case presentAppleDouble:
+		return o.openAppleDouble()
+	case presentResourceFork:
+		return o.openResourceFork()
+	}
 	return o.cookedOpen()
 }
 
@@ -51,9 +63,15 @@ func (o path) cookedOpen() (fs.File, error) {
 
 	switch s.Mode() & fs.ModeType {
 	case 0: // regular file
-		if _, supportsRandomAccess := f.(io.ReaderAt); !supportsRandomAccess {
+		if pf, ok := o.openPinned(); ok {
+			f.Close()
+			f = pf
+		} else if _, supportsRandomAccess := f.(io.ReaderAt); !supportsRandomAccess {
 			f.Close()
 			f = &file{path: o}
+		} else if o.isRemote() {
+			cf := &cachingFile{path: o, randomAccessFile: f.(randomAccessFile)}
+			f = osFileBuffered{cf, io.NewSectionReader(cf, 0, s.Size())}
 		}
 	case fs.ModeDir:
 		rd, ok := f.(fs.ReadDirFile)
@@ -89,6 +107,9 @@ type dir struct {
 	obj   fs.ReadDirFile
 	list  []fs.DirEntry
 	lseek int
+	err   error // ErrIncomplete, given with the last of a listing that was cut short
+
+	hurried bool // for a directory page, which would rather list what there is than wait (see hurry)
 }
 
 func (d *dir) Stat() (fs.FileInfo, error) { return d.path.cookedStat() }
@@ -0,0 +1,98 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
+)
+
+// mountTimeout stops a pathological archive from holding up a directory listing indefinitely.
+// Once it has passed, the directories of the archive are listed as far as they have been read,
+// along with ErrIncomplete, and the archive keeps being read in the background.
+// A directory page hurries this along, and says that the archive is still being indexed.
+// WebDAV clients, which have nowhere else to show it, get a warning entry at the top instead.
+// Anything that walks the tree for a machine (a manifest, an export, a validation) fails rather than
+// giving an answer that looks whole.
+var mountTimeout = 10 * time.Second
+
+const partialName = "⚠ listing incomplete, archive still being read"
+
+func (o path) setPatience(fsys fs.FS) {
+	if fskel, ok := fsys.(*fskeleton.FS); ok && mountTimeout > 0 {
+		fskel.SetPatience(mountTimeout)
+	}
+}
+
+func isIncomplete(err error) bool { return errors.Is(err, fskeleton.ErrIncomplete) }
+
+// partialDirEntry is the warning entry, which cannot be opened
+type partialDirEntry struct{}
+
+func (de partialDirEntry) Name() string               { return partialName }
+func (de partialDirEntry) Type() fs.FileMode          { return fs.ModeIrregular }
+func (de partialDirEntry) IsDir() bool                { return false }
+func (de partialDirEntry) Info() (fs.FileInfo, error) { return de, nil }
+
+func (de partialDirEntry) Size() int64        { return 0 }
+func (de partialDirEntry) Mode() fs.FileMode  { return fs.ModeIrregular | 0o444 }
+func (de partialDirEntry) ModTime() time.Time { return time.Time{} }
+func (de partialDirEntry) Sys() any           { return nil }
+
+// davView is the view given to WebDAV, which puts the warning entry at the top of a partial listing
+type davView struct{ view }
+
+func (v davView) ReadDir(name string) ([]fs.DirEntry, error) {
+	list, err := v.view.ReadDir(name)
+	if isIncomplete(err) {
+		return slices.Insert(list, 0, fs.DirEntry(partialDirEntry{})), nil
+	}
+	return list, err
+}
+
+// indexingWait is how long a directory page waits for an archive to finish being read,
+// before showing what there is so far, rather than waiting out the whole mountTimeout
+const indexingWait = time.Second
+
+// hurry is for a person waiting on a directory page, who would rather see a partial listing that refreshes itself.
+// It waits a little for the archive, then reports whether the listing should be read without waiting any longer
+// (see dir.hurried), which leaves every other reader of the archive as patient as before.
+func (o path) hurry(ctx context.Context) bool {
+	fskel, ok := o.fsys.(*fskeleton.FS)
+	if !ok || fskel.Complete(o.name.String()) {
+		return false
+	}
+	select {
+	case <-fskel.Watch():
+		return false
+	case <-ctx.Done():
+	case <-time.After(indexingWait):
+	}
+	return true
+}
+
+// hurriedReadDir lists a directory with only the files found so far, if the archive is still being read
+func (o path) hurriedReadDir() ([]fs.DirEntry, error) {
+	if fskel, ok := o.fsys.(*fskeleton.FS); ok {
+		return fskel.ReadDirNow(o.name.String())
+	}
+	return o.rawReadDir()
+}
+
+// walkFailed answers a request for a machine-readable walk of the tree that failed,
+// telling the client to retry if it was only an archive still being read
+func walkFailed(w http.ResponseWriter, err error) {
+	if isIncomplete(err) {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
@@ -1,19 +1,23 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"iter"
-	gopath "path"
 	"runtime"
 	"slices"
 	"strings"
 	"sync"
+	"unicode/utf8"
 	"unsafe"
 
 	"github.com/bmatcuk/doublestar/v4"
+	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
 	"github.com/elliotnunn/BeHierarchic/internal/internpath"
+	"golang.org/x/text/unicode/norm"
 )
 
 // A generalisation of a "file path"
@@ -47,6 +51,9 @@ func (o path) ShallowJoin(p string) path { o.name = o.name.Join(p); return o }
 // Open opens the raw file (no archive-browsing decorations) for the benefit of reader2readerat
 func (o path) Open() (fs.File, error) { return o.fsys.Open(o.name.String()) }
 
+// ReaderGroup stops one archive with thousands of members from hogging the spinner's open readers
+func (o path) ReaderGroup() any { return o.fsys }
+
 // pathRenderer converts a [path] to a textual path.
 //
 // When similar paths are encountered successively, the cost of allocations and locking is amortized.
@@ -80,13 +87,13 @@ outerloop:
 		r.nupaths = append(r.nupaths, o)
 		if o.name == (internpath.Path{}) {
 			o.container.rMu.RLock()
-			archive := o.container.reverse[o.fsys]
+			archive := o.container.mountedFrom(o.fsys)
 			o.container.rMu.RUnlock()
-			r.put("/", archive.name.PutBase, Special)
+			r.put(archive.name, Special)
 			o = archive.Thick(o.container)
 			o.name = o.name.Dir()
 		} else {
-			r.put("/", o.name.PutBase, "")
+			r.put(o.name, "")
 			o.name = o.name.Dir()
 		}
 	}
@@ -123,24 +130,17 @@ func (r *pathRenderer) grow() {
 	r.buf = buf2
 }
 
-// put inserts text on the leftmost side of the "right" field.
-// fn is function that copies text to a buffer, returning the length,
-// or 0 to request a retry with a larger buffer.
-func (r *pathRenderer) put(prefix string, fn func([]byte) int, suffix string) {
-	for {
-		if len(r.buf)-r.left-r.right > len(prefix)+len(suffix) {
-			n := fn(r.buf[r.left+len(prefix) : len(r.buf)-r.right-len(suffix)])
-			if n > 0 {
-				total := len(prefix) + n + len(suffix)
-				copy(r.buf[r.left:], prefix)
-				copy(r.buf[r.left+len(prefix)+n:], suffix)
-				copy(r.buf[len(r.buf)-r.right-total:][:total], r.buf[r.left:])
-				r.right += total
-				return
-			}
-		}
+// put inserts a slash, a path component and a suffix on the leftmost side of the "right" field.
+func (r *pathRenderer) put(name internpath.Path, suffix string) {
+	total := 1 + name.BaseLen() + len(suffix)
+	for len(r.buf)-r.left-r.right < total {
 		r.grow()
 	}
+	dst := r.buf[len(r.buf)-r.right-total:]
+	dst[0] = '/'
+	n := 1 + name.PutBase(dst[1:])
+	copy(dst[n:], suffix)
+	r.right += total
 }
 
 func (r *pathRenderer) allToLeft() {
@@ -155,15 +155,31 @@ func (r *pathRenderer) allToLeft() {
 // Consider using a pathRenderer instead.
 func (o path) String() string {
 	o.container.rMu.RLock()
-	defer o.container.rMu.RUnlock()
-	warps := []string{o.name.String()}
+	warps := []internpath.Path{o.name}
 	thin := o.Thin()
 	for thin.fsys != o.container.root {
-		thin = o.container.reverse[thin.fsys]
-		warps = append(warps, thin.name.String()+Special)
+		thin = o.container.mountedFrom(thin.fsys)
+		warps = append(warps, thin.name)
 	}
-	slices.Reverse(warps)
-	return gopath.Join(warps...)
+	o.container.rMu.RUnlock()
+
+	var buf []byte
+	for i, name := range slices.Backward(warps) {
+		if i == 0 && name == (internpath.Path{}) {
+			break // the root of an archive
+		}
+		if len(buf) > 0 {
+			buf = append(buf, '/')
+		}
+		buf = name.AppendTo(buf)
+		if i > 0 {
+			buf = append(buf, Special...)
+		}
+	}
+	if len(buf) == 0 {
+		return "."
+	}
+	return unsafe.String(&buf[0], len(buf))
 }
 
 func (o path) deepWalk() iter.Seq2[path, fs.FileMode] {
@@ -233,7 +249,10 @@ func (o path) flatWalk() iter.Seq2[path, fs.FileMode] {
 // path turns a string into our internal path representation
 //
 // Nonexistent paths might, but won't always, return fs.ErrNotExist
-func (fsys *FS) path(name string) (path, error) {
+func (fsys *FS) path(name string) (path, error) { return fsys.pathIn(nil, name) }
+
+// pathIn resolves a path through the archives that a session has already been into, see generation.go
+func (fsys *FS) pathIn(s *session, name string) (path, error) {
 	warps := strings.Split(name, Special+"/")
 	if strings.HasSuffix(name, Special) {
 		warps[len(warps)-1] = strings.TrimSuffix(warps[len(warps)-1], Special)
@@ -241,14 +260,114 @@ func (fsys *FS) path(name string) (path, error) {
 	}
 
 	p := fsys.rootPath()
-	for _, el := range warps[:len(warps)-1] {
-		var isar bool
-		isar, p = p.ShallowJoin(el).getArchive(true, true)
-		if !isar {
-			return path{}, fs.ErrNotExist
+	var layers []thinPath // hidden layers, which an older path might still name
+	if len(warps) > 1 {
+		key := strings.Join(warps[:len(warps)-1], Special+"/")
+		start := 0
+		var host path
+		var gen uint64
+		if i, r, ok := s.lookup(warps); ok {
+			if g, ok := r.host.mountGeneration(); !ok || g != r.gen {
+				s.reset(fsys.generation())
+				return path{}, errStaleSession
+			}
+			start, p, layers, host, gen = i, r.p, r.layers, r.host, r.gen
+		} else if r, ok := fsys.getResolved(key); ok {
+			start, p, layers, host, gen = len(warps)-1, r.p, r.layers, r.host, r.gen
+		}
+		if start < len(warps)-1 {
+		warp:
+			for _, el := range warps[start : len(warps)-1] {
+				for _, v := range normVariants(el) {
+					if isar, mnt := p.ShallowJoin(v).getArchive(true, true); isar {
+						if p.fsys == fsys.root {
+							host = p.ShallowJoin(v)
+							gen, _ = host.mountGeneration()
+						}
+						p = mnt
+						layers = fsys.hiddenLayers(p.fsys)
+						continue warp
+					}
+				}
+				if len(layers) > 0 && slices.Contains(normVariants(el), layers[0].name.String()) {
+					layers = layers[1:]
+					continue
+				}
+				return path{}, fs.ErrNotExist
+			}
+			if start == 0 { // else it began in an archive that other sessions might no longer see
+				fsys.setResolved(key, resolved{p, layers, host, gen})
+			}
+		}
+		s.remember(key, resolved{p, layers, host, gen})
+	}
+	last := warps[len(warps)-1]
+	if len(layers) > 0 && slices.Contains(normVariants(last), layers[0].name.String()) {
+		if _, err := p.ShallowJoin(last).rawStat(); errors.Is(err, fs.ErrNotExist) {
+			return layers[0].Thick(fsys), nil
+		}
+	}
+	variants := normVariants(last)
+	for i, v := range variants {
+		if fsk, ok := p.fsys.(*fskeleton.FS); ok && fsk.DefinitelyAbsent(v) {
+			continue // without interning a name that will never be used again
+		}
+		o := p.ShallowJoin(v)
+		if i == len(variants)-1 {
+			return o, nil
+		} else if _, err := o.rawStat(); !errors.Is(err, fs.ErrNotExist) {
+			return o, nil
+		}
+	}
+	return path{}, fs.ErrNotExist
+}
+
+// resolved is the archive that a path leads to up to its last Special,
+// remembered so that repeated requests for a deeply nested file skip probing every archive on the way
+type resolved struct {
+	p      path
+	layers []thinPath // shared, do not modify
+	host   path       // the archive on the host that the path first went into
+	gen    uint64     // the generation in which host was probed, see generation.go
+}
+
+const maxResolved = 4096 // beyond which an arbitrary one is forgotten
+
+func (fsys *FS) getResolved(key string) (resolved, bool) {
+	fsys.wMu.RLock()
+	defer fsys.wMu.RUnlock()
+	r, ok := fsys.resolved[key]
+	return r, ok
+}
+
+func (fsys *FS) setResolved(key string, r resolved) {
+	fsys.wMu.Lock()
+	defer fsys.wMu.Unlock()
+	if len(fsys.resolved) >= maxResolved {
+		for k := range fsys.resolved {
+			delete(fsys.resolved, k)
+			break
 		}
 	}
-	return p.ShallowJoin(warps[len(warps)-1]), nil
+	fsys.resolved[key] = r
+}
+
+// normVariants lists a name followed by its NFC and NFD forms, if they differ.
+// Names from HFS are decomposed, names from most other places are composed,
+// and a browser sends whichever it was given, so a link copied between clients might not match.
+func normVariants(name string) []string {
+	variants := []string{name}
+	for i := range len(name) {
+		if name[i] >= utf8.RuneSelf {
+			for _, form := range [...]norm.Form{norm.NFC, norm.NFD} {
+				if v := form.String(name); !slices.Contains(variants, v) {
+					variants = append(variants, v)
+				}
+			}
+			break
+		}
+	}
+	return variants
 }
 
 func (fsys *FS) rootPath() path { return path{fsys, fsys.root, internpath.Path{}} }
@@ -259,7 +378,8 @@ func (fsys *FS) rootPath() path { return path{fsys, fsys.root, internpath.Path{}
 // Returned buffers are only valid until the next iteration.
 // Effort is made to return results in a deterministic order.
 // Effort is made to be fast, although with questionable success.
-func (o path) glob(pattern string) iter.Seq[[]byte] {
+// The walk stops early if ctx is cancelled, even if no matches are being found.
+func (o path) glob(ctx context.Context, pattern string) iter.Seq[[]byte] {
 	return func(yield func([]byte) bool) {
 		ignorePrefix := 0
 		if str := o.String(); str != "." {
@@ -267,9 +387,24 @@ func (o path) glob(pattern string) iter.Seq[[]byte] {
 		}
 		pattern, dironly := strings.CutSuffix(pattern, "/")
 
+		// A search counts as one task, and has as many extra workers as there are free slots
+		if waitTask(ctx) != nil {
+			return
+		}
+		defer doneTask()
+		nworker := 1
+		for nworker < runtime.GOMAXPROCS(-1) && tryTask() {
+			nworker++
+		}
+		defer func() {
+			for range nworker - 1 {
+				doneTask()
+			}
+		}()
+
 		// Set up channels
 		const batch = 128 // tuned
-		nworker := runtime.GOMAXPROCS(-1)
+
 		cancel := make(chan struct{}) // channel sends should also wait on this channel
 
 		// The first goroutine sends batches of paths on these channels in a round-robin
@@ -287,7 +422,8 @@ func (o path) glob(pattern string) iter.Seq[[]byte] {
 
 		// Acquire paths and batch them into generous work units
 		go func() {
-			pull, _ := iter.Pull2(o.deepWalk())
+			pull, stop := iter.Pull2(o.deepWalk())
+			defer stop()
 
 			defer func() {
 				for wkr := range nworker {
@@ -307,6 +443,8 @@ func (o path) glob(pattern string) iter.Seq[[]byte] {
 							select {
 							case <-cancel:
 								return
+							case <-ctx.Done():
+								return
 							case pathChan[wkr] <- batch:
 								return
 							}
@@ -316,6 +454,8 @@ func (o path) glob(pattern string) iter.Seq[[]byte] {
 					select {
 					case <-cancel:
 						return
+					case <-ctx.Done():
+						return
 					case pathChan[wkr] <- batch:
 					}
 				}
@@ -341,6 +481,9 @@ func (o path) glob(pattern string) iter.Seq[[]byte] {
 						}
 
 						relpath := buf[ignorePrefix:]
+						if hideNoise && isNoisyPath(relpath) {
+							continue
+						}
 						unsafeString := unsafe.String(&relpath[0], len(relpath))
 						if doublestar.MatchUnvalidated(pattern, unsafeString) {
 							bufs[i] = buf
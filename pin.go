@@ -0,0 +1,320 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/cockroachdb/pebble/v2"
+)
+
+// A curator can pin a file within an archive that is about to be downloaded heavily (after being featured somewhere).
+// It is decompressed in full into the pinned directory beside the cache database, and opened from there
+// until it is unpinned, or until an archive on the way to it changes.
+// Nothing else removes a pinned file.
+
+const pinPrefix = "\xffpin/" // + textual path, and the value is the dbkey of the file when it was pinned
+
+var errPinLocal = errors.New("already a file on local disk, so pinning would not help")
+
+func (fsys *FS) pinFile(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return filepath.Join(fsys.pinDir, hex.EncodeToString(sum[:]))
+}
+
+func (fsys *FS) loadPins() {
+	fsys.pins = make(map[string][]byte)
+	iter, err := fsys.db.Load().NewIter(&pebble.IterOptions{
+		LowerBound: []byte(pinPrefix),
+		UpperBound: []byte(pinPrefix + "\xff"),
+	})
+	if err != nil {
+		slog.Error("pinLoadError", "err", err)
+		return
+	}
+	defer iter.Close()
+	for iter.First(); iter.Valid(); iter.Next() {
+		fsys.pins[string(iter.Key()[len(pinPrefix):])] = slices.Clone(iter.Value())
+	}
+}
+
+// pinned lists the textual paths of the pinned files, in order
+func (fsys *FS) pinned() []string {
+	fsys.pMu.RLock()
+	defer fsys.pMu.RUnlock()
+	names := make([]string, 0, len(fsys.pins))
+	for name := range fsys.pins {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func (fsys *FS) pin(name string) (size int64, err error) {
+	if fsys.db.Load() == nil {
+		return 0, errNoDB
+	}
+	o, err := fsys.path(name)
+	if err != nil {
+		return 0, err
+	}
+	if o.fsys == o.container.root {
+		return 0, errPinLocal
+	} else if s, err := o.rawStat(); err != nil {
+		return 0, err
+	} else if !s.Mode().IsRegular() {
+		return 0, fs.ErrInvalid
+	}
+	name = o.String()
+
+	f, err := o.cookedOpen()
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	err = os.MkdirAll(fsys.pinDir, 0o755)
+	if err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(fsys.pinDir, "*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name()) // harmless once renamed
+	size, err = io.Copy(tmp, f)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), fsys.pinFile(name))
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	key := dbkey(o)
+	defer discardkey(key)
+	err = fsys.db.Load().Set([]byte(pinPrefix+name), key, pebble.Sync)
+	if err != nil {
+		return 0, err
+	}
+	fsys.pMu.Lock()
+	fsys.pins[name] = slices.Clone(key)
+	fsys.pMu.Unlock()
+	slog.Info("pin", "path", name, "size", size)
+	return size, nil
+}
+
+func (fsys *FS) unpin(name string) error {
+	if fsys.db.Load() == nil {
+		return errNoDB
+	}
+	o, err := fsys.path(name)
+	if err == nil {
+		name = o.String()
+	}
+	fsys.pMu.Lock()
+	_, ok := fsys.pins[name]
+	delete(fsys.pins, name)
+	fsys.pMu.Unlock()
+	if !ok {
+		return fs.ErrNotExist
+	}
+	err = fsys.db.Load().Delete([]byte(pinPrefix+name), pebble.Sync)
+	if err != nil {
+		return err
+	}
+	slog.Info("unpin", "path", name)
+	return os.Remove(fsys.pinFile(name))
+}
+
+type pinnedFile struct {
+	*os.File
+	stat fs.FileInfo
+}
+
+func (f pinnedFile) Stat() (fs.FileInfo, error) { return f.stat, nil }
+
+// openPinned opens the pinned copy of a file, if it has one that is not stale
+func (o path) openPinned() (fs.File, bool) {
+	fsys := o.container
+	if o.fsys == fsys.root {
+		return nil, false
+	}
+	fsys.pMu.RLock()
+	n := len(fsys.pins)
+	fsys.pMu.RUnlock()
+	if n == 0 {
+		return nil, false
+	}
+
+	name := o.String()
+	fsys.pMu.RLock()
+	want, ok := fsys.pins[name]
+	fsys.pMu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	key := dbkey(o)
+	defer discardkey(key)
+	if !bytes.Equal(key, want) {
+		slog.Warn("pinStale", "path", name)
+		return nil, false
+	}
+
+	stat, err := o.cookedStat()
+	if err != nil {
+		return nil, false
+	}
+	f, err := os.Open(fsys.pinFile(name))
+	if err != nil {
+		slog.Error("pinMissing", "path", name, "err", err)
+		return nil, false
+	}
+	return pinnedFile{f, stat}, true
+}
+
+// pinAPI lists, adds and removes pinned files.
+//
+//	GET    /api/v1/pin
+//	POST   /api/v1/pin?path=PATH
+//	DELETE /api/v1/pin?path=PATH
+//
+// A GET gives a line {"path":"...","size":N} for each pinned file and the final line is {"count":N}.
+// A POST decompresses the file before it answers. POST and DELETE need the curator login.
+func pinAPI(fsys *FS, w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(r.URL.Query().Get("path"), "/")
+	switch r.Method {
+	case "GET", "HEAD":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Cache-Control", "no-store")
+		if r.Method == "HEAD" {
+			return
+		}
+		enc := json.NewEncoder(w)
+		names := fsys.pinned()
+		for _, name := range names {
+			var size int64
+			if s, err := os.Stat(fsys.pinFile(name)); err == nil {
+				size = s.Size()
+			}
+			enc.Encode(struct {
+				Path string `json:"path"`
+				Size int64  `json:"size"`
+			}{name, size})
+		}
+		enc.Encode(struct {
+			Count int `json:"count"`
+		}{len(names)})
+		return
+	case "POST", "DELETE":
+	default:
+		http.Error(w, "use GET, POST or DELETE", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if curator == "" {
+		http.Error(w, "pinning needs a -curator login", http.StatusForbidden)
+		return
+	} else if !isCurator(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="BeHierarchic curators"`)
+		http.Error(w, "curator login required to pin files", http.StatusUnauthorized)
+		return
+	} else if name == "" {
+		http.Error(w, "which path?", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == "DELETE" {
+		err := fsys.unpin(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			http.Error(w, "not pinned", http.StatusNotFound)
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+
+	size, err := fsys.pin(name)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, errPinLocal), errors.Is(err, fs.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Path string `json:"path"`
+		Size int64  `json:"size"`
+	}{name, size})
+}
+
+const pinHello = `Usage:  BeHierarchic pin [-remove] CACHE SHAREPOINT PATH...
+
+Decompresses files within archives in full beside the cache, so that they are
+quick to serve when they are about to be downloaded heavily. With -remove, unpins them.
+Run it while the server is stopped, or use the /api/v1/pin endpoint on the -admin address instead.`
+
+func pinCmd(args []string) error {
+	flags := flag.NewFlagSet("pin", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprintln(flags.Output(), pinHello) }
+	remove := flags.Bool("remove", false, "")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() < 3 {
+		return errors.New(pinHello)
+	}
+	cache, target := flags.Arg(0), flags.Arg(1)
+
+	s, err := os.Stat(target)
+	if err != nil {
+		return err
+	} else if !s.IsDir() {
+		return fmt.Errorf("%s: not a directory", target)
+	}
+
+	fsys := Wrapper(os.DirFS(target), cache)
+	if fsys.db.Load() == nil {
+		return errNoDB
+	}
+	defer fsys.db.Load().Close()
+	for _, name := range flags.Args()[2:] {
+		name = strings.Trim(filepath.ToSlash(name), "/")
+		if *remove {
+			err = fsys.unpin(name)
+		} else {
+			_, err = fsys.pin(name)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -4,11 +4,14 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
 	"io"
 	"io/fs"
 	"log/slog"
-	"math"
 	"math/bits"
+	"os"
 	"runtime"
 	"slices"
 	"strconv"
@@ -20,7 +23,6 @@ import (
 
 	"github.com/cespare/xxhash/v2"
 	"github.com/cockroachdb/pebble/v2"
-	"github.com/cockroachdb/pebble/v2/sstable/block"
 	"github.com/elliotnunn/BeHierarchic/internal/fileid"
 	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
 	"github.com/elliotnunn/BeHierarchic/internal/internpath"
@@ -29,34 +31,21 @@ import (
 
 const (
 	// meant to be eye-catching, and must never be <= 8 (see appendint)
-	offsetByte = 0xcc // appended to a dbkey ~ "offset follows, value is data"
+	offsetByte = 0xcd // appended to a dbkey ~ "offset follows, value is sealed data" (legacyOffsetByte before sealing)
 	sizeByte   = 0x55 // appended to a dbkey ~ "value is a size"
+	digestByte = 0x5d // appended to a dbkey ~ "value is a modtime and SHA-256 digest"
+	sha1Byte   = 0x51 // appended to a dbkey ~ "value is a modtime and SHA-1 digest"
+	inoByte    = 0x1d // appended to a dbkey ~ "value is an inode number"
+	seenByte   = 0x5e // appended to a dbkey ~ "value is the modtime when last prefetched"
+	crcOKByte  = 0xc0 // appended to a dbkey ~ "value is a modtime and the archive's checksum, seen to match"
+	crcBadByte = 0xba // appended to a dbkey ~ "value is a modtime and the archive's checksum, seen not to match"
+
+	zeroRunMin = 4096               // zeros worth storing as a marker instead of as data
+	zeroSalt   = 0x2e20e2052e20e205 // distinguishes the seal of a zero run from that of data
 )
 
-func (fsys *FS) setupDB(dsn string) {
-	if dsn == "" {
-		return
-	}
-
-	opts := &pebble.Options{
-		CacheSize:            128 * 1024 * 1024,
-		AllocatorSizeClasses: []int{16 * 1024, 32 * 1024, 64 * 1024, 128 * 1024, 256 * 1024},
-	}
-	opts.ApplyCompressionSettings(func() pebble.DBCompressionSettings {
-		return pebble.UniformDBCompressionSettings(block.MinLZCompression)
-	})
-
-	db, err := pebble.Open(dsn, opts)
-	if err != nil {
-		slog.Error("dbFail", "path", dsn, "err", err)
-		return
-	}
-	slog.Info("dbOK", "dsn", dsn)
-	fsys.db = db
-}
-
 func (fsys *FS) dumpDB() {
-	iter, err := fsys.db.NewIter(&pebble.IterOptions{})
+	iter, err := fsys.db.Load().NewIter(&pebble.IterOptions{})
 	if err != nil {
 		panic(err)
 	}
@@ -70,46 +59,29 @@ func (fsys *FS) dumpDB() {
 }
 
 func (f *cachingFile) ReadAt(p []byte, off int64) (n int, err error) {
-	if f.isCaching() {
-		n = f.getCache(p, off)
-		atomic.AddInt64(&f.path.container.scoreGood, int64(n))
-		if n == len(p) {
-			return n, nil
-		}
+	if !f.isCaching() {
+		return f.randomAccessFile.ReadAt(p, off)
 	}
-
-	more, err := f.randomAccessFile.ReadAt(p[n:], off+int64(n))
-	n += more
-
-	if f.isCaching() && more > 0 {
-		atomic.AddInt64(&f.path.container.scoreBad, int64(n))
-		f.setCache(p[:n], off)
-
-		// if n > 0 {
-		// 	p2 := make([]byte, len(p))
-		// 	n2 := f.getCache(p2, off)
-		// 	if n2 != n || !bytes.Equal(p2[:n2], p[:n]) {
-		// 		slog.Error("cacheMismatch",
-		// 			"key", hex.EncodeToString(dbkey(f.path)),
-		// 			"path", f.path,
-		// 			"off", off,
-		// 			"len", len(p))
-		// 		slog.Error("cacheMismatchExpect",
-		// 			"n", n,
-		// 			"data", hex.EncodeToString(p[:n]))
-		// 		slog.Error("cacheMismatchGot",
-		// 			"n", n2,
-		// 			"data", hex.EncodeToString(p2[:n2]))
-		// 		f.path.container.dumpDB()
-		// 	}
-		// }
+	n = f.getCache(p, off)
+	atomic.AddInt64(&f.path.container.scoreGood, int64(n))
+	if n == len(p) {
+		return n, nil
 	}
 
+	more, err := f.path.coalesce(p[n:], off+int64(n), func() (int, error) {
+		more, err := f.randomAccessFile.ReadAt(p[n:], off+int64(n))
+		if more > 0 {
+			atomic.AddInt64(&f.path.container.scoreBad, int64(n+more))
+			f.setCache(p[:n+more], off)
+		}
+		return more, err
+	})
+	n += more
 	return
 }
 
 func (f *cachingFile) getCache(p []byte, off int64) (n int) {
-	if f.path.container.db == nil {
+	if f.path.container.db.Load() == nil {
 		return 0
 	}
 
@@ -117,7 +89,7 @@ func (f *cachingFile) getCache(p []byte, off int64) (n int) {
 	id := appendint(idPrefix, off)
 	defer discardkey(id)
 
-	iter, dberr := f.path.container.db.NewIter(&pebble.IterOptions{
+	iter, dberr := f.path.container.db.Load().NewIter(&pebble.IterOptions{
 		LowerBound: id,
 	})
 	if dberr != nil {
@@ -125,45 +97,88 @@ func (f *cachingFile) getCache(p []byte, off int64) (n int) {
 	}
 	defer iter.Close()
 
-	if !iter.First() {
-		return 0
-	}
-
-	xid := iter.Key()
-	if !bytes.HasPrefix(xid, idPrefix) {
-		return 0
-	}
-
-	xp, dberr := iter.ValueAndErr()
-	if dberr != nil {
-		slog.Error("pebbleIteratorValueErr", "err", dberr)
-		return 0
-	}
+	// Data and zero runs are cached separately, so a read can span several adjacent extents
+	for iter.First(); iter.Valid() && n < len(p); iter.Next() {
+		xid := iter.Key()
+		if !bytes.HasPrefix(xid, idPrefix) {
+			break
+		}
 
-	xbufend, ok := read1int(xid[len(idPrefix):])
-	if !ok {
-		return 0
+		xp, dberr := iter.ValueAndErr()
+		if dberr != nil {
+			slog.Error("pebbleIteratorValueErr", "err", dberr)
+			break
+		}
+		xp, zeros, ref, ok := unsealExtent(xp)
+		xbufend, endok := read1int(xid[len(idPrefix):])
+		here := off + int64(n)
+		if ok && endok && xbufend <= here {
+			continue // ends just where this read starts
+		}
+		if ok && ref != nil {
+			xp, ok = f.path.container.getBlock(*ref)
+		}
+		if !ok && ref != nil {
+			f.path.cacheCorrupt(xid)
+			f.path.container.dropRef(idPrefix, slices.Clone(xid), *ref)
+			break // so the caller will re-fetch
+		} else if !ok {
+			f.path.cacheCorrupt(xid)
+			f.path.container.db.Load().Delete(xid, &pebble.WriteOptions{})
+			break // so the caller will re-fetch
+		} else if !endok {
+			break
+		}
+		var more int
+		if zeros > 0 {
+			more = subZero(p[n:], here, xbufend-zeros, xbufend)
+		} else {
+			more = subRead(p[n:], here, xp, bufStart(xp, xbufend))
+		}
+		if more == 0 {
+			break // a gap
+		}
+		n += more
 	}
-	xoff := bufStart(xp, xbufend)
-
-	return subRead(p, off, xp, xoff)
+	return n
 }
 
+// setCache stores p, except that long runs of zeros (as in sparse files and blank disk images)
+// are stored as markers of their length
 func (f *cachingFile) setCache(p []byte, off int64) {
-	if f.path.container.db == nil || len(p) == 0 {
+	f.path.traceCache(len(p))
+	if f.path.container.db.Load() == nil {
 		return
 	}
+	if len(p) > 0 {
+		f.path.noteExtents()
+	}
+	for len(p) > 0 {
+		n, zero := nextRun(p)
+		f.setExtent(p[:n], off, zero)
+		p, off = p[n:], off+int64(n)
+	}
+}
 
+// setExtent joins p to the overlapping extents already cached, but never joins zero runs to data
+func (f *cachingFile) setExtent(p []byte, off int64, zero bool) {
 	// do not accidentally append over someone else's data!
 	p = p[:len(p):len(p)]
+	end := bufEnd(p, off)
 
 	idPrefix := append(dbkey(f.path), offsetByte)
 	id := appendint(idPrefix, off)
 	defer discardkey(id)
 
-	batch := f.path.container.db.NewBatch()
+	fsys := f.path.container
+	batch := fsys.db.Load().NewBatch()
+	refs := make(map[blockSum]blockRef)
+	if !zero { // zero runs never take apart a block
+		defer fsys.lockExtents(idPrefix)()
+	}
+	off0, end0 := off, end
 
-	iter, dberr := f.path.container.db.NewIter(&pebble.IterOptions{
+	iter, dberr := f.path.container.db.Load().NewIter(&pebble.IterOptions{
 		LowerBound: id,
 	})
 	if dberr != nil {
@@ -182,14 +197,52 @@ func (f *cachingFile) setCache(p []byte, off int64) {
 		if dberr != nil {
 			panic(dberr)
 		}
-		xp = slices.Clone(xp) // a copy that we own
+		xp, zeros, ref, ok := unsealExtent(xp)
+		if !ok {
+			f.path.cacheCorrupt(xid)
+			batch.Delete(xid, &pebble.WriteOptions{})
+			continue
+		} else if (zeros > 0) != zero {
+			break
+		}
 
 		xbufend, ok := read1int(xid[len(idPrefix):])
 		if !ok {
 			break // questionable whether this is actually a good idea
 		}
-		xoff := bufStart(xp, xbufend)
 
+		// A block is taken apart only if this write overlaps it, lest a write take apart every block after it
+		if ref != nil {
+			if xbufend <= off0 {
+				continue
+			} else if xbufend-dedupBlock >= end0 {
+				break
+			}
+			sum := *ref
+			r := refs[sum]
+			r.delta-- // whether the block is taken apart or found corrupt, this reference goes
+			refs[sum] = r
+			batch.Delete(xid, &pebble.WriteOptions{})
+			if xp, ok = fsys.getBlock(sum); !ok {
+				f.path.cacheCorrupt(xid)
+				continue
+			}
+			bufJoin(&p, &off, xp, bufStart(xp, xbufend))
+			continue
+		}
+
+		if zero {
+			xoff := xbufend - zeros
+			if end < xoff || xbufend < off {
+				break
+			}
+			off, end = min(off, xoff), max(end, xbufend)
+			batch.Delete(xid, &pebble.WriteOptions{})
+			continue
+		}
+
+		xp = slices.Clone(xp) // a copy that we own
+		xoff := bufStart(xp, xbufend)
 		if bufJoin(&p, &off, xp, xoff) {
 			batch.Delete(xid, &pebble.WriteOptions{})
 		} else {
@@ -197,20 +250,90 @@ func (f *cachingFile) setCache(p []byte, off int64) {
 		}
 	}
 	// Now that we are done with the iter, we can append to idPrefix, even though it will clobber id
-	batch.Set(appendint(idPrefix, bufEnd(p, off)), p, &pebble.WriteOptions{})
+	if zero {
+		batch.Set(appendint(idPrefix, end), sealZeros(end-off), &pebble.WriteOptions{})
+	} else {
+		fsys.setData(batch, idPrefix, p, off, refs)
+		defer fsys.lockBlocks(refs)()
+		fsys.applyRefs(batch, refs)
+	}
 	dberr = batch.Commit(&pebble.WriteOptions{})
 	if dberr != nil {
 		panic(dberr)
 	}
 }
 
+// nextRun measures the data at the start of p up to the next long run of zeros,
+// or if p starts with a long run of zeros, measures that
+func nextRun(p []byte) (n int, zero bool) {
+	zeros := 0
+	for i, b := range p {
+		if b != 0 {
+			if zeros >= zeroRunMin && zeros == i {
+				return zeros, true
+			}
+			zeros = 0
+			continue
+		}
+		zeros++
+		if zeros == zeroRunMin && zeros <= i {
+			return i + 1 - zeros, false
+		}
+	}
+	if zeros >= zeroRunMin && zeros == len(p) {
+		return zeros, true
+	}
+	return len(p), false
+}
+
+// seal appends an xxhash of the data, because the cache has been known to return the wrong bytes
+func seal(p []byte) []byte {
+	return binary.BigEndian.AppendUint64(p[:len(p):len(p)], xxhash.Sum64(p))
+}
+
+func unseal(v []byte) ([]byte, bool) {
+	if len(v) < 8 {
+		return nil, false
+	}
+	p, sum := v[:len(v)-8], v[len(v)-8:]
+	return p, binary.BigEndian.Uint64(sum) == xxhash.Sum64(p)
+}
+
+// sealZeros stands for a run of n zeros, with a seal that cannot be mistaken for that of real data
+func sealZeros(n int64) []byte {
+	v := appendint(nil, n)
+	return binary.BigEndian.AppendUint64(v, xxhash.Sum64(v)^zeroSalt)
+}
+
+// unsealExtent unseals real data, a run of zeros or a reference to a block stored by its hash
+func unsealExtent(v []byte) (p []byte, zeros int64, ref *blockSum, ok bool) {
+	if p, ok := unseal(v); ok {
+		return p, 0, nil, true
+	} else if sum, ok := unsealRef(v); ok {
+		return nil, 0, &sum, true
+	} else if len(v) < 8 {
+		return nil, 0, nil, false
+	}
+	m, sum := v[:len(v)-8], v[len(v)-8:]
+	if binary.BigEndian.Uint64(sum) != xxhash.Sum64(m)^zeroSalt {
+		return nil, 0, nil, false
+	}
+	zeros, ok = read1int(m)
+	return nil, zeros, nil, ok && zeros > 0
+}
+
+func (o path) cacheCorrupt(key []byte) {
+	atomic.AddInt64(&o.container.scoreCorrupt, 1)
+	slog.Warn("cacheCorrupt", "path", o, "key", hex.EncodeToString(key))
+}
+
 func (o path) getCacheSize() (int64, bool) {
-	if o.container.db == nil {
+	if o.container.db.Load() == nil {
 		return 0, false
 	}
 	id := append(dbkey(o), sizeByte)
 	defer discardkey(id)
-	val, closer, err := o.container.db.Get(id)
+	val, closer, err := o.container.db.Load().Get(id)
 	if err == pebble.ErrNotFound {
 		return 0, false
 	} else if err != nil {
@@ -222,30 +345,85 @@ func (o path) getCacheSize() (int64, bool) {
 }
 
 func (o path) setCacheSize(s int64) {
-	if o.container.db == nil {
+	o.traceSize()
+	if o.container.db.Load() == nil {
 		return
 	}
 	id := append(dbkey(o), sizeByte)
 	defer discardkey(id)
 	val := appendint([]byte(nil), s)
-	err := o.container.db.Set(id, val, &pebble.WriteOptions{})
+	err := o.container.db.Load().Set(id, val, &pebble.WriteOptions{})
 	if err != nil {
 		slog.Error("setCacheSizeError", "path", o, "err", err)
 	}
 }
 
-func (fsys *FS) Prefetch() {
-	slog.Info("prefetchStart")
+const prefetchHello = `Usage:  BeHierarchic prefetch [-new] CACHE SHAREPOINT
+        BeHierarchic prefetch -dryrun [-v] SHAREPOINT
+
+Indexes the sharepoint into the cache and exits, without serving anything.
+With -new, skips the files that were already indexed at their current modtime,
+which suits an hourly cron job over a mirror that keeps growing.
+
+With -dryrun, no cache is opened or written. Instead a line is printed for every archive,
+with the format that claimed it and what would have been cached,
+and for every archive that failed to probe or mount, with the error.
+With -v as well, every other file is listed as not an archive, and each line has the time taken.`
+
+func prefetchCmd(args []string) error {
+	flags := flag.NewFlagSet("prefetch", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprintln(flags.Output(), prefetchHello) }
+	onlyNew := flags.Bool("new", false, "")
+	dry := flags.Bool("dryrun", false, "")
+	verbose := flags.Bool("v", false, "")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *dry {
+		if *onlyNew || flags.NArg() != 1 {
+			return errors.New(prefetchHello) // -new would need the cache
+		}
+		return dryRunCmd(flags.Arg(0), *verbose)
+	} else if *verbose || flags.NArg() != 2 {
+		return errors.New(prefetchHello)
+	}
+	cache, target := flags.Arg(0), flags.Arg(1)
+
+	s, err := os.Stat(target)
+	if err != nil {
+		return err
+	} else if !s.IsDir() {
+		return fmt.Errorf("%s: not a directory", target)
+	}
+
+	fsys := Wrapper(os.DirFS(target), cache)
+	fsys.prefetch(*onlyNew)
+	fsys.waitSizeQueue()
+	if fsys.db.Load() != nil {
+		return fsys.db.Load().Close()
+	}
+	return nil
+}
+
+// Prefetch indexes every file in the sharepoint
+func (fsys *FS) Prefetch() { fsys.prefetch(false) }
+
+func (fsys *FS) prefetch(onlyNew bool) {
+	slog.Info("prefetchStart", "onlyNew", onlyNew)
 	atomic.StoreInt64(&fsys.scoreGood, 0)
 	atomic.StoreInt64(&fsys.scoreBad, 0)
 
 	t := time.Now()
-	var progress atomic.Int64
+	progress := &fsys.progress
+	progress.start(t)
+	defer progress.stop()
+	fsys.startSizeQueue() // with anything left over from last time
 	printProgress := func() {
 		var mem runtime.MemStats
 		runtime.ReadMemStats(&mem)
 		ram := mem.HeapInuse + mem.StackInuse + uint64(internpath.MemoryUnknownToRuntime())
-		disk := progress.Load()
+		disk := progress.bytes.Load()
 		fsys.rMu.RLock()
 		mounts := len(fsys.reverse)
 		fsys.rMu.RUnlock()
@@ -257,6 +435,7 @@ func (fsys *FS) Prefetch() {
 			"ramPerArchive", strconv.FormatFloat(float64(ram)/float64(disk), 'f', 3, 64),
 			"cacheHitBytes", thouSep(atomic.LoadInt64(&fsys.scoreGood)),
 			"cacheMissBytes", thouSep(atomic.LoadInt64(&fsys.scoreBad)),
+			"cacheCorrupt", thouSep(atomic.LoadInt64(&fsys.scoreCorrupt)),
 		)
 	}
 
@@ -274,18 +453,23 @@ func (fsys *FS) Prefetch() {
 	}()
 
 	// the time consuming part
-	path{fsys, fsys.root, internpath.Path{}}.prefetchThisFS(runtime.GOMAXPROCS(-1), &progress)
+	path{fsys, fsys.root, internpath.Path{}}.prefetchThisFS(runtime.GOMAXPROCS(-1), progress, onlyNew)
 
 	close(stopTick)
-	if fsys.db != nil {
-		fsys.db.Flush()
+	if fsys.db.Load() != nil {
+		fsys.db.Load().Flush()
 	}
 	runtime.GC() // make the last set of memory stats reflect the long-term memory use
 	printProgress()
 	slog.Info("prefetchStop")
 }
 
-func (o path) prefetchThisFS(concurrency int, progress *atomic.Int64) {
+// mountSlots bounds the extra goroutines that scan nested archives in parallel with their siblings.
+// Each might hold a decompressor open, and the spinner only keeps so many.
+var mountSlots = make(chan struct{}, spinner.MaxReaders/2)
+
+// onlyNew skips files already prefetched at their current modtime, and applies only to the sharepoint itself
+func (o path) prefetchThisFS(concurrency int, progress *prefetchProgress, onlyNew bool) {
 	if o.name != (internpath.Path{}) {
 		panic("this should be a filesystem!!")
 	}
@@ -307,6 +491,9 @@ func (o path) prefetchThisFS(concurrency int, progress *atomic.Int64) {
 			fs.WalkDir(o.fsys, ".", func(pathname string, d fs.DirEntry, err error) error {
 				if d.Type().IsRegular() {
 					list = append(list, internpath.Make(pathname))
+					if info, err := d.Info(); err == nil && progress != nil {
+						progress.found(info.Size())
+					}
 				}
 				return nil
 			})
@@ -322,18 +509,25 @@ func (o path) prefetchThisFS(concurrency int, progress *atomic.Int64) {
 		}
 	}()
 
-	buf1 := make([]byte, 1)
-	var wg sync.WaitGroup
+	var wg, subwg sync.WaitGroup
+	defer subwg.Wait()
 	for range concurrency {
 		wg.Go(func() {
 			for name := range ch {
 				o := o
 				o.name = name
 
-				if progress != nil {
+				var mtime time.Time
+				if o.fsys == o.container.root {
 					rawstat, rawerr := o.rawStat()
 					if rawerr == nil {
-						progress.Add(rawstat.Size())
+						mtime = rawstat.ModTime()
+						if progress != nil {
+							progress.scanned(rawstat.Size())
+						}
+					}
+					if onlyNew && rawerr == nil && o.seenAt(mtime) {
+						continue
 					}
 				}
 
@@ -347,38 +541,82 @@ func (o path) prefetchThisFS(concurrency int, progress *atomic.Int64) {
 					}
 				}
 
+				t := time.Now()
 				timer := time.AfterFunc(time.Second*5, func() { slog.Info("takingLongTime", "path", o) })
 				isar, fsys := o.getArchive(true, true)
 				timer.Stop()
+				took := time.Since(t)
 				if isar && !strings.HasPrefix(o.name.Base(), "._") { // no use probing resource forks!
-					fsys.prefetchThisFS(1, nil)
+					select {
+					case mountSlots <- struct{}{}: // scan this archive alongside its siblings
+						subwg.Go(func() {
+							defer func() { <-mountSlots }()
+							fsys.prefetchThisFS(1, nil, false)
+						})
+					default: // enough going on, scan it in this worker
+						fsys.prefetchThisFS(1, nil, false)
+					}
 				}
 
 				if fsys, ok := o.fsys.(*fskeleton.FS); ok {
 					if hardSize, err := fsys.BornSizeUnknown(o.name); err == nil && hardSize {
-						size, err := fsys.Size(o.name)
-						if err == fskeleton.ErrSizeUnknown {
-							spinner.ReadAt(o, buf1, math.MaxInt64-1)
-							size, err = fsys.Size(o.name)
-						}
-						if err != fskeleton.ErrSizeUnknown {
-							slog.Info("hardWonSize", "size", size, "path", o)
+						if size, err := fsys.Size(o.name); err == nil {
 							o.setCacheSize(size)
+						} else {
+							o.queueSize()
 						}
 					}
 				}
+
+				if o.fsys == o.container.root && !mtime.IsZero() {
+					o.setSeen(mtime)
+				}
+				o.traceDone(isar, took)
 			}
 		})
 	}
 	wg.Wait()
 }
 
+// seenAt reports whether the file was last prefetched when it had this modtime
+func (o path) seenAt(mtime time.Time) bool {
+	if o.container.db.Load() == nil {
+		return false
+	}
+	id := append(dbkey(o), seenByte)
+	defer discardkey(id)
+	val, closer, err := o.container.db.Load().Get(id)
+	if err != nil {
+		return false
+	}
+	defer closer.Close()
+	n, ok := read1int(val)
+	return ok && n == mtime.UnixNano()
+}
+
+func (o path) setSeen(mtime time.Time) {
+	if o.container.db.Load() == nil {
+		return
+	}
+	id := append(dbkey(o), seenByte)
+	defer discardkey(id)
+	err := o.container.db.Load().Set(id, appendint([]byte(nil), mtime.UnixNano()), &pebble.WriteOptions{})
+	if err != nil {
+		slog.Error("setSeenError", "path", o, "err", err)
+	}
+}
+
 // please don't use on a directory!
 func (o path) prefetchCachedOpen() (*cachingFile, error) {
 	f, err := o.cookedOpen()
 	if err != nil {
 		return nil, err
 	}
+	if b, ok := f.(osFileBuffered); ok {
+		if cf, ok := b.statCloser.(*cachingFile); ok {
+			return cf, nil // a remote file, already cached
+		}
+	}
 	rdr, ok := f.(randomAccessFile)
 	if !ok { // ???not a file
 		return nil, fs.ErrInvalid
@@ -397,10 +635,15 @@ type cachingFile struct {
 	randomAccessFile
 }
 
-func (f *cachingFile) isCaching() bool                  { return f.path.container != nil }
-func (f *cachingFile) stopCaching()                     { f.path = path{} }
-func (f *cachingFile) makePanic()                       { f.randomAccessFile = nil }
-func (f *cachingFile) withoutCaching() randomAccessFile { return f.randomAccessFile }
+func (f *cachingFile) isCaching() bool { return f.path.container != nil }
+func (f *cachingFile) stopCaching()    { f.path = path{} }
+func (f *cachingFile) makePanic()      { f.randomAccessFile = nil }
+func (f *cachingFile) withoutCaching() randomAccessFile {
+	if f.path.isRemote() {
+		return f // every read is worth keeping
+	}
+	return f.randomAccessFile
+}
 
 func appendint(buf []byte, n int64) []byte {
 	u := uint64(n)
@@ -438,6 +681,13 @@ func (o path) identify() (ret fileid.ID) {
 		}
 	}
 
+	// Not remembered in idCache, because the answer changes when a remote file does
+	if idr, ok := o.fsys.(fileid.FS); ok {
+		if id, err := idr.FileID(o.name.String()); err == nil {
+			return id
+		}
+	}
+
 	if o.fsys == o.container.root {
 		o.container.iMu.RLock()
 		var ok bool
@@ -452,8 +702,7 @@ func (o path) identify() (ret fileid.ID) {
 	// Fall back on hashing the filename
 	if id == *new(fileid.ID) {
 		var h xxhash.Digest
-		// could optimise by adding a WriteTo method to internpath.Path
-		h.WriteString(o.name.String())
+		o.name.WriteTo(&h)
 		binary.BigEndian.PutUint64(id[len(id)-8:], h.Sum64())
 	}
 
@@ -520,6 +769,15 @@ func subRead(p []byte, off int64, srcP []byte, srcOff int64) (n int) {
 	}
 }
 
+func subZero(p []byte, off int64, zeroOff, zeroEnd int64) (n int) {
+	if zeroOff > off || zeroEnd <= off {
+		return 0
+	}
+	n = int(min(int64(len(p)), zeroEnd-off))
+	clear(p[:n])
+	return n
+}
+
 func bufJoin(p1 *[]byte, off1 *int64, p2 []byte, off2 int64) bool {
 	end1, end2 := bufEnd(*p1, *off1), bufEnd(p2, off2)
 	if end1 < off2 || end2 < *off1 {
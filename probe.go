@@ -9,21 +9,54 @@ import (
 	"io/fs"
 	"math"
 	gopath "path"
+	"slices"
 	"strings"
 
 	"github.com/elliotnunn/BeHierarchic/internal/apm"
+	"github.com/elliotnunn/BeHierarchic/internal/applesingle"
+	"github.com/elliotnunn/BeHierarchic/internal/arc"
+	"github.com/elliotnunn/BeHierarchic/internal/arj"
+	"github.com/elliotnunn/BeHierarchic/internal/binhex"
+	"github.com/elliotnunn/BeHierarchic/internal/cpm"
+	"github.com/elliotnunn/BeHierarchic/internal/cue"
+	"github.com/elliotnunn/BeHierarchic/internal/diskcopy"
+	"github.com/elliotnunn/BeHierarchic/internal/dos33"
 	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
 	"github.com/elliotnunn/BeHierarchic/internal/hfs"
+	"github.com/elliotnunn/BeHierarchic/internal/imd"
+	"github.com/elliotnunn/BeHierarchic/internal/lha"
+	"github.com/elliotnunn/BeHierarchic/internal/mfs"
+	"github.com/elliotnunn/BeHierarchic/internal/newton"
+	"github.com/elliotnunn/BeHierarchic/internal/nufx"
+	"github.com/elliotnunn/BeHierarchic/internal/palm"
+	"github.com/elliotnunn/BeHierarchic/internal/prodos"
+	"github.com/elliotnunn/BeHierarchic/internal/rar"
 	"github.com/elliotnunn/BeHierarchic/internal/resourcefork"
 	"github.com/elliotnunn/BeHierarchic/internal/sectionreader"
 	"github.com/elliotnunn/BeHierarchic/internal/sit"
 	"github.com/elliotnunn/BeHierarchic/internal/tar"
+	"github.com/elliotnunn/BeHierarchic/internal/teledisk"
+	"github.com/elliotnunn/BeHierarchic/internal/wim"
 	"github.com/elliotnunn/BeHierarchic/internal/zip"
+	"github.com/elliotnunn/BeHierarchic/internal/zoo"
 	"github.com/therootcompany/xz"
 )
 
-// probeArchive examines the filename and file header,
-// and returns a function returning an fs.FS (which can be expensive to run).
+// probeArchive returns a function returning an fs.FS (which can be expensive to run),
+// from the built-in readers or else from an external program (see external.go)
+func (o path) probeArchive() (fsysGenerator, error) {
+	gen, err := o.probeBuiltIn()
+	if gen != nil || err != nil || len(externalRules) == 0 {
+		return gen, err
+	}
+	info, err := o.rawStat()
+	if err != nil || !info.Mode().IsRegular() {
+		return nil, err
+	}
+	return o.probeExternal(info)
+}
+
+// probeBuiltIn examines the filename and file header.
 //
 // Much ink has been spilt over the problem of determining file types from examining headers.
 // The competing requirements of this implementation are:
@@ -33,7 +66,7 @@ import (
 //     might not require a very expensive update to every file's cache entry
 //   - But also not fill up the cache needlessly
 //   - Be sceptical of the file extension, only using it if it brings great savings
-func (o path) probeArchive() (fsysGenerator, error) {
+func (o path) probeBuiltIn() (fsysGenerator, error) {
 	info, err := o.rawStat()
 	if err != nil {
 		return nil, err
@@ -47,6 +80,9 @@ func (o path) probeArchive() (fsysGenerator, error) {
 		return nil, err
 	}
 	dataReader := headerReader.withoutCaching()
+	allow := func(format string, gen fsysGenerator) (fsysGenerator, error) {
+		return o.allowFormat(format, info, gen)
+	}
 
 	// Easiest: AppleDouble file
 	if strings.HasPrefix(o.name.Base(), "._") {
@@ -59,13 +95,26 @@ func (o path) probeArchive() (fsysGenerator, error) {
 		}
 		headerReader := sectionreader.Section(headerReader, roffset, rsize)
 		dataReader := sectionreader.Section(dataReader, roffset, rsize)
-		return func() (fs.FS, error) { return resourcefork.New2(headerReader, dataReader) }, nil
+		return allow("appledouble", func() (fs.FS, error) { return resourcefork.New2(headerReader, dataReader) })
 	}
 
 	// Easy: switch on file extension
 	switch gopath.Ext(o.name.Base()) {
 	case ".tar":
-		return func() (fs.FS, error) { return tar.New2(headerReader, dataReader), nil }, nil
+		return allow("tar", func() (fs.FS, error) { return tar.New2(headerReader, dataReader), nil })
+	case ".cue":
+		return allow("cue", func() (fs.FS, error) {
+			return cue.New(io.NewSectionReader(headerReader, 0, math.MaxInt64), o.openSibling, info.ModTime())
+		})
+	case ".pdb", ".prc", ".pqa":
+		stat, err := headerReader.Stat()
+		if err != nil {
+			return nil, err
+		}
+		size := stat.Size()
+		if palm.Probe(headerReader, size) {
+			return allow("palm", func() (fs.FS, error) { return palm.New2(headerReader, dataReader, size) })
+		}
 	}
 
 	// Slightly harder: switch on the first 16 bytes
@@ -81,16 +130,71 @@ func (o path) probeArchive() (fsysGenerator, error) {
 	at := func(s string, o int) bool { return string(head[o:][:len(s)]) == s }
 
 	switch {
-	case at("StuffIt (c)1997-", 0) || at("S", 0) && at("rLau", 10):
-		return func() (fs.FS, error) { return sit.New2(headerReader, dataReader) }, nil
+	case cue.IsRaw(head): // a lone data track in 2352-byte sectors, as in .bin and some .toast files
+		stat, err := headerReader.Stat()
+		if err != nil {
+			return nil, err
+		}
+		size := stat.Size()
+		return allow("cue", func() (fs.FS, error) { return cue.NewRaw(dataReader, size, info.ModTime()) })
+	case newton.IsPackage(head):
+		return allow("newton", func() (fs.FS, error) { return newton.New2(headerReader, dataReader) })
+	case wim.IsWIM(head):
+		return allow("wim", func() (fs.FS, error) { return wim.New2(headerReader, dataReader) })
+	case nufx.IsArchive(head):
+		return allow("nufx", func() (fs.FS, error) { return nufx.New2(headerReader, dataReader) })
+	case at("2IMG", 0): // Apple II universal disk image, of which only the ProDOS order is read
+		h := make([]byte, 64)
+		headerReader.ReadAt(h, 0)
+		if offset, size, ok := prodos.Parse2IMG(h); ok {
+			headerReader := sectionreader.Section(headerReader, offset, size)
+			dataReader := sectionreader.Section(dataReader, offset, size)
+			return allow("prodos", func() (fs.FS, error) { return prodos.New2(headerReader, dataReader, size) })
+		}
+	case zoo.IsArchive(head):
+		return allow("zoo", func() (fs.FS, error) { return zoo.New2(headerReader, dataReader) })
+	case rar.IsArchive(head):
+		if rar.IsLaterVolume(headerReader, o.name.Base()) {
+			return nil, nil // read as part of the first volume
+		}
+		name := o.name.Base()
+		return allow("rar", func() (fs.FS, error) { return rar.New2(headerReader, dataReader, name, o.openSibling) })
+	case arj.IsArchive(head):
+		return allow("arj", func() (fs.FS, error) { return arj.New2(headerReader, dataReader) })
+	case lha.IsArchive(head):
+		return allow("lha", func() (fs.FS, error) { return lha.New2(headerReader, dataReader) })
+	case arc.IsArchive(head): // weakest of the three
+		return allow("arc", func() (fs.FS, error) { return arc.New2(headerReader, dataReader) })
+	case imd.IsImage(head):
+		innerName := changeSuffix(o.name.Base(), ".imd=.img .IMD=.IMG")
+		return allow("imd", func() (fs.FS, error) { return imd.New2(headerReader, dataReader, innerName, info.ModTime()) })
+	case teledisk.IsImage(head):
+		innerName := changeSuffix(o.name.Base(), ".td0=.img .TD0=.IMG")
+		return allow("teledisk", func() (fs.FS, error) { return teledisk.New2(headerReader, dataReader, innerName, info.ModTime()) })
+	case isStuffIt(head):
+		return allow("sit", func() (fs.FS, error) { return sit.New2(headerReader, dataReader) })
+	case at("\x00\x05\x16\x00", 0) || head[0] == 0 && head[1] > 0 && head[1] < 64: // AppleSingle or MacBinary
+		if offset, size, ok := seaRange(headerReader); ok {
+			headerReader := sectionreader.Section(headerReader, offset, size)
+			dataReader := sectionreader.Section(dataReader, offset, size)
+			return allow("sea", func() (fs.FS, error) { return sit.New2(headerReader, dataReader) })
+		}
+		if applesingle.IsAppleSingle(head) {
+			innerName := changeSuffix(o.name.Base(), ".ast .as .AST .AS")
+			return allow("applesingle", func() (fs.FS, error) {
+				return applesingle.New2(headerReader, dataReader, innerName, info.ModTime())
+			})
+		}
+	case resourcefork.IsResourceFork(head): // as extracted by other tools, often named .rsrc
+		return allow("rsrc", func() (fs.FS, error) { return resourcefork.New2(headerReader, dataReader) })
 	case at("ER", 0) && // Apple Partition Map
 		(at("\x02\x00", 2) || at("\x04\x00", 2) || at("\x08\x00", 2) || at("\x10\x00", 2)): // block sizes
-		return func() (fs.FS, error) {
+		return allow("apm", func() (fs.FS, error) {
 			defer headerReader.stopCaching()
 			return apm.New(headerReader)
-		}, nil
+		})
 	case at("\x1f\x8b\x08", 0):
-		return func() (fs.FS, error) {
+		return allow("gzip", func() (fs.FS, error) {
 			innerName := changeSuffix(o.name.Base(), ".gz .gzip .tgz=.tar")
 			opener := func() (io.ReadCloser, error) {
 				return gzip.NewReader(io.NewSectionReader(dataReader, 0, math.MaxInt64))
@@ -99,10 +203,10 @@ func (o path) probeArchive() (fsysGenerator, error) {
 			fsys.CreateReadCloser(innerName, 0, opener, fskeleton.SizeUnknown, 0, info.ModTime())
 			fsys.NoMore()
 			return fsys, nil
-		}, nil
+		})
 	case at("BZh", 0) && head[3] >= '0' && head[3] <= '9' && at("\x31\x41\x59\x26\x53\x59", 4) &&
 		!strings.HasSuffix(o.name.Base(), ".dmg"): // UDIFs have a more complex format, ignore the bzip2 header
-		return func() (fs.FS, error) {
+		return allow("bzip2", func() (fs.FS, error) {
 			innerName := changeSuffix(o.name.Base(), ".bz .bz2 .bzip2 .tbz=.tar .tb2=.tar")
 			opener := func() (io.Reader, error) {
 				return bzip2.NewReader(io.NewSectionReader(dataReader, 0, math.MaxInt64)), nil
@@ -111,9 +215,9 @@ func (o path) probeArchive() (fsysGenerator, error) {
 			fsys.CreateReader(innerName, 0, opener, fskeleton.SizeUnknown, 0, info.ModTime())
 			fsys.NoMore()
 			return fsys, nil
-		}, nil
+		})
 	case at("\xfd7zXZ\x00", 0):
-		return func() (fs.FS, error) {
+		return allow("xz", func() (fs.FS, error) {
 			innerName := changeSuffix(o.name.Base(), ".xz .txz=.tar")
 			opener := func() (io.Reader, error) {
 				return xz.NewReader(io.NewSectionReader(dataReader, 0, math.MaxInt64), xz.DefaultDictMax)
@@ -122,7 +226,7 @@ func (o path) probeArchive() (fsysGenerator, error) {
 			fsys.CreateReader(innerName, 0, opener, fskeleton.SizeUnknown, 0, info.ModTime())
 			fsys.NoMore()
 			return fsys, nil
-		}, nil
+		})
 	case at("MZ", 0): // possible self-extracting ZIP, work backward from end to find PK
 		// currently only accommodates ZIP headers without a comment field
 		stat, err := headerReader.Stat()
@@ -150,19 +254,42 @@ func (o path) probeArchive() (fsysGenerator, error) {
 			return nil, err
 		}
 		size := stat.Size()
-		return func() (fs.FS, error) {
+		return allow("zip", func() (fs.FS, error) {
 			return zip.New2(headerReader, dataReader, size)
-		}, nil
+		})
+	}
+
+	// BinHex is text, and its marker line may come after a mail header, so look further into text files
+	if !slices.ContainsFunc(head, func(c byte) bool { return c >= 0x7f || c < ' ' && c != '\r' && c != '\n' && c != '\t' }) {
+		text := make([]byte, 4096)
+		n, _ := headerReader.ReadAt(text, 0)
+		if binhex.IsBinHex(text[:n]) {
+			innerName := changeSuffix(o.name.Base(), ".hqx .hcx .HQX .HCX")
+			return allow("binhex", func() (fs.FS, error) {
+				return binhex.New2(headerReader, dataReader, innerName, info.ModTime())
+			})
+		}
+	}
+
+	// Disk Copy 4.2 images, also written by ShrinkWrap, have no magic number but many constrained fields
+	if head[0] > 0 && head[0] < 64 {
+		h := make([]byte, diskcopy.HeaderSize)
+		n, _ := headerReader.ReadAt(h, 0)
+		if hdr, err := diskcopy.ParseHeader(h[:n]); err == nil && info.Size() >= diskcopy.HeaderSize+hdr.DataSize+hdr.TagSize {
+			return allow("diskcopy", func() (fs.FS, error) { return diskcopy.New2(headerReader, dataReader, info.ModTime()) })
+		}
 	}
 
-	// Hardest: HFS volumes
+	// Hardest: HFS volumes, and the MFS volumes ("D2D7") of the first 400K floppies
 	// - has no reliable file extension or type code
 	// - magic number offset by 1 kb
 	// - (unsupported) Disk Copy compression leaves the magic number intact
-	// First two bytes of the "boot block" will be blank or Larry Kenyon's initials
+	// First two bytes of the "boot block" will be blank or Larry Kenyon's initials,
+	// unless the file is exactly the size of a floppy, when the boot block could be anything
 	if at("\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00", 0) || // boot blocks truly empty
 		at("LK\x60", 0) || // boot blocks on
-		at("\x00\x00\x60", 0) { // boot blocks deliberately disabled
+		at("\x00\x00\x60", 0) || // boot blocks deliberately disabled
+		isFloppySize(info.Size()) {
 		stat, err := o.cookedStat()
 		if err != nil {
 			return nil, err
@@ -175,14 +302,90 @@ func (o path) probeArchive() (fsysGenerator, error) {
 			if n == len(mdb) &&
 				string(mdb[:2]) == "BD" && string(mdb[0x7c:0x7e]) != "H+" && // enforce HFS, exclude HFS+ wrapper
 				drAlBlkSiz >= 512 && drAlBlkSiz%512 == 0 { // reinforce the fairly weak magic number
-				return func() (fs.FS, error) { return hfs.New2(headerReader, dataReader) }, nil
+				return allow("hfs", func() (fs.FS, error) { return hfs.New2(headerReader, dataReader) })
+			} else if n == len(mdb) && mfs.IsVolume(mdb) {
+				return allow("mfs", func() (fs.FS, error) { return mfs.New2(headerReader, dataReader) })
+			}
+		}
+	}
+
+	// ProDOS volumes (.po, .hdv) have their volume directory header in block 2,
+	// which is looked for only in files the size of a volume or named like one, lest every file's third block be cached
+	if isProDOSSize(info.Size()) || slices.Contains([]string{".po", ".hdv", ".2mg"}, strings.ToLower(gopath.Ext(o.name.Base()))) {
+		vdh := make([]byte, prodos.BlockSize)
+		if n, _ := headerReader.ReadAt(vdh, 2*prodos.BlockSize); n == len(vdh) && prodos.IsVolume(vdh) {
+			stat, err := o.cookedStat()
+			if err != nil {
+				return nil, err
 			}
+			size := stat.Size()
+			return allow("prodos", func() (fs.FS, error) { return prodos.New2(headerReader, dataReader, size) })
 		}
 	}
+
+	// DOS 3.3 disks (.dsk, .do) have only their volume table of contents in track 17, so the catalog is checked too
+	if order, ok := dos33.Probe(headerReader, info.Size()); ok {
+		return allow("dos33", func() (fs.FS, error) { return dos33.New2(headerReader, dataReader, order, info.ModTime()) })
+	}
+
+	// Weakest of all: CP/M disks have no magic number, so only images the size of a known format are tried,
+	// or smaller ones from a floppy image that left out the empty tracks at the end
+	o.container.rMu.RLock()
+	outer := o.container.formats[o.fsys]
+	o.container.rMu.RUnlock()
+	if f, ok := cpm.Probe(headerReader, info.Size(), outer == "imd" || outer == "teledisk"); ok {
+		return allow("cpm", func() (fs.FS, error) { return cpm.New2(headerReader, dataReader, f, info.ModTime()) })
+	}
+
 	headerReader.Close()
 	return nil, nil // not an archive
 }
 
+// isFloppySize matches raw dumps of the common 3.5-inch formats, whether GCR or MFM
+func isFloppySize(size int64) bool {
+	switch size {
+	case 400 * 1024, 720 * 1024, 800 * 1024, 1440 * 1024:
+		return true
+	}
+	return false
+}
+
+// isProDOSSize matches volumes from a 140K floppy up to the largest that ProDOS can address
+func isProDOSSize(size int64) bool {
+	return size%prodos.BlockSize == 0 && size >= 140*1024 && size <= 0xffff*prodos.BlockSize
+}
+
+// openSibling opens a file named relative to o, as a cue sheet names its track files,
+// ignoring case if need be because such names often come from DOS
+func (o path) openSibling(name string) (io.ReaderAt, int64, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	sib := path{o.container, o.fsys, o.name.Dir().Join(name)}
+	if _, err := sib.rawStat(); err != nil {
+		dir := path{o.container, o.fsys, sib.name.Dir()}
+		listing, _ := dir.rawReadDir()
+		i := slices.IndexFunc(listing, func(de fs.DirEntry) bool { return strings.EqualFold(de.Name(), sib.name.Base()) })
+		if i < 0 {
+			return nil, 0, err
+		}
+		sib = dir.ShallowJoin(listing[i].Name())
+	}
+	f, err := sib.cookedOpen()
+	if err != nil {
+		return nil, 0, err
+	}
+	ra, ok := f.(randomAccessFile)
+	if !ok {
+		f.Close()
+		return nil, 0, fs.ErrInvalid
+	}
+	stat, err := ra.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return ra, stat.Size(), nil
+}
+
 func changeSuffix(s string, suffixes string) string {
 	for _, rule := range strings.Split(suffixes, " ") {
 		from, to, _ := strings.Cut(rule, "=")
@@ -199,6 +402,11 @@ func changeSuffix(s string, suffixes string) string {
 var ErrNotAppleDouble = errors.New("not a correct AppleDouble file")
 
 func resourceForkRange(r io.ReaderAt) (offset, size int64, err error) {
+	return appleEntryRange(r, 2, 286)
+}
+
+// appleEntryRange finds an entry in an AppleDouble or AppleSingle file, or returns zeros if it is missing or too small
+func appleEntryRange(r io.ReaderAt, id uint32, minSize int64) (offset, size int64, err error) {
 	defer func() {
 		if err == io.EOF {
 			err = ErrNotAppleDouble
@@ -222,7 +430,7 @@ func resourceForkRange(r io.ReaderAt) (offset, size int64, err error) {
 		return 0, 0, err
 	}
 	for ; len(recList) > 0; recList = recList[12:] {
-		if binary.BigEndian.Uint32(recList) == 2 && binary.BigEndian.Uint32(recList[8:]) >= 286 {
+		if binary.BigEndian.Uint32(recList) == id && int64(binary.BigEndian.Uint32(recList[8:])) >= minSize {
 			offset := int64(binary.BigEndian.Uint32(recList[4:]))
 			size := int64(binary.BigEndian.Uint32(recList[8:]))
 			return offset, size, nil
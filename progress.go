@@ -0,0 +1,121 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// prefetchProgress counts the files of the sharepoint itself, not the files within archives,
+// because only those can be counted before they are scanned
+type prefetchProgress struct {
+	files, totalFiles atomic.Int64
+	bytes, totalBytes atomic.Int64
+
+	mu                sync.Mutex
+	started, finished time.Time
+}
+
+func (p *prefetchProgress) start(t time.Time) {
+	p.files.Store(0)
+	p.totalFiles.Store(0)
+	p.bytes.Store(0)
+	p.totalBytes.Store(0)
+	p.mu.Lock()
+	p.started, p.finished = t, time.Time{}
+	p.mu.Unlock()
+}
+
+func (p *prefetchProgress) stop() {
+	p.mu.Lock()
+	p.finished = time.Now()
+	p.mu.Unlock()
+}
+
+func (p *prefetchProgress) found(size int64) {
+	p.totalFiles.Add(1)
+	p.totalBytes.Add(size)
+}
+
+func (p *prefetchProgress) scanned(size int64) {
+	p.files.Add(1)
+	p.bytes.Add(size)
+}
+
+type prefetchStatus struct {
+	Running    bool      `json:"running"`
+	Started    time.Time `json:"started,omitzero"`
+	Finished   time.Time `json:"finished,omitzero"`
+	Files      int64     `json:"files"`
+	TotalFiles int64     `json:"totalFiles"`
+	Bytes      int64     `json:"bytes"`
+	TotalBytes int64     `json:"totalBytes"`
+	Mounts     int       `json:"mounts"`
+	ETA        string    `json:"eta,omitempty"`
+}
+
+func (fsys *FS) prefetchStatus() prefetchStatus {
+	p := &fsys.progress
+	p.mu.Lock()
+	s := prefetchStatus{
+		Started:  p.started,
+		Finished: p.finished,
+	}
+	p.mu.Unlock()
+	s.Running = !s.Started.IsZero() && s.Finished.IsZero()
+	s.Files, s.TotalFiles = p.files.Load(), p.totalFiles.Load()
+	s.Bytes, s.TotalBytes = p.bytes.Load(), p.totalBytes.Load()
+	fsys.rMu.RLock()
+	s.Mounts = len(fsys.reverse)
+	fsys.rMu.RUnlock()
+
+	// Assume that the remaining bytes will go as fast as the ones already scanned
+	if s.Running && s.Bytes > 0 && s.TotalBytes >= s.Bytes {
+		elapsed := time.Since(s.Started)
+		eta := time.Duration(float64(elapsed) * float64(s.TotalBytes-s.Bytes) / float64(s.Bytes))
+		s.ETA = eta.Truncate(time.Second).String()
+	}
+	return s
+}
+
+// prefetchAPI reports how far the startup scan has got.
+//
+//	GET /api/v1/prefetch
+//
+// The counts are of the files in the sharepoint itself, not of the files within archives.
+func prefetchAPI(fsys *FS, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method == "HEAD" {
+		return
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	enc.Encode(fsys.prefetchStatus())
+}
+
+// prefetchFooter writes a line of HTML about the scan, if it is still going, and reports whether it did
+func prefetchFooter(fsys *FS, w io.Writer) bool {
+	s := fsys.prefetchStatus()
+	if !s.Running {
+		return false
+	}
+	fmt.Fprintf(w, `<p><small>Still indexing: %s of %s files, %s of %s bytes, %s archives mounted`,
+		thouSep(s.Files), thouSep(s.TotalFiles), thouSep(s.Bytes), thouSep(s.TotalBytes), thouSep(int64(s.Mounts)))
+	if s.ETA != "" {
+		fmt.Fprintf(w, `, about %s to go`, s.ETA)
+	}
+	fmt.Fprint(w, `</small></p>`+"\n")
+	return true
+}
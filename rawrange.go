@@ -0,0 +1,79 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
+)
+
+// rawRanged is implemented by the members of archives that know where their data lies, such as zip and tar
+type rawRanged interface {
+	Raw() (fskeleton.Raw, error)
+}
+
+// rawAPI says where a member's data lies within its archive, still compressed,
+// so that a client can fetch it with a Range request on the archive and decompress it itself.
+//
+//	GET /api/v1/raw?path=PATH
+//
+// The answer is {"container":"...","url":"...","offset":N,"size":N,"method":"deflate"},
+// where the container is the archive file that directly holds the member, and url is where to fetch it.
+// A wrapper layer that is otherwise hidden, such as the .tar in a .tar.gz, is named as the container.
+func rawAPI(fsys *FS, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	pathname := strings.Trim(r.URL.Query().Get("path"), "/")
+	o, err := fsys.path(pathname)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	raw, err := o.rawRange()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	fsys.rMu.RLock()
+	archive := fsys.reverse[o.fsys]
+	fsys.rMu.RUnlock()
+	container := archive.Thick(fsys).String()
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "HEAD" {
+		return
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(struct {
+		Container string `json:"container"`
+		URL       string `json:"url"`
+		Offset    int64  `json:"offset"`
+		Size      int64  `json:"size"`
+		Method    string `json:"method"`
+	}{container, urlenc("/" + container), raw.Offset, raw.Size, raw.Method})
+}
+
+var errNoRaw = errors.New("not a member of an archive that records where its data lies")
+
+func (o path) rawRange() (fskeleton.Raw, error) {
+	if o.fsys == o.container.root {
+		return fskeleton.Raw{}, errNoRaw
+	}
+	f, err := o.rawOpen()
+	if err != nil {
+		return fskeleton.Raw{}, err
+	}
+	defer f.Close()
+	if rr, ok := f.(rawRanged); ok {
+		return rr.Raw()
+	}
+	return fskeleton.Raw{}, errNoRaw
+}
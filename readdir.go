@@ -4,10 +4,16 @@ import (
 	"cmp"
 	"io"
 	"io/fs"
+	"log/slog"
 	"slices"
+	"sync"
+
+	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
 )
 
-func (fsys *FS) ReadDir(name string) (list []fs.DirEntry, err error) {
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) { return fsys.readDir(nil, name) }
+
+func (fsys *FS) readDir(s *session, name string) (list []fs.DirEntry, err error) {
 	defer func() {
 		if err != nil {
 			err = &fs.PathError{Op: "readdir", Path: name, Err: err}
@@ -18,74 +24,89 @@ func (fsys *FS) ReadDir(name string) (list []fs.DirEntry, err error) {
 		return nil, fs.ErrInvalid
 	}
 
-	o, err := fsys.path(name)
+	raw, kind, err := unpresent(name)
+	if err != nil {
+		return nil, err
+	}
+	o, err := fsys.pathIn(s, raw)
 	if err != nil {
 		return nil, err
 	}
 
-	return o.cookedReadDir()
+	switch kind {
+	case presentAppleDouble:
+		return o.appleDoubleReadDir()
+	case presentResourceFork:
+		return nil, fs.ErrInvalid
+	}
+	return o.cookedReadDir(false)
 }
 
 func (o path) rawReadDir() ([]fs.DirEntry, error) { return fs.ReadDir(o.fsys, o.name.String()) }
-func (o path) cookedReadDir() ([]fs.DirEntry, error) {
+func (o path) cookedReadDir(hurried bool) ([]fs.DirEntry, error) {
 	// Cases to cover:
 	// - all files must return a real, positive value for Info().Size()
 	// - add mountpoints to the listing
 	listing, err := o.rawReadDir()
-	if err != nil {
+	if hurried {
+		listing, err = o.hurriedReadDir()
+	}
+	partial := isIncomplete(err)
+	if err != nil && !partial {
 		return nil, err
 	}
 	for i := range listing {
 		listing[i] = fileDirEntry{path: o.ShallowJoin(listing[i].Name()), mode: listing[i].Type()}
 	}
 
-	answers := make(chan *mountpointDirEntry)
-
-	n := 0
-	for _, l := range listing {
+	answers := make([]*mountpointDirEntry, len(listing))
+	var wg sync.WaitGroup
+	for i, l := range listing {
 		if l.IsDir() {
 			continue // no to directories
 		}
 
-		go func() {
+		goTask(&wg, func() {
 			outer := o.ShallowJoin(l.Name())
 			isar, _ := outer.getArchive(true, false)
 			if isar {
-				answers <- &mountpointDirEntry{outer: outer}
-			} else {
-				answers <- nil
+				answers[i] = &mountpointDirEntry{outer: outer}
 			}
-		}()
-		n++
+		})
 	}
+	wg.Wait()
 
-	for range n {
-		l := <-answers
+	for _, l := range answers {
 		if l != nil {
 			listing = append(listing, *l)
 		}
 	}
 
 	slices.SortFunc(listing, func(a, b fs.DirEntry) int {
-		return cmp.Compare(a.Name(), b.Name())
+		return compareNames(a.Name(), b.Name())
 	})
 
+	listing = hideTwinEntries(hideNoisyEntries(o.presentListing(listing)))
+	if partial {
+		slog.Warn("archiveMountTimeout", "path", o, "entries", len(listing))
+		return listing, fskeleton.ErrIncomplete
+	}
 	return listing, nil
 }
 
 func (d *dir) ReadDir(count int) ([]fs.DirEntry, error) {
 	if d.lseek == 0 {
-		listing, err := d.path.cookedReadDir()
-		if err != nil {
+		listing, err := d.path.cookedReadDir(d.hurried)
+		if err != nil && !isIncomplete(err) {
 			return nil, err
 		}
-		d.list = listing
+		d.list, d.err = listing, err
 	}
 
 	// Implement those tricky partial-listing semantics
 	n := len(d.list) - d.lseek
 	if n == 0 && count > 0 {
-		return nil, io.EOF
+		return nil, cmp.Or(d.err, io.EOF)
 	}
 	if count > 0 && n > count {
 		n = count
@@ -93,6 +114,9 @@ func (d *dir) ReadDir(count int) ([]fs.DirEntry, error) {
 	list := make([]fs.DirEntry, n)
 	copy(list, d.list[d.lseek:][:n])
 	d.lseek += n
+	if count <= 0 {
+		return list, d.err
+	}
 	return list, nil
 }
 
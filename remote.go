@@ -0,0 +1,49 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/elliotnunn/BeHierarchic/internal/remotefs"
+)
+
+// A sharepoint can be the URL of another BeHierarchic server, making this one a caching edge node:
+// the files are listed from the other server's manifest and read with range requests,
+// and everything read is kept in this server's cache.
+// The manifest is first read when a file is looked up (usually by the startup prefetch),
+// so this server starts listening without waiting for it.
+
+func isRemoteSharepoint(target string) bool {
+	return strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://")
+}
+
+// isRemote is true for the files of a sharepoint on another server, for which every read is a round trip
+func (o path) isRemote() bool {
+	_, ok := o.fsys.(*remotefs.FS)
+	return ok
+}
+
+// refreshRemote polls the other server's manifest, and treats the files that have changed
+// as the drop-box treats new uploads
+func (fsys *FS) refreshRemote(remote *remotefs.FS, every time.Duration) {
+	for range time.Tick(every) {
+		changed, err := remote.Refresh()
+		if err != nil {
+			slog.Warn("remoteRefreshFail", "err", err)
+			continue
+		} else if len(changed) == 0 {
+			continue
+		}
+		slog.Info("remoteRefresh", "changed", len(changed))
+		for _, name := range changed {
+			fsys.forget(name)
+		}
+		for _, name := range changed {
+			fsys.probeUpload(name)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	gopath "path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// An old archive split across floppies, or nested three deep, is easier to pass on as one modern container.
+// The sidecars are repacked in the sibling style whatever -appledouble says,
+// in __MACOSX for a zip as the Finder's compressor does, and beside each file in a tar as bsdtar does,
+// so that macOS restores the Finder info and resource forks when it unpacks either.
+
+var repackFormats = []string{"zip", "tar.zst"}
+
+const repackHello = `Usage:  BeHierarchic repack [-format zip|tar.zst] CACHE SHAREPOINT PATH OUT
+
+Repacks a directory, which may be inside archives, as a single .zip or .tar.zst with maximal compression.
+Archives nested within it are repacked as files, not descended into. OUT may be - for standard output.`
+
+func repackCmd(args []string) error {
+	flags := flag.NewFlagSet("repack", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprintln(flags.Output(), repackHello) }
+	format := flags.String("format", "zip", "")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 4 || !slices.Contains(repackFormats, *format) {
+		return errors.New(repackHello)
+	}
+	cache, target, root, out := flags.Arg(0), flags.Arg(1), flags.Arg(2), flags.Arg(3)
+
+	s, err := os.Stat(target)
+	if err != nil {
+		return err
+	} else if !s.IsDir() {
+		return fmt.Errorf("%s: not a directory", target)
+	}
+	fsys := Wrapper(os.DirFS(target), cache)
+	root = strings.Trim(filepath.ToSlash(root), "/")
+	if root == "" {
+		root = "."
+	}
+	if stat, err := fs.Stat(fsys, root); err != nil {
+		return err
+	} else if !stat.IsDir() {
+		return fmt.Errorf("%s: not a directory", root)
+	}
+
+	if out == "-" {
+		return repack(context.Background(), fsys, root, *format, os.Stdout)
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	err = repack(context.Background(), fsys, root, *format, f)
+	return errors.Join(err, f.Close())
+}
+
+// maxRepacks is how many repacks the public address streams at once.
+// Each recompresses a whole tree while its client waits, so the rest are refused with 503,
+// and the read budget (see budget.go) cuts off any one that grows too large.
+const maxRepacks = 2
+
+var repackSlots = make(chan struct{}, maxRepacks)
+
+// repackAPI streams a directory as a .zip or .tar.zst.
+//
+//	GET /api/v1/repack?root=PATH[&format=zip|tar.zst]
+func repackAPI(fsys *FS, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	root := strings.Trim(r.URL.Query().Get("root"), "/")
+	if root == "" {
+		root = "."
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "zip"
+	} else if !slices.Contains(repackFormats, format) {
+		http.Error(w, "format must be one of "+strings.Join(repackFormats, ", "), http.StatusBadRequest)
+		return
+	}
+	if stat, err := fs.Stat(fsys, root); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if !stat.IsDir() {
+		http.Error(w, "can only repack a directory", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", repackType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, exportName(root), format))
+	if r.Method == "HEAD" {
+		return
+	}
+
+	select {
+	case repackSlots <- struct{}{}:
+		defer func() { <-repackSlots }()
+	default:
+		w.Header().Del("Content-Disposition")
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "too many repacks running, try again later", http.StatusServiceUnavailable)
+		return
+	}
+	err := repack(r.Context(), fsys, root, format, w)
+	if err != nil && r.Context().Err() == nil {
+		slog.Error("repackErr", "root", root, "format", format, "err", err)
+	}
+}
+
+func repackType(format string) string {
+	if format == "zip" {
+		return "application/zip"
+	}
+	return "application/zstd"
+}
+
+// repackWriter is the part of a zip or tar writer that repack needs
+type repackWriter interface {
+	dir(name string, mtime time.Time) error
+	file(name string, size int64, mode fs.FileMode, mtime time.Time) (io.Writer, error)
+	symlink(name, target string, mtime time.Time) error
+	sidecar(name string, size int64, mtime time.Time) (io.Writer, error) // name is of the file it belongs to
+	Close() error
+}
+
+func repack(ctx context.Context, fsys *FS, root, format string, w io.Writer) error {
+	var rw repackWriter
+	if format == "zip" {
+		zw := zip.NewWriter(w)
+		zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(w, flate.BestCompression)
+		})
+		rw = &zipRepacker{zw}
+	} else {
+		zw, err := zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+		if err != nil {
+			return err
+		}
+		rw = &tarRepacker{tar.NewWriter(zw), zw}
+	}
+
+	err := fs.WalkDir(fsys, root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if err := ctx.Err(); err != nil {
+			return err
+		} else if name == root {
+			return nil
+		} else if strings.HasSuffix(name, Special) {
+			return fs.SkipDir // repack nested archives as files
+		} else if isSidecar(d) || d.Name() == netatalkDir {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil // repacked alongside the file they belong to
+		}
+
+		rel := name
+		if root != "." {
+			rel = name[len(root)+1:]
+		}
+		o, err := fsys.path(name)
+		if err != nil {
+			return err
+		}
+		stat, err := o.cookedStat()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			err = rw.dir(rel, stat.ModTime())
+		case d.Type() == fs.ModeSymlink:
+			var target string
+			target, err = fs.ReadLink(o.fsys, o.name.String())
+			if err != nil {
+				slog.Warn("repackSymlinkErr", "path", name, "err", err)
+				return nil
+			}
+			err = rw.symlink(rel, repackLinkTarget(o.name.String(), target), stat.ModTime())
+		case d.Type().IsRegular():
+			err = repackCopy(o, func(size int64) (io.Writer, error) {
+				return rw.file(rel, size, stat.Mode(), stat.ModTime())
+			})
+		}
+		if err != nil {
+			return err
+		}
+
+		sidecar := path{o.container, o.fsys, o.name.Dir().Join("._" + o.name.Base())}
+		if _, err := sidecar.cookedStat(); err == nil {
+			err = repackCopy(sidecar, func(size int64) (io.Writer, error) {
+				return rw.sidecar(rel, size, stat.ModTime())
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return errors.Join(err, rw.Close())
+}
+
+func repackCopy(o path, create func(size int64) (io.Writer, error)) error {
+	stat, err := o.cookedStat()
+	if err != nil {
+		return err
+	}
+	f, err := o.cookedOpen()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w, err := create(stat.Size())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// repackLinkTarget makes the absolute target of a symlink at name into a relative one
+func repackLinkTarget(name, target string) string {
+	depth := strings.Count(name, "/")
+	return strings.Repeat("../", depth) + target
+}
+
+type zipRepacker struct{ *zip.Writer }
+
+func (z *zipRepacker) dir(name string, mtime time.Time) error {
+	h := &zip.FileHeader{Name: name + "/", Modified: mtime}
+	h.SetMode(fs.ModeDir | 0o755)
+	_, err := z.CreateHeader(h)
+	return err
+}
+
+func (z *zipRepacker) file(name string, size int64, mode fs.FileMode, mtime time.Time) (io.Writer, error) {
+	h := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: mtime, UncompressedSize64: uint64(size)}
+	h.SetMode(mode.Perm() | 0o644)
+	return z.CreateHeader(h)
+}
+
+func (z *zipRepacker) symlink(name, target string, mtime time.Time) error {
+	h := &zip.FileHeader{Name: name, Method: zip.Store, Modified: mtime}
+	h.SetMode(fs.ModeSymlink | 0o777)
+	w, err := z.CreateHeader(h)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, target)
+	return err
+}
+
+func (z *zipRepacker) sidecar(name string, size int64, mtime time.Time) (io.Writer, error) {
+	dir, base := gopath.Split(name)
+	return z.file("__MACOSX/"+dir+"._"+base, size, 0o644, mtime)
+}
+
+type tarRepacker struct {
+	*tar.Writer
+	zw *zstd.Encoder
+}
+
+func (t *tarRepacker) dir(name string, mtime time.Time) error {
+	return t.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: name + "/", Mode: 0o755, ModTime: mtime})
+}
+
+func (t *tarRepacker) file(name string, size int64, mode fs.FileMode, mtime time.Time) (io.Writer, error) {
+	err := t.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Size: size,
+		Mode: int64(mode.Perm() | 0o644), ModTime: mtime})
+	return t.Writer, err
+}
+
+func (t *tarRepacker) symlink(name, target string, mtime time.Time) error {
+	return t.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: name, Linkname: target, Mode: 0o777, ModTime: mtime})
+}
+
+func (t *tarRepacker) sidecar(name string, size int64, mtime time.Time) (io.Writer, error) {
+	dir, base := gopath.Split(name)
+	return t.file(dir+"._"+base, size, 0o644, mtime)
+}
+
+func (t *tarRepacker) Close() error {
+	return errors.Join(t.Writer.Close(), t.zw.Close())
+}
@@ -0,0 +1,220 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/cockroachdb/pebble/v2"
+)
+
+// Saved searches live in their own corner of the database.
+// No dbkey can collide because the first byte of a dbkey is the length of a fileid.ID.
+const savedSearchPrefix = "\xffsavedsearch/"
+
+// curator is "USER:PASSWORD", and if nonempty then searches can be saved
+var curator string
+
+// A savedSearch keeps the filters of the search page as well as its glob,
+// stored as the NUL-separated value "ROOT PATTERN SORT DAYS" (older records have only the first two)
+type savedSearch struct {
+	Name, Root, Pattern string
+	Sort                string // see searchSorts
+	Days                int    // see searchQuery
+}
+
+// URL returns the (lazily evaluated) search page for this saved search
+func (s savedSearch) URL() string {
+	return s.query().URL()
+}
+
+func (s savedSearch) query() searchQuery {
+	return searchQuery{root: s.Root, pattern: s.Pattern, sort: s.Sort, days: s.Days, limit: searchPageSize}
+}
+
+// describe is the search as shown on the front page, with its filters after the glob
+func (s savedSearch) describe() string {
+	d := gopathJoinForDisplay(s.Root, s.Pattern)
+	if s.Sort != "" {
+		d += ", " + s.Sort + " first"
+	}
+	if s.Days > 0 {
+		d += fmt.Sprintf(", modified in the last %d days", s.Days)
+	}
+	return d
+}
+
+var errNoDB = errors.New("no cache database, so nothing can be saved")
+
+func (fsys *FS) savedSearches() []savedSearch {
+	if fsys.db.Load() == nil {
+		return nil
+	}
+	iter, err := fsys.db.Load().NewIter(&pebble.IterOptions{
+		LowerBound: []byte(savedSearchPrefix),
+		UpperBound: []byte(savedSearchPrefix[:len(savedSearchPrefix)-1] + "0"), // '/'+1
+	})
+	if err != nil {
+		slog.Error("savedSearchIterErr", "err", err)
+		return nil
+	}
+	defer iter.Close()
+
+	var ret []savedSearch
+	for iter.First(); iter.Valid(); iter.Next() {
+		fields := bytes.Split(iter.Value(), []byte{0})
+		if len(fields) < 2 {
+			continue
+		}
+		s := savedSearch{
+			Name:    string(iter.Key()[len(savedSearchPrefix):]),
+			Root:    string(fields[0]),
+			Pattern: string(fields[1]),
+		}
+		if len(fields) >= 4 {
+			s.Sort = string(fields[2])
+			s.Days, _ = strconv.Atoi(string(fields[3]))
+		}
+		ret = append(ret, s)
+	}
+	return ret
+}
+
+func (fsys *FS) saveSearch(s savedSearch) error {
+	if fsys.db.Load() == nil {
+		return errNoDB
+	}
+	return fsys.db.Load().Set([]byte(savedSearchPrefix+s.Name), []byte(s.Root+"\x00"+s.Pattern+"\x00"+s.Sort+"\x00"+strconv.Itoa(s.Days)), pebble.Sync)
+}
+
+func (fsys *FS) deleteSearch(name string) error {
+	if fsys.db.Load() == nil {
+		return errNoDB
+	}
+	return fsys.db.Load().Delete([]byte(savedSearchPrefix+name), pebble.Sync)
+}
+
+func isCurator(r *http.Request) bool {
+	if curator == "" {
+		return false
+	}
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(user+":"+pass), []byte(curator)) == 1
+}
+
+func canSaveSearches(fsys *FS) bool { return curator != "" && fsys.db.Load() != nil }
+
+// saveSearchPost handles the "Save Search" and "Delete" form buttons.
+// The browser sends the curator's Basic auth to any page that posts here,
+// so a form posted from another site is refused, as are the browser's own cross-origin fetches.
+func saveSearchPost(fsys *FS, w http.ResponseWriter, r *http.Request, searchroot string) {
+	if err := new(http.CrossOriginProtection).Check(r); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if !isCurator(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="BeHierarchic curators"`)
+		http.Error(w, "curator login required to save searches", http.StatusUnauthorized)
+		return
+	}
+
+	name := strings.TrimSpace(r.PostFormValue("name"))
+	if name == "" || strings.ContainsRune(name, 0) {
+		http.Error(w, "a saved search needs a name", http.StatusBadRequest)
+		return
+	}
+
+	if r.PostFormValue("delete") != "" {
+		err := fsys.deleteSearch(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		slog.Info("savedSearchDelete", "name", name)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	s := savedSearch{Name: name, Root: searchroot, Pattern: r.PostFormValue("q"), Sort: r.PostFormValue("sort")}
+	if !doublestar.ValidatePattern(s.Pattern) {
+		http.Error(w, "not a valid glob pattern", http.StatusBadRequest)
+		return
+	}
+	if s.Sort != "" && !slices.Contains(searchSorts, s.Sort) {
+		http.Error(w, "sort must be one of "+strings.Join(searchSorts, ", "), http.StatusBadRequest)
+		return
+	}
+	if d := r.PostFormValue("days"); d != "" {
+		var err error
+		s.Days, err = strconv.Atoi(d)
+		if err != nil || s.Days < 0 {
+			http.Error(w, "days must be a whole number", http.StatusBadRequest)
+			return
+		}
+	}
+	err := fsys.saveSearch(s)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.Info("savedSearchSet", "name", s.Name, "root", s.Root, "pattern", s.Pattern, "sort", s.Sort, "days", s.Days)
+	http.Redirect(w, r, s.URL(), http.StatusSeeOther)
+}
+
+// listSavedSearches goes on the front page
+func listSavedSearches(fsys *FS, w io.Writer) {
+	list := fsys.savedSearches()
+	if len(list) == 0 {
+		return
+	}
+	fmt.Fprint(w, "<h3>Saved Searches</h3><pre>")
+	for _, s := range list {
+		fmt.Fprintf(w, `<a href="%s">%s</a>  %s`,
+			htmlReplacer.Replace(s.URL()),
+			htmlReplacer.Replace(s.Name),
+			htmlReplacer.Replace(s.describe()))
+		if curator != "" {
+			fmt.Fprintf(w, `  <form action="/.glob.html" method="POST" style="display:inline">`+
+				`<input type="hidden" name="name" value="%s">`+
+				`<button type="submit" name="delete" value="1">Delete</button></form>`,
+				htmlReplacer.Replace(s.Name))
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprint(w, "</pre>")
+}
+
+// saveSearchForm goes on the search results page
+func saveSearchForm(fsys *FS, w io.Writer, sq searchQuery) {
+	if !canSaveSearches(fsys) || sq.pattern == "" {
+		return
+	}
+	fmt.Fprintf(w, `<form action=".glob.html" method="POST">`+
+		`<input type="hidden" name="q" value="%s">`+
+		`<input type="hidden" name="sort" value="%s">`+
+		`<input type="hidden" name="days" value="%d">`+
+		`<input type="text" name="name" size="30" placeholder="Name for this search">`+
+		`<button type="submit">Save Search</button></form>`,
+		htmlReplacer.Replace(sq.pattern), htmlReplacer.Replace(sq.sort), sq.days)
+}
+
+func gopathJoinForDisplay(root, pattern string) string {
+	if root == "." {
+		return pattern
+	}
+	return root + "/" + pattern
+}
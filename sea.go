@@ -0,0 +1,62 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"io"
+
+	"github.com/elliotnunn/BeHierarchic/internal/macbinary"
+)
+
+// A self-extracting archive keeps its 68k extractor in the resource fork, and the archive in the data fork.
+// On its own the data fork is found by the usual StuffIt probe, but a .sea is more often downloaded
+// flattened into MacBinary or AppleSingle, which puts the extractor and a header in front of the archive.
+//
+// Compact Pro self-extractors have the same layout, but there is no Compact Pro reader yet.
+
+func isStuffIt(head []byte) bool {
+	return len(head) >= 16 &&
+		(string(head[:16]) == "StuffIt (c)1997-" || head[0] == 'S' && string(head[10:14]) == "rLau")
+}
+
+// seaRange finds the archive inside a flattened StuffIt self-extracting application
+func seaRange(r io.ReaderAt) (offset, size int64, ok bool) {
+	h := make([]byte, macbinary.HeaderSize)
+	if n, _ := r.ReadAt(h, 0); n < len(h) {
+		return 0, 0, false
+	}
+
+	var finderType string
+	if string(h[:4]) == "\x00\x05\x16\x00" { // AppleSingle
+		fioff, fisize, err := appleEntryRange(r, 9, 4)
+		if err != nil || fisize == 0 {
+			return 0, 0, false
+		}
+		var fi [4]byte
+		if n, _ := r.ReadAt(fi[:], fioff); n < len(fi) {
+			return 0, 0, false
+		}
+		finderType = string(fi[:])
+		offset, size, err = appleEntryRange(r, 1, 22)
+		if err != nil || size == 0 {
+			return 0, 0, false
+		}
+	} else {
+		hdr, err := macbinary.ParseHeader(h)
+		if err != nil || hdr.HasCRC && !hdr.CRCOK {
+			return 0, 0, false
+		}
+		finderType = string(hdr.Type[:])
+		offset, size = macbinary.HeaderSize+(hdr.SecondaryLen+127)&^127, hdr.DataLen
+	}
+	if finderType != "APPL" {
+		return 0, 0, false
+	}
+
+	head := make([]byte, 16)
+	if n, _ := r.ReadAt(head, offset); n < len(head) || !isStuffIt(head) {
+		return 0, 0, false
+	}
+	return offset, size, true
+}
@@ -0,0 +1,465 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	stdtar "archive/tar"
+	stdzip "archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	_ "embed"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/elliotnunn/BeHierarchic/internal/appledouble"
+	"github.com/elliotnunn/BeHierarchic/internal/scratch"
+)
+
+const selftestHello = `Usage:  BeHierarchic selftest [-v] [-keep]
+
+Serves a generated corpus of small archives from a temporary directory,
+and checks the answers to a script of WebDAV and HTTP requests,
+to validate a build before it is deployed.`
+
+// selftestSeed is a gzipped tar containing a zip containing an HFS disk image
+//
+//go:embed testdata/archive.tgz
+var selftestSeed []byte
+
+const (
+	seedText     = "Macintosh HD/hello world.txt" // within the disk image
+	sidecarCount = 16
+)
+
+var selftestResource = []byte("A resource, in a resource fork, in an AppleDouble file")
+
+func selftestCmd(args []string) error {
+	flags := flag.NewFlagSet("selftest", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), selftestHello)
+		flags.PrintDefaults()
+	}
+	verbose := flags.Bool("v", false, "print every request")
+	keep := flags.Bool("keep", false, "keep the corpus and cache afterwards")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	} else if flags.NArg() != 0 {
+		return errors.New(selftestHello)
+	}
+
+	dir, err := os.MkdirTemp("", "BeHierarchic-selftest-")
+	if err != nil {
+		return err
+	}
+	if *keep {
+		fmt.Println("corpus and cache in", dir)
+	} else {
+		defer os.RemoveAll(dir)
+	}
+	share, cache := filepath.Join(dir, "share"), filepath.Join(dir, "cache")
+	if err := writeCorpus(share); err != nil {
+		return fmt.Errorf("generating the corpus: %w", err)
+	}
+	scratchSpace, err = scratch.New(filepath.Join(dir, "scratch"), 64<<20)
+	if err != nil {
+		return err
+	}
+
+	fsys := Wrapper(os.DirFS(share), cache)
+	fsys.Prefetch()
+	srv := httptest.NewServer(handler(fsys))
+	defer srv.Close()
+
+	st := &selftest{base: srv.URL, client: srv.Client(), verbose: *verbose}
+	st.run()
+	fmt.Printf("%d passed, %d failed\n", st.passed, st.failed)
+	if st.failed > 0 {
+		return errors.New("selftest failed")
+	}
+	return nil
+}
+
+// writeCorpus makes the sharepoint: nested archives, a disk image in a StuffIt archive, and a tree full of sidecars
+func writeCorpus(share string) error {
+	disk, err := seedDiskImage()
+	if err != nil {
+		return err
+	}
+	rfork := resourceForkOf("TEXT", 128, selftestResource)
+	sidecar := appleDoubleOf("TEXTttxt", rfork)
+	files := map[string][]byte{
+		"archive.tgz": selftestSeed,
+		"disk.sit": stuffItOf([]sitMember{
+			{name: "disk.img", typ: "dImgdCpy", data: disk},
+			{name: "Read Me", typ: "TEXTttxt", data: []byte("Read me\r"), rsrc: rfork},
+		}),
+		"sidecars/notes.zip": zipOf(map[string][]byte{
+			"Note":            []byte("Note\n"),
+			"__MACOSX/._Note": sidecar,
+		}),
+	}
+	for i := range sidecarCount {
+		name := fmt.Sprintf("sidecars/Note %d", i)
+		files[name] = []byte(name + "\n")
+		files[filepath.Dir(name)+"/._"+filepath.Base(name)] = sidecar
+	}
+	for name, data := range files {
+		host := filepath.Join(share, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(host), 0o755); err != nil {
+			return err
+		} else if err := os.WriteFile(host, data, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seedDiskImage digs the disk image out of the seed with the standard library, to be packed another way
+func seedDiskImage() ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(selftestSeed))
+	if err != nil {
+		return nil, err
+	}
+	tr := stdtar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err != nil {
+			return nil, err
+		} else if filepath.Ext(h.Name) != ".zip" {
+			continue
+		}
+		z, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		zr, err := stdzip.NewReader(bytes.NewReader(z), int64(len(z)))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range zr.File {
+			if filepath.Ext(f.Name) == ".img" {
+				r, err := f.Open()
+				if err != nil {
+					return nil, err
+				}
+				defer r.Close()
+				return io.ReadAll(r)
+			}
+		}
+		return nil, errors.New("no disk image in the seed")
+	}
+}
+
+// resourceForkOf makes a resource fork with a single resource
+func resourceForkOf(typ string, id int16, data []byte) []byte {
+	be := binary.BigEndian
+	const dataOffset, mapLen = 256, 50
+	dataLen := 4 + len(data)
+	fork := make([]byte, dataOffset+dataLen+mapLen)
+	header := fork[:16]
+	be.PutUint32(header, dataOffset)
+	be.PutUint32(header[4:], uint32(dataOffset+dataLen))
+	be.PutUint32(header[8:], uint32(dataLen))
+	be.PutUint32(header[12:], mapLen)
+	be.PutUint32(fork[dataOffset:], uint32(len(data)))
+	copy(fork[dataOffset+4:], data)
+
+	rmap := fork[dataOffset+dataLen:]
+	copy(rmap, header)
+	be.PutUint16(rmap[24:], 28)     // type list
+	be.PutUint16(rmap[26:], mapLen) // name list, empty
+	be.PutUint16(rmap[28:], 0)      // one type
+	copy(rmap[30:], typ)            //
+	be.PutUint16(rmap[34:], 0)      // with one resource
+	be.PutUint16(rmap[36:], 10)     // whose reference is after the type list
+	be.PutUint16(rmap[38:], uint16(id))
+	be.PutUint16(rmap[40:], 0xffff) // no name
+	return fork
+}
+
+// appleDoubleOf makes a sidecar with Finder info and a resource fork
+func appleDoubleOf(typeCreator string, rfork []byte) []byte {
+	finfo := make([]byte, 32)
+	copy(finfo, typeCreator)
+	prefix, rOffset := appledouble.MakePrefix(map[int][]byte{appledouble.FINDER_INFO: finfo}, int64(len(rfork)), 0)
+	ad := make([]byte, rOffset, rOffset+int64(len(rfork)))
+	copy(ad, prefix)
+	return append(ad, rfork...)
+}
+
+type sitMember struct {
+	name       string
+	typ        string // type and creator
+	data, rsrc []byte
+}
+
+// stuffItOf makes a StuffIt 1.5 archive with the forks stored uncompressed
+func stuffItOf(members []sitMember) []byte {
+	be := binary.BigEndian
+	mtime := uint32(time.Date(1999, 12, 31, 0, 0, 0, 0, time.UTC).Sub(time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)) / time.Second)
+	ar := make([]byte, 22)
+	copy(ar, "SIT!")
+	be.PutUint16(ar[4:], uint16(len(members)))
+	copy(ar[10:], "rLau")
+	ar[14] = 2
+	for _, m := range members {
+		h := make([]byte, 112)
+		h[2] = byte(len(m.name))
+		copy(h[3:], m.name)
+		copy(h[66:], m.typ)
+		be.PutUint32(h[76:], mtime)
+		be.PutUint32(h[80:], mtime)
+		be.PutUint32(h[84:], uint32(len(m.rsrc)))
+		be.PutUint32(h[88:], uint32(len(m.data)))
+		be.PutUint32(h[92:], uint32(len(m.rsrc)))
+		be.PutUint32(h[96:], uint32(len(m.data)))
+		be.PutUint16(h[100:], arcCRC16(m.rsrc))
+		be.PutUint16(h[102:], arcCRC16(m.data))
+		be.PutUint16(h[110:], arcCRC16(h[:110]))
+		ar = append(ar, h...)
+		ar = append(ar, m.rsrc...)
+		ar = append(ar, m.data...)
+	}
+	be.PutUint32(ar[6:], uint32(len(ar)))
+	return ar
+}
+
+// arcCRC16 is the reflected CRC-16 that StuffIt uses, unlike the CRC-16 of BinHex and MacBinary
+func arcCRC16(p []byte) uint16 {
+	var crc uint16
+	for _, b := range p {
+		crc ^= uint16(b)
+		for range 8 {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xa001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+func zipOf(files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	zw := stdzip.NewWriter(&buf)
+	for name, data := range files {
+		w, _ := zw.Create(name)
+		w.Write(data)
+	}
+	zw.Close()
+	return buf.Bytes()
+}
+
+type selftest struct {
+	base           string
+	client         *http.Client
+	verbose        bool
+	passed, failed int
+}
+
+func (st *selftest) check(name string, err error) {
+	if err != nil {
+		st.failed++
+		fmt.Printf("FAIL %s: %v\n", name, err)
+	} else {
+		st.passed++
+		fmt.Printf("ok   %s\n", name)
+	}
+}
+
+// do makes a request, expecting a status
+func (st *selftest) do(method, name string, header map[string]string, status int) ([]byte, http.Header, error) {
+	name, query, _ := strings.Cut(name, "?")
+	u := st.base + "/" + (&url.URL{Path: name, RawQuery: query}).String()
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := st.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if st.verbose {
+		fmt.Printf("     %s %s %s -> %s, %d bytes\n", method, name, header, resp.Status, len(body))
+	}
+	if err != nil {
+		return nil, nil, err
+	} else if resp.StatusCode != status {
+		return body, resp.Header, fmt.Errorf("%s %s: expected status %d, got %s", method, name, status, resp.Status)
+	}
+	return body, resp.Header, nil
+}
+
+func (st *selftest) get(name string) ([]byte, error) {
+	body, _, err := st.do("GET", name, nil, http.StatusOK)
+	return body, err
+}
+
+func (st *selftest) run() {
+	ar := "◆"
+	nestedText := "archive.tgz" + ar + "/archive.tar" + ar + "/archive.zip" + ar + "/disk.img" + ar + "/" + seedText
+	sitText := "disk.sit" + ar + "/disk.img" + ar + "/" + seedText
+
+	var text []byte
+	st.check("GET a file in HFS in zip in tar in gzip", func() (err error) {
+		text, err = st.get(nestedText)
+		if err == nil && len(text) == 0 {
+			err = errors.New("empty")
+		}
+		return err
+	}())
+
+	st.check("GET the same file in HFS in StuffIt", func() error {
+		got, err := st.get(sitText)
+		if err == nil && !bytes.Equal(got, text) {
+			err = fmt.Errorf("expected %q, got %q", text, got)
+		}
+		return err
+	}())
+
+	st.check("GET a Range", func() error {
+		if len(text) < 4 {
+			return errors.New("file too short for the test")
+		}
+		got, h, err := st.do("GET", sitText, map[string]string{"Range": "bytes=1-3"}, http.StatusPartialContent)
+		if err == nil && !bytes.Equal(got, text[1:4]) {
+			err = fmt.Errorf("expected %q, got %q", text[1:4], got)
+		} else if err == nil && h.Get("Content-Range") != fmt.Sprintf("bytes 1-3/%d", len(text)) {
+			err = fmt.Errorf("unexpected Content-Range %q", h.Get("Content-Range"))
+		}
+		return err
+	}())
+
+	st.check("GET a Range beyond the end", func() error {
+		_, _, err := st.do("GET", nestedText, map[string]string{"Range": "bytes=100000-"}, http.StatusRequestedRangeNotSatisfiable)
+		return err
+	}())
+
+	st.check("HEAD", func() error {
+		_, h, err := st.do("HEAD", nestedText, nil, http.StatusOK)
+		if err == nil && h.Get("Content-Length") != fmt.Sprint(len(text)) {
+			err = fmt.Errorf("expected Content-Length %d, got %q", len(text), h.Get("Content-Length"))
+		}
+		return err
+	}())
+
+	st.check("GET a missing file", func() error {
+		_, _, err := st.do("GET", "archive.tgz"+ar+"/no such file", nil, http.StatusNotFound)
+		return err
+	}())
+
+	st.check("PROPFIND the sharepoint", func() error {
+		body, _, err := st.do("PROPFIND", "", map[string]string{"Depth": "1"}, http.StatusMultiStatus)
+		return expectHrefs(body, err, "archive.tgz", "disk.sit", "sidecars/")
+	}())
+
+	st.check("PROPFIND within a StuffIt archive", func() error {
+		body, _, err := st.do("PROPFIND", "disk.sit"+ar+"/", map[string]string{"Depth": "1"}, http.StatusMultiStatus)
+		return expectHrefs(body, err, "disk.img", "Read%20Me", "._Read%20Me")
+	}())
+
+	st.check("PROPFIND a tree full of sidecars", func() error {
+		body, _, err := st.do("PROPFIND", "sidecars/", map[string]string{"Depth": "1"}, http.StatusMultiStatus)
+		if err != nil {
+			return err
+		}
+		if n := bytes.Count(body, []byte("/._Note%20")); n != 2*sidecarCount {
+			return fmt.Errorf("expected %d sidecars and their resource forks, found %d", 2*sidecarCount, n)
+		}
+		return nil
+	}())
+
+	for _, rsrc := range []string{
+		"sidecars/._Note 0" + ar + "/TEXT/128",
+		"sidecars/notes.zip" + ar + "/._Note" + ar + "/TEXT/128",
+		"disk.sit" + ar + "/._Read Me" + ar + "/TEXT/128",
+	} {
+		st.check("GET a resource at "+rsrc, func() error {
+			got, err := st.get(rsrc)
+			if err == nil && !bytes.Equal(got, selftestResource) {
+				err = fmt.Errorf("expected %q, got %q", selftestResource, got)
+			}
+			return err
+		}())
+	}
+
+	st.check("search API", func() error {
+		body, _, err := st.do("GET", "api/v1/search?q="+url.QueryEscape("**/hello world.txt"), nil, http.StatusOK)
+		return expectCount(body, err, 2)
+	}())
+
+	st.check("search page", func() error {
+		body, _, err := st.do("GET", ".glob.html?q="+url.QueryEscape("**/hello world.txt"), nil, http.StatusOK)
+		if err == nil && !bytes.Contains(body, []byte("hello world.txt")) {
+			err = errors.New("no match on the page")
+		}
+		return err
+	}())
+
+	st.check("manifest", func() error {
+		body, _, err := st.do("GET", "sidecars/.manifest", nil, http.StatusOK)
+		return expectCount(body, err, 2*sidecarCount+1)
+	}())
+
+	st.check("directory page", func() error {
+		body, _, err := st.do("GET", "archive.tgz"+ar+"/archive.tar"+ar+"/", nil, http.StatusOK)
+		if err == nil && !bytes.Contains(body, []byte("archive.zip")) {
+			err = errors.New("archive.zip not listed")
+		}
+		return err
+	}())
+}
+
+// expectHrefs looks for hrefs ending with each suffix in a multistatus response
+func expectHrefs(body []byte, err error, suffixes ...string) error {
+	if err != nil {
+		return err
+	}
+	for _, s := range suffixes {
+		if !bytes.Contains(body, []byte("/"+s+"</")) && !bytes.Contains(body, []byte("/"+s+"/</")) {
+			return fmt.Errorf("no href to %s", s)
+		}
+	}
+	return nil
+}
+
+// expectCount reads the final {"count":N} line of a newline-delimited JSON response
+func expectCount(body []byte, err error, want int) error {
+	if err != nil {
+		return err
+	}
+	var last []byte
+	sc := bufio.NewScanner(bytes.NewReader(body))
+	for sc.Scan() {
+		last = sc.Bytes()
+	}
+	var counts struct {
+		Count *int `json:"count"`
+	}
+	if err := json.Unmarshal(last, &counts); err != nil || counts.Count == nil {
+		return fmt.Errorf("no count line in %q", strings.TrimSpace(string(body)))
+	} else if *counts.Count != want {
+		return fmt.Errorf("expected %d, got %d", want, *counts.Count)
+	}
+	return nil
+}
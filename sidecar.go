@@ -0,0 +1,277 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+	"time"
+)
+
+// sidecarStyle is how the "._name" AppleDouble files that archives generate for their
+// Finder info and resource forks are presented. Files in the sharepoint itself are left alone.
+var sidecarStyle = sidecarSibling
+
+const (
+	sidecarSibling   = "sibling"   // dir/._name, as macOS writes to foreign volumes
+	sidecarNetatalk  = "netatalk"  // dir/.AppleDouble/name
+	sidecarNamedFork = "namedfork" // dir/name/..namedfork/rsrc, the bare resource fork, as macOS accepts
+	sidecarHidden    = "hidden"
+)
+
+var sidecarStyles = []string{sidecarSibling, sidecarNetatalk, sidecarNamedFork, sidecarHidden}
+
+const (
+	netatalkDir  = ".AppleDouble"
+	namedForkDir = "..namedfork"
+)
+
+type presentation int
+
+const (
+	presentAsIs         presentation = iota
+	presentRenamed                   // a netatalk sidecar, named without the "._"
+	presentAppleDouble               // a netatalk .AppleDouble directory
+	presentResourceFork              // the resource fork within a sidecar
+)
+
+// unpresent translates a name in the chosen sidecar style back to the sibling style
+// that the archive FSs generate, and says which kind of synthetic object it refers to.
+// A sidecar's sibling name is not valid in other styles.
+func unpresent(name string) (string, presentation, error) {
+	if sidecarStyle == sidecarSibling {
+		return name, presentAsIs, nil
+	}
+	comps := strings.Split(name, "/")
+	start := slices.IndexFunc(comps, func(c string) bool { return strings.HasSuffix(c, Special) }) + 1
+	if start == 0 {
+		return name, presentAsIs, nil // not within an archive
+	}
+
+	kind := presentAsIs
+	out := comps[:start:start]
+	for i := start; i < len(comps); i++ {
+		c := comps[i]
+		if strings.HasPrefix(c, "._") {
+			return "", 0, fs.ErrNotExist
+		}
+		switch {
+		case sidecarStyle == sidecarNetatalk && c == netatalkDir && i == len(comps)-1:
+			kind = presentAppleDouble
+			continue
+		case sidecarStyle == sidecarNetatalk && c == netatalkDir:
+			i++
+			c = "._" + comps[i]
+			if i == len(comps)-1 {
+				kind = presentRenamed
+			}
+		case sidecarStyle == sidecarNamedFork && i == len(comps)-3 &&
+			comps[i+1] == namedForkDir && comps[i+2] == "rsrc":
+			out = append(out, "._"+c)
+			return strings.Join(out, "/"), presentResourceFork, nil
+		}
+		out = append(out, c)
+	}
+	if len(out) == 0 {
+		return ".", kind, nil
+	}
+	return strings.Join(out, "/"), kind, nil
+}
+
+// presentListing rearranges the sidecars in a directory listing within an archive
+func (o path) presentListing(listing []fs.DirEntry) []fs.DirEntry {
+	if sidecarStyle == sidecarSibling || o.fsys == o.container.root {
+		return listing
+	}
+	n := len(listing)
+	listing = slices.DeleteFunc(listing, isSidecar)
+	if sidecarStyle == sidecarNetatalk && len(listing) < n {
+		listing = append(listing, appleDoubleDirEntry{o})
+		slices.SortFunc(listing, func(a, b fs.DirEntry) int { return compareNames(a.Name(), b.Name()) })
+	}
+	return listing
+}
+
+func isSidecar(de fs.DirEntry) bool { return strings.HasPrefix(de.Name(), "._") }
+
+type renamedEntry struct {
+	fs.DirEntry
+	name string
+}
+
+func (de renamedEntry) Name() string { return de.name }
+func (de renamedEntry) Info() (fs.FileInfo, error) {
+	stat, err := de.DirEntry.Info()
+	if err != nil {
+		return nil, err
+	}
+	return renamedStat{stat, de.name}, nil
+}
+
+type renamedStat struct {
+	fs.FileInfo
+	name string
+}
+
+func (s renamedStat) Name() string { return s.name }
+func (s renamedStat) Inode() uint64 {
+	if i, ok := s.FileInfo.(inoder); ok {
+		return i.Inode()
+	}
+	return 0
+}
+
+// The netatalk .AppleDouble directory (o is its parent)
+
+type appleDoubleDirEntry struct{ o path }
+
+func (de appleDoubleDirEntry) Name() string               { return netatalkDir }
+func (de appleDoubleDirEntry) Type() fs.FileMode          { return fs.ModeDir }
+func (de appleDoubleDirEntry) IsDir() bool                { return true }
+func (de appleDoubleDirEntry) Info() (fs.FileInfo, error) { return de.o.appleDoubleStat() }
+
+func (o path) appleDoubleStat() (fs.FileInfo, error) {
+	parent, err := o.cookedStat()
+	if err != nil {
+		return nil, err
+	}
+	listing, err := o.rawReadDir()
+	if err != nil && !isIncomplete(err) {
+		return nil, err
+	} else if !slices.ContainsFunc(listing, isSidecar) {
+		return nil, fs.ErrNotExist
+	}
+	return syntheticStat{
+		name:  netatalkDir,
+		mode:  fs.ModeDir | 0o555,
+		mtime: parent.ModTime(),
+		o:     o.ShallowJoin(netatalkDir),
+	}, nil
+}
+
+func (o path) appleDoubleReadDir() ([]fs.DirEntry, error) {
+	listing, err := o.rawReadDir()
+	if err != nil && !isIncomplete(err) {
+		return nil, err
+	}
+	var sidecars []fs.DirEntry
+	for _, de := range listing {
+		if !isSidecar(de) {
+			continue
+		}
+		sidecar := o.ShallowJoin(de.Name())
+		name := strings.TrimPrefix(de.Name(), "._")
+		sidecars = append(sidecars, renamedEntry{fileDirEntry{path: sidecar, mode: de.Type()}, name})
+		if isar, _ := sidecar.getArchive(true, false); isar {
+			sidecars = append(sidecars, renamedEntry{mountpointDirEntry{outer: sidecar}, name + Special})
+		}
+	}
+	if len(sidecars) == 0 {
+		return nil, fs.ErrNotExist
+	}
+	slices.SortFunc(sidecars, func(a, b fs.DirEntry) int { return compareNames(a.Name(), b.Name()) })
+	return hideNoisyEntries(sidecars), nil
+}
+
+func (o path) openAppleDouble() (fs.File, error) {
+	stat, err := o.appleDoubleStat()
+	if err != nil {
+		return nil, err
+	}
+	list, err := o.appleDoubleReadDir()
+	if err != nil {
+		return nil, err
+	}
+	return &syntheticDir{path: o.ShallowJoin(netatalkDir), stat: stat, list: list}, nil
+}
+
+type syntheticDir struct {
+	path path
+	stat fs.FileInfo
+	list []fs.DirEntry
+}
+
+func (d *syntheticDir) Stat() (fs.FileInfo, error) { return d.stat, nil }
+func (d *syntheticDir) Close() error               { return nil }
+func (d *syntheticDir) Read(p []byte) (int, error) { return 0, io.EOF }
+
+func (d *syntheticDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	if count <= 0 {
+		list := d.list
+		d.list = nil
+		return list, nil
+	} else if len(d.list) == 0 {
+		return nil, io.EOF
+	}
+	list := d.list[:min(count, len(d.list))]
+	d.list = d.list[len(list):]
+	return list, nil
+}
+
+// The macOS name/..namedfork/rsrc file (o is the sidecar)
+
+func (o path) resourceForkStat() (fs.FileInfo, error) {
+	f, err := o.openResourceFork()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Stat()
+}
+
+func (o path) openResourceFork() (fs.File, error) {
+	stat, err := o.cookedStat()
+	if err != nil {
+		return nil, err
+	} else if !stat.Mode().IsRegular() {
+		return nil, fs.ErrNotExist
+	}
+	f, err := o.cookedOpen()
+	if err != nil {
+		return nil, err
+	}
+	ra, ok := f.(io.ReaderAt)
+	if !ok {
+		f.Close()
+		return nil, fmt.Errorf("sidecar without ReadAt: %s", o)
+	}
+	offset, size, err := resourceForkRange(ra)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &resourceForkFile{
+		SectionReader: io.NewSectionReader(ra, offset, size),
+		closer:        f,
+		stat:          syntheticStat{name: "rsrc", size: size, mode: 0o444, mtime: stat.ModTime(), o: o},
+	}, nil
+}
+
+type resourceForkFile struct {
+	*io.SectionReader
+	closer io.Closer
+	stat   fs.FileInfo
+}
+
+func (f *resourceForkFile) Stat() (fs.FileInfo, error) { return f.stat, nil }
+func (f *resourceForkFile) Close() error               { return f.closer.Close() }
+
+type syntheticStat struct {
+	name  string
+	size  int64
+	mode  fs.FileMode
+	mtime time.Time
+	o     path // for the inode number
+}
+
+func (s syntheticStat) Name() string       { return s.name }
+func (s syntheticStat) Size() int64        { return s.size }
+func (s syntheticStat) Mode() fs.FileMode  { return s.mode }
+func (s syntheticStat) ModTime() time.Time { return s.mtime }
+func (s syntheticStat) IsDir() bool        { return s.mode.IsDir() }
+func (s syntheticStat) Sys() any           { return nil }
+func (s syntheticStat) Inode() uint64      { return s.o.inode(s.IsDir()) }
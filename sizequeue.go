@@ -0,0 +1,137 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"sync"
+
+	"github.com/cockroachdb/pebble/v2"
+)
+
+// Finding the size of a file born without one (a gzip stream, say) means decompressing all of it.
+// Prefetch leaves that to a few background workers so that one huge stream cannot stall the scan.
+// The queue lives in the database, so sizes still owed at shutdown are worked out after a restart.
+
+const (
+	sizeQueuePrefix = "\xffsizequeue/" // + textual path
+	sizeWorkers     = 2
+	sizeBatch       = 256
+)
+
+type sizeQueue struct {
+	once sync.Once
+	wake chan struct{}
+
+	mu   sync.Mutex
+	idle *sync.Cond
+	busy bool
+}
+
+// queueSize puts off hardWonSize, unless there is no database to keep the queue in,
+// and in a dry run only says that it would have
+func (o path) queueSize() {
+	fsys := o.container
+	if fsys.dryRun != nil {
+		o.traceSize() // and leave the slow part undone
+		return
+	} else if fsys.db.Load() == nil {
+		o.hardWonSize()
+		return
+	}
+	err := fsys.db.Load().Set([]byte(sizeQueuePrefix+o.String()), nil, &pebble.WriteOptions{})
+	if err != nil {
+		slog.Error("sizeQueueError", "path", o, "err", err)
+		return
+	}
+	fsys.startSizeQueue()
+}
+
+// startSizeQueue also resumes the work left over from a previous run
+func (fsys *FS) startSizeQueue() {
+	if fsys.db.Load() == nil {
+		return
+	}
+	q := &fsys.sizeQ
+	q.once.Do(func() {
+		q.wake = make(chan struct{}, 1)
+		q.idle = sync.NewCond(&q.mu)
+		go fsys.sizeDispatcher()
+	})
+	q.mu.Lock()
+	q.busy = true
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// waitSizeQueue returns when every queued size has been worked out
+func (fsys *FS) waitSizeQueue() {
+	q := &fsys.sizeQ
+	if q.idle == nil {
+		return // never started
+	}
+	q.mu.Lock()
+	for q.busy {
+		q.idle.Wait()
+	}
+	q.mu.Unlock()
+}
+
+func (fsys *FS) sizeDispatcher() {
+	q := &fsys.sizeQ
+	slots := make(chan struct{}, sizeWorkers)
+	for range q.wake {
+		for {
+			names := fsys.queuedSizes()
+			if len(names) == 0 {
+				break
+			}
+			var wg sync.WaitGroup
+			for _, name := range names {
+				slots <- struct{}{}
+				wg.Go(func() {
+					defer func() { <-slots }()
+					fsys.dequeueSize(name)
+				})
+			}
+			wg.Wait()
+		}
+
+		q.mu.Lock()
+		if len(q.wake) == 0 { // else more was queued since the last look
+			q.busy = false
+			q.idle.Broadcast()
+		}
+		q.mu.Unlock()
+	}
+}
+
+func (fsys *FS) queuedSizes() (names []string) {
+	iter, err := fsys.db.Load().NewIter(&pebble.IterOptions{
+		LowerBound: []byte(sizeQueuePrefix),
+		UpperBound: []byte(sizeQueuePrefix + "\xff"),
+	})
+	if err != nil {
+		slog.Error("sizeQueueError", "err", err)
+		return nil
+	}
+	defer iter.Close()
+	for iter.First(); iter.Valid() && len(names) < sizeBatch; iter.Next() {
+		names = append(names, string(bytes.TrimPrefix(iter.Key(), []byte(sizeQueuePrefix))))
+	}
+	return names
+}
+
+func (fsys *FS) dequeueSize(name string) {
+	if o, err := fsys.path(name); err != nil {
+		slog.Warn("sizeQueueGone", "path", name, "err", err) // deleted since it was queued
+	} else if _, err := o.rawStat(); err == nil {
+		o.hardWonSize()
+	}
+	fsys.db.Load().Delete([]byte(sizeQueuePrefix+name), &pebble.WriteOptions{})
+}
@@ -0,0 +1,110 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	gopath "path"
+	"path/filepath"
+	"strings"
+)
+
+const snapshotHello = `Usage:  BeHierarchic snapshot CACHE SHAREPOINT OUT
+
+Renders every directory page (including those inside archives) as static HTML,
+with relative links, so that the index can be hosted anywhere. File contents are not copied.`
+
+func snapshotCmd(args []string) error {
+	if len(args) != 3 {
+		return errors.New(snapshotHello)
+	}
+	cache, target, out := args[0], args[1], args[2]
+
+	s, err := os.Stat(target)
+	if err != nil {
+		return err
+	} else if !s.IsDir() {
+		return fmt.Errorf("%s: not a directory", target)
+	}
+
+	fsys := Wrapper(os.DirFS(target), cache)
+	n, err := snapshotDir(fsys, ".", out)
+	slog.Info("snapshotDone", "dirs", n, "out", out)
+	return err
+}
+
+// snapshotDir writes OUT/NAME/index.html and recurses, skipping unreadable directories
+func snapshotDir(fsys *FS, name, out string) (n int, err error) {
+	list, listErr := fsys.ReadDir(name)
+	if listErr != nil {
+		slog.Warn("snapshotReadDirErr", "path", name, "err", listErr)
+	}
+
+	dir := filepath.Join(out, filepath.FromSlash(name))
+	err = os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.Create(filepath.Join(dir, "index.html"))
+	if err != nil {
+		return 0, err
+	}
+	bw := bufio.NewWriter(f)
+	snapshotPage(bw, name, list, listErr)
+	err = errors.Join(bw.Flush(), f.Close())
+	if err != nil {
+		return 0, err
+	}
+	n++
+
+	for _, de := range list {
+		if de.IsDir() {
+			more, err := snapshotDir(fsys, gopath.Join(name, de.Name()), out)
+			n += more
+			if err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func snapshotPage(w io.Writer, name string, list []fs.DirEntry, listErr error) {
+	depth := 0
+	if name != "." {
+		depth = strings.Count(name, "/") + 1
+	}
+
+	fmt.Fprintf(w, "<!doctype html>\n")
+	fmt.Fprintf(w, "<meta charset=\"utf-8\">\n")
+	fmt.Fprintf(w, "<meta name=\"viewport\" content=\"width=device-width\">\n")
+	fmt.Fprint(w, "<h1>BeHierarchic</h1>")
+	fmt.Fprint(w, "<h2>")
+	fmt.Fprintf(w, `<a href="%sindex.html">/</a>`, strings.Repeat("../", depth))
+	if name != "." {
+		for i, step := range strings.Split(name, "/") {
+			fmt.Fprintf(w, `<a href="%sindex.html">%s</a>/`,
+				strings.Repeat("../", depth-1-i), htmlReplacer.Replace(step))
+		}
+	}
+	fmt.Fprint(w, "</h2>")
+	fmt.Fprintf(w, "<pre>")
+	for _, de := range list {
+		if de.IsDir() {
+			fmt.Fprintf(w, `<a href="%s/index.html">%s/</a>`+"\n",
+				htmlReplacer.Replace(urlenc(de.Name())), htmlReplacer.Replace(de.Name()))
+		} else {
+			fmt.Fprintln(w, htmlReplacer.Replace(de.Name()))
+		}
+	}
+	if listErr != nil {
+		fmt.Fprintln(w, htmlReplacer.Replace(listErr.Error()))
+	}
+}
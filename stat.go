@@ -4,13 +4,18 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"log/slog"
 	"math"
+	gopath "path"
 
+	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
 	"github.com/elliotnunn/BeHierarchic/internal/internpath"
 	"github.com/elliotnunn/BeHierarchic/internal/spinner"
 )
 
-func (fsys *FS) Stat(name string) (stat fs.FileInfo, err error) {
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) { return fsys.stat(nil, name) }
+
+func (fsys *FS) stat(s *session, name string) (stat fs.FileInfo, err error) {
 	defer func() {
 		if err != nil {
 			err = &fs.PathError{Op: "stat", Path: name, Err: err}
@@ -21,11 +26,27 @@ func (fsys *FS) Stat(name string) (stat fs.FileInfo, err error) {
 		return nil, fs.ErrInvalid
 	}
 
-	o, err := fsys.path(name)
+	raw, kind, err := unpresent(name)
+	if err != nil {
+		return nil, err
+	}
+	o, err := fsys.pathIn(s, raw)
 	if err != nil {
 		return nil, err
 	}
 
+	switch kind {
+	case presentAppleDouble:
+		return o.appleDoubleStat()
+	case presentResourceFork:
+		return o.resourceForkStat()
+	case presentRenamed:
+		stat, err := o.cookedStat()
+		if err != nil {
+			return nil, err
+		}
+		return renamedStat{stat, gopath.Base(name)}, nil
+	}
 	return o.cookedStat()
 }
 
@@ -38,13 +59,13 @@ func (o path) cookedStat() (fs.FileInfo, error) {
 	isMountpoint := o.fsys != o.container.root && o.name == internpath.Path{}
 	if isMountpoint {
 		o.container.rMu.RLock()
-		diskImage := o.container.reverse[o.fsys].Thick(o.container)
+		diskImage := o.container.mountedFrom(o.fsys).Thick(o.container)
 		o.container.rMu.RUnlock()
 		imgStat, err := diskImage.rawStat()
 		if err != nil {
 			return nil, err
 		}
-		return mountpointStat{FileInfo: imgStat, name: diskImage.name.Base() + Special}, nil
+		return mountpointStat{FileInfo: imgStat, name: diskImage.name.Base() + Special, o: o}, nil
 	} else {
 		stat, err := o.rawStat()
 		if err != nil {
@@ -53,7 +74,7 @@ func (o path) cookedStat() (fs.FileInfo, error) {
 		if stat.Mode().IsRegular() && stat.Size() < 0 {
 			return sizeDeferredStat{stat, o}, nil
 		} else {
-			return stat, nil
+			return inodeStat{stat, o}, nil
 		}
 	}
 }
@@ -61,10 +82,12 @@ func (o path) cookedStat() (fs.FileInfo, error) {
 type mountpointStat struct {
 	fs.FileInfo // inner
 	name        string
+	o           path
 }
 
-func (s mountpointStat) Name() string { return s.name }
-func (s mountpointStat) IsDir() bool  { return true }
+func (s mountpointStat) Name() string  { return s.name }
+func (s mountpointStat) IsDir() bool   { return true }
+func (s mountpointStat) Inode() uint64 { return s.o.inode(true) }
 func (s mountpointStat) Mode() fs.FileMode {
 	return s.FileInfo.Mode() | fs.ModeDir | s.FileInfo.Mode()&0o444>>2
 }
@@ -74,29 +97,56 @@ type sizeDeferredStat struct {
 	o           path
 }
 
-func (s sizeDeferredStat) Size() int64 {
-	raw, err := s.o.rawStat()
+func (s sizeDeferredStat) Size() int64   { return s.o.hardWonSize() }
+func (s sizeDeferredStat) Inode() uint64 { return s.o.inode(false) }
+
+type inodeStat struct {
+	fs.FileInfo // everything but Inode()
+	o           path
+}
+
+func (s inodeStat) Inode() uint64 { return s.o.inode(s.IsDir()) }
+
+// hardWonSize is for a file that was born without knowing its size (e.g. a gzip).
+// The database is consulted first, and failing that the file is read to the end,
+// and the result saved so that the next HEAD or PROPFIND (even after a restart) is cheap.
+func (o path) hardWonSize() int64 {
+	raw, err := o.rawStat()
 	if err != nil {
-		panic(fmt.Sprintf("stat failed where previously stat worked: %v %s", err, s.o))
+		panic(fmt.Sprintf("stat failed where previously stat worked: %v %s", err, o))
 	}
 	if s := raw.Size(); s >= 0 {
 		return s
 	}
 
-	f, err := s.o.rawOpen()
-	if err != nil {
-		panic(fmt.Sprintf("open failed where previously stat worked: %v %s", err, s.o))
+	fsk, _ := o.fsys.(*fskeleton.FS)
+	if size, ok := o.getCacheSize(); ok {
+		if fsk != nil {
+			fsk.SetSize(o.name, size)
+		}
+		return size
 	}
 
-	if _, randAccess := f.(io.ReaderAt); randAccess {
-		panic(fmt.Sprintf("random-access file has unknown size: %v %s", s.o))
+	f, err := o.rawOpen()
+	if err != nil {
+		panic(fmt.Sprintf("open failed where previously stat worked: %v %s", err, o))
+	}
+	_, randAccess := f.(io.ReaderAt)
+	f.Close()
+	if randAccess {
+		panic(fmt.Sprintf("random-access file has unknown size: %s", o))
 	}
 
-	spinner.ReadAt(s.o, make([]byte, 1), math.MaxInt64-1) // read to the end
+	spinner.ReadAt(o, make([]byte, 1), math.MaxInt64-1) // read to the end
 
-	raw, err = s.o.rawStat()
+	raw, err = o.rawStat()
 	if err != nil {
-		panic(fmt.Sprintf("stat failed where previously stat worked: %v %s", err, s.o))
+		panic(fmt.Sprintf("stat failed where previously stat worked: %v %s", err, o))
+	}
+	size := raw.Size()
+	if size >= 0 {
+		slog.Info("hardWonSize", "size", size, "path", o)
+		o.setCacheSize(size)
 	}
-	return raw.Size() // best we can do
+	return size // best we can do
 }
@@ -0,0 +1,196 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+const statsHello = `Usage:  BeHierarchic stats [-root SUBDIR] [-json] CACHE SHAREPOINT
+
+Counts the files in the collection, including those inside archives, with their total size,
+by the format of the archive that directly holds them, by compression method,
+and by the decade of their modtimes. Run it after a prefetch, so that it reads from the cache.`
+
+// statsBucket is a count of files and of the sizes that they would have if extracted
+type statsBucket struct {
+	Archives int64 `json:"archives,omitempty"`
+	Files    int64 `json:"files"`
+	Bytes    int64 `json:"bytes"`
+}
+
+type collectionStats struct {
+	statsBucket
+	Formats map[string]*statsBucket `json:"formats"` // "plain" for files outside any archive
+	Methods map[string]*statsBucket `json:"methods"` // "unknown" where the archive does not say
+	Decades map[string]*statsBucket `json:"decades"` // such as "1990s", or "undated"
+}
+
+func bucket(m map[string]*statsBucket, key string) *statsBucket {
+	b := m[key]
+	if b == nil {
+		b = new(statsBucket)
+		m[key] = b
+	}
+	return b
+}
+
+// collectStats walks a subtree, mounting archives as it goes
+func collectStats(ctx context.Context, fsys *FS, root string) (*collectionStats, error) {
+	s := &collectionStats{
+		Formats: make(map[string]*statsBucket),
+		Methods: make(map[string]*statsBucket),
+		Decades: make(map[string]*statsBucket),
+	}
+	formatOf := map[string]string{} // of each archive walked, as a directory ending in Special
+	err := fs.WalkDir(fsys, root, func(name string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		} else if isIncomplete(err) {
+			return err
+		} else if err != nil {
+			return nil // an unreadable archive is counted as a file
+		}
+		if d.IsDir() {
+			if strings.HasSuffix(name, Special) {
+				if o, err := fsys.path(name); err == nil {
+					for _, format := range fsys.formatsOf(o.fsys) {
+						bucket(s.Formats, format).Archives++
+						s.Archives++
+					}
+					formatOf[name] = fsys.formatsOf(o.fsys)[0]
+				}
+			}
+			return nil
+		} else if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		size := max(info.Size(), 0)
+		format, method := "plain", "none"
+		if i := strings.LastIndex(name, Special+"/"); i >= 0 {
+			format, method = cmp.Or(formatOf[name[:i+len(Special)]], "unknown"), "unknown"
+			if o, err := fsys.path(name); err == nil {
+				if raw, err := o.rawRange(); err == nil && raw.Method != "" {
+					method = raw.Method
+				}
+			}
+		}
+		decade := "undated"
+		if t := info.ModTime(); !t.IsZero() {
+			decade = strconv.Itoa(t.Year()/10*10) + "s"
+		}
+		for _, b := range []*statsBucket{&s.statsBucket, bucket(s.Formats, format), bucket(s.Methods, method), bucket(s.Decades, decade)} {
+			b.Files++
+			b.Bytes += size
+		}
+		return nil
+	})
+	return s, err
+}
+
+// formatsOf returns the format of an archive and then of any wrapper layers hidden above it, innermost first
+func (fsys *FS) formatsOf(f fs.FS) []string {
+	fsys.rMu.RLock()
+	defer fsys.rMu.RUnlock()
+	formats := []string{cmp.Or(fsys.formats[f], "unknown")}
+	for archive := fsys.reverse[f]; fsys.hidden[archive.fsys]; archive = fsys.reverse[archive.fsys] {
+		formats = append(formats, cmp.Or(fsys.formats[archive.fsys], "unknown"))
+	}
+	return formats
+}
+
+func (s *collectionStats) print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "files\t%s\n", thouSep(s.Files))
+	fmt.Fprintf(tw, "bytes\t%s\n", thouSep(s.Bytes))
+	fmt.Fprintf(tw, "archives\t%s\n", thouSep(s.Archives))
+	for _, table := range []struct {
+		title    string
+		m        map[string]*statsBucket
+		archives bool
+	}{{"format", s.Formats, true}, {"method", s.Methods, false}, {"decade", s.Decades, false}} {
+		fmt.Fprintln(tw) // and align the next table afresh
+		if table.archives {
+			fmt.Fprintf(tw, "%s\tarchives\tfiles\tbytes\n", table.title)
+		} else {
+			fmt.Fprintf(tw, "%s\tfiles\tbytes\n", table.title)
+		}
+		keys := slices.Sorted(maps.Keys(table.m))
+		if table.title != "decade" { // biggest first
+			slices.SortStableFunc(keys, func(a, b string) int { return cmp.Compare(table.m[b].Bytes, table.m[a].Bytes) })
+		}
+		for _, k := range keys {
+			b := table.m[k]
+			if table.archives {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", k, thouSep(b.Archives), thouSep(b.Files), thouSep(b.Bytes))
+			} else {
+				fmt.Fprintf(tw, "%s\t%s\t%s\n", k, thouSep(b.Files), thouSep(b.Bytes))
+			}
+		}
+	}
+	return tw.Flush()
+}
+
+func statsCmd(args []string) error {
+	flags := flag.NewFlagSet("stats", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprintln(flags.Output(), statsHello) }
+	root := flags.String("root", ".", "")
+	asJSON := flags.Bool("json", false, "")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return errors.New(statsHello)
+	}
+	cache, target := flags.Arg(0), flags.Arg(1)
+
+	s, err := os.Stat(target)
+	if err != nil {
+		return err
+	} else if !s.IsDir() {
+		return fmt.Errorf("%s: not a directory", target)
+	}
+	*root = strings.Trim(*root, "/")
+	if *root == "" {
+		*root = "."
+	}
+
+	fsys := Wrapper(os.DirFS(target), cache)
+	if _, err := fs.Stat(fsys, *root); err != nil {
+		return err
+	}
+	stats, err := collectStats(context.Background(), fsys, *root)
+	if err == nil {
+		if *asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "\t")
+			err = enc.Encode(stats)
+		} else {
+			err = stats.print(os.Stdout)
+		}
+	}
+	if fsys.db.Load() != nil {
+		err = errors.Join(err, fsys.db.Load().Close())
+	}
+	return err
+}
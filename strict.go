@@ -0,0 +1,94 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/cockroachdb/pebble/v2"
+	"github.com/elliotnunn/BeHierarchic/internal/checksumreader"
+)
+
+// strictReads makes every file inside an archive be read through before any of it is served,
+// so that a client never gets the first half of a file whose checksum turns out not to match.
+// A file passes if its decompressor reports no error at the end,
+// which for a zip, StuffIt or gzip member means that the stored CRC matched.
+// The answer is remembered in the database, so a file is only read through once.
+var strictReads bool
+
+// strictRefused answers 502 Bad Gateway for a file inside an archive that fails to read through,
+// and otherwise leaves the request to be answered as usual
+func strictRefused(fsys *FS, w http.ResponseWriter, r *http.Request) bool {
+	pathname := pathOf(r)
+	o, err := fsys.path(pathname)
+	if err != nil || o.fsys == fsys.root {
+		return false
+	}
+	err = o.strictCheck()
+	if err == nil {
+		return false
+	}
+	http.Error(w, "failed integrity check: "+err.Error(), http.StatusBadGateway)
+	return true
+}
+
+var errStrictBad = errors.New("checksum did not match when last read")
+
+func (o path) strictCheck() error {
+	stat, err := o.cookedStat()
+	if err != nil || !stat.Mode().IsRegular() {
+		return nil // the usual machinery gives the usual answer
+	}
+	mtime := digestMtime(stat.ModTime())
+	_, sum, verified := o.archiveChecksum()
+	if verified || o.getCacheVerified(mtime, sum) {
+		return nil
+	} else if o.getCacheStrictBad(mtime, sum) {
+		return errStrictBad
+	}
+
+	f, err := o.cookedOpen()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(io.Discard, f)
+	if errors.Is(err, checksumreader.ErrChecksum) || errors.Is(err, gzip.ErrChecksum) {
+		slog.Error("strictReadMismatch", "path", o, "err", err)
+		o.setCacheStrictBad(mtime, sum)
+	} else if err == nil {
+		o.setCacheVerified(mtime, sum)
+	}
+	return err
+}
+
+func (o path) getCacheStrictBad(mtime, sum []byte) bool {
+	if o.container.db.Load() == nil {
+		return false
+	}
+	id := append(dbkey(o), crcBadByte)
+	defer discardkey(id)
+	val, closer, err := o.container.db.Load().Get(id)
+	if err != nil {
+		return false
+	}
+	defer closer.Close()
+	return string(val) == string(mtime)+string(sum)
+}
+
+func (o path) setCacheStrictBad(mtime, sum []byte) {
+	if o.container.db.Load() == nil {
+		return
+	}
+	id := append(dbkey(o), crcBadByte)
+	defer discardkey(id)
+	err := o.container.db.Load().Set(id, append(mtime[:len(mtime):len(mtime)], sum...), &pebble.WriteOptions{})
+	if err != nil {
+		slog.Error("setCacheStrictBadError", "path", o, "err", err)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// taskLimit caps the goroutines that requests spawn to work in parallel, across the whole server,
+// because a burst of searches could otherwise start tens of thousands of walkers.
+// Work that finds no free slot is done by the goroutine that wanted to spawn it,
+// except that a search waits for a slot before it starts, so that a burst of searches queues up.
+var taskLimit = 16 * runtime.GOMAXPROCS(-1)
+
+var taskSlots = sync.OnceValue(func() chan struct{} { return make(chan struct{}, max(taskLimit, 1)) })
+
+var taskStats struct {
+	spawned, inline        atomic.Int64
+	queued, waited, gaveUp atomic.Int64
+	waitNanos              atomic.Int64
+	peak                   atomic.Int64
+}
+
+// tryTask claims a slot without waiting
+func tryTask() bool {
+	select {
+	case taskSlots() <- struct{}{}:
+		claimedTask()
+		return true
+	default:
+		return false
+	}
+}
+
+// waitTask claims a slot, waiting for one if necessary until ctx is done
+func waitTask(ctx context.Context) error {
+	if tryTask() {
+		return nil
+	}
+	t := time.Now()
+	taskStats.queued.Add(1)
+	defer taskStats.queued.Add(-1)
+	select {
+	case taskSlots() <- struct{}{}:
+		taskStats.waited.Add(1)
+		taskStats.waitNanos.Add(int64(time.Since(t)))
+		claimedTask()
+		return nil
+	case <-ctx.Done():
+		taskStats.gaveUp.Add(1)
+		return ctx.Err()
+	}
+}
+
+func claimedTask() {
+	taskStats.spawned.Add(1)
+	n := int64(len(taskSlots()))
+	for p := taskStats.peak.Load(); n > p && !taskStats.peak.CompareAndSwap(p, n); p = taskStats.peak.Load() {
+	}
+}
+
+// doneTask releases a slot claimed by tryTask or waitTask
+func doneTask() { <-taskSlots() }
+
+// goTask runs fn in a new goroutine tracked by wg if there is a free slot, and otherwise in this one
+func goTask(wg *sync.WaitGroup, fn func()) {
+	if tryTask() {
+		wg.Go(func() {
+			defer doneTask()
+			fn()
+		})
+	} else {
+		taskStats.inline.Add(1)
+		fn()
+	}
+}
+
+// tasksAPI reports the use of the task slots since the server started
+//
+//	GET /api/v1/tasks
+func tasksAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method == "HEAD" {
+		return
+	}
+	json.NewEncoder(w).Encode(struct {
+		Limit      int     `json:"limit"`
+		Running    int     `json:"running"`
+		Peak       int64   `json:"peak"`
+		Spawned    int64   `json:"spawned"`
+		Inline     int64   `json:"inline"`
+		Queued     int64   `json:"queued"`
+		Waited     int64   `json:"waited"`
+		WaitSecs   float64 `json:"waitSeconds"`
+		GaveUp     int64   `json:"gaveUp"`
+		Goroutines int     `json:"goroutines"`
+	}{
+		Limit:      cap(taskSlots()),
+		Running:    len(taskSlots()),
+		Peak:       taskStats.peak.Load(),
+		Spawned:    taskStats.spawned.Load(),
+		Inline:     taskStats.inline.Load(),
+		Queued:     taskStats.queued.Load(),
+		Waited:     taskStats.waited.Load(),
+		WaitSecs:   time.Duration(taskStats.waitNanos.Load()).Seconds(),
+		GaveUp:     taskStats.gaveUp.Load(),
+		Goroutines: runtime.NumGoroutine(),
+	})
+}
@@ -0,0 +1,89 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"bytes"
+	"sync/atomic"
+
+	"github.com/cockroachdb/pebble/v2"
+	"github.com/elliotnunn/BeHierarchic/internal/spinner"
+)
+
+// The decompressed data of a file inside an archive is cached in tiers, fastest first:
+//
+//  1. RAM: the spinner's blocks, which are lost on restart
+//  2. local disk: the extents in pebble, written when archives are probed,
+//     and when a block that was popular in RAM is evicted from it
+//  3. origin: decompressing the file again from its start
+//
+// A read is answered by the fastest tier that has all of it.
+
+func init() { spinner.Lower = pebbleTier{} }
+
+type pebbleTier struct{}
+
+// Holds remembers which files have extents, to spare a database lookup on every read of the others
+func (pebbleTier) Holds(id spinner.Opener) bool {
+	o, ok := id.(path)
+	if !ok || o.container == nil || o.container.db.Load() == nil {
+		return false
+	}
+	fsys := o.container
+	fsys.tMu.Lock()
+	has, known := fsys.extents[o.Thin()]
+	fsys.tMu.Unlock()
+	if known {
+		return has
+	}
+
+	idPrefix := append(dbkey(o), offsetByte)
+	defer discardkey(idPrefix)
+	iter, err := fsys.db.Load().NewIter(&pebble.IterOptions{LowerBound: idPrefix})
+	if err != nil {
+		return false
+	}
+	has = iter.First() && bytes.HasPrefix(iter.Key(), idPrefix)
+	iter.Close()
+
+	fsys.tMu.Lock()
+	if _, known := fsys.extents[o.Thin()]; !known { // unless setCache got there first
+		fsys.setExtentsLocked(o.Thin(), has)
+	}
+	fsys.tMu.Unlock()
+	return has
+}
+
+// maxKnownExtents bounds the memory of Holds, beyond which an arbitrary file is forgotten
+// and costs a database lookup the next time it is read
+const maxKnownExtents = 1 << 16
+
+func (fsys *FS) setExtentsLocked(t thinPath, has bool) {
+	if _, known := fsys.extents[t]; !known && len(fsys.extents) >= maxKnownExtents {
+		for k := range fsys.extents {
+			delete(fsys.extents, k)
+			break
+		}
+	}
+	fsys.extents[t] = has
+}
+
+func (pebbleTier) ReadAt(id spinner.Opener, p []byte, off int64) int {
+	f := cachingFile{path: id.(path)}
+	n := f.getCache(p, off)
+	atomic.AddInt64(&f.path.container.scoreGood, int64(n))
+	return n
+}
+
+func (pebbleTier) Promote(id spinner.Opener, off int64, p []byte) {
+	f := cachingFile{path: id.(path)}
+	f.setCache(p, off)
+}
+
+// noteExtents is called whenever extents are written, so that Holds need not look again
+func (o path) noteExtents() {
+	o.container.tMu.Lock()
+	o.container.setExtentsLocked(o.Thin(), true)
+	o.container.tMu.Unlock()
+}
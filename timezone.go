@@ -0,0 +1,73 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/elliotnunn/BeHierarchic/internal/fskeleton"
+)
+
+// Archive timestamps follow one of two conventions.
+// Tar, RAR 5, LHA level 2, WIM and the extra fields of zip record an instant in UTC, which is shown as it is.
+// MS-DOS (zip, ARJ, ARC, ZOO, LHA, RAR 4), the classic Mac OS (HFS, StuffIt), Palm and Newton
+// recorded the clock on the wall, with no zone, and such a time is read in the zone of its archive:
+// the zone given by the -tz flag for the longest subtree containing the archive, or else UTC,
+// so that a time is shown as it was recorded unless told otherwise.
+//
+// Changing the zones changes the modtimes of files inside archives,
+// so their digests are computed again rather than found in the cache.
+
+type zoneHint struct {
+	subtree string // empty for the default
+	loc     *time.Location
+}
+
+var zoneHints []zoneHint
+
+// setZoneHint parses a -tz flag of the form ZONE or SUBTREE=ZONE,
+// where ZONE is an IANA name like Asia/Tokyo, or UTC, or Local for the server's own zone
+func setZoneHint(s string) error {
+	subtree, name, ok := strings.Cut(s, "=")
+	if !ok {
+		subtree, name = "", s
+	}
+	subtree = strings.Trim(subtree, "/")
+	if subtree != "" && !fs.ValidPath(subtree) {
+		return fmt.Errorf("%s: not a valid subtree", subtree)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return fmt.Errorf("%s: %w", s, err)
+	}
+	zoneHints = append(zoneHints, zoneHint{subtree, loc})
+	return nil
+}
+
+// zoneFor returns the zone of the wall-clock times in an archive, by its path
+func zoneFor(name string) *time.Location {
+	loc, best := time.UTC, -1
+	for _, h := range zoneHints {
+		in := h.subtree == "" || h.subtree == "." || name == h.subtree || strings.HasPrefix(name, h.subtree+"/")
+		if in && len(h.subtree) >= best {
+			loc, best = h.loc, len(h.subtree)
+		}
+	}
+	return loc
+}
+
+// setZone is called when an archive is mounted
+func (o path) setZone(fsys fs.FS) {
+	if fskel, ok := fsys.(*fskeleton.FS); ok {
+		fskel.SetZone(zoneFor(o.String()))
+	}
+}
+
+// wallClocker is implemented by the FileInfo of a file inside an archive
+type wallClocker interface {
+	WallClock() bool
+}
@@ -0,0 +1,117 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	gopath "path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// collapseTwins hides "x.gz" and "x.bz2" from listings where "x" sits beside it, as mirrors often arrange,
+// and serves the gzip twin in place of "x" to a client that accepts that Content-Encoding.
+// The twins can still be opened by name.
+var collapseTwins bool
+
+var twinSuffixes = []string{".gz", ".bz2"}
+
+// twinOf returns the uncompressed name that a compressed twin would collapse into
+func twinOf(name string) (string, bool) {
+	name = strings.TrimSuffix(name, Special)
+	for _, suffix := range twinSuffixes {
+		if base, ok := strings.CutSuffix(name, suffix); ok && base != "" {
+			return base, true
+		}
+	}
+	return "", false
+}
+
+func hideTwinEntries(listing []fs.DirEntry) []fs.DirEntry {
+	if !collapseTwins {
+		return listing
+	}
+	files := make(map[string]bool)
+	for _, de := range listing {
+		if de.Type().IsRegular() {
+			files[de.Name()] = true
+		}
+	}
+	return slices.DeleteFunc(listing, func(de fs.DirEntry) bool {
+		base, ok := twinOf(de.Name())
+		return ok && files[base]
+	})
+}
+
+// serveTwin answers a GET for a file with its gzip twin, returning false to leave the request to WebDAV.
+// The twin is only used if it is the same size uncompressed, lest a stale or unrelated x.gz be served as x.
+func serveTwin(fsys *FS, w http.ResponseWriter, r *http.Request) bool {
+	name := pathOf(r)
+	s, err := fsys.Stat(name)
+	if err != nil || !s.Mode().IsRegular() {
+		return false
+	}
+	twin, err := fsys.Stat(name + ".gz")
+	if err != nil || !twin.Mode().IsRegular() {
+		return false
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(r) {
+		return false
+	}
+
+	f, err := fsys.Open(name + ".gz")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	rs, ok := f.(io.ReadSeeker)
+	if !ok || !gzipSizeMatches(rs, s.Size()) {
+		return false
+	}
+
+	ctype := mime.TypeByExtension(gopath.Ext(name))
+	if ctype == "" {
+		ctype = "application/octet-stream" // sniffing would only find gzip
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Content-Encoding", "gzip")
+	http.ServeContent(w, r, "", twin.ModTime(), rs)
+	return true
+}
+
+// gzipSizeMatches checks the uncompressed size at the end of a gzip stream (the ISIZE field, modulo 2^32),
+// and leaves it seeked to the start
+func gzipSizeMatches(rs io.ReadSeeker, size int64) bool {
+	var isize [4]byte
+	if _, err := rs.Seek(-4, io.SeekEnd); err != nil {
+		return false
+	} else if _, err := io.ReadFull(rs, isize[:]); err != nil {
+		return false
+	} else if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return false
+	}
+	return binary.LittleEndian.Uint32(isize[:]) == uint32(size)
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, coding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(coding, ";")
+		coding = strings.TrimSpace(coding)
+		if coding != "gzip" && coding != "x-gzip" {
+			continue
+		}
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, err := strconv.ParseFloat(v, 64)
+			return err == nil && q > 0
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,192 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/elliotnunn/BeHierarchic/internal/binhex"
+	"github.com/elliotnunn/BeHierarchic/internal/diskcopy"
+	"github.com/elliotnunn/BeHierarchic/internal/macbinary"
+)
+
+// validation is the outcome of checking a container's own checksums,
+// for the formats that carry them but are not archives with a checksum per member
+type validation struct {
+	Format string       `json:"format"`
+	Checks []validCheck `json:"checks"`
+}
+
+type validCheck struct {
+	What string `json:"what"`
+	OK   bool   `json:"ok"`
+}
+
+func (v *validation) check(what string, ok bool) {
+	v.Checks = append(v.Checks, validCheck{what, ok})
+}
+
+func (v *validation) ok() bool {
+	for _, c := range v.Checks {
+		if !c.OK {
+			return false
+		}
+	}
+	return true
+}
+
+// validate recognises a Disk Copy 4.2 image, a MacBinary file or a BinHex file and checks it,
+// returning nil for any other file
+func (o path) validate() (*validation, error) {
+	stat, err := o.cookedStat()
+	if err != nil {
+		return nil, err
+	} else if !stat.Mode().IsRegular() {
+		return nil, nil
+	}
+	f, err := o.cookedOpen()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ra, ok := f.(io.ReaderAt)
+	if !ok {
+		return nil, nil
+	}
+	head := make([]byte, 4096)
+	n, _ := ra.ReadAt(head, 0)
+	head = head[:n]
+	size := stat.Size()
+
+	if dc, err := diskcopy.ParseHeader(head); err == nil {
+		v := &validation{Format: "Disk Copy 4.2"}
+		v.check("length", size >= diskcopy.HeaderSize+dc.DataSize+dc.TagSize)
+		dataOK, tagOK, err := dc.Verify(ra)
+		if err != nil {
+			return nil, err
+		}
+		v.check("data checksum", dataOK)
+		v.check("tag checksum", tagOK)
+		return v, nil
+	}
+
+	if mb, err := macbinary.ParseHeader(head); err == nil {
+		complete := size >= macbinary.HeaderSize+mb.SecondaryLen+mb.DataLen+mb.RsrcLen
+		if !mb.HasCRC && (!complete || size > mb.Size()) {
+			return nil, nil // too weak a signature to call this MacBinary I
+		}
+		v := &validation{Format: "MacBinary"}
+		switch mb.Version {
+		case 2:
+			v.Format += " II"
+		case 3:
+			v.Format += " III"
+		}
+		if mb.HasCRC {
+			v.check("header CRC", mb.CRCOK)
+		}
+		v.check("length", complete)
+		return v, nil
+	}
+
+	if binhex.IsBinHex(head) {
+		res, err := binhex.Verify(io.NewSectionReader(ra, 0, size))
+		if errors.Is(err, binhex.ErrFormat) {
+			return nil, nil
+		}
+		v := &validation{Format: "BinHex 4.0"}
+		v.check("length", !errors.Is(err, io.ErrUnexpectedEOF))
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, err
+		}
+		v.check("header CRC", res.HeaderOK)
+		v.check("data fork CRC", res.DataOK)
+		v.check("resource fork CRC", res.RsrcOK)
+		return v, nil
+	}
+	return nil, nil
+}
+
+// validateAPI checks every Disk Copy, MacBinary and BinHex file in a subtree, including those within archives.
+//
+//	GET /api/v1/validate[?root=PATH]
+//
+// Each recognised file is a line {"path":"...","ok":BOOL,"format":"...","checks":[{"what":"...","ok":BOOL},...]}
+// and the final line is {"count":N,"corrupt":N}.
+func validateAPI(fsys *FS, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	root := strings.Trim(r.URL.Query().Get("root"), "/")
+	if root == "" {
+		root = "."
+	}
+	if _, err := fs.Stat(fsys, root); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if r.Method == "HEAD" {
+		return
+	}
+
+	validateTree(r.Context(), fsys, root, w)
+}
+
+// validateTree writes the lines of validateAPI
+func validateTree(ctx context.Context, fsys *FS, root string, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	var counts struct {
+		Count   int `json:"count"`
+		Corrupt int `json:"corrupt"`
+	}
+	err := fs.WalkDir(fsys, root, func(name string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		} else if isIncomplete(err) {
+			return err // rather than an answer that looks whole
+		} else if err != nil || !d.Type().IsRegular() {
+			return nil // report what we can
+		}
+		o, err := fsys.path(name)
+		if err != nil {
+			return nil
+		}
+		v, err := o.validate()
+		if err != nil || v == nil {
+			return nil
+		}
+		counts.Count++
+		if !v.ok() {
+			counts.Corrupt++
+		}
+		return enc.Encode(struct {
+			Path string `json:"path"`
+			OK   bool   `json:"ok"`
+			*validation
+		}{name, v.ok(), v})
+	})
+	if isIncomplete(err) {
+		enc.Encode(struct {
+			Error string `json:"error"`
+		}{err.Error()}) // instead of counts that look whole
+		return err
+	} else if err != nil {
+		return err // client has gone away
+	}
+	return enc.Encode(counts)
+}
@@ -0,0 +1,238 @@
+// Copyright (c) Elliot Nunn
+// Licensed under the MIT license
+
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	gopath "path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const warmHello = `Usage:  BeHierarchic warm [-j N] [-max N] URL LOGFILE
+        BeHierarchic warm [-j N] [-max N] URL http://OLD-SERVER-ADMIN/
+
+Warms the caches of a freshly started server at URL before it takes over from an old one,
+by downloading the files that were most popular on the old server, and listing their directories.
+The popularity comes from the access log of the old server, or from the download times
+that it recorded with -atime, which its -admin address serves.`
+
+func warmCmd(args []string) error {
+	flags := flag.NewFlagSet("warm", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprintln(flags.Output(), warmHello) }
+	jobs := flags.Int("j", 4, "")
+	most := flags.Int("max", 10000, "")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 2 || *jobs < 1 {
+		return errors.New(warmHello)
+	}
+	target, source := flags.Arg(0), flags.Arg(1)
+	if !isRemoteSharepoint(target) {
+		return fmt.Errorf("%s: not an HTTP URL", target)
+	}
+
+	var popular []string
+	if isRemoteSharepoint(source) {
+		popular, err = popularFromServer(source)
+	} else {
+		popular, err = popularFromLog(source)
+	}
+	if err != nil {
+		return err
+	}
+	if len(popular) > *most {
+		popular = popular[:*most]
+	}
+	slog.Info("warmStart", "paths", len(popular))
+
+	// Each directory on the way, then the file, so that the archives are mounted in a sensible order
+	var order []string
+	seen := make(map[string]bool)
+	for _, name := range popular {
+		for _, dir := range parentDirs(name) {
+			if !seen[dir] {
+				seen[dir] = true
+				order = append(order, dir)
+			}
+		}
+		if !seen[name] {
+			seen[name] = true
+			order = append(order, name)
+		}
+	}
+
+	t := time.Now()
+	var nbytes, nerrs atomic.Int64
+	ch := make(chan string)
+	var wg sync.WaitGroup
+	for range *jobs {
+		wg.Go(func() {
+			for name := range ch {
+				n, err := warmOne(target, name)
+				nbytes.Add(n)
+				if err != nil {
+					nerrs.Add(1)
+					slog.Warn("warmFail", "path", name, "err", err)
+				}
+			}
+		})
+	}
+	for _, name := range order {
+		ch <- name
+	}
+	close(ch)
+	wg.Wait()
+	slog.Info("warmDone", "requests", len(order), "bytes", thouSep(nbytes.Load()),
+		"errors", nerrs.Load(), "t", time.Since(t).Truncate(time.Second).String())
+	return nil
+}
+
+// parentDirs lists "a/", "a/b/" for "a/b/c", and "a/b/" for a directory "a/b/"
+func parentDirs(name string) (dirs []string) {
+	name = strings.TrimSuffix(name, "/")
+	for i := range len(name) {
+		if name[i] == '/' {
+			dirs = append(dirs, name[:i+1])
+		}
+	}
+	return dirs
+}
+
+// warmOne fetches a directory page or a whole file, and throws it away
+func warmOne(base, name string) (int64, error) {
+	segs := strings.Split(name, "/")
+	for i := range segs {
+		segs[i] = url.PathEscape(segs[i])
+	}
+	resp, err := http.Get(strings.TrimSuffix(base, "/") + "/" + strings.Join(segs, "/"))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	n, err := io.Copy(io.Discard, resp.Body)
+	if err == nil && resp.StatusCode != http.StatusOK {
+		err = errors.New(resp.Status)
+	}
+	return n, err
+}
+
+// popularFromLog counts the successful GETs in an access log, most popular first
+func popularFromLog(name string) ([]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hits := make(map[string]int)
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		_, rest, ok := strings.Cut(sc.Text(), " access ")
+		if !ok {
+			continue
+		}
+		attrs := parseLogAttrs(rest)
+		if attrs["method"] != "GET" || attrs["status"] != "200" && attrs["status"] != "206" {
+			continue
+		}
+		p := strings.TrimPrefix(attrs["path"], "/")
+		if p == "" || strings.HasPrefix(p, "api/") || strings.HasPrefix(gopath.Base(p), ".") {
+			continue // the front page, an API or a special page
+		}
+		hits[p]++
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return byPopularity(hits), nil
+}
+
+// parseLogAttrs reads the key=value pairs that log/slog writes, with quoted values
+func parseLogAttrs(s string) map[string]string {
+	attrs := make(map[string]string)
+	for s != "" {
+		s = strings.TrimLeft(s, " ")
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			break
+		}
+		var val string
+		if strings.HasPrefix(rest, `"`) {
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				break
+			}
+			val, _ = strconv.Unquote(quoted)
+			rest = rest[len(quoted):]
+		} else {
+			val, rest, _ = strings.Cut(rest, " ")
+		}
+		attrs[key] = val
+		s = rest
+	}
+	return attrs
+}
+
+// popularFromServer asks the old server for its recorded download counts, including those inside archives
+func popularFromServer(base string) ([]string, error) {
+	resp, err := http.Get(strings.TrimSuffix(base, "/") + "/api/v1/access?inside=1")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", resp.Request.URL, resp.Status)
+	}
+
+	hits := make(map[string]int)
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var l struct {
+			Path  string `json:"path"`
+			Hits  int    `json:"hits"`
+			Count *int   `json:"count"`
+		}
+		if err := dec.Decode(&l); err != nil {
+			return nil, err
+		} else if l.Count != nil {
+			break
+		}
+		hits[l.Path] = l.Hits
+	}
+	for name := range hits {
+		if host, _, nested := strings.Cut(name, Special+"/"); nested {
+			delete(hits, host) // credited with the downloads from inside it, which are what to warm
+		}
+	}
+	return byPopularity(hits), nil
+}
+
+func byPopularity(hits map[string]int) []string {
+	names := make([]string, 0, len(hits))
+	for name := range hits {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		return cmp.Or(cmp.Compare(hits[b], hits[a]), strings.Compare(a, b))
+	})
+	return names
+}